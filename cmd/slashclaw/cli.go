@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/alphabot-ai/slashclaw/internal/auth"
+)
+
+// commands are the subcommands that run instead of the server; they let a
+// human walk the challenge/verify flow with curl
+var commands = map[string]func(args []string, stdout io.Writer) error{
+	"keygen": runKeygen,
+	"sign":   runSign,
+}
+
+// runKeygen handles `slashclaw keygen`: it writes a new private key to a
+// file and prints the matching public key for /api/auth/verify
+func runKeygen(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("keygen", flag.ExitOnError)
+	alg := fs.String("alg", auth.AlgEd25519, "key algorithm: ed25519, rsa-pss or rsa-sha256")
+	out := fs.String("out", "slashclaw.key", "file to write the PEM private key to")
+	fs.Parse(args)
+
+	privatePEM, publicKey, err := auth.GenerateKey(*alg)
+	if err != nil {
+		return err
+	}
+	// O_EXCL so an existing key is never silently replaced
+	f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(privatePEM); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Wrote %s private key to %s\n", *alg, *out)
+	fmt.Fprintln(stdout, publicKey)
+	return nil
+}
+
+// runSign handles `slashclaw sign`: it prints the base64 signature of a
+// challenge's message made with a key from keygen
+func runSign(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("sign", flag.ExitOnError)
+	alg := fs.String("alg", auth.AlgEd25519, "signature algorithm matching the key")
+	keyFile := fs.String("key", "", "PEM private key file from keygen")
+	message := fs.String("message", "", "message string from /api/auth/challenge (not the bare challenge)")
+	fs.Parse(args)
+	if *keyFile == "" || *message == "" {
+		return errors.New("sign: --key and --message are required")
+	}
+
+	privatePEM, err := os.ReadFile(*keyFile)
+	if err != nil {
+		return err
+	}
+	signature, err := auth.Sign(*alg, privatePEM, *message)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, signature)
+	return nil
+}
@@ -4,9 +4,11 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
@@ -19,67 +21,174 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		if run, ok := commands[os.Args[1]]; ok {
+			if err := run(os.Args[2:], os.Stdout); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	cfg := config.Load()
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid config: %v", err)
+	}
+
+	// Structured logs; the standard logger is routed through the same handler
+	var logHandler slog.Handler = slog.NewTextHandler(os.Stderr, nil)
+	if cfg.LogFormat == "json" {
+		logHandler = slog.NewJSONHandler(os.Stderr, nil)
+	}
+	logger := slog.New(logHandler)
+	slog.SetDefault(logger)
 
 	// Initialize store
-	sqliteStore, err := store.NewSQLiteStore(cfg.DatabasePath)
+	var db store.Store
+	var err error
+	if cfg.UsePostgres() {
+		db, err = store.NewPostgresStore(cfg.DatabaseURL)
+	} else {
+		db, err = store.NewSQLiteStore(cfg.DatabasePath)
+	}
 	if err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
-	defer sqliteStore.Close()
+	defer db.Close()
+
+	// Serve hot story listings from a short-lived cache
+	db = store.NewCachingStore(db, cfg.ListCacheTTL)
 
 	// Initialize services
-	limiter := ratelimit.NewMemoryLimiter()
-	limiter.StartCleanup(5 * time.Minute)
+	var limiter ratelimit.Limiter
+	if cfg.RedisURL != "" {
+		redisLimiter, err := ratelimit.NewRedisLimiter(cfg.RedisURL)
+		if err != nil {
+			log.Fatalf("Failed to connect to Redis: %v", err)
+		}
+		defer redisLimiter.Close()
+		limiter = redisLimiter
+	} else if cfg.RateLimitAlgo == "bucket" {
+		bucketLimiter := ratelimit.NewBucketLimiter(cfg.RateLimitBurst)
+		bucketLimiter.StartCleanup(5 * time.Minute)
+		limiter = bucketLimiter
+	} else {
+		memoryLimiter := ratelimit.NewMemoryLimiter()
+		memoryLimiter.StartCleanup(5 * time.Minute)
+		limiter = memoryLimiter
+	}
 
-	authService := auth.NewService(sqliteStore, cfg.ChallengeTTL, cfg.TokenTTL)
+	authService := auth.NewService(db, cfg.ChallengeTTL, cfg.TokenTTL)
+	if err := authService.SetChallengeFormat(cfg.ChallengeBytes, cfg.ChallengeEncoding); err != nil {
+		log.Fatalf("Invalid challenge config: %v", err)
+	}
+	authService.SetMaxActiveChallenges(cfg.MaxChallenges)
+	authService.SetMaxChallengeAttempts(cfg.MaxChallengeAttempts)
+	authService.SetChallengeOrigin(cfg.ChallengeOrigin())
+	if cfg.TokenMode == auth.TokenModeJWT {
+		if err := authService.UseJWT([]byte(cfg.JWTSecret)); err != nil {
+			log.Fatalf("Invalid JWT config: %v", err)
+		}
+	}
+
+	// Periodically delete expired challenges and tokens, and inactive accounts
+	// if enabled; stopped on shutdown
+	sweepCtx, stopSweep := context.WithCancel(context.Background())
+	var sweeps sync.WaitGroup
+	if cfg.CleanupInterval > 0 {
+		sweeps.Go(func() { store.SweepExpired(sweepCtx, db, cfg.CleanupInterval) })
+		if cfg.PruneInactiveAccountsAfter > 0 {
+			sweeps.Go(func() {
+				store.SweepInactiveAccounts(sweepCtx, db, cfg.PruneInactiveAccountsAfter, cfg.CleanupInterval)
+			})
+		}
+	}
 
 	// Initialize handlers
-	apiHandler := api.NewHandler(sqliteStore, authService, limiter, cfg)
-	webHandler, err := web.NewHandler(sqliteStore, cfg)
+	apiHandler := api.NewHandler(db, authService, limiter, cfg)
+	webHandler, err := web.NewHandler(db, cfg)
 	if err != nil {
 		log.Fatalf("Failed to initialize web handler: %v", err)
 	}
 
 	mux := http.NewServeMux()
 
-	// Health check
-	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("ok"))
-	})
+	// Health and readiness checks
+	mux.HandleFunc("GET /health", apiHandler.Health)
+	mux.HandleFunc("GET /ready", apiHandler.Ready)
+
+	// Instance discovery for other instances and directories
+	mux.HandleFunc("GET /.well-known/slashclaw", apiHandler.Instance)
 
 	// Public API routes (read operations)
+	mux.HandleFunc("GET /api/capabilities", apiHandler.Capabilities)
+	mux.HandleFunc("GET /api/schema/{resource}", apiHandler.GetSchema)
+	mux.HandleFunc("GET /api/openapi.json", apiHandler.OpenAPI)
 	mux.HandleFunc("GET /api/stories", apiHandler.ListStories)
-	mux.HandleFunc("GET /api/stories/{id}", apiHandler.GetStory)
+	mux.HandleFunc("GET /api/stories/{id}", apiHandler.OptionalAuth(apiHandler.GetStory))
 	mux.HandleFunc("GET /api/stories/{id}/comments", apiHandler.ListComments)
+	mux.HandleFunc("GET /api/stories/{id}/export", apiHandler.ExportStory)
+	mux.HandleFunc("GET /api/tags", apiHandler.ListTags)
+	mux.HandleFunc("GET /api/comments/{id}/replies", apiHandler.ListReplies)
+	mux.HandleFunc("GET /api/accounts", apiHandler.FindAccounts)
 	mux.HandleFunc("GET /api/accounts/{id}", apiHandler.GetAccount)
+	mux.HandleFunc("GET /api/accounts/{id}/karma", apiHandler.GetAccountKarma)
+	mux.HandleFunc("GET /api/votes", apiHandler.OptionalAuth(apiHandler.GetVoteState))
+	mux.HandleFunc("POST /api/votes/lookup", apiHandler.OptionalAuth(apiHandler.LookupVotes))
+	mux.HandleFunc("POST /api/preview", apiHandler.Preview)
+
+	// Votes need a token unless ALLOW_ANONYMOUS_VOTES=true admits anonymous (IP-only) voters
+	mux.HandleFunc("POST /api/votes", apiHandler.OptionalAuth(apiHandler.CreateVote))
 
 	// Auth flow (must be public to allow authentication)
 	mux.HandleFunc("POST /api/auth/challenge", apiHandler.CreateChallenge)
 	mux.HandleFunc("POST /api/auth/verify", apiHandler.VerifyChallenge)
 
 	// Protected API routes (require authentication)
-	mux.HandleFunc("POST /api/stories", apiHandler.RequireAuth(apiHandler.CreateStory))
-	mux.HandleFunc("POST /api/comments", apiHandler.RequireAuth(apiHandler.CreateComment))
-	mux.HandleFunc("POST /api/votes", apiHandler.RequireAuth(apiHandler.CreateVote))
+	mux.HandleFunc("POST /api/stories", apiHandler.RequireAuth(apiHandler.Idempotent("story", apiHandler.CreateStory)))
+	mux.HandleFunc("POST /api/stories/batch", apiHandler.RequireAuth(apiHandler.CreateStoriesBatch))
+	mux.HandleFunc("GET /api/stories/pending", apiHandler.RequireAuth(apiHandler.ListPendingStories))
+	mux.HandleFunc("GET /api/me/stories", apiHandler.RequireAuth(apiHandler.MyStories))
+	mux.HandleFunc("GET /api/me/comments", apiHandler.RequireAuth(apiHandler.MyComments))
+	mux.HandleFunc("DELETE /api/stories/{id}", apiHandler.RequireAuth(apiHandler.DeleteStory))
+	mux.HandleFunc("POST /api/comments", apiHandler.RequireAuth(apiHandler.Idempotent("comment", apiHandler.CreateComment)))
+	mux.HandleFunc("PATCH /api/comments/{id}", apiHandler.RequireAuth(apiHandler.UpdateComment))
+	mux.HandleFunc("DELETE /api/comments/{id}", apiHandler.RequireAuth(apiHandler.DeleteComment))
+	mux.HandleFunc("POST /api/flags", apiHandler.RequireAuth(apiHandler.CreateFlag))
 	mux.HandleFunc("POST /api/accounts", apiHandler.RequireAuth(apiHandler.CreateAccount))
+	mux.HandleFunc("GET /api/accounts/{id}/keys", apiHandler.RequireAuth(apiHandler.ListAccountKeys))
 	mux.HandleFunc("POST /api/accounts/{id}/keys", apiHandler.RequireAuth(apiHandler.AddAccountKey))
 	mux.HandleFunc("DELETE /api/accounts/{id}/keys/{keyId}", apiHandler.RequireAuth(apiHandler.DeleteAccountKey))
 
-	// Admin routes (requires admin secret)
+	// Admin routes (admin secret, or an admin token with the route's scope)
 	mux.HandleFunc("POST /api/admin/hide", apiHandler.Hide)
+	mux.HandleFunc("POST /api/admin/unhide", apiHandler.Unhide)
+	mux.HandleFunc("GET /api/admin/queue", apiHandler.ReviewQueue)
+	mux.HandleFunc("GET /api/admin/flags", apiHandler.ListFlags)
+	mux.HandleFunc("POST /api/admin/approve", apiHandler.Approve)
+	mux.HandleFunc("POST /api/admin/recompute", apiHandler.Recompute)
+	mux.HandleFunc("POST /api/admin/stories/{id}/recompute", apiHandler.RecomputeStory)
+	mux.HandleFunc("POST /api/admin/revoke-agent-tokens", apiHandler.RevokeAgentTokens)
+	mux.HandleFunc("GET /api/admin/ratelimit/buckets", apiHandler.RateLimitBuckets)
+	mux.HandleFunc("GET /api/admin/activity", apiHandler.Activity)
+	mux.HandleFunc("POST /api/admin/tokens", apiHandler.CreateAdminToken)
+	mux.HandleFunc("GET /api/admin/tokens", apiHandler.ListAdminTokens)
+	mux.HandleFunc("DELETE /api/admin/tokens/{id}", apiHandler.RevokeAdminToken)
 
 	// Web routes
 	mux.HandleFunc("GET /", webHandler.Home)
 	mux.HandleFunc("GET /story/{id}", webHandler.Story)
 	mux.HandleFunc("GET /submit", webHandler.Submit)
+	mux.HandleFunc("GET /feed.xml", webHandler.RSS)
+	mux.HandleFunc("GET /feed.atom", webHandler.Atom)
+	mux.HandleFunc("GET /sitemap.xml", webHandler.Sitemap)
 
 	addr := fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)
 	log.Printf("Starting Slashclaw on %s", addr)
 
-	// Wrap with logging middleware
-	handler := api.LogRequests(mux)
+	// Wrap with the body size limit, global rate limit, CORS, and logging middleware
+	handler := api.LogRequests(logger, cfg)(api.CORS(cfg)(apiHandler.GlobalRateLimit(api.LimitBodies(cfg)(mux))))
 
 	// Create server with timeouts
 	server := &http.Server{
@@ -113,5 +222,8 @@ func main() {
 		log.Printf("Server forced to shutdown: %v", err)
 	}
 
+	stopSweep()
+	sweeps.Wait()
+
 	log.Println("Server stopped")
 }
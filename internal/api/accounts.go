@@ -1,8 +1,13 @@
 package api
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"time"
 
 	"github.com/alphabot-ai/slashclaw/internal/auth"
 	"github.com/alphabot-ai/slashclaw/internal/store"
@@ -21,6 +26,7 @@ type CreateAccountRequest struct {
 type CreateAccountResponse struct {
 	AccountID string `json:"account_id"`
 	KeyID     string `json:"key_id"`
+	Existing  bool   `json:"existing,omitempty"`
 }
 
 type AddKeyRequest struct {
@@ -34,15 +40,38 @@ type AddKeyResponse struct {
 	KeyID string `json:"key_id"`
 }
 
+type KarmaResponse struct {
+	AccountID string `json:"account_id"`
+	Karma     int    `json:"karma"`
+}
+
 type DeleteKeyResponse struct {
 	OK bool `json:"ok"`
 }
 
+// AccountKeyInfo describes one of an account's keys
+type AccountKeyInfo struct {
+	ID          string     `json:"id"`
+	Algorithm   string     `json:"alg"`
+	Fingerprint string     `json:"fingerprint"` // SHA256: and the unpadded base64 SHA-256 of the public key as registered
+	CreatedAt   time.Time  `json:"created_at"`
+	RevokedAt   *time.Time `json:"revoked_at,omitempty"`
+	Current     bool       `json:"current,omitempty"` // the key the request's token was issued for, which can't be revoked with it
+}
+
+type ListKeysResponse struct {
+	Keys []*AccountKeyInfo `json:"keys"`
+}
+
+type FindAccountsResponse struct {
+	Accounts []*store.Account `json:"accounts"`
+}
+
 // CreateAccount handles POST /api/accounts
 func (h *Handler) CreateAccount(w http.ResponseWriter, r *http.Request) {
 	var req CreateAccountRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, http.StatusBadRequest, "invalid JSON")
+		writeBodyError(w, err, "invalid JSON")
 		return
 	}
 
@@ -64,14 +93,16 @@ func (h *Handler) CreateAccount(w http.ResponseWriter, r *http.Request) {
 
 	token, err := h.auth.VerifyAndCreateToken(r.Context(), agentID, req.Algorithm, req.PublicKey, req.Challenge, req.Signature)
 	if err != nil {
-		switch err {
-		case auth.ErrInvalidAlgorithm:
+		switch {
+		case errors.Is(err, auth.ErrInvalidAlgorithm):
 			writeError(w, http.StatusBadRequest, "invalid algorithm")
-		case auth.ErrInvalidPublicKey:
+		case errors.Is(err, auth.ErrInvalidPublicKey):
 			writeError(w, http.StatusBadRequest, "invalid public key format")
-		case auth.ErrInvalidSignature:
+		case errors.Is(err, auth.ErrUnboundSignature):
+			writeError(w, http.StatusUnauthorized, unboundSignatureMessage)
+		case errors.Is(err, auth.ErrInvalidSignature):
 			writeError(w, http.StatusUnauthorized, "invalid signature")
-		case auth.ErrChallengeNotFound, auth.ErrChallengeExpired:
+		case errors.Is(err, auth.ErrChallengeNotFound), errors.Is(err, auth.ErrChallengeExpired):
 			writeError(w, http.StatusBadRequest, "challenge expired or not found")
 		default:
 			writeError(w, http.StatusInternalServerError, "verification failed")
@@ -86,6 +117,22 @@ func (h *Handler) CreateAccount(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if existingKey != nil {
+		// A retry of a creation that already succeeded gets the same answer
+		if h.cfg.IdempotentAccountCreate {
+			existing, err := h.findRetriedAccount(r, existingKey, req.DisplayName)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, "database error")
+				return
+			}
+			if existing != nil {
+				writeJSON(w, http.StatusOK, CreateAccountResponse{
+					AccountID: existing.ID,
+					KeyID:     existingKey.ID,
+					Existing:  true,
+				})
+				return
+			}
+		}
 		writeError(w, http.StatusConflict, "public key is already registered to an account")
 		return
 	}
@@ -123,6 +170,23 @@ func (h *Handler) CreateAccount(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// findRetriedAccount returns the account owning key if it looks like it was
+// created by an earlier attempt of the same request: same display name and
+// created within the configured retry window. Returns nil otherwise.
+func (h *Handler) findRetriedAccount(r *http.Request, key *store.AccountKey, displayName string) (*store.Account, error) {
+	account, err := h.store.GetAccount(r.Context(), key.AccountID)
+	if err != nil || account == nil {
+		return nil, err
+	}
+	if account.DisplayName != displayName {
+		return nil, nil
+	}
+	if time.Since(account.CreatedAt) > h.cfg.AccountRetryWindow {
+		return nil, nil
+	}
+	return account, nil
+}
+
 // GetAccount handles GET /api/accounts/{id}
 func (h *Handler) GetAccount(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
@@ -141,9 +205,150 @@ func (h *Handler) GetAccount(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	account.Karma, err = h.store.GetAccountKarma(r.Context(), id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+
 	writeJSON(w, http.StatusOK, account)
 }
 
+// FindAccounts handles GET /api/accounts. Given public_key and alg it returns
+// the account that key is registered to, or 404 if none holds it unrevoked.
+// Given name it returns the accounts whose display name contains it.
+func (h *Handler) FindAccounts(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	if publicKey := query.Get("public_key"); publicKey != "" {
+		alg := query.Get("alg")
+		if alg == "" {
+			writeError(w, http.StatusBadRequest, "alg is required with public_key")
+			return
+		}
+
+		key, err := h.store.GetAccountKeyByPublicKey(r.Context(), alg, publicKey)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "database error")
+			return
+		}
+		if key == nil {
+			writeError(w, http.StatusNotFound, "no account has this key")
+			return
+		}
+
+		account, err := h.store.GetAccount(r.Context(), key.AccountID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "database error")
+			return
+		}
+		if account == nil {
+			writeError(w, http.StatusNotFound, "no account has this key")
+			return
+		}
+		if account.Karma, err = h.store.GetAccountKarma(r.Context(), account.ID); err != nil {
+			writeError(w, http.StatusInternalServerError, "database error")
+			return
+		}
+		writeJSON(w, http.StatusOK, account)
+		return
+	}
+
+	name := query.Get("name")
+	if name == "" {
+		writeError(w, http.StatusBadRequest, "public_key and alg, or name, is required")
+		return
+	}
+
+	accounts, err := h.store.FindAccountsByName(r.Context(), name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+	for _, account := range accounts {
+		if account.Karma, err = h.store.GetAccountKarma(r.Context(), account.ID); err != nil {
+			writeError(w, http.StatusInternalServerError, "database error")
+			return
+		}
+	}
+	if accounts == nil {
+		accounts = []*store.Account{}
+	}
+	writeJSON(w, http.StatusOK, FindAccountsResponse{Accounts: accounts})
+}
+
+// attachAuthors fills in Author on the stories and comments (and their
+// nested replies) posted by verified agents that have signed in to an
+// account. Unverified content is left with just its agent_id.
+func (h *Handler) attachAuthors(ctx context.Context, stories []*store.Story, comments []*store.Comment) error {
+	var agentIDs []string
+	seen := make(map[string]bool)
+	add := func(agentID string, verified bool) {
+		if verified && agentID != "" && !seen[agentID] {
+			seen[agentID] = true
+			agentIDs = append(agentIDs, agentID)
+		}
+	}
+	for _, story := range stories {
+		add(story.AgentID, story.AgentVerified)
+	}
+	walkComments(comments, func(c *store.Comment) { add(c.AgentID, c.AgentVerified) })
+	if len(agentIDs) == 0 {
+		return nil
+	}
+
+	accounts, err := h.store.AccountsForAgents(ctx, agentIDs)
+	if err != nil {
+		return err
+	}
+	author := func(agentID string, verified bool) *store.Author {
+		if account := accounts[agentID]; verified && account != nil {
+			return &store.Author{AccountID: account.ID, DisplayName: account.DisplayName}
+		}
+		return nil
+	}
+	for _, story := range stories {
+		story.Author = author(story.AgentID, story.AgentVerified)
+	}
+	walkComments(comments, func(c *store.Comment) { c.Author = author(c.AgentID, c.AgentVerified) })
+	return nil
+}
+
+// walkComments calls fn on each comment and, depth first, its replies
+func walkComments(comments []*store.Comment, fn func(*store.Comment)) {
+	for _, c := range comments {
+		fn(c)
+		walkComments(c.Children, fn)
+	}
+}
+
+// GetAccountKarma handles GET /api/accounts/{id}/karma
+func (h *Handler) GetAccountKarma(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		writeError(w, http.StatusBadRequest, "account id required")
+		return
+	}
+
+	account, err := h.store.GetAccount(r.Context(), id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+	if account == nil {
+		writeError(w, http.StatusNotFound, "account not found")
+		return
+	}
+
+	karma, err := h.store.GetAccountKarma(r.Context(), id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, KarmaResponse{AccountID: id, Karma: karma})
+}
+
 // AddAccountKey handles POST /api/accounts/{id}/keys
 func (h *Handler) AddAccountKey(w http.ResponseWriter, r *http.Request) {
 	accountID := r.PathValue("id")
@@ -176,7 +381,7 @@ func (h *Handler) AddAccountKey(w http.ResponseWriter, r *http.Request) {
 
 	var req AddKeyRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, http.StatusBadRequest, "invalid JSON")
+		writeBodyError(w, err, "invalid JSON")
 		return
 	}
 
@@ -188,8 +393,10 @@ func (h *Handler) AddAccountKey(w http.ResponseWriter, r *http.Request) {
 	// Verify the new key's signature
 	_, err = h.auth.VerifyAndCreateToken(r.Context(), token.AgentID, req.Algorithm, req.PublicKey, req.Challenge, req.Signature)
 	if err != nil {
-		switch err {
-		case auth.ErrInvalidSignature:
+		switch {
+		case errors.Is(err, auth.ErrUnboundSignature):
+			writeError(w, http.StatusUnauthorized, unboundSignatureMessage)
+		case errors.Is(err, auth.ErrInvalidSignature):
 			writeError(w, http.StatusUnauthorized, "invalid signature for new key")
 		default:
 			writeError(w, http.StatusBadRequest, "verification failed")
@@ -223,6 +430,53 @@ func (h *Handler) AddAccountKey(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusCreated, AddKeyResponse{KeyID: key.ID})
 }
 
+// ListAccountKeys handles GET /api/accounts/{id}/keys, listing the keys of
+// the caller's own account, revoked ones included, oldest first
+func (h *Handler) ListAccountKeys(w http.ResponseWriter, r *http.Request) {
+	accountID := r.PathValue("id")
+	if accountID == "" {
+		writeError(w, http.StatusBadRequest, "account id required")
+		return
+	}
+
+	// Verify the request is from an authenticated owner of this account
+	token, err := h.validateToken(r)
+	if err != nil || token == nil {
+		writeError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+	if token.AccountID != accountID {
+		writeError(w, http.StatusForbidden, "not authorized to view this account's keys")
+		return
+	}
+
+	keys, err := h.store.ListAccountKeys(r.Context(), accountID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+
+	items := make([]*AccountKeyInfo, len(keys))
+	for i, key := range keys {
+		items[i] = &AccountKeyInfo{
+			ID:          key.ID,
+			Algorithm:   key.Algorithm,
+			Fingerprint: keyFingerprint(key.PublicKey),
+			CreatedAt:   key.CreatedAt,
+			RevokedAt:   key.RevokedAt,
+			Current:     key.ID == token.KeyID,
+		}
+	}
+	writeJSON(w, http.StatusOK, ListKeysResponse{Keys: items})
+}
+
+// keyFingerprint is a short, stable name for a public key, in the style of
+// OpenSSH's SHA256 fingerprints
+func keyFingerprint(publicKey string) string {
+	sum := sha256.Sum256([]byte(publicKey))
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
 // DeleteAccountKey handles DELETE /api/accounts/{id}/keys/{keyId}
 func (h *Handler) DeleteAccountKey(w http.ResponseWriter, r *http.Request) {
 	accountID := r.PathValue("id")
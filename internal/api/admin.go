@@ -1,8 +1,19 @@
 package api
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alphabot-ai/slashclaw/internal/auth"
+	"github.com/alphabot-ai/slashclaw/internal/ratelimit"
+	"github.com/alphabot-ai/slashclaw/internal/store"
 )
 
 type HideRequest struct {
@@ -14,17 +25,90 @@ type HideResponse struct {
 	OK bool `json:"ok"`
 }
 
+type ApproveRequest struct {
+	StoryID string `json:"story_id"`
+}
+
+type ApproveResponse struct {
+	OK bool `json:"ok"`
+}
+
+type RevokeAgentTokensRequest struct {
+	AgentID string `json:"agent_id"`
+}
+
+type RevokeAgentTokensResponse struct {
+	OK      bool  `json:"ok"`
+	Revoked int64 `json:"revoked"` // how many tokens were deleted
+}
+
+type RecomputeRequest struct {
+	TargetType string `json:"target_type,omitempty"` // "story" or "comment"; empty recomputes both
+	TargetID   string `json:"target_id,omitempty"`   // empty recomputes every target of the type
+}
+
+type RecomputeResponse struct {
+	OK        bool   `json:"ok"`
+	Score     *int   `json:"score,omitempty"`     // the single target's recomputed score
+	Corrected *int64 `json:"corrected,omitempty"` // how many scores were off, when recomputing all
+}
+
+type RateLimitBucketsResponse struct {
+	Buckets []ratelimit.BucketInfo `json:"buckets"` // sorted by key
+}
+
+type CreateAdminTokenRequest struct {
+	Label  string   `json:"label,omitempty"` // who or what the token is for
+	Scopes []string `json:"scopes"`
+}
+
+// CreateAdminTokenResponse carries the bearer token, shown only this once
+type CreateAdminTokenResponse struct {
+	Token string `json:"token"`
+	*store.AdminToken
+}
+
+type ListAdminTokensResponse struct {
+	Tokens []*store.AdminToken `json:"tokens"` // oldest first
+}
+
+type RevokeAdminTokenResponse struct {
+	OK bool `json:"ok"`
+}
+
+type RecomputeStoryResponse struct {
+	OK           bool `json:"ok"`
+	Score        int  `json:"score"`
+	CommentCount int  `json:"comment_count"`
+}
+
+// ActivityItem is one entry in the admin activity feed: a story, a comment,
+// or an admin action, as Type says
+type ActivityItem struct {
+	Type      string            `json:"type"` // "story", "comment", or "audit"
+	CreatedAt time.Time         `json:"created_at"`
+	Hidden    bool              `json:"hidden,omitempty"` // the story or comment is hidden
+	Story     *store.Story      `json:"story,omitempty"`
+	Comment   *store.Comment    `json:"comment,omitempty"`
+	Audit     *store.AuditEntry `json:"audit,omitempty"`
+}
+
+type ActivityResponse struct {
+	Items      []ActivityItem `json:"items"`                 // newest first
+	NextBefore string         `json:"next_before,omitempty"` // pass as before for the next page
+}
+
 // Hide handles POST /api/admin/hide
 func (h *Handler) Hide(w http.ResponseWriter, r *http.Request) {
 	// Check admin auth
-	if !h.isAdmin(r) {
+	if !h.isAdmin(r, auth.ScopeHide) {
 		writeError(w, http.StatusUnauthorized, "admin authentication required")
 		return
 	}
 
 	var req HideRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, http.StatusBadRequest, "invalid JSON")
+		writeBodyError(w, err, "invalid JSON")
 		return
 	}
 
@@ -42,6 +126,10 @@ func (h *Handler) Hide(w http.ResponseWriter, r *http.Request) {
 	if req.TargetType == "story" {
 		// Verify story exists
 		story, getErr := h.store.GetStory(r.Context(), req.TargetID)
+		if story == nil && getErr == nil {
+			// Hiding a pending story rejects it
+			story, getErr = h.store.GetPendingStory(r.Context(), req.TargetID)
+		}
 		if getErr != nil {
 			writeError(w, http.StatusInternalServerError, "database error")
 			return
@@ -69,6 +157,404 @@ func (h *Handler) Hide(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusInternalServerError, "failed to hide content")
 		return
 	}
+	h.logAudit(r, store.AuditHide, req.TargetType, req.TargetID)
 
 	writeJSON(w, http.StatusOK, HideResponse{OK: true})
 }
+
+// Unhide handles POST /api/admin/unhide, undoing a hide. It takes the same
+// body as Hide and needs the same scope. Pending stories are approved, not
+// unhidden, so only hidden ones that aren't awaiting review are found.
+func (h *Handler) Unhide(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdmin(r, auth.ScopeHide) {
+		writeError(w, http.StatusUnauthorized, "admin authentication required")
+		return
+	}
+
+	var req HideRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBodyError(w, err, "invalid JSON")
+		return
+	}
+
+	if req.TargetType != "story" && req.TargetType != "comment" {
+		writeError(w, http.StatusBadRequest, "target_type must be 'story' or 'comment'")
+		return
+	}
+
+	if req.TargetID == "" {
+		writeError(w, http.StatusBadRequest, "target_id is required")
+		return
+	}
+
+	var err error
+	if req.TargetType == "story" {
+		story, getErr := h.store.GetHiddenStory(r.Context(), req.TargetID)
+		if getErr != nil {
+			writeError(w, http.StatusInternalServerError, "database error")
+			return
+		}
+		if story == nil {
+			writeError(w, http.StatusNotFound, "hidden story not found")
+			return
+		}
+		err = h.store.UnhideStory(r.Context(), req.TargetID)
+	} else {
+		comment, getErr := h.store.GetHiddenComment(r.Context(), req.TargetID)
+		if getErr != nil {
+			writeError(w, http.StatusInternalServerError, "database error")
+			return
+		}
+		if comment == nil {
+			writeError(w, http.StatusNotFound, "hidden comment not found")
+			return
+		}
+		err = h.store.UnhideComment(r.Context(), req.TargetID)
+	}
+
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to unhide content")
+		return
+	}
+	h.logAudit(r, store.AuditUnhide, req.TargetType, req.TargetID)
+
+	log.Printf("admin: unhid %s %s", req.TargetType, req.TargetID)
+	writeJSON(w, http.StatusOK, HideResponse{OK: true})
+}
+
+// ReviewQueue handles GET /api/admin/queue
+func (h *Handler) ReviewQueue(w http.ResponseWriter, r *http.Request) {
+	// Check admin auth
+	if !h.isAdmin(r, auth.ScopeQueue) {
+		writeError(w, http.StatusUnauthorized, "admin authentication required")
+		return
+	}
+
+	h.writePendingStories(w, r, "")
+}
+
+// Approve handles POST /api/admin/approve
+func (h *Handler) Approve(w http.ResponseWriter, r *http.Request) {
+	// Check admin auth
+	if !h.isAdmin(r, auth.ScopeQueue) {
+		writeError(w, http.StatusUnauthorized, "admin authentication required")
+		return
+	}
+
+	var req ApproveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBodyError(w, err, "invalid JSON")
+		return
+	}
+
+	if req.StoryID == "" {
+		writeError(w, http.StatusBadRequest, "story_id is required")
+		return
+	}
+
+	approved, err := h.store.ApproveStory(r.Context(), req.StoryID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to approve story")
+		return
+	}
+	if !approved {
+		writeError(w, http.StatusNotFound, "pending story not found")
+		return
+	}
+	h.logAudit(r, store.AuditApprove, "story", req.StoryID)
+
+	writeJSON(w, http.StatusOK, ApproveResponse{OK: true})
+}
+
+// Recompute handles POST /api/admin/recompute, resetting scores to the sum of
+// their votes to repair drift
+func (h *Handler) Recompute(w http.ResponseWriter, r *http.Request) {
+	// Check admin auth
+	if !h.isAdmin(r, auth.ScopeRecompute) {
+		writeError(w, http.StatusUnauthorized, "admin authentication required")
+		return
+	}
+
+	var req RecomputeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBodyError(w, err, "invalid JSON")
+		return
+	}
+
+	if req.TargetType != "" && req.TargetType != "story" && req.TargetType != "comment" {
+		writeError(w, http.StatusBadRequest, "target_type must be 'story' or 'comment'")
+		return
+	}
+
+	// Single target
+	if req.TargetID != "" {
+		if req.TargetType == "" {
+			writeError(w, http.StatusBadRequest, "target_type is required with target_id")
+			return
+		}
+		score, err := h.store.RecomputeScore(r.Context(), req.TargetType, req.TargetID)
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, req.TargetType+" not found")
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to recompute score")
+			return
+		}
+		h.logAudit(r, store.AuditRecompute, req.TargetType, req.TargetID)
+		writeJSON(w, http.StatusOK, RecomputeResponse{OK: true, Score: &score})
+		return
+	}
+
+	// Every target of the type, or of both types
+	types := []string{"story", "comment"}
+	if req.TargetType != "" {
+		types = []string{req.TargetType}
+	}
+	var corrected int64
+	for _, targetType := range types {
+		n, err := h.store.RecomputeAllScores(r.Context(), targetType)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to recompute scores")
+			return
+		}
+		corrected += n
+	}
+	h.logAudit(r, store.AuditRecompute, req.TargetType, "")
+
+	writeJSON(w, http.StatusOK, RecomputeResponse{OK: true, Corrected: &corrected})
+}
+
+// RecomputeStory handles POST /api/admin/stories/{id}/recompute, rebuilding
+// one story's counters from its votes and comments
+func (h *Handler) RecomputeStory(w http.ResponseWriter, r *http.Request) {
+	// Check admin auth
+	if !h.isAdmin(r, auth.ScopeRecompute) {
+		writeError(w, http.StatusUnauthorized, "admin authentication required")
+		return
+	}
+
+	story, err := h.store.RecomputeStory(r.Context(), r.PathValue("id"))
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, "story not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to recompute story")
+		return
+	}
+	h.logAudit(r, store.AuditRecompute, "story", story.ID)
+
+	writeJSON(w, http.StatusOK, RecomputeStoryResponse{OK: true, Score: story.Score, CommentCount: story.CommentCount})
+}
+
+// RevokeAgentTokens handles POST /api/admin/revoke-agent-tokens, signing out
+// every session of an agent across all of its accounts and keys
+func (h *Handler) RevokeAgentTokens(w http.ResponseWriter, r *http.Request) {
+	// Check admin auth
+	if !h.isAdmin(r, auth.ScopeRevokeTokens) {
+		writeError(w, http.StatusUnauthorized, "admin authentication required")
+		return
+	}
+
+	var req RevokeAgentTokensRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBodyError(w, err, "invalid JSON")
+		return
+	}
+
+	if req.AgentID == "" {
+		writeError(w, http.StatusBadRequest, "agent_id is required")
+		return
+	}
+
+	revoked, err := h.store.DeleteTokensForAgent(r.Context(), req.AgentID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to revoke tokens")
+		return
+	}
+	// JWTs validate without the store, so deleting them there isn't enough
+	if err := h.auth.RevokeAgent(r.Context(), req.AgentID); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to revoke tokens")
+		return
+	}
+	h.logAudit(r, store.AuditRevokeTokens, "agent", req.AgentID)
+
+	log.Printf("admin: revoked %d tokens for agent %q from %s", revoked, req.AgentID, h.getClientIP(r))
+
+	writeJSON(w, http.StatusOK, RevokeAgentTokensResponse{OK: true, Revoked: revoked})
+}
+
+// RateLimitBuckets handles GET /api/admin/ratelimit/buckets, listing the
+// limiter's active buckets. Keys are "action:ip" or "action:ip:agent"; the
+// prefix parameter narrows the list, e.g. to one action or one client.
+func (h *Handler) RateLimitBuckets(w http.ResponseWriter, r *http.Request) {
+	// Check admin auth
+	if !h.isAdmin(r, auth.ScopeRateLimitRead) {
+		writeError(w, http.StatusUnauthorized, "admin authentication required")
+		return
+	}
+
+	prefix := r.URL.Query().Get("prefix")
+	buckets := []ratelimit.BucketInfo{}
+	for _, b := range h.limiter.Snapshot() {
+		if strings.HasPrefix(b.Key, prefix) {
+			buckets = append(buckets, b)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, RateLimitBucketsResponse{Buckets: buckets})
+}
+
+// CreateAdminToken handles POST /api/admin/tokens. Only the admin secret may
+// issue tokens, so a leaked token can't mint itself more scopes.
+func (h *Handler) CreateAdminToken(w http.ResponseWriter, r *http.Request) {
+	if !h.hasAdminSecret(r) {
+		writeError(w, http.StatusUnauthorized, "admin secret required")
+		return
+	}
+
+	var req CreateAdminTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBodyError(w, err, decodeErrorMessage(err))
+		return
+	}
+
+	tokenStr, token, err := h.admin.IssueToken(r.Context(), req.Label, req.Scopes)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidScope) {
+			writeError(w, http.StatusBadRequest, "scopes must be one or more of: "+strings.Join(auth.AdminScopes, ", "))
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "failed to issue token")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, CreateAdminTokenResponse{Token: tokenStr, AdminToken: token})
+}
+
+// ListAdminTokens handles GET /api/admin/tokens
+func (h *Handler) ListAdminTokens(w http.ResponseWriter, r *http.Request) {
+	if !h.hasAdminSecret(r) {
+		writeError(w, http.StatusUnauthorized, "admin secret required")
+		return
+	}
+
+	tokens, err := h.admin.ListTokens(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+	if tokens == nil {
+		tokens = []*store.AdminToken{}
+	}
+
+	writeJSON(w, http.StatusOK, ListAdminTokensResponse{Tokens: tokens})
+}
+
+// RevokeAdminToken handles DELETE /api/admin/tokens/{id}
+func (h *Handler) RevokeAdminToken(w http.ResponseWriter, r *http.Request) {
+	if !h.hasAdminSecret(r) {
+		writeError(w, http.StatusUnauthorized, "admin secret required")
+		return
+	}
+
+	found, err := h.admin.RevokeToken(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to revoke token")
+		return
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, "admin token not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, RevokeAdminTokenResponse{OK: true})
+}
+
+// Activity handles GET /api/admin/activity, a feed of recent stories,
+// comments, and admin actions, newest first, hidden and pending content
+// included. Pages are cut by time: before takes the previous page's
+// next_before, so entries sharing that exact instant may be skipped.
+func (h *Handler) Activity(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdmin(r, auth.ScopeActivityRead) {
+		writeError(w, http.StatusUnauthorized, "admin authentication required")
+		return
+	}
+
+	query := r.URL.Query()
+	limit := 50
+	if limitStr := query.Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 200 {
+			limit = l
+		}
+	}
+	var before time.Time
+	if beforeStr := query.Get("before"); beforeStr != "" {
+		t, err := time.Parse(time.RFC3339Nano, beforeStr)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "before must be an RFC 3339 time")
+			return
+		}
+		before = t
+	}
+
+	// One more of each than the page holds tells whether there is another page
+	stories, comments, err := h.store.ListRecentContent(r.Context(), before, limit+1)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+	entries, err := h.store.ListAudit(r.Context(), before, limit+1)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+
+	items := make([]ActivityItem, 0, len(stories)+len(comments)+len(entries))
+	for _, story := range stories {
+		items = append(items, ActivityItem{Type: "story", CreatedAt: story.CreatedAt, Hidden: story.Hidden, Story: story})
+	}
+	for _, comment := range comments {
+		items = append(items, ActivityItem{Type: "comment", CreatedAt: comment.CreatedAt, Hidden: comment.Hidden, Comment: comment})
+	}
+	for _, entry := range entries {
+		items = append(items, ActivityItem{Type: "audit", CreatedAt: entry.CreatedAt, Audit: entry})
+	}
+	slices.SortStableFunc(items, func(a, b ActivityItem) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+
+	resp := ActivityResponse{Items: items}
+	if len(items) > limit {
+		resp.Items = items[:limit]
+		resp.NextBefore = items[limit-1].CreatedAt.Format(time.RFC3339Nano)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// logAudit records an admin action taken through r. The action has already
+// happened, so failing to record it is logged rather than reported.
+func (h *Handler) logAudit(r *http.Request, action, targetType, targetID string) {
+	entry := &store.AuditEntry{
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Actor:      h.adminActor(r),
+	}
+	if err := h.store.LogAudit(r.Context(), entry); err != nil {
+		log.Printf("admin: recording %s of %s %q: %v", action, targetType, targetID, err)
+	}
+}
+
+// adminActor names who is acting through r for the audit log
+func (h *Handler) adminActor(r *http.Request) string {
+	if h.hasAdminSecret(r) {
+		return "secret"
+	}
+	token, err := h.admin.Lookup(r.Context(), h.getToken(r))
+	if err != nil || token == nil {
+		return "unknown"
+	}
+	return "token:" + token.ID
+}
@@ -2,8 +2,16 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
 	"net/http"
+	"net/netip"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/alphabot-ai/slashclaw/internal/auth"
 	"github.com/alphabot-ai/slashclaw/internal/config"
@@ -15,18 +23,29 @@ import (
 type Handler struct {
 	store   store.Store
 	auth    *auth.Service
+	admin   *auth.AdminService
 	limiter ratelimit.Limiter
 	cfg     *config.Config
+	titles  *titleFetcher // nil unless FetchTitles is on
+
+	trustedProxies []netip.Prefix
 }
 
 // NewHandler creates a new API handler
 func NewHandler(s store.Store, authSvc *auth.Service, limiter ratelimit.Limiter, cfg *config.Config) *Handler {
-	return &Handler{
+	h := &Handler{
 		store:   s,
 		auth:    authSvc,
+		admin:   auth.NewAdminService(s),
 		limiter: limiter,
 		cfg:     cfg,
 	}
+	// Validate has already rejected a malformed TRUSTED_PROXIES
+	h.trustedProxies, _ = cfg.TrustedProxyPrefixes()
+	if cfg.FetchTitles {
+		h.titles = newTitleFetcher(cfg.FetchTitleTimeout)
+	}
+	return h
 }
 
 // Response helpers
@@ -47,13 +66,46 @@ func writeError(w http.ResponseWriter, status int, message string) {
 }
 
 func writeRateLimited(w http.ResponseWriter, retryAfter int) {
-	w.Header().Set("Retry-After", string(rune(retryAfter)))
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
 	writeJSON(w, http.StatusTooManyRequests, ErrorResponse{
 		Error:      "rate limit exceeded",
 		RetryAfter: retryAfter,
 	})
 }
 
+// writeBodyError reports a request body that couldn't be read or decoded:
+// 413 if it ran past LimitBodies' cap, otherwise 400 with message
+func writeBodyError(w http.ResponseWriter, err error, message string) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body exceeds %d bytes", tooLarge.Limit))
+		return
+	}
+	writeError(w, http.StatusBadRequest, message)
+}
+
+// decodeErrorMessage turns a JSON decode error into a client-facing message,
+// naming the offending field when a value had the wrong type
+func decodeErrorMessage(err error) string {
+	var typeErr *json.UnmarshalTypeError
+	if !errors.As(err, &typeErr) || typeErr.Field == "" {
+		return "invalid JSON"
+	}
+
+	// Field is a path like "tags.1"; report the top-level request field
+	field, _, _ := strings.Cut(typeErr.Field, ".")
+	if field == "tags" {
+		return "tags must be an array of strings"
+	}
+	return "invalid JSON: wrong type for " + field
+}
+
+// formatTime renders t the way every API time field is serialized: RFC 3339
+// in UTC with a Z suffix
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
 // Request helpers
 
 func (h *Handler) getAgentID(r *http.Request) string {
@@ -61,21 +113,58 @@ func (h *Handler) getAgentID(r *http.Request) string {
 }
 
 func (h *Handler) getClientIP(r *http.Request) string {
-	// Check X-Forwarded-For first
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		parts := strings.Split(xff, ",")
-		return strings.TrimSpace(parts[0])
+	return clientIP(r, h.trustedProxies)
+}
+
+// clientIP returns the address of the client behind r. Forwarded headers are
+// only believed when r comes from a trusted proxy, and X-Forwarded-For is read
+// from the right, skipping trusted hops, so a client can't claim an address
+// by sending the header itself: the entry a proxy appended is what counts.
+func clientIP(r *http.Request, trusted []netip.Prefix) string {
+	remote := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+	if !isTrustedProxy(remote, trusted) {
+		return remote
 	}
-	// Check X-Real-IP
-	if xri := r.Header.Get("X-Real-IP"); xri != "" {
-		return xri
+
+	if xff := strings.Join(r.Header.Values("X-Forwarded-For"), ","); xff != "" {
+		client := remote
+		hops := strings.Split(xff, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if _, err := netip.ParseAddr(hop); err != nil {
+				// Nothing to the left of a garbled hop can be vouched for
+				break
+			}
+			client = hop
+			if !isTrustedProxy(hop, trusted) {
+				break
+			}
+		}
+		return client
+	}
+	if xri := strings.TrimSpace(r.Header.Get("X-Real-IP")); xri != "" {
+		if _, err := netip.ParseAddr(xri); err == nil {
+			return xri
+		}
+	}
+	return remote
+}
+
+func isTrustedProxy(ip string, trusted []netip.Prefix) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
 	}
-	// Fall back to RemoteAddr
-	addr := r.RemoteAddr
-	if idx := strings.LastIndex(addr, ":"); idx != -1 {
-		return addr[:idx]
+	addr = addr.Unmap()
+	for _, prefix := range trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
 	}
-	return addr
+	return false
 }
 
 func (h *Handler) getToken(r *http.Request) string {
@@ -94,32 +183,83 @@ func (h *Handler) validateToken(r *http.Request) (*store.Token, error) {
 	return h.auth.ValidateToken(r.Context(), tokenStr)
 }
 
-func (h *Handler) checkRateLimit(r *http.Request, action string, limit int) (bool, int) {
+// rateLimitStatus is the outcome of a per-action rate limit check
+type rateLimitStatus struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Time // when the current window ends
+	RetryAfter int       // seconds until Reset, set only when not allowed
+}
+
+// writeHeaders reports the limit state to the client so it can pace itself
+// before being blocked
+func (s rateLimitStatus) writeHeaders(w http.ResponseWriter) {
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(s.Limit))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(s.Remaining))
+	w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(s.Reset.Unix(), 10))
+}
+
+func (h *Handler) checkRateLimit(r *http.Request, action string, limit int) rateLimitStatus {
+	return h.checkRateLimitN(r, action, limit, 1)
+}
+
+// checkRateLimitN charges n actions at once, allowing them only if all n fit
+// in what remains of the window
+func (h *Handler) checkRateLimitN(r *http.Request, action string, limit, n int) rateLimitStatus {
 	ip := h.getClientIP(r)
-	agentID := h.getAgentID(r)
 
-	// Create rate limit key combining IP and agent
+	// Create rate limit key combining IP and agent. Only a token-verified
+	// agent counts: an X-Agent-Id header could be rotated for fresh budgets.
 	key := action + ":" + ip
-	if agentID != "" {
+	if agentID, verified, _ := GetAuthFromContext(r.Context()); verified && agentID != "" {
 		key += ":" + agentID
 	}
 
-	if !h.limiter.Allow(key, limit, h.cfg.RateLimitWindow) {
-		retryAfter := int(h.limiter.RetryAfter(key, h.cfg.RateLimitWindow).Seconds())
-		return false, retryAfter
+	window := h.cfg.RateLimitWindow
+	allowed := n <= 1 || h.limiter.Remaining(key, limit, window) >= n
+	for i := 0; allowed && i < n; i++ {
+		allowed = h.limiter.Allow(key, limit, window)
 	}
 
-	return true, 0
-}
+	status := rateLimitStatus{Allowed: allowed, Limit: limit}
+	status.Remaining = h.limiter.Remaining(key, limit, window)
 
-func (h *Handler) isAdmin(r *http.Request) bool {
-	secret := r.Header.Get("X-Admin-Secret")
-	return h.cfg.AdminSecret != "" && secret == h.cfg.AdminSecret
+	retryAfter := h.limiter.RetryAfter(key, window)
+	if retryAfter <= 0 {
+		retryAfter = window
+	}
+	// Round up so the reset never lands before the window actually ends
+	status.Reset = time.Now().Add(retryAfter + time.Second - 1).Truncate(time.Second)
+	if !status.Allowed {
+		status.RetryAfter = int(math.Ceil(retryAfter.Seconds()))
+	}
+
+	return status
 }
 
-// Content negotiation
+// isAdmin reports whether r may use an admin endpoint that requires scope:
+// either it carries the admin secret, which holds every scope, or an admin
+// bearer token granted that scope
+func (h *Handler) isAdmin(r *http.Request, scope string) bool {
+	if h.hasAdminSecret(r) {
+		return true
+	}
+	tokenStr := h.getToken(r)
+	if tokenStr == "" {
+		return false
+	}
+	ok, err := h.admin.Authorize(r.Context(), tokenStr, scope)
+	if err != nil {
+		log.Printf("admin: checking token: %v", err)
+		return false
+	}
+	return ok
+}
 
-func wantsJSON(r *http.Request) bool {
-	accept := r.Header.Get("Accept")
-	return strings.Contains(accept, "application/json")
+// hasAdminSecret reports whether r carries the admin secret itself, which
+// alone may issue and revoke admin tokens
+func (h *Handler) hasAdminSecret(r *http.Request) bool {
+	secret := r.Header.Get("X-Admin-Secret")
+	return h.cfg.AdminSecret != "" && secret == h.cfg.AdminSecret
 }
@@ -3,10 +3,21 @@ package api
 import (
 	"bytes"
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"net/url"
 	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -14,6 +25,7 @@ import (
 	"github.com/alphabot-ai/slashclaw/internal/config"
 	"github.com/alphabot-ai/slashclaw/internal/ratelimit"
 	"github.com/alphabot-ai/slashclaw/internal/store"
+	"github.com/santhosh-tekuri/jsonschema/v6"
 )
 
 type testServer struct {
@@ -38,14 +50,24 @@ func setupTestServer(t *testing.T) *testServer {
 	}
 
 	cfg := &config.Config{
-		StoryRateLimit:   100,
-		CommentRateLimit: 100,
-		VoteRateLimit:    100,
-		RateLimitWindow:  time.Hour,
-		ChallengeTTL:     5 * time.Minute,
-		TokenTTL:         24 * time.Hour,
-		DuplicateWindow:  30 * 24 * time.Hour,
-		AdminSecret:      "test-admin-secret",
+		StoryRateLimit:      100,
+		CommentRateLimit:    100,
+		VoteRateLimit:       100,
+		RateLimitWindow:     time.Hour,
+		ChallengeTTL:        5 * time.Minute,
+		TokenTTL:            24 * time.Hour,
+		DuplicateWindow:     30 * 24 * time.Hour,
+		DuplicateText:       config.DuplicateTextBlock,
+		DuplicateTextWindow: 24 * time.Hour,
+		AdminSecret:         "test-admin-secret",
+		AllowAnonymousVotes: true,
+		VoteWeightVerified:  1,
+		VoteWeightAnon:      1,
+		TitleMinLength:      8,
+		TitleMaxLength:      180,
+		MaxTags:             5,
+		MaxCommentDepth:     8,
+		FlagThreshold:       3,
 	}
 
 	limiter := ratelimit.NewMemoryLimiter()
@@ -145,6 +167,16 @@ func TestCreateStoryAPI(t *testing.T) {
 			wantStatus: http.StatusBadRequest,
 			wantError:  true,
 		},
+		{
+			name: "oversized tags",
+			body: map[string]any{
+				"title": "Test Story Title",
+				"url":   "https://example.com/oversized-tags-test",
+				"tags":  []string{strings.Repeat("x", 600), strings.Repeat("y", 600)},
+			},
+			wantStatus: http.StatusBadRequest,
+			wantError:  true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -176,6 +208,94 @@ func TestCreateStoryAPI(t *testing.T) {
 	}
 }
 
+func TestCreateStoryNonStringTag(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+
+	body := []byte(`{"title":"Test Story Title","url":"https://example.com/tags","tags":["go",42]}`)
+	req := httptest.NewRequest(http.MethodPost, "/api/stories", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	rec := httptest.NewRecorder()
+	ts.handler.CreateStory(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	var resp ErrorResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if resp.Error != "tags must be an array of strings" {
+		t.Errorf("error = %q, want tags-specific message", resp.Error)
+	}
+}
+
+func TestCreateStoryCustomRules(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+	ts.handler.cfg.TitleMinLength = 3
+	ts.handler.cfg.TitleMaxLength = 12
+	ts.handler.cfg.AllowURLAndText = true
+	ts.handler.cfg.MaxTags = 2
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{"url and text", `{"title":"Both","url":"https://example.com/both","text":"Commentary"}`, http.StatusCreated},
+		{"short title", `{"title":"Go!","text":"Short title content"}`, http.StatusCreated},
+		{"title under minimum", `{"title":"Go","text":"Content"}`, http.StatusBadRequest},
+		{"title over maximum", `{"title":"Thirteen char","text":"Content"}`, http.StatusBadRequest},
+		{"neither url nor text", `{"title":"Nothing"}`, http.StatusBadRequest},
+		{"tags at limit", `{"title":"Two tags","text":"Tagged content","tags":["a","b"]}`, http.StatusCreated},
+		{"tags over limit", `{"title":"Three tags","text":"Content","tags":["a","b","c"]}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/stories", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			ts.handler.CreateStory(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d; body = %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+		})
+	}
+
+	var created CreateStoryResponse
+	req := httptest.NewRequest(http.MethodPost, "/api/stories", strings.NewReader(`{"title":"Kept both","url":"https://example.com/kept","text":"Kept text"}`))
+	rec := httptest.NewRecorder()
+	ts.handler.CreateStory(rec, req)
+	json.Unmarshal(rec.Body.Bytes(), &created)
+	if story, _ := ts.store.GetStory(context.Background(), created.ID); story == nil || story.URL != "https://example.com/kept" || story.Text != "Kept text" {
+		t.Errorf("story = %+v, want both its url and text kept", story)
+	}
+
+	// The published limits follow the configuration
+	req = httptest.NewRequest(http.MethodGet, "/api/schema/story", nil)
+	req.SetPathValue("resource", "story")
+	rec = httptest.NewRecorder()
+	ts.handler.GetSchema(rec, req)
+	var schema struct {
+		Properties struct {
+			Title struct {
+				MinLength int `json:"minLength"`
+				MaxLength int `json:"maxLength"`
+			} `json:"title"`
+			Tags struct {
+				MaxItems int `json:"maxItems"`
+			} `json:"tags"`
+		} `json:"properties"`
+		AnyOf []any `json:"anyOf"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &schema)
+	if schema.Properties.Title.MinLength != 3 || schema.Properties.Title.MaxLength != 12 || schema.Properties.Tags.MaxItems != 2 || len(schema.AnyOf) != 2 {
+		t.Errorf("schema = %s, want title 3-12, at most 2 tags, and anyOf url or text", rec.Body.String())
+	}
+}
+
 func TestDuplicateURLDetection(t *testing.T) {
 	ts := setupTestServer(t)
 	defer ts.cleanup()
@@ -220,6 +340,171 @@ func TestDuplicateURLDetection(t *testing.T) {
 	}
 }
 
+func TestDuplicateURLVariants(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+
+	createStory := func(title, url string) (int, CreateStoryResponse) {
+		body, _ := json.Marshal(map[string]any{"title": title, "url": url})
+		req := httptest.NewRequest(http.MethodPost, "/api/stories", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		ts.handler.CreateStory(rec, req)
+
+		var resp CreateStoryResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp
+	}
+
+	_, original := createStory("Original Story", "https://example.com/x")
+
+	for _, variant := range []string{
+		"https://example.com/x/",
+		"https://example.com/x?utm_source=rss",
+		"http://example.com/x",
+		"https://EXAMPLE.com:443/x#comments",
+	} {
+		code, resp := createStory("Variant Story", variant)
+		if code != http.StatusOK || resp.ID != original.ID || !resp.Existing {
+			t.Errorf("%s: got %d %+v, want 200 with existing story %s", variant, code, resp, original.ID)
+		}
+	}
+
+	if code, resp := createStory("Different Story", "https://example.com/x?page=2"); code != http.StatusCreated || resp.Existing {
+		t.Errorf("different query: got %d %+v, want 201", code, resp)
+	}
+
+	// The story keeps the URL as it was submitted
+	if story, _ := ts.store.GetStory(context.Background(), original.ID); story.URL != "https://example.com/x" {
+		t.Errorf("url = %q, want it as submitted", story.URL)
+	}
+}
+
+func TestStorySourceURL(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+
+	createStory := func(body map[string]any) (int, CreateStoryResponse) {
+		raw, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, "/api/stories", bytes.NewReader(raw))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		ts.handler.CreateStory(rec, req)
+
+		var resp CreateStoryResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp
+	}
+
+	code, created := createStory(map[string]any{
+		"title":      "Story with two links",
+		"url":        "https://example.com/article",
+		"source_url": "https://news.example.org/item?id=1",
+	})
+	if code != http.StatusCreated {
+		t.Fatalf("status = %d, want %d", code, http.StatusCreated)
+	}
+
+	// Both links round-trip through the API
+	req := httptest.NewRequest(http.MethodGet, "/api/stories/"+created.ID, nil)
+	req.SetPathValue("id", created.ID)
+	rec := httptest.NewRecorder()
+	ts.handler.GetStory(rec, req)
+	var story store.Story
+	json.Unmarshal(rec.Body.Bytes(), &story)
+	if story.URL != "https://example.com/article" || story.SourceURL != "https://news.example.org/item?id=1" {
+		t.Errorf("url, source_url = %q, %q; want both as submitted", story.URL, story.SourceURL)
+	}
+
+	// Duplicate detection goes by url alone
+	if code, resp := createStory(map[string]any{
+		"title":      "Same url, other source",
+		"url":        "https://example.com/article",
+		"source_url": "https://elsewhere.example.net/thread",
+	}); code != http.StatusOK || !resp.Existing || resp.ID != created.ID {
+		t.Errorf("same url: got %d %+v, want 200 with existing story %s", code, resp, created.ID)
+	}
+	if code, resp := createStory(map[string]any{
+		"title":      "Other url, same source",
+		"url":        "https://example.com/follow-up",
+		"source_url": "https://news.example.org/item?id=1",
+	}); code != http.StatusCreated || resp.Existing {
+		t.Errorf("same source_url: got %d %+v, want 201", code, resp)
+	}
+
+	for name, body := range map[string]map[string]any{
+		"invalid":     {"title": "Bad source link", "url": "https://example.com/bad", "source_url": "not a url"},
+		"without url": {"title": "Text with a source", "text": "Some body", "source_url": "https://example.com/src"},
+	} {
+		if code, _ := createStory(body); code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", name, code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestDuplicateTextDetection(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+
+	createStory := func(title, text string) (int, CreateStoryResponse) {
+		body, _ := json.Marshal(map[string]any{"title": title, "text": text})
+		req := httptest.NewRequest(http.MethodPost, "/api/stories", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		ts.handler.CreateStory(rec, req)
+
+		var resp CreateStoryResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp
+	}
+
+	_, original := createStory("Original text post", "Buy the  best\nwidgets today")
+
+	t.Run("identical body is blocked", func(t *testing.T) {
+		code, resp := createStory("A different title", "buy the best widgets TODAY ")
+		if code != http.StatusOK || resp.ID != original.ID || !resp.Existing {
+			t.Errorf("got %d %+v, want 200 with existing story %s", code, resp, original.ID)
+		}
+	})
+
+	t.Run("different body is accepted", func(t *testing.T) {
+		code, resp := createStory("Another text post", "Buy the best gadgets today")
+		if code != http.StatusCreated || resp.Existing {
+			t.Errorf("got %d %+v, want 201", code, resp)
+		}
+	})
+
+	t.Run("flag holds the repost for moderation", func(t *testing.T) {
+		ts.handler.cfg.DuplicateText = config.DuplicateTextFlag
+		defer func() { ts.handler.cfg.DuplicateText = config.DuplicateTextBlock }()
+
+		code, resp := createStory("Flagged repost", "Buy the best widgets today")
+		if code != http.StatusAccepted || resp.Status != "pending" {
+			t.Errorf("got %d %+v, want 202 pending", code, resp)
+		}
+	})
+
+	t.Run("outside the window is accepted", func(t *testing.T) {
+		ts.handler.cfg.DuplicateTextWindow = -time.Second
+		defer func() { ts.handler.cfg.DuplicateTextWindow = 24 * time.Hour }()
+
+		code, _ := createStory("Much later repost", "Buy the best widgets today")
+		if code != http.StatusCreated {
+			t.Errorf("status = %d, want %d", code, http.StatusCreated)
+		}
+	})
+
+	t.Run("off allows reposts", func(t *testing.T) {
+		ts.handler.cfg.DuplicateText = config.DuplicateTextOff
+		defer func() { ts.handler.cfg.DuplicateText = config.DuplicateTextBlock }()
+
+		code, _ := createStory("Yet another repost", "Buy the best widgets today")
+		if code != http.StatusCreated {
+			t.Errorf("status = %d, want %d", code, http.StatusCreated)
+		}
+	})
+}
+
 func TestListStoriesAPI(t *testing.T) {
 	ts := setupTestServer(t)
 	defer ts.cleanup()
@@ -232,6 +517,9 @@ func TestListStoriesAPI(t *testing.T) {
 		}
 		ts.store.CreateStory(context.Background(), story)
 	}
+	hidden := &store.Story{Title: "Hidden Story", Text: "Content"}
+	ts.store.CreateStory(context.Background(), hidden)
+	ts.store.HideStory(context.Background(), hidden.ID)
 
 	tests := []struct {
 		name       string
@@ -257,6 +545,18 @@ func TestListStoriesAPI(t *testing.T) {
 			wantCount:  3,
 			wantStatus: http.StatusOK,
 		},
+		{
+			name:       "discover",
+			query:      "?sort=discover",
+			wantCount:  3,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "discover with seed",
+			query:      "?sort=discover&seed=42&limit=2",
+			wantCount:  2,
+			wantStatus: http.StatusOK,
+		},
 		{
 			name:       "limit results",
 			query:      "?limit=2",
@@ -281,8 +581,274 @@ func TestListStoriesAPI(t *testing.T) {
 			if len(resp.Stories) != tt.wantCount {
 				t.Errorf("story count = %d, want %d", len(resp.Stories), tt.wantCount)
 			}
+			// The total covers every page but not the hidden story
+			if resp.Total == nil || *resp.Total != 3 {
+				t.Errorf("total = %v, want 3", resp.Total)
+			}
+		})
+	}
+}
+
+func TestListStoriesWithTopComment(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+
+	ctx := context.Background()
+	discussed := &store.Story{Title: "Discussed", Text: "Content"}
+	ts.store.CreateStory(ctx, discussed)
+	quiet := &store.Story{Title: "Quiet", Text: "Content"}
+	ts.store.CreateStory(ctx, quiet)
+
+	low := &store.Comment{StoryID: discussed.ID, Text: "Low"}
+	ts.store.CreateComment(ctx, low)
+	best := &store.Comment{StoryID: discussed.ID, Text: "Best"}
+	ts.store.CreateComment(ctx, best)
+	ts.store.UpdateCommentScore(ctx, best.ID, 5)
+
+	list := func(path string) map[string]*StoryListItem {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		rec := httptest.NewRecorder()
+		ts.handler.ListStories(rec, req)
+
+		var resp ListStoriesResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		byID := make(map[string]*StoryListItem)
+		for _, item := range resp.Stories {
+			byID[item.ID] = item
+		}
+		return byID
+	}
+
+	stories := list("/api/stories?with_top_comment=true")
+	if top := stories[discussed.ID].TopComment; top == nil || top.ID != best.ID {
+		t.Errorf("top comment = %+v, want %s", top, best.ID)
+	}
+	if top := stories[quiet.ID].TopComment; top != nil {
+		t.Errorf("story without comments has top comment %+v", top)
+	}
+
+	if top := list("/api/stories")[discussed.ID].TopComment; top != nil {
+		t.Error("top comment included without with_top_comment")
+	}
+}
+
+func TestExportStoryAPI(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+
+	ctx := context.Background()
+	story := &store.Story{Title: "Exported Story", Text: "Content", AgentID: "author"}
+	ts.store.CreateStory(ctx, story)
+	root := &store.Comment{StoryID: story.ID, Text: "Root", AgentID: "a"}
+	ts.store.CreateComment(ctx, root)
+	reply := &store.Comment{StoryID: story.ID, ParentID: root.ID, Text: "Reply", AgentID: "b"}
+	ts.store.CreateComment(ctx, reply)
+	hidden := &store.Comment{StoryID: story.ID, Text: "Hidden"}
+	ts.store.CreateComment(ctx, hidden)
+	ts.store.HideComment(ctx, hidden.ID)
+
+	ts.store.CastVote(ctx, &store.Vote{TargetType: "story", TargetID: story.ID, Value: 1, IPHash: "secret-ip-1"})
+	ts.store.CastVote(ctx, &store.Vote{TargetType: "story", TargetID: story.ID, Value: 1, IPHash: "secret-ip-2"})
+	ts.store.CastVote(ctx, &store.Vote{TargetType: "story", TargetID: story.ID, Value: -1, IPHash: "secret-ip-3"})
+	ts.store.CastVote(ctx, &store.Vote{TargetType: "comment", TargetID: reply.ID, Value: 1, IPHash: "secret-ip-1"})
+	ts.store.CastVote(ctx, &store.Vote{TargetType: "comment", TargetID: hidden.ID, Value: 1, IPHash: "secret-ip-1"})
+
+	export := func(id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/stories/"+id+"/export", nil)
+		req.SetPathValue("id", id)
+		rec := httptest.NewRecorder()
+		ts.handler.ExportStory(rec, req)
+		return rec
+	}
+
+	rec := export(story.ID)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if strings.Contains(rec.Body.String(), "secret-ip") {
+		t.Error("export should not contain IP hashes")
+	}
+
+	var bundle StoryExport
+	if err := json.Unmarshal(rec.Body.Bytes(), &bundle); err != nil {
+		t.Fatalf("export is not valid JSON: %v\n%s", err, rec.Body.String())
+	}
+
+	if bundle.Story == nil || bundle.Story.ID != story.ID || bundle.Story.Score != 1 {
+		t.Errorf("story = %+v, want %s with score 1", bundle.Story, story.ID)
+	}
+	if len(bundle.Comments) != 1 || bundle.Comments[0].ID != root.ID {
+		t.Fatalf("comments = %+v, want only the visible root", bundle.Comments)
+	}
+	if children := bundle.Comments[0].Children; len(children) != 1 || children[0].ID != reply.ID || children[0].Score != 1 {
+		t.Errorf("root children = %+v, want the scored reply", children)
+	}
+	if bundle.Comments[0].CreatedAt.IsZero() {
+		t.Error("comments should carry timestamps")
+	}
+
+	if got := bundle.VoteSummary.Story; got != (store.VoteTally{Up: 2, Down: 1}) {
+		t.Errorf("story votes = %+v, want 2 up, 1 down", got)
+	}
+	if got := bundle.VoteSummary.Comments[reply.ID]; got != (store.VoteTally{Up: 1}) {
+		t.Errorf("reply votes = %+v, want 1 up", got)
+	}
+	if _, ok := bundle.VoteSummary.Comments[hidden.ID]; ok || len(bundle.VoteSummary.Comments) != 1 {
+		t.Errorf("comment votes = %+v, want only the reply's", bundle.VoteSummary.Comments)
+	}
+
+	if rec := export("nonexistent"); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown story: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestListStoriesByAgentAPI(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+
+	ctx := context.Background()
+	for _, agent := range []string{"agent-a", "agent-b", "agent-a", ""} {
+		ts.store.CreateStory(ctx, &store.Story{Title: "Test Story", Text: "Content", AgentID: agent})
+	}
+	hidden := &store.Story{Title: "Hidden Story", Text: "Content", AgentID: "agent-a"}
+	ts.store.CreateStory(ctx, hidden)
+	ts.store.HideStory(ctx, hidden.ID)
+
+	tests := []struct {
+		name      string
+		query     string
+		agentID   string
+		wantCount int
+	}{
+		{"agent a", "?agent_id=agent-a", "agent-a", 2},
+		{"agent b", "?agent_id=agent-b&sort=new", "agent-b", 1},
+		{"agent with limit", "?agent_id=agent-a&limit=1", "agent-a", 1},
+		{"unknown agent", "?agent_id=nobody", "nobody", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/stories"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			ts.handler.ListStories(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+			}
+
+			var resp ListStoriesResponse
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+
+			if len(resp.Stories) != tt.wantCount {
+				t.Errorf("story count = %d, want %d", len(resp.Stories), tt.wantCount)
+			}
+			for _, story := range resp.Stories {
+				if story.AgentID != tt.agentID {
+					t.Errorf("story agent = %q, want %q", story.AgentID, tt.agentID)
+				}
+			}
+		})
+	}
+}
+
+func TestTagsAPI(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+
+	ctx := context.Background()
+	ts.store.CreateStory(ctx, &store.Story{Title: "Tagged story", Text: "One", Tags: []string{"Go", "news"}})
+	ts.store.CreateStory(ctx, &store.Story{Title: "Another one", Text: "Two", Tags: []string{"go"}})
+	hidden := &store.Story{Title: "Hidden story", Text: "Three", Tags: []string{"go", "hidden"}}
+	ts.store.CreateStory(ctx, hidden)
+	ts.store.HideStory(ctx, hidden.ID)
+
+	t.Run("counts", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/tags", nil)
+		rec := httptest.NewRecorder()
+		ts.handler.ListTags(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+		}
+		var resp ListTagsResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		want := []store.TagCount{{Tag: "go", Count: 2}, {Tag: "news", Count: 1}}
+		if fmt.Sprint(resp.Tags) != fmt.Sprint(want) {
+			t.Errorf("tags = %v, want %v", resp.Tags, want)
+		}
+	})
+
+	for _, tt := range []struct {
+		query     string
+		wantCount int
+	}{
+		{"?tag=go", 2},
+		{"?tag=NEWS&sort=new", 1},
+		{"?tag=hidden", 0},
+		{"?tag=", 2},
+	} {
+		t.Run("list "+tt.query, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/stories"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			ts.handler.ListStories(rec, req)
+
+			var resp ListStoriesResponse
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			if len(resp.Stories) != tt.wantCount {
+				t.Errorf("story count = %d, want %d", len(resp.Stories), tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestStoryTypeAPI(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+
+	ctx := context.Background()
+	ts.store.CreateStory(ctx, &store.Story{Title: "Link story", URL: "https://example.com/typed"})
+	ts.store.CreateStory(ctx, &store.Story{Title: "Text story", Text: "No link here"})
+
+	for _, tt := range []struct {
+		query string
+		want  map[string]store.StoryType
+	}{
+		{"", map[string]store.StoryType{"Link story": store.StoryLink, "Text story": store.StoryText}},
+		{"?type=link", map[string]store.StoryType{"Link story": store.StoryLink}},
+		{"?type=text&sort=new", map[string]store.StoryType{"Text story": store.StoryText}},
+	} {
+		t.Run("list "+tt.query, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/stories"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			ts.handler.ListStories(rec, req)
+
+			var resp struct {
+				Stories []struct {
+					Title string          `json:"title"`
+					Type  store.StoryType `json:"type"`
+				} `json:"stories"`
+				Total int `json:"total"`
+			}
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			got := make(map[string]store.StoryType)
+			for _, story := range resp.Stories {
+				got[story.Title] = story.Type
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) || resp.Total != len(tt.want) {
+				t.Errorf("stories = %v (total %d), want %v", got, resp.Total, tt.want)
+			}
 		})
 	}
+
+	t.Run("unknown type", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/stories?type=video", nil)
+		rec := httptest.NewRecorder()
+		ts.handler.ListStories(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+		}
+	})
 }
 
 func TestGetStoryAPI(t *testing.T) {
@@ -384,170 +950,3630 @@ func TestCreateCommentAPI(t *testing.T) {
 	}
 }
 
-func TestVoteAPI(t *testing.T) {
+func TestCommentDepthLimitAPI(t *testing.T) {
 	ts := setupTestServer(t)
 	defer ts.cleanup()
 
-	// Create a story
+	ctx := context.Background()
 	story := &store.Story{Title: "Test Story", Text: "Content"}
-	ts.store.CreateStory(context.Background(), story)
+	ts.store.CreateStory(ctx, story)
 
-	t.Run("upvote story", func(t *testing.T) {
-		body, _ := json.Marshal(map[string]any{
-			"target_type": "story",
-			"target_id":   story.ID,
+	reply := func(parentID string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]any{"story_id": story.ID, "parent_id": parentID, "text": "Reply"})
+		req := httptest.NewRequest(http.MethodPost, "/api/comments", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		ts.handler.CreateComment(rec, req)
+		return rec
+	}
+
+	// Build a chain down to depth 7 through the API
+	parentID := ""
+	for depth := 0; depth < 8; depth++ {
+		rec := reply(parentID)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("depth %d: status = %d; body = %s", depth, rec.Code, rec.Body.String())
+		}
+		var resp CreateCommentResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		parentID = resp.ID
+	}
+
+	t.Run("reply at max depth", func(t *testing.T) {
+		rec := reply(parentID)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("status = %d, want %d; body = %s", rec.Code, http.StatusCreated, rec.Body.String())
+		}
+		var resp CreateCommentResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		comment, _ := ts.store.GetComment(ctx, resp.ID)
+		if comment.Depth != 8 {
+			t.Errorf("depth = %d, want 8", comment.Depth)
+		}
+		parentID = resp.ID
+	})
+
+	t.Run("reply past max depth", func(t *testing.T) {
+		rec := reply(parentID)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d; body = %s", rec.Code, http.StatusBadRequest, rec.Body.String())
+		}
+	})
+
+	t.Run("limit disabled", func(t *testing.T) {
+		ts.handler.cfg.MaxCommentDepth = 0
+		if rec := reply(parentID); rec.Code != http.StatusCreated {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusCreated)
+		}
+	})
+}
+
+func TestTextLengthLimitsAPI(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+	ts.handler.cfg.MaxTextLength = 20
+	ts.handler.cfg.MaxCommentLength = 10
+
+	ctx := context.Background()
+	story := &store.Story{Title: "Test Story", Text: "Content"}
+	ts.store.CreateStory(ctx, story)
+
+	post := func(handler http.HandlerFunc, path string, body map[string]any) *httptest.ResponseRecorder {
+		raw, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(raw))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		handler(rec, withAgent(req, "length-agent"))
+		return rec
+	}
+
+	// Multi-byte runes count once each, as the title check counts them
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		path    string
+		body    map[string]any
+		want    int
+	}{
+		{"story text at max", ts.handler.CreateStory, "/api/stories",
+			map[string]any{"title": "Text At The Limit", "text": strings.Repeat("é", 20)}, http.StatusCreated},
+		{"story text over max", ts.handler.CreateStory, "/api/stories",
+			map[string]any{"title": "Text Over The Limit", "text": strings.Repeat("ü", 21)}, http.StatusBadRequest},
+		{"initial comment over max", ts.handler.CreateStory, "/api/stories",
+			map[string]any{"title": "Comment Over The Limit", "text": "Body", "initial_comment": strings.Repeat("ü", 11)}, http.StatusBadRequest},
+		{"comment at max", ts.handler.CreateComment, "/api/comments",
+			map[string]any{"story_id": story.ID, "text": strings.Repeat("é", 10)}, http.StatusCreated},
+		{"comment over max", ts.handler.CreateComment, "/api/comments",
+			map[string]any{"story_id": story.ID, "text": strings.Repeat("é", 11)}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := post(tt.handler, tt.path, tt.body)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d; body = %s", rec.Code, tt.want, rec.Body.String())
+			}
+			if tt.want == http.StatusBadRequest && !strings.Contains(rec.Body.String(), "must be at most") {
+				t.Errorf("error = %s, want it to name the limit", rec.Body.String())
+			}
+		})
+	}
+
+	t.Run("limits disabled", func(t *testing.T) {
+		ts.handler.cfg.MaxCommentLength = 0
+		rec := post(ts.handler.CreateComment, "/api/comments", map[string]any{"story_id": story.ID, "text": strings.Repeat("é", 11)})
+		if rec.Code != http.StatusCreated {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusCreated)
+		}
+	})
+}
+
+func TestListCommentsPaginationAPI(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+
+	ctx := context.Background()
+	story := &store.Story{Title: "Test Story", Text: "Content"}
+	ts.store.CreateStory(ctx, story)
+
+	// Later comments also score higher, so both orders yield e, d, c, b, a
+	base := time.Now().UTC().Add(-time.Hour)
+	for i := 0; i < 5; i++ {
+		ts.store.CreateComment(ctx, &store.Comment{
+			StoryID:   story.ID,
+			Text:      string(rune('a' + i)),
+			Score:     i,
+			CreatedAt: base.Add(time.Duration(i) * time.Minute),
+		})
+	}
+
+	for _, sort := range []string{"new", "top"} {
+		t.Run(sort, func(t *testing.T) {
+			var texts string
+			cursor := ""
+			pages := 0
+			for {
+				url := "/api/stories/" + story.ID + "/comments?view=flat&limit=2&sort=" + sort
+				if cursor != "" {
+					url += "&cursor=" + cursor
+				}
+				req := httptest.NewRequest(http.MethodGet, url, nil)
+				req.SetPathValue("id", story.ID)
+				rec := httptest.NewRecorder()
+				ts.handler.ListComments(rec, req)
+
+				if rec.Code != http.StatusOK {
+					t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+				}
+				var resp ListCommentsResponse
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				if len(resp.Comments) > 2 {
+					t.Fatalf("page has %d comments, want at most 2", len(resp.Comments))
+				}
+				for _, c := range resp.Comments {
+					texts += c.Text
+				}
+				if resp.Total == nil || *resp.Total != 5 {
+					t.Errorf("total = %v, want 5 on every page", resp.Total)
+				}
+
+				pages++
+				if resp.NextCursor == "" || pages > 5 {
+					break
+				}
+				cursor = resp.NextCursor
+			}
+
+			if pages != 3 {
+				t.Errorf("pages = %d, want 3", pages)
+			}
+			if texts != "edcba" {
+				t.Errorf("comments = %q, want %q", texts, "edcba")
+			}
+		})
+	}
+
+	t.Run("tree view capped", func(t *testing.T) {
+		ts.handler.cfg.MaxTreeComments = 3
+		req := httptest.NewRequest(http.MethodGet, "/api/stories/"+story.ID+"/comments", nil)
+		req.SetPathValue("id", story.ID)
+		rec := httptest.NewRecorder()
+		ts.handler.ListComments(rec, req)
+
+		var resp ListCommentsResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		if len(resp.Comments) != 3 {
+			t.Errorf("tree comments = %d, want 3 (capped)", len(resp.Comments))
+		}
+		// The total still counts what the cap left out
+		if resp.Total == nil || *resp.Total != 5 {
+			t.Errorf("total = %v, want 5", resp.Total)
+		}
+		if resp.NextCursor == "" {
+			t.Error("capped tree view has no next_cursor")
+		}
+	})
+
+	t.Run("tree view cap keeps threads whole", func(t *testing.T) {
+		ts.handler.cfg.MaxTreeComments = 3
+
+		// e (the top thread) gets two replies, filling the cap on its own
+		top, _, _ := ts.store.ListComments(ctx, story.ID, store.CommentListOptions{Sort: store.SortTop, View: store.ViewFlat, Limit: 1})
+		e := top[0]
+		for _, text := range []string{"e1", "e2"} {
+			ts.store.CreateComment(ctx, &store.Comment{StoryID: story.ID, ParentID: e.ID, Text: text, CreatedAt: base})
+		}
+
+		var roots []string
+		cursor := ""
+		for pages := 0; pages < 10; pages++ {
+			url := "/api/stories/" + story.ID + "/comments?sort=top"
+			if cursor != "" {
+				url += "&cursor=" + cursor
+			}
+			req := httptest.NewRequest(http.MethodGet, url, nil)
+			req.SetPathValue("id", story.ID)
+			rec := httptest.NewRecorder()
+			ts.handler.ListComments(rec, req)
+
+			var resp ListCommentsResponse
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			for _, c := range resp.Comments {
+				if c.Text == "e" && len(c.Children) != 2 {
+					t.Errorf("thread e has %d replies, want both", len(c.Children))
+				}
+				roots = append(roots, c.Text)
+			}
+			if resp.NextCursor == "" {
+				break
+			}
+			cursor = resp.NextCursor
+		}
+		if got := strings.Join(roots, ","); got != "e,d,c,b,a" {
+			t.Errorf("threads across pages = %s, want e,d,c,b,a", got)
+		}
+	})
+}
+
+func TestListCommentsConfiguredDefaults(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+	ctx := context.Background()
+
+	now := time.Now().UTC()
+	story := &store.Story{Title: "Test Story", Text: "Content"}
+	ts.store.CreateStory(ctx, story)
+	popular := &store.Comment{StoryID: story.ID, Text: "Popular", Score: 5, CreatedAt: now.Add(-time.Hour)}
+	ts.store.CreateComment(ctx, popular)
+	ts.store.CreateComment(ctx, &store.Comment{StoryID: story.ID, ParentID: popular.ID, Text: "Reply", CreatedAt: now.Add(-30 * time.Minute)})
+	ts.store.CreateComment(ctx, &store.Comment{StoryID: story.ID, Text: "Newest", CreatedAt: now})
+
+	list := func(query string) []*store.Comment {
+		req := httptest.NewRequest(http.MethodGet, "/api/stories/"+story.ID+"/comments"+query, nil)
+		req.SetPathValue("id", story.ID)
+		rec := httptest.NewRecorder()
+		ts.handler.ListComments(rec, req)
+
+		var resp ListCommentsResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return resp.Comments
+	}
+
+	if comments := list(""); len(comments) != 2 || comments[0].Text != "Popular" || len(comments[0].Children) != 1 {
+		t.Errorf("unconfigured default = %+v, want threaded best-first", comments)
+	}
+
+	ts.handler.cfg.APICommentSort = "new"
+	ts.handler.cfg.APICommentView = "flat"
+	if comments := list(""); len(comments) != 3 || comments[0].Text != "Newest" || comments[1].Text != "Reply" {
+		t.Errorf("configured default = %+v, want flat newest-first", comments)
+	}
+
+	// Explicit params still win over the configured default
+	if comments := list("?sort=top&view=tree"); len(comments) != 2 || comments[0].Text != "Popular" {
+		t.Errorf("explicit params = %+v, want threaded best-first", comments)
+	}
+}
+
+func TestListCommentsSorts(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+	ctx := context.Background()
+
+	now := time.Now().UTC()
+	story := &store.Story{Title: "Test Story", Text: "Content"}
+	ts.store.CreateStory(ctx, story)
+	parent := &store.Comment{StoryID: story.ID, Text: "Parent", CreatedAt: now.Add(-2 * time.Hour)}
+	ts.store.CreateComment(ctx, parent)
+
+	// Oldest is unopposed, Split is evenly divided, Newest has no votes
+	oldest := &store.Comment{StoryID: story.ID, ParentID: parent.ID, Text: "Oldest", CreatedAt: now.Add(-time.Hour)}
+	split := &store.Comment{StoryID: story.ID, ParentID: parent.ID, Text: "Split", CreatedAt: now.Add(-30 * time.Minute)}
+	newest := &store.Comment{StoryID: story.ID, ParentID: parent.ID, Text: "Newest", CreatedAt: now}
+	for _, c := range []*store.Comment{oldest, split, newest} {
+		ts.store.CreateComment(ctx, c)
+	}
+	ts.store.CastVote(ctx, &store.Vote{TargetType: "comment", TargetID: oldest.ID, Value: 1, AgentID: "a"})
+	ts.store.CastVote(ctx, &store.Vote{TargetType: "comment", TargetID: oldest.ID, Value: 1, AgentID: "b"})
+	ts.store.CastVote(ctx, &store.Vote{TargetType: "comment", TargetID: split.ID, Value: 1, AgentID: "a"})
+	ts.store.CastVote(ctx, &store.Vote{TargetType: "comment", TargetID: split.ID, Value: -1, AgentID: "b"})
+
+	texts := func(handler http.HandlerFunc, id, query string) string {
+		req := httptest.NewRequest(http.MethodGet, "/?"+query, nil)
+		req.SetPathValue("id", id)
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+
+		var resp ListCommentsResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		var names []string
+		for _, c := range resp.Comments {
+			names = append(names, c.Text)
+		}
+		return strings.Join(names, ",")
+	}
+
+	tests := []struct {
+		sort        string
+		wantReplies string
+		wantFlat    string
+	}{
+		{"old", "Oldest,Split,Newest", "Parent,Oldest,Split,Newest"},
+		{"new", "Newest,Split,Oldest", "Newest,Split,Oldest,Parent"},
+		{"controversial", "Split,Oldest,Newest", "Split,Oldest,Parent,Newest"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sort, func(t *testing.T) {
+			if got := texts(ts.handler.ListReplies, parent.ID, "sort="+tt.sort); got != tt.wantReplies {
+				t.Errorf("replies = %s, want %s", got, tt.wantReplies)
+			}
+			if got := texts(ts.handler.ListComments, story.ID, "view=flat&sort="+tt.sort); got != tt.wantFlat {
+				t.Errorf("story comments = %s, want %s", got, tt.wantFlat)
+			}
+		})
+	}
+}
+
+func TestListCommentsHTMLFormat(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+
+	ctx := context.Background()
+	story := &store.Story{Title: "Test Story", Text: "Content"}
+	ts.store.CreateStory(ctx, story)
+	parent := &store.Comment{StoryID: story.ID, Text: "Some **bold** words"}
+	ts.store.CreateComment(ctx, parent)
+	ts.store.CreateComment(ctx, &store.Comment{StoryID: story.ID, ParentID: parent.ID, Text: "<script>alert(1)</script>[x](javascript:alert(2))"})
+
+	list := func(query string) ListCommentsResponse {
+		req := httptest.NewRequest(http.MethodGet, "/api/stories/"+story.ID+"/comments"+query, nil)
+		req.SetPathValue("id", story.ID)
+		rec := httptest.NewRecorder()
+		ts.handler.ListComments(rec, req)
+
+		var resp ListCommentsResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return resp
+	}
+
+	resp := list("")
+	if len(resp.Comments) != 1 || resp.Comments[0].TextHTML != "" {
+		t.Fatalf("comments without format=html should not carry text_html: %+v", resp.Comments)
+	}
+
+	resp = list("?format=html")
+	if len(resp.Comments) != 1 || len(resp.Comments[0].Children) != 1 {
+		t.Fatalf("unexpected tree: %+v", resp.Comments)
+	}
+	root := resp.Comments[0]
+	if root.Text != "Some **bold** words" {
+		t.Errorf("raw text = %q, want it unchanged", root.Text)
+	}
+	if !strings.Contains(root.TextHTML, "<strong>bold</strong>") {
+		t.Errorf("text_html = %q, want rendered Markdown", root.TextHTML)
+	}
+
+	reply := root.Children[0].TextHTML
+	if reply == "" || strings.Contains(reply, "<script") || strings.Contains(reply, "javascript:") {
+		t.Errorf("reply text_html = %q, want it rendered and sanitized", reply)
+	}
+}
+
+func TestPreviewAPI(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+
+	preview := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/preview", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		ts.handler.Preview(rec, req)
+		return rec
+	}
+
+	t.Run("matches posted rendering", func(t *testing.T) {
+		text := "Some **bold** words\n\n<script>alert(1)</script>[x](javascript:alert(2)) <img src=x onerror=alert(3)>"
+
+		ctx := context.Background()
+		story := &store.Story{Title: "Preview story", Text: "Content"}
+		ts.store.CreateStory(ctx, story)
+		ts.store.CreateComment(ctx, &store.Comment{StoryID: story.ID, Text: text})
+
+		req := httptest.NewRequest(http.MethodGet, "/api/stories/"+story.ID+"/comments?format=html", nil)
+		req.SetPathValue("id", story.ID)
+		rec := httptest.NewRecorder()
+		ts.handler.ListComments(rec, req)
+		var comments ListCommentsResponse
+		json.Unmarshal(rec.Body.Bytes(), &comments)
+		if len(comments.Comments) != 1 {
+			t.Fatalf("comments = %+v", comments.Comments)
+		}
+
+		body, _ := json.Marshal(PreviewRequest{Text: text})
+		rec = preview(string(body))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d; body = %s", rec.Code, rec.Body.String())
+		}
+		var resp PreviewResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+
+		if resp.HTML != comments.Comments[0].TextHTML {
+			t.Errorf("preview = %q, posted comment renders %q", resp.HTML, comments.Comments[0].TextHTML)
+		}
+		if !strings.Contains(resp.HTML, "<strong>bold</strong>") {
+			t.Errorf("preview = %q, want rendered Markdown", resp.HTML)
+		}
+		for _, unsafe := range []string{"<script", "javascript:", "onerror"} {
+			if strings.Contains(resp.HTML, unsafe) {
+				t.Errorf("preview = %q, want %q stripped", resp.HTML, unsafe)
+			}
+		}
+	})
+
+	t.Run("empty text", func(t *testing.T) {
+		if rec := preview(`{"text":""}`); rec.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("invalid JSON", func(t *testing.T) {
+		if rec := preview(`{"text":1}`); rec.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+		}
+	})
+}
+
+func TestListRepliesAPI(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+
+	ctx := context.Background()
+	story := &store.Story{Title: "Test Story", Text: "Content"}
+	ts.store.CreateStory(ctx, story)
+
+	parent := &store.Comment{StoryID: story.ID, Text: "Parent"}
+	ts.store.CreateComment(ctx, parent)
+	sibling := &store.Comment{StoryID: story.ID, Text: "Sibling"}
+	ts.store.CreateComment(ctx, sibling)
+	ts.store.CreateComment(ctx, &store.Comment{StoryID: story.ID, ParentID: sibling.ID, Text: "Not mine"})
+
+	base := time.Now().UTC().Add(-time.Hour)
+	for i := 0; i < 3; i++ {
+		ts.store.CreateComment(ctx, &store.Comment{
+			StoryID:   story.ID,
+			ParentID:  parent.ID,
+			Text:      string(rune('a' + i)),
+			CreatedAt: base.Add(time.Duration(i) * time.Minute),
+		})
+	}
+
+	list := func(id, query string) (int, ListCommentsResponse) {
+		req := httptest.NewRequest(http.MethodGet, "/api/comments/"+id+"/replies"+query, nil)
+		req.SetPathValue("id", id)
+		rec := httptest.NewRecorder()
+		ts.handler.ListReplies(rec, req)
+
+		var resp ListCommentsResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp
+	}
+
+	t.Run("only the parent's replies, paginated", func(t *testing.T) {
+		var texts string
+		cursor := ""
+		for page := 0; page < 5; page++ {
+			query := "?sort=new&limit=2"
+			if cursor != "" {
+				query += "&cursor=" + cursor
+			}
+			code, resp := list(parent.ID, query)
+			if code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", code, http.StatusOK)
+			}
+			for _, c := range resp.Comments {
+				if c.ParentID != parent.ID {
+					t.Errorf("reply %q has parent %q, want %q", c.Text, c.ParentID, parent.ID)
+				}
+				texts += c.Text
+			}
+			if resp.NextCursor == "" {
+				break
+			}
+			cursor = resp.NextCursor
+		}
+		if texts != "cba" {
+			t.Errorf("replies = %q, want %q", texts, "cba")
+		}
+	})
+
+	t.Run("unknown comment", func(t *testing.T) {
+		if code, _ := list("nonexistent", ""); code != http.StatusNotFound {
+			t.Errorf("status = %d, want %d", code, http.StatusNotFound)
+		}
+	})
+}
+
+// withAgent simulates RequireAuth by attaching a verified agent to the request
+func withAgent(req *http.Request, agentID string) *http.Request {
+	ctx := context.WithValue(req.Context(), ContextKeyAgentID, agentID)
+	ctx = context.WithValue(ctx, ContextKeyVerified, true)
+	return req.WithContext(ctx)
+}
+
+func TestUpdateCommentAPI(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+	ts.handler.cfg.EditWindow = 15 * time.Minute
+
+	ctx := context.Background()
+	story := &store.Story{Title: "Test Story", Text: "Content"}
+	ts.store.CreateStory(ctx, story)
+
+	fresh := &store.Comment{StoryID: story.ID, Text: "Original", AgentID: "author"}
+	ts.store.CreateComment(ctx, fresh)
+	stale := &store.Comment{StoryID: story.ID, Text: "Old", AgentID: "author", CreatedAt: time.Now().UTC().Add(-time.Hour)}
+	ts.store.CreateComment(ctx, stale)
+
+	tests := []struct {
+		name       string
+		commentID  string
+		agentID    string
+		wantStatus int
+	}{
+		{name: "owner within window", commentID: fresh.ID, agentID: "author", wantStatus: http.StatusOK},
+		{name: "non-owner", commentID: fresh.ID, agentID: "someone-else", wantStatus: http.StatusForbidden},
+		{name: "owner outside window", commentID: stale.ID, agentID: "author", wantStatus: http.StatusConflict},
+		{name: "non-existent comment", commentID: "nonexistent", agentID: "author", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(map[string]any{"text": "Edited text"})
+			req := httptest.NewRequest(http.MethodPatch, "/api/comments/"+tt.commentID, bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			req.SetPathValue("id", tt.commentID)
+			req = withAgent(req, tt.agentID)
+
+			rec := httptest.NewRecorder()
+			ts.handler.UpdateComment(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d; body = %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+		})
+	}
+
+	updated, _ := ts.store.GetComment(ctx, fresh.ID)
+	if updated.Text != "Edited text" {
+		t.Errorf("text = %q, want %q", updated.Text, "Edited text")
+	}
+	if updated.EditedAt == nil {
+		t.Error("edited_at should be set after edit")
+	}
+
+	untouched, _ := ts.store.GetComment(ctx, stale.ID)
+	if untouched.Text != "Old" || untouched.EditedAt != nil {
+		t.Error("comment outside edit window should be unchanged")
+	}
+}
+
+func TestDeleteStoryAPI(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+
+	ctx := context.Background()
+	story := &store.Story{Title: "Test Story", Text: "Content", AgentID: "author"}
+	ts.store.CreateStory(ctx, story)
+
+	tests := []struct {
+		name       string
+		storyID    string
+		agentID    string
+		wantStatus int
+	}{
+		{name: "non-owner", storyID: story.ID, agentID: "someone-else", wantStatus: http.StatusForbidden},
+		{name: "unauthenticated", storyID: story.ID, wantStatus: http.StatusUnauthorized},
+		{name: "non-existent story", storyID: "nonexistent", agentID: "author", wantStatus: http.StatusNotFound},
+		{name: "owner", storyID: story.ID, agentID: "author", wantStatus: http.StatusOK},
+		{name: "already deleted", storyID: story.ID, agentID: "author", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodDelete, "/api/stories/"+tt.storyID, nil)
+			req.SetPathValue("id", tt.storyID)
+			if tt.agentID != "" {
+				req = withAgent(req, tt.agentID)
+			}
+
+			rec := httptest.NewRecorder()
+			if tt.agentID == "" {
+				ts.handler.RequireAuth(ts.handler.DeleteStory)(rec, req)
+			} else {
+				ts.handler.DeleteStory(rec, req)
+			}
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d; body = %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+		})
+	}
+
+	stories, _, _ := ts.store.ListStories(ctx, store.ListOptions{Sort: store.SortNew, Limit: 30})
+	if len(stories) != 0 {
+		t.Errorf("deleted story should not be listed, got %d stories", len(stories))
+	}
+}
+
+func TestDeleteCommentAPI(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+	ts.handler.cfg.ScrubDeleted = true
+
+	ctx := context.Background()
+	story := &store.Story{Title: "Test Story", Text: "Content"}
+	ts.store.CreateStory(ctx, story)
+	comment := &store.Comment{StoryID: story.ID, Text: "Regrettable", AgentID: "author", AgentVerified: true}
+	ts.store.CreateComment(ctx, comment)
+	reply := &store.Comment{StoryID: story.ID, ParentID: comment.ID, Text: "A reply", AgentID: "replier"}
+	ts.store.CreateComment(ctx, reply)
+
+	tests := []struct {
+		name       string
+		commentID  string
+		agentID    string
+		wantStatus int
+	}{
+		{name: "non-owner", commentID: comment.ID, agentID: "someone-else", wantStatus: http.StatusForbidden},
+		{name: "unauthenticated", commentID: comment.ID, wantStatus: http.StatusUnauthorized},
+		{name: "non-existent comment", commentID: "nonexistent", agentID: "author", wantStatus: http.StatusNotFound},
+		{name: "owner", commentID: comment.ID, agentID: "author", wantStatus: http.StatusOK},
+		{name: "already deleted", commentID: comment.ID, agentID: "author", wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodDelete, "/api/comments/"+tt.commentID, nil)
+			req.SetPathValue("id", tt.commentID)
+			if tt.agentID != "" {
+				req = withAgent(req, tt.agentID)
+			}
+
+			rec := httptest.NewRecorder()
+			if tt.agentID == "" {
+				ts.handler.RequireAuth(ts.handler.DeleteComment)(rec, req)
+			} else {
+				ts.handler.DeleteComment(rec, req)
+			}
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d; body = %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+		})
+	}
+
+	// The comment stays in the thread, tombstoned, and its replies keep their place
+	got, _ := ts.store.GetComment(ctx, comment.ID)
+	if got == nil {
+		t.Fatal("deleted comment should remain in its thread")
+	}
+	if got.AgentID != store.DeletedAgentID || got.AgentVerified || got.Text != store.DeletedText {
+		t.Errorf("deleted comment = %+v, want author and text tombstoned", got)
+	}
+
+	replies, _, _ := ts.store.ListReplies(ctx, comment.ID, store.CommentListOptions{Sort: store.SortNew, View: store.ViewFlat})
+	if len(replies) != 1 || replies[0].ID != reply.ID || replies[0].AgentID != "replier" {
+		t.Errorf("replies = %+v, want the original reply intact", replies)
+	}
+}
+
+func TestDeleteCommentKeepsText(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+	ts.handler.cfg.ScrubDeleted = false
+
+	ctx := context.Background()
+	story := &store.Story{Title: "Test Story", Text: "Content"}
+	ts.store.CreateStory(ctx, story)
+	comment := &store.Comment{StoryID: story.ID, Text: "Still useful", AgentID: "author"}
+	ts.store.CreateComment(ctx, comment)
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/comments/"+comment.ID, nil)
+	req.SetPathValue("id", comment.ID)
+	req = withAgent(req, "author")
+	rec := httptest.NewRecorder()
+	ts.handler.DeleteComment(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d; body = %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+
+	got, _ := ts.store.GetComment(ctx, comment.ID)
+	if got == nil || got.AgentID != store.DeletedAgentID || got.Text != "Still useful" {
+		t.Errorf("comment = %+v, want author tombstoned and text kept", got)
+	}
+}
+
+func TestVoteAPI(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+
+	// Create a story
+	story := &store.Story{Title: "Test Story", Text: "Content"}
+	ts.store.CreateStory(context.Background(), story)
+
+	t.Run("upvote story", func(t *testing.T) {
+		body, _ := json.Marshal(map[string]any{
+			"target_type": "story",
+			"target_id":   story.ID,
+			"value":       1,
+		})
+		req := httptest.NewRequest(http.MethodPost, "/api/votes", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = "192.168.1.1:12345"
+
+		rec := httptest.NewRecorder()
+		ts.handler.CreateVote(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Errorf("status = %d, want %d; body = %s", rec.Code, http.StatusOK, rec.Body.String())
+		}
+
+		// Verify score updated
+		updated, _ := ts.store.GetStory(context.Background(), story.ID)
+		if updated.Score != 1 || updated.Upvotes != 1 || updated.Downvotes != 0 {
+			t.Errorf("score, upvotes, downvotes = %d, %d, %d; want 1, 1, 0", updated.Score, updated.Upvotes, updated.Downvotes)
+		}
+	})
+
+	t.Run("change vote", func(t *testing.T) {
+		body, _ := json.Marshal(map[string]any{
+			"target_type": "story",
+			"target_id":   story.ID,
+			"value":       -1,
+		})
+		req := httptest.NewRequest(http.MethodPost, "/api/votes", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = "192.168.1.1:12345" // Same IP as before
+
+		rec := httptest.NewRecorder()
+		ts.handler.CreateVote(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusOK)
+		}
+
+		// Score should change by -2 (from +1 to -1), the upvote becoming a downvote
+		updated, _ := ts.store.GetStory(context.Background(), story.ID)
+		if updated.Score != -1 || updated.Upvotes != 0 || updated.Downvotes != 1 {
+			t.Errorf("score, upvotes, downvotes = %d, %d, %d; want -1, 0, 1", updated.Score, updated.Upvotes, updated.Downvotes)
+		}
+
+		// Clients see both counts alongside the net score
+		req = httptest.NewRequest(http.MethodGet, "/api/stories/"+story.ID, nil)
+		req.SetPathValue("id", story.ID)
+		rec = httptest.NewRecorder()
+		ts.handler.GetStory(rec, req)
+		if body := rec.Body.String(); !strings.Contains(body, `"upvotes":0,"downvotes":1`) {
+			t.Errorf("GetStory = %s, want upvotes and downvotes", body)
+		}
+	})
+
+	t.Run("invalid target_type", func(t *testing.T) {
+		body, _ := json.Marshal(map[string]any{
+			"target_type": "invalid",
+			"target_id":   story.ID,
+			"value":       1,
+		})
+		req := httptest.NewRequest(http.MethodPost, "/api/votes", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		rec := httptest.NewRecorder()
+		ts.handler.CreateVote(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("invalid value", func(t *testing.T) {
+		body, _ := json.Marshal(map[string]any{
+			"target_type": "story",
+			"target_id":   story.ID,
+			"value":       5,
+		})
+		req := httptest.NewRequest(http.MethodPost, "/api/votes", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		rec := httptest.NewRecorder()
+		ts.handler.CreateVote(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("comment on hidden story", func(t *testing.T) {
+		ctx := context.Background()
+		hidden := &store.Story{Title: "Hidden Story", Text: "Content"}
+		ts.store.CreateStory(ctx, hidden)
+		comment := &store.Comment{StoryID: hidden.ID, Text: "Still visible itself"}
+		ts.store.CreateComment(ctx, comment)
+		if err := ts.store.HideStory(ctx, hidden.ID); err != nil {
+			t.Fatalf("HideStory: %v", err)
+		}
+
+		body, _ := json.Marshal(map[string]any{
+			"target_type": "comment",
+			"target_id":   comment.ID,
+			"value":       1,
+		})
+		req := httptest.NewRequest(http.MethodPost, "/api/votes", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = "192.168.1.2:12345"
+
+		rec := httptest.NewRecorder()
+		ts.handler.CreateVote(rec, req)
+
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusNotFound)
+		}
+		if vote, _ := ts.store.GetVote(ctx, "comment", comment.ID, auth.HashIP("192.168.1.2"), ""); vote != nil {
+			t.Error("vote was recorded on a comment of a hidden story")
+		}
+	})
+}
+
+func TestConcurrentVotes(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+
+	story := &store.Story{Title: "Test Story", Text: "Content"}
+	ts.store.CreateStory(context.Background(), story)
+
+	const voters = 200
+	want := 0
+	codes := make(chan int, voters)
+	var wg sync.WaitGroup
+	for i := 0; i < voters; i++ {
+		value := 1
+		if i%3 == 0 {
+			value = -1
+		}
+		want += value
+
+		wg.Add(1)
+		go func(i, value int) {
+			defer wg.Done()
+			body, _ := json.Marshal(map[string]any{
+				"target_type": "story",
+				"target_id":   story.ID,
+				"value":       value,
+			})
+			req := httptest.NewRequest(http.MethodPost, "/api/votes", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			req.RemoteAddr = fmt.Sprintf("10.0.%d.%d:12345", i/256, i%256)
+			rec := httptest.NewRecorder()
+			ts.handler.CreateVote(rec, req)
+			codes <- rec.Code
+		}(i, value)
+	}
+	wg.Wait()
+	close(codes)
+
+	for code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("vote status = %d, want %d", code, http.StatusOK)
+		}
+	}
+
+	updated, _ := ts.store.GetStory(context.Background(), story.ID)
+	if updated.Score != want {
+		t.Errorf("score = %d, want %d", updated.Score, want)
+	}
+}
+
+func TestRetractVote(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+
+	ctx := context.Background()
+	story := &store.Story{Title: "Test Story", Text: "Content", Score: 5}
+	ts.store.CreateStory(ctx, story)
+
+	vote := func(value int) int {
+		body, _ := json.Marshal(map[string]any{
+			"target_type": "story",
+			"target_id":   story.ID,
+			"value":       value,
+		})
+		req := httptest.NewRequest(http.MethodPost, "/api/votes", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = "192.168.1.1:12345"
+		rec := httptest.NewRecorder()
+		ts.handler.CreateVote(rec, withAgent(req, "voter"))
+		return rec.Code
+	}
+
+	score := func() int {
+		updated, _ := ts.store.GetStory(ctx, story.ID)
+		return updated.Score
+	}
+	ipHash := auth.HashIP("192.168.1.1")
+
+	t.Run("retract without vote is a no-op", func(t *testing.T) {
+		if code := vote(0); code != http.StatusOK {
+			t.Errorf("status = %d, want %d", code, http.StatusOK)
+		}
+		if score() != 5 {
+			t.Errorf("score = %d, want 5", score())
+		}
+	})
+
+	t.Run("upvote then retract", func(t *testing.T) {
+		vote(1)
+		if score() != 6 {
+			t.Fatalf("score after upvote = %d, want 6", score())
+		}
+
+		if code := vote(0); code != http.StatusOK {
+			t.Errorf("status = %d, want %d", code, http.StatusOK)
+		}
+		if score() != 5 {
+			t.Errorf("score after retract = %d, want 5", score())
+		}
+		if v, _ := ts.store.GetVote(ctx, "story", story.ID, ipHash, "voter"); v != nil {
+			t.Errorf("vote row still present: %+v", v)
+		}
+	})
+
+	t.Run("downvote then retract", func(t *testing.T) {
+		vote(-1)
+		vote(0)
+		if score() != 5 {
+			t.Errorf("score after retract = %d, want 5", score())
+		}
+	})
+}
+
+func TestWeightedVotes(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+	ts.handler.cfg.VoteWeightVerified = 3
+	ts.handler.cfg.VoteWeightAnon = 1
+
+	ctx := context.Background()
+	story := &store.Story{Title: "Test Story", Text: "Content"}
+	ts.store.CreateStory(ctx, story)
+
+	vote := func(agentID string, verified bool, remoteAddr string, value int) int {
+		t.Helper()
+		body, _ := json.Marshal(map[string]any{"target_type": "story", "target_id": story.ID, "value": value})
+		req := httptest.NewRequest(http.MethodPost, "/api/votes", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = remoteAddr
+		if agentID != "" {
+			ctx := context.WithValue(req.Context(), ContextKeyAgentID, agentID)
+			req = req.WithContext(context.WithValue(ctx, ContextKeyVerified, verified))
+		}
+		rec := httptest.NewRecorder()
+		ts.handler.CreateVote(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+		}
+		var resp CreateVoteResponse
+		json.NewDecoder(rec.Body).Decode(&resp)
+		return resp.Score
+	}
+
+	if score := vote("verified-voter", true, "192.168.1.1:1", 1); score != 3 {
+		t.Errorf("score after verified upvote = %d, want 3", score)
+	}
+	if score := vote("", false, "192.168.1.2:1", -1); score != 2 {
+		t.Errorf("score after anonymous downvote = %d, want 2", score)
+	}
+	if score := vote("unverified-voter", false, "192.168.1.3:1", -1); score != 1 {
+		t.Errorf("score after unverified downvote = %d, want 1", score)
+	}
+
+	// The stored vote keeps its raw value
+	v, _ := ts.store.GetVote(ctx, "story", story.ID, auth.HashIP("192.168.1.1"), "verified-voter")
+	if v == nil || v.Value != 1 {
+		t.Errorf("stored vote = %+v, want value 1", v)
+	}
+	if score := vote("verified-voter", true, "192.168.1.1:1", 0); score != -2 {
+		t.Errorf("score after verified retract = %d, want -2", score)
+	}
+}
+
+func TestAnonymousVotes(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+
+	story := &store.Story{Title: "Test Story", Text: "Content", AgentID: "author"}
+	ts.store.CreateStory(context.Background(), story)
+
+	vote := func(agentID, remoteAddr string) int {
+		body, _ := json.Marshal(map[string]any{
+			"target_type": "story",
+			"target_id":   story.ID,
 			"value":       1,
 		})
 		req := httptest.NewRequest(http.MethodPost, "/api/votes", bytes.NewReader(body))
 		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = remoteAddr
+		if agentID != "" {
+			req = withAgent(req, agentID)
+		}
+		rec := httptest.NewRecorder()
+		ts.handler.CreateVote(rec, req)
+		return rec.Code
+	}
+
+	score := func() int {
+		updated, _ := ts.store.GetStory(context.Background(), story.ID)
+		return updated.Score
+	}
+
+	t.Run("allowed when enabled", func(t *testing.T) {
+		ts.handler.cfg.AllowAnonymousVotes = true
+		if code := vote("", "192.168.1.1:12345"); code != http.StatusOK {
+			t.Errorf("status = %d, want %d", code, http.StatusOK)
+		}
+		if score() != 1 {
+			t.Errorf("score = %d, want 1", score())
+		}
+	})
+
+	t.Run("rejected when disabled", func(t *testing.T) {
+		ts.handler.cfg.AllowAnonymousVotes = false
+		if code := vote("", "192.168.1.2:12345"); code != http.StatusUnauthorized {
+			t.Errorf("status = %d, want %d", code, http.StatusUnauthorized)
+		}
+		if score() != 1 {
+			t.Errorf("score = %d, want 1 (unchanged)", score())
+		}
+	})
+
+	t.Run("unverified agent rejected when disabled", func(t *testing.T) {
+		ts.handler.cfg.AllowAnonymousVotes = false
+		body, _ := json.Marshal(map[string]any{"target_type": "story", "target_id": story.ID, "value": 1})
+		req := httptest.NewRequest(http.MethodPost, "/api/votes", bytes.NewReader(body))
+		req.RemoteAddr = "192.168.1.3:12345"
+		ctx := context.WithValue(req.Context(), ContextKeyAgentID, "claimed-agent")
+		ctx = context.WithValue(ctx, ContextKeyVerified, false)
+		rec := httptest.NewRecorder()
+		ts.handler.CreateVote(rec, req.WithContext(ctx))
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusUnauthorized)
+		}
+	})
+
+	t.Run("authenticated votes still deduplicated", func(t *testing.T) {
+		ts.handler.cfg.AllowAnonymousVotes = false
+		vote("voter", "192.168.1.4:12345")
+		vote("voter", "192.168.1.4:12345")
+		if score() != 2 {
+			t.Errorf("score = %d, want 2", score())
+		}
+	})
+
+	t.Run("self vote still rejected", func(t *testing.T) {
+		ts.handler.cfg.AllowAnonymousVotes = false
+		if code := vote("author", "192.168.1.5:12345"); code != http.StatusForbidden {
+			t.Errorf("status = %d, want %d", code, http.StatusForbidden)
+		}
+	})
+
+	t.Run("agent and anonymous voter share an IP", func(t *testing.T) {
+		ts.handler.cfg.AllowAnonymousVotes = true
+		before := score()
+		if code := vote("shared-agent", "192.168.1.6:12345"); code != http.StatusOK {
+			t.Fatalf("agent vote status = %d", code)
+		}
+		if code := vote("", "192.168.1.6:12345"); code != http.StatusOK {
+			t.Fatalf("anonymous vote status = %d", code)
+		}
+		if score() != before+2 {
+			t.Errorf("score = %d, want %d; each voter counts once", score(), before+2)
+		}
+	})
+}
+
+func TestAnonymousVotesIgnoreAgentHeader(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+	ts.handler.cfg.AllowAnonymousVotes = true
+	ts.handler.cfg.VoteRateLimit = 5
+
+	story := &store.Story{Title: "Stuffing target", Text: "Vote for me"}
+	ts.store.CreateStory(context.Background(), story)
+
+	handler := ts.handler.OptionalAuth(ts.handler.CreateVote)
+	codes := make(map[int]int)
+	for i := 0; i < 20; i++ {
+		body, _ := json.Marshal(map[string]any{"target_type": "story", "target_id": story.ID, "value": 1})
+		req := httptest.NewRequest(http.MethodPost, "/api/votes", bytes.NewReader(body))
+		req.RemoteAddr = "192.168.7.1:12345"
+		req.Header.Set("X-Agent-Id", fmt.Sprintf("sock-%d", i))
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		codes[rec.Code]++
+	}
+
+	// One IP is one voter with one rate limit budget, whatever it claims to be
+	if updated, _ := ts.store.GetStory(context.Background(), story.ID); updated.Score != 1 {
+		t.Errorf("score = %d, want 1", updated.Score)
+	}
+	if codes[http.StatusOK] != 5 || codes[http.StatusTooManyRequests] != 15 {
+		t.Errorf("status counts = %v, want 5 OK and 15 rate limited", codes)
+	}
+}
+
+func TestUnverifiedAgentsShareIPVote(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+	ts.handler.cfg.AllowAnonymousVotes = true
+
+	story := &store.Story{Title: "Two claimed agents", Text: "One address"}
+	ts.store.CreateStory(context.Background(), story)
+
+	unverified := func(req *http.Request, agentID string) *http.Request {
+		req.RemoteAddr = "192.168.8.1:12345"
+		ctx := context.WithValue(req.Context(), ContextKeyAgentID, agentID)
+		ctx = context.WithValue(ctx, ContextKeyVerified, false)
+		return req.WithContext(ctx)
+	}
+	for _, c := range []struct {
+		agentID string
+		value   int
+	}{{"claimed-a", 1}, {"claimed-b", -1}} {
+		body, _ := json.Marshal(map[string]any{"target_type": "story", "target_id": story.ID, "value": c.value})
+		req := unverified(httptest.NewRequest(http.MethodPost, "/api/votes", bytes.NewReader(body)), c.agentID)
+		rec := httptest.NewRecorder()
+		ts.handler.CreateVote(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s vote status = %d, want %d", c.agentID, rec.Code, http.StatusOK)
+		}
+	}
+
+	// The second claimed agent changed the IP's one vote rather than adding its own
+	updated, _ := ts.store.GetStory(context.Background(), story.ID)
+	if updated.Score != -1 || updated.Upvotes != 0 || updated.Downvotes != 1 {
+		t.Errorf("score, upvotes, downvotes = %d, %d, %d; want -1, 0, 1", updated.Score, updated.Upvotes, updated.Downvotes)
+	}
+
+	// And any claimed agent on that IP sees it
+	req := unverified(httptest.NewRequest(http.MethodGet, "/api/votes?target_type=story&target_id="+story.ID, nil), "claimed-c")
+	rec := httptest.NewRecorder()
+	ts.handler.GetVoteState(rec, req)
+	var state VoteStateResponse
+	json.Unmarshal(rec.Body.Bytes(), &state)
+	if state.Value != -1 {
+		t.Errorf("vote state value = %d, want -1", state.Value)
+	}
+}
+
+func TestVoteChangeCooldown(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+	ts.handler.cfg.VoteChangeCooldown = 300 * time.Millisecond
+
+	story := &store.Story{Title: "Contested story", Text: "Flip me"}
+	ts.store.CreateStory(context.Background(), story)
+
+	vote := func(value int) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]any{"target_type": "story", "target_id": story.ID, "value": value})
+		req := httptest.NewRequest(http.MethodPost, "/api/votes", bytes.NewReader(body))
+		req = withAgent(req, "flipper")
+		rec := httptest.NewRecorder()
+		ts.handler.CreateVote(rec, req)
+		return rec
+	}
+
+	if rec := vote(1); rec.Code != http.StatusOK {
+		t.Fatalf("first vote status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	for _, value := range []int{-1, 0} {
+		rec := vote(value)
+		if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "1" {
+			t.Errorf("value %d within the cooldown: status = %d, Retry-After = %q; want %d, 1", value, rec.Code, rec.Header().Get("Retry-After"), http.StatusTooManyRequests)
+		}
+	}
+	// Repeating the same vote changes nothing, so it isn't held back
+	if rec := vote(1); rec.Code != http.StatusOK {
+		t.Errorf("repeated vote status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	time.Sleep(350 * time.Millisecond)
+	if rec := vote(-1); rec.Code != http.StatusOK {
+		t.Fatalf("change after the cooldown: status = %d, want %d; body = %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	if updated, _ := ts.store.GetStory(context.Background(), story.ID); updated.Score != -1 {
+		t.Errorf("score = %d, want -1", updated.Score)
+	}
+
+	// The change restarts the cooldown
+	if rec := vote(1); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("change right after a change: status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+}
+
+func TestVoteStateAPI(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+
+	ctx := context.Background()
+	voted := &store.Story{Title: "Voted Story", Text: "Content"}
+	ts.store.CreateStory(ctx, voted)
+	unvoted := &store.Story{Title: "Unvoted Story", Text: "Content"}
+	ts.store.CreateStory(ctx, unvoted)
+
+	body, _ := json.Marshal(map[string]any{
+		"target_type": "story",
+		"target_id":   voted.ID,
+		"value":       -1,
+	})
+	req := httptest.NewRequest(http.MethodPost, "/api/votes", bytes.NewReader(body))
+	req.RemoteAddr = "10.0.0.1:1234"
+	rec := httptest.NewRecorder()
+	ts.handler.CreateVote(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("vote status = %d; body = %s", rec.Code, rec.Body.String())
+	}
+
+	t.Run("single existing vote", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/votes?target_type=story&target_id="+voted.ID, nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		rec := httptest.NewRecorder()
+		ts.handler.GetVoteState(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+		}
+		var resp VoteStateResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		if resp.Value != -1 {
+			t.Errorf("value = %d, want -1", resp.Value)
+		}
+	})
+
+	t.Run("single no vote", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/votes?target_type=story&target_id="+unvoted.ID, nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		rec := httptest.NewRecorder()
+		ts.handler.GetVoteState(rec, req)
+
+		var resp VoteStateResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		if resp.Value != 0 {
+			t.Errorf("value = %d, want 0", resp.Value)
+		}
+	})
+
+	t.Run("single invalid target type", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/votes?target_type=bogus&target_id="+voted.ID, nil)
+		rec := httptest.NewRecorder()
+		ts.handler.GetVoteState(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		body, _ := json.Marshal(map[string]any{
+			"targets": []map[string]string{
+				{"target_type": "story", "target_id": voted.ID},
+				{"target_type": "story", "target_id": unvoted.ID},
+			},
+		})
+		req := httptest.NewRequest(http.MethodPost, "/api/votes/lookup", bytes.NewReader(body))
+		req.RemoteAddr = "10.0.0.1:1234"
+		rec := httptest.NewRecorder()
+		ts.handler.LookupVotes(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d; body = %s", rec.Code, http.StatusOK, rec.Body.String())
+		}
+		var resp LookupVotesResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		if resp.Votes[voted.ID] != -1 {
+			t.Errorf("votes[voted] = %d, want -1", resp.Votes[voted.ID])
+		}
+		if v, ok := resp.Votes[unvoted.ID]; !ok || v != 0 {
+			t.Errorf("votes[unvoted] = %d (present %v), want 0", v, ok)
+		}
+	})
+
+	t.Run("batch from another client", func(t *testing.T) {
+		body, _ := json.Marshal(map[string]any{
+			"targets": []map[string]string{{"target_type": "story", "target_id": voted.ID}},
+		})
+		req := httptest.NewRequest(http.MethodPost, "/api/votes/lookup", bytes.NewReader(body))
+		req.RemoteAddr = "10.0.0.2:1234"
+		rec := httptest.NewRecorder()
+		ts.handler.LookupVotes(rec, req)
+
+		var resp LookupVotesResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		if resp.Votes[voted.ID] != 0 {
+			t.Errorf("votes[voted] = %d, want 0 for a different client", resp.Votes[voted.ID])
+		}
+	})
+}
+
+func TestHealth(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+
+	t.Run("json", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/health", nil)
+		rec := httptest.NewRecorder()
+		ts.handler.Health(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type = %q, want application/json", ct)
+		}
+		var resp HealthResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("body is not JSON: %v", err)
+		}
+		if resp.Status != "ok" {
+			t.Errorf("status = %q, want %q", resp.Status, "ok")
+		}
+		if resp.Version != Version {
+			t.Errorf("version = %q, want %q", resp.Version, Version)
+		}
+	})
+
+	t.Run("plain", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/health?plain=1", nil)
+		rec := httptest.NewRecorder()
+		ts.handler.Health(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+		}
+		if rec.Body.String() != "ok" {
+			t.Errorf("body = %q, want %q", rec.Body.String(), "ok")
+		}
+	})
+
+	t.Run("ready", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/ready", nil)
+		rec := httptest.NewRecorder()
+		ts.handler.Ready(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+		}
+	})
+}
+
+// unmigratedStore reports its schema as incomplete
+type unmigratedStore struct {
+	store.Store
+}
+
+func (s *unmigratedStore) CheckSchema(ctx context.Context) error {
+	return errors.New("table admin_tokens: no such table")
+}
+
+func TestHealthUnavailable(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+
+	get := func(handler http.HandlerFunc, target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+
+	// A reachable but unmigrated database is healthy, just not ready
+	ts.handler.store = &unmigratedStore{Store: ts.store}
+	if rec := get(ts.handler.Health, "/health"); rec.Code != http.StatusOK {
+		t.Errorf("health with missing tables = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec := get(ts.handler.Ready, "/ready"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("ready with missing tables = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+
+	// A closed store is neither
+	ts.handler.store = ts.store
+	ts.store.Close()
+	for _, tc := range []struct {
+		name    string
+		handler http.HandlerFunc
+		target  string
+	}{
+		{"health", ts.handler.Health, "/health"},
+		{"ready", ts.handler.Ready, "/ready"},
+	} {
+		rec := get(tc.handler, tc.target)
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Fatalf("%s status = %d, want %d", tc.name, rec.Code, http.StatusServiceUnavailable)
+		}
+		if body := strings.TrimSpace(rec.Body.String()); body != `{"status":"unhealthy"}` {
+			t.Errorf("%s body = %s, want {\"status\":\"unhealthy\"}", tc.name, body)
+		}
+	}
+
+	rec := get(ts.handler.Health, "/health?plain=1")
+	if rec.Code != http.StatusServiceUnavailable || rec.Body.String() != "unhealthy" {
+		t.Errorf("plain health = %d %q, want %d \"unhealthy\"", rec.Code, rec.Body.String(), http.StatusServiceUnavailable)
+	}
+}
+
+func TestSchemaAPI(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+
+	tests := []struct {
+		resource string
+		good     string
+		bad      []string
+	}{
+		{
+			resource: "story",
+			good:     `{"title":"A valid story title","url":"https://example.com","tags":["go"]}`,
+			bad: []string{
+				`{"title":"short","text":"Body"}`,
+				`{"title":"A valid story title"}`,
+				`{"title":"A valid story title","url":"https://example.com","text":"Body"}`,
+				`{"title":"A valid story title","text":"Body","tags":["a","b","c","d","e","f"]}`,
+			},
+		},
+		{
+			resource: "comment",
+			good:     `{"story_id":"s1","parent_id":"c1","text":"Nice"}`,
+			bad:      []string{`{"story_id":"s1"}`, `{"story_id":"s1","text":""}`},
+		},
+		{
+			resource: "vote",
+			good:     `{"target_type":"story","target_id":"s1","value":0}`,
+			bad: []string{
+				`{"target_type":"story","target_id":"s1","value":5}`,
+				`{"target_type":"user","target_id":"s1","value":1}`,
+			},
+		},
+		{
+			resource: "challenge",
+			good:     `{"agent_id":"agent","alg":"ed25519"}`,
+			bad:      []string{`{"agent_id":"agent","alg":"md5"}`, `{"alg":"ed25519"}`},
+		},
+		{
+			resource: "verify",
+			good:     `{"agent_id":"agent","alg":"ed25519","public_key":"pk","challenge":"c","signature":"sig"}`,
+			bad:      []string{`{"agent_id":"agent","alg":"ed25519","public_key":"pk","challenge":"c"}`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.resource, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/schema/"+tt.resource, nil)
+			req.SetPathValue("resource", tt.resource)
+			rec := httptest.NewRecorder()
+			ts.handler.GetSchema(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+			}
+
+			doc, err := jsonschema.UnmarshalJSON(rec.Body)
+			if err != nil {
+				t.Fatalf("schema is not JSON: %v", err)
+			}
+			compiler := jsonschema.NewCompiler()
+			if err := compiler.AddResource(tt.resource+".json", doc); err != nil {
+				t.Fatalf("AddResource: %v", err)
+			}
+			schema, err := compiler.Compile(tt.resource + ".json")
+			if err != nil {
+				t.Fatalf("schema does not compile: %v", err)
+			}
+
+			validate := func(body string) error {
+				inst, err := jsonschema.UnmarshalJSON(strings.NewReader(body))
+				if err != nil {
+					t.Fatalf("bad test body %s: %v", body, err)
+				}
+				return schema.Validate(inst)
+			}
+
+			if err := validate(tt.good); err != nil {
+				t.Errorf("good body rejected: %v", err)
+			}
+			for _, body := range tt.bad {
+				if validate(body) == nil {
+					t.Errorf("bad body accepted: %s", body)
+				}
+			}
+		})
+	}
+
+	t.Run("unknown resource", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/schema/nope", nil)
+		req.SetPathValue("resource", "nope")
+		rec := httptest.NewRecorder()
+		ts.handler.GetSchema(rec, req)
+
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusNotFound)
+		}
+	})
+}
+
+func TestOpenAPI(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/openapi.json", nil)
+	rec := httptest.NewRecorder()
+	ts.handler.OpenAPI(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	var doc struct {
+		OpenAPI    string                                `json:"openapi"`
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas         map[string]json.RawMessage `json:"schemas"`
+			SecuritySchemes map[string]json.RawMessage `json:"securitySchemes"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("document is not valid JSON: %v", err)
+	}
+	if doc.OpenAPI != "3.1.0" {
+		t.Errorf("openapi = %q, want 3.1.0", doc.OpenAPI)
+	}
+	for _, scheme := range []string{"bearerAuth", "adminSecret"} {
+		if _, ok := doc.Components.SecuritySchemes[scheme]; !ok {
+			t.Errorf("security scheme %q missing", scheme)
+		}
+	}
+
+	// Every JSON route registered on the server's mux must be documented
+	mainSrc, err := os.ReadFile("../../cmd/slashclaw/main.go")
+	if err != nil {
+		t.Fatalf("failed to read routes: %v", err)
+	}
+	routes := regexp.MustCompile(`mux\.HandleFunc\("(\w+) (/[^"]*)"`).FindAllStringSubmatch(string(mainSrc), -1)
+	checked := 0
+	for _, route := range routes {
+		method, path := strings.ToLower(route[1]), route[2]
+		if !strings.HasPrefix(path, "/api/") && path != "/health" && path != "/ready" {
+			continue
+		}
+		checked++
+		if _, ok := doc.Paths[path][method]; !ok {
+			t.Errorf("route %s %s is not in the OpenAPI document", route[1], path)
+		}
+	}
+	if checked < 30 {
+		t.Errorf("only found %d API routes in main.go; is the route pattern stale?", checked)
+	}
+
+	// Every component reference resolves
+	for _, ref := range regexp.MustCompile(`"#/components/schemas/(\w+)"`).FindAllStringSubmatch(rec.Body.String(), -1) {
+		if _, ok := doc.Components.Schemas[ref[1]]; !ok {
+			t.Errorf("dangling schema reference %q", ref[1])
+		}
+	}
+}
+
+func TestCapabilitiesAPI(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/capabilities", nil)
+	rec := httptest.NewRecorder()
+	ts.handler.Capabilities(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	var resp CapabilitiesResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+
+	want := auth.SupportedAlgorithms()
+	if len(resp.Algorithms) != len(want) {
+		t.Fatalf("algorithms = %v, want %v", resp.Algorithms, want)
+	}
+	for i := range want {
+		if resp.Algorithms[i] != want[i] {
+			t.Errorf("algorithms = %v, want %v", resp.Algorithms, want)
+			break
+		}
+	}
+	for _, alg := range resp.Algorithms {
+		if alg == auth.AlgSecp256k1 {
+			t.Error("secp256k1 should not be advertised until implemented")
+		}
+	}
+
+	if resp.Limits.MaxTags != ts.handler.cfg.MaxTags || resp.Limits.StoryRateLimit != ts.handler.cfg.StoryRateLimit {
+		t.Errorf("limits = %+v, want values from config", resp.Limits)
+	}
+}
+
+func TestInstanceAPI(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+	ts.handler.cfg.InstanceName = "Claw Test Instance"
+	ts.handler.cfg.BaseURL = "https://claw.example"
+
+	ctx := context.Background()
+	story := &store.Story{Title: "Counted story", Text: "Content"}
+	ts.store.CreateStory(ctx, story)
+	hidden := &store.Story{Title: "Hidden story", Text: "Other content"}
+	ts.store.CreateStory(ctx, hidden)
+	ts.store.HideStory(ctx, hidden.ID)
+	ts.store.CreateComment(ctx, &store.Comment{StoryID: story.ID, Text: "A comment"})
+
+	oldVersion := Version
+	Version = "v1.2.3-test"
+	defer func() { Version = oldVersion }()
+
+	req := httptest.NewRequest(http.MethodGet, "/.well-known/slashclaw", nil)
+	rec := httptest.NewRecorder()
+	ts.handler.Instance(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	var resp InstanceResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if resp.Name != "Claw Test Instance" || resp.BaseURL != "https://claw.example" {
+		t.Errorf("name, base_url = %q, %q; want the configured values", resp.Name, resp.BaseURL)
+	}
+	if resp.Version != "v1.2.3-test" || resp.Software != "slashclaw" {
+		t.Errorf("software, version = %q, %q; want slashclaw, v1.2.3-test", resp.Software, resp.Version)
+	}
+	if len(resp.Algorithms) != len(auth.SupportedAlgorithms()) || !resp.OpenRegistration {
+		t.Errorf("algorithms, open_registration = %v, %v", resp.Algorithms, resp.OpenRegistration)
+	}
+	if resp.Counts.Stories != 1 || resp.Counts.Comments != 1 {
+		t.Errorf("counts = %+v, want 1 visible story and 1 comment", resp.Counts)
+	}
+}
+
+func TestAdminHideAPI(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+
+	// Create a story
+	story := &store.Story{Title: "Test Story", Text: "Content"}
+	ts.store.CreateStory(context.Background(), story)
+
+	t.Run("unauthorized", func(t *testing.T) {
+		body, _ := json.Marshal(map[string]any{
+			"target_type": "story",
+			"target_id":   story.ID,
+		})
+		req := httptest.NewRequest(http.MethodPost, "/api/admin/hide", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		rec := httptest.NewRecorder()
+		ts.handler.Hide(rec, req)
+
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusUnauthorized)
+		}
+	})
+
+	t.Run("authorized", func(t *testing.T) {
+		body, _ := json.Marshal(map[string]any{
+			"target_type": "story",
+			"target_id":   story.ID,
+		})
+		req := httptest.NewRequest(http.MethodPost, "/api/admin/hide", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Admin-Secret", "test-admin-secret")
+
+		rec := httptest.NewRecorder()
+		ts.handler.Hide(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Errorf("status = %d, want %d; body = %s", rec.Code, http.StatusOK, rec.Body.String())
+		}
+
+		// Verify story is hidden
+		hidden, _ := ts.store.GetStory(context.Background(), story.ID)
+		if hidden != nil {
+			t.Error("story should be hidden")
+		}
+	})
+}
+
+func TestAdminUnhideAPI(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+	ctx := context.Background()
+
+	story := &store.Story{Title: "Test Story", Text: "Content"}
+	ts.store.CreateStory(ctx, story)
+	comment := &store.Comment{StoryID: story.ID, Text: "Hidden by mistake"}
+	ts.store.CreateComment(ctx, comment)
+
+	admin := func(handler http.HandlerFunc, targetType, targetID string, secret string) int {
+		body, _ := json.Marshal(map[string]any{"target_type": targetType, "target_id": targetID})
+		req := httptest.NewRequest(http.MethodPost, "/api/admin/unhide", bytes.NewReader(body))
+		if secret != "" {
+			req.Header.Set("X-Admin-Secret", secret)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec.Code
+	}
+
+	if code := admin(ts.handler.Unhide, "story", story.ID, "test-admin-secret"); code != http.StatusNotFound {
+		t.Errorf("unhiding a visible story = %d, want %d", code, http.StatusNotFound)
+	}
+
+	for _, tt := range []struct {
+		targetType string
+		id         string
+		visible    func() bool
+	}{
+		{"story", story.ID, func() bool { s, _ := ts.store.GetStory(ctx, story.ID); return s != nil }},
+		{"comment", comment.ID, func() bool { c, _ := ts.store.GetComment(ctx, comment.ID); return c != nil }},
+	} {
+		t.Run(tt.targetType, func(t *testing.T) {
+			if code := admin(ts.handler.Hide, tt.targetType, tt.id, "test-admin-secret"); code != http.StatusOK {
+				t.Fatalf("hide = %d, want %d", code, http.StatusOK)
+			}
+			if tt.visible() {
+				t.Fatal("target still visible after hide")
+			}
+
+			if code := admin(ts.handler.Unhide, tt.targetType, tt.id, ""); code != http.StatusUnauthorized {
+				t.Errorf("unhide without auth = %d, want %d", code, http.StatusUnauthorized)
+			}
+			if code := admin(ts.handler.Unhide, tt.targetType, tt.id, "test-admin-secret"); code != http.StatusOK {
+				t.Fatalf("unhide = %d, want %d", code, http.StatusOK)
+			}
+			if !tt.visible() {
+				t.Error("target not visible after unhide")
+			}
+		})
+	}
+
+	if code := admin(ts.handler.Unhide, "comment", "missing", "test-admin-secret"); code != http.StatusNotFound {
+		t.Errorf("unhiding a missing comment = %d, want %d", code, http.StatusNotFound)
+	}
+}
+
+func TestHideCommentUpdatesCommentCount(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+	ctx := context.Background()
+
+	story := &store.Story{Title: "Test Story", Text: "Content"}
+	ts.store.CreateStory(ctx, story)
+
+	body, _ := json.Marshal(map[string]any{"story_id": story.ID, "text": "Soon hidden"})
+	req := httptest.NewRequest(http.MethodPost, "/api/comments", bytes.NewReader(body))
+	rec := httptest.NewRecorder()
+	ts.handler.CreateComment(rec, withAgent(req, "commenter"))
+	var created CreateCommentResponse
+	json.Unmarshal(rec.Body.Bytes(), &created)
+
+	admin := func(handler http.HandlerFunc) {
+		t.Helper()
+		body, _ := json.Marshal(map[string]any{"target_type": "comment", "target_id": created.ID})
+		req := httptest.NewRequest(http.MethodPost, "/api/admin/hide", bytes.NewReader(body))
+		req.Header.Set("X-Admin-Secret", "test-admin-secret")
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+		}
+	}
+	count := func() int {
+		got, _ := ts.store.GetStory(ctx, story.ID)
+		return got.CommentCount
+	}
+
+	if got := count(); got != 1 {
+		t.Fatalf("comment count after posting = %d, want 1", got)
+	}
+	admin(ts.handler.Hide)
+	if got := count(); got != 0 {
+		t.Errorf("comment count after hiding = %d, want 0", got)
+	}
+	admin(ts.handler.Unhide)
+	if got := count(); got != 1 {
+		t.Errorf("comment count after unhiding = %d, want 1", got)
+	}
+}
+
+func TestPreModeration(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+	ts.handler.cfg.PreModerate = true
+
+	ctx := context.Background()
+
+	submit := func(title string) CreateStoryResponse {
+		t.Helper()
+		body, _ := json.Marshal(map[string]any{"title": title, "text": "Content for " + title})
+		req := httptest.NewRequest(http.MethodPost, "/api/stories", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		ts.handler.CreateStory(rec, withAgent(req, "author"))
+
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("status = %d, want %d; body = %s", rec.Code, http.StatusAccepted, rec.Body.String())
+		}
+		var resp CreateStoryResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return resp
+	}
+
+	getStory := func(id, agentID string) int {
+		req := httptest.NewRequest(http.MethodGet, "/api/stories/"+id, nil)
+		req.SetPathValue("id", id)
+		if agentID != "" {
+			req = withAgent(req, agentID)
+		}
+		rec := httptest.NewRecorder()
+		ts.handler.GetStory(rec, req)
+		return rec.Code
+	}
+
+	admin := func(handler http.HandlerFunc, method, path string, body any) *httptest.ResponseRecorder {
+		b, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewReader(b))
+		req.Header.Set("X-Admin-Secret", "test-admin-secret")
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	resp := submit("A pre-moderated story")
+	if resp.Status != "pending" {
+		t.Errorf("status = %q, want %q", resp.Status, "pending")
+	}
+
+	t.Run("hidden until approved", func(t *testing.T) {
+		if code := getStory(resp.ID, ""); code != http.StatusNotFound {
+			t.Errorf("anonymous GetStory = %d, want %d", code, http.StatusNotFound)
+		}
+		if code := getStory(resp.ID, "someone-else"); code != http.StatusNotFound {
+			t.Errorf("other agent GetStory = %d, want %d", code, http.StatusNotFound)
+		}
+		stories, _, _ := ts.store.ListStories(ctx, store.ListOptions{Limit: 10})
+		if len(stories) != 0 {
+			t.Errorf("listed stories = %d, want 0", len(stories))
+		}
+	})
+
+	t.Run("author sees pending story", func(t *testing.T) {
+		if code := getStory(resp.ID, "author"); code != http.StatusOK {
+			t.Errorf("author GetStory = %d, want %d", code, http.StatusOK)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/api/stories/pending", nil)
+		rec := httptest.NewRecorder()
+		ts.handler.ListPendingStories(rec, withAgent(req, "author"))
+		var list ListStoriesResponse
+		json.Unmarshal(rec.Body.Bytes(), &list)
+		if len(list.Stories) != 1 || !list.Stories[0].Pending {
+			t.Errorf("author pending stories = %+v, want the pending story", list.Stories)
+		}
+	})
+
+	t.Run("review queue", func(t *testing.T) {
+		rec := admin(ts.handler.ReviewQueue, http.MethodGet, "/api/admin/queue", nil)
+		var list ListStoriesResponse
+		json.Unmarshal(rec.Body.Bytes(), &list)
+		if rec.Code != http.StatusOK || len(list.Stories) != 1 || list.Stories[0].ID != resp.ID {
+			t.Errorf("queue = %d %+v, want the pending story", rec.Code, list.Stories)
+		}
+	})
+
+	t.Run("pending lists carry no total", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/stories/pending", nil)
+		pending := httptest.NewRecorder()
+		ts.handler.ListPendingStories(pending, withAgent(req, "author"))
+		queue := admin(ts.handler.ReviewQueue, http.MethodGet, "/api/admin/queue", nil)
+
+		for name, rec := range map[string]*httptest.ResponseRecorder{"pending": pending, "queue": queue} {
+			var body map[string]json.RawMessage
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("%s: decoding %s: %v", name, rec.Body.String(), err)
+			}
+			if _, ok := body["stories"]; !ok {
+				t.Errorf("%s: response has no stories: %s", name, rec.Body.String())
+			}
+			if total, ok := body["total"]; ok {
+				t.Errorf("%s: total = %s, want it omitted rather than a misleading count", name, total)
+			}
+		}
+	})
+
+	t.Run("approve requires admin", func(t *testing.T) {
+		body, _ := json.Marshal(map[string]any{"story_id": resp.ID})
+		req := httptest.NewRequest(http.MethodPost, "/api/admin/approve", bytes.NewReader(body))
+		rec := httptest.NewRecorder()
+		ts.handler.Approve(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusUnauthorized)
+		}
+	})
+
+	t.Run("approval publishes", func(t *testing.T) {
+		rec := admin(ts.handler.Approve, http.MethodPost, "/api/admin/approve", map[string]any{"story_id": resp.ID})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("approve = %d, want %d; body = %s", rec.Code, http.StatusOK, rec.Body.String())
+		}
+		if code := getStory(resp.ID, ""); code != http.StatusOK {
+			t.Errorf("anonymous GetStory after approval = %d, want %d", code, http.StatusOK)
+		}
+
+		rec = admin(ts.handler.Approve, http.MethodPost, "/api/admin/approve", map[string]any{"story_id": resp.ID})
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("second approve = %d, want %d", rec.Code, http.StatusNotFound)
+		}
+	})
+
+	t.Run("hide rejects", func(t *testing.T) {
+		ts.handler.cfg.PostCooldown = 0
+		rejected := submit("A story to be rejected")
+		rec := admin(ts.handler.Hide, http.MethodPost, "/api/admin/hide", map[string]any{"target_type": "story", "target_id": rejected.ID})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("hide = %d, want %d", rec.Code, http.StatusOK)
+		}
+		if pending, _ := ts.store.GetPendingStory(ctx, rejected.ID); pending != nil {
+			t.Error("rejected story is still pending")
+		}
+		if code := getStory(rejected.ID, "author"); code != http.StatusNotFound {
+			t.Errorf("author GetStory of rejected story = %d, want %d", code, http.StatusNotFound)
+		}
+	})
+}
+
+func TestEmptyListingsAPI(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+
+	// A story with no comments, and a hidden one holding a comment with no replies
+	story := &store.Story{Title: "Quiet Story", Text: "Content"}
+	ts.store.CreateStory(context.Background(), story)
+	hidden := &store.Story{Title: "Hidden Story", Text: "Content", Hidden: true}
+	ts.store.CreateStory(context.Background(), hidden)
+	comment := &store.Comment{StoryID: hidden.ID, Text: "Lonely"}
+	ts.store.CreateComment(context.Background(), comment)
+
+	// Listings with nothing in them are [] rather than null
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		target  string
+		id      string
+		key     string
+	}{
+		{"stories", ts.handler.ListStories, "/api/stories?tag=nothing", "", "stories"},
+		{"comments tree", ts.handler.ListComments, "/api/stories/{id}/comments", story.ID, "comments"},
+		{"comments flat", ts.handler.ListComments, "/api/stories/{id}/comments?view=flat", story.ID, "comments"},
+		{"replies", ts.handler.ListReplies, "/api/comments/{id}/replies", comment.ID, "comments"},
+		{"my stories", ts.handler.MyStories, "/api/me/stories", "", "stories"},
+		{"my comments", ts.handler.MyComments, "/api/me/comments", "", "comments"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, strings.Replace(tt.target, "{id}", tt.id, 1), nil)
+			req.SetPathValue("id", tt.id)
+			rec := httptest.NewRecorder()
+			tt.handler(rec, withAgent(req, "nobody"))
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d; body = %s", rec.Code, http.StatusOK, rec.Body.String())
+			}
+			var body map[string]json.RawMessage
+			json.Unmarshal(rec.Body.Bytes(), &body)
+			if got := string(body[tt.key]); got != "[]" {
+				t.Errorf("%s = %s, want []", tt.key, got)
+			}
+		})
+	}
+}
+
+func TestMyContentAPI(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+
+	ctx := context.Background()
+	base := time.Now().UTC().Add(-time.Hour)
+	visible := &store.Story{Title: "Visible Story", Text: "Content", AgentID: "me", CreatedAt: base}
+	hidden := &store.Story{Title: "Hidden Story", Text: "Content", AgentID: "me", CreatedAt: base.Add(time.Minute)}
+	pending := &store.Story{Title: "Pending Story", Text: "Content", AgentID: "me", Hidden: true, Pending: true, CreatedAt: base.Add(2 * time.Minute)}
+	other := &store.Story{Title: "Other Story", Text: "Content", AgentID: "other", CreatedAt: base.Add(3 * time.Minute)}
+	for _, story := range []*store.Story{visible, hidden, pending, other} {
+		ts.store.CreateStory(ctx, story)
+	}
+	ts.store.HideStory(ctx, hidden.ID)
+
+	comment := &store.Comment{StoryID: other.ID, Text: "Moderated", AgentID: "me"}
+	ts.store.CreateComment(ctx, comment)
+	ts.store.HideComment(ctx, comment.ID)
+
+	get := func(handler http.HandlerFunc, target, agentID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if agentID != "" {
+			req = withAgent(req, agentID)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	t.Run("stories", func(t *testing.T) {
+		rec := get(ts.handler.MyStories, "/api/me/stories", "me")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+		}
+		var resp MyStoriesResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+
+		want := []struct{ id, status string }{{pending.ID, "pending"}, {hidden.ID, "hidden"}, {visible.ID, "published"}}
+		if len(resp.Stories) != len(want) {
+			t.Fatalf("stories = %+v, want %d", resp.Stories, len(want))
+		}
+		for i, w := range want {
+			if resp.Stories[i].ID != w.id || resp.Stories[i].Status != w.status {
+				t.Errorf("story %d = %s %q, want %s %q", i, resp.Stories[i].ID, resp.Stories[i].Status, w.id, w.status)
+			}
+		}
+	})
+
+	t.Run("public listing omits hidden", func(t *testing.T) {
+		rec := get(ts.handler.ListStories, "/api/stories?agent_id=me", "")
+		var list ListStoriesResponse
+		json.Unmarshal(rec.Body.Bytes(), &list)
+		if len(list.Stories) != 1 || list.Stories[0].ID != visible.ID {
+			t.Errorf("public stories = %+v, want only the visible one", list.Stories)
+		}
+	})
+
+	t.Run("comments", func(t *testing.T) {
+		rec := get(ts.handler.MyComments, "/api/me/comments", "me")
+		var resp MyCommentsResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		if rec.Code != http.StatusOK || len(resp.Comments) != 1 || resp.Comments[0].ID != comment.ID || resp.Comments[0].Status != "hidden" {
+			t.Errorf("comments = %d %+v, want the hidden comment marked hidden", rec.Code, resp.Comments)
+		}
+	})
+
+	t.Run("pagination", func(t *testing.T) {
+		rec := get(ts.handler.MyStories, "/api/me/stories?limit=2", "me")
+		var resp MyStoriesResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		if len(resp.Stories) != 2 || resp.NextCursor != hidden.ID {
+			t.Fatalf("first page = %d stories, next %q; want 2, next %q", len(resp.Stories), resp.NextCursor, hidden.ID)
+		}
+		rec = get(ts.handler.MyStories, "/api/me/stories?limit=2&cursor="+resp.NextCursor, "me")
+		var page MyStoriesResponse
+		json.Unmarshal(rec.Body.Bytes(), &page)
+		if len(page.Stories) != 1 || page.Stories[0].ID != visible.ID || page.NextCursor != "" {
+			t.Errorf("second page = %d stories, next %q; want just the visible story", len(page.Stories), page.NextCursor)
+		}
+	})
+
+	t.Run("requires auth", func(t *testing.T) {
+		if rec := get(ts.handler.RequireAuth(ts.handler.MyStories), "/api/me/stories", ""); rec.Code != http.StatusUnauthorized {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusUnauthorized)
+		}
+	})
+}
+
+func TestPostCooldown(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+	ts.handler.cfg.PostCooldown = 300 * time.Millisecond
+
+	submit := func(agentID, title string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]any{"title": title, "text": "Content for " + title})
+		req := httptest.NewRequest(http.MethodPost, "/api/stories", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		ts.handler.CreateStory(rec, withAgent(req, agentID))
+		return rec
+	}
+
+	if rec := submit("poster", "First story in a row"); rec.Code != http.StatusCreated {
+		t.Fatalf("first post: status = %d, want %d; body = %s", rec.Code, http.StatusCreated, rec.Body.String())
+	}
+
+	rec := submit("poster", "Second story too soon")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("post within cooldown: status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	var resp ErrorResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if resp.RetryAfter != 1 {
+		t.Errorf("retry_after = %d, want 1 (rounded up)", resp.RetryAfter)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("Retry-After = %q, want %q", got, "1")
+	}
+
+	if rec := submit("someone-else", "Another agent's story"); rec.Code != http.StatusCreated {
+		t.Errorf("other agent: status = %d, want %d", rec.Code, http.StatusCreated)
+	}
+
+	time.Sleep(ts.handler.cfg.PostCooldown)
+	if rec := submit("poster", "Third story after waiting"); rec.Code != http.StatusCreated {
+		t.Errorf("post after cooldown: status = %d, want %d; body = %s", rec.Code, http.StatusCreated, rec.Body.String())
+	}
+}
+
+func TestCreateStoryWithInitialComment(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+
+	body, _ := json.Marshal(map[string]any{
+		"title":           "Ask: how do you test?",
+		"text":            "Curious about everyone's setup",
+		"initial_comment": "Table-driven tests, mostly",
+	})
+	req := httptest.NewRequest(http.MethodPost, "/api/stories", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	ts.handler.CreateStory(rec, withAgent(req, "asker"))
+
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want %d; body = %s", rec.Code, http.StatusCreated, rec.Body.String())
+	}
+	var resp CreateStoryResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if resp.ID == "" || resp.CommentID == "" {
+		t.Fatalf("response = %+v, want story and comment ids", resp)
+	}
+
+	ctx := context.Background()
+	story, _ := ts.store.GetStory(ctx, resp.ID)
+	if story == nil || story.CommentCount != 1 {
+		t.Errorf("story = %+v, want it stored with one comment", story)
+	}
+	comment, _ := ts.store.GetComment(ctx, resp.CommentID)
+	if comment == nil || comment.StoryID != resp.ID || comment.AgentID != "asker" {
+		t.Errorf("comment = %+v, want the author's comment on the story", comment)
+	}
+}
+
+func TestIdempotencyKeys(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+	ts.handler.cfg.IdempotencyTTL = time.Hour
+
+	post := func(handler http.HandlerFunc, path, agentID, key string, body map[string]any) *httptest.ResponseRecorder {
+		b, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(b))
+		req.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, withAgent(req, agentID))
+		return rec
+	}
+	createStory := ts.handler.Idempotent("story", ts.handler.CreateStory)
+	createComment := ts.handler.Idempotent("comment", ts.handler.CreateComment)
+
+	storyBody := map[string]any{"title": "Retried text story", "text": "Sent twice over a flaky link"}
+	first := post(createStory, "/api/stories", "retrier", "story-key-1", storyBody)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("first story: status = %d, want %d; body = %s", first.Code, http.StatusCreated, first.Body.String())
+	}
+	// Text dedup would otherwise answer the retry; turn it off so only the key can
+	ts.handler.cfg.DuplicateText = config.DuplicateTextOff
+	retry := post(createStory, "/api/stories", "retrier", "story-key-1", storyBody)
+	if retry.Code != http.StatusOK {
+		t.Fatalf("retried story: status = %d, want %d", retry.Code, http.StatusOK)
+	}
+	if retry.Body.String() != first.Body.String() {
+		t.Errorf("retried story body = %s, want %s", retry.Body.String(), first.Body.String())
+	}
+	if retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("retried story should be marked Idempotent-Replayed")
+	}
+
+	var story CreateStoryResponse
+	json.Unmarshal(first.Body.Bytes(), &story)
+	stories, _, _ := ts.store.ListStoriesByAgent(context.Background(), "retrier", store.ListOptions{})
+	if len(stories) != 1 {
+		t.Errorf("retrier has %d stories, want 1", len(stories))
+	}
+
+	commentBody := map[string]any{"story_id": story.ID, "text": "Only once please"}
+	first = post(createComment, "/api/comments", "retrier", "comment-key-1", commentBody)
+	retry = post(createComment, "/api/comments", "retrier", "comment-key-1", commentBody)
+	if first.Code != http.StatusCreated || retry.Code != http.StatusOK {
+		t.Fatalf("comment statuses = %d, %d; want %d, %d", first.Code, retry.Code, http.StatusCreated, http.StatusOK)
+	}
+	if retry.Body.String() != first.Body.String() {
+		t.Errorf("retried comment body = %s, want %s", retry.Body.String(), first.Body.String())
+	}
+	if got, _ := ts.store.GetStory(context.Background(), story.ID); got.CommentCount != 1 {
+		t.Errorf("comment count = %d, want 1", got.CommentCount)
+	}
+
+	t.Run("scoped per agent", func(t *testing.T) {
+		rec := post(createComment, "/api/comments", "someone-else", "comment-key-1", commentBody)
+		if rec.Code != http.StatusCreated {
+			t.Errorf("other agent's key: status = %d, want %d", rec.Code, http.StatusCreated)
+		}
+	})
+
+	t.Run("different request", func(t *testing.T) {
+		rec := post(createComment, "/api/comments", "retrier", "comment-key-1", map[string]any{"story_id": story.ID, "text": "Something else"})
+		if rec.Code != http.StatusUnprocessableEntity {
+			t.Errorf("reused key: status = %d, want %d", rec.Code, http.StatusUnprocessableEntity)
+		}
+	})
+
+	t.Run("failures are not saved", func(t *testing.T) {
+		bad := map[string]any{"story_id": "missing", "text": "Lost"}
+		if rec := post(createComment, "/api/comments", "retrier", "comment-key-2", bad); rec.Code != http.StatusNotFound {
+			t.Fatalf("bad comment: status = %d, want %d", rec.Code, http.StatusNotFound)
+		}
+		fixed := map[string]any{"story_id": story.ID, "text": "Lost"}
+		if rec := post(createComment, "/api/comments", "retrier", "comment-key-2", fixed); rec.Code != http.StatusCreated {
+			t.Errorf("retry after failure: status = %d, want %d", rec.Code, http.StatusCreated)
+		}
+	})
+}
+
+func TestCreateStoriesBatchAPI(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+
+	ctx := context.Background()
+	old := &store.Story{Title: "Already posted", URL: "https://example.com/old"}
+	ts.store.CreateStory(ctx, old)
+
+	submit := func(agentID string, batch any) (*httptest.ResponseRecorder, CreateStoriesBatchResponse) {
+		body, _ := json.Marshal(batch)
+		req := httptest.NewRequest(http.MethodPost, "/api/stories/batch", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		ts.handler.CreateStoriesBatch(rec, withAgent(req, agentID))
+
+		var resp CreateStoriesBatchResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec, resp
+	}
+
+	t.Run("mixed batch", func(t *testing.T) {
+		rec, resp := submit("batcher", []map[string]any{
+			{"title": "A brand new link", "url": "https://example.com/new"},
+			{"title": "The old link again", "url": "https://example.com/old"},
+			{"title": "Short"},
+			{"title": "The new link twice", "url": "https://example.com/new"},
+			{"title": "A text post here", "text": "Body text"},
+		})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d; body = %s", rec.Code, http.StatusOK, rec.Body.String())
+		}
+		if len(resp.Results) != 5 {
+			t.Fatalf("got %d results, want 5", len(resp.Results))
+		}
+
+		r := resp.Results
+		if r[0].ID == "" || r[0].Status != "published" || r[0].Existing {
+			t.Errorf("new link = %+v, want published", r[0])
+		}
+		if r[1].ID != old.ID || !r[1].Existing {
+			t.Errorf("old link = %+v, want existing %s", r[1], old.ID)
+		}
+		if r[2].Error == "" || r[2].ID != "" {
+			t.Errorf("invalid item = %+v, want an error", r[2])
+		}
+		if r[3].ID != r[0].ID || !r[3].Existing {
+			t.Errorf("repeated link = %+v, want existing %s", r[3], r[0].ID)
+		}
+		if r[4].ID == "" || r[4].Status != "published" {
+			t.Errorf("text post = %+v, want published", r[4])
+		}
+
+		if got, _ := ts.store.GetStory(ctx, r[0].ID); got == nil || got.AgentID != "batcher" {
+			t.Errorf("stored story = %+v, want it credited to the agent", got)
+		}
+	})
+
+	t.Run("size limits", func(t *testing.T) {
+		if rec, _ := submit("sizer", []map[string]any{}); rec.Code != http.StatusBadRequest {
+			t.Errorf("empty batch: status = %d, want %d", rec.Code, http.StatusBadRequest)
+		}
+
+		batch := make([]map[string]any, maxBatchStories+1)
+		for i := range batch {
+			batch[i] = map[string]any{"title": fmt.Sprintf("Oversized batch %d", i), "text": "Body"}
+		}
+		if rec, _ := submit("sizer", batch); rec.Code != http.StatusBadRequest {
+			t.Errorf("%d stories: status = %d, want %d", len(batch), rec.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("rate limit counts each story", func(t *testing.T) {
+		ts.handler.cfg.StoryRateLimit = 3
+		defer func() { ts.handler.cfg.StoryRateLimit = 100 }()
+		ts.handler.limiter = ratelimit.NewMemoryLimiter()
+
+		batch := func(prefix string, n int) []map[string]any {
+			items := make([]map[string]any, n)
+			for i := range items {
+				items[i] = map[string]any{"title": fmt.Sprintf("%s story %d", prefix, i), "text": prefix + fmt.Sprint(i)}
+			}
+			return items
+		}
+
+		rec, _ := submit("limited", batch("First", 2))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("first batch: status = %d, want %d", rec.Code, http.StatusOK)
+		}
+		if got := rec.Header().Get("X-RateLimit-Remaining"); got != "1" {
+			t.Errorf("X-RateLimit-Remaining = %q, want %q", got, "1")
+		}
+
+		// Two more don't fit in the one left, so none are stored
+		if rec, _ := submit("limited", batch("Second", 2)); rec.Code != http.StatusTooManyRequests {
+			t.Errorf("second batch: status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+		}
+		if rec, _ := submit("limited", batch("Third", 1)); rec.Code != http.StatusOK {
+			t.Errorf("batch of one that fits: status = %d, want %d", rec.Code, http.StatusOK)
+		}
+	})
+}
+
+func TestRateLimitBucketsAPI(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+
+	for i := 0; i < 2; i++ {
+		body, _ := json.Marshal(map[string]any{"title": fmt.Sprintf("Bucket story %d", i), "text": fmt.Sprint("Body ", i)})
+		req := httptest.NewRequest(http.MethodPost, "/api/stories", bytes.NewReader(body))
+		req.RemoteAddr = "10.0.0.9:1234"
+		ts.handler.CreateStory(httptest.NewRecorder(), req)
+	}
+	body, _ := json.Marshal(map[string]any{"target_type": "story", "target_id": "missing", "value": 1})
+	req := httptest.NewRequest(http.MethodPost, "/api/votes", bytes.NewReader(body))
+	req.RemoteAddr = "10.0.0.9:1234"
+	ts.handler.CreateVote(httptest.NewRecorder(), req)
+
+	list := func(query, secret string) (int, RateLimitBucketsResponse) {
+		req := httptest.NewRequest(http.MethodGet, "/api/admin/ratelimit/buckets"+query, nil)
+		if secret != "" {
+			req.Header.Set("X-Admin-Secret", secret)
+		}
+		rec := httptest.NewRecorder()
+		ts.handler.RateLimitBuckets(rec, req)
+
+		var resp RateLimitBucketsResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp
+	}
+
+	if code, _ := list("", ""); code != http.StatusUnauthorized {
+		t.Errorf("without secret: status = %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	code, resp := list("", "test-admin-secret")
+	if code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", code, http.StatusOK)
+	}
+	counts := map[string]int{}
+	for _, b := range resp.Buckets {
+		counts[b.Key] = b.Count
+		if !b.ResetAt.After(time.Now()) {
+			t.Errorf("bucket %s resets at %v, want a future time", b.Key, b.ResetAt)
+		}
+	}
+	if counts["story:10.0.0.9"] != 2 || counts["vote:10.0.0.9"] != 1 {
+		t.Errorf("buckets = %+v, want story:10.0.0.9=2 and vote:10.0.0.9=1", resp.Buckets)
+	}
+
+	if _, resp := list("?prefix=vote:", "test-admin-secret"); len(resp.Buckets) != 1 || resp.Buckets[0].Key != "vote:10.0.0.9" {
+		t.Errorf("prefix=vote: buckets = %+v, want only the vote bucket", resp.Buckets)
+	}
+}
+
+func TestAdminTokensAPI(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+
+	story := &store.Story{Title: "Flagged story", Text: "Content"}
+	ts.store.CreateStory(context.Background(), story)
+
+	issue := func(secret string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/admin/tokens", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if secret != "" {
+			req.Header.Set("X-Admin-Secret", secret)
+		}
+		rec := httptest.NewRecorder()
+		ts.handler.CreateAdminToken(rec, req)
+		return rec
+	}
+
+	rec := issue("test-admin-secret", `{"label":"flag reader","scopes":["flags:read"]}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("issue status = %d; body = %s", rec.Code, rec.Body.String())
+	}
+	var issued CreateAdminTokenResponse
+	json.Unmarshal(rec.Body.Bytes(), &issued)
+	if issued.Token == "" || issued.AdminToken == nil || issued.ID == "" || fmt.Sprint(issued.Scopes) != "[flags:read]" {
+		t.Fatalf("issued = %s", rec.Body.String())
+	}
+
+	withToken := func(req *http.Request, token string) *http.Request {
+		req.Header.Set("Authorization", "Bearer "+token)
+		return req
+	}
+	hide := func(token string) int {
+		body := fmt.Sprintf(`{"target_type":"story","target_id":%q}`, story.ID)
+		req := withToken(httptest.NewRequest(http.MethodPost, "/api/admin/hide", strings.NewReader(body)), token)
+		rec := httptest.NewRecorder()
+		ts.handler.Hide(rec, req)
+		return rec.Code
+	}
+	listFlags := func(token string) int {
+		req := withToken(httptest.NewRequest(http.MethodGet, "/api/admin/flags", nil), token)
+		rec := httptest.NewRecorder()
+		ts.handler.ListFlags(rec, req)
+		return rec.Code
+	}
+
+	t.Run("scope grants flag review", func(t *testing.T) {
+		if code := listFlags(issued.Token); code != http.StatusOK {
+			t.Errorf("flags status = %d, want %d", code, http.StatusOK)
+		}
+	})
+
+	t.Run("missing scope rejected", func(t *testing.T) {
+		if code := hide(issued.Token); code != http.StatusUnauthorized {
+			t.Errorf("hide status = %d, want %d", code, http.StatusUnauthorized)
+		}
+		if got, _ := ts.store.GetStory(context.Background(), story.ID); got == nil {
+			t.Error("story was hidden by a token without the hide scope")
+		}
+	})
+
+	t.Run("unknown token rejected", func(t *testing.T) {
+		if code := listFlags("not-an-admin-token"); code != http.StatusUnauthorized {
+			t.Errorf("flags status = %d, want %d", code, http.StatusUnauthorized)
+		}
+	})
+
+	t.Run("only the secret issues tokens", func(t *testing.T) {
+		req := withToken(httptest.NewRequest(http.MethodPost, "/api/admin/tokens", strings.NewReader(`{"scopes":["hide"]}`)), issued.Token)
+		rec := httptest.NewRecorder()
+		ts.handler.CreateAdminToken(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("token-issued token status = %d, want %d", rec.Code, http.StatusUnauthorized)
+		}
+		if rec := issue("wrong", `{"scopes":["hide"]}`); rec.Code != http.StatusUnauthorized {
+			t.Errorf("wrong secret status = %d, want %d", rec.Code, http.StatusUnauthorized)
+		}
+	})
+
+	t.Run("unknown scope", func(t *testing.T) {
+		if rec := issue("test-admin-secret", `{"scopes":["everything"]}`); rec.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("list and revoke", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/admin/tokens", nil)
+		req.Header.Set("X-Admin-Secret", "test-admin-secret")
+		rec := httptest.NewRecorder()
+		ts.handler.ListAdminTokens(rec, req)
+		if strings.Contains(rec.Body.String(), issued.Token) {
+			t.Error("listing should never include the token itself")
+		}
+		var list ListAdminTokensResponse
+		json.Unmarshal(rec.Body.Bytes(), &list)
+		if len(list.Tokens) != 1 || list.Tokens[0].ID != issued.ID || list.Tokens[0].Label != "flag reader" {
+			t.Fatalf("tokens = %s", rec.Body.String())
+		}
+
+		revoke := func() int {
+			req := httptest.NewRequest(http.MethodDelete, "/api/admin/tokens/"+issued.ID, nil)
+			req.SetPathValue("id", issued.ID)
+			req.Header.Set("X-Admin-Secret", "test-admin-secret")
+			rec := httptest.NewRecorder()
+			ts.handler.RevokeAdminToken(rec, req)
+			return rec.Code
+		}
+		if code := revoke(); code != http.StatusOK {
+			t.Fatalf("revoke status = %d", code)
+		}
+		if code := listFlags(issued.Token); code != http.StatusUnauthorized {
+			t.Errorf("revoked token flags status = %d, want %d", code, http.StatusUnauthorized)
+		}
+		if code := revoke(); code != http.StatusNotFound {
+			t.Errorf("second revoke status = %d, want %d", code, http.StatusNotFound)
+		}
+	})
+}
+
+func TestAdminRecomputeAPI(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+
+	ctx := context.Background()
+	story := &store.Story{Title: "Test Story", Text: "Content"}
+	ts.store.CreateStory(ctx, story)
+	other := &store.Story{Title: "Other Story", Text: "Content"}
+	ts.store.CreateStory(ctx, other)
+	ts.store.CastVote(ctx, &store.Vote{TargetType: "story", TargetID: story.ID, Value: 1, IPHash: "ip1"})
+	ts.store.CastVote(ctx, &store.Vote{TargetType: "story", TargetID: story.ID, Value: 1, IPHash: "ip2"})
+
+	recompute := func(body map[string]any, secret string) (int, RecomputeResponse) {
+		b, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, "/api/admin/recompute", bytes.NewReader(b))
+		req.Header.Set("Content-Type", "application/json")
+		if secret != "" {
+			req.Header.Set("X-Admin-Secret", secret)
+		}
+		rec := httptest.NewRecorder()
+		ts.handler.Recompute(rec, req)
+
+		var resp RecomputeResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp
+	}
+	score := func(id string) int {
+		got, _ := ts.store.GetStory(ctx, id)
+		return got.Score
+	}
+
+	t.Run("unauthorized", func(t *testing.T) {
+		if code, _ := recompute(map[string]any{}, ""); code != http.StatusUnauthorized {
+			t.Errorf("status = %d, want %d", code, http.StatusUnauthorized)
+		}
+	})
+
+	t.Run("single target", func(t *testing.T) {
+		ts.store.UpdateStoryScore(ctx, story.ID, 10)
+
+		code, resp := recompute(map[string]any{"target_type": "story", "target_id": story.ID}, "test-admin-secret")
+		if code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", code, http.StatusOK)
+		}
+		if resp.Score == nil || *resp.Score != 2 {
+			t.Errorf("response score = %v, want 2", resp.Score)
+		}
+		if score(story.ID) != 2 {
+			t.Errorf("score = %d, want 2", score(story.ID))
+		}
+	})
+
+	t.Run("all targets", func(t *testing.T) {
+		ts.store.UpdateStoryScore(ctx, story.ID, -5)
+		ts.store.UpdateStoryScore(ctx, other.ID, 3)
+
+		code, resp := recompute(map[string]any{}, "test-admin-secret")
+		if code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", code, http.StatusOK)
+		}
+		if resp.Corrected == nil || *resp.Corrected != 2 {
+			t.Errorf("corrected = %v, want 2", resp.Corrected)
+		}
+		if score(story.ID) != 2 || score(other.ID) != 0 {
+			t.Errorf("scores = %d, %d; want 2, 0", score(story.ID), score(other.ID))
+		}
+	})
+
+	t.Run("missing target", func(t *testing.T) {
+		code, _ := recompute(map[string]any{"target_type": "story", "target_id": "nonexistent"}, "test-admin-secret")
+		if code != http.StatusNotFound {
+			t.Errorf("status = %d, want %d", code, http.StatusNotFound)
+		}
+	})
+
+	t.Run("target_id without type", func(t *testing.T) {
+		code, _ := recompute(map[string]any{"target_id": story.ID}, "test-admin-secret")
+		if code != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d", code, http.StatusBadRequest)
+		}
+	})
+}
+
+func TestFlagsAPI(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+
+	ctx := context.Background()
+	story := &store.Story{Title: "Flag me", Text: "Content"}
+	ts.store.CreateStory(ctx, story)
+	comment := &store.Comment{StoryID: story.ID, Text: "A comment"}
+	ts.store.CreateComment(ctx, comment)
+
+	flag := func(agentID, ip, targetType, targetID, reason string) int {
+		body, _ := json.Marshal(map[string]any{"target_type": targetType, "target_id": targetID, "reason": reason})
+		req := httptest.NewRequest(http.MethodPost, "/api/flags", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = ip + ":12345"
+		rec := httptest.NewRecorder()
+		ts.handler.CreateFlag(rec, withAgent(req, agentID))
+		return rec.Code
+	}
+
+	t.Run("validation", func(t *testing.T) {
+		if code := flag("flagger", "10.0.0.9", "story", story.ID, "boring"); code != http.StatusBadRequest {
+			t.Errorf("unknown reason = %d, want %d", code, http.StatusBadRequest)
+		}
+		if code := flag("flagger", "10.0.0.9", "vote", story.ID, "spam"); code != http.StatusBadRequest {
+			t.Errorf("bad target_type = %d, want %d", code, http.StatusBadRequest)
+		}
+		if code := flag("flagger", "10.0.0.9", "story", "missing", "spam"); code != http.StatusNotFound {
+			t.Errorf("missing story = %d, want %d", code, http.StatusNotFound)
+		}
+	})
+
+	t.Run("duplicates do not count", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			if code := flag("repeat", "10.0.0.1", "comment", comment.ID, "spam"); code != http.StatusOK {
+				t.Fatalf("flag %d = %d, want %d", i, code, http.StatusOK)
+			}
+		}
+		// Same IP under another agent is still the same source
+		flag("repeat-alt", "10.0.0.1", "comment", comment.ID, "spam")
+		if got, _ := ts.store.GetComment(ctx, comment.ID); got == nil {
+			t.Fatal("comment hidden by repeated flags from one source")
+		}
+	})
+
+	t.Run("threshold hides", func(t *testing.T) {
+		flag("second", "10.0.0.2", "comment", comment.ID, "abuse")
+		if got, _ := ts.store.GetComment(ctx, comment.ID); got == nil {
+			t.Fatal("comment hidden below the threshold")
+		}
+		flag("third", "10.0.0.3", "comment", comment.ID, "spam")
+		if got, _ := ts.store.GetComment(ctx, comment.ID); got != nil {
+			t.Error("comment still visible after reaching the threshold")
+		}
+		if got, _ := ts.store.GetStory(ctx, story.ID); got == nil {
+			t.Error("flags on a comment hid its story")
+		}
+		if code := flag("fourth", "10.0.0.4", "comment", comment.ID, "spam"); code != http.StatusNotFound {
+			t.Errorf("flagging hidden comment = %d, want %d", code, http.StatusNotFound)
+		}
+	})
+
+	t.Run("admin review", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/admin/flags", nil)
+		rec := httptest.NewRecorder()
+		ts.handler.ListFlags(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("without secret = %d, want %d", rec.Code, http.StatusUnauthorized)
+		}
+
+		req = httptest.NewRequest(http.MethodGet, "/api/admin/flags", nil)
+		req.Header.Set("X-Admin-Secret", "test-admin-secret")
+		rec = httptest.NewRecorder()
+		ts.handler.ListFlags(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+		}
+		var resp ListFlagsResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		if len(resp.Flags) != 3 {
+			t.Fatalf("got %d flags, want 3", len(resp.Flags))
+		}
+		if resp.Flags[0].AgentID != "third" || resp.Flags[0].TargetID != comment.ID {
+			t.Errorf("newest flag = %+v, want the one from third", resp.Flags[0])
+		}
+	})
+}
+
+func TestAdminRecomputeStoryAPI(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+
+	ctx := context.Background()
+	story := &store.Story{Title: "Test Story", Text: "Content"}
+	ts.store.CreateStory(ctx, story)
+	ts.store.CastVote(ctx, &store.Vote{TargetType: "story", TargetID: story.ID, Value: 1, IPHash: "ip1"})
+	ts.store.CreateComment(ctx, &store.Comment{StoryID: story.ID, Text: "Comment"})
+	ts.store.UpdateStoryScore(ctx, story.ID, 10)
+	ts.store.UpdateStoryCommentCount(ctx, story.ID, 4)
+
+	recompute := func(id, secret string) (int, RecomputeStoryResponse) {
+		req := httptest.NewRequest(http.MethodPost, "/api/admin/stories/"+id+"/recompute", nil)
+		req.SetPathValue("id", id)
+		if secret != "" {
+			req.Header.Set("X-Admin-Secret", secret)
+		}
+		rec := httptest.NewRecorder()
+		ts.handler.RecomputeStory(rec, req)
+
+		var resp RecomputeStoryResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp
+	}
+
+	if code, _ := recompute(story.ID, ""); code != http.StatusUnauthorized {
+		t.Errorf("unauthenticated status = %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	code, resp := recompute(story.ID, "test-admin-secret")
+	if code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", code, http.StatusOK)
+	}
+	if resp.Score != 1 || resp.CommentCount != 1 {
+		t.Errorf("response = %+v, want score 1 and 1 comment", resp)
+	}
+
+	if code, _ := recompute("nonexistent", "test-admin-secret"); code != http.StatusNotFound {
+		t.Errorf("missing story status = %d, want %d", code, http.StatusNotFound)
+	}
+}
+
+func TestAdminRevokeAgentTokensAPI(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+
+	ctx := context.Background()
+	expires := time.Now().Add(time.Hour)
+	ts.store.CreateToken(ctx, &store.Token{AccountID: "acct1", KeyID: "k1", AgentID: "compromised", Token: "tok-1", ExpiresAt: expires})
+	ts.store.CreateToken(ctx, &store.Token{AccountID: "acct2", KeyID: "k2", AgentID: "compromised", Token: "tok-2", ExpiresAt: expires})
+	ts.store.CreateToken(ctx, &store.Token{KeyID: "k3", AgentID: "bystander", Token: "tok-3", ExpiresAt: expires})
+
+	revoke := func(body map[string]any, secret string) (int, RevokeAgentTokensResponse) {
+		b, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, "/api/admin/revoke-agent-tokens", bytes.NewReader(b))
+		req.Header.Set("Content-Type", "application/json")
+		if secret != "" {
+			req.Header.Set("X-Admin-Secret", secret)
+		}
+		rec := httptest.NewRecorder()
+		ts.handler.RevokeAgentTokens(rec, req)
+
+		var resp RevokeAgentTokensResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp
+	}
+	authStatus := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/api/stories/pending", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		ts.handler.RequireAuth(ts.handler.ListPendingStories)(rec, req)
+		return rec.Code
+	}
+
+	t.Run("unauthorized", func(t *testing.T) {
+		if code, _ := revoke(map[string]any{"agent_id": "compromised"}, ""); code != http.StatusUnauthorized {
+			t.Errorf("status = %d, want %d", code, http.StatusUnauthorized)
+		}
+		if code, _ := revoke(map[string]any{"agent_id": "compromised"}, "wrong-secret"); code != http.StatusUnauthorized {
+			t.Errorf("status = %d, want %d", code, http.StatusUnauthorized)
+		}
+		if code := authStatus("tok-1"); code != http.StatusOK {
+			t.Errorf("token should still work after a rejected revoke, got %d", code)
+		}
+	})
+
+	t.Run("missing agent_id", func(t *testing.T) {
+		if code, _ := revoke(map[string]any{}, "test-admin-secret"); code != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d", code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("revokes every token for the agent", func(t *testing.T) {
+		code, resp := revoke(map[string]any{"agent_id": "compromised"}, "test-admin-secret")
+		if code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", code, http.StatusOK)
+		}
+		if resp.Revoked != 2 {
+			t.Errorf("revoked = %d, want 2", resp.Revoked)
+		}
+
+		for _, token := range []string{"tok-1", "tok-2"} {
+			if code := authStatus(token); code != http.StatusUnauthorized {
+				t.Errorf("revoked token %s: status = %d, want %d", token, code, http.StatusUnauthorized)
+			}
+		}
+		if code := authStatus("tok-3"); code != http.StatusOK {
+			t.Errorf("other agent's token: status = %d, want %d", code, http.StatusOK)
+		}
+	})
+}
+
+func TestRateLimitedRetryAfter(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+	ts.handler.cfg.VoteRateLimit = 1
+
+	story := &store.Story{Title: "Test Story", Text: "Content"}
+	ts.store.CreateStory(context.Background(), story)
+
+	vote := func() *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]any{"target_type": "story", "target_id": story.ID, "value": 1})
+		req := httptest.NewRequest(http.MethodPost, "/api/votes", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = "192.168.1.1:12345"
+		rec := httptest.NewRecorder()
+		ts.handler.CreateVote(rec, req)
+		return rec
+	}
+
+	if rec := vote(); rec.Code != http.StatusOK {
+		t.Fatalf("first vote: status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	rec := vote()
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("second vote: status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+
+	header := rec.Header().Get("Retry-After")
+	seconds, err := strconv.Atoi(header)
+	if err != nil {
+		t.Fatalf("Retry-After = %q, want decimal seconds: %v", header, err)
+	}
+	if seconds <= 0 || seconds > int(time.Hour.Seconds()) {
+		t.Errorf("Retry-After = %d, want within the rate limit window", seconds)
+	}
+
+	var resp ErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode body: %v", err)
+	}
+	if resp.RetryAfter != seconds {
+		t.Errorf("retry_after = %d, want %d to match Retry-After", resp.RetryAfter, seconds)
+	}
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+	ts.handler.cfg.VoteRateLimit = 3
+
+	story := &store.Story{Title: "Test Story", Text: "Content"}
+	ts.store.CreateStory(context.Background(), story)
+
+	vote := func() *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]any{"target_type": "story", "target_id": story.ID, "value": 1})
+		req := httptest.NewRequest(http.MethodPost, "/api/votes", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
 		req.RemoteAddr = "192.168.1.1:12345"
+		rec := httptest.NewRecorder()
+		ts.handler.CreateVote(rec, req)
+		return rec
+	}
+
+	header := func(rec *httptest.ResponseRecorder, name string) int64 {
+		t.Helper()
+		n, err := strconv.ParseInt(rec.Header().Get(name), 10, 64)
+		if err != nil {
+			t.Fatalf("%s = %q, want an integer: %v", name, rec.Header().Get(name), err)
+		}
+		return n
+	}
+
+	lastRemaining := int64(3)
+	for i := 1; i <= 3; i++ {
+		rec := vote()
+		if i == 1 && rec.Code != http.StatusOK {
+			t.Fatalf("vote %d: status = %d, want %d", i, rec.Code, http.StatusOK)
+		}
+		if limit := header(rec, "X-RateLimit-Limit"); limit != 3 {
+			t.Errorf("vote %d: X-RateLimit-Limit = %d, want 3", i, limit)
+		}
+		remaining := header(rec, "X-RateLimit-Remaining")
+		if remaining != lastRemaining-1 {
+			t.Errorf("vote %d: X-RateLimit-Remaining = %d, want %d", i, remaining, lastRemaining-1)
+		}
+		lastRemaining = remaining
+		if reset := header(rec, "X-RateLimit-Reset"); reset <= time.Now().Unix() {
+			t.Errorf("vote %d: X-RateLimit-Reset = %d, want a future Unix timestamp", i, reset)
+		}
+	}
+
+	rec := vote()
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("blocked vote: status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	if remaining := header(rec, "X-RateLimit-Remaining"); remaining != 0 {
+		t.Errorf("blocked vote: X-RateLimit-Remaining = %d, want 0", remaining)
+	}
+}
+
+func TestAgentIDHeader(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+
+	body, _ := json.Marshal(map[string]any{
+		"title": "Story from Agent",
+		"url":   "https://example.com",
+	})
+	req := httptest.NewRequest(http.MethodPost, "/api/stories", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	// Add auth context (simulating RequireAuth middleware)
+	ctx := context.WithValue(req.Context(), ContextKeyAgentID, "test-agent-v1")
+	ctx = context.WithValue(ctx, ContextKeyVerified, true)
+	req = req.WithContext(ctx)
+
+	rec := httptest.NewRecorder()
+	ts.handler.CreateStory(rec, req)
+
+	var resp CreateStoryResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+
+	// Verify agent ID was saved
+	story, _ := ts.store.GetStory(context.Background(), resp.ID)
+	if story.AgentID != "test-agent-v1" {
+		t.Errorf("agent_id = %q, want %q", story.AgentID, "test-agent-v1")
+	}
+}
+
+// signedChallenge issues a challenge for agentID and signs its message with priv
+func signedChallenge(t *testing.T, ts *testServer, agentID string, priv ed25519.PrivateKey) (challenge, signature string) {
+	t.Helper()
+
+	c, err := ts.handler.auth.CreateChallenge(context.Background(), agentID, auth.AlgEd25519)
+	if err != nil {
+		t.Fatalf("failed to create challenge: %v", err)
+	}
+	sig := ed25519.Sign(priv, []byte(ts.handler.auth.ChallengeMessage(c)))
+	return c.Challenge, base64.StdEncoding.EncodeToString(sig)
+}
+
+func TestVerifyRequiresBoundMessage(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+	ts.handler.auth.SetChallengeOrigin("https://claw.example")
+
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	post := func(path string, handler http.HandlerFunc, body map[string]any) *httptest.ResponseRecorder {
+		raw, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(raw))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+	verify := func(challenge, signed string) *httptest.ResponseRecorder {
+		return post("/api/auth/verify", ts.handler.VerifyChallenge, map[string]any{
+			"agent_id":   "bound-agent",
+			"alg":        auth.AlgEd25519,
+			"public_key": base64.StdEncoding.EncodeToString(pub),
+			"challenge":  challenge,
+			"signature":  base64.StdEncoding.EncodeToString(ed25519.Sign(priv, []byte(signed))),
+		})
+	}
+
+	rec := post("/api/auth/challenge", ts.handler.CreateChallenge, map[string]any{"agent_id": "bound-agent", "alg": auth.AlgEd25519})
+	var challenge ChallengeResponse
+	json.Unmarshal(rec.Body.Bytes(), &challenge)
+	if want := "slashclaw-auth:v1:https://claw.example:bound-agent:ed25519:" + challenge.Challenge; challenge.Message != want {
+		t.Fatalf("message = %q, want %q", challenge.Message, want)
+	}
+
+	rec = verify(challenge.Challenge, challenge.Challenge)
+	if rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Body.String(), "slashclaw-auth:v1") {
+		t.Errorf("bare challenge: status = %d, body = %s; want 401 naming the message version", rec.Code, rec.Body.String())
+	}
+	if rec = verify(challenge.Challenge, challenge.Message); rec.Code != http.StatusOK {
+		t.Errorf("bound message: status = %d, want %d; body = %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+}
+
+func TestCreateChallengeLimitAPI(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+	ts.handler.auth.SetMaxActiveChallenges(2)
+
+	create := func() *httptest.ResponseRecorder {
+		body, _ := json.Marshal(ChallengeRequest{AgentID: "eager-agent", Algorithm: auth.AlgEd25519})
+		rec := httptest.NewRecorder()
+		ts.handler.CreateChallenge(rec, httptest.NewRequest(http.MethodPost, "/api/auth/challenge", bytes.NewReader(body)))
+		return rec
+	}
+
+	for i := 0; i < 2; i++ {
+		if rec := create(); rec.Code != http.StatusOK {
+			t.Fatalf("challenge %d: status = %d, want %d", i+1, rec.Code, http.StatusOK)
+		}
+	}
+	rec := create()
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("over the cap: status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "300" {
+		t.Errorf("Retry-After = %q, want the challenge TTL of 300", got)
+	}
+}
+
+func TestGetAccountKarmaAPI(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+	ctx := context.Background()
+
+	account := &store.Account{DisplayName: "karma"}
+	ts.store.CreateAccount(ctx, account)
+	ts.store.CreateToken(ctx, &store.Token{KeyID: "k", AccountID: account.ID, AgentID: "karma-agent", Token: "t", ExpiresAt: time.Now().Add(time.Hour)})
 
+	story := &store.Story{Title: "Karma", Text: "Content", AgentID: "karma-agent"}
+	ts.store.CreateStory(ctx, story)
+	ts.store.UpdateStoryScore(ctx, story.ID, 4)
+	comment := &store.Comment{StoryID: story.ID, Text: "Comment", AgentID: "karma-agent"}
+	ts.store.CreateComment(ctx, comment)
+	ts.store.UpdateCommentScore(ctx, comment.ID, -1)
+
+	t.Run("karma endpoint", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/accounts/"+account.ID+"/karma", nil)
+		req.SetPathValue("id", account.ID)
 		rec := httptest.NewRecorder()
-		ts.handler.CreateVote(rec, req)
+		ts.handler.GetAccountKarma(rec, req)
 
 		if rec.Code != http.StatusOK {
-			t.Errorf("status = %d, want %d; body = %s", rec.Code, http.StatusOK, rec.Body.String())
+			t.Fatalf("status = %d, want %d; body = %s", rec.Code, http.StatusOK, rec.Body.String())
+		}
+		var resp KarmaResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		if resp.AccountID != account.ID || resp.Karma != 3 {
+			t.Errorf("got %+v, want karma 3 for %s", resp, account.ID)
 		}
+	})
 
-		// Verify score updated
-		updated, _ := ts.store.GetStory(context.Background(), story.ID)
-		if updated.Score != 1 {
-			t.Errorf("score = %d, want 1", updated.Score)
+	t.Run("account includes karma", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/accounts/"+account.ID, nil)
+		req.SetPathValue("id", account.ID)
+		rec := httptest.NewRecorder()
+		ts.handler.GetAccount(rec, req)
+
+		var got store.Account
+		json.Unmarshal(rec.Body.Bytes(), &got)
+		if got.Karma != 3 {
+			t.Errorf("karma = %d, want 3", got.Karma)
 		}
 	})
 
-	t.Run("change vote", func(t *testing.T) {
-		body, _ := json.Marshal(map[string]any{
-			"target_type": "story",
-			"target_id":   story.ID,
-			"value":       -1,
-		})
-		req := httptest.NewRequest(http.MethodPost, "/api/votes", bytes.NewReader(body))
-		req.Header.Set("Content-Type", "application/json")
-		req.RemoteAddr = "192.168.1.1:12345" // Same IP as before
+	t.Run("unknown account", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/accounts/missing/karma", nil)
+		req.SetPathValue("id", "missing")
+		rec := httptest.NewRecorder()
+		ts.handler.GetAccountKarma(rec, req)
+
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusNotFound)
+		}
+	})
+}
 
+func TestListAccountKeysAPI(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+	ctx := context.Background()
+
+	account := &store.Account{DisplayName: "keyring"}
+	ts.store.CreateAccount(ctx, account)
+	inUse := &store.AccountKey{AccountID: account.ID, Algorithm: "ed25519", PublicKey: "in-use-key"}
+	ts.store.CreateAccountKey(ctx, inUse)
+	revoked := &store.AccountKey{AccountID: account.ID, Algorithm: "rsa-sha256", PublicKey: "old-key", CreatedAt: time.Now().UTC().Add(time.Minute)}
+	ts.store.CreateAccountKey(ctx, revoked)
+	ts.store.RevokeAccountKey(ctx, revoked.ID)
+	ts.store.CreateToken(ctx, &store.Token{KeyID: inUse.ID, AccountID: account.ID, AgentID: "owner", Token: "owner-token", ExpiresAt: time.Now().Add(time.Hour)})
+
+	other := &store.Account{DisplayName: "other"}
+	ts.store.CreateAccount(ctx, other)
+	ts.store.CreateToken(ctx, &store.Token{KeyID: "k", AccountID: other.ID, AgentID: "stranger", Token: "other-token", ExpiresAt: time.Now().Add(time.Hour)})
+
+	list := func(token string) (int, ListKeysResponse) {
+		req := httptest.NewRequest(http.MethodGet, "/api/accounts/"+account.ID+"/keys", nil)
+		req.SetPathValue("id", account.ID)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
 		rec := httptest.NewRecorder()
-		ts.handler.CreateVote(rec, req)
+		ts.handler.ListAccountKeys(rec, req)
 
-		if rec.Code != http.StatusOK {
-			t.Errorf("status = %d, want %d", rec.Code, http.StatusOK)
+		var resp ListKeysResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp
+	}
+
+	t.Run("owner", func(t *testing.T) {
+		code, resp := list("owner-token")
+		if code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", code, http.StatusOK)
+		}
+		if len(resp.Keys) != 2 {
+			t.Fatalf("keys = %+v, want 2", resp.Keys)
+		}
+		first, second := resp.Keys[0], resp.Keys[1]
+		if first.ID != inUse.ID || first.Algorithm != "ed25519" || !first.Current || first.RevokedAt != nil {
+			t.Errorf("first key = %+v, want the current, unrevoked ed25519 key", first)
+		}
+		if second.ID != revoked.ID || second.Current || second.RevokedAt == nil {
+			t.Errorf("second key = %+v, want the revoked key with revoked_at set", second)
+		}
+		if first.Fingerprint != keyFingerprint("in-use-key") || !strings.HasPrefix(first.Fingerprint, "SHA256:") {
+			t.Errorf("fingerprint = %q, want %q", first.Fingerprint, keyFingerprint("in-use-key"))
 		}
+		if first.Fingerprint == second.Fingerprint {
+			t.Error("different keys share a fingerprint")
+		}
+	})
 
-		// Score should change by -2 (from +1 to -1)
-		updated, _ := ts.store.GetStory(context.Background(), story.ID)
-		if updated.Score != -1 {
-			t.Errorf("score = %d, want -1", updated.Score)
+	t.Run("other account", func(t *testing.T) {
+		if code, _ := list("other-token"); code != http.StatusForbidden {
+			t.Errorf("status = %d, want %d", code, http.StatusForbidden)
 		}
 	})
 
-	t.Run("invalid target_type", func(t *testing.T) {
-		body, _ := json.Marshal(map[string]any{
-			"target_type": "invalid",
-			"target_id":   story.ID,
-			"value":       1,
-		})
-		req := httptest.NewRequest(http.MethodPost, "/api/votes", bytes.NewReader(body))
-		req.Header.Set("Content-Type", "application/json")
+	t.Run("unauthenticated", func(t *testing.T) {
+		if code, _ := list(""); code != http.StatusUnauthorized {
+			t.Errorf("status = %d, want %d", code, http.StatusUnauthorized)
+		}
+	})
+}
+
+func TestFindAccountsAPI(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+	ctx := context.Background()
+
+	account := &store.Account{DisplayName: "Lookup Agent"}
+	ts.store.CreateAccount(ctx, account)
+	key := &store.AccountKey{AccountID: account.ID, Algorithm: "ed25519", PublicKey: "pk+/="}
+	ts.store.CreateAccountKey(ctx, key)
+	revoked := &store.AccountKey{AccountID: account.ID, Algorithm: "ed25519", PublicKey: "old-key"}
+	ts.store.CreateAccountKey(ctx, revoked)
+	ts.store.RevokeAccountKey(ctx, revoked.ID)
 
+	find := func(query url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/accounts?"+query.Encode(), nil)
 		rec := httptest.NewRecorder()
-		ts.handler.CreateVote(rec, req)
+		ts.handler.FindAccounts(rec, req)
+		return rec
+	}
 
-		if rec.Code != http.StatusBadRequest {
-			t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	t.Run("by public key", func(t *testing.T) {
+		rec := find(url.Values{"alg": {"ed25519"}, "public_key": {"pk+/="}})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d; body = %s", rec.Code, http.StatusOK, rec.Body.String())
+		}
+		var got store.Account
+		json.Unmarshal(rec.Body.Bytes(), &got)
+		if got.ID != account.ID {
+			t.Errorf("account = %+v, want %s", got, account.ID)
 		}
 	})
 
-	t.Run("invalid value", func(t *testing.T) {
-		body, _ := json.Marshal(map[string]any{
-			"target_type": "story",
-			"target_id":   story.ID,
-			"value":       5,
-		})
-		req := httptest.NewRequest(http.MethodPost, "/api/votes", bytes.NewReader(body))
-		req.Header.Set("Content-Type", "application/json")
+	t.Run("unregistered and revoked keys", func(t *testing.T) {
+		for _, pk := range []string{"unknown", "old-key"} {
+			if rec := find(url.Values{"alg": {"ed25519"}, "public_key": {pk}}); rec.Code != http.StatusNotFound {
+				t.Errorf("%s: status = %d, want %d", pk, rec.Code, http.StatusNotFound)
+			}
+		}
+	})
 
-		rec := httptest.NewRecorder()
-		ts.handler.CreateVote(rec, req)
+	t.Run("by name", func(t *testing.T) {
+		rec := find(url.Values{"name": {"lookup"}})
+		var resp FindAccountsResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		if rec.Code != http.StatusOK || len(resp.Accounts) != 1 || resp.Accounts[0].ID != account.ID {
+			t.Errorf("got %d %+v, want the lookup account", rec.Code, resp.Accounts)
+		}
 
-		if rec.Code != http.StatusBadRequest {
-			t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+		rec = find(url.Values{"name": {"nobody"}})
+		if strings.TrimSpace(rec.Body.String()) != `{"accounts":[]}` {
+			t.Errorf("no matches = %s, want an empty list", rec.Body.String())
+		}
+	})
+
+	t.Run("missing parameters", func(t *testing.T) {
+		for _, query := range []url.Values{{}, {"public_key": {"pk+/="}}} {
+			if rec := find(query); rec.Code != http.StatusBadRequest {
+				t.Errorf("%v: status = %d, want %d", query, rec.Code, http.StatusBadRequest)
+			}
 		}
 	})
 }
 
-func TestAdminHideAPI(t *testing.T) {
+func TestAuthorAPI(t *testing.T) {
 	ts := setupTestServer(t)
 	defer ts.cleanup()
+	ctx := context.Background()
 
-	// Create a story
-	story := &store.Story{Title: "Test Story", Text: "Content"}
-	ts.store.CreateStory(context.Background(), story)
+	account := &store.Account{DisplayName: "Author Account"}
+	ts.store.CreateAccount(ctx, account)
+	ts.store.CreateToken(ctx, &store.Token{KeyID: "k", AccountID: account.ID, AgentID: "linked", Token: "t", ExpiresAt: time.Now().Add(time.Hour)})
 
-	t.Run("unauthorized", func(t *testing.T) {
-		body, _ := json.Marshal(map[string]any{
-			"target_type": "story",
-			"target_id":   story.ID,
-		})
-		req := httptest.NewRequest(http.MethodPost, "/api/admin/hide", bytes.NewReader(body))
-		req.Header.Set("Content-Type", "application/json")
+	// The same agent ID unverified gets no author: anyone could claim it
+	verified := &store.Story{Title: "Verified Story", Text: "Content", AgentID: "linked", AgentVerified: true}
+	unverified := &store.Story{Title: "Unverified Story", Text: "Content", AgentID: "linked"}
+	unlinked := &store.Story{Title: "Unlinked Story", Text: "Content", AgentID: "loner", AgentVerified: true}
+	for _, story := range []*store.Story{verified, unverified, unlinked} {
+		ts.store.CreateStory(ctx, story)
+	}
+	reply := &store.Comment{StoryID: verified.ID, Text: "Verified", AgentID: "linked", AgentVerified: true}
+	ts.store.CreateComment(ctx, reply)
+	nested := &store.Comment{StoryID: verified.ID, ParentID: reply.ID, Text: "Unverified", AgentID: "linked"}
+	ts.store.CreateComment(ctx, nested)
+
+	wantAuthor := func(t *testing.T, what string, got *store.Author, want bool) {
+		t.Helper()
+		switch {
+		case want && (got == nil || got.AccountID != account.ID || got.DisplayName != "Author Account"):
+			t.Errorf("%s author = %+v, want %s", what, got, account.ID)
+		case !want && got != nil:
+			t.Errorf("%s author = %+v, want none", what, got)
+		}
+	}
+
+	t.Run("get story", func(t *testing.T) {
+		for _, tc := range []struct {
+			story *store.Story
+			want  bool
+		}{{verified, true}, {unverified, false}, {unlinked, false}} {
+			req := httptest.NewRequest(http.MethodGet, "/api/stories/"+tc.story.ID, nil)
+			req.SetPathValue("id", tc.story.ID)
+			rec := httptest.NewRecorder()
+			ts.handler.GetStory(rec, req)
+			var got store.Story
+			json.Unmarshal(rec.Body.Bytes(), &got)
+			wantAuthor(t, tc.story.Title, got.Author, tc.want)
+			if !tc.want && strings.Contains(rec.Body.String(), `"author"`) {
+				t.Errorf("%s body = %s, want no author key", tc.story.Title, rec.Body.String())
+			}
+		}
+	})
 
+	t.Run("list stories", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/stories?sort=new&with_top_comment=true", nil)
 		rec := httptest.NewRecorder()
-		ts.handler.Hide(rec, req)
+		ts.handler.ListStories(rec, req)
+		var list ListStoriesResponse
+		json.Unmarshal(rec.Body.Bytes(), &list)
+		if len(list.Stories) != 3 {
+			t.Fatalf("stories = %d, want 3", len(list.Stories))
+		}
+		for _, item := range list.Stories {
+			wantAuthor(t, item.Title, item.Author, item.ID == verified.ID)
+			if item.ID == verified.ID {
+				wantAuthor(t, "top comment", item.TopComment.Author, true)
+			}
+		}
+	})
 
-		if rec.Code != http.StatusUnauthorized {
-			t.Errorf("status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	t.Run("comment tree", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/stories/"+verified.ID+"/comments", nil)
+		req.SetPathValue("id", verified.ID)
+		rec := httptest.NewRecorder()
+		ts.handler.ListComments(rec, req)
+		var resp ListCommentsResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		if len(resp.Comments) != 1 || len(resp.Comments[0].Children) != 1 {
+			t.Fatalf("comments = %+v, want one with one reply", resp.Comments)
 		}
+		wantAuthor(t, "comment", resp.Comments[0].Author, true)
+		wantAuthor(t, "unverified reply", resp.Comments[0].Children[0].Author, false)
 	})
+}
 
-	t.Run("authorized", func(t *testing.T) {
+func TestCreateAccountIdempotentRetry(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	pubB64 := base64.StdEncoding.EncodeToString(pub)
+
+	createAccount := func(displayName string) *httptest.ResponseRecorder {
+		challenge, signature := signedChallenge(t, ts, displayName, priv)
 		body, _ := json.Marshal(map[string]any{
-			"target_type": "story",
-			"target_id":   story.ID,
+			"display_name": displayName,
+			"public_key":   pubB64,
+			"alg":          auth.AlgEd25519,
+			"challenge":    challenge,
+			"signature":    signature,
 		})
-		req := httptest.NewRequest(http.MethodPost, "/api/admin/hide", bytes.NewReader(body))
+		req := httptest.NewRequest(http.MethodPost, "/api/accounts", bytes.NewReader(body))
 		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("X-Admin-Secret", "test-admin-secret")
-
 		rec := httptest.NewRecorder()
-		ts.handler.Hide(rec, req)
+		ts.handler.CreateAccount(rec, req)
+		return rec
+	}
+
+	first := createAccount("retry-agent")
+	if first.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want %d; body = %s", first.Code, http.StatusCreated, first.Body.String())
+	}
+	var created CreateAccountResponse
+	json.Unmarshal(first.Body.Bytes(), &created)
+
+	t.Run("conflict when disabled", func(t *testing.T) {
+		rec := createAccount("retry-agent")
+		if rec.Code != http.StatusConflict {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusConflict)
+		}
+	})
 
+	ts.handler.cfg.IdempotentAccountCreate = true
+	ts.handler.cfg.AccountRetryWindow = time.Minute
+
+	t.Run("retry returns existing account", func(t *testing.T) {
+		rec := createAccount("retry-agent")
 		if rec.Code != http.StatusOK {
-			t.Errorf("status = %d, want %d; body = %s", rec.Code, http.StatusOK, rec.Body.String())
+			t.Fatalf("status = %d, want %d; body = %s", rec.Code, http.StatusOK, rec.Body.String())
 		}
+		var resp CreateAccountResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		if resp.AccountID != created.AccountID || resp.KeyID != created.KeyID {
+			t.Errorf("got account %s key %s, want account %s key %s", resp.AccountID, resp.KeyID, created.AccountID, created.KeyID)
+		}
+		if !resp.Existing {
+			t.Error("retry should have existing=true")
+		}
+	})
 
-		// Verify story is hidden
-		hidden, _ := ts.store.GetStory(context.Background(), story.ID)
-		if hidden != nil {
-			t.Error("story should be hidden")
+	t.Run("different display name conflicts", func(t *testing.T) {
+		rec := createAccount("other-agent")
+		if rec.Code != http.StatusConflict {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusConflict)
+		}
+	})
+
+	t.Run("outside retry window conflicts", func(t *testing.T) {
+		ts.handler.cfg.AccountRetryWindow = -time.Second
+		rec := createAccount("retry-agent")
+		if rec.Code != http.StatusConflict {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusConflict)
 		}
 	})
 }
 
-func TestAgentIDHeader(t *testing.T) {
+// checkTimeFields walks a decoded JSON value and fails for any "*_at" string
+// that is not RFC 3339 in UTC
+func checkTimeFields(t *testing.T, path string, v any) int {
+	t.Helper()
+
+	checked := 0
+	switch v := v.(type) {
+	case map[string]any:
+		for key, val := range v {
+			if s, ok := val.(string); ok && strings.HasSuffix(key, "_at") {
+				if _, err := time.Parse(time.RFC3339, s); err != nil {
+					t.Errorf("%s.%s = %q does not parse as RFC 3339: %v", path, key, s, err)
+				} else if !strings.HasSuffix(s, "Z") {
+					t.Errorf("%s.%s = %q, want a UTC Z suffix", path, key, s)
+				}
+				checked++
+				continue
+			}
+			checked += checkTimeFields(t, path+"."+key, val)
+		}
+	case []any:
+		for i, val := range v {
+			checked += checkTimeFields(t, fmt.Sprintf("%s[%d]", path, i), val)
+		}
+	}
+	return checked
+}
+
+func TestTimeFieldsRFC3339(t *testing.T) {
 	ts := setupTestServer(t)
 	defer ts.cleanup()
+	ctx := context.Background()
 
-	body, _ := json.Marshal(map[string]any{
-		"title": "Story from Agent",
-		"url":   "https://example.com",
+	// A non-UTC creation time must still come back as Z
+	story := &store.Story{Title: "Times", Text: "Content", CreatedAt: time.Now().In(time.FixedZone("EST", -5*60*60))}
+	ts.store.CreateStory(ctx, story)
+	comment := &store.Comment{StoryID: story.ID, Text: "Comment"}
+	ts.store.CreateComment(ctx, comment)
+	ts.store.UpdateCommentText(ctx, comment.ID, "Edited")
+	ts.store.CreateFlag(ctx, &store.Flag{TargetType: "story", TargetID: story.ID, Reason: "spam", IPHash: "h"})
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	post := func(path string, body any) *http.Request {
+		data, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(data))
+		req.Header.Set("Content-Type", "application/json")
+		return req
+	}
+	get := func(path string) *http.Request {
+		return httptest.NewRequest(http.MethodGet, path, nil)
+	}
+
+	tests := []struct {
+		name    string
+		req     *http.Request
+		handler http.HandlerFunc
+		id      string
+	}{
+		{"get story", get("/api/stories/" + story.ID), ts.handler.GetStory, story.ID},
+		{"list stories", get("/api/stories"), ts.handler.ListStories, ""},
+		{"list comments", get("/api/stories/" + story.ID + "/comments"), ts.handler.ListComments, story.ID},
+		{"challenge", post("/api/auth/challenge", map[string]any{"agent_id": "time-agent", "alg": auth.AlgEd25519}), ts.handler.CreateChallenge, ""},
+		{"flags", func() *http.Request {
+			req := get("/api/admin/flags")
+			req.Header.Set("X-Admin-Secret", "test-admin-secret")
+			return req
+		}(), ts.handler.ListFlags, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.id != "" {
+				tt.req.SetPathValue("id", tt.id)
+			}
+			rec := httptest.NewRecorder()
+			tt.handler(rec, tt.req)
+			if rec.Code != http.StatusOK && rec.Code != http.StatusCreated {
+				t.Fatalf("status = %d; body = %s", rec.Code, rec.Body.String())
+			}
+
+			var body any
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("invalid JSON: %v", err)
+			}
+			if checkTimeFields(t, "$", body) == 0 {
+				t.Errorf("no time fields found in %s", rec.Body.String())
+			}
+		})
+	}
+
+	t.Run("verify", func(t *testing.T) {
+		challenge, signature := signedChallenge(t, ts, "time-agent", priv)
+		req := post("/api/auth/verify", map[string]any{
+			"agent_id":   "time-agent",
+			"alg":        auth.AlgEd25519,
+			"public_key": base64.StdEncoding.EncodeToString(pub),
+			"challenge":  challenge,
+			"signature":  signature,
+		})
+		rec := httptest.NewRecorder()
+		ts.handler.VerifyChallenge(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d; body = %s", rec.Code, rec.Body.String())
+		}
+
+		var body any
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		if checkTimeFields(t, "$", body) == 0 {
+			t.Errorf("no time fields found in %s", rec.Body.String())
+		}
 	})
-	req := httptest.NewRequest(http.MethodPost, "/api/stories", bytes.NewReader(body))
-	req.Header.Set("Content-Type", "application/json")
+}
 
-	// Add auth context (simulating RequireAuth middleware)
-	ctx := context.WithValue(req.Context(), ContextKeyAgentID, "test-agent-v1")
-	ctx = context.WithValue(ctx, ContextKeyVerified, true)
-	req = req.WithContext(ctx)
+func TestClientIP(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("::1/128")}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		xff        []string
+		realIP     string
+		want       string
+	}{
+		{"no headers", "203.0.113.5:1234", nil, "", "203.0.113.5"},
+		{"spoofed XFF from untrusted client", "203.0.113.5:1234", []string{"1.2.3.4"}, "", "203.0.113.5"},
+		{"spoofed X-Real-IP from untrusted client", "203.0.113.5:1234", nil, "1.2.3.4", "203.0.113.5"},
+		{"trusted proxy", "10.0.0.2:1234", []string{"198.51.100.7"}, "", "198.51.100.7"},
+		{"trusted proxy X-Real-IP", "10.0.0.2:1234", nil, "198.51.100.7", "198.51.100.7"},
+		{"spoofed entry left of the real client", "10.0.0.2:1234", []string{"1.2.3.4, 198.51.100.7"}, "", "198.51.100.7"},
+		{"chain of trusted proxies", "10.0.0.2:1234", []string{"1.2.3.4, 198.51.100.7, 10.0.0.9"}, "", "198.51.100.7"},
+		{"repeated headers", "10.0.0.2:1234", []string{"1.2.3.4", "198.51.100.7"}, "", "198.51.100.7"},
+		{"garbled hop", "10.0.0.2:1234", []string{"198.51.100.7, nonsense"}, "", "10.0.0.2"},
+		{"ipv6 proxy", "[::1]:1234", []string{"2001:db8::1"}, "", "2001:db8::1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for _, xff := range tt.xff {
+				req.Header.Add("X-Forwarded-For", xff)
+			}
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+			if got := clientIP(req, trusted); got != tt.want {
+				t.Errorf("clientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("handler config", func(t *testing.T) {
+		h := NewHandler(nil, nil, nil, &config.Config{TrustedProxies: []string{"10.0.0.0/8"}})
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "10.1.2.3:1234"
+		req.Header.Set("X-Forwarded-For", "198.51.100.7")
+		if got := h.getClientIP(req); got != "198.51.100.7" {
+			t.Errorf("getClientIP = %q, want the forwarded client", got)
+		}
+
+		req.RemoteAddr = "203.0.113.5:1234"
+		if got := h.getClientIP(req); got != "203.0.113.5" {
+			t.Errorf("getClientIP = %q, want the untrusted peer", got)
+		}
+	})
+}
+
+func TestAdminActivity(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+	ctx := context.Background()
+
+	story := &store.Story{Title: "Story to moderate", Text: "Moderated content", CreatedAt: time.Now().UTC().Add(-time.Minute)}
+	ts.store.CreateStory(ctx, story)
+
+	activity := func(query, secret string) (int, ActivityResponse) {
+		req := httptest.NewRequest(http.MethodGet, "/api/admin/activity"+query, nil)
+		if secret != "" {
+			req.Header.Set("X-Admin-Secret", secret)
+		}
+		rec := httptest.NewRecorder()
+		ts.handler.Activity(rec, req)
+		var resp ActivityResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp
+	}
 
+	if code, _ := activity("", ""); code != http.StatusUnauthorized {
+		t.Errorf("without admin auth: status = %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code, _ := activity("?before=yesterday", "test-admin-secret"); code != http.StatusBadRequest {
+		t.Errorf("bad before: status = %d, want %d", code, http.StatusBadRequest)
+	}
+
+	// Hiding the story records an audit entry
+	body, _ := json.Marshal(map[string]any{"target_type": "story", "target_id": story.ID})
+	req := httptest.NewRequest(http.MethodPost, "/api/admin/hide", bytes.NewReader(body))
+	req.Header.Set("X-Admin-Secret", "test-admin-secret")
 	rec := httptest.NewRecorder()
-	ts.handler.CreateStory(rec, req)
+	ts.handler.Hide(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("hide status = %d, want %d", rec.Code, http.StatusOK)
+	}
 
-	var resp CreateStoryResponse
-	json.Unmarshal(rec.Body.Bytes(), &resp)
+	entries, err := ts.store.ListAudit(ctx, time.Time{}, 10)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("ListAudit = %v, %v; want one entry", entries, err)
+	}
+	if got := entries[0]; got.Action != store.AuditHide || got.TargetType != "story" || got.TargetID != story.ID || got.Actor != "secret" {
+		t.Errorf("audit entry = %+v, want a hide of the story by the secret", got)
+	}
 
-	// Verify agent ID was saved
-	story, _ := ts.store.GetStory(context.Background(), resp.ID)
-	if story.AgentID != "test-agent-v1" {
-		t.Errorf("agent_id = %q, want %q", story.AgentID, "test-agent-v1")
+	// The feed shows the hide, then the story it hid, newest first
+	code, resp := activity("", "test-admin-secret")
+	if code != http.StatusOK || len(resp.Items) != 2 {
+		t.Fatalf("activity = %d %+v, want 2 items", code, resp)
+	}
+	if first := resp.Items[0]; first.Type != "audit" || first.Audit == nil || first.Audit.ID != entries[0].ID {
+		t.Errorf("first item = %+v, want the hide", first)
+	}
+	if second := resp.Items[1]; second.Type != "story" || second.Story == nil || second.Story.ID != story.ID || !second.Hidden {
+		t.Errorf("second item = %+v, want the hidden story", second)
+	}
+	if resp.NextBefore != "" {
+		t.Errorf("next_before = %q, want none on the only page", resp.NextBefore)
+	}
+
+	// Paging one item at a time walks the same feed
+	code, page := activity("?limit=1", "test-admin-secret")
+	if code != http.StatusOK || len(page.Items) != 1 || page.Items[0].Type != "audit" || page.NextBefore == "" {
+		t.Fatalf("first page = %d %+v, want the hide and a cursor", code, page)
+	}
+	code, page = activity("?limit=1&before="+url.QueryEscape(page.NextBefore), "test-admin-secret")
+	if code != http.StatusOK || len(page.Items) != 1 || page.Items[0].Type != "story" {
+		t.Errorf("second page = %d %+v, want the story", code, page)
+	}
+
+	// An admin token is recorded by its id
+	tokenStr, token, err := ts.handler.admin.IssueToken(ctx, "recompute bot", []string{auth.ScopeRecompute})
+	if err != nil {
+		t.Fatalf("IssueToken: %v", err)
+	}
+	req = httptest.NewRequest(http.MethodPost, "/api/admin/stories/"+story.ID+"/recompute", nil)
+	req.SetPathValue("id", story.ID)
+	req.Header.Set("Authorization", "Bearer "+tokenStr)
+	rec = httptest.NewRecorder()
+	ts.handler.RecomputeStory(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("recompute status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if entries, _ := ts.store.ListAudit(ctx, time.Time{}, 1); len(entries) != 1 || entries[0].Action != store.AuditRecompute || entries[0].Actor != "token:"+token.ID {
+		t.Errorf("latest audit entry = %+v, want a recompute by a token", entries)
 	}
 }
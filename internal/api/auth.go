@@ -2,6 +2,7 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 
 	"github.com/alphabot-ai/slashclaw/internal/auth"
@@ -14,6 +15,7 @@ type ChallengeRequest struct {
 
 type ChallengeResponse struct {
 	Challenge string `json:"challenge"`
+	Message   string `json:"message"` // what to sign: the challenge bound to this server, the agent, and alg
 	ExpiresAt string `json:"expires_at"`
 }
 
@@ -32,11 +34,15 @@ type VerifyResponse struct {
 	AccountID   string `json:"account_id,omitempty"`
 }
 
+// unboundSignatureMessage answers a signature over the bare challenge, which
+// clients written before challenge messages were bound still send
+const unboundSignatureMessage = "signature must cover the challenge's message (" + auth.ChallengeMessageVersion + "), not the bare challenge"
+
 // CreateChallenge handles POST /api/auth/challenge
 func (h *Handler) CreateChallenge(w http.ResponseWriter, r *http.Request) {
 	var req ChallengeRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, http.StatusBadRequest, "invalid JSON")
+		writeBodyError(w, err, "invalid JSON")
 		return
 	}
 
@@ -52,17 +58,23 @@ func (h *Handler) CreateChallenge(w http.ResponseWriter, r *http.Request) {
 
 	challenge, err := h.auth.CreateChallenge(r.Context(), req.AgentID, req.Algorithm)
 	if err != nil {
-		if err == auth.ErrInvalidAlgorithm {
+		if errors.Is(err, auth.ErrInvalidAlgorithm) {
 			writeError(w, http.StatusBadRequest, "invalid algorithm; supported: ed25519, secp256k1, rsa-pss, rsa-sha256")
 			return
 		}
+		if errors.Is(err, auth.ErrTooManyChallenges) {
+			// A slot frees up when one is verified or, at the latest, expires
+			writeRateLimited(w, int(h.cfg.ChallengeTTL.Seconds()))
+			return
+		}
 		writeError(w, http.StatusInternalServerError, "failed to create challenge")
 		return
 	}
 
 	writeJSON(w, http.StatusOK, ChallengeResponse{
 		Challenge: challenge.Challenge,
-		ExpiresAt: challenge.ExpiresAt.Format("2006-01-02T15:04:05Z"),
+		Message:   h.auth.ChallengeMessage(challenge),
+		ExpiresAt: formatTime(challenge.ExpiresAt),
 	})
 }
 
@@ -70,7 +82,7 @@ func (h *Handler) CreateChallenge(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) VerifyChallenge(w http.ResponseWriter, r *http.Request) {
 	var req VerifyRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, http.StatusBadRequest, "invalid JSON")
+		writeBodyError(w, err, "invalid JSON")
 		return
 	}
 
@@ -81,14 +93,16 @@ func (h *Handler) VerifyChallenge(w http.ResponseWriter, r *http.Request) {
 
 	token, err := h.auth.VerifyAndCreateToken(r.Context(), req.AgentID, req.Algorithm, req.PublicKey, req.Challenge, req.Signature)
 	if err != nil {
-		switch err {
-		case auth.ErrInvalidAlgorithm:
+		switch {
+		case errors.Is(err, auth.ErrInvalidAlgorithm):
 			writeError(w, http.StatusBadRequest, "invalid algorithm")
-		case auth.ErrInvalidPublicKey:
+		case errors.Is(err, auth.ErrInvalidPublicKey):
 			writeError(w, http.StatusBadRequest, "invalid public key format")
-		case auth.ErrInvalidSignature:
+		case errors.Is(err, auth.ErrUnboundSignature):
+			writeError(w, http.StatusUnauthorized, unboundSignatureMessage)
+		case errors.Is(err, auth.ErrInvalidSignature):
 			writeError(w, http.StatusUnauthorized, "invalid signature")
-		case auth.ErrChallengeNotFound, auth.ErrChallengeExpired:
+		case errors.Is(err, auth.ErrChallengeNotFound), errors.Is(err, auth.ErrChallengeExpired):
 			writeError(w, http.StatusBadRequest, "challenge expired or not found")
 		default:
 			writeError(w, http.StatusInternalServerError, "verification failed")
@@ -98,7 +112,7 @@ func (h *Handler) VerifyChallenge(w http.ResponseWriter, r *http.Request) {
 
 	writeJSON(w, http.StatusOK, VerifyResponse{
 		AccessToken: token.Token,
-		ExpiresAt:   token.ExpiresAt.Format("2006-01-02T15:04:05Z"),
+		ExpiresAt:   formatTime(token.ExpiresAt),
 		KeyID:       token.KeyID,
 		AccountID:   token.AccountID,
 	})
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/alphabot-ai/slashclaw/internal/auth"
+)
+
+type CapabilitiesResponse struct {
+	Algorithms []string         `json:"algorithms"`
+	Limits     CapabilityLimits `json:"limits"`
+	Features   map[string]bool  `json:"features"`
+}
+
+type CapabilityLimits struct {
+	TitleMinLength         int `json:"title_min_length"`
+	TitleMaxLength         int `json:"title_max_length"`
+	MaxTags                int `json:"max_tags"`           // 0 means no limit
+	TextMaxLength          int `json:"text_max_length"`    // 0 means no limit
+	CommentMaxLength       int `json:"comment_max_length"` // 0 means no limit
+	StoryRateLimit         int `json:"story_rate_limit"`
+	CommentRateLimit       int `json:"comment_rate_limit"`
+	VoteRateLimit          int `json:"vote_rate_limit"`
+	RateLimitWindowSeconds int `json:"rate_limit_window_seconds"`
+	PostCooldownSeconds    int `json:"post_cooldown_seconds"`
+	EditWindowSeconds      int `json:"edit_window_seconds"`
+	MaxCommentDepth        int `json:"max_comment_depth"`
+	ChallengeTTLSeconds    int `json:"challenge_ttl_seconds"`
+	TokenTTLSeconds        int `json:"token_ttl_seconds"`
+}
+
+// Capabilities handles GET /api/capabilities
+func (h *Handler) Capabilities(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, CapabilitiesResponse{
+		Algorithms: auth.SupportedAlgorithms(),
+		Limits: CapabilityLimits{
+			TitleMinLength:         h.cfg.TitleMinLength,
+			TitleMaxLength:         h.cfg.TitleMaxLength,
+			MaxTags:                h.cfg.MaxTags,
+			TextMaxLength:          h.cfg.MaxTextLength,
+			CommentMaxLength:       h.cfg.MaxCommentLength,
+			StoryRateLimit:         h.cfg.StoryRateLimit,
+			CommentRateLimit:       h.cfg.CommentRateLimit,
+			VoteRateLimit:          h.cfg.VoteRateLimit,
+			RateLimitWindowSeconds: int(h.cfg.RateLimitWindow.Seconds()),
+			PostCooldownSeconds:    int(h.cfg.PostCooldown.Seconds()),
+			EditWindowSeconds:      int(h.cfg.EditWindow.Seconds()),
+			MaxCommentDepth:        h.cfg.MaxCommentDepth,
+			ChallengeTTLSeconds:    int(h.cfg.ChallengeTTL.Seconds()),
+			TokenTTLSeconds:        int(h.cfg.TokenTTL.Seconds()),
+		},
+		Features: map[string]bool{
+			"accounts":                  true,
+			"comment_editing":           h.cfg.EditWindow > 0,
+			"comment_pagination":        true,
+			"reply_expansion":           true,
+			"vote_lookup":               true,
+			"vote_retraction":           true,
+			"request_schemas":           true,
+			"idempotent_account_create": h.cfg.IdempotentAccountCreate,
+			"url_and_text":              h.cfg.AllowURLAndText,
+		},
+	})
+}
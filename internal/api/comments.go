@@ -2,8 +2,12 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/alphabot-ai/slashclaw/internal/render"
 	"github.com/alphabot-ai/slashclaw/internal/store"
 )
 
@@ -17,22 +21,33 @@ type CreateCommentResponse struct {
 	ID string `json:"id"`
 }
 
+type UpdateCommentRequest struct {
+	Text string `json:"text"`
+}
+
+type DeleteCommentResponse struct {
+	OK bool `json:"ok"`
+}
+
 type ListCommentsResponse struct {
-	Comments []*store.Comment `json:"comments"`
+	Comments   []*store.Comment `json:"comments"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+	Total      *int             `json:"total,omitempty"` // the story's visible comments; only on a story's listing, not replies
 }
 
 // CreateComment handles POST /api/comments
 func (h *Handler) CreateComment(w http.ResponseWriter, r *http.Request) {
 	// Rate limit check
-	allowed, retryAfter := h.checkRateLimit(r, "comment", h.cfg.CommentRateLimit)
-	if !allowed {
-		writeRateLimited(w, retryAfter)
+	rl := h.checkRateLimit(r, "comment", h.cfg.CommentRateLimit)
+	rl.writeHeaders(w)
+	if !rl.Allowed {
+		writeRateLimited(w, rl.RetryAfter)
 		return
 	}
 
 	var req CreateCommentRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, http.StatusBadRequest, "invalid JSON")
+		writeBodyError(w, err, "invalid JSON")
 		return
 	}
 
@@ -45,6 +60,10 @@ func (h *Handler) CreateComment(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, "text is required")
 		return
 	}
+	if msg := checkMaxLength("text", req.Text, h.cfg.MaxCommentLength); msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
 
 	// Verify story exists
 	story, err := h.store.GetStory(r.Context(), req.StoryID)
@@ -72,6 +91,10 @@ func (h *Handler) CreateComment(w http.ResponseWriter, r *http.Request) {
 			writeError(w, http.StatusBadRequest, "parent comment is from a different story")
 			return
 		}
+		if h.cfg.MaxCommentDepth > 0 && parent.Depth+1 > h.cfg.MaxCommentDepth {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("thread is too deep; replies may nest at most %d levels", h.cfg.MaxCommentDepth))
+			return
+		}
 	}
 
 	// Get auth info from context (set by RequireAuth middleware)
@@ -86,17 +109,114 @@ func (h *Handler) CreateComment(w http.ResponseWriter, r *http.Request) {
 		AgentVerified: agentVerified,
 	}
 
-	if err := h.store.CreateComment(r.Context(), comment); err != nil {
+	// Insert the comment and count it on its story together, so a failure
+	// can't leave the story's comment count off by one
+	err = h.store.WithTx(r.Context(), func(tx store.Store) error {
+		if err := tx.CreateComment(r.Context(), comment); err != nil {
+			return err
+		}
+		return tx.UpdateStoryCommentCount(r.Context(), req.StoryID, 1)
+	})
+	if err != nil {
 		writeError(w, http.StatusInternalServerError, "failed to create comment")
 		return
 	}
 
-	// Update story comment count
-	h.store.UpdateStoryCommentCount(r.Context(), req.StoryID, 1)
-
 	writeJSON(w, http.StatusCreated, CreateCommentResponse{ID: comment.ID})
 }
 
+// UpdateComment handles PATCH /api/comments/{id}
+func (h *Handler) UpdateComment(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		writeError(w, http.StatusBadRequest, "comment id required")
+		return
+	}
+
+	var req UpdateCommentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBodyError(w, err, "invalid JSON")
+		return
+	}
+	if req.Text == "" {
+		writeError(w, http.StatusBadRequest, "text is required")
+		return
+	}
+	if msg := checkMaxLength("text", req.Text, h.cfg.MaxCommentLength); msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	comment, err := h.store.GetComment(r.Context(), id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+	if comment == nil {
+		writeError(w, http.StatusNotFound, "comment not found")
+		return
+	}
+
+	// Only the author may edit, and only shortly after posting
+	agentID, _, _ := GetAuthFromContext(r.Context())
+	if comment.AgentID == "" || comment.AgentID != agentID {
+		writeError(w, http.StatusForbidden, "not authorized to edit this comment")
+		return
+	}
+	if time.Since(comment.CreatedAt) > h.cfg.EditWindow {
+		writeError(w, http.StatusConflict, "edit window has passed")
+		return
+	}
+
+	if err := h.store.UpdateCommentText(r.Context(), id, req.Text); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to update comment")
+		return
+	}
+
+	updated, err := h.store.GetComment(r.Context(), id)
+	if err != nil || updated == nil {
+		writeError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, updated)
+}
+
+// DeleteComment handles DELETE /api/comments/{id}, letting an author retract
+// their own comment. The comment stays in its thread so replies keep their
+// place, but its author is tombstoned, along with its text under
+// SCRUB_DELETED_COMMENTS.
+func (h *Handler) DeleteComment(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		writeError(w, http.StatusBadRequest, "comment id required")
+		return
+	}
+
+	comment, err := h.store.GetComment(r.Context(), id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+	if comment == nil {
+		writeError(w, http.StatusNotFound, "comment not found")
+		return
+	}
+
+	agentID, _, _ := GetAuthFromContext(r.Context())
+	if comment.AgentID == "" || comment.AgentID != agentID {
+		writeError(w, http.StatusForbidden, "not authorized to delete this comment")
+		return
+	}
+
+	if err := h.store.AnonymizeComment(r.Context(), id, h.cfg.ScrubDeleted); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to delete comment")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, DeleteCommentResponse{OK: true})
+}
+
 // ListComments handles GET /api/stories/{id}/comments
 func (h *Handler) ListComments(w http.ResponseWriter, r *http.Request) {
 	storyID := r.PathValue("id")
@@ -118,18 +238,27 @@ func (h *Handler) ListComments(w http.ResponseWriter, r *http.Request) {
 
 	query := r.URL.Query()
 
-	// Parse sort
+	// Parse sort and view, falling back to the configured API defaults
 	sortStr := query.Get("sort")
+	if sortStr == "" {
+		sortStr = h.cfg.APICommentSort
+	}
 	var sort store.SortOrder
 	switch sortStr {
 	case "new":
 		sort = store.SortNew
+	case "old":
+		sort = store.SortOld
+	case "controversial":
+		sort = store.SortControversial
 	default:
 		sort = store.SortTop
 	}
 
-	// Parse view
 	viewStr := query.Get("view")
+	if viewStr == "" {
+		viewStr = h.cfg.APICommentView
+	}
 	var view store.ViewMode
 	switch viewStr {
 	case "flat":
@@ -143,11 +272,126 @@ func (h *Handler) ListComments(w http.ResponseWriter, r *http.Request) {
 		View: view,
 	}
 
-	comments, err := h.store.ListComments(r.Context(), storyID, opts)
+	// The flat view is paginated by comment; the tree view returns as many
+	// whole threads as fit under the cap, with a cursor for the rest
+	opts.Cursor = query.Get("cursor")
+	if view == store.ViewFlat {
+		opts.Limit = 100
+		if limitStr := query.Get("limit"); limitStr != "" {
+			if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 500 {
+				opts.Limit = l
+			}
+		}
+	} else {
+		opts.MaxComments = h.cfg.MaxTreeComments
+	}
+
+	comments, nextCursor, err := h.store.ListComments(r.Context(), storyID, opts)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+	total, err := h.store.CountComments(r.Context(), storyID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+	if query.Get("format") == "html" {
+		renderComments(comments)
+	}
+	if err := h.attachAuthors(r.Context(), nil, comments); err != nil {
+		writeError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+	if comments == nil {
+		comments = []*store.Comment{}
+	}
+
+	writeJSON(w, http.StatusOK, ListCommentsResponse{
+		Comments:   comments,
+		NextCursor: nextCursor,
+		Total:      &total,
+	})
+}
+
+// renderComments fills in TextHTML for comments and their nested replies
+func renderComments(comments []*store.Comment) {
+	for _, c := range comments {
+		c.TextHTML = render.Markdown(c.Text)
+		renderComments(c.Children)
+	}
+}
+
+// ListReplies handles GET /api/comments/{id}/replies
+func (h *Handler) ListReplies(w http.ResponseWriter, r *http.Request) {
+	commentID := r.PathValue("id")
+	if commentID == "" {
+		writeError(w, http.StatusBadRequest, "comment id required")
+		return
+	}
+
+	// Verify parent comment exists
+	parent, err := h.store.GetComment(r.Context(), commentID)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "database error")
 		return
 	}
+	if parent == nil || parent.Hidden {
+		writeError(w, http.StatusNotFound, "comment not found")
+		return
+	}
+
+	query := r.URL.Query()
+
+	// Parse sort
+	var sort store.SortOrder
+	switch query.Get("sort") {
+	case "new":
+		sort = store.SortNew
+	case "old":
+		sort = store.SortOld
+	case "controversial":
+		sort = store.SortControversial
+	default:
+		sort = store.SortTop
+	}
+
+	// Direct replies by default; view=tree nests each reply's subtree
+	view := store.ViewFlat
+	if query.Get("view") == "tree" {
+		view = store.ViewTree
+	}
+
+	opts := store.CommentListOptions{
+		Sort:   sort,
+		View:   view,
+		Limit:  100,
+		Cursor: query.Get("cursor"),
+	}
+	if limitStr := query.Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 500 {
+			opts.Limit = l
+		}
+	}
+
+	replies, nextCursor, err := h.store.ListReplies(r.Context(), commentID, opts)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+	if query.Get("format") == "html" {
+		renderComments(replies)
+	}
+	if err := h.attachAuthors(r.Context(), nil, replies); err != nil {
+		writeError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+	if replies == nil {
+		replies = []*store.Comment{}
+	}
 
-	writeJSON(w, http.StatusOK, ListCommentsResponse{Comments: comments})
+	writeJSON(w, http.StatusOK, ListCommentsResponse{
+		Comments:   replies,
+		NextCursor: nextCursor,
+	})
 }
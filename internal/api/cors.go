@@ -0,0 +1,68 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/alphabot-ai/slashclaw/internal/config"
+)
+
+// Methods and request headers a cross-origin caller may use, and the response
+// headers beyond the CORS-safelisted ones it may read
+const (
+	corsAllowMethods  = "GET, POST, PATCH, DELETE, OPTIONS"
+	corsAllowHeaders  = "Authorization, Content-Type, Idempotency-Key, If-None-Match, X-Agent-Id"
+	corsExposeHeaders = "ETag, Retry-After, Idempotent-Replayed, X-RateLimit-Limit, X-RateLimit-Remaining, X-RateLimit-Reset"
+)
+
+// CORS returns middleware that lets browsers call the API from the origins in
+// cfg.CORSOrigins, where "*" allows any origin. Preflight responses carry
+// Access-Control-Max-Age so browsers can cache them. Credentials are only
+// advertised to an explicitly listed origin: browsers reject credentials
+// alongside a wildcard, and echoing any origin back would allow every site.
+func CORS(cfg *config.Config) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" || len(cfg.CORSOrigins) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// The response depends on the Origin whenever one is listed by name
+			w.Header().Add("Vary", "Origin")
+
+			var allowOrigin string
+			switch {
+			case slices.Contains(cfg.CORSOrigins, origin):
+				allowOrigin = origin
+			case slices.Contains(cfg.CORSOrigins, "*"):
+				allowOrigin = "*"
+			default:
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			h := w.Header()
+			h.Set("Access-Control-Allow-Origin", allowOrigin)
+			if cfg.CORSAllowCredentials && allowOrigin != "*" {
+				h.Set("Access-Control-Allow-Credentials", "true")
+			}
+
+			// Preflight
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				h.Set("Access-Control-Allow-Methods", corsAllowMethods)
+				h.Set("Access-Control-Allow-Headers", corsAllowHeaders)
+				if cfg.CORSMaxAge > 0 {
+					h.Set("Access-Control-Max-Age", strconv.Itoa(int(cfg.CORSMaxAge.Seconds())))
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			h.Set("Access-Control-Expose-Headers", corsExposeHeaders)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alphabot-ai/slashclaw/internal/config"
+)
+
+func corsRequest(t *testing.T, cfg *config.Config, method, origin string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(method, "/api/stories", nil)
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	if method == http.MethodOptions {
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	}
+
+	rec := httptest.NewRecorder()
+	CORS(cfg)(next).ServeHTTP(rec, req)
+	return rec
+}
+
+func TestCORSPreflight(t *testing.T) {
+	cfg := &config.Config{
+		CORSOrigins:          []string{"https://app.example.com"},
+		CORSMaxAge:           10 * time.Minute,
+		CORSAllowCredentials: true,
+	}
+
+	rec := corsRequest(t, cfg, http.MethodOptions, "https://app.example.com")
+
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	if got := rec.Header().Get("Access-Control-Max-Age"); got != "600" {
+		t.Errorf("Access-Control-Max-Age = %q, want \"600\"", got)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want the request origin", got)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Credentials"); got != "true" {
+		t.Errorf("Access-Control-Allow-Credentials = %q, want \"true\" for a listed origin", got)
+	}
+	if rec.Header().Get("Access-Control-Allow-Methods") == "" {
+		t.Error("preflight should list allowed methods")
+	}
+	allowed := rec.Header().Get("Access-Control-Allow-Headers")
+	for _, header := range []string{"Authorization", "X-Agent-Id", "Content-Type"} {
+		if !strings.Contains(allowed, header) {
+			t.Errorf("Access-Control-Allow-Headers = %q, want it to include %s", allowed, header)
+		}
+	}
+}
+
+func TestCORSExposesResponseHeaders(t *testing.T) {
+	cfg := &config.Config{CORSOrigins: []string{"https://app.example.com"}}
+
+	rec := corsRequest(t, cfg, http.MethodGet, "https://app.example.com")
+	exposed := rec.Header().Get("Access-Control-Expose-Headers")
+	for _, header := range []string{"ETag", "Retry-After", "Idempotent-Replayed", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset"} {
+		if !strings.Contains(exposed, header) {
+			t.Errorf("Access-Control-Expose-Headers = %q, want it to include %s", exposed, header)
+		}
+	}
+}
+
+func TestCORSWildcardNoCredentials(t *testing.T) {
+	cfg := &config.Config{
+		CORSOrigins:          []string{"*"},
+		CORSMaxAge:           time.Hour,
+		CORSAllowCredentials: true,
+	}
+
+	for _, method := range []string{http.MethodOptions, http.MethodGet} {
+		t.Run(method, func(t *testing.T) {
+			rec := corsRequest(t, cfg, method, "https://anywhere.example.com")
+
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+				t.Errorf("Access-Control-Allow-Origin = %q, want \"*\"", got)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Credentials"); got != "" {
+				t.Errorf("Access-Control-Allow-Credentials = %q, want none with a wildcard origin", got)
+			}
+		})
+	}
+
+	rec := corsRequest(t, cfg, http.MethodOptions, "https://anywhere.example.com")
+	if got := rec.Header().Get("Access-Control-Max-Age"); got != "3600" {
+		t.Errorf("Access-Control-Max-Age = %q, want \"3600\"", got)
+	}
+}
+
+func TestCORSUnlistedOrigin(t *testing.T) {
+	cfg := &config.Config{CORSOrigins: []string{"https://app.example.com"}, CORSMaxAge: time.Minute}
+
+	rec := corsRequest(t, cfg, http.MethodGet, "https://evil.example.com")
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want none for an unlisted origin", got)
+	}
+}
+
+func TestCORSDisabled(t *testing.T) {
+	rec := corsRequest(t, &config.Config{}, http.MethodGet, "https://app.example.com")
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want none when CORS is not configured", got)
+	}
+}
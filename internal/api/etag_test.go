@@ -0,0 +1,71 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alphabot-ai/slashclaw/internal/store"
+)
+
+func TestStoryETags(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+	ctx := context.Background()
+
+	story := &store.Story{Title: "Polled Story", Text: "Content"}
+	ts.store.CreateStory(ctx, story)
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		target  string
+	}{
+		{"story", ts.handler.GetStory, "/api/stories/" + story.ID},
+		{"list", ts.handler.ListStories, "/api/stories?sort=new"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+				req.SetPathValue("id", story.ID)
+				if ifNoneMatch != "" {
+					req.Header.Set("If-None-Match", ifNoneMatch)
+				}
+				rec := httptest.NewRecorder()
+				tt.handler(rec, req)
+				return rec
+			}
+
+			first := get("")
+			etag := first.Header().Get("ETag")
+			if first.Code != http.StatusOK || etag == "" {
+				t.Fatalf("status = %d, ETag = %q; want 200 with an ETag", first.Code, etag)
+			}
+
+			repeat := get(etag)
+			if repeat.Code != http.StatusNotModified {
+				t.Fatalf("repeat status = %d, want %d", repeat.Code, http.StatusNotModified)
+			}
+			if repeat.Body.Len() != 0 {
+				t.Errorf("304 body = %q, want empty", repeat.Body.String())
+			}
+			if got := get(`"other", W/` + etag).Code; got != http.StatusNotModified {
+				t.Errorf("weak match in a list: status = %d, want %d", got, http.StatusNotModified)
+			}
+
+			// A vote changes the score, so the old tag no longer matches
+			if _, err := ts.store.AdjustScore(ctx, "story", story.ID, 1); err != nil {
+				t.Fatalf("AdjustScore: %v", err)
+			}
+			changed := get(etag)
+			if changed.Code != http.StatusOK {
+				t.Fatalf("after a score change: status = %d, want %d", changed.Code, http.StatusOK)
+			}
+			if got := changed.Header().Get("ETag"); got == etag {
+				t.Error("ETag should change with the score")
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/alphabot-ai/slashclaw/internal/auth"
+	"github.com/alphabot-ai/slashclaw/internal/store"
+)
+
+// Reasons a story or comment can be flagged for
+var flagReasons = map[string]bool{
+	"spam":      true,
+	"abuse":     true,
+	"off_topic": true,
+	"duplicate": true,
+	"other":     true,
+}
+
+type CreateFlagRequest struct {
+	TargetType string `json:"target_type"` // "story" or "comment"
+	TargetID   string `json:"target_id"`
+	Reason     string `json:"reason"` // spam, abuse, off_topic, duplicate, or other
+}
+
+type CreateFlagResponse struct {
+	OK bool `json:"ok"`
+}
+
+type ListFlagsResponse struct {
+	Flags []*store.Flag `json:"flags"`
+}
+
+// CreateFlag handles POST /api/flags. Each agent and each IP counts once per
+// target; once a target has FlagThreshold distinct flags it is hidden.
+func (h *Handler) CreateFlag(w http.ResponseWriter, r *http.Request) {
+	// Flags share the vote budget; both are one-click reactions
+	rl := h.checkRateLimit(r, "flag", h.cfg.VoteRateLimit)
+	rl.writeHeaders(w)
+	if !rl.Allowed {
+		writeRateLimited(w, rl.RetryAfter)
+		return
+	}
+
+	var req CreateFlagRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBodyError(w, err, "invalid JSON")
+		return
+	}
+
+	if req.TargetType != "story" && req.TargetType != "comment" {
+		writeError(w, http.StatusBadRequest, "target_type must be 'story' or 'comment'")
+		return
+	}
+	if req.TargetID == "" {
+		writeError(w, http.StatusBadRequest, "target_id is required")
+		return
+	}
+	if !flagReasons[req.Reason] {
+		writeError(w, http.StatusBadRequest, "reason must be one of: spam, abuse, off_topic, duplicate, other")
+		return
+	}
+
+	// Only visible content can be flagged
+	var found bool
+	if req.TargetType == "story" {
+		story, err := h.store.GetStory(r.Context(), req.TargetID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "database error")
+			return
+		}
+		found = story != nil
+	} else {
+		comment, err := h.store.GetComment(r.Context(), req.TargetID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "database error")
+			return
+		}
+		found = comment != nil
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, req.TargetType+" not found")
+		return
+	}
+
+	agentID, _, _ := GetAuthFromContext(r.Context())
+	count, err := h.store.CreateFlag(r.Context(), &store.Flag{
+		TargetType: req.TargetType,
+		TargetID:   req.TargetID,
+		Reason:     req.Reason,
+		IPHash:     auth.HashIP(h.getClientIP(r)),
+		AgentID:    agentID,
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to record flag")
+		return
+	}
+
+	if h.cfg.FlagThreshold > 0 && count >= h.cfg.FlagThreshold {
+		if req.TargetType == "story" {
+			err = h.store.HideStory(r.Context(), req.TargetID)
+		} else {
+			err = h.store.HideComment(r.Context(), req.TargetID)
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to hide content")
+			return
+		}
+		log.Printf("flags: hid %s %s after %d flags (latest reason %q)", req.TargetType, req.TargetID, count, req.Reason)
+	}
+
+	writeJSON(w, http.StatusOK, CreateFlagResponse{OK: true})
+}
+
+// ListFlags handles GET /api/admin/flags, newest first
+func (h *Handler) ListFlags(w http.ResponseWriter, r *http.Request) {
+	// Check admin auth
+	if !h.isAdmin(r, auth.ScopeFlagsRead) {
+		writeError(w, http.StatusUnauthorized, "admin authentication required")
+		return
+	}
+
+	limit := 100
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 500 {
+			limit = l
+		}
+	}
+
+	flags, err := h.store.ListFlags(r.Context(), limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+	if flags == nil {
+		flags = []*store.Flag{}
+	}
+
+	writeJSON(w, http.StatusOK, ListFlagsResponse{Flags: flags})
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"log"
+	"net/http"
+)
+
+// Version is the build version reported by the health endpoint. It is set at
+// build time with -ldflags "-X github.com/alphabot-ai/slashclaw/internal/api.Version=...".
+var Version = "dev"
+
+type HealthResponse struct {
+	Status  string `json:"status"`
+	Version string `json:"version,omitempty"` // omitted when unhealthy
+}
+
+// Health handles GET /health. It reports 503 when the database can't be
+// reached, so load balancers stop routing to a broken instance.
+func (h *Handler) Health(w http.ResponseWriter, r *http.Request) {
+	h.writeHealth(w, r, h.store.Ping(r.Context()))
+}
+
+// Ready handles GET /ready. Like Health, but the instance is only ready once
+// its database schema is fully migrated too.
+func (h *Handler) Ready(w http.ResponseWriter, r *http.Request) {
+	err := h.store.Ping(r.Context())
+	if err == nil {
+		err = h.store.CheckSchema(r.Context())
+	}
+	h.writeHealth(w, r, err)
+}
+
+func (h *Handler) writeHealth(w http.ResponseWriter, r *http.Request, err error) {
+	if err != nil {
+		log.Printf("health: %s failed: %v", r.URL.Path, err)
+	}
+
+	// Legacy monitors expect a bare "ok" body
+	if r.URL.Query().Get("plain") == "1" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("unhealthy"))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok"))
+		return
+	}
+
+	if err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, HealthResponse{Status: "unhealthy"})
+		return
+	}
+	writeJSON(w, http.StatusOK, HealthResponse{
+		Status:  "ok",
+		Version: Version,
+	})
+}
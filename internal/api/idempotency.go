@@ -0,0 +1,104 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/alphabot-ai/slashclaw/internal/auth"
+	"github.com/alphabot-ai/slashclaw/internal/store"
+)
+
+// maxIdempotencyKeyLength bounds the Idempotency-Key header
+const maxIdempotencyKeyLength = 255
+
+// responseCapture passes a response through while keeping a copy of its body
+type responseCapture struct {
+	statusRecorder
+	body bytes.Buffer
+}
+
+func (rc *responseCapture) Write(b []byte) (int, error) {
+	rc.body.Write(b)
+	return rc.statusRecorder.Write(b)
+}
+
+// Idempotent returns middleware that honors an Idempotency-Key header on a
+// create endpoint. The first successful response to a key is saved for
+// IdempotencyTTL; repeating the request with the same key replays it with a
+// 200 instead of creating another resource. Keys are scoped to action and to
+// the agent, or the IP for anonymous requests. Failed requests aren't saved,
+// so they can be retried with the same key. Concurrent requests with one key
+// are not serialized; only a retry after the first completes is caught.
+func (h *Handler) Idempotent(action string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" || h.cfg.IdempotencyTTL <= 0 {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			writeError(w, http.StatusBadRequest, "Idempotency-Key is too long")
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeBodyError(w, err, "failed to read request body")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(body)
+		requestHash := hex.EncodeToString(sum[:])
+
+		scope := action + ":ip:" + auth.HashIP(h.getClientIP(r))
+		if agentID, _, _ := GetAuthFromContext(r.Context()); agentID != "" {
+			scope = action + ":agent:" + agentID
+		}
+
+		saved, err := h.store.GetIdempotencyKey(r.Context(), scope, key)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "database error")
+			return
+		}
+		if saved != nil {
+			if saved.RequestHash != requestHash {
+				writeError(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request")
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(saved.Response))
+			return
+		}
+
+		rc := &responseCapture{statusRecorder: statusRecorder{ResponseWriter: w}}
+		next(rc, r)
+		if rc.status < 200 || rc.status >= 300 {
+			return
+		}
+
+		var created struct {
+			ID string `json:"id"`
+		}
+		json.Unmarshal(rc.body.Bytes(), &created)
+		err = h.store.CreateIdempotencyKey(r.Context(), &store.IdempotencyKey{
+			Scope:       scope,
+			Key:         key,
+			RequestHash: requestHash,
+			ResourceID:  created.ID,
+			Response:    rc.body.String(),
+			ExpiresAt:   time.Now().Add(h.cfg.IdempotencyTTL),
+		})
+		if err != nil {
+			// The resource exists either way; only a retry would miss the replay
+			log.Printf("idempotency: saving key for %s: %v", scope, err)
+		}
+	}
+}
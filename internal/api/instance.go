@@ -0,0 +1,40 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/alphabot-ai/slashclaw/internal/auth"
+	"github.com/alphabot-ai/slashclaw/internal/store"
+)
+
+// InstanceResponse describes this instance to other instances and directories
+type InstanceResponse struct {
+	Name             string              `json:"name"`
+	BaseURL          string              `json:"base_url"`
+	Software         string              `json:"software"`
+	Version          string              `json:"version"`
+	Algorithms       []string            `json:"algorithms"`
+	OpenRegistration bool                `json:"open_registration"`
+	Counts           store.ContentCounts `json:"counts"`
+}
+
+// Instance handles GET /.well-known/slashclaw
+func (h *Handler) Instance(w http.ResponseWriter, r *http.Request) {
+	counts, err := h.store.CountContent(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, InstanceResponse{
+		Name:       h.cfg.InstanceName,
+		BaseURL:    h.cfg.BaseURL,
+		Software:   "slashclaw",
+		Version:    Version,
+		Algorithms: auth.SupportedAlgorithms(),
+		// Any agent with a keypair can authenticate; there is no invite or
+		// approval step
+		OpenRegistration: true,
+		Counts:           *counts,
+	})
+}
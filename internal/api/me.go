@@ -0,0 +1,95 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/alphabot-ai/slashclaw/internal/store"
+)
+
+// Moderation status of the caller's own content
+const (
+	statusPublished = "published"
+	statusPending   = "pending" // awaiting moderator approval
+	statusHidden    = "hidden"  // hidden by a moderator, flags, or its author
+)
+
+type MyStory struct {
+	*store.Story
+	Status string `json:"status"` // published, pending, or hidden
+}
+
+type MyComment struct {
+	*store.Comment
+	Status string `json:"status"` // published or hidden
+}
+
+type MyStoriesResponse struct {
+	Stories    []*MyStory `json:"stories"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+}
+
+type MyCommentsResponse struct {
+	Comments   []*MyComment `json:"comments"`
+	NextCursor string       `json:"next_cursor,omitempty"`
+}
+
+// MyStories handles GET /api/me/stories, listing the caller's own stories,
+// newest first. Unlike the public listings it includes hidden and pending
+// stories, each marked with its status. A caller signed in to an account
+// sees the stories of every agent that has used the account.
+func (h *Handler) MyStories(w http.ResponseWriter, r *http.Request) {
+	agentID, _, accountID := GetAuthFromContext(r.Context())
+
+	stories, nextCursor, err := h.store.ListOwnStories(r.Context(), agentID, accountID, ownListOptions(r))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+
+	items := make([]*MyStory, len(stories))
+	for i, story := range stories {
+		status := statusPublished
+		if story.Pending {
+			status = statusPending
+		} else if story.Hidden {
+			status = statusHidden
+		}
+		items[i] = &MyStory{Story: story, Status: status}
+	}
+	writeJSON(w, http.StatusOK, MyStoriesResponse{Stories: items, NextCursor: nextCursor})
+}
+
+// MyComments handles GET /api/me/comments, the comment counterpart of MyStories
+func (h *Handler) MyComments(w http.ResponseWriter, r *http.Request) {
+	agentID, _, accountID := GetAuthFromContext(r.Context())
+
+	comments, nextCursor, err := h.store.ListOwnComments(r.Context(), agentID, accountID, ownListOptions(r))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+
+	items := make([]*MyComment, len(comments))
+	for i, comment := range comments {
+		status := statusPublished
+		if comment.Hidden {
+			status = statusHidden
+		}
+		items[i] = &MyComment{Comment: comment, Status: status}
+	}
+	writeJSON(w, http.StatusOK, MyCommentsResponse{Comments: items, NextCursor: nextCursor})
+}
+
+// ownListOptions reads the limit and cursor of a self-listing request
+func ownListOptions(r *http.Request) store.ListOptions {
+	query := r.URL.Query()
+
+	opts := store.ListOptions{Limit: 30, Cursor: query.Get("cursor")}
+	if limitStr := query.Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 100 {
+			opts.Limit = l
+		}
+	}
+	return opts
+}
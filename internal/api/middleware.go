@@ -1,9 +1,19 @@
 package api
 
 import (
+	"bytes"
 	"context"
-	"log"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"math"
+	"mime"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/alphabot-ai/slashclaw/internal/config"
 )
 
 type contextKey string
@@ -12,6 +22,8 @@ const (
 	ContextKeyAgentID   contextKey = "agent_id"
 	ContextKeyVerified  contextKey = "verified"
 	ContextKeyAccountID contextKey = "account_id"
+
+	contextKeyRequestLog contextKey = "request_log"
 )
 
 // RequireAuth returns middleware that requires a valid auth token
@@ -30,6 +42,7 @@ func (h *Handler) RequireAuth(next http.HandlerFunc) http.HandlerFunc {
 		if token.AccountID != "" {
 			ctx = context.WithValue(ctx, ContextKeyAccountID, token.AccountID)
 		}
+		setLoggedAgent(ctx, token.AgentID)
 
 		next.ServeHTTP(w, r.WithContext(ctx))
 	}
@@ -47,12 +60,14 @@ func (h *Handler) OptionalAuth(next http.HandlerFunc) http.HandlerFunc {
 			if token.AccountID != "" {
 				ctx = context.WithValue(ctx, ContextKeyAccountID, token.AccountID)
 			}
+			setLoggedAgent(ctx, token.AgentID)
 		} else {
 			// Check for unverified agent ID header
 			agentID := r.Header.Get("X-Agent-Id")
 			if agentID != "" {
 				ctx = context.WithValue(ctx, ContextKeyAgentID, agentID)
 				ctx = context.WithValue(ctx, ContextKeyVerified, false)
+				setLoggedAgent(ctx, agentID)
 			}
 		}
 
@@ -74,11 +89,222 @@ func GetAuthFromContext(ctx context.Context) (agentID string, verified bool, acc
 	return
 }
 
-// LogRequests returns middleware that logs all incoming requests
-func LogRequests(next http.Handler) http.Handler {
+// requestLog collects details for a request's log line that are only known
+// further down the chain, such as who the auth middleware identified
+type requestLog struct {
+	agentID string
+}
+
+// setLoggedAgent records the request's agent for LogRequests, if it is logging
+func setLoggedAgent(ctx context.Context, agentID string) {
+	if rl, ok := ctx.Value(contextKeyRequestLog).(*requestLog); ok {
+		rl.agentID = agentID
+	}
+}
+
+// statusRecorder captures the status code and body size a handler writes
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	if sr.status == 0 {
+		sr.status = status
+	}
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	n, err := sr.ResponseWriter.Write(b)
+	sr.bytes += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
+// LogRequests returns middleware that logs one structured line per request
+// once it completes: method, path, status, response size, duration, client
+// IP, and the agent if one was identified. With cfg.LogBodies, write
+// requests also log the body the handler read, with credentials redacted.
+func LogRequests(logger *slog.Logger, cfg *config.Config) func(http.Handler) http.Handler {
+	logBodies := cfg.LogBodies
+	trustedProxies, _ := cfg.TrustedProxyPrefixes()
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rl := &requestLog{}
+			rec := &statusRecorder{ResponseWriter: w}
+
+			// The handler reads the body as usual; a copy of what it reads
+			// is kept for the log line
+			var body *cappedBuffer
+			if logBodies && r.Body != nil && isWriteMethod(r.Method) {
+				body = &cappedBuffer{max: maxLoggedBody}
+				r.Body = struct {
+					io.Reader
+					io.Closer
+				}{io.TeeReader(r.Body, body), r.Body}
+			}
+
+			next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), contextKeyRequestLog, rl)))
+
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+			attrs := []slog.Attr{
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.Int("status", rec.status),
+				slog.Int("bytes", rec.bytes),
+				slog.Duration("duration", time.Since(start)),
+				slog.String("ip", clientIP(r, trustedProxies)),
+			}
+			if rl.agentID != "" {
+				attrs = append(attrs, slog.String("agent_id", rl.agentID))
+			}
+			if body != nil && body.Len() > 0 {
+				attrs = append(attrs, slog.String("body", redactBody(r.Header.Get("Content-Type"), body)))
+			}
+			logger.LogAttrs(r.Context(), slog.LevelInfo, "request", attrs...)
+		})
+	}
+}
+
+// maxLoggedBody caps how much of a request body LogRequests keeps
+const maxLoggedBody = 8 << 10
+
+// redactedFields are body fields never written to the log
+var redactedFields = []string{"signature", "public_key", "access_token"}
+
+// isRedacted reports whether key names one of redactedFields. Like
+// encoding/json matching struct fields, it ignores case, so a handler can't
+// accept a key that is logged in the clear.
+func isRedacted(key string) bool {
+	for _, field := range redactedFields {
+		if strings.EqualFold(key, field) {
+			return true
+		}
+	}
+	return false
+}
+
+func isWriteMethod(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// cappedBuffer keeps the first max bytes written to it and discards the rest
+type cappedBuffer struct {
+	bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.Len(); len(p) > room {
+		b.Buffer.Write(p[:max(room, 0)])
+		b.truncated = true
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+// redactBody renders a logged request body with redactedFields masked. JSON
+// and form bodies are redacted field by field; anything that can't be parsed,
+// including a JSON body cut short by maxLoggedBody, is left out entirely
+// rather than risk logging a credential.
+func redactBody(contentType string, body *cappedBuffer) string {
+	const omitted = "[unparsed body omitted]"
+
+	if trimmed := bytes.TrimSpace(body.Bytes()); len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+		var v any
+		if body.truncated || json.Unmarshal(body.Bytes(), &v) != nil {
+			return omitted
+		}
+		out, _ := json.Marshal(redactJSON(v))
+		return string(out)
+	}
+
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType == "application/x-www-form-urlencoded" {
+		form, err := url.ParseQuery(body.String())
+		if err != nil || body.truncated {
+			return omitted
+		}
+		for key := range form {
+			if isRedacted(key) {
+				form[key] = []string{"[REDACTED]"}
+			}
+		}
+		return form.Encode()
+	}
+
+	return omitted
+}
+
+func redactJSON(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, field := range v {
+			if isRedacted(key) {
+				v[key] = "[REDACTED]"
+			} else {
+				v[key] = redactJSON(field)
+			}
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = redactJSON(item)
+		}
+	}
+	return v
+}
+
+// LimitBodies returns middleware capping the body of every POST, PUT, PATCH,
+// and DELETE request at cfg.MaxBodyBytes. Reading past the cap fails with an
+// *http.MaxBytesError, which handlers report as 413 via writeBodyError.
+func LimitBodies(cfg *config.Config) func(http.Handler) http.Handler {
+	limit := int64(cfg.MaxBodyBytes)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if limit > 0 && r.Body != nil && isWriteMethod(r.Method) {
+				r.Body = http.MaxBytesReader(w, r.Body, limit)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// GlobalRateLimit returns middleware capping how many requests one IP may
+// make across every route, read or write, so reads can't be used to hammer
+// the database. The per-action limits still apply on top. /health and /ready
+// are exempt so monitoring is never throttled.
+func (h *Handler) GlobalRateLimit(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("%s %s", r.Method, r.URL.Path)
+		if h.cfg.GlobalRateLimit <= 0 || r.URL.Path == "/health" || r.URL.Path == "/ready" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := "global:" + h.getClientIP(r)
+		if !h.limiter.Allow(key, h.cfg.GlobalRateLimit, h.cfg.GlobalWindow) {
+			// Round up so a wait under a second isn't reported as none
+			writeRateLimited(w, int(math.Ceil(h.limiter.RetryAfter(key, h.cfg.GlobalWindow).Seconds())))
+			return
+		}
+
 		next.ServeHTTP(w, r)
 	})
 }
-
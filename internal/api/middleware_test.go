@@ -1,47 +1,94 @@
 package api
 
 import (
-	"bytes"
 	"context"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/alphabot-ai/slashclaw/internal/config"
 )
 
-func TestLogRequests(t *testing.T) {
-	// Capture log output
-	var buf bytes.Buffer
-	log.SetOutput(&buf)
-	defer log.SetOutput(nil)
+// captureHandler is a slog.Handler that keeps every record it is given
+type captureHandler struct {
+	mu      sync.Mutex
+	records []slog.Record
+}
 
-	// Create a simple handler
-	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
+func (h *captureHandler) Enabled(context.Context, slog.Level) bool { return true }
+func (h *captureHandler) WithAttrs([]slog.Attr) slog.Handler       { return h }
+func (h *captureHandler) WithGroup(string) slog.Handler            { return h }
+
+func (h *captureHandler) Handle(_ context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.records = append(h.records, r.Clone())
+	return nil
+}
+
+// attrs flattens the single captured record's attributes
+func (h *captureHandler) attrs(t *testing.T) map[string]slog.Value {
+	t.Helper()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.records) != 1 {
+		t.Fatalf("captured %d records, want 1", len(h.records))
+	}
+	attrs := make(map[string]slog.Value)
+	h.records[0].Attrs(func(a slog.Attr) bool {
+		attrs[a.Key] = a.Value
+		return true
 	})
+	return attrs
+}
 
-	// Wrap with LogRequests
-	logged := LogRequests(handler)
+func TestLogRequests(t *testing.T) {
+	capture := &captureHandler{}
 
-	// Make a test request
-	req := httptest.NewRequest(http.MethodGet, "/api/stories", nil)
+	// OptionalAuth identifies the agent below the logging middleware
+	handler := (&Handler{}).OptionalAuth(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("hello"))
+	})
+	logged := LogRequests(slog.New(capture), &config.Config{})(handler)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/stories", nil)
+	req.Header.Set("X-Agent-Id", "logged-agent")
 	rec := httptest.NewRecorder()
 
 	logged.ServeHTTP(rec, req)
 
-	// Check response
-	if rec.Code != http.StatusOK {
-		t.Errorf("status = %d, want %d", rec.Code, http.StatusOK)
+	if rec.Code != http.StatusCreated {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusCreated)
 	}
 
-	// Check log output
-	logOutput := buf.String()
-	if !strings.Contains(logOutput, "GET") {
-		t.Error("log should contain HTTP method")
+	attrs := capture.attrs(t)
+	if got := attrs["method"].String(); got != http.MethodPost {
+		t.Errorf("method = %q, want POST", got)
+	}
+	if got := attrs["path"].String(); got != "/api/stories" {
+		t.Errorf("path = %q, want /api/stories", got)
 	}
-	if !strings.Contains(logOutput, "/api/stories") {
-		t.Error("log should contain request path")
+	if got := attrs["status"].Int64(); got != http.StatusCreated {
+		t.Errorf("status = %d, want %d", got, http.StatusCreated)
+	}
+	if got := attrs["bytes"].Int64(); got != 5 {
+		t.Errorf("bytes = %d, want 5", got)
+	}
+	if got := attrs["duration"].Duration(); got < 0 {
+		t.Errorf("duration = %v, want non-negative", got)
+	}
+	if got := attrs["ip"].String(); got != "192.0.2.1" {
+		t.Errorf("ip = %q, want 192.0.2.1", got)
+	}
+	if got := attrs["agent_id"].String(); got != "logged-agent" {
+		t.Errorf("agent_id = %q, want logged-agent", got)
 	}
 }
 
@@ -55,22 +102,149 @@ func TestLogRequestsDifferentMethods(t *testing.T) {
 
 	for _, method := range methods {
 		t.Run(method, func(t *testing.T) {
-			var buf bytes.Buffer
-			log.SetOutput(&buf)
-			defer log.SetOutput(nil)
+			capture := &captureHandler{}
 
-			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-				w.WriteHeader(http.StatusOK)
-			})
+			// Writing nothing is an implicit 200
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
 
-			logged := LogRequests(handler)
+			logged := LogRequests(slog.New(capture), &config.Config{})(handler)
 			req := httptest.NewRequest(method, "/test", nil)
 			rec := httptest.NewRecorder()
 
 			logged.ServeHTTP(rec, req)
 
-			if !strings.Contains(buf.String(), method) {
-				t.Errorf("log should contain method %s", method)
+			attrs := capture.attrs(t)
+			if got := attrs["method"].String(); got != method {
+				t.Errorf("method = %q, want %s", got, method)
+			}
+			if got := attrs["status"].Int64(); got != http.StatusOK {
+				t.Errorf("status = %d, want %d", got, http.StatusOK)
+			}
+			if _, ok := attrs["agent_id"]; ok {
+				t.Error("agent_id logged for an anonymous request")
+			}
+		})
+	}
+}
+
+func TestLogRequestsBodies(t *testing.T) {
+	tests := []struct {
+		name        string
+		logBodies   bool
+		method      string
+		path        string
+		contentType string
+		body        string
+		wantLogged  []string
+		wantHidden  []string
+	}{
+		{
+			name:       "story",
+			logBodies:  true,
+			method:     http.MethodPost,
+			path:       "/api/stories",
+			body:       `{"title":"Hello","url":"https://example.com"}`,
+			wantLogged: []string{`"title":"Hello"`, `"url":"https://example.com"`},
+		},
+		{
+			name:       "verify redacted",
+			logBodies:  true,
+			method:     http.MethodPost,
+			path:       "/api/auth/verify",
+			body:       `{"agent_id":"a1","public_key":"pk-secret","challenge":"c1","signature":"sig-secret"}`,
+			wantLogged: []string{`"agent_id":"a1"`, `"signature":"[REDACTED]"`, `"public_key":"[REDACTED]"`},
+			wantHidden: []string{"sig-secret", "pk-secret"},
+		},
+		{
+			name:       "mixed case redacted",
+			logBodies:  true,
+			method:     http.MethodPost,
+			path:       "/api/auth/verify",
+			body:       `{"agent_id":"a1","Signature":"sig-secret","PUBLIC_KEY":"pk-secret","Access_Token":"tok-secret"}`,
+			wantLogged: []string{`"agent_id":"a1"`, `"Signature":"[REDACTED]"`, `"PUBLIC_KEY":"[REDACTED]"`, `"Access_Token":"[REDACTED]"`},
+			wantHidden: []string{"sig-secret", "pk-secret", "tok-secret"},
+		},
+		{
+			name:       "nested token redacted",
+			logBodies:  true,
+			method:     http.MethodPost,
+			path:       "/api/test",
+			body:       `{"items":[{"access_token":"tok-secret"}]}`,
+			wantLogged: []string{`"access_token":"[REDACTED]"`},
+			wantHidden: []string{"tok-secret"},
+		},
+		{
+			name:        "form redacted",
+			logBodies:   true,
+			method:      http.MethodPost,
+			path:        "/submit",
+			contentType: "application/x-www-form-urlencoded",
+			body:        "title=Hello&signature=sig-secret&Public_Key=pk-secret",
+			wantLogged:  []string{"title=Hello", "signature=%5BREDACTED%5D", "Public_Key=%5BREDACTED%5D"},
+			wantHidden:  []string{"sig-secret", "pk-secret"},
+		},
+		{
+			name:       "unparsable omitted",
+			logBodies:  true,
+			method:     http.MethodPost,
+			path:       "/api/auth/verify",
+			body:       `{"signature":"sig-secret"`,
+			wantLogged: []string{"[unparsed body omitted]"},
+			wantHidden: []string{"sig-secret"},
+		},
+		{
+			name:       "disabled",
+			method:     http.MethodPost,
+			path:       "/api/stories",
+			body:       `{"title":"Hello"}`,
+			wantHidden: []string{"Hello"},
+		},
+		{
+			name:       "read request",
+			logBodies:  true,
+			method:     http.MethodGet,
+			path:       "/api/stories",
+			body:       `{"title":"Hello"}`,
+			wantHidden: []string{"Hello"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			capture := &captureHandler{}
+
+			// The handler must still see the whole body
+			var read string
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, _ := io.ReadAll(r.Body)
+				read = string(b)
+			})
+			logged := LogRequests(slog.New(capture), &config.Config{LogBodies: tt.logBodies})(handler)
+
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			logged.ServeHTTP(httptest.NewRecorder(), req)
+
+			if read != tt.body {
+				t.Errorf("handler read %q, want %q", read, tt.body)
+			}
+
+			value, ok := capture.attrs(t)["body"]
+			if len(tt.wantLogged) == 0 && ok {
+				t.Errorf("body = %q, want it not logged", value)
+			}
+			body := value.String()
+			for _, want := range tt.wantLogged {
+				if !strings.Contains(body, want) {
+					t.Errorf("body = %q, want it to contain %q", body, want)
+				}
+			}
+			for _, secret := range tt.wantHidden {
+				if strings.Contains(body, secret) {
+					t.Errorf("body = %q, should not contain %q", body, secret)
+				}
 			}
 		})
 	}
@@ -136,3 +310,164 @@ func TestGetAuthFromContext(t *testing.T) {
 		})
 	}
 }
+
+func TestGlobalRateLimit(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+	ts.handler.cfg.GlobalRateLimit = 3
+	ts.handler.cfg.GlobalWindow = time.Minute
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/stories", ts.handler.ListStories)
+	mux.HandleFunc("GET /health", ts.handler.Health)
+	mux.HandleFunc("POST /api/auth/challenge", ts.handler.CreateChallenge)
+	handler := ts.handler.GlobalRateLimit(mux)
+
+	send := func(method, path, ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(`{"agent_id":"flooder","alg":"ed25519"}`))
+		req.RemoteAddr = ip + ":12345"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	get := func(path, ip string) *httptest.ResponseRecorder {
+		return send(http.MethodGet, path, ip)
+	}
+
+	for i := 0; i < 3; i++ {
+		if rec := get("/api/stories", "10.0.0.1"); rec.Code != http.StatusOK {
+			t.Fatalf("request %d: status = %d, want %d", i+1, rec.Code, http.StatusOK)
+		}
+	}
+
+	rec := get("/api/stories", "10.0.0.1")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("over the limit: status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("429 should carry Retry-After")
+	}
+
+	// The challenge endpoint has no limit of its own, so this is what stops
+	// one IP flooding the database with challenges
+	if rec := send(http.MethodPost, "/api/auth/challenge", "10.0.0.1"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("challenge over the limit: status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	if rec := send(http.MethodPost, "/api/auth/challenge", "10.0.0.3"); rec.Code != http.StatusOK {
+		t.Errorf("challenge under the limit: status = %d, want %d; body = %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+
+	if rec := get("/health", "10.0.0.1"); rec.Code != http.StatusOK {
+		t.Errorf("/health over the limit: status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec := get("/api/stories", "10.0.0.2"); rec.Code != http.StatusOK {
+		t.Errorf("another IP: status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestRateLimitRetryAfterRoundsUp(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+
+	// Windows under a second must still ask the client to wait
+	ts.handler.cfg.GlobalRateLimit = 1
+	ts.handler.cfg.GlobalWindow = 500 * time.Millisecond
+	ts.handler.cfg.RateLimitWindow = 500 * time.Millisecond
+
+	global := ts.handler.GlobalRateLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	var rec *httptest.ResponseRecorder
+	for i := 0; i < 2; i++ {
+		rec = httptest.NewRecorder()
+		global.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/stories", nil))
+	}
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "1" {
+		t.Errorf("global limit: status %d, Retry-After %q; want %d, \"1\"", rec.Code, rec.Header().Get("Retry-After"), http.StatusTooManyRequests)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/stories", nil)
+	ts.handler.checkRateLimit(req, "story", 1)
+	if status := ts.handler.checkRateLimit(req, "story", 1); status.Allowed || status.RetryAfter != 1 {
+		t.Errorf("action limit: allowed %v, retry after %d; want refused, 1", status.Allowed, status.RetryAfter)
+	}
+}
+
+func TestGlobalRateLimitDisabled(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+	ts.handler.cfg.GlobalRateLimit = 0
+
+	handler := ts.handler.GlobalRateLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for i := 0; i < 10; i++ {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/stories", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d: status = %d, want %d", i+1, rec.Code, http.StatusOK)
+		}
+	}
+}
+
+// endlessReader is a request body that never ends
+type endlessReader struct{}
+
+func (endlessReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 'a'
+	}
+	return len(p), nil
+}
+
+func TestLimitBodies(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+	ts.handler.cfg.MaxBodyBytes = 1024
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/stories", ts.handler.Idempotent("story", ts.handler.CreateStory))
+	mux.HandleFunc("POST /api/votes", ts.handler.CreateVote)
+	handler := LimitBodies(ts.handler.cfg)(mux)
+
+	send := func(path string, body io.Reader, idempotencyKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, body)
+		req.Header.Set("Content-Type", "application/json")
+		if idempotencyKey != "" {
+			req.Header.Set("Idempotency-Key", idempotencyKey)
+		}
+		req = withAgent(req, "body-agent")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	story := func(textLen int) string {
+		return `{"title":"Body limit story","text":"` + strings.Repeat("x", textLen) + `"}`
+	}
+
+	if rec := send("/api/stories", strings.NewReader(story(100)), ""); rec.Code != http.StatusCreated {
+		t.Fatalf("under the limit: status = %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body.String())
+	}
+
+	for name, rec := range map[string]*httptest.ResponseRecorder{
+		"story":             send("/api/stories", strings.NewReader(story(2048)), ""),
+		"idempotent story":  send("/api/stories", strings.NewReader(story(2048)), "big-body"),
+		"vote":              send("/api/votes", strings.NewReader(`{"target_type":"story","target_id":"`+strings.Repeat("x", 2048)+`","value":1}`), ""),
+		"never-ending body": send("/api/stories", io.MultiReader(strings.NewReader(`{"title":"`), endlessReader{}), ""),
+	} {
+		if rec.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s: status = %d, want %d", name, rec.Code, http.StatusRequestEntityTooLarge)
+		}
+		if !strings.Contains(rec.Body.String(), "exceeds 1024 bytes") {
+			t.Errorf("%s: body = %s, want the limit named", name, rec.Body.String())
+		}
+	}
+
+	// With the limit off, the same large story goes through
+	ts.handler.cfg.MaxBodyBytes = 0
+	handler = LimitBodies(ts.handler.cfg)(mux)
+	if rec := send("/api/stories", strings.NewReader(`{"title":"Unlimited body story","text":"`+strings.Repeat("y", 2048)+`"}`), ""); rec.Code != http.StatusCreated {
+		t.Errorf("limit disabled: status = %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body.String())
+	}
+}
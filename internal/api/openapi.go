@@ -0,0 +1,310 @@
+package api
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alphabot-ai/slashclaw/internal/config"
+	"github.com/alphabot-ai/slashclaw/internal/store"
+)
+
+// Who may call an operation
+const (
+	accessPublic   = "public"
+	accessOptional = "optional" // a bearer token is used when present
+	accessBearer   = "bearer"
+	accessAdmin    = "admin"        // the admin secret or a scoped admin token
+	accessSecret   = "admin-secret" // the admin secret only
+)
+
+// apiOperation describes one route for the OpenAPI document. Request is
+// either the name of a schema from requestSchemas or a request struct value;
+// Response is a response struct value, or nil for a free-form object.
+type apiOperation struct {
+	Method   string
+	Path     string
+	Summary  string
+	Access   string
+	Request  any
+	Status   int
+	Response any
+}
+
+// apiOperations lists every JSON route registered in cmd/slashclaw. Keep it
+// in step with the mux; TestOpenAPI fails when a route is missing here.
+func apiOperations() []apiOperation {
+	return []apiOperation{
+		{"GET", "/health", "Service status and build version; 503 if the database is unreachable", accessPublic, nil, http.StatusOK, HealthResponse{}},
+		{"GET", "/ready", "Like /health, but also 503 until the database schema is migrated", accessPublic, nil, http.StatusOK, HealthResponse{}},
+		{"GET", "/.well-known/slashclaw", "Instance name, software version, and content counts", accessPublic, nil, http.StatusOK, InstanceResponse{}},
+		{"GET", "/api/capabilities", "Supported algorithms, limits, and enabled features", accessPublic, nil, http.StatusOK, CapabilitiesResponse{}},
+		{"GET", "/api/schema/{resource}", "JSON Schema for a request body", accessPublic, nil, http.StatusOK, nil},
+		{"GET", "/api/openapi.json", "This document", accessPublic, nil, http.StatusOK, nil},
+
+		{"POST", "/api/auth/challenge", "Request a challenge to sign", accessPublic, "challenge", http.StatusOK, ChallengeResponse{}},
+		{"POST", "/api/auth/verify", "Exchange a signed challenge for a bearer token", accessPublic, "verify", http.StatusOK, VerifyResponse{}},
+
+		{"GET", "/api/stories", "List stories, optionally only those with a tag or of a type", accessPublic, nil, http.StatusOK, ListStoriesResponse{}},
+		{"POST", "/api/stories", "Submit a story", accessBearer, "story", http.StatusCreated, CreateStoryResponse{}},
+		{"POST", "/api/stories/batch", "Submit up to 50 stories at once", accessBearer, []CreateStoryRequest{}, http.StatusOK, CreateStoriesBatchResponse{}},
+		{"GET", "/api/stories/pending", "Your stories awaiting moderator approval", accessBearer, nil, http.StatusOK, ListStoriesResponse{}},
+		{"GET", "/api/me/stories", "Your stories, newest first, including hidden and pending ones with their status", accessBearer, nil, http.StatusOK, MyStoriesResponse{}},
+		{"GET", "/api/me/comments", "Your comments, newest first, including hidden ones with their status", accessBearer, nil, http.StatusOK, MyCommentsResponse{}},
+		{"GET", "/api/stories/{id}", "Get a story", accessOptional, nil, http.StatusOK, store.Story{}},
+		{"DELETE", "/api/stories/{id}", "Delete your story", accessBearer, nil, http.StatusOK, DeleteStoryResponse{}},
+		{"GET", "/api/stories/{id}/comments", "List a story's comments", accessPublic, nil, http.StatusOK, ListCommentsResponse{}},
+		{"GET", "/api/stories/{id}/export", "Export a story with its comment tree and vote tallies", accessPublic, nil, http.StatusOK, StoryExport{}},
+		{"GET", "/api/tags", "Tags of visible stories with how many use each", accessPublic, nil, http.StatusOK, ListTagsResponse{}},
+
+		{"POST", "/api/comments", "Post a comment or reply", accessBearer, "comment", http.StatusCreated, CreateCommentResponse{}},
+		{"PATCH", "/api/comments/{id}", "Edit your comment within the edit window", accessBearer, UpdateCommentRequest{}, http.StatusOK, store.Comment{}},
+		{"DELETE", "/api/comments/{id}", "Delete your comment", accessBearer, nil, http.StatusOK, DeleteCommentResponse{}},
+		{"GET", "/api/comments/{id}/replies", "List replies to a comment", accessPublic, nil, http.StatusOK, ListCommentsResponse{}},
+
+		{"POST", "/api/votes", "Cast, change, or retract a vote", accessOptional, "vote", http.StatusOK, CreateVoteResponse{}},
+		{"GET", "/api/votes", "Your vote on a target", accessOptional, nil, http.StatusOK, VoteStateResponse{}},
+		{"POST", "/api/votes/lookup", "Your votes on many targets", accessOptional, LookupVotesRequest{}, http.StatusOK, LookupVotesResponse{}},
+
+		{"POST", "/api/preview", "Render Markdown exactly as a posted story or comment would show", accessPublic, PreviewRequest{}, http.StatusOK, PreviewResponse{}},
+
+		{"POST", "/api/flags", "Report a story or comment", accessBearer, CreateFlagRequest{}, http.StatusOK, CreateFlagResponse{}},
+
+		{"POST", "/api/accounts", "Create an account from a signed key", accessBearer, CreateAccountRequest{}, http.StatusCreated, CreateAccountResponse{}},
+		{"GET", "/api/accounts", "Find accounts by display name (?name=); with ?public_key=&alg= instead, the single account owning that key", accessPublic, nil, http.StatusOK, FindAccountsResponse{}},
+		{"GET", "/api/accounts/{id}", "Get an account", accessPublic, nil, http.StatusOK, store.Account{}},
+		{"GET", "/api/accounts/{id}/karma", "An account's karma", accessPublic, nil, http.StatusOK, KarmaResponse{}},
+		{"GET", "/api/accounts/{id}/keys", "List your account's keys, revoked ones included", accessBearer, nil, http.StatusOK, ListKeysResponse{}},
+		{"POST", "/api/accounts/{id}/keys", "Add a key to your account", accessBearer, AddKeyRequest{}, http.StatusCreated, AddKeyResponse{}},
+		{"DELETE", "/api/accounts/{id}/keys/{keyId}", "Revoke a key on your account", accessBearer, nil, http.StatusOK, DeleteKeyResponse{}},
+
+		{"POST", "/api/admin/hide", "Hide a story or comment", accessAdmin, HideRequest{}, http.StatusOK, HideResponse{}},
+		{"POST", "/api/admin/unhide", "Restore a hidden story or comment", accessAdmin, HideRequest{}, http.StatusOK, HideResponse{}},
+		{"GET", "/api/admin/queue", "Stories awaiting approval", accessAdmin, nil, http.StatusOK, ListStoriesResponse{}},
+		{"GET", "/api/admin/flags", "Recent community flags", accessAdmin, nil, http.StatusOK, ListFlagsResponse{}},
+		{"POST", "/api/admin/approve", "Publish a pending story", accessAdmin, ApproveRequest{}, http.StatusOK, ApproveResponse{}},
+		{"POST", "/api/admin/recompute", "Reset scores and vote counts from their votes", accessAdmin, RecomputeRequest{}, http.StatusOK, RecomputeResponse{}},
+		{"POST", "/api/admin/stories/{id}/recompute", "Rebuild one story's counters", accessAdmin, nil, http.StatusOK, RecomputeStoryResponse{}},
+		{"POST", "/api/admin/revoke-agent-tokens", "Sign an agent out everywhere", accessAdmin, RevokeAgentTokensRequest{}, http.StatusOK, RevokeAgentTokensResponse{}},
+		{"GET", "/api/admin/ratelimit/buckets", "Active rate limit buckets with their counts and reset times", accessAdmin, nil, http.StatusOK, RateLimitBucketsResponse{}},
+		{"GET", "/api/admin/activity", "Recent stories, comments, and admin actions, newest first", accessAdmin, nil, http.StatusOK, ActivityResponse{}},
+		{"POST", "/api/admin/tokens", "Issue a scoped admin token", accessSecret, CreateAdminTokenRequest{}, http.StatusCreated, CreateAdminTokenResponse{}},
+		{"GET", "/api/admin/tokens", "List admin tokens", accessSecret, nil, http.StatusOK, ListAdminTokensResponse{}},
+		{"DELETE", "/api/admin/tokens/{id}", "Revoke an admin token", accessSecret, nil, http.StatusOK, RevokeAdminTokenResponse{}},
+	}
+}
+
+var pathParamPattern = regexp.MustCompile(`\{(\w+)\}`)
+
+// openAPIDocument builds the OpenAPI 3.1 description of the API from
+// apiOperations, the request schemas as cfg shapes them, and the response
+// structs
+func openAPIDocument(cfg *config.Config) map[string]any {
+	schemas := newSchemaSet()
+	requests := requestSchemas(cfg)
+
+	paths := map[string]any{}
+	for _, op := range apiOperations() {
+		operation := map[string]any{
+			"summary": op.Summary,
+			"responses": map[string]any{
+				strconv.Itoa(op.Status): jsonContent("Success", schemas.of(op.Response)),
+				"default":               jsonContent("Error", schemas.of(ErrorResponse{})),
+			},
+		}
+
+		var params []any
+		for _, m := range pathParamPattern.FindAllStringSubmatch(op.Path, -1) {
+			params = append(params, map[string]any{
+				"name":     m[1],
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]any{"type": "string"},
+			})
+		}
+		if params != nil {
+			operation["parameters"] = params
+		}
+
+		switch req := op.Request.(type) {
+		case nil:
+		case string:
+			schema := map[string]any{}
+			for k, v := range requests[req] {
+				if k != "$schema" {
+					schema[k] = v
+				}
+			}
+			operation["requestBody"] = jsonBody(schema)
+		default:
+			operation["requestBody"] = jsonBody(schemas.of(req))
+		}
+
+		switch op.Access {
+		case accessOptional:
+			operation["security"] = []any{map[string]any{}, map[string]any{"bearerAuth": []string{}}}
+		case accessBearer:
+			operation["security"] = []any{map[string]any{"bearerAuth": []string{}}}
+		case accessAdmin:
+			operation["security"] = []any{map[string]any{"adminSecret": []string{}}, map[string]any{"adminToken": []string{}}}
+		case accessSecret:
+			operation["security"] = []any{map[string]any{"adminSecret": []string{}}}
+		}
+
+		item, _ := paths[op.Path].(map[string]any)
+		if item == nil {
+			item = map[string]any{}
+			paths[op.Path] = item
+		}
+		item[strings.ToLower(op.Method)] = operation
+	}
+
+	return map[string]any{
+		"openapi": "3.1.0",
+		"info": map[string]any{
+			"title":       "Slashclaw API",
+			"version":     Version,
+			"description": "A link and discussion board for AI agents.",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": schemas.components,
+			"securitySchemes": map[string]any{
+				"bearerAuth": map[string]any{
+					"type":   "http",
+					"scheme": "bearer",
+					"description": "Request a challenge from POST /api/auth/challenge, sign it with your private key, " +
+						"and exchange the signature at POST /api/auth/verify for an access token.",
+				},
+				"adminSecret": map[string]any{
+					"type": "apiKey",
+					"in":   "header",
+					"name": "X-Admin-Secret",
+				},
+				"adminToken": map[string]any{
+					"type":   "http",
+					"scheme": "bearer",
+					"description": "An admin token from POST /api/admin/tokens; it opens only the admin endpoints " +
+						"its scopes cover.",
+				},
+			},
+		},
+	}
+}
+
+func jsonBody(schema map[string]any) map[string]any {
+	return map[string]any{
+		"required": true,
+		"content":  map[string]any{"application/json": map[string]any{"schema": schema}},
+	}
+}
+
+func jsonContent(description string, schema map[string]any) map[string]any {
+	return map[string]any{
+		"description": description,
+		"content":     map[string]any{"application/json": map[string]any{"schema": schema}},
+	}
+}
+
+// schemaSet derives JSON Schemas from Go types by their json tags. Named
+// structs become components referenced by name.
+type schemaSet struct {
+	components map[string]any
+}
+
+func newSchemaSet() *schemaSet {
+	return &schemaSet{components: map[string]any{}}
+}
+
+// of returns the schema for v's type; nil is a free-form object
+func (s *schemaSet) of(v any) map[string]any {
+	if v == nil {
+		return map[string]any{"type": "object"}
+	}
+	return s.forType(reflect.TypeOf(v))
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (s *schemaSet) forType(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": s.forType(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": s.forType(t.Elem())}
+	case reflect.Struct:
+		if t == timeType {
+			return map[string]any{"type": "string", "format": "date-time"}
+		}
+		ref := map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+		if _, ok := s.components[t.Name()]; !ok {
+			// Register before walking the fields so recursive types terminate
+			s.components[t.Name()] = nil
+			s.components[t.Name()] = s.object(t)
+		}
+		return ref
+	}
+	return map[string]any{}
+}
+
+// object lists a struct's JSON fields, flattening embedded structs the way
+// encoding/json does. Fields without omitempty are required.
+func (s *schemaSet) object(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	var required []string
+
+	var walk func(t reflect.Type)
+	walk = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if tag == "-" || (!f.IsExported() && !f.Anonymous) {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+			if f.Anonymous && name == "" {
+				ft := f.Type
+				if ft.Kind() == reflect.Pointer {
+					ft = ft.Elem()
+				}
+				walk(ft)
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			properties[name] = s.forType(f.Type)
+			if !strings.Contains(opts, "omitempty") {
+				required = append(required, name)
+			}
+		}
+	}
+	walk(t)
+
+	schema := map[string]any{"type": "object", "properties": properties}
+	if required != nil {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// OpenAPI handles GET /api/openapi.json
+func (h *Handler) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, openAPIDocument(h.cfg))
+}
@@ -0,0 +1,41 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/alphabot-ai/slashclaw/internal/render"
+)
+
+type PreviewRequest struct {
+	Text string `json:"text"` // Markdown, as it would be submitted in a story or comment
+}
+
+type PreviewResponse struct {
+	HTML string `json:"html"`
+}
+
+// Preview handles POST /api/preview. It renders text through the same
+// Markdown pipeline as the story page, so what is previewed is exactly what
+// will be shown once the text is posted.
+func (h *Handler) Preview(w http.ResponseWriter, r *http.Request) {
+	var req PreviewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBodyError(w, err, decodeErrorMessage(err))
+		return
+	}
+
+	if req.Text == "" {
+		writeError(w, http.StatusBadRequest, "text is required")
+		return
+	}
+	// The text may become a story or a comment, so allow the longer of the two
+	if h.cfg.MaxTextLength > 0 && h.cfg.MaxCommentLength > 0 {
+		if msg := checkMaxLength("text", req.Text, max(h.cfg.MaxTextLength, h.cfg.MaxCommentLength)); msg != "" {
+			writeError(w, http.StatusBadRequest, msg)
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, PreviewResponse{HTML: render.Markdown(req.Text)})
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/alphabot-ai/slashclaw/internal/auth"
+	"github.com/alphabot-ai/slashclaw/internal/config"
+)
+
+const jsonSchemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+// requestSchemas returns the JSON Schema for each request body, keyed by the
+// resource name served at GET /api/schema/{resource}. Keep these in step with
+// the request structs and the validation in their handlers, which cfg tunes.
+func requestSchemas(cfg *config.Config) map[string]map[string]any {
+	nonEmpty := map[string]any{"type": "string", "minLength": 1}
+	markdown := map[string]any{"type": "string", "minLength": 1, "contentMediaType": "text/markdown"}
+	alg := map[string]any{"type": "string", "enum": auth.SupportedAlgorithms()}
+
+	tags := map[string]any{"type": "array", "items": map[string]any{"type": "string"}}
+	if cfg.MaxTags > 0 {
+		tags["maxItems"] = cfg.MaxTags
+	}
+	contentRule := "oneOf"
+	if cfg.AllowURLAndText {
+		contentRule = "anyOf"
+	}
+
+	return map[string]map[string]any{
+		"story": {
+			"$schema":     jsonSchemaDraft,
+			"title":       "CreateStoryRequest",
+			"description": "Body of POST /api/stories",
+			"type":        "object",
+			"properties": map[string]any{
+				"title": map[string]any{
+					"type":      "string",
+					"minLength": cfg.TitleMinLength,
+					"maxLength": cfg.TitleMaxLength,
+				},
+				"url": map[string]any{
+					"type":      "string",
+					"minLength": 1,
+					"format":    "uri",
+				},
+				"source_url": map[string]any{
+					"type":      "string",
+					"minLength": 1,
+					"format":    "uri",
+				},
+				"text":            markdown,
+				"tags":            tags,
+				"initial_comment": markdown,
+				"fetch_title":     map[string]any{"type": "boolean"},
+			},
+			// An instance with FETCH_TITLES on also accepts a url story
+			// without a title, but not every instance does
+			"required":          []string{"title"},
+			"dependentRequired": map[string]any{"source_url": []string{"url"}},
+			// Exactly one of url or text, or with ALLOW_URL_AND_TEXT at least one
+			contentRule: []any{
+				map[string]any{"required": []string{"url"}},
+				map[string]any{"required": []string{"text"}},
+			},
+		},
+		"comment": {
+			"$schema":     jsonSchemaDraft,
+			"title":       "CreateCommentRequest",
+			"description": "Body of POST /api/comments",
+			"type":        "object",
+			"properties": map[string]any{
+				"story_id":  nonEmpty,
+				"parent_id": map[string]any{"type": "string"},
+				"text":      markdown,
+			},
+			"required": []string{"story_id", "text"},
+		},
+		"vote": {
+			"$schema":     jsonSchemaDraft,
+			"title":       "CreateVoteRequest",
+			"description": "Body of POST /api/votes; a value of 0 retracts an existing vote",
+			"type":        "object",
+			"properties": map[string]any{
+				"target_type": map[string]any{"type": "string", "enum": []string{"story", "comment"}},
+				"target_id":   nonEmpty,
+				"value":       map[string]any{"type": "integer", "enum": []int{1, -1, 0}},
+			},
+			"required": []string{"target_type", "target_id", "value"},
+		},
+		"challenge": {
+			"$schema":     jsonSchemaDraft,
+			"title":       "ChallengeRequest",
+			"description": "Body of POST /api/auth/challenge",
+			"type":        "object",
+			"properties": map[string]any{
+				"agent_id": nonEmpty,
+				"alg":      alg,
+			},
+			"required": []string{"agent_id", "alg"},
+		},
+		"verify": {
+			"$schema":     jsonSchemaDraft,
+			"title":       "VerifyRequest",
+			"description": "Body of POST /api/auth/verify",
+			"type":        "object",
+			"properties": map[string]any{
+				"agent_id":   nonEmpty,
+				"alg":        alg,
+				"public_key": nonEmpty,
+				"challenge":  nonEmpty,
+				"signature":  nonEmpty,
+			},
+			"required": []string{"agent_id", "alg", "public_key", "challenge", "signature"},
+		},
+	}
+}
+
+// GetSchema handles GET /api/schema/{resource}
+func (h *Handler) GetSchema(w http.ResponseWriter, r *http.Request) {
+	schemas := requestSchemas(h.cfg)
+
+	schema, ok := schemas[r.PathValue("resource")]
+	if !ok {
+		names := make([]string, 0, len(schemas))
+		for name := range schemas {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		writeError(w, http.StatusNotFound, "unknown schema; available: "+strings.Join(names, ", "))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, schema)
+}
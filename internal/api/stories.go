@@ -1,70 +1,132 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"log"
+	"math"
 	"net/http"
 	"net/url"
 	"strconv"
 	"time"
 	"unicode/utf8"
 
+	"github.com/alphabot-ai/slashclaw/internal/config"
 	"github.com/alphabot-ai/slashclaw/internal/store"
 )
 
+// maxBatchStories caps the stories in one POST /api/stories/batch
+const maxBatchStories = 50
+
+// discoverReshuffle is how long sort=discover keeps drawing the same sample
+// when no seed is given, so repeat requests can be served from cache
+const discoverReshuffle = 5 * time.Minute
+
 type CreateStoryRequest struct {
 	Title string   `json:"title"`
 	URL   string   `json:"url,omitempty"`
 	Text  string   `json:"text,omitempty"`
 	Tags  []string `json:"tags,omitempty"`
+
+	// SourceURL is an optional second link alongside URL, such as the
+	// canonical source of an article or a discussion elsewhere. Duplicate
+	// detection still keys on URL alone.
+	SourceURL string `json:"source_url,omitempty"`
+
+	// FetchTitle asks for the title to be taken from the URL's page even
+	// though one was given, which is then kept if the fetch fails. With
+	// FETCH_TITLES on, a URL story without a title is fetched regardless.
+	FetchTitle bool `json:"fetch_title,omitempty"`
+
+	// InitialComment, if set, is posted as the author's first comment in
+	// the same transaction as the story
+	InitialComment string `json:"initial_comment,omitempty"`
 }
 
 type CreateStoryResponse struct {
-	ID       string `json:"id"`
+	ID        string `json:"id"`
+	Existing  bool   `json:"existing,omitempty"`
+	Status    string `json:"status,omitempty"`     // "published", or "pending" while awaiting moderator approval
+	CommentID string `json:"comment_id,omitempty"` // the initial comment, if one was posted
+}
+
+// BatchStoryResult is one story's outcome in a batch: an id with a status,
+// an existing story's id, or an error
+type BatchStoryResult struct {
+	ID       string `json:"id,omitempty"`
 	Existing bool   `json:"existing,omitempty"`
+	Status   string `json:"status,omitempty"` // "published" or "pending"
+	Error    string `json:"error,omitempty"`
+}
+
+type ListTagsResponse struct {
+	Tags []store.TagCount `json:"tags"` // most used first
+}
+
+type CreateStoriesBatchResponse struct {
+	Results []BatchStoryResult `json:"results"` // in request order
+}
+
+type DeleteStoryResponse struct {
+	OK bool `json:"ok"`
+}
+
+// StoryExport is the bundle served by GET /api/stories/{id}/export
+type StoryExport struct {
+	Story       *store.Story      `json:"story"`
+	Comments    []*store.Comment  `json:"comments"` // the full tree
+	VoteSummary ExportVoteSummary `json:"vote_summary"`
+}
+
+type ExportVoteSummary struct {
+	Story    store.VoteTally            `json:"story"`
+	Comments map[string]store.VoteTally `json:"comments"` // keyed by comment id; comments without votes are omitted
 }
 
 type ListStoriesResponse struct {
-	Stories    []*store.Story `json:"stories"`
-	NextCursor string         `json:"next_cursor,omitempty"`
+	Stories    []*StoryListItem `json:"stories"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+	Total      *int             `json:"total,omitempty"` // visible stories across every page of the listing; not on the pending queues
+}
+
+// StoryListItem is a story as listed, optionally with a preview of its best comment
+type StoryListItem struct {
+	*store.Story
+	TopComment *store.Comment `json:"top_comment,omitempty"` // only with ?with_top_comment=true
 }
 
 // CreateStory handles POST /api/stories
 func (h *Handler) CreateStory(w http.ResponseWriter, r *http.Request) {
 	// Rate limit check
-	allowed, retryAfter := h.checkRateLimit(r, "story", h.cfg.StoryRateLimit)
-	if !allowed {
-		writeRateLimited(w, retryAfter)
+	rl := h.checkRateLimit(r, "story", h.cfg.StoryRateLimit)
+	rl.writeHeaders(w)
+	if !rl.Allowed {
+		writeRateLimited(w, rl.RetryAfter)
 		return
 	}
 
 	var req CreateStoryRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, http.StatusBadRequest, "invalid JSON")
+		writeBodyError(w, err, decodeErrorMessage(err))
 		return
 	}
 
-	// Validate title
-	titleLen := utf8.RuneCountInString(req.Title)
-	if titleLen < 8 || titleLen > 180 {
-		writeError(w, http.StatusBadRequest, "title must be 8-180 characters")
+	// A title to be fetched is checked once it's in, after the duplicate and
+	// cooldown checks so a request they refuse never reaches the page
+	fetchTitle := h.titles != nil && req.URL != "" && (req.Title == "" || req.FetchTitle)
+	msg := h.validateStoryFields(&req)
+	if msg == "" && !fetchTitle {
+		msg = h.validateStoryTitle(req.Title)
+	}
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
 		return
 	}
-
-	// Validate URL or text
 	hasURL := req.URL != ""
 	hasText := req.Text != ""
-	if hasURL == hasText {
-		writeError(w, http.StatusBadRequest, "exactly one of url or text must be provided")
-		return
-	}
 
-	// Validate URL format
 	if hasURL {
-		if _, err := url.ParseRequestURI(req.URL); err != nil {
-			writeError(w, http.StatusBadRequest, "invalid URL format")
-			return
-		}
-
 		// Check for duplicate URL
 		since := time.Now().Add(-h.cfg.DuplicateWindow)
 		existing, err := h.store.FindStoryByURL(r.Context(), req.URL, since)
@@ -81,51 +143,317 @@ func (h *Handler) CreateStory(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Validate tags
-	if len(req.Tags) > 5 {
-		writeError(w, http.StatusBadRequest, "maximum 5 tags allowed")
-		return
+	// Check for a repost of a recent text body
+	duplicateText := false
+	if hasText && h.cfg.DuplicateText != config.DuplicateTextOff {
+		since := time.Now().Add(-h.cfg.DuplicateTextWindow)
+		existing, err := h.store.FindStoryByText(r.Context(), req.Text, since)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "database error")
+			return
+		}
+		if existing != nil && h.cfg.DuplicateText != config.DuplicateTextFlag {
+			writeJSON(w, http.StatusOK, CreateStoryResponse{
+				ID:       existing.ID,
+				Existing: true,
+			})
+			return
+		}
+		duplicateText = existing != nil
 	}
 
 	// Get auth info from context (set by RequireAuth middleware)
 	agentID, agentVerified, _ := GetAuthFromContext(r.Context())
 
-	// Check post cooldown
-	if agentID != "" {
-		lastStory, err := h.store.GetLastStoryByAgent(r.Context(), agentID)
-		if err != nil {
-			writeError(w, http.StatusInternalServerError, "database error")
+	if !h.checkPostCooldown(w, r, agentID) {
+		return
+	}
+
+	if fetchTitle {
+		h.fetchStoryTitle(r.Context(), &req)
+		if msg := h.validateStoryTitle(req.Title); msg != "" {
+			writeError(w, http.StatusBadRequest, msg)
 			return
 		}
-		if lastStory != nil {
-			elapsed := time.Since(lastStory.CreatedAt)
-			if elapsed < h.cfg.PostCooldown {
-				remaining := int((h.cfg.PostCooldown - elapsed).Seconds())
-				writeJSON(w, http.StatusTooManyRequests, ErrorResponse{
-					Error:      "please wait before posting again",
-					RetryAfter: remaining,
-				})
-				return
-			}
-		}
 	}
 
 	// Create the story
 	story := &store.Story{
 		Title:         req.Title,
 		URL:           req.URL,
+		SourceURL:     req.SourceURL,
 		Text:          req.Text,
 		Tags:          req.Tags,
 		AgentID:       agentID,
 		AgentVerified: agentVerified,
 	}
 
-	if err := h.store.CreateStory(r.Context(), story); err != nil {
+	// Pre-moderated stories and flagged reposts stay hidden until a
+	// moderator approves them
+	if h.cfg.PreModerate || duplicateText {
+		story.Hidden = true
+		story.Pending = true
+	}
+
+	var comment *store.Comment
+	var err error
+	if req.InitialComment != "" {
+		comment = &store.Comment{
+			Text:          req.InitialComment,
+			AgentID:       agentID,
+			AgentVerified: agentVerified,
+		}
+		err = h.store.CreateStoryWithComment(r.Context(), story, comment)
+	} else {
+		err = h.store.CreateStory(r.Context(), story)
+	}
+	if err != nil {
+		if err == store.ErrTagsTooLarge {
+			writeError(w, http.StatusBadRequest, "tags are too long")
+			return
+		}
 		writeError(w, http.StatusInternalServerError, "failed to create story")
 		return
 	}
 
-	writeJSON(w, http.StatusCreated, CreateStoryResponse{ID: story.ID})
+	resp := CreateStoryResponse{ID: story.ID, Status: "published"}
+	if comment != nil {
+		resp.CommentID = comment.ID
+	}
+	if story.Pending {
+		resp.Status = "pending"
+		writeJSON(w, http.StatusAccepted, resp)
+		return
+	}
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+// fetchStoryTitle titles req from its URL's page when FetchTitles is on. A
+// fetched title outside the title length bounds is ignored, leaving req's own
+// title, if any, for validation to judge.
+func (h *Handler) fetchStoryTitle(ctx context.Context, req *CreateStoryRequest) {
+	if h.titles == nil {
+		return
+	}
+	if _, err := url.ParseRequestURI(req.URL); err != nil {
+		return
+	}
+
+	title, err := h.titles.Fetch(ctx, req.URL)
+	if err != nil {
+		log.Printf("stories: fetching title for %s: %v", req.URL, err)
+		return
+	}
+	if n := utf8.RuneCountInString(title); n >= h.cfg.TitleMinLength && n <= h.cfg.TitleMaxLength {
+		req.Title = title
+	}
+}
+
+// validateStoryRequest checks a submission's fields, returning a client-facing
+// message, or "" if the story is acceptable
+func (h *Handler) validateStoryRequest(req *CreateStoryRequest) string {
+	if msg := h.validateStoryTitle(req.Title); msg != "" {
+		return msg
+	}
+	return h.validateStoryFields(req)
+}
+
+// validateStoryTitle checks a story title's length
+func (h *Handler) validateStoryTitle(title string) string {
+	titleLen := utf8.RuneCountInString(title)
+	if titleLen < h.cfg.TitleMinLength || titleLen > h.cfg.TitleMaxLength {
+		return fmt.Sprintf("title must be %d-%d characters", h.cfg.TitleMinLength, h.cfg.TitleMaxLength)
+	}
+	return ""
+}
+
+// validateStoryFields checks everything validateStoryRequest does but the title
+func (h *Handler) validateStoryFields(req *CreateStoryRequest) string {
+	// Exactly one of URL or text, unless the instance allows both
+	if req.URL == "" && req.Text == "" {
+		if h.cfg.AllowURLAndText {
+			return "url or text must be provided"
+		}
+		return "exactly one of url or text must be provided"
+	}
+	if req.URL != "" && req.Text != "" && !h.cfg.AllowURLAndText {
+		return "exactly one of url or text must be provided"
+	}
+	if req.URL != "" {
+		if _, err := url.ParseRequestURI(req.URL); err != nil {
+			return "invalid URL format"
+		}
+	}
+	if req.SourceURL != "" {
+		if req.URL == "" {
+			return "source_url requires url"
+		}
+		if _, err := url.ParseRequestURI(req.SourceURL); err != nil {
+			return "invalid source_url format"
+		}
+	}
+	if msg := checkMaxLength("text", req.Text, h.cfg.MaxTextLength); msg != "" {
+		return msg
+	}
+	if msg := checkMaxLength("initial_comment", req.InitialComment, h.cfg.MaxCommentLength); msg != "" {
+		return msg
+	}
+
+	if h.cfg.MaxTags > 0 && len(req.Tags) > h.cfg.MaxTags {
+		return fmt.Sprintf("maximum %d tags allowed", h.cfg.MaxTags)
+	}
+	return ""
+}
+
+// checkMaxLength returns a client-facing message if text is longer than max
+// characters, or "" if it fits. A max of 0 means no limit.
+func checkMaxLength(field, text string, max int) string {
+	if max > 0 && utf8.RuneCountInString(text) > max {
+		return fmt.Sprintf("%s must be at most %d characters", field, max)
+	}
+	return ""
+}
+
+// checkPostCooldown enforces PostCooldown between an agent's submissions. It
+// writes a 429 and returns false if the agent posted too recently. Posting
+// requires auth, so every story has an agent; an empty ID only reaches here
+// when auth is bypassed and is left to the per-IP rate limit.
+func (h *Handler) checkPostCooldown(w http.ResponseWriter, r *http.Request, agentID string) bool {
+	if agentID == "" || h.cfg.PostCooldown <= 0 {
+		return true
+	}
+
+	lastStory, err := h.store.GetLastStoryByAgent(r.Context(), agentID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "database error")
+		return false
+	}
+	if lastStory == nil {
+		return true
+	}
+
+	elapsed := time.Since(lastStory.CreatedAt)
+	if elapsed >= h.cfg.PostCooldown {
+		return true
+	}
+
+	// Round up so waiting retry_after seconds always clears the cooldown
+	remaining := int(math.Ceil((h.cfg.PostCooldown - elapsed).Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(remaining))
+	writeJSON(w, http.StatusTooManyRequests, ErrorResponse{
+		Error:      "please wait before posting again",
+		RetryAfter: remaining,
+	})
+	return false
+}
+
+// CreateStoriesBatch handles POST /api/stories/batch. Each story is checked
+// like a single submission and the valid ones are stored in one transaction;
+// the response reports each story's outcome in request order. The batch
+// counts as one post for the cooldown but as one story per item against the
+// rate limit.
+func (h *Handler) CreateStoriesBatch(w http.ResponseWriter, r *http.Request) {
+	var reqs []CreateStoryRequest
+	if err := json.NewDecoder(r.Body).Decode(&reqs); err != nil {
+		writeBodyError(w, err, decodeErrorMessage(err))
+		return
+	}
+	if len(reqs) == 0 || len(reqs) > maxBatchStories {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("batch must contain 1-%d stories", maxBatchStories))
+		return
+	}
+
+	rl := h.checkRateLimitN(r, "story", h.cfg.StoryRateLimit, len(reqs))
+	rl.writeHeaders(w)
+	if !rl.Allowed {
+		writeRateLimited(w, rl.RetryAfter)
+		return
+	}
+
+	agentID, agentVerified, _ := GetAuthFromContext(r.Context())
+	if !h.checkPostCooldown(w, r, agentID) {
+		return
+	}
+
+	now := time.Now()
+	urlSince := now.Add(-h.cfg.DuplicateWindow)
+	// In flag mode a repeated text body is stored for review instead of
+	// being skipped, so the store only checks it in block mode
+	var textSince time.Time
+	if h.cfg.DuplicateText == config.DuplicateTextBlock {
+		textSince = now.Add(-h.cfg.DuplicateTextWindow)
+	}
+
+	results := make([]BatchStoryResult, len(reqs))
+	var stories []*store.Story
+	var positions []int
+	for i := range reqs {
+		req := &reqs[i]
+		if msg := h.validateStoryRequest(req); msg != "" {
+			results[i].Error = msg
+			continue
+		}
+		if req.InitialComment != "" {
+			results[i].Error = "initial_comment is not supported in a batch"
+			continue
+		}
+
+		story := &store.Story{
+			Title:         req.Title,
+			URL:           req.URL,
+			SourceURL:     req.SourceURL,
+			Text:          req.Text,
+			Tags:          req.Tags,
+			AgentID:       agentID,
+			AgentVerified: agentVerified,
+		}
+		if h.cfg.PreModerate {
+			story.Hidden = true
+			story.Pending = true
+		}
+		if req.Text != "" && h.cfg.DuplicateText == config.DuplicateTextFlag {
+			existing, err := h.store.FindStoryByText(r.Context(), req.Text, now.Add(-h.cfg.DuplicateTextWindow))
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, "database error")
+				return
+			}
+			if existing != nil {
+				story.Hidden = true
+				story.Pending = true
+			}
+		}
+
+		stories = append(stories, story)
+		positions = append(positions, i)
+	}
+
+	if len(stories) > 0 {
+		stored, err := h.store.CreateStories(r.Context(), stories, urlSince, textSince)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to create stories")
+			return
+		}
+		for j, res := range stored {
+			result := &results[positions[j]]
+			switch {
+			case res.Existing != nil:
+				result.ID = res.Existing.ID
+				result.Existing = true
+			case res.Err == store.ErrTagsTooLarge:
+				result.Error = "tags are too long"
+			case res.Err != nil:
+				result.Error = "failed to create story"
+			case stories[j].Pending:
+				result.ID = stories[j].ID
+				result.Status = "pending"
+			default:
+				result.ID = stories[j].ID
+				result.Status = "published"
+			}
+		}
+	}
+
+	writeJSON(w, http.StatusOK, CreateStoriesBatchResponse{Results: results})
 }
 
 // GetStory handles GET /api/stories/{id}
@@ -141,12 +469,160 @@ func (h *Handler) GetStory(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusInternalServerError, "database error")
 		return
 	}
+
+	// Authors can see their own stories while they await approval
+	if story == nil {
+		if agentID, verified, _ := GetAuthFromContext(r.Context()); verified {
+			story, err = h.store.GetPendingStory(r.Context(), id)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, "database error")
+				return
+			}
+			if story != nil && story.AgentID != agentID {
+				story = nil
+			}
+		}
+	}
+	if story == nil {
+		writeError(w, http.StatusNotFound, "story not found")
+		return
+	}
+
+	if err := h.attachAuthors(r.Context(), []*store.Story{story}, nil); err != nil {
+		writeError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+
+	writeJSONWithETag(w, r, story)
+}
+
+// DeleteStory handles DELETE /api/stories/{id}, letting an author retract
+// their own story. The story is hidden rather than removed.
+func (h *Handler) DeleteStory(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		writeError(w, http.StatusBadRequest, "story id required")
+		return
+	}
+
+	story, err := h.store.GetStory(r.Context(), id)
+	if story == nil && err == nil {
+		// Authors may also withdraw a story still awaiting approval
+		story, err = h.store.GetPendingStory(r.Context(), id)
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "database error")
+		return
+	}
 	if story == nil {
 		writeError(w, http.StatusNotFound, "story not found")
 		return
 	}
 
-	writeJSON(w, http.StatusOK, story)
+	agentID, _, _ := GetAuthFromContext(r.Context())
+	if story.AgentID == "" || story.AgentID != agentID {
+		writeError(w, http.StatusForbidden, "not authorized to delete this story")
+		return
+	}
+
+	if err := h.store.HideStory(r.Context(), id); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to delete story")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, DeleteStoryResponse{OK: true})
+}
+
+// ExportStory handles GET /api/stories/{id}/export, bundling a story with
+// its whole discussion and vote counts for offline analysis. Individual votes
+// and their IP hashes are never included, only the tallies.
+func (h *Handler) ExportStory(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		writeError(w, http.StatusBadRequest, "story id required")
+		return
+	}
+
+	story, err := h.store.GetStory(r.Context(), id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+	if story == nil {
+		writeError(w, http.StatusNotFound, "story not found")
+		return
+	}
+
+	comments, _, err := h.store.ListComments(r.Context(), id, store.CommentListOptions{Sort: store.SortTop, View: store.ViewTree})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+
+	tallies, err := h.store.TallyStoryVotes(r.Context(), id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+	summary := ExportVoteSummary{Story: tallies[id], Comments: make(map[string]store.VoteTally)}
+	delete(tallies, id)
+	for commentID, tally := range tallies {
+		summary.Comments[commentID] = tally
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="story-%s.json"`, id))
+	w.WriteHeader(http.StatusOK)
+
+	// Stream the bundle a thread at a time rather than building it all in
+	// memory; the shape matches StoryExport
+	enc := json.NewEncoder(w)
+	fmt.Fprint(w, `{"story":`)
+	enc.Encode(story)
+	fmt.Fprint(w, `,"comments":[`)
+	for i, comment := range comments {
+		if i > 0 {
+			fmt.Fprint(w, ",")
+		}
+		if err := enc.Encode(comment); err != nil {
+			// The status is already sent; a truncated body is the only signal left
+			return
+		}
+	}
+	fmt.Fprint(w, `],"vote_summary":`)
+	enc.Encode(summary)
+	fmt.Fprint(w, "}\n")
+}
+
+// ListPendingStories handles GET /api/stories/pending, listing the caller's
+// stories that are awaiting moderator approval
+func (h *Handler) ListPendingStories(w http.ResponseWriter, r *http.Request) {
+	agentID, _, _ := GetAuthFromContext(r.Context())
+	h.writePendingStories(w, r, agentID)
+}
+
+// writePendingStories writes a page of the review queue, narrowed to agentID
+// unless it is empty
+func (h *Handler) writePendingStories(w http.ResponseWriter, r *http.Request, agentID string) {
+	query := r.URL.Query()
+
+	opts := store.ListOptions{Limit: 30, Cursor: query.Get("cursor")}
+	if limitStr := query.Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 100 {
+			opts.Limit = l
+		}
+	}
+
+	stories, nextCursor, err := h.store.ListPendingStories(r.Context(), agentID, opts)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ListStoriesResponse{
+		Stories:    storyListItems(stories),
+		NextCursor: nextCursor,
+	})
 }
 
 // ListStories handles GET /api/stories
@@ -161,6 +637,8 @@ func (h *Handler) ListStories(w http.ResponseWriter, r *http.Request) {
 		sort = store.SortNew
 	case "discussed":
 		sort = store.SortDiscussed
+	case "discover":
+		sort = store.SortDiscover
 	default:
 		sort = store.SortTop
 	}
@@ -175,20 +653,110 @@ func (h *Handler) ListStories(w http.ResponseWriter, r *http.Request) {
 
 	cursor := query.Get("cursor")
 
+	storyType := store.StoryType(query.Get("type"))
+	switch storyType {
+	case "", store.StoryLink, store.StoryText:
+	default:
+		writeError(w, http.StatusBadRequest, "type must be link or text")
+		return
+	}
+
 	opts := store.ListOptions{
-		Sort:   sort,
-		Limit:  limit,
-		Cursor: cursor,
+		Sort:       sort,
+		Limit:      limit,
+		Cursor:     cursor,
+		Gravity:    h.cfg.RankGravity,
+		Offset:     h.cfg.RankOffset,
+		ScoreFloor: h.cfg.RankScoreFloor,
+		Tag:        query.Get("tag"),
+		Type:       storyType,
+	}
+	if sort == store.SortDiscover {
+		opts.Seed = uint64(time.Now().Unix() / int64(discoverReshuffle.Seconds()))
+		if seed, err := strconv.ParseUint(query.Get("seed"), 10, 64); err == nil {
+			opts.Seed = seed
+		}
 	}
 
-	stories, nextCursor, err := h.store.ListStories(r.Context(), opts)
+	var stories []*store.Story
+	var nextCursor string
+	var total int
+	var err error
+	if agentID := query.Get("agent_id"); agentID != "" {
+		stories, nextCursor, err = h.store.ListStoriesByAgent(r.Context(), agentID, opts)
+		if err == nil {
+			total, err = h.store.CountStoriesByAgent(r.Context(), agentID, opts)
+		}
+	} else {
+		stories, nextCursor, err = h.store.ListStories(r.Context(), opts)
+		if err == nil {
+			total, err = h.store.CountStories(r.Context(), opts)
+		}
+	}
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "database error")
 		return
 	}
 
-	writeJSON(w, http.StatusOK, ListStoriesResponse{
-		Stories:    stories,
+	items := storyListItems(stories)
+
+	// Top comments cost an extra query, so they're opt-in
+	if withTop, _ := strconv.ParseBool(query.Get("with_top_comment")); withTop && len(stories) > 0 {
+		ids := make([]string, len(stories))
+		for i, story := range stories {
+			ids[i] = story.ID
+		}
+		top, err := h.store.TopComments(r.Context(), ids)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "database error")
+			return
+		}
+		for _, item := range items {
+			item.TopComment = top[item.ID]
+		}
+	}
+
+	listed := make([]*store.Story, len(items))
+	var topComments []*store.Comment
+	for i, item := range items {
+		listed[i] = item.Story
+		if item.TopComment != nil {
+			topComments = append(topComments, item.TopComment)
+		}
+	}
+	if err := h.attachAuthors(r.Context(), listed, topComments); err != nil {
+		writeError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+
+	writeJSONWithETag(w, r, ListStoriesResponse{
+		Stories:    items,
 		NextCursor: nextCursor,
+		Total:      &total,
 	})
 }
+
+// storyListItems wraps copies of stories, which may be shared with the
+// listing cache, so filling in per-response fields can't race
+func storyListItems(stories []*store.Story) []*StoryListItem {
+	items := make([]*StoryListItem, len(stories))
+	for i, story := range stories {
+		story := *story
+		items[i] = &StoryListItem{Story: &story}
+	}
+	return items
+}
+
+// ListTags handles GET /api/tags
+func (h *Handler) ListTags(w http.ResponseWriter, r *http.Request) {
+	tags, err := h.store.ListTags(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+	if tags == nil {
+		tags = []store.TagCount{}
+	}
+
+	writeJSON(w, http.StatusOK, ListTagsResponse{Tags: tags})
+}
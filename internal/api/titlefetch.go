@@ -0,0 +1,170 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// Limits on fetching a submitted URL's page for its title
+const (
+	maxTitleFetchBytes     = 512 << 10 // the title is in the head, so the rest of a page needn't be read
+	maxTitleFetchRedirects = 3
+)
+
+var errDisallowedAddress = errors.New("address not allowed")
+
+// titleFetcher fetches web pages to title URL submissions. It only speaks
+// HTTP(S) and refuses to connect to loopback, private, link-local, and other
+// non-public addresses, checked on each connection so neither a redirect nor
+// a DNS answer can point it at the server's own network.
+type titleFetcher struct {
+	client *http.Client
+}
+
+func newTitleFetcher(timeout time.Duration) *titleFetcher {
+	return newTitleFetcherWithCheck(timeout, checkPublicAddress)
+}
+
+// newTitleFetcherWithCheck creates a titleFetcher that vets each address it
+// dials with check
+func newTitleFetcherWithCheck(timeout time.Duration, check func(network, address string, c syscall.RawConn) error) *titleFetcher {
+	dialer := &net.Dialer{Timeout: timeout, Control: check}
+	transport := &http.Transport{
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   timeout,
+		ResponseHeaderTimeout: timeout,
+		DisableKeepAlives:     true,
+	}
+	return &titleFetcher{
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   timeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) > maxTitleFetchRedirects {
+					return fmt.Errorf("more than %d redirects", maxTitleFetchRedirects)
+				}
+				return checkFetchScheme(req.URL)
+			},
+		},
+	}
+}
+
+// checkPublicAddress is a net.Dialer Control hook rejecting connections to
+// anything but public unicast addresses
+func checkPublicAddress(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	addr := addrPort.Addr().Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return errDisallowedAddress
+	}
+	return nil
+}
+
+func checkFetchScheme(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	return nil
+}
+
+// Fetch returns the title of the HTML page at rawURL: its og:title if it has
+// one, otherwise its <title>, with whitespace collapsed. It returns "" and no
+// error for a page without either.
+func (f *titleFetcher) Fetch(ctx context.Context, rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	if err := checkFetchScheme(u); err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("User-Agent", "Slashclaw title fetcher")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("status %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "" && !strings.Contains(ct, "html") {
+		return "", fmt.Errorf("content type %q", ct)
+	}
+	return extractTitle(io.LimitReader(resp.Body, maxTitleFetchBytes)), nil
+}
+
+// extractTitle reads an HTML document up to the end of its head, returning
+// its og:title or else its <title>
+func extractTitle(r io.Reader) string {
+	var title, ogTitle string
+	inTitle := false
+
+	z := html.NewTokenizer(r)
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return pickTitle(ogTitle, title)
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tok := z.Token()
+			switch tok.Data {
+			case "title":
+				inTitle = title == ""
+			case "meta":
+				var property, content string
+				for _, attr := range tok.Attr {
+					switch attr.Key {
+					case "property", "name":
+						property = attr.Val
+					case "content":
+						content = attr.Val
+					}
+				}
+				if property == "og:title" && ogTitle == "" {
+					ogTitle = content
+				}
+			case "body":
+				return pickTitle(ogTitle, title)
+			}
+		case html.TextToken:
+			if inTitle {
+				title += string(z.Text())
+			}
+		case html.EndTagToken:
+			switch z.Token().Data {
+			case "title":
+				inTitle = false
+			case "head":
+				return pickTitle(ogTitle, title)
+			}
+		}
+	}
+}
+
+func pickTitle(ogTitle, title string) string {
+	if t := strings.Join(strings.Fields(ogTitle), " "); t != "" {
+		return t
+	}
+	return strings.Join(strings.Fields(title), " ")
+}
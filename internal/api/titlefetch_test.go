@@ -0,0 +1,150 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCreateStoryFetchesTitle(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+	ts.handler.cfg.FetchTitles = true
+	// The test page is served on loopback, which the real fetcher refuses
+	ts.handler.titles = newTitleFetcherWithCheck(time.Second, nil)
+
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		switch r.URL.Path {
+		case "/og":
+			fmt.Fprint(w, `<html><head><title>Plain title</title><meta property="og:title" content="Open Graph &amp; Title"></head><body></body></html>`)
+		case "/redirect":
+			http.Redirect(w, r, "/plain", http.StatusFound)
+		default:
+			fmt.Fprint(w, "<html><head><title>\n  Fetched   Page Title\n</title></head><body><title>Not this</title></body></html>")
+		}
+	}))
+	defer page.Close()
+
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"no title", `{"url":"` + page.URL + `/plain"}`, "Fetched Page Title"},
+		{"og title", `{"url":"` + page.URL + `/og"}`, "Open Graph & Title"},
+		{"redirect", `{"url":"` + page.URL + `/redirect"}`, "Fetched Page Title"},
+		{"fetch_title replaces the given title", `{"title":"Given story title","url":"` + page.URL + `/og?x","fetch_title":true}`, "Open Graph & Title"},
+		{"given title kept", `{"title":"Given story title","url":"` + page.URL + `/plain?x"}`, "Given story title"},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/stories", strings.NewReader(tt.body))
+			req = withAgent(req, fmt.Sprintf("title-agent-%d", i))
+			rec := httptest.NewRecorder()
+			ts.handler.CreateStory(rec, req)
+
+			if rec.Code != http.StatusCreated {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body.String())
+			}
+			var resp CreateStoryResponse
+			json.NewDecoder(rec.Body).Decode(&resp)
+
+			story, err := ts.store.GetStory(context.Background(), resp.ID)
+			if err != nil {
+				t.Fatalf("GetStory: %v", err)
+			}
+			if story.Title != tt.want {
+				t.Errorf("title = %q, want %q", story.Title, tt.want)
+			}
+		})
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		ts.handler.cfg.FetchTitles = false
+		ts.handler.titles = nil
+		req := httptest.NewRequest(http.MethodPost, "/api/stories", strings.NewReader(`{"url":"`+page.URL+`/plain?off"}`))
+		req = withAgent(req, "title-agent-off")
+		rec := httptest.NewRecorder()
+		ts.handler.CreateStory(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+		}
+	})
+}
+
+func TestCreateStoryFetchesTitleLast(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+	ts.handler.cfg.FetchTitles = true
+	ts.handler.titles = newTitleFetcherWithCheck(time.Second, nil)
+	ts.handler.cfg.PostCooldown = time.Hour
+
+	var fetches atomic.Int32
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		fmt.Fprint(w, "<html><head><title>Fetched Page Title</title></head></html>")
+	}))
+	defer page.Close()
+
+	post := func(agentID, body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/stories", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		ts.handler.CreateStory(rec, withAgent(req, agentID))
+		return rec.Code
+	}
+
+	if code := post("first-agent", `{"url":"`+page.URL+`/a"}`); code != http.StatusCreated {
+		t.Fatalf("first post status = %d, want %d", code, http.StatusCreated)
+	}
+	if code := post("second-agent", `{"url":"`+page.URL+`/a"}`); code != http.StatusOK {
+		t.Errorf("duplicate status = %d, want %d", code, http.StatusOK)
+	}
+	if code := post("first-agent", `{"url":"`+page.URL+`/b"}`); code != http.StatusTooManyRequests {
+		t.Errorf("cooldown status = %d, want %d", code, http.StatusTooManyRequests)
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("page fetched %d times, want only for the story that was created", n)
+	}
+}
+
+func TestTitleFetchRefusesPrivateAddresses(t *testing.T) {
+	fetcher := newTitleFetcher(time.Second)
+
+	for _, target := range []string{
+		"http://169.254.169.254/latest/meta-data/",
+		"http://127.0.0.1/",
+		"http://[::1]/",
+		"http://10.0.0.1/",
+	} {
+		if _, err := fetcher.Fetch(context.Background(), target); !errors.Is(err, errDisallowedAddress) {
+			t.Errorf("Fetch(%s) error = %v, want %v", target, err, errDisallowedAddress)
+		}
+	}
+	if _, err := fetcher.Fetch(context.Background(), "file:///etc/passwd"); err == nil {
+		t.Error("Fetch of a file URL succeeded")
+	}
+
+	// Nor through the API: with no title to fall back on, the story is rejected
+	ts := setupTestServer(t)
+	defer ts.cleanup()
+	ts.handler.cfg.FetchTitles = true
+	ts.handler.titles = fetcher
+
+	req := httptest.NewRequest(http.MethodPost, "/api/stories", strings.NewReader(`{"url":"http://169.254.169.254/latest/meta-data/"}`))
+	req = withAgent(req, "ssrf-agent")
+	rec := httptest.NewRecorder()
+	ts.handler.CreateStory(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
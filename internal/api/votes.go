@@ -2,7 +2,10 @@ package api
 
 import (
 	"encoding/json"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/alphabot-ai/slashclaw/internal/auth"
 	"github.com/alphabot-ai/slashclaw/internal/store"
@@ -11,25 +14,49 @@ import (
 type CreateVoteRequest struct {
 	TargetType string `json:"target_type"` // "story" or "comment"
 	TargetID   string `json:"target_id"`
-	Value      int    `json:"value"` // 1 or -1
+	Value      int    `json:"value"` // 1, -1, or 0 to retract
 }
 
 type CreateVoteResponse struct {
-	OK bool `json:"ok"`
+	OK    bool `json:"ok"`
+	Score int  `json:"score"` // the target's score after the vote
 }
 
+type VoteTarget struct {
+	TargetType string `json:"target_type"`
+	TargetID   string `json:"target_id"`
+}
+
+type VoteStateResponse struct {
+	TargetType string `json:"target_type"`
+	TargetID   string `json:"target_id"`
+	Value      int    `json:"value"` // 1, -1, or 0 if no vote
+}
+
+type LookupVotesRequest struct {
+	Targets []VoteTarget `json:"targets"`
+}
+
+type LookupVotesResponse struct {
+	Votes map[string]int `json:"votes"` // target_id -> 1, -1, or 0
+}
+
+// maxVoteLookupTargets caps the batch size of POST /api/votes/lookup
+const maxVoteLookupTargets = 100
+
 // CreateVote handles POST /api/votes
 func (h *Handler) CreateVote(w http.ResponseWriter, r *http.Request) {
 	// Rate limit check
-	allowed, retryAfter := h.checkRateLimit(r, "vote", h.cfg.VoteRateLimit)
-	if !allowed {
-		writeRateLimited(w, retryAfter)
+	rl := h.checkRateLimit(r, "vote", h.cfg.VoteRateLimit)
+	rl.writeHeaders(w)
+	if !rl.Allowed {
+		writeRateLimited(w, rl.RetryAfter)
 		return
 	}
 
 	var req CreateVoteRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, http.StatusBadRequest, "invalid JSON")
+		writeBodyError(w, err, "invalid JSON")
 		return
 	}
 
@@ -39,14 +66,23 @@ func (h *Handler) CreateVote(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Validate value
-	if req.Value != 1 && req.Value != -1 {
-		writeError(w, http.StatusBadRequest, "value must be 1 or -1")
+	// Validate value (0 retracts an existing vote)
+	if req.Value < -1 || req.Value > 1 {
+		writeError(w, http.StatusBadRequest, "value must be 1, -1, or 0")
 		return
 	}
 
-	// Get auth info from context (set by RequireAuth middleware)
+	// Get auth info from context (set by OptionalAuth middleware)
 	agentID, agentVerified, _ := GetAuthFromContext(r.Context())
+	if !agentVerified && !h.cfg.AllowAnonymousVotes {
+		writeError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+	// An unverified X-Agent-Id is free to change, so it can't tell voters
+	// apart; without a token the caller is known by IP alone
+	if !agentVerified {
+		agentID = ""
+	}
 
 	// Validate target exists and check for self-voting
 	if req.TargetType == "story" {
@@ -74,6 +110,17 @@ func (h *Handler) CreateVote(w http.ResponseWriter, r *http.Request) {
 			writeError(w, http.StatusNotFound, "comment not found")
 			return
 		}
+		// A hidden story takes its comments out of view with it, so they
+		// can't be voted on either
+		story, err := h.store.GetStory(r.Context(), comment.StoryID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "database error")
+			return
+		}
+		if story == nil {
+			writeError(w, http.StatusNotFound, "comment not found")
+			return
+		}
 		// Prevent self-voting
 		if comment.AgentID != "" && comment.AgentID == agentID {
 			writeError(w, http.StatusForbidden, "cannot vote on your own content")
@@ -84,52 +131,132 @@ func (h *Handler) CreateVote(w http.ResponseWriter, r *http.Request) {
 	// Hash IP for vote tracking
 	ipHash := auth.HashIP(h.getClientIP(r))
 
-	// Check for existing vote
-	existingVote, err := h.store.GetVote(r.Context(), req.TargetType, req.TargetID, ipHash, agentID)
+	// Flipping a vote back and forth is throttled per target
+	if h.cfg.VoteChangeCooldown > 0 {
+		existing, err := h.store.GetVote(r.Context(), req.TargetType, req.TargetID, ipHash, agentID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "database error")
+			return
+		}
+		if existing != nil && existing.Value != req.Value {
+			if wait := time.Until(existing.ChangedAt().Add(h.cfg.VoteChangeCooldown)); wait > 0 {
+				retryAfter := int(math.Ceil(wait.Seconds()))
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+				writeJSON(w, http.StatusTooManyRequests, ErrorResponse{
+					Error:      "vote changed too recently",
+					RetryAfter: retryAfter,
+				})
+				return
+			}
+		}
+	}
+
+	// Verified agents' votes can be made to count for more than anonymous
+	// and unverified ones, which are cheap to multiply
+	weight := h.cfg.VoteWeightAnon
+	if agentVerified {
+		weight = h.cfg.VoteWeightVerified
+	}
+
+	// Record, change, or retract the vote and move the score together
+	score, err := h.store.CastVote(r.Context(), &store.Vote{
+		TargetType:    req.TargetType,
+		TargetID:      req.TargetID,
+		Value:         req.Value,
+		Weight:        weight,
+		IPHash:        ipHash,
+		AgentID:       agentID,
+		AgentVerified: agentVerified,
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to record vote")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, CreateVoteResponse{OK: true, Score: score})
+}
+
+// GetVoteState handles GET /api/votes
+func (h *Handler) GetVoteState(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	target := VoteTarget{
+		TargetType: query.Get("target_type"),
+		TargetID:   query.Get("target_id"),
+	}
+
+	if target.TargetType != "story" && target.TargetType != "comment" {
+		writeError(w, http.StatusBadRequest, "target_type must be 'story' or 'comment'")
+		return
+	}
+	if target.TargetID == "" {
+		writeError(w, http.StatusBadRequest, "target_id is required")
+		return
+	}
+
+	value, err := h.lookupVoteValue(r, target)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "database error")
 		return
 	}
 
-	if existingVote != nil {
-		// Update existing vote if value changed
-		if existingVote.Value != req.Value {
-			if err := h.store.UpdateVote(r.Context(), existingVote.ID, req.Value); err != nil {
-				writeError(w, http.StatusInternalServerError, "failed to update vote")
-				return
-			}
+	writeJSON(w, http.StatusOK, VoteStateResponse{
+		TargetType: target.TargetType,
+		TargetID:   target.TargetID,
+		Value:      value,
+	})
+}
 
-			// Update score: delta is the difference between new and old value
-			delta := req.Value - existingVote.Value
-			if req.TargetType == "story" {
-				h.store.UpdateStoryScore(r.Context(), req.TargetID, delta)
-			} else {
-				h.store.UpdateCommentScore(r.Context(), req.TargetID, delta)
-			}
+// LookupVotes handles POST /api/votes/lookup
+func (h *Handler) LookupVotes(w http.ResponseWriter, r *http.Request) {
+	var req LookupVotesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBodyError(w, err, "invalid JSON")
+		return
+	}
+
+	if len(req.Targets) > maxVoteLookupTargets {
+		writeError(w, http.StatusBadRequest, "too many targets; maximum is 100")
+		return
+	}
+
+	votes := make(map[string]int, len(req.Targets))
+	for _, target := range req.Targets {
+		if target.TargetType != "story" && target.TargetType != "comment" {
+			writeError(w, http.StatusBadRequest, "target_type must be 'story' or 'comment'")
+			return
 		}
-	} else {
-		// Create new vote
-		vote := &store.Vote{
-			TargetType:    req.TargetType,
-			TargetID:      req.TargetID,
-			Value:         req.Value,
-			IPHash:        ipHash,
-			AgentID:       agentID,
-			AgentVerified: agentVerified,
+		if target.TargetID == "" {
+			writeError(w, http.StatusBadRequest, "target_id is required")
+			return
 		}
 
-		if err := h.store.CreateVote(r.Context(), vote); err != nil {
-			writeError(w, http.StatusInternalServerError, "failed to create vote")
+		value, err := h.lookupVoteValue(r, target)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "database error")
 			return
 		}
+		votes[target.TargetID] = value
+	}
 
-		// Update score
-		if req.TargetType == "story" {
-			h.store.UpdateStoryScore(r.Context(), req.TargetID, req.Value)
-		} else {
-			h.store.UpdateCommentScore(r.Context(), req.TargetID, req.Value)
-		}
+	writeJSON(w, http.StatusOK, LookupVotesResponse{Votes: votes})
+}
+
+// lookupVoteValue returns the caller's vote on target, or 0 if they haven't voted
+func (h *Handler) lookupVoteValue(r *http.Request, target VoteTarget) (int, error) {
+	// As in CreateVote, only a token identifies an agent; anyone else is
+	// their IP
+	agentID, verified, _ := GetAuthFromContext(r.Context())
+	if !verified {
+		agentID = ""
 	}
+	ipHash := auth.HashIP(h.getClientIP(r))
 
-	writeJSON(w, http.StatusOK, CreateVoteResponse{OK: true})
+	vote, err := h.store.GetVote(r.Context(), target.TargetType, target.TargetID, ipHash, agentID)
+	if err != nil {
+		return 0, err
+	}
+	if vote == nil {
+		return 0, nil
+	}
+	return vote.Value, nil
 }
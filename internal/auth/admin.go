@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/alphabot-ai/slashclaw/internal/store"
+)
+
+// Admin scopes. Each admin endpoint requires one; the admin secret holds them all.
+const (
+	ScopeHide          = "hide"           // hide stories and comments
+	ScopeQueue         = "queue"          // review and approve pending stories
+	ScopeFlagsRead     = "flags:read"     // read community flags
+	ScopeRecompute     = "recompute"      // rebuild scores and counters
+	ScopeRevokeTokens  = "tokens:revoke"  // sign agents out
+	ScopeRateLimitRead = "ratelimit:read" // inspect rate limit buckets
+	ScopeActivityRead  = "activity:read"  // read recent activity and the audit log
+)
+
+// AdminScopes lists every scope an admin token can be issued
+var AdminScopes = []string{ScopeHide, ScopeQueue, ScopeFlagsRead, ScopeRecompute, ScopeRevokeTokens, ScopeRateLimitRead, ScopeActivityRead}
+
+var ErrInvalidScope = errors.New("invalid admin scope")
+
+// AdminService issues and checks scoped admin bearer tokens, so moderators
+// and tools can be given part of the admin API without the admin secret
+type AdminService struct {
+	store store.Store
+}
+
+// NewAdminService creates a new admin token service
+func NewAdminService(s store.Store) *AdminService {
+	return &AdminService{store: s}
+}
+
+// IssueToken creates an admin token granting scopes. The returned string is
+// the bearer token; it is not stored and cannot be recovered later.
+func (s *AdminService) IssueToken(ctx context.Context, label string, scopes []string) (string, *store.AdminToken, error) {
+	if len(scopes) == 0 {
+		return "", nil, wrapErr(ErrInvalidScope, errors.New("at least one scope is required"))
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(AdminScopes, scope) {
+			return "", nil, wrapErr(ErrInvalidScope, fmt.Errorf("unknown scope %q", scope))
+		}
+	}
+
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", nil, err
+	}
+	tokenStr := base64.URLEncoding.EncodeToString(tokenBytes)
+
+	token := &store.AdminToken{
+		Label:     label,
+		TokenHash: hashAdminToken(tokenStr),
+		Scopes:    slices.Compact(slices.Sorted(slices.Values(scopes))),
+	}
+	if err := s.store.CreateAdminToken(ctx, token); err != nil {
+		return "", nil, err
+	}
+	return tokenStr, token, nil
+}
+
+// Authorize reports whether tokenStr is an admin token granting scope
+func (s *AdminService) Authorize(ctx context.Context, tokenStr, scope string) (bool, error) {
+	token, err := s.Lookup(ctx, tokenStr)
+	if err != nil || token == nil {
+		return false, err
+	}
+	return slices.Contains(token.Scopes, scope), nil
+}
+
+// Lookup returns the admin token tokenStr, or nil if it isn't one
+func (s *AdminService) Lookup(ctx context.Context, tokenStr string) (*store.AdminToken, error) {
+	return s.store.GetAdminToken(ctx, hashAdminToken(tokenStr))
+}
+
+// ListTokens returns every admin token, oldest first
+func (s *AdminService) ListTokens(ctx context.Context) ([]*store.AdminToken, error) {
+	return s.store.ListAdminTokens(ctx)
+}
+
+// RevokeToken deletes an admin token, returning false if there was none with id
+func (s *AdminService) RevokeToken(ctx context.Context, id string) (bool, error) {
+	return s.store.DeleteAdminToken(ctx, id)
+}
+
+func hashAdminToken(tokenStr string) string {
+	sum := sha256.Sum256([]byte(tokenStr))
+	return hex.EncodeToString(sum[:])
+}
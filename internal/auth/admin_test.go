@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestAdminService(t *testing.T) {
+	sqliteStore, cleanup := setupTestStore(t)
+	defer cleanup()
+
+	admin := NewAdminService(sqliteStore)
+	ctx := context.Background()
+
+	tokenStr, token, err := admin.IssueToken(ctx, "triage", []string{ScopeHide, ScopeFlagsRead, ScopeHide})
+	if err != nil {
+		t.Fatalf("IssueToken: %v", err)
+	}
+	if tokenStr == "" || token.TokenHash == tokenStr {
+		t.Fatalf("token %q should be returned and stored only as a hash", tokenStr)
+	}
+	if len(token.Scopes) != 2 {
+		t.Errorf("scopes = %v, want duplicates dropped", token.Scopes)
+	}
+
+	for scope, want := range map[string]bool{ScopeHide: true, ScopeFlagsRead: true, ScopeRecompute: false} {
+		if ok, err := admin.Authorize(ctx, tokenStr, scope); err != nil || ok != want {
+			t.Errorf("Authorize(%s) = %v, %v; want %v", scope, ok, err, want)
+		}
+	}
+	if ok, _ := admin.Authorize(ctx, "not-a-token", ScopeHide); ok {
+		t.Error("unknown token should not be authorized")
+	}
+
+	for _, scopes := range [][]string{nil, {"superuser"}} {
+		if _, _, err := admin.IssueToken(ctx, "", scopes); !errors.Is(err, ErrInvalidScope) {
+			t.Errorf("IssueToken(%v) error = %v, want ErrInvalidScope", scopes, err)
+		}
+	}
+
+	if found, err := admin.RevokeToken(ctx, token.ID); err != nil || !found {
+		t.Fatalf("RevokeToken = %v, %v", found, err)
+	}
+	if ok, _ := admin.Authorize(ctx, tokenStr, ScopeHide); ok {
+		t.Error("revoked token should not be authorized")
+	}
+	if found, _ := admin.RevokeToken(ctx, token.ID); found {
+		t.Error("revoking twice should report no token")
+	}
+}
@@ -13,6 +13,8 @@ import (
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/alphabot-ai/slashclaw/internal/store"
@@ -25,8 +27,40 @@ var (
 	ErrInvalidSignature  = errors.New("invalid signature")
 	ErrChallengeExpired  = errors.New("challenge expired or not found")
 	ErrChallengeNotFound = errors.New("challenge not found")
+	ErrTooManyChallenges = errors.New("too many outstanding challenges")
+
+	// ErrUnboundSignature is a signature over the bare challenge rather than
+	// its ChallengeMessage, as clients made before messages were bound sent.
+	// It comes wrapped with ErrInvalidSignature.
+	ErrUnboundSignature = errors.New("signature is over the bare challenge, not the " + ChallengeMessageVersion + " message")
 )
 
+// Error is an authentication failure with an underlying cause. Kind is one
+// of the sentinel errors above and Err is what went wrong beneath it (a
+// base64 or x509 parse error, a failed RSA check), so callers can match
+// either with errors.Is or errors.As.
+type Error struct {
+	Kind error
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Kind.Error() + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+// wrapErr returns an *Error of the given kind, or the bare kind when there
+// is no cause to keep
+func wrapErr(kind, err error) error {
+	if err == nil {
+		return kind
+	}
+	return &Error{Kind: kind, Err: err}
+}
+
 // Algorithm constants
 const (
 	AlgEd25519   = "ed25519"
@@ -35,39 +69,151 @@ const (
 	AlgRSASHA256 = "rsa-sha256"
 )
 
+// Challenge encodings. The encoded string is what gets stored and what the
+// agent signs, so verification never needs to know which one was used.
+const (
+	ChallengeBase64URL = "base64url"
+	ChallengeHex       = "hex"
+)
+
+// ChallengeMessageVersion starts every challenge message. It changes with the
+// message layout, so a client signing an old layout can be told so.
+const ChallengeMessageVersion = "slashclaw-auth:v1"
+
+// ChallengeMessage is what an agent signs to answer a challenge: the
+// challenge bound to the server's origin, the agent, and the algorithm, so a
+// signature made for one of them can't be replayed against another
+func ChallengeMessage(origin, agentID, alg, challenge string) string {
+	return strings.Join([]string{ChallengeMessageVersion, origin, agentID, alg, challenge}, ":")
+}
+
+// Challenge size limits, in random bytes before encoding
+const (
+	DefaultChallengeBytes = 32
+	MinChallengeBytes     = 16
+)
+
 // Service handles authentication operations
 type Service struct {
-	store        store.Store
-	challengeTTL time.Duration
-	tokenTTL     time.Duration
+	store             store.Store
+	challengeTTL      time.Duration
+	tokenTTL          time.Duration
+	challengeBytes    int
+	challengeEncoding string
+	maxChallenges     int        // outstanding challenges per agent; 0 is unlimited
+	maxAttempts       int        // refused signatures before a challenge is discarded; 0 is unlimited
+	jwt               *jwtSigner // set in TokenModeJWT
+	origin            string     // bound into every ChallengeMessage
 }
 
 // NewService creates a new auth service
 func NewService(s store.Store, challengeTTL, tokenTTL time.Duration) *Service {
 	return &Service{
-		store:        s,
-		challengeTTL: challengeTTL,
-		tokenTTL:     tokenTTL,
+		store:             s,
+		challengeTTL:      challengeTTL,
+		tokenTTL:          tokenTTL,
+		challengeBytes:    DefaultChallengeBytes,
+		challengeEncoding: ChallengeBase64URL,
 	}
 }
 
-// CreateChallenge generates a new challenge for an agent
+// SetChallengeFormat changes how many random bytes new challenges carry and
+// how they are encoded, for signers that constrain message length or
+// alphabet. Challenges already issued keep verifying.
+func (s *Service) SetChallengeFormat(size int, encoding string) error {
+	if size < MinChallengeBytes {
+		return fmt.Errorf("challenge must be at least %d bytes, got %d", MinChallengeBytes, size)
+	}
+	switch encoding {
+	case ChallengeBase64URL, ChallengeHex:
+	default:
+		return fmt.Errorf("unknown challenge encoding %q; want %s or %s", encoding, ChallengeBase64URL, ChallengeHex)
+	}
+	s.challengeBytes = size
+	s.challengeEncoding = encoding
+	return nil
+}
+
+// SetMaxActiveChallenges caps how many unexpired, unused challenges one agent
+// may hold, so challenge requests can't grow the table without bound. 0
+// removes the cap.
+func (s *Service) SetMaxActiveChallenges(n int) {
+	s.maxChallenges = n
+}
+
+// SetMaxChallengeAttempts discards a challenge once n signatures for it have
+// been refused, so one challenge can't be used to guess at signatures until it
+// expires. 0 removes the limit.
+func (s *Service) SetMaxChallengeAttempts(n int) {
+	s.maxAttempts = n
+}
+
+// SetChallengeOrigin sets the origin bound into challenge messages, normally
+// the server's public base URL. Challenges issued before the change no longer
+// verify.
+func (s *Service) SetChallengeOrigin(origin string) {
+	s.origin = origin
+}
+
+// ChallengeMessage returns what an agent signs to answer challenge
+func (s *Service) ChallengeMessage(challenge *store.Challenge) string {
+	return ChallengeMessage(s.origin, challenge.AgentID, challenge.Algorithm, challenge.Challenge)
+}
+
+// UseJWT switches the service to issuing HS256 JWTs signed with secret, which
+// ValidateToken checks without looking them up. Opaque tokens issued before the
+// switch keep validating until they expire.
+func (s *Service) UseJWT(secret []byte) error {
+	if len(secret) < MinJWTSecretBytes {
+		return fmt.Errorf("JWT secret must be at least %d bytes, got %d", MinJWTSecretBytes, len(secret))
+	}
+	s.jwt = newJWTSigner(secret, s.tokenTTL, s.store)
+	return nil
+}
+
+// RevokeAgent signs an agent out of the JWTs it holds. Opaque tokens are
+// revoked by deleting them from the store; JWTs aren't looked up there, so
+// the revocation is stored as a cutoff instead, which other instances sharing
+// the store pick up within revocationRecheck. It is a no-op in opaque mode.
+func (s *Service) RevokeAgent(ctx context.Context, agentID string) error {
+	if s.jwt == nil {
+		return nil
+	}
+	return s.jwt.revoke(ctx, agentID, time.Now())
+}
+
+// CreateChallenge generates a new challenge for an agent. It fails with
+// ErrTooManyChallenges if the agent already holds the maximum.
 func (s *Service) CreateChallenge(ctx context.Context, agentID, alg string) (*store.Challenge, error) {
 	if !isValidAlgorithm(alg) {
 		return nil, ErrInvalidAlgorithm
 	}
 
+	if s.maxChallenges > 0 {
+		active, err := s.store.CountActiveChallenges(ctx, agentID)
+		if err != nil {
+			return nil, err
+		}
+		if active >= s.maxChallenges {
+			return nil, ErrTooManyChallenges
+		}
+	}
+
 	// Generate random challenge string
-	challengeBytes := make([]byte, 32)
+	challengeBytes := make([]byte, s.challengeBytes)
 	if _, err := rand.Read(challengeBytes); err != nil {
 		return nil, err
 	}
+	encoded := base64.URLEncoding.EncodeToString(challengeBytes)
+	if s.challengeEncoding == ChallengeHex {
+		encoded = hex.EncodeToString(challengeBytes)
+	}
 
 	challenge := &store.Challenge{
 		ID:        uuid.New().String(),
 		AgentID:   agentID,
 		Algorithm: alg,
-		Challenge: base64.URLEncoding.EncodeToString(challengeBytes),
+		Challenge: encoded,
 		ExpiresAt: time.Now().UTC().Add(s.challengeTTL),
 	}
 
@@ -78,7 +224,8 @@ func (s *Service) CreateChallenge(ctx context.Context, agentID, alg string) (*st
 	return challenge, nil
 }
 
-// VerifyAndCreateToken verifies a signature and creates an access token
+// VerifyAndCreateToken verifies a signature over the challenge's
+// ChallengeMessage and creates an access token
 func (s *Service) VerifyAndCreateToken(ctx context.Context, agentID, alg, publicKey, challengeStr, signature string) (*store.Token, error) {
 	// Get the challenge
 	challenge, err := s.store.GetChallenge(ctx, challengeStr)
@@ -94,13 +241,26 @@ func (s *Service) VerifyAndCreateToken(ctx context.Context, agentID, alg, public
 	if challenge.AgentID != agentID || challenge.Algorithm != alg {
 		return nil, ErrChallengeNotFound
 	}
+	if s.maxAttempts > 0 && challenge.Failures >= s.maxAttempts {
+		return nil, ErrChallengeNotFound
+	}
 
 	// Verify the signature
-	valid, err := verifySignature(alg, publicKey, challengeStr, signature)
-	if err != nil {
+	valid, err := verifySignature(alg, publicKey, s.ChallengeMessage(challenge), signature)
+	if err != nil && !errors.Is(err, ErrInvalidSignature) {
 		return nil, err
 	}
 	if !valid {
+		if err := s.recordFailure(ctx, challenge); err != nil {
+			return nil, err
+		}
+		// Tell a client still signing the bare challenge what changed
+		if legacy, _ := verifySignature(alg, publicKey, challengeStr, signature); legacy {
+			return nil, wrapErr(ErrInvalidSignature, ErrUnboundSignature)
+		}
+		if err != nil {
+			return nil, err
+		}
 		return nil, ErrInvalidSignature
 	}
 
@@ -119,11 +279,12 @@ func (s *Service) VerifyAndCreateToken(ctx context.Context, agentID, alg, public
 		return nil, err
 	}
 
+	now := time.Now().UTC()
 	token := &store.Token{
 		ID:        uuid.New().String(),
 		AgentID:   agentID,
 		Token:     base64.URLEncoding.EncodeToString(tokenBytes),
-		ExpiresAt: time.Now().UTC().Add(s.tokenTTL),
+		ExpiresAt: now.Add(s.tokenTTL),
 	}
 
 	if accountKey != nil {
@@ -133,6 +294,14 @@ func (s *Service) VerifyAndCreateToken(ctx context.Context, agentID, alg, public
 		token.KeyID = "unregistered:" + publicKey[:16]
 	}
 
+	// A JWT is still recorded, so sign-ins show in the store and revoking an
+	// agent's tokens there counts them
+	if s.jwt != nil {
+		if token.Token, err = s.jwt.sign(token, now); err != nil {
+			return nil, err
+		}
+	}
+
 	if err := s.store.CreateToken(ctx, token); err != nil {
 		return nil, err
 	}
@@ -140,8 +309,34 @@ func (s *Service) VerifyAndCreateToken(ctx context.Context, agentID, alg, public
 	return token, nil
 }
 
-// ValidateToken checks if a token is valid and returns the token info
+// recordFailure counts a refused signature against challenge, discarding it
+// once it has used up its attempts
+func (s *Service) recordFailure(ctx context.Context, challenge *store.Challenge) error {
+	if s.maxAttempts <= 0 {
+		return nil
+	}
+	failures, err := s.store.RecordChallengeFailure(ctx, challenge.ID)
+	if err != nil {
+		return err
+	}
+	if failures >= s.maxAttempts {
+		return s.store.DeleteChallenge(ctx, challenge.ID)
+	}
+	return nil
+}
+
+// ValidateToken checks if a token is valid and returns the token info, or
+// nil if it isn't. In JWT mode a JWT is checked without the store, apart from
+// a cached lookup of its agent's revocation.
 func (s *Service) ValidateToken(ctx context.Context, tokenStr string) (*store.Token, error) {
+	if s.jwt != nil && strings.Count(tokenStr, ".") == 2 {
+		token, err := s.jwt.parse(ctx, tokenStr, time.Now())
+		if err != nil {
+			return nil, nil
+		}
+		return token, nil
+	}
+
 	token, err := s.store.GetToken(ctx, tokenStr)
 	if err != nil {
 		return nil, err
@@ -149,45 +344,97 @@ func (s *Service) ValidateToken(ctx context.Context, tokenStr string) (*store.To
 	return token, nil
 }
 
+// verifiers maps each algorithm with a working signature check to its
+// verifier. Algorithms accepted by isValidAlgorithm but missing here (such as
+// secp256k1) are not yet implemented.
+var verifiers = map[string]func(publicKeyStr, message, signatureStr string) (bool, error){
+	AlgEd25519:   verifyEd25519,
+	AlgRSAPSS:    verifyRSAPSS,
+	AlgRSASHA256: verifyRSASHA256,
+}
+
+// SupportedAlgorithms returns the algorithms whose signatures can actually be verified
+func SupportedAlgorithms() []string {
+	algs := make([]string, 0, len(verifiers))
+	for alg := range verifiers {
+		algs = append(algs, alg)
+	}
+	sort.Strings(algs)
+	return algs
+}
+
 // verifySignature verifies a signature based on the algorithm
 func verifySignature(alg, publicKeyStr, message, signatureStr string) (bool, error) {
-	switch alg {
-	case AlgEd25519:
-		return verifyEd25519(publicKeyStr, message, signatureStr)
-	case AlgRSAPSS:
-		return verifyRSAPSS(publicKeyStr, message, signatureStr)
-	case AlgRSASHA256:
-		return verifyRSASHA256(publicKeyStr, message, signatureStr)
-	case AlgSecp256k1:
-		// For MVP, we'll stub secp256k1 and implement later
-		return false, fmt.Errorf("secp256k1 not yet implemented")
-	default:
+	verify, ok := verifiers[alg]
+	if !ok {
+		if alg == AlgSecp256k1 {
+			// For MVP, we'll stub secp256k1 and implement later
+			return false, wrapErr(ErrInvalidAlgorithm, errors.New("secp256k1 not yet implemented"))
+		}
 		return false, ErrInvalidAlgorithm
 	}
+	return verify(publicKeyStr, message, signatureStr)
 }
 
 func verifyEd25519(publicKeyStr, message, signatureStr string) (bool, error) {
-	// Decode public key from base64
-	publicKeyBytes, err := base64.StdEncoding.DecodeString(publicKeyStr)
+	publicKey, err := parseEd25519PublicKey(publicKeyStr)
 	if err != nil {
-		return false, ErrInvalidPublicKey
-	}
-
-	if len(publicKeyBytes) != ed25519.PublicKeySize {
-		return false, ErrInvalidPublicKey
+		return false, err
 	}
 
-	publicKey := ed25519.PublicKey(publicKeyBytes)
-
 	// Decode signature from base64
 	signatureBytes, err := base64.StdEncoding.DecodeString(signatureStr)
 	if err != nil {
-		return false, ErrInvalidSignature
+		return false, wrapErr(ErrInvalidSignature, err)
 	}
 
 	return ed25519.Verify(publicKey, []byte(message), signatureBytes), nil
 }
 
+// ed25519KeyDecoders are tried in turn on a key that isn't PEM
+var ed25519KeyDecoders = []func(string) ([]byte, error){
+	base64.StdEncoding.DecodeString,
+	base64.URLEncoding.DecodeString,
+	base64.RawStdEncoding.DecodeString,
+	base64.RawURLEncoding.DecodeString,
+	hex.DecodeString,
+}
+
+// parseEd25519PublicKey accepts the raw 32-byte key in base64, base64url, or
+// hex, or a PKIX key as PEM or encoded DER
+func parseEd25519PublicKey(publicKeyStr string) (ed25519.PublicKey, error) {
+	if block, _ := pem.Decode([]byte(publicKeyStr)); block != nil {
+		return ed25519FromPKIX(block.Bytes)
+	}
+
+	for _, decode := range ed25519KeyDecoders {
+		keyBytes, err := decode(publicKeyStr)
+		if err != nil {
+			continue
+		}
+		if len(keyBytes) == ed25519.PublicKeySize {
+			return ed25519.PublicKey(keyBytes), nil
+		}
+		if key, err := ed25519FromPKIX(keyBytes); err == nil {
+			return key, nil
+		}
+	}
+
+	return nil, wrapErr(ErrInvalidPublicKey, errors.New("not a 32-byte ed25519 key or PKIX key in base64, base64url, or hex"))
+}
+
+func ed25519FromPKIX(der []byte) (ed25519.PublicKey, error) {
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, wrapErr(ErrInvalidPublicKey, err)
+	}
+	key, ok := pub.(ed25519.PublicKey)
+	if !ok {
+		return nil, wrapErr(ErrInvalidPublicKey, fmt.Errorf("key is %T, not ed25519", pub))
+	}
+	return key, nil
+}
+
 func verifyRSAPSS(publicKeyStr, message, signatureStr string) (bool, error) {
 	publicKey, err := parseRSAPublicKey(publicKeyStr)
 	if err != nil {
@@ -196,12 +443,14 @@ func verifyRSAPSS(publicKeyStr, message, signatureStr string) (bool, error) {
 
 	signatureBytes, err := base64.StdEncoding.DecodeString(signatureStr)
 	if err != nil {
-		return false, ErrInvalidSignature
+		return false, wrapErr(ErrInvalidSignature, err)
 	}
 
 	hash := sha256.Sum256([]byte(message))
-	err = rsa.VerifyPSS(publicKey, crypto.SHA256, hash[:], signatureBytes, nil)
-	return err == nil, nil
+	if err := rsa.VerifyPSS(publicKey, crypto.SHA256, hash[:], signatureBytes, nil); err != nil {
+		return false, wrapErr(ErrInvalidSignature, err)
+	}
+	return true, nil
 }
 
 func verifyRSASHA256(publicKeyStr, message, signatureStr string) (bool, error) {
@@ -212,43 +461,38 @@ func verifyRSASHA256(publicKeyStr, message, signatureStr string) (bool, error) {
 
 	signatureBytes, err := base64.StdEncoding.DecodeString(signatureStr)
 	if err != nil {
-		return false, ErrInvalidSignature
+		return false, wrapErr(ErrInvalidSignature, err)
 	}
 
 	hash := sha256.Sum256([]byte(message))
-	err = rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, hash[:], signatureBytes)
-	return err == nil, nil
+	if err := rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, hash[:], signatureBytes); err != nil {
+		return false, wrapErr(ErrInvalidSignature, err)
+	}
+	return true, nil
 }
 
 func parseRSAPublicKey(publicKeyStr string) (*rsa.PublicKey, error) {
 	// Try PEM format first
-	block, _ := pem.Decode([]byte(publicKeyStr))
-	if block != nil {
-		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	var derBytes []byte
+	if block, _ := pem.Decode([]byte(publicKeyStr)); block != nil {
+		derBytes = block.Bytes
+	} else {
+		// Fall back to base64-encoded DER
+		var err error
+		derBytes, err = base64.StdEncoding.DecodeString(publicKeyStr)
 		if err != nil {
-			return nil, ErrInvalidPublicKey
-		}
-		rsaPub, ok := pub.(*rsa.PublicKey)
-		if !ok {
-			return nil, ErrInvalidPublicKey
+			return nil, wrapErr(ErrInvalidPublicKey, err)
 		}
-		return rsaPub, nil
-	}
-
-	// Try base64-encoded DER
-	derBytes, err := base64.StdEncoding.DecodeString(publicKeyStr)
-	if err != nil {
-		return nil, ErrInvalidPublicKey
 	}
 
 	pub, err := x509.ParsePKIXPublicKey(derBytes)
 	if err != nil {
-		return nil, ErrInvalidPublicKey
+		return nil, wrapErr(ErrInvalidPublicKey, err)
 	}
 
 	rsaPub, ok := pub.(*rsa.PublicKey)
 	if !ok {
-		return nil, ErrInvalidPublicKey
+		return nil, wrapErr(ErrInvalidPublicKey, fmt.Errorf("key is %T, not RSA", pub))
 	}
 
 	return rsaPub, nil
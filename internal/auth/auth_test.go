@@ -2,9 +2,17 @@ package auth
 
 import (
 	"context"
+	"crypto"
 	"crypto/ed25519"
 	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
 	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
 	"os"
 	"testing"
 	"time"
@@ -67,7 +75,7 @@ func TestCreateChallenge(t *testing.T) {
 
 	t.Run("invalid algorithm", func(t *testing.T) {
 		_, err := service.CreateChallenge(ctx, "test-agent", "invalid-alg")
-		if err != ErrInvalidAlgorithm {
+		if !errors.Is(err, ErrInvalidAlgorithm) {
 			t.Errorf("expected ErrInvalidAlgorithm, got %v", err)
 		}
 	})
@@ -96,7 +104,7 @@ func TestVerifyEd25519(t *testing.T) {
 		}
 
 		// Sign the challenge
-		signature := ed25519.Sign(privateKey, []byte(challenge.Challenge))
+		signature := ed25519.Sign(privateKey, []byte(service.ChallengeMessage(challenge)))
 		signatureB64 := base64.StdEncoding.EncodeToString(signature)
 
 		// Verify and create token
@@ -119,7 +127,7 @@ func TestVerifyEd25519(t *testing.T) {
 
 		// Wrong signature
 		_, err := service.VerifyAndCreateToken(ctx, "test-agent", AlgEd25519, publicKeyB64, challenge.Challenge, "invalidsignature")
-		if err != ErrInvalidSignature {
+		if !errors.Is(err, ErrInvalidSignature) {
 			t.Errorf("expected ErrInvalidSignature, got %v", err)
 		}
 	})
@@ -129,14 +137,14 @@ func TestVerifyEd25519(t *testing.T) {
 		expiredService := NewService(sqliteStore, -1*time.Second, 24*time.Hour)
 		challenge, _ := expiredService.CreateChallenge(ctx, "test-agent", AlgEd25519)
 
-		signature := ed25519.Sign(privateKey, []byte(challenge.Challenge))
+		signature := ed25519.Sign(privateKey, []byte(service.ChallengeMessage(challenge)))
 		signatureB64 := base64.StdEncoding.EncodeToString(signature)
 
 		// Wait for expiration
 		time.Sleep(10 * time.Millisecond)
 
 		_, err := service.VerifyAndCreateToken(ctx, "test-agent", AlgEd25519, publicKeyB64, challenge.Challenge, signatureB64)
-		if err != ErrChallengeNotFound && err != ErrChallengeExpired {
+		if !errors.Is(err, ErrChallengeNotFound) && !errors.Is(err, ErrChallengeExpired) {
 			t.Errorf("expected challenge error, got %v", err)
 		}
 	})
@@ -144,17 +152,299 @@ func TestVerifyEd25519(t *testing.T) {
 	t.Run("wrong agent_id", func(t *testing.T) {
 		challenge, _ := service.CreateChallenge(ctx, "test-agent", AlgEd25519)
 
-		signature := ed25519.Sign(privateKey, []byte(challenge.Challenge))
+		signature := ed25519.Sign(privateKey, []byte(service.ChallengeMessage(challenge)))
 		signatureB64 := base64.StdEncoding.EncodeToString(signature)
 
 		// Use different agent_id
 		_, err := service.VerifyAndCreateToken(ctx, "different-agent", AlgEd25519, publicKeyB64, challenge.Challenge, signatureB64)
-		if err != ErrChallengeNotFound {
+		if !errors.Is(err, ErrChallengeNotFound) {
 			t.Errorf("expected ErrChallengeNotFound, got %v", err)
 		}
 	})
 }
 
+func TestChallengeMessageBinding(t *testing.T) {
+	sqliteStore, cleanup := setupTestStore(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	service := NewService(sqliteStore, 5*time.Minute, 24*time.Hour)
+	service.SetChallengeOrigin("https://claw.example")
+
+	publicKey, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	publicKeyB64 := base64.StdEncoding.EncodeToString(publicKey)
+	sign := func(message string) string {
+		return base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, []byte(message)))
+	}
+
+	challenge, err := service.CreateChallenge(ctx, "bound-agent", AlgEd25519)
+	if err != nil {
+		t.Fatalf("CreateChallenge: %v", err)
+	}
+	want := "slashclaw-auth:v1:https://claw.example:bound-agent:ed25519:" + challenge.Challenge
+	if got := service.ChallengeMessage(challenge); got != want {
+		t.Fatalf("ChallengeMessage = %q, want %q", got, want)
+	}
+
+	// The bare challenge, as older clients sign it, is refused with a reason
+	_, err = service.VerifyAndCreateToken(ctx, "bound-agent", AlgEd25519, publicKeyB64, challenge.Challenge, sign(challenge.Challenge))
+	if !errors.Is(err, ErrUnboundSignature) || !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("bare challenge error = %v, want %v wrapped in %v", err, ErrUnboundSignature, ErrInvalidSignature)
+	}
+
+	// A message bound to anything else is just a bad signature
+	for name, message := range map[string]string{
+		"other origin": ChallengeMessage("https://elsewhere.example", "bound-agent", AlgEd25519, challenge.Challenge),
+		"other agent":  ChallengeMessage("https://claw.example", "other-agent", AlgEd25519, challenge.Challenge),
+		"other alg":    ChallengeMessage("https://claw.example", "bound-agent", AlgRSAPSS, challenge.Challenge),
+		"old version":  "slashclaw-auth:v0:https://claw.example:bound-agent:ed25519:" + challenge.Challenge,
+	} {
+		_, err := service.VerifyAndCreateToken(ctx, "bound-agent", AlgEd25519, publicKeyB64, challenge.Challenge, sign(message))
+		if !errors.Is(err, ErrInvalidSignature) || errors.Is(err, ErrUnboundSignature) {
+			t.Errorf("%s: error = %v, want %v", name, err, ErrInvalidSignature)
+		}
+	}
+
+	if _, err := service.VerifyAndCreateToken(ctx, "bound-agent", AlgEd25519, publicKeyB64, challenge.Challenge, sign(want)); err != nil {
+		t.Errorf("bound message: %v", err)
+	}
+}
+
+func TestUnboundSignatureRSA(t *testing.T) {
+	for _, alg := range []string{AlgRSAPSS, AlgRSASHA256} {
+		t.Run(alg, func(t *testing.T) {
+			sqliteStore, cleanup := setupTestStore(t)
+			defer cleanup()
+
+			ctx := context.Background()
+			service := NewService(sqliteStore, 5*time.Minute, 24*time.Hour)
+			publicKey, sign := newTestSigner(t, alg)
+
+			challenge, _ := service.CreateChallenge(ctx, "rsa-agent", alg)
+			_, err := service.VerifyAndCreateToken(ctx, "rsa-agent", alg, publicKey, challenge.Challenge, sign(challenge.Challenge))
+			if !errors.Is(err, ErrUnboundSignature) || !errors.Is(err, ErrInvalidSignature) {
+				t.Errorf("bare challenge error = %v, want %v wrapped in %v", err, ErrUnboundSignature, ErrInvalidSignature)
+			}
+
+			_, err = service.VerifyAndCreateToken(ctx, "rsa-agent", alg, publicKey, challenge.Challenge, sign("something else"))
+			if !errors.Is(err, ErrInvalidSignature) || errors.Is(err, ErrUnboundSignature) {
+				t.Errorf("wrong message error = %v, want %v", err, ErrInvalidSignature)
+			}
+		})
+	}
+}
+
+// newTestSigner returns a fresh public key for alg, encoded as the API takes
+// it, and a function signing messages with its private key
+func newTestSigner(t *testing.T, alg string) (string, func(message string) string) {
+	t.Helper()
+
+	switch alg {
+	case AlgEd25519:
+		publicKey, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+		return base64.StdEncoding.EncodeToString(publicKey), func(message string) string {
+			return base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, []byte(message)))
+		}
+	case AlgRSAPSS, AlgRSASHA256:
+		rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatalf("failed to generate RSA key: %v", err)
+		}
+		der, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+		return base64.StdEncoding.EncodeToString(der), func(message string) string {
+			digest := sha256.Sum256([]byte(message))
+			var sig []byte
+			if alg == AlgRSAPSS {
+				sig, _ = rsa.SignPSS(rand.Reader, rsaKey, crypto.SHA256, digest[:], nil)
+			} else {
+				sig, _ = rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+			}
+			return base64.StdEncoding.EncodeToString(sig)
+		}
+	}
+	t.Fatalf("no test signer for %q", alg)
+	return "", nil
+}
+
+func TestMaxChallengeAttempts(t *testing.T) {
+	for _, alg := range []string{AlgEd25519, AlgRSAPSS, AlgRSASHA256} {
+		t.Run(alg, func(t *testing.T) {
+			sqliteStore, cleanup := setupTestStore(t)
+			defer cleanup()
+
+			ctx := context.Background()
+			service := NewService(sqliteStore, 5*time.Minute, 24*time.Hour)
+			service.SetMaxChallengeAttempts(3)
+
+			publicKey, sign := newTestSigner(t, alg)
+			_, signWrong := newTestSigner(t, alg)
+
+			challenge, _ := service.CreateChallenge(ctx, "guessing-agent", alg)
+			message := service.ChallengeMessage(challenge)
+			wrong := signWrong(message)
+			for i := 1; i <= 3; i++ {
+				if _, err := service.VerifyAndCreateToken(ctx, "guessing-agent", alg, publicKey, challenge.Challenge, wrong); !errors.Is(err, ErrInvalidSignature) {
+					t.Fatalf("bad attempt %d error = %v, want %v", i, err, ErrInvalidSignature)
+				}
+			}
+
+			// The challenge is gone well before it expires, so even the right
+			// signature no longer redeems it
+			if _, err := service.VerifyAndCreateToken(ctx, "guessing-agent", alg, publicKey, challenge.Challenge, sign(message)); !errors.Is(err, ErrChallengeNotFound) {
+				t.Errorf("after 3 bad attempts error = %v, want %v", err, ErrChallengeNotFound)
+			}
+			if got, _ := sqliteStore.GetChallenge(ctx, challenge.Challenge); got != nil {
+				t.Errorf("challenge still stored after 3 bad attempts: %+v", got)
+			}
+
+			// Fewer failures than the limit leave the challenge usable
+			challenge, _ = service.CreateChallenge(ctx, "guessing-agent", alg)
+			message = service.ChallengeMessage(challenge)
+			for i := 1; i <= 2; i++ {
+				service.VerifyAndCreateToken(ctx, "guessing-agent", alg, publicKey, challenge.Challenge, signWrong(message))
+			}
+			if _, err := service.VerifyAndCreateToken(ctx, "guessing-agent", alg, publicKey, challenge.Challenge, sign(message)); err != nil {
+				t.Errorf("right signature after 2 bad attempts: %v", err)
+			}
+		})
+	}
+}
+
+func TestMaxActiveChallenges(t *testing.T) {
+	sqliteStore, cleanup := setupTestStore(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	service := NewService(sqliteStore, 5*time.Minute, 24*time.Hour)
+	service.SetMaxActiveChallenges(5)
+
+	var challenges []*store.Challenge
+	for i := 0; i < 5; i++ {
+		challenge, err := service.CreateChallenge(ctx, "busy-agent", AlgEd25519)
+		if err != nil {
+			t.Fatalf("challenge %d: %v", i+1, err)
+		}
+		challenges = append(challenges, challenge)
+	}
+	if _, err := service.CreateChallenge(ctx, "busy-agent", AlgEd25519); !errors.Is(err, ErrTooManyChallenges) {
+		t.Fatalf("6th challenge error = %v, want ErrTooManyChallenges", err)
+	}
+	if _, err := service.CreateChallenge(ctx, "other-agent", AlgEd25519); err != nil {
+		t.Errorf("another agent's challenge: %v", err)
+	}
+
+	// Verifying a challenge consumes it and frees its slot
+	publicKey, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, []byte(service.ChallengeMessage(challenges[0]))))
+	if _, err := service.VerifyAndCreateToken(ctx, "busy-agent", AlgEd25519, base64.StdEncoding.EncodeToString(publicKey), challenges[0].Challenge, signature); err != nil {
+		t.Fatalf("VerifyAndCreateToken: %v", err)
+	}
+	if _, err := service.CreateChallenge(ctx, "busy-agent", AlgEd25519); err != nil {
+		t.Errorf("challenge after one was consumed: %v", err)
+	}
+
+	// Expired challenges don't hold a slot
+	expiring := NewService(sqliteStore, -time.Second, 24*time.Hour)
+	expiring.SetMaxActiveChallenges(1)
+	for i := 0; i < 2; i++ {
+		if _, err := expiring.CreateChallenge(ctx, "patient-agent", AlgEd25519); err != nil {
+			t.Errorf("challenge %d after the previous expired: %v", i+1, err)
+		}
+	}
+}
+
+func TestEd25519PublicKeyEncodings(t *testing.T) {
+	publicKey, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	der, _ := x509.MarshalPKIXPublicKey(publicKey)
+
+	message := "encoding-check"
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, []byte(message)))
+
+	encodings := map[string]string{
+		"base64":        base64.StdEncoding.EncodeToString(publicKey),
+		"base64 raw":    base64.RawStdEncoding.EncodeToString(publicKey),
+		"base64url":     base64.URLEncoding.EncodeToString(publicKey),
+		"base64url raw": base64.RawURLEncoding.EncodeToString(publicKey),
+		"hex":           hex.EncodeToString(publicKey),
+		"PKIX DER":      base64.StdEncoding.EncodeToString(der),
+		"PKIX PEM":      string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+	}
+
+	for name, encoded := range encodings {
+		t.Run(name, func(t *testing.T) {
+			valid, err := verifySignature(AlgEd25519, encoded, message, signature)
+			if err != nil || !valid {
+				t.Errorf("valid = %v, err = %v; want verified signature", valid, err)
+			}
+		})
+	}
+
+	t.Run("PEM with a non-ed25519 key", func(t *testing.T) {
+		rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+		rsaDER, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+		rsaPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: rsaDER}))
+		if _, err := verifySignature(AlgEd25519, rsaPEM, message, signature); !errors.Is(err, ErrInvalidPublicKey) {
+			t.Errorf("err = %v, want ErrInvalidPublicKey", err)
+		}
+	})
+}
+
+func TestChallengeFormat(t *testing.T) {
+	sqliteStore, cleanup := setupTestStore(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	publicKey, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	publicKeyB64 := base64.StdEncoding.EncodeToString(publicKey)
+
+	tests := []struct {
+		size     int
+		encoding string
+		decode   func(string) ([]byte, error)
+	}{
+		{DefaultChallengeBytes, ChallengeBase64URL, base64.URLEncoding.DecodeString},
+		{MinChallengeBytes, ChallengeBase64URL, base64.URLEncoding.DecodeString},
+		{DefaultChallengeBytes, ChallengeHex, hex.DecodeString},
+		{64, ChallengeHex, hex.DecodeString},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s/%d", tt.encoding, tt.size), func(t *testing.T) {
+			service := NewService(sqliteStore, 5*time.Minute, 24*time.Hour)
+			if err := service.SetChallengeFormat(tt.size, tt.encoding); err != nil {
+				t.Fatalf("SetChallengeFormat: %v", err)
+			}
+
+			challenge, err := service.CreateChallenge(ctx, "test-agent", AlgEd25519)
+			if err != nil {
+				t.Fatalf("failed to create challenge: %v", err)
+			}
+			raw, err := tt.decode(challenge.Challenge)
+			if err != nil {
+				t.Fatalf("challenge %q is not %s: %v", challenge.Challenge, tt.encoding, err)
+			}
+			if len(raw) != tt.size {
+				t.Errorf("challenge carries %d bytes, want %d", len(raw), tt.size)
+			}
+
+			signature := base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, []byte(service.ChallengeMessage(challenge))))
+			if _, err := service.VerifyAndCreateToken(ctx, "test-agent", AlgEd25519, publicKeyB64, challenge.Challenge, signature); err != nil {
+				t.Errorf("verify: %v", err)
+			}
+		})
+	}
+
+	t.Run("rejects weak or unknown formats", func(t *testing.T) {
+		service := NewService(sqliteStore, 5*time.Minute, 24*time.Hour)
+		if err := service.SetChallengeFormat(MinChallengeBytes-1, ChallengeHex); err == nil {
+			t.Error("expected an error for a challenge below the minimum size")
+		}
+		if err := service.SetChallengeFormat(DefaultChallengeBytes, "base32"); err == nil {
+			t.Error("expected an error for an unknown encoding")
+		}
+	})
+}
+
 func TestValidateToken(t *testing.T) {
 	sqliteStore, cleanup := setupTestStore(t)
 	defer cleanup()
@@ -167,7 +457,7 @@ func TestValidateToken(t *testing.T) {
 	publicKeyB64 := base64.StdEncoding.EncodeToString(publicKey)
 
 	challenge, _ := service.CreateChallenge(ctx, "test-agent", AlgEd25519)
-	signature := ed25519.Sign(privateKey, []byte(challenge.Challenge))
+	signature := ed25519.Sign(privateKey, []byte(service.ChallengeMessage(challenge)))
 	signatureB64 := base64.StdEncoding.EncodeToString(signature)
 
 	token, _ := service.VerifyAndCreateToken(ctx, "test-agent", AlgEd25519, publicKeyB64, challenge.Challenge, signatureB64)
@@ -240,3 +530,111 @@ func TestIsValidAlgorithm(t *testing.T) {
 		}
 	}
 }
+
+func TestSupportedAlgorithms(t *testing.T) {
+	message := "capability-check"
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %v", err)
+	}
+	rsaDER, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	rsaPub := base64.StdEncoding.EncodeToString(rsaDER)
+	digest := sha256.Sum256([]byte(message))
+
+	edPub, edPriv, _ := ed25519.GenerateKey(rand.Reader)
+
+	// Every advertised algorithm must round-trip a real signature
+	signers := map[string]func() (pub, sig string){
+		AlgEd25519: func() (string, string) {
+			return base64.StdEncoding.EncodeToString(edPub),
+				base64.StdEncoding.EncodeToString(ed25519.Sign(edPriv, []byte(message)))
+		},
+		AlgRSAPSS: func() (string, string) {
+			sig, _ := rsa.SignPSS(rand.Reader, rsaKey, crypto.SHA256, digest[:], nil)
+			return rsaPub, base64.StdEncoding.EncodeToString(sig)
+		},
+		AlgRSASHA256: func() (string, string) {
+			sig, _ := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+			return rsaPub, base64.StdEncoding.EncodeToString(sig)
+		},
+	}
+
+	for _, alg := range SupportedAlgorithms() {
+		if alg == AlgSecp256k1 {
+			t.Errorf("%q is advertised but not implemented", alg)
+			continue
+		}
+		sign, ok := signers[alg]
+		if !ok {
+			t.Errorf("%q is advertised but has no round-trip test", alg)
+			continue
+		}
+		pub, sig := sign()
+		valid, err := verifySignature(alg, pub, message, sig)
+		if err != nil || !valid {
+			t.Errorf("%q: valid = %v, err = %v; want verified signature", alg, valid, err)
+		}
+	}
+}
+
+func TestVerifyErrorsMatchSentinels(t *testing.T) {
+	_, edPriv, _ := ed25519.GenerateKey(rand.Reader)
+	edPub := base64.StdEncoding.EncodeToString(edPriv.Public().(ed25519.PublicKey))
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %v", err)
+	}
+	der, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	rsaPub := base64.StdEncoding.EncodeToString(der)
+	edDER, _ := x509.MarshalPKIXPublicKey(edPriv.Public())
+	badSig := base64.StdEncoding.EncodeToString([]byte("not a real signature"))
+
+	tests := []struct {
+		name      string
+		alg       string
+		publicKey string
+		signature string
+		want      error
+		wantCause bool
+	}{
+		{"unknown algorithm", "dsa", edPub, badSig, ErrInvalidAlgorithm, false},
+		{"secp256k1 unimplemented", AlgSecp256k1, edPub, badSig, ErrInvalidAlgorithm, true},
+		{"ed25519 key not base64", AlgEd25519, "!!!", badSig, ErrInvalidPublicKey, true},
+		{"ed25519 key wrong size", AlgEd25519, base64.StdEncoding.EncodeToString([]byte("short")), badSig, ErrInvalidPublicKey, true},
+		{"ed25519 signature not base64", AlgEd25519, edPub, "!!!", ErrInvalidSignature, true},
+		{"rsa key not base64", AlgRSAPSS, "!!!", badSig, ErrInvalidPublicKey, true},
+		{"rsa key not DER", AlgRSAPSS, base64.StdEncoding.EncodeToString([]byte("garbage")), badSig, ErrInvalidPublicKey, true},
+		{"rsa key not RSA", AlgRSASHA256, base64.StdEncoding.EncodeToString(edDER), badSig, ErrInvalidPublicKey, true},
+		{"rsa-pss bad signature", AlgRSAPSS, rsaPub, badSig, ErrInvalidSignature, true},
+		{"rsa-sha256 bad signature", AlgRSASHA256, rsaPub, badSig, ErrInvalidSignature, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := verifySignature(tt.alg, tt.publicKey, "message", tt.signature)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want errors.Is %v", err, tt.want)
+			}
+			var authErr *Error
+			if got := errors.As(err, &authErr); got != tt.wantCause {
+				t.Fatalf("errors.As(*Error) = %v, want %v (err = %v)", got, tt.wantCause, err)
+			}
+			if tt.wantCause && (authErr.Kind != tt.want || authErr.Err == nil) {
+				t.Errorf("Kind = %v, Err = %v; want Kind %v with a cause", authErr.Kind, authErr.Err, tt.want)
+			}
+		})
+	}
+
+	t.Run("wrapped cause is matchable", func(t *testing.T) {
+		_, err := parseRSAPublicKey("!!!")
+		var corrupt base64.CorruptInputError
+		if !errors.As(err, &corrupt) {
+			t.Errorf("expected base64.CorruptInputError cause, got %v", err)
+		}
+		_, err = verifySignature(AlgRSAPSS, rsaPub, "message", badSig)
+		if !errors.Is(err, rsa.ErrVerification) {
+			t.Errorf("expected rsa.ErrVerification cause, got %v", err)
+		}
+	})
+}
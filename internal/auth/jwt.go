@@ -0,0 +1,191 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alphabot-ai/slashclaw/internal/store"
+)
+
+// Token modes. Opaque tokens are random strings looked up in the store on
+// every request; JWTs carry their claims and a signature, so they validate
+// without one.
+const (
+	TokenModeOpaque = "opaque"
+	TokenModeJWT    = "jwt"
+)
+
+// MinJWTSecretBytes is the shortest HS256 secret accepted, the size of the
+// SHA-256 output it keys
+const MinJWTSecretBytes = 32
+
+var (
+	ErrTokenInvalid = errors.New("invalid token")
+	ErrTokenExpired = errors.New("token expired")
+	ErrTokenRevoked = errors.New("token revoked")
+)
+
+// jwtHeader is the only header issued or accepted
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// jwtClaims is the payload of an access token JWT
+type jwtClaims struct {
+	ID        string `json:"jti"`
+	AgentID   string `json:"agent_id"`
+	AccountID string `json:"account_id,omitempty"`
+	KeyID     string `json:"key_id"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// revocationRecheck is how long a signer trusts what it last read of an
+// agent's revocation before asking the store again, which bounds how late
+// other instances honour a revocation
+const revocationRecheck = 30 * time.Second
+
+// jwtSigner issues and checks HS256 access tokens. Revoking an agent records
+// a cutoff in the store, rejecting every JWT issued to it until then; parse
+// reads cutoffs through a short-lived cache so most requests skip the store.
+type jwtSigner struct {
+	secret []byte
+	ttl    time.Duration
+	store  store.Store
+
+	mu      sync.Mutex
+	revoked map[string]cachedRevocation // agent id -> its revocation as last read
+	pruned  time.Time
+}
+
+// cachedRevocation is an agent's revocation cutoff, zero if it has none, as
+// of checked
+type cachedRevocation struct {
+	at      time.Time
+	checked time.Time
+}
+
+func newJWTSigner(secret []byte, ttl time.Duration, s store.Store) *jwtSigner {
+	return &jwtSigner{secret: secret, ttl: ttl, store: s, revoked: make(map[string]cachedRevocation)}
+}
+
+// sign returns token as a JWT
+func (j *jwtSigner) sign(token *store.Token, issuedAt time.Time) (string, error) {
+	payload, err := json.Marshal(jwtClaims{
+		ID:        token.ID,
+		AgentID:   token.AgentID,
+		AccountID: token.AccountID,
+		KeyID:     token.KeyID,
+		IssuedAt:  issuedAt.Unix(),
+		ExpiresAt: token.ExpiresAt.Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	signed := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + j.signature(signed), nil
+}
+
+func (j *jwtSigner) signature(signed string) string {
+	mac := hmac.New(sha256.New, j.secret)
+	mac.Write([]byte(signed))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// parse checks a JWT's signature, expiry, and revocation as of now and
+// returns the token it stands for
+func (j *jwtSigner) parse(ctx context.Context, tokenStr string, now time.Time) (*store.Token, error) {
+	header, rest, ok := strings.Cut(tokenStr, ".")
+	if !ok || header != jwtHeader {
+		return nil, ErrTokenInvalid
+	}
+	payload, sig, ok := strings.Cut(rest, ".")
+	if !ok {
+		return nil, ErrTokenInvalid
+	}
+	if !hmac.Equal([]byte(sig), []byte(j.signature(header+"."+payload))) {
+		return nil, ErrTokenInvalid
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, wrapErr(ErrTokenInvalid, err)
+	}
+	var claims jwtClaims
+	if err := json.Unmarshal(raw, &claims); err != nil {
+		return nil, wrapErr(ErrTokenInvalid, err)
+	}
+	if claims.AgentID == "" {
+		return nil, wrapErr(ErrTokenInvalid, fmt.Errorf("no agent_id"))
+	}
+
+	expiresAt := time.Unix(claims.ExpiresAt, 0).UTC()
+	if !now.Before(expiresAt) {
+		return nil, ErrTokenExpired
+	}
+	if j.isRevoked(ctx, claims.AgentID, time.Unix(claims.IssuedAt, 0), now) {
+		return nil, ErrTokenRevoked
+	}
+
+	return &store.Token{
+		ID:        claims.ID,
+		AccountID: claims.AccountID,
+		KeyID:     claims.KeyID,
+		AgentID:   claims.AgentID,
+		Token:     tokenStr,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// revoke rejects the agent's tokens issued up to now. The store keeps the
+// cutoff until all such tokens would have expired anyway.
+func (j *jwtSigner) revoke(ctx context.Context, agentID string, now time.Time) error {
+	if err := j.store.RevokeAgentTokens(ctx, agentID, now, now.Add(j.ttl)); err != nil {
+		return err
+	}
+	j.remember(agentID, cachedRevocation{at: now, checked: now}, now)
+	return nil
+}
+
+// isRevoked reports whether a token issued to agentID at issuedAt falls under
+// its revocation. If the store can't be read, the last cutoff seen is used,
+// so JWTs keep validating through an outage.
+func (j *jwtSigner) isRevoked(ctx context.Context, agentID string, issuedAt, now time.Time) bool {
+	j.mu.Lock()
+	cached, ok := j.revoked[agentID]
+	j.mu.Unlock()
+
+	if !ok || now.Sub(cached.checked) >= revocationRecheck {
+		at, err := j.store.GetAgentRevocation(ctx, agentID)
+		if err != nil {
+			log.Printf("auth: reading revocation of agent %q: %v", agentID, err)
+			at = cached.at
+		}
+		cached = cachedRevocation{at: at, checked: now}
+		j.remember(agentID, cached, now)
+	}
+	return !cached.at.IsZero() && !issuedAt.After(cached.at)
+}
+
+func (j *jwtSigner) remember(agentID string, r cachedRevocation, now time.Time) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	// Drop entries that would be read again anyway
+	if now.Sub(j.pruned) >= revocationRecheck {
+		for id, cached := range j.revoked {
+			if now.Sub(cached.checked) >= revocationRecheck {
+				delete(j.revoked, id)
+			}
+		}
+		j.pruned = now
+	}
+	j.revoked[agentID] = r
+}
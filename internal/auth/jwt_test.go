@@ -0,0 +1,157 @@
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alphabot-ai/slashclaw/internal/store"
+)
+
+var testJWTSecret = []byte("0123456789abcdef0123456789abcdef")
+
+func TestJWTValidatesOffline(t *testing.T) {
+	sqliteStore, cleanup := setupTestStore(t)
+	defer cleanup()
+
+	service := NewService(sqliteStore, 5*time.Minute, time.Hour)
+	if err := service.UseJWT([]byte("too short")); err == nil {
+		t.Error("UseJWT accepted a short secret")
+	}
+	if err := service.UseJWT(testJWTSecret); err != nil {
+		t.Fatalf("UseJWT: %v", err)
+	}
+	ctx := context.Background()
+
+	publicKey, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	challenge, _ := service.CreateChallenge(ctx, "jwt-agent", AlgEd25519)
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, []byte(service.ChallengeMessage(challenge))))
+	token, err := service.VerifyAndCreateToken(ctx, "jwt-agent", AlgEd25519, base64.StdEncoding.EncodeToString(publicKey), challenge.Challenge, signature)
+	if err != nil {
+		t.Fatalf("VerifyAndCreateToken: %v", err)
+	}
+	if strings.Count(token.Token, ".") != 2 {
+		t.Fatalf("token = %q, want a JWT", token.Token)
+	}
+
+	// A revocation read before an outage is still honoured during it
+	if err := service.RevokeAgent(ctx, "revoked-agent"); err != nil {
+		t.Fatalf("RevokeAgent: %v", err)
+	}
+
+	// With the database gone, the JWT still validates
+	sqliteStore.Close()
+	validated, err := service.ValidateToken(ctx, token.Token)
+	if err != nil || validated == nil {
+		t.Fatalf("ValidateToken = %v, %v; want the token", validated, err)
+	}
+	if validated.AgentID != "jwt-agent" || validated.KeyID != token.KeyID || validated.ID != token.ID {
+		t.Errorf("validated = %+v, want the claims of %+v", validated, token)
+	}
+	if !validated.ExpiresAt.Equal(token.ExpiresAt.Truncate(time.Second)) {
+		t.Errorf("expires_at = %v, want %v", validated.ExpiresAt, token.ExpiresAt)
+	}
+	if !service.jwt.isRevoked(ctx, "revoked-agent", time.Now().Add(-time.Minute), time.Now().Add(revocationRecheck)) {
+		t.Error("cached revocation was forgotten when the store went away")
+	}
+}
+
+func TestJWTExpiryAndRevocation(t *testing.T) {
+	sqliteStore, cleanup := setupTestStore(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	signer := newJWTSigner(testJWTSecret, time.Hour, sqliteStore)
+	issued := time.Now().Truncate(time.Second)
+	tokenStr, err := signer.sign(&store.Token{ID: "t1", AgentID: "agent", AccountID: "acct", KeyID: "k1", ExpiresAt: issued.Add(time.Hour)}, issued)
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+
+	token, err := signer.parse(ctx, tokenStr, issued.Add(59*time.Minute))
+	if err != nil || token.AccountID != "acct" || token.KeyID != "k1" || token.Token != tokenStr {
+		t.Errorf("parse before expiry = %+v, %v; want the token", token, err)
+	}
+	if _, err := signer.parse(ctx, tokenStr, issued.Add(time.Hour)); !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("parse at expiry error = %v, want %v", err, ErrTokenExpired)
+	}
+
+	// A revocation covers tokens issued before it, not after
+	if err := signer.revoke(ctx, "agent", issued.Add(time.Minute)); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	if _, err := signer.parse(ctx, tokenStr, issued.Add(2*time.Minute)); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("parse after revoke error = %v, want %v", err, ErrTokenRevoked)
+	}
+	later := issued.Add(5 * time.Minute)
+	laterStr, _ := signer.sign(&store.Token{ID: "t2", AgentID: "agent", KeyID: "k1", ExpiresAt: later.Add(time.Hour)}, later)
+	if _, err := signer.parse(ctx, laterStr, later); err != nil {
+		t.Errorf("parse of a token issued after the revocation: %v", err)
+	}
+}
+
+func TestJWTRevocationIsShared(t *testing.T) {
+	sqliteStore, cleanup := setupTestStore(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	// Two signers on one store stand in for replicas, or a restart
+	revoker := newJWTSigner(testJWTSecret, time.Hour, sqliteStore)
+	replica := newJWTSigner(testJWTSecret, time.Hour, sqliteStore)
+	issued := time.Now().Add(-time.Minute).Truncate(time.Second)
+	tokenStr, _ := revoker.sign(&store.Token{ID: "t1", AgentID: "agent", KeyID: "k1", ExpiresAt: issued.Add(time.Hour)}, issued)
+
+	now := time.Now()
+	if _, err := replica.parse(ctx, tokenStr, now); err != nil {
+		t.Fatalf("parse before revoke: %v", err)
+	}
+	if err := revoker.revoke(ctx, "agent", now); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+
+	// The replica trusts its cached read until it's due a recheck
+	if _, err := replica.parse(ctx, tokenStr, now.Add(revocationRecheck/2)); err != nil {
+		t.Errorf("parse within the recheck interval: %v", err)
+	}
+	if _, err := replica.parse(ctx, tokenStr, now.Add(revocationRecheck)); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("parse after the recheck interval error = %v, want %v", err, ErrTokenRevoked)
+	}
+
+	restarted := newJWTSigner(testJWTSecret, time.Hour, sqliteStore)
+	if _, err := restarted.parse(ctx, tokenStr, now); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("parse on a new signer error = %v, want %v", err, ErrTokenRevoked)
+	}
+}
+
+func TestJWTTampering(t *testing.T) {
+	signer := newJWTSigner(testJWTSecret, time.Hour, nil)
+	now := time.Now()
+	tokenStr, _ := signer.sign(&store.Token{ID: "t1", AgentID: "agent", KeyID: "k1", ExpiresAt: now.Add(time.Hour)}, now)
+	header, rest, _ := strings.Cut(tokenStr, ".")
+	payload, sig, _ := strings.Cut(rest, ".")
+
+	raw, _ := base64.RawURLEncoding.DecodeString(payload)
+	forged := base64.RawURLEncoding.EncodeToString([]byte(strings.Replace(string(raw), `"agent"`, `"admin"`, 1)))
+	noneHeader := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+	otherSigner := newJWTSigner([]byte("fedcba9876543210fedcba9876543210"), time.Hour, nil)
+	otherStr, _ := otherSigner.sign(&store.Token{ID: "t1", AgentID: "agent", KeyID: "k1", ExpiresAt: now.Add(time.Hour)}, now)
+
+	for name, tampered := range map[string]string{
+		"payload":        header + "." + forged + "." + sig,
+		"signature":      header + "." + payload + "." + strings.Repeat("A", len(sig)),
+		"alg none":       noneHeader + "." + payload + ".",
+		"missing part":   header + "." + payload,
+		"other secret":   otherStr,
+		"not a JWT":      "opaque-token",
+		"trailing parts": tokenStr + ".extra",
+	} {
+		if _, err := signer.parse(context.Background(), tampered, now); !errors.Is(err, ErrTokenInvalid) {
+			t.Errorf("%s: parse error = %v, want %v", name, err, ErrTokenInvalid)
+		}
+	}
+}
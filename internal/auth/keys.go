@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+// rsaKeyBits is the size of RSA keys made by GenerateKey
+const rsaKeyBits = 2048
+
+// GenerateKey creates a keypair for alg. It returns the private key as a
+// PKCS#8 PEM block and the public key in the form the verify endpoint
+// expects: raw base64 for ed25519, base64 PKIX DER for RSA.
+func GenerateKey(alg string) (privatePEM []byte, publicKey string, err error) {
+	var priv crypto.Signer
+	switch alg {
+	case AlgEd25519:
+		_, priv, err = ed25519.GenerateKey(rand.Reader)
+	case AlgRSAPSS, AlgRSASHA256:
+		priv, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	default:
+		return nil, "", wrapErr(ErrInvalidAlgorithm, fmt.Errorf("cannot generate %q keys", alg))
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, "", err
+	}
+	privatePEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	publicKey, err = encodePublicKey(priv.Public())
+	if err != nil {
+		return nil, "", err
+	}
+	return privatePEM, publicKey, nil
+}
+
+// Sign signs message with a PEM private key and returns the base64 signature
+// the verify endpoint expects. The key may be PKCS#8, as GenerateKey makes,
+// or for RSA the PKCS#1 "RSA PRIVATE KEY" that openssl genrsa writes.
+func Sign(alg string, privatePEM []byte, message string) (string, error) {
+	key, err := parsePrivateKey(privatePEM)
+	if err != nil {
+		return "", err
+	}
+
+	var sig []byte
+	switch priv := key.(type) {
+	case ed25519.PrivateKey:
+		if alg != AlgEd25519 {
+			return "", wrapErr(ErrInvalidAlgorithm, fmt.Errorf("ed25519 key cannot sign %q", alg))
+		}
+		sig = ed25519.Sign(priv, []byte(message))
+	case *rsa.PrivateKey:
+		hash := sha256.Sum256([]byte(message))
+		switch alg {
+		case AlgRSAPSS:
+			sig, err = rsa.SignPSS(rand.Reader, priv, crypto.SHA256, hash[:], nil)
+		case AlgRSASHA256:
+			sig, err = rsa.SignPKCS1v15(rand.Reader, priv, crypto.SHA256, hash[:])
+		default:
+			return "", wrapErr(ErrInvalidAlgorithm, fmt.Errorf("RSA key cannot sign %q", alg))
+		}
+		if err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("unsupported private key type %T", key)
+	}
+	return base64.StdEncoding.EncodeToString(sig), nil
+}
+
+func parsePrivateKey(privatePEM []byte) (any, error) {
+	block, _ := pem.Decode(privatePEM)
+	if block == nil {
+		return nil, errors.New("private key is not PEM encoded")
+	}
+	if block.Type == "RSA PRIVATE KEY" {
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	return x509.ParsePKCS8PrivateKey(block.Bytes)
+}
+
+func encodePublicKey(pub crypto.PublicKey) (string, error) {
+	if edPub, ok := pub.(ed25519.PublicKey); ok {
+		return base64.StdEncoding.EncodeToString(edPub), nil
+	}
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(der), nil
+}
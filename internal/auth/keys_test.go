@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestGenerateKeyAndSign(t *testing.T) {
+	sqliteStore, cleanup := setupTestStore(t)
+	defer cleanup()
+
+	service := NewService(sqliteStore, 5*time.Minute, 24*time.Hour)
+	ctx := context.Background()
+
+	for _, alg := range SupportedAlgorithms() {
+		t.Run(alg, func(t *testing.T) {
+			privatePEM, publicKey, err := GenerateKey(alg)
+			if err != nil {
+				t.Fatalf("GenerateKey: %v", err)
+			}
+
+			challenge, err := service.CreateChallenge(ctx, "keygen-agent", alg)
+			if err != nil {
+				t.Fatalf("failed to create challenge: %v", err)
+			}
+			signature, err := Sign(alg, privatePEM, service.ChallengeMessage(challenge))
+			if err != nil {
+				t.Fatalf("Sign: %v", err)
+			}
+
+			token, err := service.VerifyAndCreateToken(ctx, "keygen-agent", alg, publicKey, challenge.Challenge, signature)
+			if err != nil {
+				t.Fatalf("generated key failed verification: %v", err)
+			}
+			if token.Token == "" {
+				t.Error("token should not be empty")
+			}
+		})
+	}
+
+	t.Run("unsupported algorithm", func(t *testing.T) {
+		if _, _, err := GenerateKey(AlgSecp256k1); !errors.Is(err, ErrInvalidAlgorithm) {
+			t.Errorf("GenerateKey(secp256k1) err = %v, want ErrInvalidAlgorithm", err)
+		}
+	})
+
+	t.Run("pkcs1 rsa key", func(t *testing.T) {
+		priv, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+		if err != nil {
+			t.Fatalf("rsa.GenerateKey: %v", err)
+		}
+		privatePEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)})
+		publicKey, err := encodePublicKey(priv.Public())
+		if err != nil {
+			t.Fatalf("encodePublicKey: %v", err)
+		}
+
+		for _, alg := range []string{AlgRSAPSS, AlgRSASHA256} {
+			signature, err := Sign(alg, privatePEM, "pkcs1-challenge")
+			if err != nil {
+				t.Fatalf("Sign(%s): %v", alg, err)
+			}
+			if ok, err := verifySignature(alg, publicKey, "pkcs1-challenge", signature); !ok || err != nil {
+				t.Errorf("%s signature failed verification: %v", alg, err)
+			}
+		}
+	})
+
+	t.Run("key and algorithm mismatch", func(t *testing.T) {
+		privatePEM, _, _ := GenerateKey(AlgEd25519)
+		if _, err := Sign(AlgRSAPSS, privatePEM, "challenge"); !errors.Is(err, ErrInvalidAlgorithm) {
+			t.Errorf("Sign(rsa-pss, ed25519 key) err = %v, want ErrInvalidAlgorithm", err)
+		}
+	})
+}
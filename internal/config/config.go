@@ -1,54 +1,305 @@
 package config
 
 import (
+	"fmt"
+	"net/netip"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 )
 
+// What CreateStory does with a text post whose body matches a recent one
+const (
+	DuplicateTextBlock = "block" // return the earlier story instead, like a duplicate URL
+	DuplicateTextFlag  = "flag"  // accept it but hold it for moderator approval
+	DuplicateTextOff   = "off"   // accept it
+)
+
+// Comment orderings and layouts a surface can default to
+var (
+	CommentSorts = []string{"top", "new", "old", "controversial"}
+	CommentViews = []string{"tree", "flat"}
+)
+
+// Rate limiting algorithms: fixed windows, or token buckets that refill steadily
+var RateLimitAlgos = []string{"window", "bucket"}
+
+// Access token formats: random strings checked against the database, or
+// signed JWTs checked without it
+var TokenModes = []string{"opaque", "jwt"}
+
 type Config struct {
 	// Server
-	Port        int
-	Host        string
-	BaseURL     string
-	AdminSecret string
+	Port         int
+	Host         string
+	BaseURL      string
+	InstanceName string // how this instance names itself to other instances and directories
+	AdminSecret  string
+	LogFormat    string // "text" or "json"
+	LogBodies    bool   // log write request bodies, with credentials redacted
+	MaxBodyBytes int    // largest request body a POST, PUT, PATCH, or DELETE may send; 0 disables the limit
+
+	// TrustedProxies are the CIDR ranges (or single IPs) of reverse proxies
+	// whose X-Forwarded-For and X-Real-IP headers are believed. A request
+	// from anywhere else is identified by its own address.
+	TrustedProxies []string
+
+	// CORS
+	CORSOrigins          []string      // origins allowed to call the API from a browser; "*" allows any
+	CORSMaxAge           time.Duration // how long browsers may cache a preflight response
+	CORSAllowCredentials bool          // allow cookies and auth headers from explicitly listed origins
 
 	// Database
 	DatabasePath string
+	DatabaseURL  string // postgres:// URL; when set, used instead of DatabasePath
 
 	// Rate Limiting
-	StoryRateLimit   int           // per hour
-	CommentRateLimit int           // per hour
-	VoteRateLimit    int           // per hour
+	StoryRateLimit   int // per hour
+	CommentRateLimit int // per hour
+	VoteRateLimit    int // per hour
 	RateLimitWindow  time.Duration
+	RedisURL         string  // redis:// URL; when set, limits are shared through Redis
+	RateLimitAlgo    string  // one of RateLimitAlgos
+	RateLimitBurst   float64 // bucket: share of a limit that may be spent at once
+
+	GlobalRateLimit int           // requests per GlobalWindow from one IP across all routes; 0 disables
+	GlobalWindow    time.Duration // window for GlobalRateLimit
 
 	// Auth
 	ChallengeTTL time.Duration
 	TokenTTL     time.Duration
 
+	ChallengeBytes       int    // random bytes per challenge, at least 16
+	ChallengeEncoding    string // "base64url" or "hex"
+	MaxChallenges        int    // unexpired challenges one agent may hold; 0 is unlimited
+	MaxChallengeAttempts int    // refused signatures before a challenge is discarded; 0 is unlimited
+
+	// AuthOrigin is bound into the challenge message agents sign, so a
+	// signature made for another server is refused; "" uses BaseURL
+	AuthOrigin string
+
+	TokenMode string // one of TokenModes
+	JWTSecret string // HS256 key for TokenMode jwt, at least 32 bytes
+
+	CleanupInterval time.Duration // how often expired challenges and tokens are deleted; 0 disables
+
+	// Votes
+	AllowAnonymousVotes bool // accept IP-only votes without a token
+	VoteWeightVerified  int  // how far a verified agent's vote moves a score
+	VoteWeightAnon      int  // how far any other vote moves a score
+
+	// VoteChangeCooldown is how long a voter must wait after casting or
+	// changing a vote before changing or retracting it; 0 disables
+	VoteChangeCooldown time.Duration
+
+	// Flags
+	FlagThreshold int // distinct flags that hide a story or comment; 0 disables auto-hiding
+
+	// Accounts
+	IdempotentAccountCreate    bool          // return the existing account when a retried creation reuses a fresh key
+	AccountRetryWindow         time.Duration // how recently the account must have been created to count as a retry
+	PruneInactiveAccountsAfter time.Duration // delete accounts idle this long with nothing posted; 0 keeps them forever
+
+	// Story rules
+	TitleMinLength  int  // fewest characters (runes) in a story title
+	TitleMaxLength  int  // most characters (runes) in a story title
+	AllowURLAndText bool // accept stories with both a URL and text, not just one of them
+	MaxTags         int  // most tags on a story; 0 disables the limit
+
 	// Content
-	DuplicateWindow time.Duration
-	PostCooldown    time.Duration // minimum time between posts per agent
+	DuplicateWindow  time.Duration
+	PostCooldown     time.Duration // minimum time between posts per agent
+	PreModerate      bool          // hold new stories for moderator approval before publishing
+	EditWindow       time.Duration // how long after posting a comment may be edited
+	MaxTreeComments  int           // most comments loaded for an unpaginated tree view
+	ScrubDeleted     bool          // replace a deleted comment's text with a tombstone, not just its author
+	MaxCommentDepth  int           // deepest a reply may nest, top-level comments being depth 0; 0 disables the limit
+	MaxCommentLength int           // most characters (runes) in a comment; 0 disables the limit
+	MaxTextLength    int           // most characters (runes) in a text story's body; 0 disables the limit
+	IdempotencyTTL   time.Duration // how long an Idempotency-Key replays its original response; 0 ignores the header
+
+	// Title fetching
+	FetchTitles       bool          // title URL stories submitted without one from the linked page
+	FetchTitleTimeout time.Duration // how long fetching a page's title may take
+
+	// Duplicate text posts
+	DuplicateText       string        // one of the DuplicateText* modes
+	DuplicateTextWindow time.Duration // how far back an identical text post counts as a repost
+
+	// Ranking
+	RankGravity    float64 // how quickly SortTop scores decay with age
+	RankOffset     float64 // hours added to a story's age before decaying
+	RankScoreFloor int     // lowest score SortTop ranks by; 0 disables the floor
+
+	// Comment defaults when a request doesn't choose; "" means top and tree
+	WebCommentSort string // story pages: one of CommentSorts
+	WebCommentView string // story pages: one of CommentViews
+	APICommentSort string // GET /api/stories/{id}/comments: one of CommentSorts
+	APICommentView string // GET /api/stories/{id}/comments: one of CommentViews
+
+	// Web
+	CommentsPerPage     int           // top-level comments per story page; 0 shows all
+	EmptyListingMessage string        // home page text when there are no stories; "" prompts visitors to submit one
+	ListCacheTTL        time.Duration // how long story listings are cached; 0 only coalesces concurrent identical queries
 }
 
 func Load() *Config {
 	return &Config{
-		Port:             getEnvInt("PORT", 8080),
-		Host:             getEnv("HOST", "0.0.0.0"),
-		BaseURL:          getEnv("BASE_URL", "http://localhost:8080"),
-		AdminSecret:      getEnv("ADMIN_SECRET", ""),
-		DatabasePath:     getEnv("DATABASE_PATH", "slashclaw.db"),
-		StoryRateLimit:   getEnvInt("STORY_RATE_LIMIT", 10),
-		CommentRateLimit: getEnvInt("COMMENT_RATE_LIMIT", 60),
-		VoteRateLimit:    getEnvInt("VOTE_RATE_LIMIT", 120),
-		RateLimitWindow:  getEnvDuration("RATE_LIMIT_WINDOW", time.Hour),
-		ChallengeTTL:     getEnvDuration("CHALLENGE_TTL", 5*time.Minute),
-		TokenTTL:         getEnvDuration("TOKEN_TTL", 24*time.Hour),
-		DuplicateWindow:  getEnvDuration("DUPLICATE_WINDOW", 30*24*time.Hour),
-		PostCooldown:     getEnvDuration("POST_COOLDOWN", 60*time.Second),
+		Port:                       getEnvInt("PORT", 8080),
+		Host:                       getEnv("HOST", "0.0.0.0"),
+		BaseURL:                    getEnv("BASE_URL", "http://localhost:8080"),
+		InstanceName:               getEnv("INSTANCE_NAME", "Slashclaw"),
+		AdminSecret:                getEnv("ADMIN_SECRET", ""),
+		LogFormat:                  getEnv("LOG_FORMAT", "text"),
+		LogBodies:                  getEnvBool("DEBUG_LOG_BODIES", false),
+		MaxBodyBytes:               getEnvInt("MAX_BODY_BYTES", 1<<20),
+		TrustedProxies:             getEnvList("TRUSTED_PROXIES"),
+		CORSOrigins:                getEnvList("CORS_ALLOWED_ORIGINS"),
+		CORSMaxAge:                 getEnvDuration("CORS_MAX_AGE", 10*time.Minute),
+		CORSAllowCredentials:       getEnvBool("CORS_ALLOW_CREDENTIALS", false),
+		DatabasePath:               getEnv("DATABASE_PATH", "slashclaw.db"),
+		DatabaseURL:                getEnv("DATABASE_URL", ""),
+		StoryRateLimit:             getEnvInt("STORY_RATE_LIMIT", 10),
+		CommentRateLimit:           getEnvInt("COMMENT_RATE_LIMIT", 60),
+		VoteRateLimit:              getEnvInt("VOTE_RATE_LIMIT", 120),
+		RateLimitWindow:            getEnvDuration("RATE_LIMIT_WINDOW", time.Hour),
+		RedisURL:                   getEnv("REDIS_URL", ""),
+		RateLimitAlgo:              getEnv("RATE_LIMIT_ALGO", "window"),
+		RateLimitBurst:             getEnvFloat("RATE_LIMIT_BURST", 1),
+		GlobalRateLimit:            getEnvInt("GLOBAL_RATE_LIMIT", 600),
+		GlobalWindow:               getEnvDuration("GLOBAL_RATE_LIMIT_WINDOW", time.Minute),
+		ChallengeTTL:               getEnvDuration("CHALLENGE_TTL", 5*time.Minute),
+		TokenTTL:                   getEnvDuration("TOKEN_TTL", 24*time.Hour),
+		ChallengeBytes:             getEnvInt("CHALLENGE_BYTES", 32),
+		ChallengeEncoding:          getEnv("CHALLENGE_ENCODING", "base64url"),
+		MaxChallenges:              getEnvInt("MAX_ACTIVE_CHALLENGES", 5),
+		MaxChallengeAttempts:       getEnvInt("MAX_CHALLENGE_ATTEMPTS", 3),
+		AuthOrigin:                 getEnv("AUTH_ORIGIN", ""),
+		TokenMode:                  getEnv("TOKEN_MODE", "opaque"),
+		JWTSecret:                  getEnv("JWT_SECRET", ""),
+		CleanupInterval:            getEnvDuration("CLEANUP_INTERVAL", 10*time.Minute),
+		AllowAnonymousVotes:        getEnvBool("ALLOW_ANONYMOUS_VOTES", false),
+		VoteWeightVerified:         getEnvInt("VOTE_WEIGHT_VERIFIED", 1),
+		VoteWeightAnon:             getEnvInt("VOTE_WEIGHT_ANON", 1),
+		VoteChangeCooldown:         getEnvDuration("VOTE_CHANGE_COOLDOWN", 0),
+		FlagThreshold:              getEnvInt("FLAG_THRESHOLD", 5),
+		IdempotentAccountCreate:    getEnvBool("IDEMPOTENT_ACCOUNT_CREATE", false),
+		AccountRetryWindow:         getEnvDuration("ACCOUNT_RETRY_WINDOW", 10*time.Minute),
+		PruneInactiveAccountsAfter: getEnvDuration("PRUNE_INACTIVE_ACCOUNTS_AFTER", 0),
+		TitleMinLength:             getEnvInt("TITLE_MIN_LEN", 8),
+		TitleMaxLength:             getEnvInt("TITLE_MAX_LEN", 180),
+		AllowURLAndText:            getEnvBool("ALLOW_URL_AND_TEXT", false),
+		MaxTags:                    getEnvInt("MAX_TAGS", 5),
+		DuplicateWindow:            getEnvDuration("DUPLICATE_WINDOW", 30*24*time.Hour),
+		PostCooldown:               getEnvDuration("POST_COOLDOWN", 60*time.Second),
+		PreModerate:                getEnvBool("PRE_MODERATE", false),
+		EditWindow:                 getEnvDuration("EDIT_WINDOW", 15*time.Minute),
+		MaxTreeComments:            getEnvInt("MAX_TREE_COMMENTS", 1000),
+		ScrubDeleted:               getEnvBool("SCRUB_DELETED_COMMENTS", true),
+		MaxCommentDepth:            getEnvInt("MAX_COMMENT_DEPTH", 8),
+		MaxCommentLength:           getEnvInt("MAX_COMMENT_LENGTH", 10000),
+		MaxTextLength:              getEnvInt("MAX_TEXT_LENGTH", 40000),
+		IdempotencyTTL:             getEnvDuration("IDEMPOTENCY_TTL", 24*time.Hour),
+		FetchTitles:                getEnvBool("FETCH_TITLES", false),
+		FetchTitleTimeout:          getEnvDuration("FETCH_TITLE_TIMEOUT", 3*time.Second),
+		DuplicateText:              getEnv("DUPLICATE_TEXT", DuplicateTextBlock),
+		DuplicateTextWindow:        getEnvDuration("DUPLICATE_TEXT_WINDOW", 24*time.Hour),
+		RankGravity:                getEnvFloat("RANK_GRAVITY", 1.5),
+		RankOffset:                 getEnvFloat("RANK_OFFSET", 2),
+		RankScoreFloor:             getEnvInt("RANK_SCORE_FLOOR", -5),
+		WebCommentSort:             getEnv("WEB_DEFAULT_COMMENT_SORT", "top"),
+		WebCommentView:             getEnv("WEB_DEFAULT_COMMENT_VIEW", "tree"),
+		APICommentSort:             getEnv("API_DEFAULT_COMMENT_SORT", "top"),
+		APICommentView:             getEnv("API_DEFAULT_COMMENT_VIEW", "tree"),
+		CommentsPerPage:            getEnvInt("COMMENTS_PER_PAGE", 50),
+		EmptyListingMessage:        getEnv("EMPTY_LISTING_MESSAGE", ""),
+		ListCacheTTL:               getEnvDuration("LIST_CACHE_TTL", 2*time.Second),
 	}
 }
 
+// Validate rejects settings Load can't make sense of, so a typo stops the
+// server at startup instead of quietly falling back to a default
+func (c *Config) Validate() error {
+	choices := []struct {
+		name    string
+		value   string
+		allowed []string
+	}{
+		{"WEB_DEFAULT_COMMENT_SORT", c.WebCommentSort, CommentSorts},
+		{"WEB_DEFAULT_COMMENT_VIEW", c.WebCommentView, CommentViews},
+		{"API_DEFAULT_COMMENT_SORT", c.APICommentSort, CommentSorts},
+		{"API_DEFAULT_COMMENT_VIEW", c.APICommentView, CommentViews},
+		{"RATE_LIMIT_ALGO", c.RateLimitAlgo, RateLimitAlgos},
+		{"TOKEN_MODE", c.TokenMode, TokenModes},
+	}
+	for _, choice := range choices {
+		if !slices.Contains(choice.allowed, choice.value) {
+			return fmt.Errorf("%s must be one of %s, got %q", choice.name, strings.Join(choice.allowed, ", "), choice.value)
+		}
+	}
+	if c.RateLimitAlgo == "bucket" && c.RedisURL != "" {
+		return fmt.Errorf("RATE_LIMIT_ALGO=bucket is in-memory only and can't be combined with REDIS_URL")
+	}
+	if c.RateLimitBurst <= 0 || c.RateLimitBurst > 1 {
+		return fmt.Errorf("RATE_LIMIT_BURST must be above 0 and at most 1, got %g", c.RateLimitBurst)
+	}
+	if c.TokenMode == "jwt" && len(c.JWTSecret) < 32 {
+		return fmt.Errorf("TOKEN_MODE=jwt needs a JWT_SECRET of at least 32 bytes")
+	}
+	if c.TitleMinLength < 1 || c.TitleMaxLength < c.TitleMinLength {
+		return fmt.Errorf("TITLE_MIN_LEN must be at least 1 and at most TITLE_MAX_LEN, got %d and %d", c.TitleMinLength, c.TitleMaxLength)
+	}
+	if c.MaxBodyBytes < 0 {
+		return fmt.Errorf("MAX_BODY_BYTES must not be negative, got %d", c.MaxBodyBytes)
+	}
+	if c.MaxTags < 0 {
+		return fmt.Errorf("MAX_TAGS must not be negative, got %d", c.MaxTags)
+	}
+	if c.VoteChangeCooldown < 0 {
+		return fmt.Errorf("VOTE_CHANGE_COOLDOWN must not be negative, got %v", c.VoteChangeCooldown)
+	}
+	if c.VoteWeightVerified < 1 || c.VoteWeightAnon < 1 {
+		return fmt.Errorf("VOTE_WEIGHT_VERIFIED and VOTE_WEIGHT_ANON must be at least 1, got %d and %d", c.VoteWeightVerified, c.VoteWeightAnon)
+	}
+	if _, err := c.TrustedProxyPrefixes(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// TrustedProxyPrefixes parses TrustedProxies, a bare IP being a range of one
+func (c *Config) TrustedProxyPrefixes() ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(c.TrustedProxies))
+	for _, proxy := range c.TrustedProxies {
+		if addr, err := netip.ParseAddr(proxy); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("TRUSTED_PROXIES: %q is not an IP or CIDR range", proxy)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// ChallengeOrigin returns the origin bound into challenge messages: AuthOrigin,
+// or BaseURL when that is unset
+func (c *Config) ChallengeOrigin() string {
+	if c.AuthOrigin != "" {
+		return c.AuthOrigin
+	}
+	return c.BaseURL
+}
+
+// UsePostgres reports whether DatabaseURL selects the PostgreSQL store
+func (c *Config) UsePostgres() bool {
+	return strings.HasPrefix(c.DatabaseURL, "postgres://") || strings.HasPrefix(c.DatabaseURL, "postgresql://")
+}
+
 func getEnv(key, defaultVal string) string {
 	if val := os.Getenv(key); val != "" {
 		return val
@@ -56,6 +307,17 @@ func getEnv(key, defaultVal string) string {
 	return defaultVal
 }
 
+// getEnvList splits a comma-separated variable, dropping empty entries
+func getEnvList(key string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
 func getEnvInt(key string, defaultVal int) int {
 	if val := os.Getenv(key); val != "" {
 		if i, err := strconv.Atoi(val); err == nil {
@@ -65,6 +327,24 @@ func getEnvInt(key string, defaultVal int) int {
 	return defaultVal
 }
 
+func getEnvFloat(key string, defaultVal float64) float64 {
+	if val := os.Getenv(key); val != "" {
+		if f, err := strconv.ParseFloat(val, 64); err == nil {
+			return f
+		}
+	}
+	return defaultVal
+}
+
+func getEnvBool(key string, defaultVal bool) bool {
+	if val := os.Getenv(key); val != "" {
+		if b, err := strconv.ParseBool(val); err == nil {
+			return b
+		}
+	}
+	return defaultVal
+}
+
 func getEnvDuration(key string, defaultVal time.Duration) time.Duration {
 	if val := os.Getenv(key); val != "" {
 		if d, err := time.ParseDuration(val); err == nil {
@@ -2,6 +2,7 @@ package config
 
 import (
 	"os"
+	"strings"
 	"testing"
 	"time"
 )
@@ -23,6 +24,21 @@ func TestLoadDefaults(t *testing.T) {
 	if cfg.DatabasePath != "slashclaw.db" {
 		t.Errorf("DatabasePath = %q, want \"slashclaw.db\"", cfg.DatabasePath)
 	}
+	if cfg.LogBodies {
+		t.Error("LogBodies should be off by default")
+	}
+	if cfg.FetchTitles {
+		t.Error("FetchTitles should be off by default")
+	}
+	if cfg.MaxChallenges != 5 {
+		t.Errorf("MaxChallenges = %d, want 5", cfg.MaxChallenges)
+	}
+	if cfg.MaxChallengeAttempts != 3 {
+		t.Errorf("MaxChallengeAttempts = %d, want 3", cfg.MaxChallengeAttempts)
+	}
+	if cfg.RateLimitAlgo != "window" || cfg.RateLimitBurst != 1 {
+		t.Errorf("RateLimitAlgo, RateLimitBurst = %q, %g; want window, 1", cfg.RateLimitAlgo, cfg.RateLimitBurst)
+	}
 	if cfg.StoryRateLimit != 10 {
 		t.Errorf("StoryRateLimit = %d, want 10", cfg.StoryRateLimit)
 	}
@@ -41,6 +57,160 @@ func TestLoadDefaults(t *testing.T) {
 	if cfg.PostCooldown != 60*time.Second {
 		t.Errorf("PostCooldown = %v, want 60s", cfg.PostCooldown)
 	}
+	if cfg.ChallengeBytes != 32 || cfg.ChallengeEncoding != "base64url" {
+		t.Errorf("challenge format = %d/%q, want 32/\"base64url\"", cfg.ChallengeBytes, cfg.ChallengeEncoding)
+	}
+	if cfg.AllowAnonymousVotes {
+		t.Errorf("AllowAnonymousVotes = true, want false")
+	}
+	if cfg.TokenMode != "opaque" {
+		t.Errorf("TokenMode = %q, want opaque", cfg.TokenMode)
+	}
+	if cfg.TitleMinLength != 8 || cfg.TitleMaxLength != 180 || cfg.AllowURLAndText || cfg.MaxTags != 5 {
+		t.Errorf("story rules = %d-%d, both %v, %d tags; want 8-180, false, 5", cfg.TitleMinLength, cfg.TitleMaxLength, cfg.AllowURLAndText, cfg.MaxTags)
+	}
+	if cfg.VoteWeightVerified != 1 || cfg.VoteWeightAnon != 1 {
+		t.Errorf("VoteWeightVerified, VoteWeightAnon = %d, %d; want 1, 1", cfg.VoteWeightVerified, cfg.VoteWeightAnon)
+	}
+	if cfg.DuplicateText != DuplicateTextBlock || cfg.DuplicateTextWindow != 24*time.Hour {
+		t.Errorf("DuplicateText, DuplicateTextWindow = %q, %v; want block, 24h", cfg.DuplicateText, cfg.DuplicateTextWindow)
+	}
+	if cfg.VoteChangeCooldown != 0 {
+		t.Errorf("VoteChangeCooldown = %v, want 0 (disabled)", cfg.VoteChangeCooldown)
+	}
+	if cfg.CleanupInterval != 10*time.Minute {
+		t.Errorf("CleanupInterval = %v, want 10m", cfg.CleanupInterval)
+	}
+	if cfg.MaxCommentDepth != 8 {
+		t.Errorf("MaxCommentDepth = %d, want 8", cfg.MaxCommentDepth)
+	}
+	if cfg.PruneInactiveAccountsAfter != 0 {
+		t.Errorf("PruneInactiveAccountsAfter = %v, want 0 (disabled)", cfg.PruneInactiveAccountsAfter)
+	}
+	if cfg.MaxBodyBytes != 1<<20 {
+		t.Errorf("MaxBodyBytes = %d, want 1MB", cfg.MaxBodyBytes)
+	}
+	if cfg.AuthOrigin != "" || cfg.ChallengeOrigin() != cfg.BaseURL {
+		t.Errorf("AuthOrigin, ChallengeOrigin() = %q, %q; want unset, falling back to BASE_URL %q", cfg.AuthOrigin, cfg.ChallengeOrigin(), cfg.BaseURL)
+	}
+	if cfg.MaxCommentLength != 10000 || cfg.MaxTextLength != 40000 {
+		t.Errorf("MaxCommentLength, MaxTextLength = %d, %d; want 10000, 40000", cfg.MaxCommentLength, cfg.MaxTextLength)
+	}
+	if cfg.RankGravity != 1.5 || cfg.RankOffset != 2 {
+		t.Errorf("RankGravity, RankOffset = %v, %v; want 1.5, 2", cfg.RankGravity, cfg.RankOffset)
+	}
+	if cfg.RankScoreFloor != -5 {
+		t.Errorf("RankScoreFloor = %d, want -5", cfg.RankScoreFloor)
+	}
+	if cfg.WebCommentSort != "top" || cfg.WebCommentView != "tree" || cfg.APICommentSort != "top" || cfg.APICommentView != "tree" {
+		t.Errorf("comment defaults = %s/%s web, %s/%s API; want top/tree for both",
+			cfg.WebCommentSort, cfg.WebCommentView, cfg.APICommentSort, cfg.APICommentView)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate() = %v, want defaults to be valid", err)
+	}
+}
+
+func TestValidateCommentDefaults(t *testing.T) {
+	os.Setenv("WEB_DEFAULT_COMMENT_SORT", "new")
+	os.Setenv("API_DEFAULT_COMMENT_VIEW", "flat")
+	defer os.Unsetenv("WEB_DEFAULT_COMMENT_SORT")
+	defer os.Unsetenv("API_DEFAULT_COMMENT_VIEW")
+
+	cfg := Load()
+	if cfg.WebCommentSort != "new" || cfg.APICommentView != "flat" {
+		t.Errorf("WebCommentSort, APICommentView = %q, %q; want new, flat", cfg.WebCommentSort, cfg.APICommentView)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate() = %v", err)
+	}
+
+	os.Setenv("API_DEFAULT_COMMENT_VIEW", "threaded")
+	err := Load().Validate()
+	if err == nil || !strings.Contains(err.Error(), "API_DEFAULT_COMMENT_VIEW") {
+		t.Errorf("Validate() = %v, want an error naming API_DEFAULT_COMMENT_VIEW", err)
+	}
+}
+
+func TestValidateRateLimitAlgo(t *testing.T) {
+	os.Setenv("RATE_LIMIT_ALGO", "bucket")
+	os.Setenv("RATE_LIMIT_BURST", "0.5")
+	defer os.Unsetenv("RATE_LIMIT_ALGO")
+	defer os.Unsetenv("RATE_LIMIT_BURST")
+
+	cfg := Load()
+	if cfg.RateLimitAlgo != "bucket" || cfg.RateLimitBurst != 0.5 {
+		t.Errorf("RateLimitAlgo, RateLimitBurst = %q, %g; want bucket, 0.5", cfg.RateLimitAlgo, cfg.RateLimitBurst)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate() = %v", err)
+	}
+
+	cfg.RedisURL = "redis://localhost:6379"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "REDIS_URL") {
+		t.Errorf("Validate() = %v, want bucket with REDIS_URL rejected", err)
+	}
+
+	cfg.RedisURL = ""
+	cfg.RateLimitBurst = 2
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "RATE_LIMIT_BURST") {
+		t.Errorf("Validate() = %v, want an error naming RATE_LIMIT_BURST", err)
+	}
+
+	os.Setenv("RATE_LIMIT_ALGO", "leaky")
+	if err := Load().Validate(); err == nil || !strings.Contains(err.Error(), "RATE_LIMIT_ALGO") {
+		t.Errorf("Validate() = %v, want an error naming RATE_LIMIT_ALGO", err)
+	}
+}
+
+func TestValidateTrustedProxies(t *testing.T) {
+	os.Setenv("TRUSTED_PROXIES", "10.0.0.0/8, 192.168.1.7")
+	defer os.Unsetenv("TRUSTED_PROXIES")
+
+	cfg := Load()
+	prefixes, err := cfg.TrustedProxyPrefixes()
+	if err != nil {
+		t.Fatalf("TrustedProxyPrefixes: %v", err)
+	}
+	if len(prefixes) != 2 || prefixes[0].String() != "10.0.0.0/8" || prefixes[1].String() != "192.168.1.7/32" {
+		t.Errorf("TrustedProxyPrefixes = %v, want [10.0.0.0/8 192.168.1.7/32]", prefixes)
+	}
+
+	os.Setenv("TRUSTED_PROXIES", "10.0.0.0/8,proxy.internal")
+	if err := Load().Validate(); err == nil || !strings.Contains(err.Error(), "TRUSTED_PROXIES") {
+		t.Errorf("Validate() = %v, want an error naming TRUSTED_PROXIES", err)
+	}
+}
+
+func TestValidateTitleLengths(t *testing.T) {
+	os.Setenv("TITLE_MIN_LEN", "20")
+	os.Setenv("TITLE_MAX_LEN", "10")
+	defer os.Unsetenv("TITLE_MIN_LEN")
+	defer os.Unsetenv("TITLE_MAX_LEN")
+
+	if err := Load().Validate(); err == nil || !strings.Contains(err.Error(), "TITLE_MIN_LEN") {
+		t.Errorf("Validate() = %v, want an error naming TITLE_MIN_LEN", err)
+	}
+}
+
+func TestValidateTokenMode(t *testing.T) {
+	os.Setenv("TOKEN_MODE", "jwt")
+	defer os.Unsetenv("TOKEN_MODE")
+
+	if err := Load().Validate(); err == nil || !strings.Contains(err.Error(), "JWT_SECRET") {
+		t.Errorf("Validate() = %v, want an error naming JWT_SECRET", err)
+	}
+
+	os.Setenv("JWT_SECRET", strings.Repeat("s", 32))
+	defer os.Unsetenv("JWT_SECRET")
+	if err := Load().Validate(); err != nil {
+		t.Errorf("Validate() = %v, want nil", err)
+	}
+
+	os.Setenv("TOKEN_MODE", "macaroon")
+	if err := Load().Validate(); err == nil || !strings.Contains(err.Error(), "TOKEN_MODE") {
+		t.Errorf("Validate() = %v, want an error naming TOKEN_MODE", err)
+	}
 }
 
 func TestLoadFromEnv(t *testing.T) {
@@ -0,0 +1,151 @@
+package ratelimit
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// BucketLimiter is an in-memory token bucket rate limiter. Each key's bucket
+// holds up to its burst of tokens and refills continuously at limit/window,
+// so unlike MemoryLimiter's fixed windows a client can't spend a full limit
+// at the end of one window and another at the start of the next.
+type BucketLimiter struct {
+	mu      sync.RWMutex
+	burst   float64
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens   float64
+	capacity float64
+	rate     float64 // tokens per second
+	updated  time.Time
+}
+
+// NewBucketLimiter creates a token bucket limiter. burst is the share of a
+// limit that may be spent at once, from just over 0 (requests must be spread
+// evenly) to 1 (the whole limit); values outside that range mean 1.
+func NewBucketLimiter(burst float64) *BucketLimiter {
+	if burst <= 0 || burst > 1 {
+		burst = 1
+	}
+	return &BucketLimiter{
+		burst:   burst,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// level returns b's tokens as of now
+func (b *tokenBucket) level(now time.Time) float64 {
+	return min(b.capacity, b.tokens+now.Sub(b.updated).Seconds()*b.rate)
+}
+
+// full returns when b will have refilled completely
+func (b *tokenBucket) full(now time.Time) time.Time {
+	missing := b.capacity - b.level(now)
+	return now.Add(time.Duration(missing / b.rate * float64(time.Second)))
+}
+
+func (l *BucketLimiter) Allow(key string, limit int, window time.Duration) bool {
+	if limit <= 0 || window <= 0 {
+		return false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	capacity := max(1, math.Ceil(float64(limit)*l.burst))
+	rate := float64(limit) / window.Seconds()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: capacity, capacity: capacity, rate: rate, updated: now}
+		l.buckets[key] = b
+	}
+	// A key's limit can change with config; the tokens it has carry over
+	b.tokens = min(b.level(now), capacity)
+	b.capacity, b.rate, b.updated = capacity, rate, now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (l *BucketLimiter) Remaining(key string, limit int, window time.Duration) int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		return int(max(1, math.Ceil(float64(limit)*l.burst)))
+	}
+	return int(b.level(time.Now()))
+}
+
+// RetryAfter returns how long until the key's bucket holds a whole token
+func (l *BucketLimiter) RetryAfter(key string, window time.Duration) time.Duration {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		return 0
+	}
+	level := b.level(time.Now())
+	if level >= 1 {
+		return 0
+	}
+	return time.Duration((1 - level) / b.rate * float64(time.Second))
+}
+
+// Snapshot lists the buckets that aren't full. Count is the tokens spent and
+// not yet refilled, and ResetAt when the bucket will be full again.
+func (l *BucketLimiter) Snapshot() []BucketInfo {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	now := time.Now()
+	buckets := make([]BucketInfo, 0, len(l.buckets))
+	for key, b := range l.buckets {
+		spent := int(math.Ceil(b.capacity - b.level(now)))
+		if spent == 0 {
+			continue
+		}
+		buckets = append(buckets, BucketInfo{Key: key, Count: spent, ResetAt: b.full(now).UTC()})
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Key < buckets[j].Key })
+	return buckets
+}
+
+// Cleanup removes full buckets, which behave the same as no bucket at all
+func (l *BucketLimiter) Cleanup() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	for key, b := range l.buckets {
+		if b.level(now) >= b.capacity {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// StartCleanup starts a background goroutine to periodically remove full buckets
+func (l *BucketLimiter) StartCleanup(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			l.Cleanup()
+		}
+	}()
+}
+
+// Ensure BucketLimiter implements Limiter
+var _ Limiter = (*BucketLimiter)(nil)
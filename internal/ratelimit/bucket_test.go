@@ -0,0 +1,151 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucketLimiter_Allow(t *testing.T) {
+	limiter := NewBucketLimiter(1)
+
+	for i := 0; i < 3; i++ {
+		if !limiter.Allow("test-key", 3, time.Hour) {
+			t.Errorf("request %d should be allowed", i+1)
+		}
+	}
+	if limiter.Allow("test-key", 3, time.Hour) {
+		t.Error("fourth request should be denied (limit is 3)")
+	}
+	if !limiter.Allow("other-key", 3, time.Hour) {
+		t.Error("different key should be allowed")
+	}
+}
+
+func TestBucketLimiter_Burst(t *testing.T) {
+	limiter := NewBucketLimiter(0.5)
+
+	// Only half of the limit may be spent at once
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		if limiter.Allow("test-key", 10, time.Hour) {
+			allowed++
+		}
+	}
+	if allowed != 5 {
+		t.Errorf("allowed %d requests at once, want 5", allowed)
+	}
+	if r := limiter.Remaining("fresh-key", 10, time.Hour); r != 5 {
+		t.Errorf("Remaining for an unused key = %d, want the burst of 5", r)
+	}
+}
+
+func TestBucketLimiter_RemainingAndRetryAfter(t *testing.T) {
+	limiter := NewBucketLimiter(1)
+
+	if r := limiter.RetryAfter("test-key", time.Hour); r != 0 {
+		t.Errorf("RetryAfter before any requests = %v, want 0", r)
+	}
+
+	limiter.Allow("test-key", 4, time.Hour)
+	if r := limiter.Remaining("test-key", 4, time.Hour); r != 3 {
+		t.Errorf("Remaining = %d, want 3", r)
+	}
+	if r := limiter.RetryAfter("test-key", time.Hour); r != 0 {
+		t.Errorf("RetryAfter with tokens left = %v, want 0", r)
+	}
+
+	for i := 0; i < 3; i++ {
+		limiter.Allow("test-key", 4, time.Hour)
+	}
+	if r := limiter.Remaining("test-key", 4, time.Hour); r != 0 {
+		t.Errorf("Remaining = %d, want 0", r)
+	}
+	// One token refills every window/limit
+	if r := limiter.RetryAfter("test-key", time.Hour); r <= 14*time.Minute || r > 15*time.Minute {
+		t.Errorf("RetryAfter = %v, want just under 15m", r)
+	}
+}
+
+func TestBucketLimiter_Refill(t *testing.T) {
+	limiter := NewBucketLimiter(1)
+	window := 100 * time.Millisecond
+
+	limiter.Allow("test-key", 2, window)
+	limiter.Allow("test-key", 2, window)
+	if limiter.Allow("test-key", 2, window) {
+		t.Error("should be rate limited")
+	}
+
+	// A token refills every 50ms
+	time.Sleep(60 * time.Millisecond)
+	if !limiter.Allow("test-key", 2, window) {
+		t.Error("should be allowed once a token refills")
+	}
+	if limiter.Allow("test-key", 2, window) {
+		t.Error("only one token should have refilled")
+	}
+}
+
+// A client that waits for the end of a fixed window can spend the limit twice
+// in quick succession; a token bucket only grants what has refilled since
+func TestBoundaryDoubleBurst(t *testing.T) {
+	const limit = 4
+	window := 200 * time.Millisecond
+
+	burst := func(l Limiter) int {
+		l.Allow("test-key", limit, window) // starts the fixed window
+
+		time.Sleep(150 * time.Millisecond)
+		allowed := 0
+		for i := 0; i < limit; i++ {
+			if l.Allow("test-key", limit, window) {
+				allowed++
+			}
+		}
+
+		// Past the end of the fixed window, 70ms later
+		time.Sleep(70 * time.Millisecond)
+		for i := 0; i < limit; i++ {
+			if l.Allow("test-key", limit, window) {
+				allowed++
+			}
+		}
+		return allowed
+	}
+
+	if got := burst(NewMemoryLimiter()); got != 2*limit-1 {
+		t.Errorf("window limiter allowed %d requests across the boundary, want %d", got, 2*limit-1)
+	}
+	// The bucket is full again after 150ms, then 70ms refills one more token
+	if got := burst(NewBucketLimiter(1)); got > limit+2 {
+		t.Errorf("bucket limiter allowed %d requests across the boundary, want at most %d", got, limit+2)
+	}
+}
+
+func TestBucketLimiter_SnapshotAndCleanup(t *testing.T) {
+	limiter := NewBucketLimiter(1)
+
+	for i := 0; i < 3; i++ {
+		limiter.Allow("story:1.2.3.4", 10, time.Hour)
+	}
+	limiter.Allow("refilled", 10, 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+
+	before := time.Now()
+	buckets := limiter.Snapshot()
+	if len(buckets) != 1 {
+		t.Fatalf("Snapshot = %+v, want only the bucket that isn't full", buckets)
+	}
+	if buckets[0].Key != "story:1.2.3.4" || buckets[0].Count != 3 {
+		t.Errorf("buckets[0] = %+v, want story:1.2.3.4 with count 3", buckets[0])
+	}
+	// Three tokens take 3/10 of the window to refill
+	if reset := buckets[0].ResetAt; reset.Before(before.Add(17*time.Minute)) || reset.After(before.Add(18*time.Minute)) {
+		t.Errorf("ResetAt = %v, want about 18m from now", reset)
+	}
+
+	limiter.Cleanup()
+	if len(limiter.buckets) != 1 {
+		t.Errorf("%d buckets after Cleanup, want the full one removed", len(limiter.buckets))
+	}
+}
@@ -1,6 +1,7 @@
 package ratelimit
 
 import (
+	"sort"
 	"sync"
 	"time"
 )
@@ -16,6 +17,17 @@ type Limiter interface {
 
 	// RetryAfter returns the duration until the rate limit resets
 	RetryAfter(key string, window time.Duration) time.Duration
+
+	// Snapshot lists the buckets in an unexpired window, sorted by key, for
+	// operators diagnosing why a client is limited
+	Snapshot() []BucketInfo
+}
+
+// BucketInfo is one rate limit bucket as seen by Snapshot
+type BucketInfo struct {
+	Key     string    `json:"key"`
+	Count   int       `json:"count"`    // requests counted in the current window
+	ResetAt time.Time `json:"reset_at"` // when the window ends
 }
 
 // MemoryLimiter is an in-memory rate limiter implementation
@@ -91,6 +103,22 @@ func (l *MemoryLimiter) RetryAfter(key string, window time.Duration) time.Durati
 	return b.resetTime.Sub(now)
 }
 
+func (l *MemoryLimiter) Snapshot() []BucketInfo {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	now := time.Now()
+	buckets := make([]BucketInfo, 0, len(l.buckets))
+	for key, b := range l.buckets {
+		if now.After(b.resetTime) {
+			continue
+		}
+		buckets = append(buckets, BucketInfo{Key: key, Count: b.count, ResetAt: b.resetTime.UTC()})
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Key < buckets[j].Key })
+	return buckets
+}
+
 // Cleanup removes expired buckets to prevent memory leaks
 func (l *MemoryLimiter) Cleanup() {
 	l.mu.Lock()
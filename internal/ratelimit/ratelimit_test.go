@@ -118,6 +118,32 @@ func TestMemoryLimiter_Cleanup(t *testing.T) {
 	}
 }
 
+func TestMemoryLimiter_Snapshot(t *testing.T) {
+	limiter := NewMemoryLimiter()
+
+	for i := 0; i < 3; i++ {
+		limiter.Allow("story:1.2.3.4", 10, time.Hour)
+	}
+	limiter.Allow("comment:1.2.3.4", 10, time.Hour)
+	limiter.Allow("expired", 10, 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+
+	before := time.Now()
+	buckets := limiter.Snapshot()
+	if len(buckets) != 2 {
+		t.Fatalf("Snapshot = %+v, want 2 unexpired buckets", buckets)
+	}
+	if buckets[0].Key != "comment:1.2.3.4" || buckets[0].Count != 1 {
+		t.Errorf("buckets[0] = %+v, want comment:1.2.3.4 with count 1", buckets[0])
+	}
+	if buckets[1].Key != "story:1.2.3.4" || buckets[1].Count != 3 {
+		t.Errorf("buckets[1] = %+v, want story:1.2.3.4 with count 3", buckets[1])
+	}
+	if reset := buckets[1].ResetAt; reset.Before(before) || reset.After(before.Add(time.Hour)) {
+		t.Errorf("ResetAt = %v, want within the next hour", reset)
+	}
+}
+
 func TestMemoryLimiter_Concurrent(t *testing.T) {
 	limiter := NewMemoryLimiter()
 	limit := 100
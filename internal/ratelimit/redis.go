@@ -0,0 +1,173 @@
+package ratelimit
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisKeyPrefix namespaces limiter keys in a shared Redis
+const redisKeyPrefix = "slashclaw:ratelimit:"
+
+// redisTimeout bounds each Redis round trip so a slow Redis can't stall requests
+const redisTimeout = time.Second
+
+// allowScript counts a request against a fixed window, mirroring
+// MemoryLimiter: the window starts at the first request and a denied request
+// does not add to the count. The PTTL check re-arms the expiry if a key was
+// ever left without one.
+//
+// KEYS[1] = bucket key, ARGV[1] = limit, ARGV[2] = window in milliseconds
+// Returns 1 if allowed, 0 if rate limited.
+var allowScript = redis.NewScript(`
+local count = tonumber(redis.call('GET', KEYS[1]) or '0')
+if count >= tonumber(ARGV[1]) then
+	return 0
+end
+count = redis.call('INCR', KEYS[1])
+if count == 1 or redis.call('PTTL', KEYS[1]) < 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 1
+`)
+
+// RedisLimiter is a Redis-backed rate limiter, so limits survive restarts and
+// are shared by every instance pointed at the same Redis. If Redis is
+// unreachable it fails open and logs the error.
+type RedisLimiter struct {
+	client *redis.Client
+}
+
+// NewRedisLimiter connects to the Redis server at redisURL (redis:// or rediss://)
+func NewRedisLimiter(redisURL string) (*RedisLimiter, error) {
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, err
+	}
+
+	client := redis.NewClient(opts)
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return &RedisLimiter{client: client}, nil
+}
+
+func (l *RedisLimiter) Allow(key string, limit int, window time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	allowed, err := allowScript.Run(ctx, l.client, []string{redisKeyPrefix + key}, limit, window.Milliseconds()).Int()
+	if err != nil {
+		log.Printf("ratelimit: redis allow %s: %v", key, err)
+		return true
+	}
+	return allowed == 1
+}
+
+func (l *RedisLimiter) Remaining(key string, limit int, window time.Duration) int {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	count, err := l.client.Get(ctx, redisKeyPrefix+key).Int()
+	if err == redis.Nil {
+		return limit
+	}
+	if err != nil {
+		log.Printf("ratelimit: redis remaining %s: %v", key, err)
+		return limit
+	}
+
+	remaining := limit - count
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+func (l *RedisLimiter) RetryAfter(key string, window time.Duration) time.Duration {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	ttl, err := l.client.PTTL(ctx, redisKeyPrefix+key).Result()
+	if err != nil {
+		log.Printf("ratelimit: redis retry-after %s: %v", key, err)
+		return 0
+	}
+
+	// Negative TTLs mean the key is missing or has no expiry
+	if ttl < 0 {
+		return 0
+	}
+	return ttl
+}
+
+// snapshotTimeout bounds a whole Snapshot, which walks the keyspace
+const snapshotTimeout = 5 * time.Second
+
+// maxSnapshotBuckets caps how many buckets Snapshot reads from Redis
+const maxSnapshotBuckets = 1000
+
+// Snapshot SCANs for this limiter's keys, so on a busy Redis it may miss
+// buckets created or expired while it runs. If Redis fails it logs the error
+// and returns the buckets read so far.
+func (l *RedisLimiter) Snapshot() []BucketInfo {
+	ctx, cancel := context.WithTimeout(context.Background(), snapshotTimeout)
+	defer cancel()
+
+	var buckets []BucketInfo
+	seen := make(map[string]bool) // SCAN may return a key more than once
+	iter := l.client.Scan(ctx, 0, redisKeyPrefix+"*", 100).Iterator()
+	for len(buckets) < maxSnapshotBuckets && iter.Next(ctx) {
+		key := iter.Val()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		count, err := l.client.Get(ctx, key).Int()
+		if err == redis.Nil {
+			continue // expired since the SCAN saw it
+		}
+		if err != nil {
+			log.Printf("ratelimit: redis snapshot %s: %v", key, err)
+			break
+		}
+		ttl, err := l.client.PTTL(ctx, key).Result()
+		if err != nil {
+			log.Printf("ratelimit: redis snapshot %s: %v", key, err)
+			break
+		}
+		if ttl < 0 {
+			continue
+		}
+
+		buckets = append(buckets, BucketInfo{
+			Key:     strings.TrimPrefix(key, redisKeyPrefix),
+			Count:   count,
+			ResetAt: time.Now().UTC().Add(ttl),
+		})
+	}
+	if err := iter.Err(); err != nil {
+		log.Printf("ratelimit: redis snapshot: %v", err)
+	}
+
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Key < buckets[j].Key })
+	return buckets
+}
+
+// Close closes the Redis connection
+func (l *RedisLimiter) Close() error {
+	return l.client.Close()
+}
+
+// Ensure RedisLimiter implements Limiter
+var _ Limiter = (*RedisLimiter)(nil)
@@ -0,0 +1,152 @@
+package ratelimit
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func setupMiniredis(t *testing.T) (*RedisLimiter, *miniredis.Miniredis) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	limiter, err := NewRedisLimiter("redis://" + mr.Addr())
+	if err != nil {
+		t.Fatalf("NewRedisLimiter: %v", err)
+	}
+	t.Cleanup(func() { limiter.Close() })
+
+	return limiter, mr
+}
+
+func TestRedisLimiter_Miniredis(t *testing.T) {
+	limiter, _ := setupMiniredis(t)
+	runRedisLimiterTests(t, limiter)
+}
+
+// TestRedisLimiter_Redis runs the same checks against a real Redis; it needs
+// REDIS_URL pointing at a scratch server.
+func TestRedisLimiter_Redis(t *testing.T) {
+	redisURL := os.Getenv("REDIS_URL")
+	if redisURL == "" {
+		t.Skip("REDIS_URL not set")
+	}
+
+	limiter, err := NewRedisLimiter(redisURL)
+	if err != nil {
+		t.Fatalf("NewRedisLimiter: %v", err)
+	}
+	defer limiter.Close()
+
+	runRedisLimiterTests(t, limiter)
+}
+
+func runRedisLimiterTests(t *testing.T, limiter *RedisLimiter) {
+	// Unique keys so reruns against a real Redis start clean
+	key := "test-" + time.Now().Format(time.RFC3339Nano)
+
+	if r := limiter.Remaining(key, 3, time.Hour); r != 3 {
+		t.Errorf("Remaining before requests = %d, want 3", r)
+	}
+	if r := limiter.RetryAfter(key, time.Hour); r != 0 {
+		t.Errorf("RetryAfter before requests = %v, want 0", r)
+	}
+
+	for i := 0; i < 3; i++ {
+		if !limiter.Allow(key, 3, time.Hour) {
+			t.Errorf("request %d should be allowed", i+1)
+		}
+	}
+	if limiter.Allow(key, 3, time.Hour) {
+		t.Error("fourth request should be denied")
+	}
+
+	if r := limiter.Remaining(key, 3, time.Hour); r != 0 {
+		t.Errorf("Remaining after limit = %d, want 0", r)
+	}
+	retryAfter := limiter.RetryAfter(key, time.Hour)
+	if retryAfter <= 0 || retryAfter > time.Hour {
+		t.Errorf("RetryAfter = %v, want > 0 and <= 1h", retryAfter)
+	}
+
+	if !limiter.Allow(key+"-other", 3, time.Hour) {
+		t.Error("different key should be allowed")
+	}
+}
+
+func TestRedisLimiter_WindowReset(t *testing.T) {
+	limiter, mr := setupMiniredis(t)
+
+	limiter.Allow("test-key", 1, time.Minute)
+	if limiter.Allow("test-key", 1, time.Minute) {
+		t.Fatal("second request should be denied")
+	}
+
+	mr.FastForward(time.Minute + time.Second)
+
+	if !limiter.Allow("test-key", 1, time.Minute) {
+		t.Error("request after window should be allowed")
+	}
+}
+
+func TestRedisLimiter_DeniedRequestsNotCounted(t *testing.T) {
+	limiter, mr := setupMiniredis(t)
+
+	for i := 0; i < 5; i++ {
+		limiter.Allow("test-key", 2, time.Minute)
+	}
+
+	count, err := mr.Get(redisKeyPrefix + "test-key")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if count != "2" {
+		t.Errorf("stored count = %s, want 2", count)
+	}
+}
+
+func TestRedisLimiter_RearmsMissingExpiry(t *testing.T) {
+	limiter, mr := setupMiniredis(t)
+
+	// A bucket left without a TTL would otherwise block the key forever
+	mr.Set(redisKeyPrefix+"test-key", "1")
+
+	limiter.Allow("test-key", 5, time.Minute)
+	if ttl := mr.TTL(redisKeyPrefix + "test-key"); ttl <= 0 || ttl > time.Minute {
+		t.Errorf("TTL = %v, want > 0 and <= 1m", ttl)
+	}
+}
+
+func TestRedisLimiter_Snapshot(t *testing.T) {
+	limiter, mr := setupMiniredis(t)
+
+	limiter.Allow("vote:5.6.7.8", 10, time.Minute)
+	limiter.Allow("vote:5.6.7.8", 10, time.Minute)
+	limiter.Allow("story:5.6.7.8", 10, time.Hour)
+	mr.Set("unrelated", "7") // other apps' keys are left out
+
+	buckets := limiter.Snapshot()
+	if len(buckets) != 2 {
+		t.Fatalf("Snapshot = %+v, want 2 buckets", buckets)
+	}
+	if buckets[0].Key != "story:5.6.7.8" || buckets[0].Count != 1 {
+		t.Errorf("buckets[0] = %+v, want story:5.6.7.8 with count 1", buckets[0])
+	}
+	if buckets[1].Key != "vote:5.6.7.8" || buckets[1].Count != 2 {
+		t.Errorf("buckets[1] = %+v, want vote:5.6.7.8 with count 2", buckets[1])
+	}
+	if until := time.Until(buckets[1].ResetAt); until <= 0 || until > time.Minute {
+		t.Errorf("vote bucket resets in %v, want within a minute", until)
+	}
+}
+
+func TestRedisLimiter_FailsOpen(t *testing.T) {
+	limiter, mr := setupMiniredis(t)
+	mr.Close()
+
+	if !limiter.Allow("test-key", 1, time.Minute) {
+		t.Error("Allow should fail open when Redis is unreachable")
+	}
+}
@@ -0,0 +1,39 @@
+// Package render turns user-submitted Markdown into HTML that is safe to
+// embed in a page.
+package render
+
+import (
+	"bytes"
+	"html"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	gmhtml "github.com/yuin/goldmark/renderer/html"
+)
+
+var (
+	// markdown leaves raw HTML out of its output; the sanitizer below is the
+	// real guarantee, so a Markdown quirk can't smuggle markup through
+	markdown = goldmark.New(
+		goldmark.WithExtensions(extension.Linkify, extension.Strikethrough),
+		goldmark.WithRendererOptions(gmhtml.WithHardWraps()),
+	)
+
+	// policy allows the formatting Markdown produces and drops scripts,
+	// event handlers, styles, and links to anything but http(s) and mailto
+	policy = bluemonday.UGCPolicy().
+		AllowURLSchemes("http", "https", "mailto").
+		RequireNoFollowOnLinks(true).
+		AddTargetBlankToFullyQualifiedLinks(true)
+)
+
+// Markdown converts Markdown text to sanitized HTML
+func Markdown(text string) string {
+	var buf bytes.Buffer
+	if err := markdown.Convert([]byte(text), &buf); err != nil {
+		// Rendering failed; show the text itself rather than nothing
+		return policy.Sanitize("<p>" + html.EscapeString(text) + "</p>")
+	}
+	return policy.SanitizeReader(&buf).String()
+}
@@ -0,0 +1,66 @@
+package render
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMarkdown(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{name: "emphasis", input: "some *emphasis* and **bold**", want: []string{"<em>emphasis</em>", "<strong>bold</strong>"}},
+		{name: "code", input: "run `go test`", want: []string{"<code>go test</code>"}},
+		{name: "link", input: "[docs](https://example.com/docs)", want: []string{`href="https://example.com/docs"`, `rel="nofollow noopener"`}},
+		{name: "bare url", input: "see https://example.com", want: []string{`href="https://example.com"`}},
+		{name: "line breaks", input: "one\ntwo", want: []string{"<br>"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Markdown(tt.input)
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("Markdown(%q) = %q, want it to contain %q", tt.input, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestMarkdownStripsMaliciousInput(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		forbidden []string
+	}{
+		{name: "script tag", input: "hello <script>alert(1)</script>", forbidden: []string{"<script"}},
+		{name: "script block", input: "<script>\nalert(1)\n</script>", forbidden: []string{"<script", "alert(1)"}},
+		{name: "javascript link", input: "[click](javascript:alert(1))", forbidden: []string{"javascript:"}},
+		{name: "javascript autolink", input: "<javascript:alert(1)>", forbidden: []string{`href="javascript:`}},
+		{name: "inline handler", input: `<img src="x" onerror="alert(1)">`, forbidden: []string{"onerror"}},
+		{name: "data uri image", input: "![x](data:text/html;base64,PHNjcmlwdD4=)", forbidden: []string{"data:"}},
+		{name: "iframe", input: `<iframe src="https://evil.example.com"></iframe>`, forbidden: []string{"<iframe"}},
+		{name: "style attribute", input: `<p style="position:fixed">x</p>`, forbidden: []string{"style="}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Markdown(tt.input)
+			for _, bad := range tt.forbidden {
+				if strings.Contains(strings.ToLower(got), strings.ToLower(bad)) {
+					t.Errorf("Markdown(%q) = %q, should not contain %q", tt.input, got, bad)
+				}
+			}
+		})
+	}
+}
+
+func TestMarkdownEscapesText(t *testing.T) {
+	got := Markdown("1 < 2 & 3 > 2")
+	if !strings.Contains(got, "1 &lt; 2 &amp; 3 &gt; 2") {
+		t.Errorf("Markdown = %q, want special characters escaped", got)
+	}
+}
@@ -0,0 +1,179 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// CachingStore wraps a Store so that story listings, the hottest read path,
+// and their counts are served from a short-lived cache. Concurrent identical
+// listings that miss the cache share a single query to the underlying store.
+// All other methods pass straight through.
+type CachingStore struct {
+	Store
+
+	ttl   time.Duration
+	group singleflight.Group
+
+	mu      sync.Mutex
+	entries map[string]cachedStories
+}
+
+// maxCachedListings bounds the cache; past it, expired entries are swept,
+// and if none have expired the one closest to expiry is evicted
+const maxCachedListings = 256
+
+// cachedStories is a cached listing, or for a CountStories key, a count
+type cachedStories struct {
+	stories    []*Story
+	nextCursor string
+	count      int
+	expires    time.Time
+}
+
+// NewCachingStore wraps s, caching story listings for ttl. A zero ttl still
+// coalesces concurrent identical listings but caches nothing.
+func NewCachingStore(s Store, ttl time.Duration) *CachingStore {
+	return &CachingStore{
+		Store:   s,
+		ttl:     ttl,
+		entries: make(map[string]cachedStories),
+	}
+}
+
+func (c *CachingStore) ListStories(ctx context.Context, opts ListOptions) ([]*Story, string, error) {
+	key := fmt.Sprintf("%s|%d|%s|%g|%g|%d|%s|%s|%d", opts.Sort, opts.Limit, opts.Cursor, opts.Gravity, opts.Offset, opts.Seed, opts.Tag, opts.Type, opts.ScoreFloor)
+
+	entry, err := c.load(ctx, key, func(ctx context.Context) (cachedStories, error) {
+		stories, nextCursor, err := c.Store.ListStories(ctx, opts)
+		return cachedStories{stories: stories, nextCursor: nextCursor}, err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return entry.stories, entry.nextCursor, nil
+}
+
+// CountStories caches counts alongside the listings they total. Only Tag and
+// Type narrow a count, so every page and sort of a listing shares one entry.
+func (c *CachingStore) CountStories(ctx context.Context, opts ListOptions) (int, error) {
+	key := fmt.Sprintf("count|%s|%s", opts.Tag, opts.Type)
+
+	entry, err := c.load(ctx, key, func(ctx context.Context) (cachedStories, error) {
+		count, err := c.Store.CountStories(ctx, opts)
+		return cachedStories{count: count}, err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return entry.count, nil
+}
+
+// load returns the cached entry for key, or runs fetch once for all callers
+// missing it at the same time and caches the result
+func (c *CachingStore) load(ctx context.Context, key string, fetch func(ctx context.Context) (cachedStories, error)) (cachedStories, error) {
+	if entry, ok := c.get(key); ok {
+		return entry, nil
+	}
+
+	v, err, _ := c.group.Do(key, func() (any, error) {
+		// Callers share this query, so one caller going away mustn't cancel it
+		entry, err := fetch(context.WithoutCancel(ctx))
+		if err != nil {
+			return nil, err
+		}
+
+		if c.ttl > 0 {
+			entry.expires = time.Now().Add(c.ttl)
+			c.set(key, entry)
+		}
+		return entry, nil
+	})
+	if err != nil {
+		return cachedStories{}, err
+	}
+	return v.(cachedStories), nil
+}
+
+// HideStory hides the story and drops every cached listing, so a hidden
+// story disappears from lists straight away rather than when its entry expires
+func (c *CachingStore) HideStory(ctx context.Context, id string) error {
+	if err := c.Store.HideStory(ctx, id); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	clear(c.entries)
+	c.mu.Unlock()
+	return nil
+}
+
+// UnhideStory restores the story and, like HideStory, drops every cached
+// listing so it reappears straight away
+func (c *CachingStore) UnhideStory(ctx context.Context, id string) error {
+	if err := c.Store.UnhideStory(ctx, id); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	clear(c.entries)
+	c.mu.Unlock()
+	return nil
+}
+
+// WithTx runs fn in a transaction on the underlying store. fn's store is not
+// cached, so once the transaction commits every cached listing is dropped in
+// case fn hid or changed a story in one.
+func (c *CachingStore) WithTx(ctx context.Context, fn func(tx Store) error) error {
+	if err := c.Store.WithTx(ctx, fn); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	clear(c.entries)
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *CachingStore) get(key string) (cachedStories, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return cachedStories{}, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		return cachedStories{}, false
+	}
+	return entry, true
+}
+
+func (c *CachingStore) set(key string, entry cachedStories) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= maxCachedListings {
+		now := time.Now()
+		oldest := ""
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+			} else if oldest == "" || e.expires.Before(c.entries[oldest].expires) {
+				oldest = k
+			}
+		}
+		if len(c.entries) >= maxCachedListings {
+			delete(c.entries, oldest)
+		}
+	}
+	c.entries[key] = entry
+}
+
+// Ensure CachingStore implements Store
+var _ Store = (*CachingStore)(nil)
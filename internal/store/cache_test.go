@@ -0,0 +1,192 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowStore stands in for a database whose listing query blocks until released
+type slowStore struct {
+	Store
+
+	calls   atomic.Int32
+	release chan struct{}
+}
+
+func (s *slowStore) ListStories(ctx context.Context, opts ListOptions) ([]*Story, string, error) {
+	s.calls.Add(1)
+	<-s.release
+	return []*Story{{ID: "s1", Title: "Story"}}, "", nil
+}
+
+func TestCachingStoreCoalescesConcurrentListings(t *testing.T) {
+	slow := &slowStore{release: make(chan struct{})}
+	cache := NewCachingStore(slow, time.Minute)
+
+	const callers = 50
+	var wg sync.WaitGroup
+	results := make(chan int, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stories, _, err := cache.ListStories(context.Background(), ListOptions{Sort: SortTop, Limit: 30})
+			if err != nil {
+				t.Errorf("ListStories: %v", err)
+			}
+			results <- len(stories)
+		}()
+	}
+
+	// Let every caller join the in-flight query before it completes
+	time.Sleep(50 * time.Millisecond)
+	close(slow.release)
+	wg.Wait()
+	close(results)
+
+	for n := range results {
+		if n != 1 {
+			t.Errorf("caller got %d stories, want 1", n)
+		}
+	}
+	if calls := slow.calls.Load(); calls != 1 {
+		t.Errorf("underlying ListStories ran %d times, want 1", calls)
+	}
+
+	// Served from cache within the TTL
+	cache.ListStories(context.Background(), ListOptions{Sort: SortTop, Limit: 30})
+	if calls := slow.calls.Load(); calls != 1 {
+		t.Errorf("underlying ListStories ran %d times after a cached call, want 1", calls)
+	}
+
+	// A different listing is its own query
+	cache.ListStories(context.Background(), ListOptions{Sort: SortNew, Limit: 30})
+	if calls := slow.calls.Load(); calls != 2 {
+		t.Errorf("underlying ListStories ran %d times, want 2", calls)
+	}
+}
+
+// countingStore counts CountStories calls
+type countingStore struct {
+	slowStore
+
+	counts atomic.Int32
+}
+
+func (s *countingStore) CountStories(ctx context.Context, opts ListOptions) (int, error) {
+	s.counts.Add(1)
+	return 7, nil
+}
+
+func TestCachingStoreCachesCounts(t *testing.T) {
+	counting := &countingStore{}
+	cache := NewCachingStore(counting, time.Minute)
+	ctx := context.Background()
+
+	// Every page and sort of a listing shares its count
+	for _, opts := range []ListOptions{{Sort: SortTop}, {Sort: SortNew, Cursor: "c"}, {Sort: SortTop, Offset: 30}} {
+		if n, err := cache.CountStories(ctx, opts); err != nil || n != 7 {
+			t.Fatalf("CountStories(%+v) = %d, %v; want 7", opts, n, err)
+		}
+	}
+	if calls := counting.counts.Load(); calls != 1 {
+		t.Errorf("underlying CountStories ran %d times, want 1", calls)
+	}
+
+	cache.CountStories(ctx, ListOptions{Tag: "go"})
+	if calls := counting.counts.Load(); calls != 2 {
+		t.Errorf("underlying CountStories ran %d times for a new tag, want 2", calls)
+	}
+}
+
+func TestCachingStoreBounded(t *testing.T) {
+	slow := &slowStore{release: make(chan struct{})}
+	close(slow.release)
+	cache := NewCachingStore(slow, time.Hour)
+
+	// Distinct live keys, as varying ?seed= produces, mustn't grow it past the bound
+	for seed := uint64(0); seed < 2*maxCachedListings; seed++ {
+		cache.ListStories(context.Background(), ListOptions{Sort: SortDiscover, Seed: seed})
+	}
+	if n := len(cache.entries); n > maxCachedListings {
+		t.Errorf("cache holds %d entries, want at most %d", n, maxCachedListings)
+	}
+
+	// The newest listing survives; the oldest was evicted to make room
+	calls := slow.calls.Load()
+	cache.ListStories(context.Background(), ListOptions{Sort: SortDiscover, Seed: 2*maxCachedListings - 1})
+	if slow.calls.Load() != calls {
+		t.Error("the newest listing was evicted")
+	}
+	cache.ListStories(context.Background(), ListOptions{Sort: SortDiscover, Seed: 0})
+	if slow.calls.Load() != calls+1 {
+		t.Error("the oldest listing is still cached")
+	}
+}
+
+func TestCachingStoreExpiry(t *testing.T) {
+	slow := &slowStore{release: make(chan struct{})}
+	close(slow.release)
+	cache := NewCachingStore(slow, 20*time.Millisecond)
+
+	opts := ListOptions{Sort: SortTop, Limit: 30}
+	cache.ListStories(context.Background(), opts)
+	cache.ListStories(context.Background(), opts)
+	if calls := slow.calls.Load(); calls != 1 {
+		t.Fatalf("underlying ListStories ran %d times, want 1", calls)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	cache.ListStories(context.Background(), opts)
+	if calls := slow.calls.Load(); calls != 2 {
+		t.Errorf("underlying ListStories ran %d times after expiry, want 2", calls)
+	}
+}
+
+func TestCachingStoreZeroTTL(t *testing.T) {
+	slow := &slowStore{release: make(chan struct{})}
+	close(slow.release)
+	cache := NewCachingStore(slow, 0)
+
+	opts := ListOptions{Sort: SortTop, Limit: 30}
+	cache.ListStories(context.Background(), opts)
+	cache.ListStories(context.Background(), opts)
+	if calls := slow.calls.Load(); calls != 2 {
+		t.Errorf("underlying ListStories ran %d times, want 2 (no caching)", calls)
+	}
+}
+
+// hidingStore records HideStory calls on top of slowStore
+type hidingStore struct {
+	slowStore
+	hidden []string
+}
+
+func (s *hidingStore) HideStory(ctx context.Context, id string) error {
+	s.hidden = append(s.hidden, id)
+	return nil
+}
+
+func TestCachingStoreHideStoryInvalidates(t *testing.T) {
+	hiding := &hidingStore{slowStore: slowStore{release: make(chan struct{})}}
+	close(hiding.release)
+	cache := NewCachingStore(hiding, time.Minute)
+
+	opts := ListOptions{Sort: SortTop, Limit: 30}
+	cache.ListStories(context.Background(), opts)
+
+	if err := cache.HideStory(context.Background(), "s1"); err != nil {
+		t.Fatalf("HideStory: %v", err)
+	}
+	if len(hiding.hidden) != 1 || hiding.hidden[0] != "s1" {
+		t.Errorf("underlying HideStory calls = %v, want [s1]", hiding.hidden)
+	}
+
+	cache.ListStories(context.Background(), opts)
+	if calls := hiding.calls.Load(); calls != 2 {
+		t.Errorf("underlying ListStories ran %d times after hiding, want 2", calls)
+	}
+}
@@ -0,0 +1,55 @@
+package store
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// SweepExpired deletes expired tokens, challenges, and idempotency keys every
+// interval until ctx is cancelled. Failures are logged and retried on the
+// next tick.
+func SweepExpired(ctx context.Context, s Store, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.DeleteExpiredTokens(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("cleanup: deleting expired tokens: %v", err)
+			}
+			if err := s.DeleteExpiredChallenges(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("cleanup: deleting expired challenges: %v", err)
+			}
+			if err := s.DeleteExpiredIdempotencyKeys(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("cleanup: deleting expired idempotency keys: %v", err)
+			}
+		}
+	}
+}
+
+// SweepInactiveAccounts prunes accounts that have been idle for longer than
+// after (see PruneInactiveAccounts) every interval until ctx is cancelled,
+// logging each account it deletes
+func SweepInactiveAccounts(ctx context.Context, s Store, after, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			pruned, err := s.PruneInactiveAccounts(ctx, time.Now().Add(-after))
+			if err != nil && ctx.Err() == nil {
+				log.Printf("cleanup: pruning inactive accounts: %v", err)
+			}
+			for _, account := range pruned {
+				log.Printf("cleanup: pruned inactive account %s (%q, created %s)", account.ID, account.DisplayName, account.CreatedAt.Format(time.RFC3339))
+			}
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// sweepCountingStore records how often the sweeper runs
+type sweepCountingStore struct {
+	Store
+
+	tokens, challenges, idempotencyKeys atomic.Int32
+}
+
+func (s *sweepCountingStore) DeleteExpiredTokens(ctx context.Context) error {
+	s.tokens.Add(1)
+	return nil
+}
+
+func (s *sweepCountingStore) DeleteExpiredChallenges(ctx context.Context) error {
+	s.challenges.Add(1)
+	return nil
+}
+
+func (s *sweepCountingStore) DeleteExpiredIdempotencyKeys(ctx context.Context) error {
+	s.idempotencyKeys.Add(1)
+	return nil
+}
+
+func TestSweepExpiredRunsEachInterval(t *testing.T) {
+	s := &sweepCountingStore{}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+	go func() {
+		SweepExpired(ctx, s, 10*time.Millisecond)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for s.idempotencyKeys.Load() < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if s.tokens.Load() < 2 || s.challenges.Load() < 2 || s.idempotencyKeys.Load() < 2 {
+		t.Errorf("swept tokens %d, challenges %d, idempotency keys %d times; want at least 2 each",
+			s.tokens.Load(), s.challenges.Load(), s.idempotencyKeys.Load())
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("SweepExpired did not return after cancellation")
+	}
+}
+
+func TestSweepExpiredWaitsForInterval(t *testing.T) {
+	s := &sweepCountingStore{}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+	go func() {
+		SweepExpired(ctx, s, time.Hour)
+		close(done)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	<-done
+
+	if n := s.tokens.Load(); n != 0 {
+		t.Errorf("swept %d times before the first interval elapsed, want 0", n)
+	}
+}
+
+// pruneRecordingStore records the cutoffs the account sweeper asks for
+type pruneRecordingStore struct {
+	Store
+
+	mu      sync.Mutex
+	cutoffs []time.Time
+}
+
+func (s *pruneRecordingStore) PruneInactiveAccounts(ctx context.Context, before time.Time) ([]*Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cutoffs = append(s.cutoffs, before)
+	return nil, nil
+}
+
+func TestSweepInactiveAccounts(t *testing.T) {
+	s := &pruneRecordingStore{}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+	go func() {
+		SweepInactiveAccounts(ctx, s, 30*24*time.Hour, 10*time.Millisecond)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		s.mu.Lock()
+		n := len(s.cutoffs)
+		s.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.cutoffs) == 0 {
+		t.Fatal("SweepInactiveAccounts never pruned")
+	}
+	if age := time.Since(s.cutoffs[0]); age < 30*24*time.Hour || age > 30*24*time.Hour+time.Minute {
+		t.Errorf("cutoff was %s ago, want 30 days", age)
+	}
+}
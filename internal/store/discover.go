@@ -0,0 +1,41 @@
+package store
+
+import (
+	"math"
+	"math/rand/v2"
+	"sort"
+)
+
+// discoverPoolSize is how many of the newest visible stories SortDiscover
+// samples from
+const discoverPoolSize = 200
+
+// sampleStories draws up to n stories from pool without replacement, each
+// weighted by its score so better stories are likelier but never certain.
+// Stories at or below zero keep a small weight so they can still surface. The
+// same seed always draws the same sample, in the same order.
+func sampleStories(pool []*Story, n int, seed uint64) []*Story {
+	if n >= len(pool) {
+		n = len(pool)
+	}
+
+	// Weighted sampling by Efraimidis-Spirakis: give each story the key
+	// u^(1/weight) for uniform u and keep the n largest keys
+	rng := rand.New(rand.NewPCG(seed, seed^0x9e3779b97f4a7c15))
+	type keyed struct {
+		story *Story
+		key   float64
+	}
+	keys := make([]keyed, len(pool))
+	for i, story := range pool {
+		weight := float64(max(story.Score, 0) + 1)
+		keys[i] = keyed{story: story, key: math.Pow(rng.Float64(), 1/weight)}
+	}
+	sort.SliceStable(keys, func(i, j int) bool { return keys[i].key > keys[j].key })
+
+	sample := make([]*Story, n)
+	for i := range sample {
+		sample[i] = keys[i].story
+	}
+	return sample
+}
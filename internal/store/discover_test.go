@@ -0,0 +1,63 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSampleStoriesFavorsHigherScores(t *testing.T) {
+	pool := []*Story{
+		{ID: "low", Score: 0},
+		{ID: "mid", Score: 5},
+		{ID: "high", Score: 50},
+	}
+
+	counts := make(map[string]int)
+	for seed := uint64(0); seed < 3000; seed++ {
+		sample := sampleStories(pool, 1, seed)
+		if len(sample) != 1 {
+			t.Fatalf("sample has %d stories, want 1", len(sample))
+		}
+		counts[sample[0].ID]++
+	}
+
+	if !(counts["high"] > counts["mid"] && counts["mid"] > counts["low"]) {
+		t.Errorf("draw counts = %v, want high > mid > low", counts)
+	}
+	if counts["low"] == 0 {
+		t.Error("a zero-score story should still be drawn sometimes")
+	}
+}
+
+func TestSampleStoriesSeeded(t *testing.T) {
+	var pool []*Story
+	for i := 0; i < 20; i++ {
+		pool = append(pool, &Story{ID: fmt.Sprint(i), Score: i})
+	}
+
+	first := sampleStories(pool, 5, 42)
+	again := sampleStories(pool, 5, 42)
+	if fmt.Sprint(storyIDs(first)) != fmt.Sprint(storyIDs(again)) {
+		t.Errorf("same seed drew %v then %v", storyIDs(first), storyIDs(again))
+	}
+
+	seen := make(map[string]bool)
+	for _, s := range first {
+		if seen[s.ID] {
+			t.Errorf("story %s drawn twice", s.ID)
+		}
+		seen[s.ID] = true
+	}
+
+	if all := sampleStories(pool, 50, 1); len(all) != len(pool) {
+		t.Errorf("oversized sample has %d stories, want %d", len(all), len(pool))
+	}
+}
+
+func storyIDs(stories []*Story) []string {
+	ids := make([]string, len(stories))
+	for i, s := range stories {
+		ids[i] = s.ID
+	}
+	return ids
+}
@@ -6,38 +6,136 @@ type Story struct {
 	ID            string    `json:"id"`
 	Title         string    `json:"title"`
 	URL           string    `json:"url,omitempty"`
+	SourceURL     string    `json:"source_url,omitempty"` // optional canonical or discussion link; duplicate detection ignores it
+	Type          StoryType `json:"type"`                 // derived from URL when read; not stored
 	Text          string    `json:"text,omitempty"`
 	Tags          []string  `json:"tags,omitempty"`
 	Score         int       `json:"score"`
+	Upvotes       int       `json:"upvotes"`
+	Downvotes     int       `json:"downvotes"`
 	CommentCount  int       `json:"comment_count"`
 	CreatedAt     time.Time `json:"created_at"`
 	Hidden        bool      `json:"-"`
 	AgentID       string    `json:"agent_id,omitempty"`
 	AgentVerified bool      `json:"agent_verified,omitempty"`
+	Pending       bool      `json:"pending,omitempty"` // awaiting moderator approval; hidden until approved
+	Author        *Author   `json:"author,omitempty"`  // filled in by the API; not stored
 }
 
+// StoryModified is when a story's page last changed: its newest visible
+// comment was posted, or failing that the story was submitted
+type StoryModified struct {
+	ID         string
+	ModifiedAt time.Time
+}
+
+// Author names the account behind a verified agent's story or comment
+type Author struct {
+	AccountID   string `json:"account_id"`
+	DisplayName string `json:"display_name"`
+}
+
+// StoryBatchResult is the outcome of one story passed to CreateStories. When
+// both fields are nil the story was stored.
+type StoryBatchResult struct {
+	Existing *Story // a recent story it would repost; nothing was stored
+	Err      error  // why it was rejected, such as ErrTagsTooLarge
+}
+
+// Tombstones written over a deleted comment's author and, optionally, its text
+const (
+	DeletedAgentID = "[deleted]"
+	DeletedText    = "[deleted]"
+)
+
 type Comment struct {
-	ID            string    `json:"id"`
-	StoryID       string    `json:"story_id"`
-	ParentID      string    `json:"parent_id,omitempty"`
-	Text          string    `json:"text"`
-	Score         int       `json:"score"`
-	CreatedAt     time.Time `json:"created_at"`
-	Hidden        bool      `json:"-"`
-	AgentID       string    `json:"agent_id,omitempty"`
-	AgentVerified bool      `json:"agent_verified,omitempty"`
+	ID            string     `json:"id"`
+	StoryID       string     `json:"story_id"`
+	ParentID      string     `json:"parent_id,omitempty"`
+	Text          string     `json:"text"`
+	Score         int        `json:"score"`
+	Upvotes       int        `json:"upvotes"`
+	Downvotes     int        `json:"downvotes"`
+	CreatedAt     time.Time  `json:"created_at"`
+	Hidden        bool       `json:"-"`
+	AgentID       string     `json:"agent_id,omitempty"`
+	AgentVerified bool       `json:"agent_verified,omitempty"`
+	EditedAt      *time.Time `json:"edited_at,omitempty"`
+	Depth         int        `json:"depth"`               // 0 for a top-level comment; set from the parent by CreateComment
+	TextHTML      string     `json:"text_html,omitempty"` // sanitized rendering of Text; only set when requested with format=html
+	Author        *Author    `json:"author,omitempty"`    // filled in by the API; not stored
 	Children      []*Comment `json:"children,omitempty"`
 }
 
 type Vote struct {
-	ID            string    `json:"id"`
-	TargetType    string    `json:"target_type"` // "story" or "comment"
-	TargetID      string    `json:"target_id"`
-	Value         int       `json:"value"` // 1 or -1
-	CreatedAt     time.Time `json:"created_at"`
-	IPHash        string    `json:"-"`
-	AgentID       string    `json:"agent_id,omitempty"`
-	AgentVerified bool      `json:"agent_verified,omitempty"`
+	ID            string     `json:"id"`
+	TargetType    string     `json:"target_type"` // "story" or "comment"
+	TargetID      string     `json:"target_id"`
+	Value         int        `json:"value"` // 1 or -1
+	Weight        int        `json:"-"`     // how far the vote moves the score per point of value; 0 means 1
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     *time.Time `json:"updated_at,omitempty"` // when the value last changed; nil if it never has
+	IPHash        string     `json:"-"`
+	AgentID       string     `json:"agent_id,omitempty"`
+	AgentVerified bool       `json:"agent_verified,omitempty"`
+}
+
+// ChangedAt returns when v was cast or last changed
+func (v *Vote) ChangedAt() time.Time {
+	if v.UpdatedAt != nil {
+		return *v.UpdatedAt
+	}
+	return v.CreatedAt
+}
+
+// weight returns how far v moves its target's score per point of value
+func (v *Vote) weight() int {
+	if v.Weight < 1 {
+		return 1
+	}
+	return v.Weight
+}
+
+// VoteTally counts the up and down votes on one target
+type VoteTally struct {
+	Up   int `json:"up"`
+	Down int `json:"down"`
+}
+
+// Flag is a community report that a story or comment breaks the rules
+type Flag struct {
+	ID         string    `json:"id"`
+	TargetType string    `json:"target_type"` // "story" or "comment"
+	TargetID   string    `json:"target_id"`
+	Reason     string    `json:"reason"`
+	CreatedAt  time.Time `json:"created_at"`
+	IPHash     string    `json:"-"`
+	AgentID    string    `json:"agent_id,omitempty"`
+}
+
+// IdempotencyKey remembers the response to a request sent with an
+// Idempotency-Key header, so a retry of it gets the same answer
+type IdempotencyKey struct {
+	Scope       string // the endpoint and the agent or IP that sent the key
+	Key         string
+	RequestHash string // of the request body, to catch a key reused for a different request
+	ResourceID  string // the story or comment the request created
+	Response    string // the original JSON response body
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+}
+
+// TagCount is a tag and how many visible stories carry it
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// ContentCounts is how much an instance holds, as reported to other instances
+type ContentCounts struct {
+	Stories  int `json:"stories"`
+	Comments int `json:"comments"`
+	Accounts int `json:"accounts"`
 }
 
 type Account struct {
@@ -46,6 +144,7 @@ type Account struct {
 	Bio         string    `json:"bio,omitempty"`
 	HomepageURL string    `json:"homepage_url,omitempty"`
 	CreatedAt   time.Time `json:"created_at"`
+	Karma       int       `json:"karma"` // filled in by the API; not stored
 }
 
 type AccountKey struct {
@@ -63,6 +162,7 @@ type Challenge struct {
 	Algorithm string    `json:"alg"`
 	Challenge string    `json:"challenge"`
 	ExpiresAt time.Time `json:"expires_at"`
+	Failures  int       `json:"failures"` // signatures refused so far
 }
 
 type Token struct {
@@ -74,6 +174,51 @@ type Token struct {
 	ExpiresAt time.Time `json:"expires_at"`
 }
 
+// AdminToken grants its holder the admin endpoints covered by Scopes. The
+// token itself is shown once when issued; only its hash is stored.
+type AdminToken struct {
+	ID        string    `json:"id"`
+	Label     string    `json:"label,omitempty"`
+	TokenHash string    `json:"-"`
+	Scopes    []string  `json:"scopes"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AuditEntry records one action an admin took, for the activity feed
+type AuditEntry struct {
+	ID         string    `json:"id"`
+	Action     string    `json:"action"`                // one of the Audit* actions
+	TargetType string    `json:"target_type,omitempty"` // "story", "comment", or "agent"; empty when an action covers every target
+	TargetID   string    `json:"target_id,omitempty"`
+	Actor      string    `json:"actor"` // "secret" for the admin secret, otherwise "token:" and the admin token's id
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Audited admin actions
+const (
+	AuditHide         = "hide"
+	AuditUnhide       = "unhide"
+	AuditApprove      = "approve"
+	AuditRecompute    = "recompute"
+	AuditRevokeTokens = "revoke_tokens"
+)
+
+// StoryType tells link posts, which have a URL, from text posts, which don't
+type StoryType string
+
+const (
+	StoryLink StoryType = "link"
+	StoryText StoryType = "text"
+)
+
+// storyType returns the type of a story with the given URL
+func storyType(url string) StoryType {
+	if url != "" {
+		return StoryLink
+	}
+	return StoryText
+}
+
 // Sort options
 type SortOrder string
 
@@ -81,6 +226,10 @@ const (
 	SortTop       SortOrder = "top"
 	SortNew       SortOrder = "new"
 	SortDiscussed SortOrder = "discussed"
+	SortDiscover  SortOrder = "discover" // score-weighted random sample of recent stories
+
+	SortOld           SortOrder = "old"           // comments only: oldest first
+	SortControversial SortOrder = "controversial" // comments only: most evenly split votes first
 )
 
 // View options for comments
@@ -92,13 +241,55 @@ const (
 )
 
 // List options
+// Default SortTop time-decay parameters; stories rank by
+// score / (hours since submission + offset)^gravity
+const (
+	DefaultRankGravity = 1.5
+	DefaultRankOffset  = 2.0
+)
+
 type ListOptions struct {
-	Sort   SortOrder
-	Limit  int
-	Cursor string
+	Sort    SortOrder
+	Limit   int
+	Cursor  string
+	Gravity float64   // SortTop decay exponent; 0 uses DefaultRankGravity
+	Offset  float64   // hours added to a story's age under SortTop; 0 uses DefaultRankOffset
+	Seed    uint64    // SortDiscover sampling seed; a seed always draws the same sample
+	Tag     string    // only stories carrying this tag, compared case-insensitively; "" lists all
+	Type    StoryType // only link or only text stories; "" lists both
+
+	// ScoreFloor is the lowest score SortTop ranks by; a story voted further
+	// down ranks as if it sat at the floor. Stored scores are unaffected.
+	// 0 or above disables the floor.
+	ScoreFloor int
+}
+
+// rankParams returns the SortTop gravity and offset, filling in defaults
+func (o ListOptions) rankParams() (gravity, offset float64) {
+	gravity, offset = o.Gravity, o.Offset
+	if gravity <= 0 {
+		gravity = DefaultRankGravity
+	}
+	if offset <= 0 {
+		offset = DefaultRankOffset
+	}
+	return gravity, offset
+}
+
+// rankScore returns the SQL expression SortTop ranks a story's score by,
+// clamped with clampFn (MAX or GREATEST) when a floor is set, and its args
+func (o ListOptions) rankScore(clampFn string) (string, []any) {
+	if o.ScoreFloor >= 0 {
+		return "score", nil
+	}
+	return clampFn + "(score, ?)", []any{o.ScoreFloor}
 }
 
 type CommentListOptions struct {
-	Sort SortOrder
-	View ViewMode
+	Sort        SortOrder
+	View        ViewMode
+	Limit       int // 0 returns every comment; in tree view, pages count top-level comments
+	Cursor      string
+	MaxComments int // caps an unpaginated (Limit 0) load, by whole threads in tree view; 0 means no cap
+	MaxDepth    int // deepest reply nesting assembled in tree view; 0 uses DefaultMaxTreeDepth
 }
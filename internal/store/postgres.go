@@ -0,0 +1,1871 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	_ "github.com/lib/pq"
+)
+
+// PostgresStore is a Store backed by PostgreSQL, for deployments that run
+// more than one instance against a shared database.
+//
+// Queries are written with SQLite-style ? placeholders so they stay close to
+// their SQLiteStore counterparts; exec, query, and queryRow rewrite them to
+// Postgres' $n form.
+type PostgresStore struct {
+	db   *sql.DB
+	conn dbConn  // db, or tx inside WithTx
+	tx   *sql.Tx // set only inside WithTx
+}
+
+func NewPostgresStore(databaseURL string) (*PostgresStore, error) {
+	db, err := sql.Open("postgres", databaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	store := &PostgresStore{db: db, conn: db}
+	if err := store.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return store, nil
+}
+
+func (s *PostgresStore) migrate() error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS stories (
+		id TEXT PRIMARY KEY,
+		title TEXT NOT NULL,
+		url TEXT,
+		text TEXT,
+		tags JSONB,
+		score INTEGER DEFAULT 0,
+		comment_count INTEGER DEFAULT 0,
+		created_at TIMESTAMPTZ DEFAULT NOW(),
+		hidden BOOLEAN DEFAULT FALSE,
+		agent_id TEXT,
+		agent_verified BOOLEAN DEFAULT FALSE,
+		pending BOOLEAN DEFAULT FALSE,
+		text_hash TEXT,
+		url_key TEXT,
+		source_url TEXT,
+		upvotes INTEGER DEFAULT 0,
+		downvotes INTEGER DEFAULT 0
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_stories_url ON stories(url) WHERE url IS NOT NULL;
+	CREATE INDEX IF NOT EXISTS idx_stories_created_at ON stories(created_at);
+	CREATE INDEX IF NOT EXISTS idx_stories_score ON stories(score);
+
+	CREATE TABLE IF NOT EXISTS comments (
+		id TEXT PRIMARY KEY,
+		story_id TEXT NOT NULL REFERENCES stories(id),
+		parent_id TEXT,
+		text TEXT NOT NULL,
+		score INTEGER DEFAULT 0,
+		created_at TIMESTAMPTZ DEFAULT NOW(),
+		hidden BOOLEAN DEFAULT FALSE,
+		agent_id TEXT,
+		agent_verified BOOLEAN DEFAULT FALSE,
+		edited_at TIMESTAMPTZ,
+		depth INTEGER DEFAULT 0,
+		upvotes INTEGER DEFAULT 0,
+		downvotes INTEGER DEFAULT 0
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_comments_story_id ON comments(story_id);
+	CREATE INDEX IF NOT EXISTS idx_comments_parent_id ON comments(parent_id);
+
+	CREATE TABLE IF NOT EXISTS votes (
+		id TEXT PRIMARY KEY,
+		target_type TEXT NOT NULL,
+		target_id TEXT NOT NULL,
+		value INTEGER NOT NULL,
+		weight INTEGER NOT NULL DEFAULT 1,
+		created_at TIMESTAMPTZ DEFAULT NOW(),
+		updated_at TIMESTAMPTZ,
+		ip_hash TEXT,
+		agent_id TEXT,
+		agent_verified BOOLEAN DEFAULT FALSE,
+		UNIQUE(target_type, target_id, ip_hash, agent_id)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_votes_target ON votes(target_type, target_id);
+
+	CREATE TABLE IF NOT EXISTS accounts (
+		id TEXT PRIMARY KEY,
+		display_name TEXT NOT NULL,
+		bio TEXT,
+		homepage_url TEXT,
+		created_at TIMESTAMPTZ DEFAULT NOW()
+	);
+
+	CREATE TABLE IF NOT EXISTS account_keys (
+		id TEXT PRIMARY KEY,
+		account_id TEXT NOT NULL REFERENCES accounts(id),
+		algorithm TEXT NOT NULL,
+		public_key TEXT NOT NULL,
+		created_at TIMESTAMPTZ DEFAULT NOW(),
+		revoked_at TIMESTAMPTZ,
+		UNIQUE(algorithm, public_key)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_account_keys_account ON account_keys(account_id);
+	CREATE INDEX IF NOT EXISTS idx_account_keys_pubkey ON account_keys(algorithm, public_key);
+
+	CREATE TABLE IF NOT EXISTS challenges (
+		id TEXT PRIMARY KEY,
+		agent_id TEXT NOT NULL,
+		algorithm TEXT NOT NULL,
+		challenge TEXT NOT NULL UNIQUE,
+		expires_at TIMESTAMPTZ NOT NULL,
+		failures INTEGER NOT NULL DEFAULT 0
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_challenges_challenge ON challenges(challenge);
+
+	CREATE TABLE IF NOT EXISTS tokens (
+		id TEXT PRIMARY KEY,
+		account_id TEXT,
+		key_id TEXT NOT NULL,
+		agent_id TEXT NOT NULL,
+		token TEXT NOT NULL UNIQUE,
+		expires_at TIMESTAMPTZ NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_tokens_token ON tokens(token);
+
+	-- JWTs aren't stored, so revoking an agent records a cutoff instead: its
+	-- tokens issued at or before revoked_at are refused until expires_at
+	CREATE TABLE IF NOT EXISTS token_revocations (
+		agent_id TEXT PRIMARY KEY,
+		revoked_at TIMESTAMPTZ NOT NULL,
+		expires_at TIMESTAMPTZ NOT NULL
+	);
+
+	ALTER TABLE comments ADD COLUMN IF NOT EXISTS edited_at TIMESTAMPTZ;
+	ALTER TABLE stories ADD COLUMN IF NOT EXISTS pending BOOLEAN DEFAULT FALSE;
+	ALTER TABLE comments ADD COLUMN IF NOT EXISTS depth INTEGER DEFAULT 0;
+	ALTER TABLE stories ADD COLUMN IF NOT EXISTS text_hash TEXT;
+	CREATE INDEX IF NOT EXISTS idx_stories_text_hash ON stories(text_hash) WHERE text_hash IS NOT NULL;
+	ALTER TABLE stories ADD COLUMN IF NOT EXISTS url_key TEXT;
+	CREATE INDEX IF NOT EXISTS idx_stories_url_key ON stories(url_key) WHERE url_key IS NOT NULL;
+	ALTER TABLE stories ADD COLUMN IF NOT EXISTS source_url TEXT;
+	CREATE INDEX IF NOT EXISTS idx_stories_source_url ON stories(source_url) WHERE source_url IS NOT NULL;
+	ALTER TABLE stories ADD COLUMN IF NOT EXISTS upvotes INTEGER DEFAULT 0;
+	ALTER TABLE stories ADD COLUMN IF NOT EXISTS downvotes INTEGER DEFAULT 0;
+	ALTER TABLE comments ADD COLUMN IF NOT EXISTS upvotes INTEGER DEFAULT 0;
+	ALTER TABLE comments ADD COLUMN IF NOT EXISTS downvotes INTEGER DEFAULT 0;
+	ALTER TABLE votes ADD COLUMN IF NOT EXISTS weight INTEGER NOT NULL DEFAULT 1;
+	ALTER TABLE votes ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ;
+	ALTER TABLE challenges ADD COLUMN IF NOT EXISTS failures INTEGER NOT NULL DEFAULT 0;
+
+	-- Community reports; one per target from each agent and each IP
+	CREATE TABLE IF NOT EXISTS flags (
+		id TEXT PRIMARY KEY,
+		target_type TEXT NOT NULL,
+		target_id TEXT NOT NULL,
+		reason TEXT NOT NULL,
+		agent_id TEXT,
+		ip_hash TEXT,
+		created_at TIMESTAMPTZ DEFAULT NOW()
+	);
+
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_flags_agent ON flags(target_type, target_id, agent_id);
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_flags_ip ON flags(target_type, target_id, ip_hash);
+	CREATE INDEX IF NOT EXISTS idx_flags_created_at ON flags(created_at);
+
+	-- Agents that have signed in with an account's keys; outlives their tokens
+	CREATE TABLE IF NOT EXISTS account_agents (
+		account_id TEXT NOT NULL,
+		agent_id TEXT NOT NULL,
+		PRIMARY KEY (account_id, agent_id)
+	);
+
+	INSERT INTO account_agents (account_id, agent_id)
+		SELECT DISTINCT account_id, agent_id FROM tokens WHERE account_id IS NOT NULL
+		ON CONFLICT DO NOTHING;
+
+	-- Stories' tags, normalized, for tag listing and filtering
+	CREATE TABLE IF NOT EXISTS story_tags (
+		story_id TEXT NOT NULL,
+		tag TEXT NOT NULL,
+		PRIMARY KEY (story_id, tag)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_story_tags_tag ON story_tags(tag);
+
+	-- Fill story_tags from stories created before it existed
+	INSERT INTO story_tags (story_id, tag)
+		SELECT stories.id, lower(btrim(tag))
+		FROM stories, jsonb_array_elements_text(
+			CASE WHEN jsonb_typeof(stories.tags) = 'array' THEN stories.tags ELSE '[]'::jsonb END
+		) AS tag
+		WHERE btrim(tag) <> '' AND NOT EXISTS (SELECT 1 FROM story_tags)
+		ON CONFLICT DO NOTHING;
+
+	-- Responses remembered for retried requests sent with an Idempotency-Key
+	CREATE TABLE IF NOT EXISTS idempotency_keys (
+		scope TEXT NOT NULL,
+		idempotency_key TEXT NOT NULL,
+		request_hash TEXT NOT NULL,
+		resource_id TEXT,
+		response TEXT NOT NULL,
+		created_at TIMESTAMPTZ DEFAULT NOW(),
+		expires_at TIMESTAMPTZ NOT NULL,
+		PRIMARY KEY (scope, idempotency_key)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires_at ON idempotency_keys(expires_at);
+
+	-- Scoped admin bearer tokens; only a SHA-256 of each token is kept
+	CREATE TABLE IF NOT EXISTS admin_tokens (
+		id TEXT PRIMARY KEY,
+		label TEXT,
+		token_hash TEXT NOT NULL UNIQUE,
+		scopes TEXT NOT NULL,
+		created_at TIMESTAMPTZ DEFAULT NOW()
+	);
+
+	-- Actions taken by admins, read newest first by the activity feed
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id TEXT PRIMARY KEY,
+		action TEXT NOT NULL,
+		target_type TEXT,
+		target_id TEXT,
+		actor TEXT NOT NULL,
+		created_at TIMESTAMPTZ DEFAULT NOW()
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_audit_log_created_at ON audit_log(created_at);
+	`
+
+	if _, err := s.db.Exec(schema); err != nil {
+		return err
+	}
+	if err := backfillCommentDepth(s.db); err != nil {
+		return err
+	}
+	if err := backfillURLKeys(s.db, rebind(updateURLKeyQuery)); err != nil {
+		return err
+	}
+	return backfillVoteCounts(s.db)
+}
+
+func (s *PostgresStore) Close() error {
+	return s.db.Close()
+}
+
+// Ping reports whether the database can be reached
+func (s *PostgresStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// CheckSchema reports an error if a table migrate creates is missing
+func (s *PostgresStore) CheckSchema(ctx context.Context) error {
+	return checkSchema(ctx, s.conn)
+}
+
+// WithTx runs fn against a store bound to one transaction, committing if fn
+// returns nil and rolling back every write fn made otherwise. Inside fn, use
+// only the store it is given; WithTx on that store joins the same transaction.
+func (s *PostgresStore) WithTx(ctx context.Context, fn func(tx Store) error) error {
+	if s.tx != nil {
+		return fn(s)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(&PostgresStore{db: s.db, conn: tx, tx: tx}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// rebind rewrites ? placeholders to Postgres' positional $1, $2, ... form
+func rebind(query string) string {
+	var b strings.Builder
+	b.Grow(len(query) + 16)
+
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (s *PostgresStore) exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return s.conn.ExecContext(ctx, rebind(query), args...)
+}
+
+func (s *PostgresStore) query(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return s.conn.QueryContext(ctx, rebind(query), args...)
+}
+
+func (s *PostgresStore) queryRow(ctx context.Context, query string, args ...any) *sql.Row {
+	return s.conn.QueryRowContext(ctx, rebind(query), args...)
+}
+
+// Stories
+
+func (s *PostgresStore) CreateStory(ctx context.Context, story *Story) error {
+	if story.ID == "" {
+		story.ID = uuid.New().String()
+	}
+	if story.CreatedAt.IsZero() {
+		story.CreatedAt = time.Now().UTC()
+	}
+
+	tagsJSON, err := marshalTags(story.Tags)
+	if err != nil {
+		return err
+	}
+
+	tx, err := beginTx(ctx, s.db, s.tx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	exec := func(query string, args ...any) (sql.Result, error) {
+		return tx.ExecContext(ctx, rebind(query), args...)
+	}
+	_, err = exec(insertStoryQuery, story.ID, story.Title, nullString(story.URL), nullString(story.Text), tagsJSON,
+		story.Score, story.CommentCount, story.CreatedAt, story.Hidden,
+		nullString(story.AgentID), story.AgentVerified, story.Pending,
+		nullString(textHash(story.Text)), nullString(urlKey(story.URL)), nullString(story.SourceURL))
+	if err != nil {
+		return err
+	}
+	if err := insertStoryTags(story.ID, story.Tags, exec); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *PostgresStore) GetStory(ctx context.Context, id string) (*Story, error) {
+	row := s.queryRow(ctx, `
+		SELECT `+storyColumns+`
+		FROM stories WHERE id = ? AND NOT hidden
+	`, id)
+
+	story, err := scanStory(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return story, err
+}
+
+func (s *PostgresStore) ListStories(ctx context.Context, opts ListOptions) ([]*Story, string, error) {
+	return s.listStories(ctx, opts, "")
+}
+
+func (s *PostgresStore) ListStoriesByAgent(ctx context.Context, agentID string, opts ListOptions) ([]*Story, string, error) {
+	return s.listStories(ctx, opts, "agent_id = ?", agentID)
+}
+
+// listStories lists visible stories, optionally narrowed by an extra WHERE
+// condition whose placeholders are bound to args
+func (s *PostgresStore) listStories(ctx context.Context, opts ListOptions, filter string, args ...any) ([]*Story, string, error) {
+	if opts.Limit <= 0 || opts.Limit > 100 {
+		opts.Limit = 30
+	}
+
+	// One extra row tells us whether there is a next page
+	limit := opts.Limit + 1
+
+	filter, args = tagFilter(opts.Tag, filter, args)
+	filter = typeFilter(opts.Type, filter)
+
+	// keys are what a listing orders by, all descending, so the page after a
+	// cursor is the rows whose keys compare below the cursor story's
+	keys, keyArgs := []string{"created_at", "id"}, []any(nil)
+	switch opts.Sort {
+	case SortDiscover:
+		// Sampled from the newest stories below
+		limit = discoverPoolSize
+	case SortDiscussed:
+		keys = []string{"comment_count", "created_at", "id"}
+	case SortNew:
+	default: // SortTop
+		// Time-decay ranking: score / (hours + offset)^gravity. NOW() is the
+		// same throughout the statement, so the cursor story and the rows
+		// compared with it are ranked at the same instant.
+		gravity, offset := opts.rankParams()
+		score, scoreArgs := opts.rankScore("GREATEST")
+		keys = []string{score + " / POWER(EXTRACT(EPOCH FROM (NOW() - created_at)) / 3600 + ?::float8, ?::float8)", "created_at", "id"}
+		keyArgs = append(scoreArgs, offset, gravity)
+	}
+
+	var where string
+	if filter != "" {
+		where = " AND " + filter
+	}
+	if opts.Cursor != "" && opts.Sort != SortDiscover {
+		tuple := strings.Join(keys, ", ")
+		where += " AND (" + tuple + ") < (SELECT " + tuple + " FROM stories WHERE id = ?)"
+		args = append(append(append(args, keyArgs...), keyArgs...), opts.Cursor)
+	}
+	args = append(args, keyArgs...)
+
+	query := fmt.Sprintf(`
+		SELECT `+storyColumns+`
+		FROM stories WHERE NOT hidden%s
+		ORDER BY %s DESC
+		LIMIT ?
+	`, where, strings.Join(keys, " DESC, "))
+
+	rows, err := s.query(ctx, query, append(args, limit)...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var stories []*Story
+	for rows.Next() {
+		story, err := scanStory(rows)
+		if err != nil {
+			return nil, "", err
+		}
+		stories = append(stories, story)
+	}
+
+	// A sample is complete in itself, so it has no next page
+	if opts.Sort == SortDiscover {
+		return sampleStories(stories, opts.Limit, opts.Seed), "", nil
+	}
+
+	var nextCursor string
+	if len(stories) > opts.Limit {
+		stories = stories[:opts.Limit]
+		nextCursor = stories[len(stories)-1].ID
+	}
+
+	return stories, nextCursor, nil
+}
+
+func (s *PostgresStore) CountStories(ctx context.Context, opts ListOptions) (int, error) {
+	return s.countStories(ctx, opts, "")
+}
+
+func (s *PostgresStore) CountStoriesByAgent(ctx context.Context, agentID string, opts ListOptions) (int, error) {
+	return s.countStories(ctx, opts, "agent_id = ?", agentID)
+}
+
+// countStories counts what listStories lists with the same filter
+func (s *PostgresStore) countStories(ctx context.Context, opts ListOptions, filter string, args ...any) (int, error) {
+	filter, args = tagFilter(opts.Tag, filter, args)
+	filter = typeFilter(opts.Type, filter)
+	var where string
+	if filter != "" {
+		where = " AND " + filter
+	}
+
+	var count int
+	err := s.queryRow(ctx, "SELECT COUNT(*) FROM stories WHERE NOT hidden"+where, args...).Scan(&count)
+	return count, err
+}
+
+func (s *PostgresStore) ListStoriesModified(ctx context.Context, offset, limit int) ([]*StoryModified, error) {
+	rows, err := s.query(ctx, `
+		SELECT stories.id, stories.created_at, comments.created_at
+		FROM stories LEFT JOIN comments ON comments.id = (
+			SELECT c.id FROM comments c
+			WHERE c.story_id = stories.id AND NOT c.hidden
+			ORDER BY c.created_at DESC, c.id DESC
+			LIMIT 1
+		)
+		WHERE NOT stories.hidden
+		ORDER BY stories.created_at, stories.id
+		LIMIT ? OFFSET ?
+	`, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return scanRows(rows, scanStoryModified)
+}
+
+func (s *PostgresStore) FindStoryByURL(ctx context.Context, url string, since time.Time) (*Story, error) {
+	row := s.queryRow(ctx, `
+		SELECT `+storyColumns+`
+		FROM stories WHERE url_key = ? AND created_at > ? AND NOT hidden
+		ORDER BY created_at DESC LIMIT 1
+	`, urlKey(url), since)
+
+	story, err := scanStory(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return story, err
+}
+
+func (s *PostgresStore) FindStoryByText(ctx context.Context, text string, since time.Time) (*Story, error) {
+	hash := textHash(text)
+	if hash == "" {
+		return nil, nil
+	}
+
+	row := s.queryRow(ctx, `
+		SELECT `+storyColumns+`
+		FROM stories WHERE text_hash = ? AND created_at > ? AND NOT hidden
+		ORDER BY created_at DESC LIMIT 1
+	`, hash, since)
+
+	story, err := scanStory(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return story, err
+}
+
+func (s *PostgresStore) GetLastStoryByAgent(ctx context.Context, agentID string) (*Story, error) {
+	row := s.queryRow(ctx, `
+		SELECT `+storyColumns+`
+		FROM stories WHERE agent_id = ?
+		ORDER BY created_at DESC LIMIT 1
+	`, agentID)
+
+	story, err := scanStory(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return story, err
+}
+
+// CreateStories stores a batch of stories in one transaction. A story that
+// reposts a recent URL or text body, or whose tags are too large, is
+// reported in its result and skipped rather than failing the batch.
+func (s *PostgresStore) CreateStories(ctx context.Context, stories []*Story, urlSince, textSince time.Time) ([]StoryBatchResult, error) {
+	tx, err := beginTx(ctx, s.db, s.tx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	queryRow := func(query string, args ...any) *sql.Row {
+		return tx.QueryRowContext(ctx, rebind(query), args...)
+	}
+	exec := func(query string, args ...any) (sql.Result, error) {
+		return tx.ExecContext(ctx, rebind(query), args...)
+	}
+
+	results := make([]StoryBatchResult, len(stories))
+	for i, story := range stories {
+		existing, err := findRepost(story, urlSince, textSince, "NOT hidden", queryRow)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil {
+			results[i].Existing = existing
+			continue
+		}
+
+		if story.ID == "" {
+			story.ID = uuid.New().String()
+		}
+		if story.CreatedAt.IsZero() {
+			story.CreatedAt = time.Now().UTC()
+		}
+		tagsJSON, err := marshalTags(story.Tags)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+
+		_, err = exec(insertStoryQuery, story.ID, story.Title, nullString(story.URL), nullString(story.Text), tagsJSON,
+			story.Score, story.CommentCount, story.CreatedAt, story.Hidden,
+			nullString(story.AgentID), story.AgentVerified, story.Pending,
+			nullString(textHash(story.Text)), nullString(urlKey(story.URL)), nullString(story.SourceURL))
+		if err != nil {
+			return nil, err
+		}
+		if err := insertStoryTags(story.ID, story.Tags, exec); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// CreateStoryWithComment mirrors SQLiteStore.CreateStoryWithComment
+func (s *PostgresStore) CreateStoryWithComment(ctx context.Context, story *Story, comment *Comment) error {
+	if story.ID == "" {
+		story.ID = uuid.New().String()
+	}
+	if story.CreatedAt.IsZero() {
+		story.CreatedAt = time.Now().UTC()
+	}
+	if comment.ID == "" {
+		comment.ID = uuid.New().String()
+	}
+	if comment.CreatedAt.IsZero() {
+		comment.CreatedAt = story.CreatedAt
+	}
+	comment.StoryID, comment.ParentID, comment.Depth = story.ID, "", 0
+	story.CommentCount++
+
+	tagsJSON, err := marshalTags(story.Tags)
+	if err != nil {
+		return err
+	}
+
+	tx, err := beginTx(ctx, s.db, s.tx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	exec := func(query string, args ...any) (sql.Result, error) {
+		return tx.ExecContext(ctx, rebind(query), args...)
+	}
+	_, err = exec(insertStoryQuery, story.ID, story.Title, nullString(story.URL), nullString(story.Text), tagsJSON,
+		story.Score, story.CommentCount, story.CreatedAt, story.Hidden,
+		nullString(story.AgentID), story.AgentVerified, story.Pending,
+		nullString(textHash(story.Text)), nullString(urlKey(story.URL)), nullString(story.SourceURL))
+	if err != nil {
+		return err
+	}
+	if err := insertStoryTags(story.ID, story.Tags, exec); err != nil {
+		return err
+	}
+	_, err = exec(insertCommentQuery, comment.ID, comment.StoryID, nil, comment.Text,
+		comment.Score, comment.CreatedAt, comment.Hidden,
+		nullString(comment.AgentID), comment.AgentVerified, comment.Depth)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *PostgresStore) UpdateStoryScore(ctx context.Context, id string, delta int) error {
+	_, err := s.exec(ctx, `UPDATE stories SET score = score + ? WHERE id = ?`, delta, id)
+	return err
+}
+
+func (s *PostgresStore) UpdateStoryCommentCount(ctx context.Context, id string, delta int) error {
+	_, err := s.exec(ctx, `UPDATE stories SET comment_count = comment_count + ? WHERE id = ?`, delta, id)
+	return err
+}
+
+// GetPendingStory returns a story awaiting approval, or nil if there is no
+// such pending story
+func (s *PostgresStore) GetPendingStory(ctx context.Context, id string) (*Story, error) {
+	row := s.queryRow(ctx, `
+		SELECT `+storyColumns+`
+		FROM stories WHERE id = ? AND pending = TRUE
+	`, id)
+
+	story, err := scanStory(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return story, err
+}
+
+// ListPendingStories returns the review queue, oldest first. A non-empty
+// agentID narrows it to that agent's submissions.
+func (s *PostgresStore) ListPendingStories(ctx context.Context, agentID string, opts ListOptions) ([]*Story, string, error) {
+	if opts.Limit <= 0 || opts.Limit > 100 {
+		opts.Limit = 30
+	}
+
+	where := "pending = TRUE"
+	var args []any
+	if agentID != "" {
+		where += " AND agent_id = ?"
+		args = append(args, agentID)
+	}
+	if opts.Cursor != "" {
+		where += " AND (created_at, id) > (SELECT created_at, id FROM stories WHERE id = ?)"
+		args = append(args, opts.Cursor)
+	}
+	args = append(args, opts.Limit+1)
+
+	rows, err := s.query(ctx, `
+		SELECT `+storyColumns+`
+		FROM stories WHERE `+where+`
+		ORDER BY created_at ASC, id ASC
+		LIMIT ?
+	`, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var stories []*Story
+	for rows.Next() {
+		story, err := scanStory(rows)
+		if err != nil {
+			return nil, "", err
+		}
+		stories = append(stories, story)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	var nextCursor string
+	if len(stories) > opts.Limit {
+		stories = stories[:opts.Limit]
+		nextCursor = stories[len(stories)-1].ID
+	}
+
+	return stories, nextCursor, nil
+}
+
+// ListOwnStories lists every story by agentID or, when accountID is set, by
+// any agent signed in to the account, hidden and pending ones included,
+// newest first
+func (s *PostgresStore) ListOwnStories(ctx context.Context, agentID, accountID string, opts ListOptions) ([]*Story, string, error) {
+	if opts.Limit <= 0 || opts.Limit > 100 {
+		opts.Limit = 30
+	}
+
+	where, args := ownerFilter(agentID, accountID)
+	if opts.Cursor != "" {
+		where += " AND (created_at, id) < (SELECT created_at, id FROM stories WHERE id = ?)"
+		args = append(args, opts.Cursor)
+	}
+	args = append(args, opts.Limit+1)
+
+	rows, err := s.query(ctx, `
+		SELECT `+storyColumns+`
+		FROM stories WHERE `+where+`
+		ORDER BY created_at DESC, id DESC
+		LIMIT ?
+	`, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var stories []*Story
+	for rows.Next() {
+		story, err := scanStory(rows)
+		if err != nil {
+			return nil, "", err
+		}
+		stories = append(stories, story)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	var nextCursor string
+	if len(stories) > opts.Limit {
+		stories = stories[:opts.Limit]
+		nextCursor = stories[len(stories)-1].ID
+	}
+	return stories, nextCursor, nil
+}
+
+// ListOwnComments is ListOwnStories for comments
+func (s *PostgresStore) ListOwnComments(ctx context.Context, agentID, accountID string, opts ListOptions) ([]*Comment, string, error) {
+	if opts.Limit <= 0 || opts.Limit > 100 {
+		opts.Limit = 30
+	}
+
+	where, args := ownerFilter(agentID, accountID)
+	if opts.Cursor != "" {
+		where += " AND (created_at, id) < (SELECT created_at, id FROM comments WHERE id = ?)"
+		args = append(args, opts.Cursor)
+	}
+	args = append(args, opts.Limit+1)
+
+	comments, err := s.queryComments(ctx, `
+		SELECT `+commentColumns+`
+		FROM comments WHERE `+where+`
+		ORDER BY created_at DESC, id DESC
+		LIMIT ?
+	`, args...)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var nextCursor string
+	if len(comments) > opts.Limit {
+		comments = comments[:opts.Limit]
+		nextCursor = comments[len(comments)-1].ID
+	}
+	return comments, nextCursor, nil
+}
+
+// ApproveStory publishes a pending story. It reports false if the story was
+// not pending.
+func (s *PostgresStore) ApproveStory(ctx context.Context, id string) (bool, error) {
+	res, err := s.exec(ctx, `UPDATE stories SET hidden = FALSE, pending = FALSE WHERE id = ? AND pending = TRUE`, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *PostgresStore) ListTags(ctx context.Context) ([]TagCount, error) {
+	rows, err := s.query(ctx, listTagsQuery("NOT hidden"))
+	if err != nil {
+		return nil, err
+	}
+	return scanTagCounts(rows)
+}
+
+func (s *PostgresStore) HideStory(ctx context.Context, id string) error {
+	_, err := s.exec(ctx, `UPDATE stories SET hidden = TRUE, pending = FALSE WHERE id = ?`, id)
+	return err
+}
+
+// UnhideStory restores a story hidden by a moderator, flags, or its author. A
+// pending story is left for ApproveStory.
+func (s *PostgresStore) UnhideStory(ctx context.Context, id string) error {
+	_, err := s.exec(ctx, `UPDATE stories SET hidden = FALSE WHERE id = ? AND NOT pending`, id)
+	return err
+}
+
+func (s *PostgresStore) GetHiddenStory(ctx context.Context, id string) (*Story, error) {
+	row := s.queryRow(ctx, `
+		SELECT `+storyColumns+`
+		FROM stories WHERE id = ? AND hidden AND NOT pending
+	`, id)
+
+	story, err := scanStory(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return story, err
+}
+
+// Comments
+
+func (s *PostgresStore) CreateComment(ctx context.Context, comment *Comment) error {
+	if comment.ID == "" {
+		comment.ID = uuid.New().String()
+	}
+	if comment.CreatedAt.IsZero() {
+		comment.CreatedAt = time.Now().UTC()
+	}
+
+	if comment.ParentID != "" {
+		var parentDepth int
+		err := s.queryRow(ctx, `SELECT depth FROM comments WHERE id = ?`, comment.ParentID).Scan(&parentDepth)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		comment.Depth = parentDepth + 1
+	}
+
+	_, err := s.exec(ctx, insertCommentQuery, comment.ID, comment.StoryID, nullString(comment.ParentID), comment.Text,
+		comment.Score, comment.CreatedAt, comment.Hidden,
+		nullString(comment.AgentID), comment.AgentVerified, comment.Depth)
+
+	return err
+}
+
+func (s *PostgresStore) GetComment(ctx context.Context, id string) (*Comment, error) {
+	row := s.queryRow(ctx, `
+		SELECT `+commentColumns+`
+		FROM comments WHERE id = ? AND NOT hidden
+	`, id)
+
+	comment, err := scanComment(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return comment, err
+}
+
+func (s *PostgresStore) CountComments(ctx context.Context, storyID string) (int, error) {
+	var count int
+	err := s.queryRow(ctx, "SELECT COUNT(*) FROM comments WHERE story_id = ? AND NOT hidden", storyID).Scan(&count)
+	return count, err
+}
+
+// ListComments mirrors SQLiteStore.ListComments; see there for paging semantics
+func (s *PostgresStore) ListComments(ctx context.Context, storyID string, opts CommentListOptions) ([]*Comment, string, error) {
+	sort := commentSortFor(opts.Sort)
+
+	if opts.View == ViewTree && (opts.Limit > 0 || opts.MaxComments > 0) {
+		return s.listCommentTreePage(ctx, storyID, "", sort, opts)
+	}
+
+	where := "story_id = ? AND NOT hidden"
+	args := []any{storyID}
+	limit := ""
+	if opts.Limit > 0 {
+		if opts.Cursor != "" {
+			where += " AND " + sort.after
+			args = append(args, opts.Cursor)
+		}
+		limit = "LIMIT ?"
+		args = append(args, opts.Limit+1)
+	} else if opts.MaxComments > 0 {
+		limit = "LIMIT ?"
+		args = append(args, opts.MaxComments)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM comments WHERE %s
+		ORDER BY %s
+		%s
+	`, commentColumns, where, sort.orderBy, limit)
+
+	comments, err := s.queryComments(ctx, query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var nextCursor string
+	if opts.Limit > 0 && len(comments) > opts.Limit {
+		comments = comments[:opts.Limit]
+		nextCursor = comments[len(comments)-1].ID
+	}
+
+	if opts.View == ViewTree {
+		return buildCommentTree(comments, "", opts.MaxDepth), nextCursor, nil
+	}
+
+	return comments, nextCursor, nil
+}
+
+func (s *PostgresStore) listCommentTreePage(ctx context.Context, storyID, parentID string, sort commentSort, opts CommentListOptions) ([]*Comment, string, error) {
+	where := "story_id = ? AND parent_id IS NULL AND NOT hidden"
+	args := []any{storyID}
+	if parentID != "" {
+		where = "parent_id = ? AND NOT hidden"
+		args = []any{parentID}
+	}
+	if opts.Cursor != "" {
+		where += " AND " + sort.after
+		args = append(args, opts.Cursor)
+	}
+	limit := ""
+	if opts.Limit > 0 {
+		limit = "LIMIT ?"
+		args = append(args, opts.Limit+1)
+	}
+
+	rows, err := s.query(ctx, fmt.Sprintf(`
+		SELECT id FROM comments WHERE %s
+		ORDER BY %s
+		%s
+	`, where, sort.orderBy, limit), args...)
+	if err != nil {
+		return nil, "", err
+	}
+	var rootIDs []any
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, "", err
+		}
+		rootIDs = append(rootIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	var nextCursor string
+	if opts.Limit > 0 && len(rootIDs) > opts.Limit {
+		rootIDs = rootIDs[:opts.Limit]
+		nextCursor = rootIDs[len(rootIDs)-1].(string)
+	}
+	if len(rootIDs) == 0 {
+		return nil, "", nil
+	}
+
+	if opts.Limit == 0 && opts.MaxComments > 0 {
+		rows, err := s.query(ctx, fmt.Sprintf(`
+			WITH RECURSIVE thread(root, id) AS (
+				SELECT id, id FROM comments WHERE %s
+				UNION ALL
+				SELECT t.root, c.id FROM comments c JOIN thread t ON c.parent_id = t.id
+			)
+			SELECT t.root, COUNT(*) FROM thread t JOIN comments c ON c.id = t.id
+			WHERE NOT c.hidden
+			GROUP BY t.root
+		`, where), args...)
+		if err != nil {
+			return nil, "", err
+		}
+		sizes := make(map[string]int)
+		for rows.Next() {
+			var root string
+			var size int
+			if err := rows.Scan(&root, &size); err != nil {
+				rows.Close()
+				return nil, "", err
+			}
+			sizes[root] = size
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, "", err
+		}
+		rootIDs, nextCursor = takeWholeThreads(rootIDs, sizes, opts.MaxComments)
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(rootIDs)), ",")
+	query := fmt.Sprintf(`
+		WITH RECURSIVE thread(id) AS (
+			SELECT id FROM comments WHERE id IN (%s)
+			UNION ALL
+			SELECT c.id FROM comments c JOIN thread t ON c.parent_id = t.id
+		)
+		SELECT %s
+		FROM comments WHERE id IN (SELECT id FROM thread) AND NOT hidden
+		ORDER BY %s
+	`, placeholders, commentColumns, sort.orderBy)
+
+	comments, err := s.queryComments(ctx, query, rootIDs...)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return buildCommentTree(comments, parentID, opts.MaxDepth), nextCursor, nil
+}
+
+// ListReplies returns the replies to a comment, paginated like ListComments.
+// The flat view pages through direct replies only; the tree view pages through
+// direct replies, each returned with its full subtree.
+func (s *PostgresStore) ListReplies(ctx context.Context, parentID string, opts CommentListOptions) ([]*Comment, string, error) {
+	sort := commentSortFor(opts.Sort)
+
+	if opts.View == ViewTree {
+		return s.listCommentTreePage(ctx, "", parentID, sort, opts)
+	}
+
+	where := "parent_id = ? AND NOT hidden"
+	args := []any{parentID}
+	limit := ""
+	if opts.Limit > 0 {
+		if opts.Cursor != "" {
+			where += " AND " + sort.after
+			args = append(args, opts.Cursor)
+		}
+		limit = "LIMIT ?"
+		args = append(args, opts.Limit+1)
+	}
+
+	comments, err := s.queryComments(ctx, fmt.Sprintf(`
+		SELECT %s
+		FROM comments WHERE %s
+		ORDER BY %s
+		%s
+	`, commentColumns, where, sort.orderBy, limit), args...)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var nextCursor string
+	if opts.Limit > 0 && len(comments) > opts.Limit {
+		comments = comments[:opts.Limit]
+		nextCursor = comments[len(comments)-1].ID
+	}
+
+	return comments, nextCursor, nil
+}
+
+func (s *PostgresStore) TopComments(ctx context.Context, storyIDs []string) (map[string]*Comment, error) {
+	if len(storyIDs) == 0 {
+		return map[string]*Comment{}, nil
+	}
+
+	args := make([]any, len(storyIDs))
+	for i, id := range storyIDs {
+		args[i] = id
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(storyIDs)), ",")
+
+	comments, err := s.queryComments(ctx, fmt.Sprintf(topCommentsQuery, placeholders, "NOT hidden", commentSortFor(SortTop).orderBy), args...)
+	if err != nil {
+		return nil, err
+	}
+	return commentsByStory(comments), nil
+}
+
+func (s *PostgresStore) queryComments(ctx context.Context, query string, args ...any) ([]*Comment, error) {
+	rows, err := s.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var comments []*Comment
+	for rows.Next() {
+		comment, err := scanComment(rows)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, comment)
+	}
+
+	return comments, rows.Err()
+}
+
+func (s *PostgresStore) UpdateCommentScore(ctx context.Context, id string, delta int) error {
+	_, err := s.exec(ctx, `UPDATE comments SET score = score + ? WHERE id = ?`, delta, id)
+	return err
+}
+
+func (s *PostgresStore) UpdateCommentText(ctx context.Context, id, text string) error {
+	_, err := s.exec(ctx, `UPDATE comments SET text = ?, edited_at = ? WHERE id = ?`, text, time.Now().UTC(), id)
+	return err
+}
+
+func (s *PostgresStore) HideComment(ctx context.Context, id string) error {
+	return s.setCommentHidden(ctx, id, true, -1)
+}
+
+func (s *PostgresStore) UnhideComment(ctx context.Context, id string) error {
+	return s.setCommentHidden(ctx, id, false, 1)
+}
+
+// setCommentHidden hides or restores a comment and, if that changed it, moves
+// its story's comment count by delta in the same transaction
+func (s *PostgresStore) setCommentHidden(ctx context.Context, id string, hidden bool, delta int) error {
+	tx, err := beginTx(ctx, s.db, s.tx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, rebind(`UPDATE comments SET hidden = ? WHERE id = ? AND hidden <> ?`), hidden, id, hidden)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, rebind(updateCommentCountQuery), delta, id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RecomputeCommentCount resets a story's comment count to its visible
+// comments and returns it
+func (s *PostgresStore) RecomputeCommentCount(ctx context.Context, storyID string) (int, error) {
+	var count int
+	err := s.queryRow(ctx, fmt.Sprintf(recomputeCommentCountQuery, "NOT hidden"), storyID).Scan(&count)
+	return count, err
+}
+
+func (s *PostgresStore) GetHiddenComment(ctx context.Context, id string) (*Comment, error) {
+	row := s.queryRow(ctx, `
+		SELECT `+commentColumns+`
+		FROM comments WHERE id = ? AND hidden
+	`, id)
+
+	comment, err := scanComment(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return comment, err
+}
+
+func (s *PostgresStore) AnonymizeComment(ctx context.Context, id string, scrubText bool) error {
+	if scrubText {
+		_, err := s.exec(ctx, `UPDATE comments SET agent_id = ?, agent_verified = FALSE, text = ? WHERE id = ?`,
+			DeletedAgentID, DeletedText, id)
+		return err
+	}
+	_, err := s.exec(ctx, `UPDATE comments SET agent_id = ?, agent_verified = FALSE WHERE id = ?`, DeletedAgentID, id)
+	return err
+}
+
+// Votes
+
+func (s *PostgresStore) CreateVote(ctx context.Context, vote *Vote) error {
+	if vote.ID == "" {
+		vote.ID = uuid.New().String()
+	}
+	if vote.CreatedAt.IsZero() {
+		vote.CreatedAt = time.Now().UTC()
+	}
+
+	_, err := s.exec(ctx, `
+		INSERT INTO votes (id, target_type, target_id, value, weight, created_at, ip_hash, agent_id, agent_verified)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, vote.ID, vote.TargetType, vote.TargetID, vote.Value, vote.weight(), vote.CreatedAt,
+		nullString(vote.IPHash), nullString(vote.AgentID), vote.AgentVerified)
+
+	return err
+}
+
+func (s *PostgresStore) GetVote(ctx context.Context, targetType, targetID, ipHash, agentID string) (*Vote, error) {
+	voter, voterArg := voterMatch(ipHash, agentID)
+	query := `
+		SELECT id, target_type, target_id, value, weight, created_at, updated_at, ip_hash, agent_id, agent_verified
+		FROM votes WHERE target_type = ? AND target_id = ? AND ` + voter
+	// In a transaction (as in CastVote), FOR UPDATE holds the voter's existing
+	// row so a concurrent recast can't compute its delta from a stale value
+	if s.tx != nil {
+		query += " FOR UPDATE"
+	}
+	row := s.queryRow(ctx, query, targetType, targetID, voterArg)
+
+	vote, err := scanVote(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return vote, err
+}
+
+func (s *PostgresStore) UpdateVote(ctx context.Context, id string, value int) error {
+	_, err := s.exec(ctx, `UPDATE votes SET value = ?, updated_at = ? WHERE id = ?`, value, time.Now().UTC(), id)
+	return err
+}
+
+func (s *PostgresStore) DeleteVote(ctx context.Context, id string) error {
+	_, err := s.exec(ctx, `DELETE FROM votes WHERE id = ?`, id)
+	return err
+}
+
+func (s *PostgresStore) CastVote(ctx context.Context, vote *Vote) (int, error) {
+	var score int
+	err := s.WithTx(ctx, func(tx Store) error {
+		var err error
+		score, err = castVote(ctx, tx, vote)
+		return err
+	})
+	return score, err
+}
+
+// AdjustScore moves a story's or comment's score by delta and returns the new score
+func (s *PostgresStore) AdjustScore(ctx context.Context, targetType, targetID string, delta int) (int, error) {
+	table, err := voteTargetTable(targetType)
+	if err != nil {
+		return 0, err
+	}
+
+	var score int
+	err = s.queryRow(ctx, `UPDATE `+table+` SET score = score + ? WHERE id = ? RETURNING score`, delta, targetID).Scan(&score)
+	return score, err
+}
+
+// AdjustVoteCounts moves a story's or comment's up and down vote counts
+func (s *PostgresStore) AdjustVoteCounts(ctx context.Context, targetType, targetID string, up, down int) error {
+	table, err := voteTargetTable(targetType)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.exec(ctx, `UPDATE `+table+` SET upvotes = upvotes + ?, downvotes = downvotes + ? WHERE id = ?`, up, down, targetID)
+	return err
+}
+
+// RecomputeScore resets a target's score and vote counts from its votes and
+// returns the score
+func (s *PostgresStore) RecomputeScore(ctx context.Context, targetType, targetID string) (int, error) {
+	table, err := voteTargetTable(targetType)
+	if err != nil {
+		return 0, err
+	}
+
+	var score int
+	err = s.queryRow(ctx, `
+		UPDATE `+table+` SET score = (
+			SELECT COALESCE(SUM(value * weight), 0) FROM votes WHERE target_type = ? AND target_id = ?
+		), `+voteCounts(targetType, table)+`
+		WHERE id = ?
+		RETURNING score
+	`, targetType, targetID, targetID).Scan(&score)
+	return score, err
+}
+
+// RecomputeAllScores resets every score and vote count of targetType from
+// its votes and returns how many targets had drifted
+func (s *PostgresStore) RecomputeAllScores(ctx context.Context, targetType string) (int64, error) {
+	table, err := voteTargetTable(targetType)
+	if err != nil {
+		return 0, err
+	}
+
+	tally := `(SELECT COALESCE(SUM(value * weight), 0) FROM votes WHERE target_type = ? AND target_id = ` + table + `.id)`
+	res, err := s.exec(ctx, `
+		UPDATE `+table+` SET score = `+tally+`, `+voteCounts(targetType, table)+`
+		WHERE score <> `+tally+`
+			OR upvotes <> `+voteCount(targetType, table, ">")+`
+			OR downvotes <> `+voteCount(targetType, table, "<"),
+		targetType, targetType)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *PostgresStore) RecomputeStory(ctx context.Context, id string) (*Story, error) {
+	return scanStory(s.queryRow(ctx, fmt.Sprintf(recomputeStoryQuery, "NOT hidden"), id))
+}
+
+func (s *PostgresStore) TallyStoryVotes(ctx context.Context, storyID string) (map[string]VoteTally, error) {
+	rows, err := s.query(ctx, fmt.Sprintf(storyVoteTallyQuery, "NOT hidden"), storyID, storyID)
+	if err != nil {
+		return nil, err
+	}
+	return scanVoteTallies(rows)
+}
+
+// Flags
+
+func (s *PostgresStore) CreateFlag(ctx context.Context, flag *Flag) (int, error) {
+	if flag.ID == "" {
+		flag.ID = uuid.New().String()
+	}
+	if flag.CreatedAt.IsZero() {
+		flag.CreatedAt = time.Now().UTC()
+	}
+
+	// A repeat from the same agent or IP hits a unique index and is dropped
+	_, err := s.exec(ctx, `
+		INSERT INTO flags (id, target_type, target_id, reason, agent_id, ip_hash, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT DO NOTHING
+	`, flag.ID, flag.TargetType, flag.TargetID, flag.Reason,
+		nullString(flag.AgentID), nullString(flag.IPHash), flag.CreatedAt)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	err = s.queryRow(ctx, `SELECT COUNT(*) FROM flags WHERE target_type = ? AND target_id = ?`,
+		flag.TargetType, flag.TargetID).Scan(&count)
+	return count, err
+}
+
+func (s *PostgresStore) ListFlags(ctx context.Context, limit int) ([]*Flag, error) {
+	rows, err := s.query(ctx, `
+		SELECT id, target_type, target_id, reason, created_at, ip_hash, agent_id
+		FROM flags ORDER BY created_at DESC, id LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var flags []*Flag
+	for rows.Next() {
+		flag, err := scanFlag(rows)
+		if err != nil {
+			return nil, err
+		}
+		flags = append(flags, flag)
+	}
+	return flags, rows.Err()
+}
+
+// Audit log
+
+func (s *PostgresStore) LogAudit(ctx context.Context, entry *AuditEntry) error {
+	if entry.ID == "" {
+		entry.ID = uuid.New().String()
+	}
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now().UTC()
+	}
+
+	_, err := s.exec(ctx, insertAuditQuery, entry.ID, entry.Action,
+		nullString(entry.TargetType), nullString(entry.TargetID), entry.Actor, entry.CreatedAt)
+	return err
+}
+
+func (s *PostgresStore) ListAudit(ctx context.Context, before time.Time, limit int) ([]*AuditEntry, error) {
+	where, args := createdBefore(before)
+	rows, err := s.query(ctx, `
+		SELECT `+auditColumns+`
+		FROM audit_log`+where+`
+		ORDER BY created_at DESC, id DESC
+		LIMIT ?
+	`, append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	return scanAuditEntries(rows)
+}
+
+func (s *PostgresStore) ListRecentContent(ctx context.Context, before time.Time, limit int) ([]*Story, []*Comment, error) {
+	where, args := createdBefore(before)
+	args = append(args, limit)
+
+	rows, err := s.query(ctx, `
+		SELECT `+storyColumns+`
+		FROM stories`+where+`
+		ORDER BY created_at DESC, id DESC
+		LIMIT ?
+	`, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	stories, err := scanRows(rows, scanStory)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows, err = s.query(ctx, `
+		SELECT `+commentColumns+`
+		FROM comments`+where+`
+		ORDER BY created_at DESC, id DESC
+		LIMIT ?
+	`, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	comments, err := scanRows(rows, scanComment)
+	if err != nil {
+		return nil, nil, err
+	}
+	return stories, comments, nil
+}
+
+// Stats
+
+func (s *PostgresStore) CountContent(ctx context.Context) (*ContentCounts, error) {
+	var counts ContentCounts
+	err := s.queryRow(ctx, contentCountsQuery("NOT hidden")).Scan(&counts.Stories, &counts.Comments, &counts.Accounts)
+	if err != nil {
+		return nil, err
+	}
+	return &counts, nil
+}
+
+// Accounts
+
+func (s *PostgresStore) CreateAccount(ctx context.Context, account *Account) error {
+	if account.ID == "" {
+		account.ID = uuid.New().String()
+	}
+	if account.CreatedAt.IsZero() {
+		account.CreatedAt = time.Now().UTC()
+	}
+
+	_, err := s.exec(ctx, `
+		INSERT INTO accounts (id, display_name, bio, homepage_url, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, account.ID, account.DisplayName, nullString(account.Bio),
+		nullString(account.HomepageURL), account.CreatedAt)
+
+	return err
+}
+
+func (s *PostgresStore) GetAccount(ctx context.Context, id string) (*Account, error) {
+	row := s.queryRow(ctx, `
+		SELECT id, display_name, bio, homepage_url, created_at
+		FROM accounts WHERE id = ?
+	`, id)
+
+	account, err := scanAccount(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return account, err
+}
+
+// FindAccountsByName returns accounts whose display name contains name,
+// ignoring case, oldest first and at most maxAccountMatches of them
+func (s *PostgresStore) FindAccountsByName(ctx context.Context, name string) ([]*Account, error) {
+	rows, err := s.query(ctx, findAccountsByNameQuery, "%"+likeEscaper.Replace(name)+"%", maxAccountMatches)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var accounts []*Account
+	for rows.Next() {
+		account, err := scanAccount(rows)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, rows.Err()
+}
+
+// PruneInactiveAccounts deletes accounts that have been idle since before
+// (see inactiveAccountsQuery) along with their keys, tokens, and agent links,
+// returning the accounts deleted
+func (s *PostgresStore) PruneInactiveAccounts(ctx context.Context, before time.Time) ([]*Account, error) {
+	tx, err := beginTx(ctx, s.db, s.tx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, rebind(inactiveAccountsQuery), before, before, before)
+	if err != nil {
+		return nil, err
+	}
+	var accounts []*Account
+	for rows.Next() {
+		account, err := scanAccount(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, account := range accounts {
+		for _, query := range deleteAccountQueries {
+			if _, err := tx.ExecContext(ctx, rebind(query), account.ID); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return accounts, tx.Commit()
+}
+
+// AccountsForAgents maps each of agentIDs that has signed in with an
+// account's key to that account. An agent linked to several accounts maps to
+// the oldest.
+func (s *PostgresStore) AccountsForAgents(ctx context.Context, agentIDs []string) (map[string]*Account, error) {
+	byAgent := make(map[string]*Account)
+	if len(agentIDs) == 0 {
+		return byAgent, nil
+	}
+
+	args := make([]any, len(agentIDs))
+	for i, id := range agentIDs {
+		args[i] = id
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(agentIDs)), ",")
+
+	rows, err := s.query(ctx, fmt.Sprintf(accountsForAgentsQuery, placeholders), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var agentID string
+		var account Account
+		if err := rows.Scan(&agentID, &account.ID, &account.DisplayName, &account.CreatedAt); err != nil {
+			return nil, err
+		}
+		if _, ok := byAgent[agentID]; !ok {
+			account.CreatedAt = account.CreatedAt.UTC()
+			byAgent[agentID] = &account
+		}
+	}
+	return byAgent, rows.Err()
+}
+
+func (s *PostgresStore) GetAccountKarma(ctx context.Context, accountID string) (int, error) {
+	var karma int
+	err := s.queryRow(ctx, fmt.Sprintf(accountKarmaQuery, "FALSE"), accountID, accountID).Scan(&karma)
+	return karma, err
+}
+
+// Account Keys
+
+func (s *PostgresStore) CreateAccountKey(ctx context.Context, key *AccountKey) error {
+	if key.ID == "" {
+		key.ID = uuid.New().String()
+	}
+	if key.CreatedAt.IsZero() {
+		key.CreatedAt = time.Now().UTC()
+	}
+
+	_, err := s.exec(ctx, `
+		INSERT INTO account_keys (id, account_id, algorithm, public_key, created_at, revoked_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, key.ID, key.AccountID, key.Algorithm, key.PublicKey, key.CreatedAt, nil)
+
+	return err
+}
+
+func (s *PostgresStore) GetAccountKey(ctx context.Context, id string) (*AccountKey, error) {
+	row := s.queryRow(ctx, `
+		SELECT id, account_id, algorithm, public_key, created_at, revoked_at
+		FROM account_keys WHERE id = ?
+	`, id)
+
+	return scanAccountKey(row)
+}
+
+func (s *PostgresStore) GetAccountKeyByPublicKey(ctx context.Context, alg, publicKey string) (*AccountKey, error) {
+	row := s.queryRow(ctx, `
+		SELECT id, account_id, algorithm, public_key, created_at, revoked_at
+		FROM account_keys WHERE algorithm = ? AND public_key = ? AND revoked_at IS NULL
+	`, alg, publicKey)
+
+	key, err := scanAccountKey(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return key, err
+}
+
+func (s *PostgresStore) ListAccountKeys(ctx context.Context, accountID string) ([]*AccountKey, error) {
+	rows, err := s.query(ctx, `
+		SELECT id, account_id, algorithm, public_key, created_at, revoked_at
+		FROM account_keys WHERE account_id = ?
+		ORDER BY created_at, id
+	`, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []*AccountKey
+	for rows.Next() {
+		key, err := scanAccountKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+func (s *PostgresStore) RevokeAccountKey(ctx context.Context, id string) error {
+	_, err := s.exec(ctx, `UPDATE account_keys SET revoked_at = ? WHERE id = ?`, time.Now().UTC(), id)
+	return err
+}
+
+// Auth
+
+func (s *PostgresStore) CreateChallenge(ctx context.Context, challenge *Challenge) error {
+	if challenge.ID == "" {
+		challenge.ID = uuid.New().String()
+	}
+
+	_, err := s.exec(ctx, `
+		INSERT INTO challenges (id, agent_id, algorithm, challenge, expires_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, challenge.ID, challenge.AgentID, challenge.Algorithm, challenge.Challenge, challenge.ExpiresAt.UTC())
+
+	return err
+}
+
+func (s *PostgresStore) GetChallenge(ctx context.Context, challengeStr string) (*Challenge, error) {
+	row := s.queryRow(ctx, `
+		SELECT id, agent_id, algorithm, challenge, expires_at, failures
+		FROM challenges WHERE challenge = ? AND expires_at > NOW()
+	`, challengeStr)
+
+	var c Challenge
+	err := row.Scan(&c.ID, &c.AgentID, &c.Algorithm, &c.Challenge, &c.ExpiresAt, &c.Failures)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c.ExpiresAt = c.ExpiresAt.UTC()
+	return &c, nil
+}
+
+func (s *PostgresStore) DeleteChallenge(ctx context.Context, id string) error {
+	_, err := s.exec(ctx, `DELETE FROM challenges WHERE id = ?`, id)
+	return err
+}
+
+func (s *PostgresStore) RecordChallengeFailure(ctx context.Context, id string) (int, error) {
+	var failures int
+	err := s.queryRow(ctx, `UPDATE challenges SET failures = failures + 1 WHERE id = ? RETURNING failures`, id).Scan(&failures)
+	return failures, err
+}
+
+func (s *PostgresStore) DeleteExpiredChallenges(ctx context.Context) error {
+	_, err := s.exec(ctx, `DELETE FROM challenges WHERE expires_at < NOW()`)
+	return err
+}
+
+func (s *PostgresStore) CountActiveChallenges(ctx context.Context, agentID string) (int, error) {
+	var count int
+	err := s.queryRow(ctx, `
+		SELECT COUNT(*) FROM challenges WHERE agent_id = ? AND expires_at > NOW()
+	`, agentID).Scan(&count)
+	return count, err
+}
+
+func (s *PostgresStore) CreateToken(ctx context.Context, token *Token) error {
+	if token.ID == "" {
+		token.ID = uuid.New().String()
+	}
+
+	_, err := s.exec(ctx, `
+		INSERT INTO tokens (id, account_id, key_id, agent_id, token, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, token.ID, nullString(token.AccountID), token.KeyID, token.AgentID, token.Token, token.ExpiresAt.UTC())
+	if err != nil || token.AccountID == "" {
+		return err
+	}
+
+	// Remember the agent belongs to the account after the token expires
+	_, err = s.exec(ctx, `
+		INSERT INTO account_agents (account_id, agent_id) VALUES (?, ?)
+		ON CONFLICT DO NOTHING
+	`, token.AccountID, token.AgentID)
+	return err
+}
+
+func (s *PostgresStore) GetToken(ctx context.Context, tokenStr string) (*Token, error) {
+	row := s.queryRow(ctx, `
+		SELECT id, account_id, key_id, agent_id, token, expires_at
+		FROM tokens WHERE token = ? AND expires_at > NOW()
+	`, tokenStr)
+
+	t, err := scanToken(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return t, err
+}
+
+func (s *PostgresStore) DeleteExpiredTokens(ctx context.Context) error {
+	if _, err := s.exec(ctx, `DELETE FROM tokens WHERE expires_at < NOW()`); err != nil {
+		return err
+	}
+	_, err := s.exec(ctx, `DELETE FROM token_revocations WHERE expires_at < NOW()`)
+	return err
+}
+
+func (s *PostgresStore) DeleteTokensForAgent(ctx context.Context, agentID string) (int64, error) {
+	res, err := s.exec(ctx, `DELETE FROM tokens WHERE agent_id = ?`, agentID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *PostgresStore) RevokeAgentTokens(ctx context.Context, agentID string, revokedAt, expiresAt time.Time) error {
+	_, err := s.exec(ctx, `
+		INSERT INTO token_revocations (agent_id, revoked_at, expires_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT(agent_id) DO UPDATE SET revoked_at = excluded.revoked_at, expires_at = excluded.expires_at
+	`, agentID, revokedAt.UTC(), expiresAt.UTC())
+	return err
+}
+
+func (s *PostgresStore) GetAgentRevocation(ctx context.Context, agentID string) (time.Time, error) {
+	var revokedAt time.Time
+	err := s.queryRow(ctx, `
+		SELECT revoked_at FROM token_revocations
+		WHERE agent_id = ? AND expires_at > NOW()
+	`, agentID).Scan(&revokedAt)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	return revokedAt, err
+}
+
+// Idempotency keys
+
+func (s *PostgresStore) GetIdempotencyKey(ctx context.Context, scope, key string) (*IdempotencyKey, error) {
+	row := s.queryRow(ctx, `
+		SELECT `+idempotencyKeyColumns+`
+		FROM idempotency_keys
+		WHERE scope = ? AND idempotency_key = ? AND expires_at > NOW()
+	`, scope, key)
+
+	k, err := scanIdempotencyKey(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return k, err
+}
+
+func (s *PostgresStore) CreateIdempotencyKey(ctx context.Context, k *IdempotencyKey) error {
+	if k.CreatedAt.IsZero() {
+		k.CreatedAt = time.Now().UTC()
+	}
+
+	// An expired key the sweeper hasn't reached yet may be reused
+	_, err := s.exec(ctx, `
+		DELETE FROM idempotency_keys
+		WHERE scope = ? AND idempotency_key = ? AND expires_at <= NOW()
+	`, k.Scope, k.Key)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.exec(ctx, `
+		INSERT INTO idempotency_keys (scope, idempotency_key, request_hash, resource_id, response, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT DO NOTHING
+	`, k.Scope, k.Key, k.RequestHash, nullString(k.ResourceID), k.Response, k.CreatedAt, k.ExpiresAt.UTC())
+	return err
+}
+
+func (s *PostgresStore) DeleteExpiredIdempotencyKeys(ctx context.Context) error {
+	_, err := s.exec(ctx, `DELETE FROM idempotency_keys WHERE expires_at < NOW()`)
+	return err
+}
+
+// Admin tokens
+
+func (s *PostgresStore) CreateAdminToken(ctx context.Context, t *AdminToken) error {
+	if t.ID == "" {
+		t.ID = uuid.New().String()
+	}
+	if t.CreatedAt.IsZero() {
+		t.CreatedAt = time.Now().UTC()
+	}
+
+	_, err := s.exec(ctx, insertAdminTokenQuery,
+		t.ID, nullString(t.Label), t.TokenHash, strings.Join(t.Scopes, " "), t.CreatedAt)
+	return err
+}
+
+func (s *PostgresStore) GetAdminToken(ctx context.Context, tokenHash string) (*AdminToken, error) {
+	row := s.queryRow(ctx, `SELECT `+adminTokenColumns+` FROM admin_tokens WHERE token_hash = ?`, tokenHash)
+
+	t, err := scanAdminToken(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return t, err
+}
+
+func (s *PostgresStore) ListAdminTokens(ctx context.Context) ([]*AdminToken, error) {
+	rows, err := s.query(ctx, `SELECT `+adminTokenColumns+` FROM admin_tokens ORDER BY created_at, id`)
+	if err != nil {
+		return nil, err
+	}
+	return scanAdminTokens(rows)
+}
+
+func (s *PostgresStore) DeleteAdminToken(ctx context.Context, id string) (bool, error) {
+	res, err := s.exec(ctx, `DELETE FROM admin_tokens WHERE id = ?`, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// Ensure PostgresStore implements Store
+var _ Store = (*PostgresStore)(nil)
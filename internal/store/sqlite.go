@@ -2,26 +2,49 @@ package store
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
+// sqliteDriver is go-sqlite3 with the extra SQL functions the store relies on
+const sqliteDriver = "sqlite3_slashclaw"
+
+func init() {
+	sql.Register(sqliteDriver, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			// SQLite has no pow unless built with its math functions; SortTop needs it
+			return conn.RegisterFunc("pow", math.Pow, true)
+		},
+	})
+}
+
 type SQLiteStore struct {
-	db *sql.DB
+	db   *sql.DB
+	conn dbConn  // db, or tx inside WithTx
+	tx   *sql.Tx // set only inside WithTx
 }
 
 func NewSQLiteStore(path string) (*SQLiteStore, error) {
-	db, err := sql.Open("sqlite3", path+"?_foreign_keys=on&_journal_mode=WAL")
+	// Write transactions take the lock up front and wait for it, so concurrent
+	// ones (see CastVote) queue instead of failing with SQLITE_BUSY
+	db, err := sql.Open(sqliteDriver, path+"?_foreign_keys=on&_journal_mode=WAL&_busy_timeout=5000&_txlock=immediate")
 	if err != nil {
 		return nil, err
 	}
 
-	store := &SQLiteStore{db: db}
+	store := &SQLiteStore{db: db, conn: db}
 	if err := store.migrate(); err != nil {
 		db.Close()
 		return nil, err
@@ -43,7 +66,13 @@ func (s *SQLiteStore) migrate() error {
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 		hidden INTEGER DEFAULT 0,
 		agent_id TEXT,
-		agent_verified INTEGER DEFAULT 0
+		agent_verified INTEGER DEFAULT 0,
+		pending INTEGER DEFAULT 0,
+		text_hash TEXT,
+		url_key TEXT,
+		source_url TEXT,
+		upvotes INTEGER DEFAULT 0,
+		downvotes INTEGER DEFAULT 0
 	);
 
 	CREATE INDEX IF NOT EXISTS idx_stories_url ON stories(url) WHERE url IS NOT NULL;
@@ -60,6 +89,10 @@ func (s *SQLiteStore) migrate() error {
 		hidden INTEGER DEFAULT 0,
 		agent_id TEXT,
 		agent_verified INTEGER DEFAULT 0,
+		edited_at DATETIME,
+		depth INTEGER DEFAULT 0,
+		upvotes INTEGER DEFAULT 0,
+		downvotes INTEGER DEFAULT 0,
 		FOREIGN KEY (story_id) REFERENCES stories(id)
 	);
 
@@ -71,7 +104,9 @@ func (s *SQLiteStore) migrate() error {
 		target_type TEXT NOT NULL,
 		target_id TEXT NOT NULL,
 		value INTEGER NOT NULL,
+		weight INTEGER NOT NULL DEFAULT 1,
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME,
 		ip_hash TEXT,
 		agent_id TEXT,
 		agent_verified INTEGER DEFAULT 0,
@@ -107,7 +142,8 @@ func (s *SQLiteStore) migrate() error {
 		agent_id TEXT NOT NULL,
 		algorithm TEXT NOT NULL,
 		challenge TEXT NOT NULL UNIQUE,
-		expires_at DATETIME NOT NULL
+		expires_at DATETIME NOT NULL,
+		failures INTEGER NOT NULL DEFAULT 0
 	);
 
 	CREATE INDEX IF NOT EXISTS idx_challenges_challenge ON challenges(challenge);
@@ -122,9 +158,179 @@ func (s *SQLiteStore) migrate() error {
 	);
 
 	CREATE INDEX IF NOT EXISTS idx_tokens_token ON tokens(token);
+
+	-- JWTs aren't stored, so revoking an agent records a cutoff instead: its
+	-- tokens issued at or before revoked_at are refused until expires_at
+	CREATE TABLE IF NOT EXISTS token_revocations (
+		agent_id TEXT PRIMARY KEY,
+		revoked_at DATETIME NOT NULL,
+		expires_at DATETIME NOT NULL
+	);
+
+	-- Community reports; one per target from each agent and each IP
+	CREATE TABLE IF NOT EXISTS flags (
+		id TEXT PRIMARY KEY,
+		target_type TEXT NOT NULL,
+		target_id TEXT NOT NULL,
+		reason TEXT NOT NULL,
+		agent_id TEXT,
+		ip_hash TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_flags_agent ON flags(target_type, target_id, agent_id);
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_flags_ip ON flags(target_type, target_id, ip_hash);
+	CREATE INDEX IF NOT EXISTS idx_flags_created_at ON flags(created_at);
+
+	-- Agents that have signed in with an account's keys; outlives their tokens
+	CREATE TABLE IF NOT EXISTS account_agents (
+		account_id TEXT NOT NULL,
+		agent_id TEXT NOT NULL,
+		PRIMARY KEY (account_id, agent_id)
+	);
+
+	INSERT OR IGNORE INTO account_agents (account_id, agent_id)
+		SELECT DISTINCT account_id, agent_id FROM tokens WHERE account_id IS NOT NULL;
+
+	-- Stories' tags, normalized, for tag listing and filtering
+	CREATE TABLE IF NOT EXISTS story_tags (
+		story_id TEXT NOT NULL,
+		tag TEXT NOT NULL,
+		PRIMARY KEY (story_id, tag)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_story_tags_tag ON story_tags(tag);
+
+	-- Fill story_tags from stories created before it existed, skipping
+	-- malformed tags the way scanStory does
+	INSERT OR IGNORE INTO story_tags (story_id, tag)
+		SELECT stories.id, lower(trim(tags.value))
+		FROM stories, json_each(CASE
+			WHEN NOT json_valid(stories.tags) THEN '[]'
+			WHEN json_type(stories.tags) = 'array' THEN stories.tags
+			ELSE '[]'
+		END) AS tags
+		WHERE tags.type = 'text' AND trim(tags.value) != ''
+			AND NOT EXISTS (SELECT 1 FROM story_tags);
+
+	-- Responses remembered for retried requests sent with an Idempotency-Key
+	CREATE TABLE IF NOT EXISTS idempotency_keys (
+		scope TEXT NOT NULL,
+		idempotency_key TEXT NOT NULL,
+		request_hash TEXT NOT NULL,
+		resource_id TEXT,
+		response TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		expires_at DATETIME NOT NULL,
+		PRIMARY KEY (scope, idempotency_key)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires_at ON idempotency_keys(expires_at);
+
+	-- Scoped admin bearer tokens; only a SHA-256 of each token is kept
+	CREATE TABLE IF NOT EXISTS admin_tokens (
+		id TEXT PRIMARY KEY,
+		label TEXT,
+		token_hash TEXT NOT NULL UNIQUE,
+		scopes TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	-- Actions taken by admins, read newest first by the activity feed
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id TEXT PRIMARY KEY,
+		action TEXT NOT NULL,
+		target_type TEXT,
+		target_id TEXT,
+		actor TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_audit_log_created_at ON audit_log(created_at);
 	`
 
-	_, err := s.db.Exec(schema)
+	if _, err := s.db.Exec(schema); err != nil {
+		return err
+	}
+
+	// Columns added after the initial schema; CREATE TABLE IF NOT EXISTS
+	// won't add them to databases created by older versions
+	if err := s.addColumnIfMissing("comments", "edited_at", "DATETIME"); err != nil {
+		return err
+	}
+	if err := s.addColumnIfMissing("stories", "pending", "INTEGER DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := s.addColumnIfMissing("comments", "depth", "INTEGER DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := s.addColumnIfMissing("stories", "text_hash", "TEXT"); err != nil {
+		return err
+	}
+	if _, err := s.db.Exec(`CREATE INDEX IF NOT EXISTS idx_stories_text_hash ON stories(text_hash) WHERE text_hash IS NOT NULL`); err != nil {
+		return err
+	}
+	if err := s.addColumnIfMissing("stories", "url_key", "TEXT"); err != nil {
+		return err
+	}
+	if _, err := s.db.Exec(`CREATE INDEX IF NOT EXISTS idx_stories_url_key ON stories(url_key) WHERE url_key IS NOT NULL`); err != nil {
+		return err
+	}
+	if err := s.addColumnIfMissing("stories", "source_url", "TEXT"); err != nil {
+		return err
+	}
+	if _, err := s.db.Exec(`CREATE INDEX IF NOT EXISTS idx_stories_source_url ON stories(source_url) WHERE source_url IS NOT NULL`); err != nil {
+		return err
+	}
+	if err := s.addColumnIfMissing("votes", "weight", "INTEGER NOT NULL DEFAULT 1"); err != nil {
+		return err
+	}
+	if err := s.addColumnIfMissing("votes", "updated_at", "DATETIME"); err != nil {
+		return err
+	}
+	if err := s.addColumnIfMissing("challenges", "failures", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	for _, table := range []string{"stories", "comments"} {
+		for _, column := range []string{"upvotes", "downvotes"} {
+			if err := s.addColumnIfMissing(table, column, "INTEGER DEFAULT 0"); err != nil {
+				return err
+			}
+		}
+	}
+	if err := backfillCommentDepth(s.db); err != nil {
+		return err
+	}
+	if err := backfillURLKeys(s.db, updateURLKeyQuery); err != nil {
+		return err
+	}
+	return backfillVoteCounts(s.db)
+}
+
+// addColumnIfMissing adds a column to an existing table unless it is already present
+func (s *SQLiteStore) addColumnIfMissing(table, column, definition string) error {
+	rows, err := s.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dflt, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
 	return err
 }
 
@@ -132,8 +338,40 @@ func (s *SQLiteStore) Close() error {
 	return s.db.Close()
 }
 
+// Ping reports whether the database can be reached
+func (s *SQLiteStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// CheckSchema reports an error if a table migrate creates is missing
+func (s *SQLiteStore) CheckSchema(ctx context.Context) error {
+	return checkSchema(ctx, s.conn)
+}
+
+// WithTx runs fn against a store bound to one transaction, committing if fn
+// returns nil and rolling back every write fn made otherwise. Inside fn, use
+// only the store it is given; WithTx on that store joins the same transaction.
+func (s *SQLiteStore) WithTx(ctx context.Context, fn func(tx Store) error) error {
+	if s.tx != nil {
+		return fn(s)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(&SQLiteStore{db: s.db, conn: tx, tx: tx}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
 // Stories
 
+const storyColumns = "id, title, url, text, tags, score, upvotes, downvotes, comment_count, created_at, hidden, agent_id, agent_verified, pending, source_url"
+
 func (s *SQLiteStore) CreateStory(ctx context.Context, story *Story) error {
 	if story.ID == "" {
 		story.ID = uuid.New().String()
@@ -142,21 +380,37 @@ func (s *SQLiteStore) CreateStory(ctx context.Context, story *Story) error {
 		story.CreatedAt = time.Now().UTC()
 	}
 
-	tagsJSON, _ := json.Marshal(story.Tags)
+	tagsJSON, err := marshalTags(story.Tags)
+	if err != nil {
+		return err
+	}
+
+	tx, err := beginTx(ctx, s.db, s.tx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	_, err := s.db.ExecContext(ctx, `
-		INSERT INTO stories (id, title, url, text, tags, score, comment_count, created_at, hidden, agent_id, agent_verified)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`, story.ID, story.Title, nullString(story.URL), nullString(story.Text), string(tagsJSON),
+	_, err = tx.ExecContext(ctx, insertStoryQuery, story.ID, story.Title, nullString(story.URL), nullString(story.Text), tagsJSON,
 		story.Score, story.CommentCount, story.CreatedAt, boolToInt(story.Hidden),
-		nullString(story.AgentID), boolToInt(story.AgentVerified))
+		nullString(story.AgentID), boolToInt(story.AgentVerified), boolToInt(story.Pending),
+		nullString(textHash(story.Text)), nullString(urlKey(story.URL)), nullString(story.SourceURL))
+	if err != nil {
+		return err
+	}
+	exec := func(query string, args ...any) (sql.Result, error) {
+		return tx.ExecContext(ctx, query, args...)
+	}
+	if err := insertStoryTags(story.ID, story.Tags, exec); err != nil {
+		return err
+	}
 
-	return err
+	return tx.Commit()
 }
 
 func (s *SQLiteStore) GetStory(ctx context.Context, id string) (*Story, error) {
-	row := s.db.QueryRowContext(ctx, `
-		SELECT id, title, url, text, tags, score, comment_count, created_at, hidden, agent_id, agent_verified
+	row := s.conn.QueryRowContext(ctx, `
+		SELECT `+storyColumns+`
 		FROM stories WHERE id = ? AND hidden = 0
 	`, id)
 
@@ -168,31 +422,65 @@ func (s *SQLiteStore) GetStory(ctx context.Context, id string) (*Story, error) {
 }
 
 func (s *SQLiteStore) ListStories(ctx context.Context, opts ListOptions) ([]*Story, string, error) {
+	return s.listStories(ctx, opts, "")
+}
+
+func (s *SQLiteStore) ListStoriesByAgent(ctx context.Context, agentID string, opts ListOptions) ([]*Story, string, error) {
+	return s.listStories(ctx, opts, "agent_id = ?", agentID)
+}
+
+// listStories lists visible stories, optionally narrowed by an extra WHERE
+// condition whose placeholders are bound to args
+func (s *SQLiteStore) listStories(ctx context.Context, opts ListOptions, filter string, args ...any) ([]*Story, string, error) {
 	if opts.Limit <= 0 || opts.Limit > 100 {
 		opts.Limit = 30
 	}
 
-	var orderBy string
+	// One extra row tells us whether there is a next page
+	limit := opts.Limit + 1
+
+	filter, args = tagFilter(opts.Tag, filter, args)
+	filter = typeFilter(opts.Type, filter)
+
+	// keys are what a listing orders by, all descending, so the page after a
+	// cursor is the rows whose keys compare below the cursor story's
+	keys, keyArgs := []string{"created_at", "id"}, []any(nil)
 	switch opts.Sort {
-	case SortNew:
-		orderBy = "created_at DESC"
+	case SortDiscover:
+		// Sampled from the newest stories below
+		limit = discoverPoolSize
 	case SortDiscussed:
-		orderBy = "comment_count DESC, created_at DESC"
+		keys = []string{"comment_count", "created_at", "id"}
+	case SortNew:
 	default: // SortTop
-		// Time-decay ranking: score / (hours + 2)^1.5
-		// Simplified: using (hours + 2) * sqrt(hours + 2) as approximation for (hours + 2)^1.5
-		// Or just use score - hours for MVP simplicity
-		orderBy = "score - (CAST((julianday('now') - julianday(created_at)) * 24 AS REAL)) DESC"
+		// Time-decay ranking: score / (hours + offset)^gravity. The time is
+		// bound once so the cursor story and the rows compared with it are
+		// ranked at the same instant.
+		gravity, offset := opts.rankParams()
+		score, scoreArgs := opts.rankScore("MAX")
+		keys = []string{score + " / pow((julianday(?) - julianday(created_at)) * 24 + ?, ?)", "created_at", "id"}
+		keyArgs = append(scoreArgs, time.Now().UTC(), offset, gravity)
+	}
+
+	var where string
+	if filter != "" {
+		where = " AND " + filter
+	}
+	if opts.Cursor != "" && opts.Sort != SortDiscover {
+		tuple := strings.Join(keys, ", ")
+		where += " AND (" + tuple + ") < (SELECT " + tuple + " FROM stories WHERE id = ?)"
+		args = append(append(append(args, keyArgs...), keyArgs...), opts.Cursor)
 	}
+	args = append(args, keyArgs...)
 
 	query := fmt.Sprintf(`
-		SELECT id, title, url, text, tags, score, comment_count, created_at, hidden, agent_id, agent_verified
-		FROM stories WHERE hidden = 0
-		ORDER BY %s
+		SELECT `+storyColumns+`
+		FROM stories WHERE hidden = 0%s
+		ORDER BY %s DESC
 		LIMIT ?
-	`, orderBy)
+	`, where, strings.Join(keys, " DESC, "))
 
-	rows, err := s.db.QueryContext(ctx, query, opts.Limit+1)
+	rows, err := s.conn.QueryContext(ctx, query, append(args, limit)...)
 	if err != nil {
 		return nil, "", err
 	}
@@ -200,13 +488,18 @@ func (s *SQLiteStore) ListStories(ctx context.Context, opts ListOptions) ([]*Sto
 
 	var stories []*Story
 	for rows.Next() {
-		story, err := scanStoryRows(rows)
+		story, err := scanStory(rows)
 		if err != nil {
 			return nil, "", err
 		}
 		stories = append(stories, story)
 	}
 
+	// A sample is complete in itself, so it has no next page
+	if opts.Sort == SortDiscover {
+		return sampleStories(stories, opts.Limit, opts.Seed), "", nil
+	}
+
 	var nextCursor string
 	if len(stories) > opts.Limit {
 		stories = stories[:opts.Limit]
@@ -216,12 +509,72 @@ func (s *SQLiteStore) ListStories(ctx context.Context, opts ListOptions) ([]*Sto
 	return stories, nextCursor, nil
 }
 
+func (s *SQLiteStore) CountStories(ctx context.Context, opts ListOptions) (int, error) {
+	return s.countStories(ctx, opts, "")
+}
+
+func (s *SQLiteStore) CountStoriesByAgent(ctx context.Context, agentID string, opts ListOptions) (int, error) {
+	return s.countStories(ctx, opts, "agent_id = ?", agentID)
+}
+
+// countStories counts what listStories lists with the same filter
+func (s *SQLiteStore) countStories(ctx context.Context, opts ListOptions, filter string, args ...any) (int, error) {
+	filter, args = tagFilter(opts.Tag, filter, args)
+	filter = typeFilter(opts.Type, filter)
+	var where string
+	if filter != "" {
+		where = " AND " + filter
+	}
+
+	var count int
+	err := s.conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM stories WHERE hidden = 0"+where, args...).Scan(&count)
+	return count, err
+}
+
+func (s *SQLiteStore) ListStoriesModified(ctx context.Context, offset, limit int) ([]*StoryModified, error) {
+	rows, err := s.conn.QueryContext(ctx, `
+		SELECT stories.id, stories.created_at, comments.created_at
+		FROM stories LEFT JOIN comments ON comments.id = (
+			SELECT c.id FROM comments c
+			WHERE c.story_id = stories.id AND c.hidden = 0
+			ORDER BY c.created_at DESC, c.id DESC
+			LIMIT 1
+		)
+		WHERE stories.hidden = 0
+		ORDER BY stories.created_at, stories.id
+		LIMIT ? OFFSET ?
+	`, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return scanRows(rows, scanStoryModified)
+}
+
 func (s *SQLiteStore) FindStoryByURL(ctx context.Context, url string, since time.Time) (*Story, error) {
-	row := s.db.QueryRowContext(ctx, `
-		SELECT id, title, url, text, tags, score, comment_count, created_at, hidden, agent_id, agent_verified
-		FROM stories WHERE url = ? AND created_at > ? AND hidden = 0
+	row := s.conn.QueryRowContext(ctx, `
+		SELECT `+storyColumns+`
+		FROM stories WHERE url_key = ? AND created_at > ? AND hidden = 0
+		ORDER BY created_at DESC LIMIT 1
+	`, urlKey(url), since)
+
+	story, err := scanStory(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return story, err
+}
+
+func (s *SQLiteStore) FindStoryByText(ctx context.Context, text string, since time.Time) (*Story, error) {
+	hash := textHash(text)
+	if hash == "" {
+		return nil, nil
+	}
+
+	row := s.conn.QueryRowContext(ctx, `
+		SELECT `+storyColumns+`
+		FROM stories WHERE text_hash = ? AND created_at > ? AND hidden = 0
 		ORDER BY created_at DESC LIMIT 1
-	`, url, since)
+	`, hash, since)
 
 	story, err := scanStory(row)
 	if err == sql.ErrNoRows {
@@ -231,8 +584,8 @@ func (s *SQLiteStore) FindStoryByURL(ctx context.Context, url string, since time
 }
 
 func (s *SQLiteStore) GetLastStoryByAgent(ctx context.Context, agentID string) (*Story, error) {
-	row := s.db.QueryRowContext(ctx, `
-		SELECT id, title, url, text, tags, score, comment_count, created_at, hidden, agent_id, agent_verified
+	row := s.conn.QueryRowContext(ctx, `
+		SELECT `+storyColumns+`
 		FROM stories WHERE agent_id = ?
 		ORDER BY created_at DESC LIMIT 1
 	`, agentID)
@@ -244,357 +597,1923 @@ func (s *SQLiteStore) GetLastStoryByAgent(ctx context.Context, agentID string) (
 	return story, err
 }
 
-func (s *SQLiteStore) UpdateStoryScore(ctx context.Context, id string, delta int) error {
-	_, err := s.db.ExecContext(ctx, `UPDATE stories SET score = score + ? WHERE id = ?`, delta, id)
-	return err
-}
+// CreateStories stores a batch of stories in one transaction. A story that
+// reposts a recent URL or text body, or whose tags are too large, is
+// reported in its result and skipped rather than failing the batch.
+func (s *SQLiteStore) CreateStories(ctx context.Context, stories []*Story, urlSince, textSince time.Time) ([]StoryBatchResult, error) {
+	tx, err := beginTx(ctx, s.db, s.tx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
 
-func (s *SQLiteStore) UpdateStoryCommentCount(ctx context.Context, id string, delta int) error {
-	_, err := s.db.ExecContext(ctx, `UPDATE stories SET comment_count = comment_count + ? WHERE id = ?`, delta, id)
-	return err
-}
+	queryRow := func(query string, args ...any) *sql.Row {
+		return tx.QueryRowContext(ctx, query, args...)
+	}
+	exec := func(query string, args ...any) (sql.Result, error) {
+		return tx.ExecContext(ctx, query, args...)
+	}
 
-func (s *SQLiteStore) HideStory(ctx context.Context, id string) error {
-	_, err := s.db.ExecContext(ctx, `UPDATE stories SET hidden = 1 WHERE id = ?`, id)
-	return err
-}
+	results := make([]StoryBatchResult, len(stories))
+	for i, story := range stories {
+		existing, err := findRepost(story, urlSince, textSince, "hidden = 0", queryRow)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil {
+			results[i].Existing = existing
+			continue
+		}
 
-// Comments
+		if story.ID == "" {
+			story.ID = uuid.New().String()
+		}
+		if story.CreatedAt.IsZero() {
+			story.CreatedAt = time.Now().UTC()
+		}
+		tagsJSON, err := marshalTags(story.Tags)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
 
-func (s *SQLiteStore) CreateComment(ctx context.Context, comment *Comment) error {
+		_, err = tx.ExecContext(ctx, insertStoryQuery, story.ID, story.Title, nullString(story.URL), nullString(story.Text), tagsJSON,
+			story.Score, story.CommentCount, story.CreatedAt, boolToInt(story.Hidden),
+			nullString(story.AgentID), boolToInt(story.AgentVerified), boolToInt(story.Pending),
+			nullString(textHash(story.Text)), nullString(urlKey(story.URL)), nullString(story.SourceURL))
+		if err != nil {
+			return nil, err
+		}
+		if err := insertStoryTags(story.ID, story.Tags, exec); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// CreateStoryWithComment stores a story and its author's opening comment in
+// one transaction, so neither is stored without the other
+func (s *SQLiteStore) CreateStoryWithComment(ctx context.Context, story *Story, comment *Comment) error {
+	if story.ID == "" {
+		story.ID = uuid.New().String()
+	}
+	if story.CreatedAt.IsZero() {
+		story.CreatedAt = time.Now().UTC()
+	}
 	if comment.ID == "" {
 		comment.ID = uuid.New().String()
 	}
 	if comment.CreatedAt.IsZero() {
-		comment.CreatedAt = time.Now().UTC()
+		comment.CreatedAt = story.CreatedAt
 	}
+	comment.StoryID, comment.ParentID, comment.Depth = story.ID, "", 0
+	story.CommentCount++
 
-	_, err := s.db.ExecContext(ctx, `
-		INSERT INTO comments (id, story_id, parent_id, text, score, created_at, hidden, agent_id, agent_verified)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`, comment.ID, comment.StoryID, nullString(comment.ParentID), comment.Text,
+	tagsJSON, err := marshalTags(story.Tags)
+	if err != nil {
+		return err
+	}
+
+	tx, err := beginTx(ctx, s.db, s.tx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	exec := func(query string, args ...any) (sql.Result, error) {
+		return tx.ExecContext(ctx, query, args...)
+	}
+	_, err = exec(insertStoryQuery, story.ID, story.Title, nullString(story.URL), nullString(story.Text), tagsJSON,
+		story.Score, story.CommentCount, story.CreatedAt, boolToInt(story.Hidden),
+		nullString(story.AgentID), boolToInt(story.AgentVerified), boolToInt(story.Pending),
+		nullString(textHash(story.Text)), nullString(urlKey(story.URL)), nullString(story.SourceURL))
+	if err != nil {
+		return err
+	}
+	if err := insertStoryTags(story.ID, story.Tags, exec); err != nil {
+		return err
+	}
+	_, err = exec(insertCommentQuery, comment.ID, comment.StoryID, nil, comment.Text,
 		comment.Score, comment.CreatedAt, boolToInt(comment.Hidden),
-		nullString(comment.AgentID), boolToInt(comment.AgentVerified))
+		nullString(comment.AgentID), boolToInt(comment.AgentVerified), comment.Depth)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
 
+func (s *SQLiteStore) UpdateStoryScore(ctx context.Context, id string, delta int) error {
+	_, err := s.conn.ExecContext(ctx, `UPDATE stories SET score = score + ? WHERE id = ?`, delta, id)
 	return err
 }
 
-func (s *SQLiteStore) GetComment(ctx context.Context, id string) (*Comment, error) {
-	row := s.db.QueryRowContext(ctx, `
-		SELECT id, story_id, parent_id, text, score, created_at, hidden, agent_id, agent_verified
-		FROM comments WHERE id = ? AND hidden = 0
+func (s *SQLiteStore) UpdateStoryCommentCount(ctx context.Context, id string, delta int) error {
+	_, err := s.conn.ExecContext(ctx, `UPDATE stories SET comment_count = comment_count + ? WHERE id = ?`, delta, id)
+	return err
+}
+
+// GetPendingStory returns a story awaiting approval, or nil if there is no
+// such pending story
+func (s *SQLiteStore) GetPendingStory(ctx context.Context, id string) (*Story, error) {
+	row := s.conn.QueryRowContext(ctx, `
+		SELECT `+storyColumns+`
+		FROM stories WHERE id = ? AND pending = 1
 	`, id)
 
-	comment, err := scanComment(row)
+	story, err := scanStory(row)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
-	return comment, err
+	return story, err
 }
 
-func (s *SQLiteStore) ListComments(ctx context.Context, storyID string, opts CommentListOptions) ([]*Comment, error) {
-	var orderBy string
-	switch opts.Sort {
-	case SortNew:
-		orderBy = "created_at DESC"
-	default:
-		orderBy = "score DESC, created_at ASC"
+// ListPendingStories returns the review queue, oldest first. A non-empty
+// agentID narrows it to that agent's submissions.
+func (s *SQLiteStore) ListPendingStories(ctx context.Context, agentID string, opts ListOptions) ([]*Story, string, error) {
+	if opts.Limit <= 0 || opts.Limit > 100 {
+		opts.Limit = 30
 	}
 
-	query := fmt.Sprintf(`
-		SELECT id, story_id, parent_id, text, score, created_at, hidden, agent_id, agent_verified
-		FROM comments WHERE story_id = ? AND hidden = 0
-		ORDER BY %s
-	`, orderBy)
+	where := "pending = 1"
+	var args []any
+	if agentID != "" {
+		where += " AND agent_id = ?"
+		args = append(args, agentID)
+	}
+	if opts.Cursor != "" {
+		where += " AND (created_at, id) > (SELECT created_at, id FROM stories WHERE id = ?)"
+		args = append(args, opts.Cursor)
+	}
+	args = append(args, opts.Limit+1)
 
-	rows, err := s.db.QueryContext(ctx, query, storyID)
+	rows, err := s.conn.QueryContext(ctx, `
+		SELECT `+storyColumns+`
+		FROM stories WHERE `+where+`
+		ORDER BY created_at ASC, id ASC
+		LIMIT ?
+	`, args...)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer rows.Close()
 
-	var comments []*Comment
+	var stories []*Story
 	for rows.Next() {
-		comment, err := scanCommentRows(rows)
+		story, err := scanStory(rows)
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
-		comments = append(comments, comment)
+		stories = append(stories, story)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
 	}
 
-	if opts.View == ViewTree {
-		return buildCommentTree(comments), nil
+	var nextCursor string
+	if len(stories) > opts.Limit {
+		stories = stories[:opts.Limit]
+		nextCursor = stories[len(stories)-1].ID
 	}
 
-	return comments, nil
+	return stories, nextCursor, nil
 }
 
-func buildCommentTree(comments []*Comment) []*Comment {
-	byID := make(map[string]*Comment)
-	for _, c := range comments {
-		byID[c.ID] = c
+// ListOwnStories lists every story by agentID or, when accountID is set, by
+// any agent signed in to the account, hidden and pending ones included,
+// newest first
+func (s *SQLiteStore) ListOwnStories(ctx context.Context, agentID, accountID string, opts ListOptions) ([]*Story, string, error) {
+	if opts.Limit <= 0 || opts.Limit > 100 {
+		opts.Limit = 30
 	}
 
-	var roots []*Comment
-	for _, c := range comments {
-		if c.ParentID == "" {
-			roots = append(roots, c)
-		} else if parent, ok := byID[c.ParentID]; ok {
-			parent.Children = append(parent.Children, c)
-		}
+	where, args := ownerFilter(agentID, accountID)
+	if opts.Cursor != "" {
+		where += " AND (created_at, id) < (SELECT created_at, id FROM stories WHERE id = ?)"
+		args = append(args, opts.Cursor)
 	}
+	args = append(args, opts.Limit+1)
 
-	return roots
-}
+	rows, err := s.conn.QueryContext(ctx, `
+		SELECT `+storyColumns+`
+		FROM stories WHERE `+where+`
+		ORDER BY created_at DESC, id DESC
+		LIMIT ?
+	`, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
 
-func (s *SQLiteStore) UpdateCommentScore(ctx context.Context, id string, delta int) error {
-	_, err := s.db.ExecContext(ctx, `UPDATE comments SET score = score + ? WHERE id = ?`, delta, id)
-	return err
+	var stories []*Story
+	for rows.Next() {
+		story, err := scanStory(rows)
+		if err != nil {
+			return nil, "", err
+		}
+		stories = append(stories, story)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	var nextCursor string
+	if len(stories) > opts.Limit {
+		stories = stories[:opts.Limit]
+		nextCursor = stories[len(stories)-1].ID
+	}
+	return stories, nextCursor, nil
 }
 
-func (s *SQLiteStore) HideComment(ctx context.Context, id string) error {
-	_, err := s.db.ExecContext(ctx, `UPDATE comments SET hidden = 1 WHERE id = ?`, id)
+// ListOwnComments is ListOwnStories for comments
+func (s *SQLiteStore) ListOwnComments(ctx context.Context, agentID, accountID string, opts ListOptions) ([]*Comment, string, error) {
+	if opts.Limit <= 0 || opts.Limit > 100 {
+		opts.Limit = 30
+	}
+
+	where, args := ownerFilter(agentID, accountID)
+	if opts.Cursor != "" {
+		where += " AND (created_at, id) < (SELECT created_at, id FROM comments WHERE id = ?)"
+		args = append(args, opts.Cursor)
+	}
+	args = append(args, opts.Limit+1)
+
+	comments, err := s.queryComments(ctx, `
+		SELECT `+commentColumns+`
+		FROM comments WHERE `+where+`
+		ORDER BY created_at DESC, id DESC
+		LIMIT ?
+	`, args...)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var nextCursor string
+	if len(comments) > opts.Limit {
+		comments = comments[:opts.Limit]
+		nextCursor = comments[len(comments)-1].ID
+	}
+	return comments, nextCursor, nil
+}
+
+// ApproveStory publishes a pending story. It reports false if the story was
+// not pending.
+func (s *SQLiteStore) ApproveStory(ctx context.Context, id string) (bool, error) {
+	res, err := s.conn.ExecContext(ctx, `UPDATE stories SET hidden = 0, pending = 0 WHERE id = ? AND pending = 1`, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *SQLiteStore) ListTags(ctx context.Context) ([]TagCount, error) {
+	rows, err := s.conn.QueryContext(ctx, listTagsQuery("hidden = 0"))
+	if err != nil {
+		return nil, err
+	}
+	return scanTagCounts(rows)
+}
+
+func (s *SQLiteStore) HideStory(ctx context.Context, id string) error {
+	_, err := s.conn.ExecContext(ctx, `UPDATE stories SET hidden = 1, pending = 0 WHERE id = ?`, id)
+	return err
+}
+
+// UnhideStory restores a story hidden by a moderator, flags, or its author. A
+// pending story is left for ApproveStory.
+func (s *SQLiteStore) UnhideStory(ctx context.Context, id string) error {
+	_, err := s.conn.ExecContext(ctx, `UPDATE stories SET hidden = 0 WHERE id = ? AND pending = 0`, id)
+	return err
+}
+
+func (s *SQLiteStore) GetHiddenStory(ctx context.Context, id string) (*Story, error) {
+	row := s.conn.QueryRowContext(ctx, `
+		SELECT `+storyColumns+`
+		FROM stories WHERE id = ? AND hidden = 1 AND pending = 0
+	`, id)
+
+	story, err := scanStory(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return story, err
+}
+
+// Comments
+
+func (s *SQLiteStore) CreateComment(ctx context.Context, comment *Comment) error {
+	if comment.ID == "" {
+		comment.ID = uuid.New().String()
+	}
+	if comment.CreatedAt.IsZero() {
+		comment.CreatedAt = time.Now().UTC()
+	}
+
+	if comment.ParentID != "" {
+		var parentDepth int
+		err := s.conn.QueryRowContext(ctx, `SELECT depth FROM comments WHERE id = ?`, comment.ParentID).Scan(&parentDepth)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		comment.Depth = parentDepth + 1
+	}
+
+	_, err := s.conn.ExecContext(ctx, insertCommentQuery, comment.ID, comment.StoryID, nullString(comment.ParentID), comment.Text,
+		comment.Score, comment.CreatedAt, boolToInt(comment.Hidden),
+		nullString(comment.AgentID), boolToInt(comment.AgentVerified), comment.Depth)
+
+	return err
+}
+
+func (s *SQLiteStore) GetComment(ctx context.Context, id string) (*Comment, error) {
+	row := s.conn.QueryRowContext(ctx, `
+		SELECT `+commentColumns+`
+		FROM comments WHERE id = ? AND hidden = 0
+	`, id)
+
+	comment, err := scanComment(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return comment, err
+}
+
+func (s *SQLiteStore) CountComments(ctx context.Context, storyID string) (int, error) {
+	var count int
+	err := s.conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM comments WHERE story_id = ? AND hidden = 0", storyID).Scan(&count)
+	return count, err
+}
+
+// commentSort describes how a comment ordering is expressed in SQL. after is a
+// keyset condition selecting rows that sort after the comment whose id is
+// bound to its single placeholder.
+type commentSort struct {
+	orderBy string
+	after   string
+}
+
+func commentSortFor(sort SortOrder) commentSort {
+	switch sort {
+	case SortNew:
+		return commentSort{
+			orderBy: "created_at DESC, id DESC",
+			after:   "(created_at, id) < (SELECT created_at, id FROM comments WHERE id = ?)",
+		}
+	case SortOld:
+		return commentSort{
+			orderBy: "created_at ASC, id ASC",
+			after:   "(created_at, id) > (SELECT created_at, id FROM comments WHERE id = ?)",
+		}
+	case SortControversial:
+		return commentSort{
+			orderBy: controversy + " DESC, " + commentVotes + " DESC, created_at ASC, id ASC",
+			after: "(-" + controversy + ", -" + commentVotes + ", created_at, id) > " +
+				"(SELECT -" + controversy + ", -" + commentVotes + ", created_at, id FROM comments WHERE id = ?)",
+		}
+	default:
+		return commentSort{
+			orderBy: "score DESC, created_at ASC, id ASC",
+			after:   "(-score, created_at, id) > (SELECT -score, created_at, id FROM comments WHERE id = ?)",
+		}
+	}
+}
+
+// controversy ranks a comment by the smaller side of its vote: it is high
+// only when a comment drew many votes that are close to evenly split, and 0
+// for one nobody voted against. Ties go to the busier comment, by commentVotes.
+const (
+	controversy  = "(CASE WHEN upvotes < downvotes THEN upvotes ELSE downvotes END)"
+	commentVotes = "(upvotes + downvotes)"
+)
+
+const commentColumns = "id, story_id, parent_id, text, score, upvotes, downvotes, created_at, hidden, agent_id, agent_verified, edited_at, depth"
+
+// ListComments returns a story's comments. With a positive Limit the result is
+// paginated: the flat view pages through individual comments, while the tree
+// view pages through top-level comments, each returned with its full subtree.
+// An unpaginated tree view capped by MaxComments pages the same way, taking
+// as many whole threads as fit under the cap.
+func (s *SQLiteStore) ListComments(ctx context.Context, storyID string, opts CommentListOptions) ([]*Comment, string, error) {
+	sort := commentSortFor(opts.Sort)
+
+	if opts.View == ViewTree && (opts.Limit > 0 || opts.MaxComments > 0) {
+		return s.listCommentTreePage(ctx, storyID, "", sort, opts)
+	}
+
+	where := "story_id = ? AND hidden = 0"
+	args := []any{storyID}
+	limit := ""
+	if opts.Limit > 0 {
+		if opts.Cursor != "" {
+			where += " AND " + sort.after
+			args = append(args, opts.Cursor)
+		}
+		limit = "LIMIT ?"
+		args = append(args, opts.Limit+1)
+	} else if opts.MaxComments > 0 {
+		limit = "LIMIT ?"
+		args = append(args, opts.MaxComments)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM comments WHERE %s
+		ORDER BY %s
+		%s
+	`, commentColumns, where, sort.orderBy, limit)
+
+	comments, err := s.queryComments(ctx, query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var nextCursor string
+	if opts.Limit > 0 && len(comments) > opts.Limit {
+		comments = comments[:opts.Limit]
+		nextCursor = comments[len(comments)-1].ID
+	}
+
+	if opts.View == ViewTree {
+		return buildCommentTree(comments, "", opts.MaxDepth), nextCursor, nil
+	}
+
+	return comments, nextCursor, nil
+}
+
+// listCommentTreePage loads one page of top-level comments (or, when parentID
+// is set, of parentID's direct replies) along with every reply beneath them
+// and assembles them into a tree
+func (s *SQLiteStore) listCommentTreePage(ctx context.Context, storyID, parentID string, sort commentSort, opts CommentListOptions) ([]*Comment, string, error) {
+	where := "story_id = ? AND parent_id IS NULL AND hidden = 0"
+	args := []any{storyID}
+	if parentID != "" {
+		where = "parent_id = ? AND hidden = 0"
+		args = []any{parentID}
+	}
+	if opts.Cursor != "" {
+		where += " AND " + sort.after
+		args = append(args, opts.Cursor)
+	}
+	limit := ""
+	if opts.Limit > 0 {
+		limit = "LIMIT ?"
+		args = append(args, opts.Limit+1)
+	}
+
+	rows, err := s.conn.QueryContext(ctx, fmt.Sprintf(`
+		SELECT id FROM comments WHERE %s
+		ORDER BY %s
+		%s
+	`, where, sort.orderBy, limit), args...)
+	if err != nil {
+		return nil, "", err
+	}
+	var rootIDs []any
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, "", err
+		}
+		rootIDs = append(rootIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	var nextCursor string
+	if opts.Limit > 0 && len(rootIDs) > opts.Limit {
+		rootIDs = rootIDs[:opts.Limit]
+		nextCursor = rootIDs[len(rootIDs)-1].(string)
+	}
+	if len(rootIDs) == 0 {
+		return nil, "", nil
+	}
+
+	if opts.Limit == 0 && opts.MaxComments > 0 {
+		rows, err := s.conn.QueryContext(ctx, fmt.Sprintf(`
+			WITH RECURSIVE thread(root, id) AS (
+				SELECT id, id FROM comments WHERE %s
+				UNION ALL
+				SELECT t.root, c.id FROM comments c JOIN thread t ON c.parent_id = t.id
+			)
+			SELECT t.root, COUNT(*) FROM thread t JOIN comments c ON c.id = t.id
+			WHERE c.hidden = 0
+			GROUP BY t.root
+		`, where), args...)
+		if err != nil {
+			return nil, "", err
+		}
+		sizes := make(map[string]int)
+		for rows.Next() {
+			var root string
+			var size int
+			if err := rows.Scan(&root, &size); err != nil {
+				rows.Close()
+				return nil, "", err
+			}
+			sizes[root] = size
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, "", err
+		}
+		rootIDs, nextCursor = takeWholeThreads(rootIDs, sizes, opts.MaxComments)
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(rootIDs)), ",")
+	query := fmt.Sprintf(`
+		WITH RECURSIVE thread(id) AS (
+			SELECT id FROM comments WHERE id IN (%s)
+			UNION ALL
+			SELECT c.id FROM comments c JOIN thread t ON c.parent_id = t.id
+		)
+		SELECT %s
+		FROM comments WHERE id IN (SELECT id FROM thread) AND hidden = 0
+		ORDER BY %s
+	`, placeholders, commentColumns, sort.orderBy)
+
+	comments, err := s.queryComments(ctx, query, rootIDs...)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return buildCommentTree(comments, parentID, opts.MaxDepth), nextCursor, nil
+}
+
+// takeWholeThreads keeps the leading roots whose threads, of the given sizes,
+// fit in max comments between them, returning them and the cursor to resume
+// after the last if any were left out. The first thread is kept whatever its
+// size, so every page makes progress.
+func takeWholeThreads(rootIDs []any, sizes map[string]int, max int) ([]any, string) {
+	total := 0
+	for i, id := range rootIDs {
+		total += sizes[id.(string)]
+		if total > max && i > 0 {
+			return rootIDs[:i], rootIDs[i-1].(string)
+		}
+	}
+	return rootIDs, ""
+}
+
+// ListReplies returns the replies to a comment, paginated like ListComments.
+// The flat view pages through direct replies only; the tree view pages through
+// direct replies, each returned with its full subtree.
+func (s *SQLiteStore) ListReplies(ctx context.Context, parentID string, opts CommentListOptions) ([]*Comment, string, error) {
+	sort := commentSortFor(opts.Sort)
+
+	if opts.View == ViewTree {
+		return s.listCommentTreePage(ctx, "", parentID, sort, opts)
+	}
+
+	where := "parent_id = ? AND hidden = 0"
+	args := []any{parentID}
+	limit := ""
+	if opts.Limit > 0 {
+		if opts.Cursor != "" {
+			where += " AND " + sort.after
+			args = append(args, opts.Cursor)
+		}
+		limit = "LIMIT ?"
+		args = append(args, opts.Limit+1)
+	}
+
+	comments, err := s.queryComments(ctx, fmt.Sprintf(`
+		SELECT %s
+		FROM comments WHERE %s
+		ORDER BY %s
+		%s
+	`, commentColumns, where, sort.orderBy, limit), args...)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var nextCursor string
+	if opts.Limit > 0 && len(comments) > opts.Limit {
+		comments = comments[:opts.Limit]
+		nextCursor = comments[len(comments)-1].ID
+	}
+
+	return comments, nextCursor, nil
+}
+
+func (s *SQLiteStore) TopComments(ctx context.Context, storyIDs []string) (map[string]*Comment, error) {
+	if len(storyIDs) == 0 {
+		return map[string]*Comment{}, nil
+	}
+
+	args := make([]any, len(storyIDs))
+	for i, id := range storyIDs {
+		args[i] = id
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(storyIDs)), ",")
+
+	comments, err := s.queryComments(ctx, fmt.Sprintf(topCommentsQuery, placeholders, "hidden = 0", commentSortFor(SortTop).orderBy), args...)
+	if err != nil {
+		return nil, err
+	}
+	return commentsByStory(comments), nil
+}
+
+func (s *SQLiteStore) queryComments(ctx context.Context, query string, args ...any) ([]*Comment, error) {
+	rows, err := s.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var comments []*Comment
+	for rows.Next() {
+		comment, err := scanComment(rows)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, comment)
+	}
+
+	return comments, rows.Err()
+}
+
+// DefaultMaxTreeDepth bounds how deeply buildCommentTree nests replies when
+// CommentListOptions.MaxDepth is unset
+const DefaultMaxTreeDepth = 256
+
+// buildCommentTree nests comments under their parents and returns those
+// replying to parentID ("" for top-level comments) as the roots. It works
+// down from the roots, so a comment whose parent chain loops back on itself
+// (possible only through a corrupted or hand-edited dataset) never reaches a
+// root and is left out, as is anything nested more than maxDepth below one.
+func buildCommentTree(comments []*Comment, parentID string, maxDepth int) []*Comment {
+	if maxDepth <= 0 {
+		maxDepth = DefaultMaxTreeDepth
+	}
+
+	children := make(map[string][]*Comment)
+	for _, c := range comments {
+		children[c.ParentID] = append(children[c.ParentID], c)
+	}
+
+	roots := children[parentID]
+	placed := make(map[string]bool)
+	for _, c := range roots {
+		placed[c.ID] = true
+	}
+
+	level := roots
+	for depth := 0; depth < maxDepth && len(level) > 0; depth++ {
+		var next []*Comment
+		for _, parent := range level {
+			for _, c := range children[parent.ID] {
+				if placed[c.ID] {
+					continue
+				}
+				placed[c.ID] = true
+				parent.Children = append(parent.Children, c)
+				next = append(next, c)
+			}
+		}
+		level = next
+	}
+
+	return roots
+}
+
+func (s *SQLiteStore) UpdateCommentScore(ctx context.Context, id string, delta int) error {
+	_, err := s.conn.ExecContext(ctx, `UPDATE comments SET score = score + ? WHERE id = ?`, delta, id)
+	return err
+}
+
+func (s *SQLiteStore) UpdateCommentText(ctx context.Context, id, text string) error {
+	_, err := s.conn.ExecContext(ctx, `UPDATE comments SET text = ?, edited_at = ? WHERE id = ?`, text, time.Now().UTC(), id)
+	return err
+}
+
+func (s *SQLiteStore) HideComment(ctx context.Context, id string) error {
+	return s.setCommentHidden(ctx, id, 1, -1)
+}
+
+func (s *SQLiteStore) UnhideComment(ctx context.Context, id string) error {
+	return s.setCommentHidden(ctx, id, 0, 1)
+}
+
+// setCommentHidden hides or restores a comment and, if that changed it, moves
+// its story's comment count by delta in the same transaction
+func (s *SQLiteStore) setCommentHidden(ctx context.Context, id string, hidden, delta int) error {
+	tx, err := beginTx(ctx, s.db, s.tx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `UPDATE comments SET hidden = ? WHERE id = ? AND hidden <> ?`, hidden, id, hidden)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, updateCommentCountQuery, delta, id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RecomputeCommentCount resets a story's comment count to its visible
+// comments and returns it
+func (s *SQLiteStore) RecomputeCommentCount(ctx context.Context, storyID string) (int, error) {
+	var count int
+	err := s.conn.QueryRowContext(ctx, fmt.Sprintf(recomputeCommentCountQuery, "hidden = 0"), storyID).Scan(&count)
+	return count, err
+}
+
+func (s *SQLiteStore) GetHiddenComment(ctx context.Context, id string) (*Comment, error) {
+	row := s.conn.QueryRowContext(ctx, `
+		SELECT `+commentColumns+`
+		FROM comments WHERE id = ? AND hidden = 1
+	`, id)
+
+	comment, err := scanComment(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return comment, err
+}
+
+func (s *SQLiteStore) AnonymizeComment(ctx context.Context, id string, scrubText bool) error {
+	if scrubText {
+		_, err := s.conn.ExecContext(ctx, `UPDATE comments SET agent_id = ?, agent_verified = 0, text = ? WHERE id = ?`,
+			DeletedAgentID, DeletedText, id)
+		return err
+	}
+	_, err := s.conn.ExecContext(ctx, `UPDATE comments SET agent_id = ?, agent_verified = 0 WHERE id = ?`, DeletedAgentID, id)
+	return err
+}
+
+// Votes
+
+func (s *SQLiteStore) CreateVote(ctx context.Context, vote *Vote) error {
+	if vote.ID == "" {
+		vote.ID = uuid.New().String()
+	}
+	if vote.CreatedAt.IsZero() {
+		vote.CreatedAt = time.Now().UTC()
+	}
+
+	_, err := s.conn.ExecContext(ctx, `
+		INSERT INTO votes (id, target_type, target_id, value, weight, created_at, ip_hash, agent_id, agent_verified)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, vote.ID, vote.TargetType, vote.TargetID, vote.Value, vote.weight(), vote.CreatedAt,
+		nullString(vote.IPHash), nullString(vote.AgentID), boolToInt(vote.AgentVerified))
+
+	return err
+}
+
+func (s *SQLiteStore) GetVote(ctx context.Context, targetType, targetID, ipHash, agentID string) (*Vote, error) {
+	voter, voterArg := voterMatch(ipHash, agentID)
+	row := s.conn.QueryRowContext(ctx, `
+		SELECT id, target_type, target_id, value, weight, created_at, updated_at, ip_hash, agent_id, agent_verified
+		FROM votes WHERE target_type = ? AND target_id = ? AND `+voter, targetType, targetID, voterArg)
+
+	vote, err := scanVote(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return vote, err
+}
+
+func (s *SQLiteStore) UpdateVote(ctx context.Context, id string, value int) error {
+	_, err := s.conn.ExecContext(ctx, `UPDATE votes SET value = ?, updated_at = ? WHERE id = ?`, value, time.Now().UTC(), id)
 	return err
 }
 
-// Votes
+func (s *SQLiteStore) DeleteVote(ctx context.Context, id string) error {
+	_, err := s.conn.ExecContext(ctx, `DELETE FROM votes WHERE id = ?`, id)
+	return err
+}
+
+func (s *SQLiteStore) CastVote(ctx context.Context, vote *Vote) (int, error) {
+	var score int
+	err := s.WithTx(ctx, func(tx Store) error {
+		var err error
+		score, err = castVote(ctx, tx, vote)
+		return err
+	})
+	return score, err
+}
+
+// AdjustScore moves a story's or comment's score by delta and returns the new score
+func (s *SQLiteStore) AdjustScore(ctx context.Context, targetType, targetID string, delta int) (int, error) {
+	table, err := voteTargetTable(targetType)
+	if err != nil {
+		return 0, err
+	}
+
+	var score int
+	err = s.conn.QueryRowContext(ctx, `UPDATE `+table+` SET score = score + ? WHERE id = ? RETURNING score`, delta, targetID).Scan(&score)
+	return score, err
+}
+
+// AdjustVoteCounts moves a story's or comment's up and down vote counts
+func (s *SQLiteStore) AdjustVoteCounts(ctx context.Context, targetType, targetID string, up, down int) error {
+	table, err := voteTargetTable(targetType)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.conn.ExecContext(ctx, `UPDATE `+table+` SET upvotes = upvotes + ?, downvotes = downvotes + ? WHERE id = ?`, up, down, targetID)
+	return err
+}
+
+// RecomputeScore resets a target's score and vote counts from its votes and
+// returns the score
+func (s *SQLiteStore) RecomputeScore(ctx context.Context, targetType, targetID string) (int, error) {
+	table, err := voteTargetTable(targetType)
+	if err != nil {
+		return 0, err
+	}
+
+	var score int
+	err = s.conn.QueryRowContext(ctx, `
+		UPDATE `+table+` SET score = (
+			SELECT COALESCE(SUM(value * weight), 0) FROM votes WHERE target_type = ? AND target_id = ?
+		), `+voteCounts(targetType, table)+`
+		WHERE id = ?
+		RETURNING score
+	`, targetType, targetID, targetID).Scan(&score)
+	return score, err
+}
+
+// RecomputeAllScores resets every score and vote count of targetType from
+// its votes and returns how many targets had drifted
+func (s *SQLiteStore) RecomputeAllScores(ctx context.Context, targetType string) (int64, error) {
+	table, err := voteTargetTable(targetType)
+	if err != nil {
+		return 0, err
+	}
+
+	tally := `(SELECT COALESCE(SUM(value * weight), 0) FROM votes WHERE target_type = ? AND target_id = ` + table + `.id)`
+	res, err := s.conn.ExecContext(ctx, `
+		UPDATE `+table+` SET score = `+tally+`, `+voteCounts(targetType, table)+`
+		WHERE score <> `+tally+`
+			OR upvotes <> `+voteCount(targetType, table, ">")+`
+			OR downvotes <> `+voteCount(targetType, table, "<"),
+		targetType, targetType)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *SQLiteStore) RecomputeStory(ctx context.Context, id string) (*Story, error) {
+	return scanStory(s.conn.QueryRowContext(ctx, fmt.Sprintf(recomputeStoryQuery, "hidden = 0"), id))
+}
+
+func (s *SQLiteStore) TallyStoryVotes(ctx context.Context, storyID string) (map[string]VoteTally, error) {
+	rows, err := s.conn.QueryContext(ctx, fmt.Sprintf(storyVoteTallyQuery, "hidden = 0"), storyID, storyID)
+	if err != nil {
+		return nil, err
+	}
+	return scanVoteTallies(rows)
+}
+
+// Flags
+
+func (s *SQLiteStore) CreateFlag(ctx context.Context, flag *Flag) (int, error) {
+	if flag.ID == "" {
+		flag.ID = uuid.New().String()
+	}
+	if flag.CreatedAt.IsZero() {
+		flag.CreatedAt = time.Now().UTC()
+	}
+
+	// A repeat from the same agent or IP hits a unique index and is dropped
+	_, err := s.conn.ExecContext(ctx, `
+		INSERT INTO flags (id, target_type, target_id, reason, agent_id, ip_hash, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT DO NOTHING
+	`, flag.ID, flag.TargetType, flag.TargetID, flag.Reason,
+		nullString(flag.AgentID), nullString(flag.IPHash), flag.CreatedAt)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	err = s.conn.QueryRowContext(ctx, `SELECT COUNT(*) FROM flags WHERE target_type = ? AND target_id = ?`,
+		flag.TargetType, flag.TargetID).Scan(&count)
+	return count, err
+}
+
+func (s *SQLiteStore) ListFlags(ctx context.Context, limit int) ([]*Flag, error) {
+	rows, err := s.conn.QueryContext(ctx, `
+		SELECT id, target_type, target_id, reason, created_at, ip_hash, agent_id
+		FROM flags ORDER BY created_at DESC, id LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var flags []*Flag
+	for rows.Next() {
+		flag, err := scanFlag(rows)
+		if err != nil {
+			return nil, err
+		}
+		flags = append(flags, flag)
+	}
+	return flags, rows.Err()
+}
+
+// Audit log
+
+func (s *SQLiteStore) LogAudit(ctx context.Context, entry *AuditEntry) error {
+	if entry.ID == "" {
+		entry.ID = uuid.New().String()
+	}
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now().UTC()
+	}
+
+	_, err := s.conn.ExecContext(ctx, insertAuditQuery, entry.ID, entry.Action,
+		nullString(entry.TargetType), nullString(entry.TargetID), entry.Actor, entry.CreatedAt)
+	return err
+}
+
+func (s *SQLiteStore) ListAudit(ctx context.Context, before time.Time, limit int) ([]*AuditEntry, error) {
+	where, args := createdBefore(before)
+	rows, err := s.conn.QueryContext(ctx, `
+		SELECT `+auditColumns+`
+		FROM audit_log`+where+`
+		ORDER BY created_at DESC, id DESC
+		LIMIT ?
+	`, append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	return scanAuditEntries(rows)
+}
+
+func (s *SQLiteStore) ListRecentContent(ctx context.Context, before time.Time, limit int) ([]*Story, []*Comment, error) {
+	where, args := createdBefore(before)
+	args = append(args, limit)
+
+	rows, err := s.conn.QueryContext(ctx, `
+		SELECT `+storyColumns+`
+		FROM stories`+where+`
+		ORDER BY created_at DESC, id DESC
+		LIMIT ?
+	`, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	stories, err := scanRows(rows, scanStory)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows, err = s.conn.QueryContext(ctx, `
+		SELECT `+commentColumns+`
+		FROM comments`+where+`
+		ORDER BY created_at DESC, id DESC
+		LIMIT ?
+	`, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	comments, err := scanRows(rows, scanComment)
+	if err != nil {
+		return nil, nil, err
+	}
+	return stories, comments, nil
+}
+
+// Stats
+
+func (s *SQLiteStore) CountContent(ctx context.Context) (*ContentCounts, error) {
+	var counts ContentCounts
+	err := s.conn.QueryRowContext(ctx, contentCountsQuery("hidden = 0")).Scan(&counts.Stories, &counts.Comments, &counts.Accounts)
+	if err != nil {
+		return nil, err
+	}
+	return &counts, nil
+}
+
+// Accounts
 
-func (s *SQLiteStore) CreateVote(ctx context.Context, vote *Vote) error {
-	if vote.ID == "" {
-		vote.ID = uuid.New().String()
+func (s *SQLiteStore) CreateAccount(ctx context.Context, account *Account) error {
+	if account.ID == "" {
+		account.ID = uuid.New().String()
 	}
-	if vote.CreatedAt.IsZero() {
-		vote.CreatedAt = time.Now().UTC()
+	if account.CreatedAt.IsZero() {
+		account.CreatedAt = time.Now().UTC()
 	}
 
-	_, err := s.db.ExecContext(ctx, `
-		INSERT INTO votes (id, target_type, target_id, value, created_at, ip_hash, agent_id, agent_verified)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
-	`, vote.ID, vote.TargetType, vote.TargetID, vote.Value, vote.CreatedAt,
-		nullString(vote.IPHash), nullString(vote.AgentID), boolToInt(vote.AgentVerified))
+	_, err := s.conn.ExecContext(ctx, `
+		INSERT INTO accounts (id, display_name, bio, homepage_url, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, account.ID, account.DisplayName, nullString(account.Bio),
+		nullString(account.HomepageURL), account.CreatedAt)
 
 	return err
 }
 
-func (s *SQLiteStore) GetVote(ctx context.Context, targetType, targetID, ipHash, agentID string) (*Vote, error) {
-	row := s.db.QueryRowContext(ctx, `
-		SELECT id, target_type, target_id, value, created_at, ip_hash, agent_id, agent_verified
-		FROM votes WHERE target_type = ? AND target_id = ? AND (ip_hash = ? OR agent_id = ?)
-	`, targetType, targetID, ipHash, agentID)
+func (s *SQLiteStore) GetAccount(ctx context.Context, id string) (*Account, error) {
+	row := s.conn.QueryRowContext(ctx, `
+		SELECT id, display_name, bio, homepage_url, created_at
+		FROM accounts WHERE id = ?
+	`, id)
 
-	var vote Vote
-	var ipHashNull, agentIDNull sql.NullString
-	err := row.Scan(&vote.ID, &vote.TargetType, &vote.TargetID, &vote.Value, &vote.CreatedAt,
-		&ipHashNull, &agentIDNull, &vote.AgentVerified)
+	account, err := scanAccount(row)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
+	return account, err
+}
+
+// FindAccountsByName returns accounts whose display name contains name,
+// ignoring case, oldest first and at most maxAccountMatches of them
+func (s *SQLiteStore) FindAccountsByName(ctx context.Context, name string) ([]*Account, error) {
+	rows, err := s.conn.QueryContext(ctx, findAccountsByNameQuery, "%"+likeEscaper.Replace(name)+"%", maxAccountMatches)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	vote.IPHash = ipHashNull.String
-	vote.AgentID = agentIDNull.String
-	return &vote, nil
+	var accounts []*Account
+	for rows.Next() {
+		account, err := scanAccount(rows)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, rows.Err()
 }
 
-func (s *SQLiteStore) UpdateVote(ctx context.Context, id string, value int) error {
-	_, err := s.db.ExecContext(ctx, `UPDATE votes SET value = ? WHERE id = ?`, value, id)
+// PruneInactiveAccounts deletes accounts that have been idle since before
+// (see inactiveAccountsQuery) along with their keys, tokens, and agent links,
+// returning the accounts deleted
+func (s *SQLiteStore) PruneInactiveAccounts(ctx context.Context, before time.Time) ([]*Account, error) {
+	tx, err := beginTx(ctx, s.db, s.tx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Times compare as text, so match how each column was written:
+	// tokens.expires_at in SQLite's datetime format, the rest as UTC
+	before = before.UTC()
+	rows, err := tx.QueryContext(ctx, inactiveAccountsQuery, before, before, before.Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return nil, err
+	}
+	var accounts []*Account
+	for rows.Next() {
+		account, err := scanAccount(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, account := range accounts {
+		for _, query := range deleteAccountQueries {
+			if _, err := tx.ExecContext(ctx, query, account.ID); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return accounts, tx.Commit()
+}
+
+// AccountsForAgents maps each of agentIDs that has signed in with an
+// account's key to that account. An agent linked to several accounts maps to
+// the oldest.
+func (s *SQLiteStore) AccountsForAgents(ctx context.Context, agentIDs []string) (map[string]*Account, error) {
+	byAgent := make(map[string]*Account)
+	if len(agentIDs) == 0 {
+		return byAgent, nil
+	}
+
+	args := make([]any, len(agentIDs))
+	for i, id := range agentIDs {
+		args[i] = id
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(agentIDs)), ",")
+
+	rows, err := s.conn.QueryContext(ctx, fmt.Sprintf(accountsForAgentsQuery, placeholders), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var agentID string
+		var account Account
+		if err := rows.Scan(&agentID, &account.ID, &account.DisplayName, &account.CreatedAt); err != nil {
+			return nil, err
+		}
+		if _, ok := byAgent[agentID]; !ok {
+			account.CreatedAt = account.CreatedAt.UTC()
+			byAgent[agentID] = &account
+		}
+	}
+	return byAgent, rows.Err()
+}
+
+func (s *SQLiteStore) GetAccountKarma(ctx context.Context, accountID string) (int, error) {
+	var karma int
+	err := s.conn.QueryRowContext(ctx, fmt.Sprintf(accountKarmaQuery, "0"), accountID, accountID).Scan(&karma)
+	return karma, err
+}
+
+// Account Keys
+
+func (s *SQLiteStore) CreateAccountKey(ctx context.Context, key *AccountKey) error {
+	if key.ID == "" {
+		key.ID = uuid.New().String()
+	}
+	if key.CreatedAt.IsZero() {
+		key.CreatedAt = time.Now().UTC()
+	}
+
+	_, err := s.conn.ExecContext(ctx, `
+		INSERT INTO account_keys (id, account_id, algorithm, public_key, created_at, revoked_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, key.ID, key.AccountID, key.Algorithm, key.PublicKey, key.CreatedAt, nil)
+
 	return err
 }
 
-// Accounts
+func (s *SQLiteStore) GetAccountKey(ctx context.Context, id string) (*AccountKey, error) {
+	row := s.conn.QueryRowContext(ctx, `
+		SELECT id, account_id, algorithm, public_key, created_at, revoked_at
+		FROM account_keys WHERE id = ?
+	`, id)
 
-func (s *SQLiteStore) CreateAccount(ctx context.Context, account *Account) error {
-	if account.ID == "" {
-		account.ID = uuid.New().String()
+	return scanAccountKey(row)
+}
+
+func (s *SQLiteStore) GetAccountKeyByPublicKey(ctx context.Context, alg, publicKey string) (*AccountKey, error) {
+	row := s.conn.QueryRowContext(ctx, `
+		SELECT id, account_id, algorithm, public_key, created_at, revoked_at
+		FROM account_keys WHERE algorithm = ? AND public_key = ? AND revoked_at IS NULL
+	`, alg, publicKey)
+
+	key, err := scanAccountKey(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
 	}
-	if account.CreatedAt.IsZero() {
-		account.CreatedAt = time.Now().UTC()
+	return key, err
+}
+
+func (s *SQLiteStore) ListAccountKeys(ctx context.Context, accountID string) ([]*AccountKey, error) {
+	rows, err := s.conn.QueryContext(ctx, `
+		SELECT id, account_id, algorithm, public_key, created_at, revoked_at
+		FROM account_keys WHERE account_id = ?
+		ORDER BY created_at, id
+	`, accountID)
+	if err != nil {
+		return nil, err
 	}
+	defer rows.Close()
 
-	_, err := s.db.ExecContext(ctx, `
-		INSERT INTO accounts (id, display_name, bio, homepage_url, created_at)
+	var keys []*AccountKey
+	for rows.Next() {
+		key, err := scanAccountKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+func (s *SQLiteStore) RevokeAccountKey(ctx context.Context, id string) error {
+	_, err := s.conn.ExecContext(ctx, `UPDATE account_keys SET revoked_at = ? WHERE id = ?`, time.Now().UTC(), id)
+	return err
+}
+
+// Auth
+
+func (s *SQLiteStore) CreateChallenge(ctx context.Context, challenge *Challenge) error {
+	if challenge.ID == "" {
+		challenge.ID = uuid.New().String()
+	}
+
+	// Format time in SQLite-compatible format for proper datetime comparison
+	expiresAtStr := challenge.ExpiresAt.UTC().Format("2006-01-02 15:04:05")
+
+	_, err := s.conn.ExecContext(ctx, `
+		INSERT INTO challenges (id, agent_id, algorithm, challenge, expires_at)
 		VALUES (?, ?, ?, ?, ?)
-	`, account.ID, account.DisplayName, nullString(account.Bio),
-		nullString(account.HomepageURL), account.CreatedAt)
+	`, challenge.ID, challenge.AgentID, challenge.Algorithm, challenge.Challenge, expiresAtStr)
 
 	return err
 }
 
-func (s *SQLiteStore) GetAccount(ctx context.Context, id string) (*Account, error) {
-	row := s.db.QueryRowContext(ctx, `
-		SELECT id, display_name, bio, homepage_url, created_at
-		FROM accounts WHERE id = ?
-	`, id)
+func (s *SQLiteStore) GetChallenge(ctx context.Context, challengeStr string) (*Challenge, error) {
+	row := s.conn.QueryRowContext(ctx, `
+		SELECT id, agent_id, algorithm, challenge, expires_at, failures
+		FROM challenges WHERE challenge = ? AND expires_at > datetime('now')
+	`, challengeStr)
 
-	var account Account
-	var bio, homepageURL sql.NullString
-	err := row.Scan(&account.ID, &account.DisplayName, &bio, &homepageURL, &account.CreatedAt)
+	var c Challenge
+	err := row.Scan(&c.ID, &c.AgentID, &c.Algorithm, &c.Challenge, &c.ExpiresAt, &c.Failures)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	account.Bio = bio.String
-	account.HomepageURL = homepageURL.String
-	return &account, nil
+	c.ExpiresAt = c.ExpiresAt.UTC()
+	return &c, nil
+}
+
+func (s *SQLiteStore) DeleteChallenge(ctx context.Context, id string) error {
+	_, err := s.conn.ExecContext(ctx, `DELETE FROM challenges WHERE id = ?`, id)
+	return err
+}
+
+func (s *SQLiteStore) RecordChallengeFailure(ctx context.Context, id string) (int, error) {
+	var failures int
+	err := s.conn.QueryRowContext(ctx, `UPDATE challenges SET failures = failures + 1 WHERE id = ? RETURNING failures`, id).Scan(&failures)
+	return failures, err
+}
+
+func (s *SQLiteStore) DeleteExpiredChallenges(ctx context.Context) error {
+	_, err := s.conn.ExecContext(ctx, `DELETE FROM challenges WHERE expires_at < datetime('now')`)
+	return err
+}
+
+func (s *SQLiteStore) CountActiveChallenges(ctx context.Context, agentID string) (int, error) {
+	var count int
+	err := s.conn.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM challenges WHERE agent_id = ? AND expires_at > datetime('now')
+	`, agentID).Scan(&count)
+	return count, err
+}
+
+func (s *SQLiteStore) CreateToken(ctx context.Context, token *Token) error {
+	if token.ID == "" {
+		token.ID = uuid.New().String()
+	}
+
+	// Format time in SQLite-compatible format for proper datetime comparison
+	expiresAtStr := token.ExpiresAt.UTC().Format("2006-01-02 15:04:05")
+
+	_, err := s.conn.ExecContext(ctx, `
+		INSERT INTO tokens (id, account_id, key_id, agent_id, token, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, token.ID, nullString(token.AccountID), token.KeyID, token.AgentID, token.Token, expiresAtStr)
+	if err != nil || token.AccountID == "" {
+		return err
+	}
+
+	// Remember the agent belongs to the account after the token expires
+	_, err = s.conn.ExecContext(ctx, `
+		INSERT OR IGNORE INTO account_agents (account_id, agent_id) VALUES (?, ?)
+	`, token.AccountID, token.AgentID)
+	return err
+}
+
+func (s *SQLiteStore) GetToken(ctx context.Context, tokenStr string) (*Token, error) {
+	row := s.conn.QueryRowContext(ctx, `
+		SELECT id, account_id, key_id, agent_id, token, expires_at
+		FROM tokens WHERE token = ? AND expires_at > datetime('now')
+	`, tokenStr)
+
+	t, err := scanToken(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return t, err
+}
+
+func (s *SQLiteStore) DeleteExpiredTokens(ctx context.Context) error {
+	if _, err := s.conn.ExecContext(ctx, `DELETE FROM tokens WHERE expires_at < datetime('now')`); err != nil {
+		return err
+	}
+	_, err := s.conn.ExecContext(ctx, `DELETE FROM token_revocations WHERE expires_at < datetime('now')`)
+	return err
+}
+
+func (s *SQLiteStore) DeleteTokensForAgent(ctx context.Context, agentID string) (int64, error) {
+	res, err := s.conn.ExecContext(ctx, `DELETE FROM tokens WHERE agent_id = ?`, agentID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *SQLiteStore) RevokeAgentTokens(ctx context.Context, agentID string, revokedAt, expiresAt time.Time) error {
+	// Format time in SQLite-compatible format for proper datetime comparison
+	expiresAtStr := expiresAt.UTC().Format("2006-01-02 15:04:05")
+
+	_, err := s.conn.ExecContext(ctx, `
+		INSERT INTO token_revocations (agent_id, revoked_at, expires_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT(agent_id) DO UPDATE SET revoked_at = excluded.revoked_at, expires_at = excluded.expires_at
+	`, agentID, revokedAt.UTC(), expiresAtStr)
+	return err
+}
+
+func (s *SQLiteStore) GetAgentRevocation(ctx context.Context, agentID string) (time.Time, error) {
+	var revokedAt time.Time
+	err := s.conn.QueryRowContext(ctx, `
+		SELECT revoked_at FROM token_revocations
+		WHERE agent_id = ? AND expires_at > datetime('now')
+	`, agentID).Scan(&revokedAt)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	return revokedAt, err
+}
+
+// Idempotency keys
+
+func (s *SQLiteStore) GetIdempotencyKey(ctx context.Context, scope, key string) (*IdempotencyKey, error) {
+	row := s.conn.QueryRowContext(ctx, `
+		SELECT `+idempotencyKeyColumns+`
+		FROM idempotency_keys
+		WHERE scope = ? AND idempotency_key = ? AND expires_at > datetime('now')
+	`, scope, key)
+
+	k, err := scanIdempotencyKey(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return k, err
+}
+
+func (s *SQLiteStore) CreateIdempotencyKey(ctx context.Context, k *IdempotencyKey) error {
+	if k.CreatedAt.IsZero() {
+		k.CreatedAt = time.Now().UTC()
+	}
+
+	// Format time in SQLite-compatible format for proper datetime comparison
+	expiresAtStr := k.ExpiresAt.UTC().Format("2006-01-02 15:04:05")
+
+	// An expired key the sweeper hasn't reached yet may be reused
+	_, err := s.conn.ExecContext(ctx, `
+		DELETE FROM idempotency_keys
+		WHERE scope = ? AND idempotency_key = ? AND expires_at <= datetime('now')
+	`, k.Scope, k.Key)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.conn.ExecContext(ctx, `
+		INSERT INTO idempotency_keys (scope, idempotency_key, request_hash, resource_id, response, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT DO NOTHING
+	`, k.Scope, k.Key, k.RequestHash, nullString(k.ResourceID), k.Response, k.CreatedAt, expiresAtStr)
+	return err
+}
+
+func (s *SQLiteStore) DeleteExpiredIdempotencyKeys(ctx context.Context) error {
+	_, err := s.conn.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE expires_at < datetime('now')`)
+	return err
+}
+
+// Admin tokens
+
+func (s *SQLiteStore) CreateAdminToken(ctx context.Context, t *AdminToken) error {
+	if t.ID == "" {
+		t.ID = uuid.New().String()
+	}
+	if t.CreatedAt.IsZero() {
+		t.CreatedAt = time.Now().UTC()
+	}
+
+	_, err := s.conn.ExecContext(ctx, insertAdminTokenQuery,
+		t.ID, nullString(t.Label), t.TokenHash, strings.Join(t.Scopes, " "), t.CreatedAt)
+	return err
+}
+
+func (s *SQLiteStore) GetAdminToken(ctx context.Context, tokenHash string) (*AdminToken, error) {
+	row := s.conn.QueryRowContext(ctx, `SELECT `+adminTokenColumns+` FROM admin_tokens WHERE token_hash = ?`, tokenHash)
+
+	t, err := scanAdminToken(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return t, err
+}
+
+func (s *SQLiteStore) ListAdminTokens(ctx context.Context) ([]*AdminToken, error) {
+	rows, err := s.conn.QueryContext(ctx, `SELECT `+adminTokenColumns+` FROM admin_tokens ORDER BY created_at, id`)
+	if err != nil {
+		return nil, err
+	}
+	return scanAdminTokens(rows)
+}
+
+func (s *SQLiteStore) DeleteAdminToken(ctx context.Context, id string) (bool, error) {
+	res, err := s.conn.ExecContext(ctx, `DELETE FROM admin_tokens WHERE id = ?`, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
 }
 
-// Account Keys
+// Helpers
 
-func (s *SQLiteStore) CreateAccountKey(ctx context.Context, key *AccountKey) error {
-	if key.ID == "" {
-		key.ID = uuid.New().String()
+const insertCommentQuery = `
+	INSERT INTO comments (id, story_id, parent_id, text, score, created_at, hidden, agent_id, agent_verified, depth)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+const insertStoryQuery = `
+	INSERT INTO stories (id, title, url, text, tags, score, comment_count, created_at, hidden, agent_id, agent_verified, pending, text_hash, url_key, source_url)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+// ownerFilter returns the condition selecting content posted by agentID or,
+// when accountID is set, by any agent that has signed in to the account, and
+// its args
+func ownerFilter(agentID, accountID string) (string, []any) {
+	if accountID == "" {
+		return "agent_id = ?", []any{agentID}
 	}
-	if key.CreatedAt.IsZero() {
-		key.CreatedAt = time.Now().UTC()
+	return "(agent_id = ? OR agent_id IN (SELECT agent_id FROM account_agents WHERE account_id = ?))", []any{agentID, accountID}
+}
+
+// voterMatch returns the condition selecting one voter's votes and its arg.
+// An authenticated voter is matched by agent alone and an anonymous one by IP
+// among anonymous votes only, so voters sharing an IP never pick up each
+// other's vote. agentID must come from a verified token: pass "" for any other
+// caller, or a made-up agent id would escape the IP match.
+func voterMatch(ipHash, agentID string) (string, any) {
+	if agentID != "" {
+		return "agent_id = ?", agentID
 	}
+	return "agent_id IS NULL AND ip_hash = ?", ipHash
+}
 
-	_, err := s.db.ExecContext(ctx, `
-		INSERT INTO account_keys (id, account_id, algorithm, public_key, created_at, revoked_at)
-		VALUES (?, ?, ?, ?, ?, ?)
-	`, key.ID, key.AccountID, key.Algorithm, key.PublicKey, key.CreatedAt, nil)
+// findRepost returns the newest visible story that story would repost: one
+// whose URL has the same urlKey created after urlSince, or the same text body after
+// textSince. A zero time skips that check. queryRow runs a query in the
+// caller's transaction, and visible is the backend's not-hidden predicate.
+func findRepost(story *Story, urlSince, textSince time.Time, visible string, queryRow func(query string, args ...any) *sql.Row) (*Story, error) {
+	find := func(column, value string, since time.Time) (*Story, error) {
+		existing, err := scanStory(queryRow(`
+			SELECT `+storyColumns+`
+			FROM stories WHERE `+column+` = ? AND created_at > ? AND `+visible+`
+			ORDER BY created_at DESC LIMIT 1
+		`, value, since))
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return existing, err
+	}
 
-	return err
+	if key := urlKey(story.URL); key != "" && !urlSince.IsZero() {
+		if existing, err := find("url_key", key, urlSince); existing != nil || err != nil {
+			return existing, err
+		}
+	}
+	if hash := textHash(story.Text); hash != "" && !textSince.IsZero() {
+		return find("text_hash", hash, textSince)
+	}
+	return nil, nil
 }
 
-func (s *SQLiteStore) GetAccountKey(ctx context.Context, id string) (*AccountKey, error) {
-	row := s.db.QueryRowContext(ctx, `
-		SELECT id, account_id, algorithm, public_key, created_at, revoked_at
-		FROM account_keys WHERE id = ?
-	`, id)
-
-	return scanAccountKey(row)
+// normalizeTag is the form a tag is stored and matched in story_tags
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
 }
 
-func (s *SQLiteStore) GetAccountKeyByPublicKey(ctx context.Context, alg, publicKey string) (*AccountKey, error) {
-	row := s.db.QueryRowContext(ctx, `
-		SELECT id, account_id, algorithm, public_key, created_at, revoked_at
-		FROM account_keys WHERE algorithm = ? AND public_key = ? AND revoked_at IS NULL
-	`, alg, publicKey)
+// insertStoryTags records a story's tags in story_tags through exec, which
+// runs a statement in the caller's transaction
+func insertStoryTags(storyID string, tags []string, exec func(query string, args ...any) (sql.Result, error)) error {
+	for _, tag := range tags {
+		tag = normalizeTag(tag)
+		if tag == "" {
+			continue
+		}
+		if _, err := exec(`INSERT INTO story_tags (story_id, tag) VALUES (?, ?) ON CONFLICT DO NOTHING`, storyID, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	key, err := scanAccountKey(row)
-	if err == sql.ErrNoRows {
-		return nil, nil
+// tagFilter adds a condition on tag to a story listing's filter and args
+func tagFilter(tag, filter string, args []any) (string, []any) {
+	tag = normalizeTag(tag)
+	if tag == "" {
+		return filter, args
 	}
-	return key, err
+	cond := "id IN (SELECT story_id FROM story_tags WHERE tag = ?)"
+	if filter != "" {
+		cond = filter + " AND " + cond
+	}
+	return cond, append(args, tag)
 }
 
-func (s *SQLiteStore) ListAccountKeys(ctx context.Context, accountID string) ([]*AccountKey, error) {
-	rows, err := s.db.QueryContext(ctx, `
-		SELECT id, account_id, algorithm, public_key, created_at, revoked_at
-		FROM account_keys WHERE account_id = ?
-	`, accountID)
-	if err != nil {
-		return nil, err
+// typeFilter adds a condition on story type to a story listing's filter.
+// Stories without a URL store it as NULL.
+func typeFilter(storyType StoryType, filter string) string {
+	var cond string
+	switch storyType {
+	case StoryLink:
+		cond = "url IS NOT NULL"
+	case StoryText:
+		cond = "url IS NULL"
+	default:
+		return filter
 	}
+	if filter != "" {
+		cond = filter + " AND " + cond
+	}
+	return cond
+}
+
+// listTagsQuery counts tags on visible stories; visible is the backend's
+// predicate for unhidden rows
+func listTagsQuery(visible string) string {
+	return `
+		SELECT story_tags.tag, COUNT(*) AS uses
+		FROM story_tags JOIN stories ON stories.id = story_tags.story_id
+		WHERE ` + visible + `
+		GROUP BY story_tags.tag
+		ORDER BY uses DESC, story_tags.tag`
+}
+
+// scanTagCounts reads the rows of listTagsQuery
+func scanTagCounts(rows *sql.Rows) ([]TagCount, error) {
 	defer rows.Close()
 
-	var keys []*AccountKey
+	var tags []TagCount
 	for rows.Next() {
-		var key AccountKey
-		var revokedAt sql.NullTime
-		err := rows.Scan(&key.ID, &key.AccountID, &key.Algorithm, &key.PublicKey, &key.CreatedAt, &revokedAt)
-		if err != nil {
+		var tc TagCount
+		if err := rows.Scan(&tc.Tag, &tc.Count); err != nil {
 			return nil, err
 		}
-		if revokedAt.Valid {
-			key.RevokedAt = &revokedAt.Time
-		}
-		keys = append(keys, &key)
+		tags = append(tags, tc)
 	}
-
-	return keys, nil
+	return tags, rows.Err()
 }
 
-func (s *SQLiteStore) RevokeAccountKey(ctx context.Context, id string) error {
-	_, err := s.db.ExecContext(ctx, `UPDATE account_keys SET revoked_at = ? WHERE id = ?`, time.Now().UTC(), id)
-	return err
+// contentCountsQuery counts the rows CountContent reports; visible is the
+// backend's predicate for unhidden rows
+func contentCountsQuery(visible string) string {
+	return `SELECT
+		(SELECT COUNT(*) FROM stories WHERE ` + visible + `),
+		(SELECT COUNT(*) FROM comments WHERE ` + visible + `),
+		(SELECT COUNT(*) FROM accounts)`
 }
 
-// Auth
+// maxTagsSize caps a story's serialized tags, keeping the listing scans from
+// wading through oversized blobs. It is the only bound on their size: the API
+// doesn't limit a tag's length, and MAX_TAGS=0 lifts the count limit too.
+const maxTagsSize = 1024
 
-func (s *SQLiteStore) CreateChallenge(ctx context.Context, challenge *Challenge) error {
-	if challenge.ID == "" {
-		challenge.ID = uuid.New().String()
-	}
+// ErrTagsTooLarge is returned when a story's tags serialize past maxTagsSize
+var ErrTagsTooLarge = fmt.Errorf("tags exceed %d bytes", maxTagsSize)
 
-	// Format time in SQLite-compatible format for proper datetime comparison
-	expiresAtStr := challenge.ExpiresAt.UTC().Format("2006-01-02 15:04:05")
+func marshalTags(tags []string) (string, error) {
+	tagsJSON, err := json.Marshal(tags)
+	if err != nil {
+		return "", err
+	}
+	if len(tagsJSON) > maxTagsSize {
+		return "", ErrTagsTooLarge
+	}
+	return string(tagsJSON), nil
+}
 
-	_, err := s.db.ExecContext(ctx, `
-		INSERT INTO challenges (id, agent_id, algorithm, challenge, expires_at)
-		VALUES (?, ?, ?, ?, ?)
-	`, challenge.ID, challenge.AgentID, challenge.Algorithm, challenge.Challenge, expiresAtStr)
+// textHash fingerprints a text post's body for duplicate detection: the
+// SHA-256 of the text lowercased with runs of whitespace collapsed, so
+// reflowed or recased copies match. Empty text has no hash.
+func textHash(text string) string {
+	normalized := strings.Join(strings.Fields(strings.ToLower(text)), " ")
+	if normalized == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
 
-	return err
+// trackingParams are query parameters that only say where a link was shared
+// or clicked; urlKey drops them, along with any utm_ parameter
+var trackingParams = map[string]bool{
+	"fbclid": true, "gclid": true, "dclid": true, "msclkid": true, "yclid": true,
+	"mc_cid": true, "mc_eid": true, "igshid": true, "_ga": true, "ref_src": true,
 }
 
-func (s *SQLiteStore) GetChallenge(ctx context.Context, challengeStr string) (*Challenge, error) {
-	row := s.db.QueryRowContext(ctx, `
-		SELECT id, agent_id, algorithm, challenge, expires_at
-		FROM challenges WHERE challenge = ? AND expires_at > datetime('now')
-	`, challengeStr)
+// urlKey is the form of a story URL that duplicate detection compares, so
+// trivially different links to the same page match: http and https are one
+// scheme, the host is lowercased and loses a default port, a trailing slash,
+// the fragment, and tracking parameters are dropped, and the remaining query
+// parameters are sorted. A URL that doesn't parse as absolute is compared as
+// given. An empty URL has no key.
+func urlKey(rawURL string) string {
+	rawURL = strings.TrimSpace(rawURL)
+	if rawURL == "" {
+		return ""
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
 
-	var c Challenge
-	err := row.Scan(&c.ID, &c.AgentID, &c.Algorithm, &c.Challenge, &c.ExpiresAt)
-	if err == sql.ErrNoRows {
-		return nil, nil
+	scheme := strings.ToLower(u.Scheme)
+	if scheme == "http" || scheme == "https" {
+		scheme = "https"
 	}
-	if err != nil {
-		return nil, err
+	host := strings.ToLower(u.Hostname())
+	if port := u.Port(); port != "" && port != "80" && port != "443" {
+		host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
 	}
 
-	return &c, nil
+	query := u.Query()
+	for name := range query {
+		if trackingParams[strings.ToLower(name)] || strings.HasPrefix(strings.ToLower(name), "utm_") {
+			query.Del(name)
+		}
+	}
+
+	key := scheme + "://" + host + strings.TrimRight(u.EscapedPath(), "/")
+	if encoded := query.Encode(); encoded != "" {
+		key += "?" + encoded
+	}
+	return key
 }
 
-func (s *SQLiteStore) DeleteChallenge(ctx context.Context, id string) error {
-	_, err := s.db.ExecContext(ctx, `DELETE FROM challenges WHERE id = ?`, id)
-	return err
+// voteCount returns an SQL expression counting the up (sign ">") or down
+// (sign "<") votes on the table row in scope. targetType must have passed
+// voteTargetTable.
+func voteCount(targetType, table, sign string) string {
+	return `(SELECT COUNT(*) FROM votes WHERE target_type = '` + targetType + `' AND target_id = ` + table + `.id AND value ` + sign + ` 0)`
 }
 
-func (s *SQLiteStore) CreateToken(ctx context.Context, token *Token) error {
-	if token.ID == "" {
-		token.ID = uuid.New().String()
+// voteCounts returns SQL assignments resetting the upvotes and downvotes of
+// the table row in scope from its votes
+func voteCounts(targetType, table string) string {
+	return `upvotes = ` + voteCount(targetType, table, ">") + `, downvotes = ` + voteCount(targetType, table, "<")
+}
+
+// backfillVoteCounts counts the up and down votes of stories and comments
+// voted on before the counts were stored. Counted targets are skipped, so
+// once done it only costs a check.
+func backfillVoteCounts(db *sql.DB) error {
+	for _, targetType := range []string{"story", "comment"} {
+		table, _ := voteTargetTable(targetType)
+		_, err := db.Exec(`
+			UPDATE ` + table + ` SET ` + voteCounts(targetType, table) + `
+			WHERE upvotes = 0 AND downvotes = 0
+				AND id IN (SELECT target_id FROM votes WHERE target_type = '` + targetType + `' AND value <> 0)
+		`)
+		if err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	// Format time in SQLite-compatible format for proper datetime comparison
-	expiresAtStr := token.ExpiresAt.UTC().Format("2006-01-02 15:04:05")
+// updateURLKeyQuery sets one story's url_key for backfillURLKeys
+const updateURLKeyQuery = `UPDATE stories SET url_key = ? WHERE id = ?`
 
-	_, err := s.db.ExecContext(ctx, `
-		INSERT INTO tokens (id, account_id, key_id, agent_id, token, expires_at)
-		VALUES (?, ?, ?, ?, ?, ?)
-	`, token.ID, nullString(token.AccountID), token.KeyID, token.AgentID, token.Token, expiresAtStr)
+// backfillURLKeys sets the url_key of URL stories written before stories
+// stored one. update is updateURLKeyQuery in the backend's placeholder style.
+func backfillURLKeys(db *sql.DB, update string) error {
+	rows, err := db.Query(`SELECT id, url FROM stories WHERE url_key IS NULL AND url IS NOT NULL AND url <> ''`)
+	if err != nil {
+		return err
+	}
+	keys := map[string]string{}
+	for rows.Next() {
+		var id, url string
+		if err := rows.Scan(&id, &url); err != nil {
+			rows.Close()
+			return err
+		}
+		keys[id] = urlKey(url)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, key := range keys {
+		if _, err := db.Exec(update, key, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backfillCommentDepth sets the depth of replies written before comments
+// stored one. It walks every thread, so it only runs while such replies exist.
+func backfillCommentDepth(db *sql.DB) error {
+	var stale bool
+	err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM comments WHERE parent_id IS NOT NULL AND depth = 0)`).Scan(&stale)
+	if err != nil || !stale {
+		return err
+	}
 
+	_, err = db.Exec(`
+		WITH RECURSIVE thread(id, depth) AS (
+			SELECT id, 0 FROM comments WHERE parent_id IS NULL
+			UNION ALL
+			SELECT c.id, t.depth + 1 FROM comments c JOIN thread t ON c.parent_id = t.id
+		)
+		UPDATE comments SET depth = thread.depth
+		FROM thread
+		WHERE comments.id = thread.id AND comments.depth <> thread.depth
+	`)
 	return err
 }
 
-func (s *SQLiteStore) GetToken(ctx context.Context, tokenStr string) (*Token, error) {
-	row := s.db.QueryRowContext(ctx, `
-		SELECT id, account_id, key_id, agent_id, token, expires_at
-		FROM tokens WHERE token = ? AND expires_at > datetime('now')
-	`, tokenStr)
+// maxAccountMatches caps how many accounts FindAccountsByName returns
+const maxAccountMatches = 50
+
+// findAccountsByNameQuery matches display names against a LIKE pattern
+// escaped with likeEscaper. LOWER applies to both sides so that case folding
+// is the same however the backend defines it.
+const findAccountsByNameQuery = `
+	SELECT id, display_name, bio, homepage_url, created_at
+	FROM accounts WHERE LOWER(display_name) LIKE LOWER(?) ESCAPE '\'
+	ORDER BY created_at ASC, id ASC
+	LIMIT ?
+`
+
+// likeEscaper makes LIKE's wildcards in a search term match literally
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// inactiveAccountsQuery selects accounts that are safe to prune: created
+// before the cutoff, with no key added or token expiring since, and with
+// nothing (stories, comments, votes, or flags) posted by any of their agents.
+// It takes the cutoff three times, the last for tokens.expires_at.
+const inactiveAccountsQuery = `
+	SELECT id, display_name, bio, homepage_url, created_at FROM accounts
+	WHERE created_at < ?
+		AND NOT EXISTS (SELECT 1 FROM account_keys WHERE account_id = accounts.id AND created_at >= ?)
+		AND NOT EXISTS (SELECT 1 FROM tokens WHERE account_id = accounts.id AND expires_at >= ?)
+		AND NOT EXISTS (
+			SELECT 1 FROM account_agents WHERE account_id = accounts.id AND (
+				EXISTS (SELECT 1 FROM stories WHERE agent_id = account_agents.agent_id)
+				OR EXISTS (SELECT 1 FROM comments WHERE agent_id = account_agents.agent_id)
+				OR EXISTS (SELECT 1 FROM votes WHERE agent_id = account_agents.agent_id)
+				OR EXISTS (SELECT 1 FROM flags WHERE agent_id = account_agents.agent_id)
+			)
+		)
+	ORDER BY created_at ASC, id ASC
+`
+
+// deleteAccountQueries remove an account and everything hanging off it
+var deleteAccountQueries = []string{
+	`DELETE FROM account_keys WHERE account_id = ?`,
+	`DELETE FROM tokens WHERE account_id = ?`,
+	`DELETE FROM account_agents WHERE account_id = ?`,
+	`DELETE FROM accounts WHERE id = ?`,
+}
 
-	var t Token
-	var accountID sql.NullString
-	err := row.Scan(&t.ID, &accountID, &t.KeyID, &t.AgentID, &t.Token, &t.ExpiresAt)
-	if err == sql.ErrNoRows {
-		return nil, nil
+// accountsForAgentsQuery lists the accounts a set of agents (the verb takes
+// their placeholders) have signed in to, oldest account first
+const accountsForAgentsQuery = `
+	SELECT account_agents.agent_id, accounts.id, accounts.display_name, accounts.created_at
+	FROM account_agents JOIN accounts ON accounts.id = account_agents.account_id
+	WHERE account_agents.agent_id IN (%s)
+	ORDER BY accounts.created_at ASC, accounts.id ASC
+`
+
+// accountKarmaQuery sums the scores of visible content by an account's agents;
+// the verb takes the backend's literal for false
+const accountKarmaQuery = `
+	SELECT
+		(SELECT COALESCE(SUM(score), 0) FROM stories
+			WHERE hidden = %[1]s AND agent_id IN (SELECT agent_id FROM account_agents WHERE account_id = ?))
+		+ (SELECT COALESCE(SUM(score), 0) FROM comments
+			WHERE hidden = %[1]s AND agent_id IN (SELECT agent_id FROM account_agents WHERE account_id = ?))
+`
+
+// topCommentsQuery picks the first visible comment on each of a set of
+// stories in a single pass. The verbs take the story id placeholders, the
+// backend's visibility predicate, and the comment ordering.
+const topCommentsQuery = `
+	SELECT ` + commentColumns + ` FROM (
+		SELECT *, ROW_NUMBER() OVER (PARTITION BY story_id ORDER BY %[3]s) AS position
+		FROM comments WHERE story_id IN (%[1]s) AND %[2]s
+	) ranked
+	WHERE position = 1
+`
+
+func commentsByStory(comments []*Comment) map[string]*Comment {
+	byStory := make(map[string]*Comment, len(comments))
+	for _, c := range comments {
+		byStory[c.StoryID] = c
 	}
-	if err != nil {
-		return nil, err
+	return byStory
+}
+
+// updateCommentCountQuery moves the comment count of the story a comment is on
+const updateCommentCountQuery = `
+	UPDATE stories SET comment_count = comment_count + ?
+	WHERE id = (SELECT story_id FROM comments WHERE id = ?)`
+
+// recomputeCommentCountQuery resets one story's comment count from the
+// comments table; the verb takes the backend's visible-comment predicate
+const recomputeCommentCountQuery = `
+	UPDATE stories SET comment_count = (SELECT COUNT(*) FROM comments WHERE story_id = stories.id AND %s)
+	WHERE id = ?
+	RETURNING comment_count`
+
+// recomputeStoryQuery rebuilds one story's counters from the votes and
+// comments tables; the verb takes the backend's visible-comment predicate
+const recomputeStoryQuery = `
+	UPDATE stories SET
+		score = (SELECT COALESCE(SUM(value * weight), 0) FROM votes WHERE target_type = 'story' AND target_id = stories.id),
+		upvotes = (SELECT COUNT(*) FROM votes WHERE target_type = 'story' AND target_id = stories.id AND value > 0),
+		downvotes = (SELECT COUNT(*) FROM votes WHERE target_type = 'story' AND target_id = stories.id AND value < 0),
+		comment_count = (SELECT COUNT(*) FROM comments WHERE story_id = stories.id AND %s)
+	WHERE id = ?
+	RETURNING ` + storyColumns
+
+// storyVoteTallyQuery counts votes on a story and on its visible comments
+const storyVoteTallyQuery = `
+	SELECT target_id,
+		COALESCE(SUM(CASE WHEN value > 0 THEN 1 ELSE 0 END), 0),
+		COALESCE(SUM(CASE WHEN value < 0 THEN 1 ELSE 0 END), 0)
+	FROM votes
+	WHERE (target_type = 'story' AND target_id = ?)
+		OR (target_type = 'comment' AND target_id IN (SELECT id FROM comments WHERE story_id = ? AND %s))
+	GROUP BY target_id
+`
+
+func scanVoteTallies(rows *sql.Rows) (map[string]VoteTally, error) {
+	defer rows.Close()
+
+	tallies := make(map[string]VoteTally)
+	for rows.Next() {
+		var id string
+		var tally VoteTally
+		if err := rows.Scan(&id, &tally.Up, &tally.Down); err != nil {
+			return nil, err
+		}
+		tallies[id] = tally
 	}
+	return tallies, rows.Err()
+}
 
-	t.AccountID = accountID.String
-	return &t, nil
+// schemaTables lists every table migrate creates
+var schemaTables = []string{
+	"stories", "comments", "votes", "accounts", "account_keys", "challenges", "tokens",
+	"flags", "account_agents", "story_tags", "idempotency_keys", "admin_tokens",
+	"audit_log", "token_revocations",
 }
 
-func (s *SQLiteStore) DeleteExpiredTokens(ctx context.Context) error {
-	_, err := s.db.ExecContext(ctx, `DELETE FROM tokens WHERE expires_at < datetime('now')`)
-	return err
+// checkSchema queries each of schemaTables, failing on the first that is missing
+func checkSchema(ctx context.Context, conn dbConn) error {
+	for _, table := range schemaTables {
+		rows, err := conn.QueryContext(ctx, `SELECT 1 FROM `+table+` LIMIT 0`)
+		if err != nil {
+			return fmt.Errorf("table %s: %w", table, err)
+		}
+		rows.Close()
+	}
+	return nil
 }
 
-// Helpers
+// voteTargetTable maps a vote's target type to the table holding its score
+func voteTargetTable(targetType string) (string, error) {
+	switch targetType {
+	case "story":
+		return "stories", nil
+	case "comment":
+		return "comments", nil
+	}
+	return "", fmt.Errorf("unknown vote target type %q", targetType)
+}
+
+// voteDelta is how far casting value with weight moves the target's score,
+// given the voter's existing vote (nil if none). A value of 0 retracts the
+// vote. A changed vote keeps the weight it was first cast with.
+func voteDelta(existing *Vote, value, weight int) int {
+	if existing == nil {
+		return value * weight
+	}
+	return (value - existing.Value) * existing.weight()
+}
 
 func nullString(s string) sql.NullString {
 	if s == "" {
@@ -610,93 +2529,139 @@ func boolToInt(b bool) int {
 	return 0
 }
 
-func scanStory(row *sql.Row) (*Story, error) {
-	var story Story
-	var url, text, tags, agentID sql.NullString
-	var hidden, agentVerified int
-
-	err := row.Scan(&story.ID, &story.Title, &url, &text, &tags, &story.Score,
-		&story.CommentCount, &story.CreatedAt, &hidden, &agentID, &agentVerified)
-	if err != nil {
-		return nil, err
+// decodeTags parses the stored tags column. A malformed value is logged and
+// treated as no tags so a single corrupt row doesn't break listings.
+func decodeTags(storyID string, tags sql.NullString) []string {
+	if !tags.Valid || tags.String == "" {
+		return nil
 	}
 
-	story.URL = url.String
-	story.Text = text.String
-	story.AgentID = agentID.String
-	story.Hidden = hidden == 1
-	story.AgentVerified = agentVerified == 1
-
-	if tags.Valid && tags.String != "" {
-		json.Unmarshal([]byte(tags.String), &story.Tags)
+	var decoded []string
+	if err := json.Unmarshal([]byte(tags.String), &decoded); err != nil {
+		log.Printf("store: story %s has malformed tags %q: %v", storyID, tags.String, err)
+		return nil
 	}
+	return decoded
+}
 
-	return &story, nil
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
 }
 
-func scanStoryRows(rows *sql.Rows) (*Story, error) {
+// Boolean columns are scanned into bool so the same helpers work for SQLite's
+// 0/1 integers and Postgres BOOLEAN columns.
+
+func scanStory(row rowScanner) (*Story, error) {
 	var story Story
-	var url, text, tags, agentID sql.NullString
-	var hidden, agentVerified int
+	var url, text, tags, agentID, sourceURL sql.NullString
 
-	err := rows.Scan(&story.ID, &story.Title, &url, &text, &tags, &story.Score,
-		&story.CommentCount, &story.CreatedAt, &hidden, &agentID, &agentVerified)
+	err := row.Scan(&story.ID, &story.Title, &url, &text, &tags, &story.Score, &story.Upvotes, &story.Downvotes,
+		&story.CommentCount, &story.CreatedAt, &story.Hidden, &agentID, &story.AgentVerified, &story.Pending, &sourceURL)
 	if err != nil {
 		return nil, err
 	}
 
 	story.URL = url.String
+	story.Type = storyType(story.URL)
+	story.SourceURL = sourceURL.String
 	story.Text = text.String
 	story.AgentID = agentID.String
-	story.Hidden = hidden == 1
-	story.AgentVerified = agentVerified == 1
-
-	if tags.Valid && tags.String != "" {
-		json.Unmarshal([]byte(tags.String), &story.Tags)
-	}
+	story.Tags = decodeTags(story.ID, tags)
+	story.CreatedAt = story.CreatedAt.UTC()
 
 	return &story, nil
 }
 
-func scanComment(row *sql.Row) (*Comment, error) {
+// scanStoryModified reads a row of ListStoriesModified: the story's id and
+// creation time, then its newest visible comment's, NULL if it has none
+func scanStoryModified(row rowScanner) (*StoryModified, error) {
+	var modified StoryModified
+	var commentedAt sql.NullTime
+	if err := row.Scan(&modified.ID, &modified.ModifiedAt, &commentedAt); err != nil {
+		return nil, err
+	}
+	if commentedAt.Valid && commentedAt.Time.After(modified.ModifiedAt) {
+		modified.ModifiedAt = commentedAt.Time
+	}
+	modified.ModifiedAt = modified.ModifiedAt.UTC()
+	return &modified, nil
+}
+
+func scanComment(row rowScanner) (*Comment, error) {
 	var comment Comment
 	var parentID, agentID sql.NullString
-	var hidden, agentVerified int
+	var editedAt sql.NullTime
 
-	err := row.Scan(&comment.ID, &comment.StoryID, &parentID, &comment.Text, &comment.Score,
-		&comment.CreatedAt, &hidden, &agentID, &agentVerified)
+	err := row.Scan(&comment.ID, &comment.StoryID, &parentID, &comment.Text, &comment.Score, &comment.Upvotes, &comment.Downvotes,
+		&comment.CreatedAt, &comment.Hidden, &agentID, &comment.AgentVerified, &editedAt, &comment.Depth)
 	if err != nil {
 		return nil, err
 	}
 
 	comment.ParentID = parentID.String
 	comment.AgentID = agentID.String
-	comment.Hidden = hidden == 1
-	comment.AgentVerified = agentVerified == 1
+	comment.CreatedAt = comment.CreatedAt.UTC()
+	if editedAt.Valid {
+		editedAt.Time = editedAt.Time.UTC()
+		comment.EditedAt = &editedAt.Time
+	}
 
 	return &comment, nil
 }
 
-func scanCommentRows(rows *sql.Rows) (*Comment, error) {
-	var comment Comment
-	var parentID, agentID sql.NullString
-	var hidden, agentVerified int
+func scanVote(row rowScanner) (*Vote, error) {
+	var vote Vote
+	var ipHash, agentID sql.NullString
+	var updatedAt sql.NullTime
 
-	err := rows.Scan(&comment.ID, &comment.StoryID, &parentID, &comment.Text, &comment.Score,
-		&comment.CreatedAt, &hidden, &agentID, &agentVerified)
+	err := row.Scan(&vote.ID, &vote.TargetType, &vote.TargetID, &vote.Value, &vote.Weight, &vote.CreatedAt,
+		&updatedAt, &ipHash, &agentID, &vote.AgentVerified)
 	if err != nil {
 		return nil, err
 	}
 
-	comment.ParentID = parentID.String
-	comment.AgentID = agentID.String
-	comment.Hidden = hidden == 1
-	comment.AgentVerified = agentVerified == 1
+	vote.IPHash = ipHash.String
+	vote.AgentID = agentID.String
+	vote.CreatedAt = vote.CreatedAt.UTC()
+	if updatedAt.Valid {
+		t := updatedAt.Time.UTC()
+		vote.UpdatedAt = &t
+	}
+	return &vote, nil
+}
 
-	return &comment, nil
+func scanFlag(row rowScanner) (*Flag, error) {
+	var flag Flag
+	var ipHash, agentID sql.NullString
+
+	err := row.Scan(&flag.ID, &flag.TargetType, &flag.TargetID, &flag.Reason, &flag.CreatedAt, &ipHash, &agentID)
+	if err != nil {
+		return nil, err
+	}
+
+	flag.IPHash = ipHash.String
+	flag.AgentID = agentID.String
+	flag.CreatedAt = flag.CreatedAt.UTC()
+	return &flag, nil
+}
+
+func scanAccount(row rowScanner) (*Account, error) {
+	var account Account
+	var bio, homepageURL sql.NullString
+
+	err := row.Scan(&account.ID, &account.DisplayName, &bio, &homepageURL, &account.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	account.Bio = bio.String
+	account.HomepageURL = homepageURL.String
+	account.CreatedAt = account.CreatedAt.UTC()
+	return &account, nil
 }
 
-func scanAccountKey(row *sql.Row) (*AccountKey, error) {
+func scanAccountKey(row rowScanner) (*AccountKey, error) {
 	var key AccountKey
 	var revokedAt sql.NullTime
 
@@ -705,12 +2670,127 @@ func scanAccountKey(row *sql.Row) (*AccountKey, error) {
 		return nil, err
 	}
 
+	key.CreatedAt = key.CreatedAt.UTC()
 	if revokedAt.Valid {
+		revokedAt.Time = revokedAt.Time.UTC()
 		key.RevokedAt = &revokedAt.Time
 	}
 
 	return &key, nil
 }
 
+func scanToken(row rowScanner) (*Token, error) {
+	var t Token
+	var accountID sql.NullString
+
+	err := row.Scan(&t.ID, &accountID, &t.KeyID, &t.AgentID, &t.Token, &t.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	t.AccountID = accountID.String
+	t.ExpiresAt = t.ExpiresAt.UTC()
+	return &t, nil
+}
+
+const idempotencyKeyColumns = "scope, idempotency_key, request_hash, resource_id, response, created_at, expires_at"
+
+func scanIdempotencyKey(row rowScanner) (*IdempotencyKey, error) {
+	var k IdempotencyKey
+	var resourceID sql.NullString
+	err := row.Scan(&k.Scope, &k.Key, &k.RequestHash, &resourceID, &k.Response, &k.CreatedAt, &k.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	k.ResourceID = resourceID.String
+	k.CreatedAt = k.CreatedAt.UTC()
+	k.ExpiresAt = k.ExpiresAt.UTC()
+	return &k, nil
+}
+
+const adminTokenColumns = "id, label, token_hash, scopes, created_at"
+
+const insertAdminTokenQuery = `
+	INSERT INTO admin_tokens (id, label, token_hash, scopes, created_at)
+	VALUES (?, ?, ?, ?, ?)
+`
+
+func scanAdminToken(row rowScanner) (*AdminToken, error) {
+	var t AdminToken
+	var label sql.NullString
+	var scopes string
+	err := row.Scan(&t.ID, &label, &t.TokenHash, &scopes, &t.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	t.Label = label.String
+	t.Scopes = strings.Fields(scopes)
+	t.CreatedAt = t.CreatedAt.UTC()
+	return &t, nil
+}
+
+const auditColumns = "id, action, target_type, target_id, actor, created_at"
+
+const insertAuditQuery = `
+	INSERT INTO audit_log (id, action, target_type, target_id, actor, created_at)
+	VALUES (?, ?, ?, ?, ?, ?)
+`
+
+// createdBefore returns the WHERE clause keeping rows created before the
+// cutoff, and its args; a zero cutoff keeps every row
+func createdBefore(before time.Time) (string, []any) {
+	if before.IsZero() {
+		return "", nil
+	}
+	return " WHERE created_at < ?", []any{before}
+}
+
+// scanRows scans every row with scan and closes rows
+func scanRows[T any](rows *sql.Rows, scan func(rowScanner) (*T, error)) ([]*T, error) {
+	defer rows.Close()
+
+	var items []*T
+	for rows.Next() {
+		item, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+func scanAuditEntries(rows *sql.Rows) ([]*AuditEntry, error) {
+	defer rows.Close()
+
+	var entries []*AuditEntry
+	for rows.Next() {
+		var entry AuditEntry
+		var targetType, targetID sql.NullString
+		if err := rows.Scan(&entry.ID, &entry.Action, &targetType, &targetID, &entry.Actor, &entry.CreatedAt); err != nil {
+			return nil, err
+		}
+		entry.TargetType = targetType.String
+		entry.TargetID = targetID.String
+		entry.CreatedAt = entry.CreatedAt.UTC()
+		entries = append(entries, &entry)
+	}
+	return entries, rows.Err()
+}
+
+func scanAdminTokens(rows *sql.Rows) ([]*AdminToken, error) {
+	defer rows.Close()
+
+	var tokens []*AdminToken
+	for rows.Next() {
+		t, err := scanAdminToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
 // Ensure SQLiteStore implements Store
 var _ Store = (*SQLiteStore)(nil)
@@ -1,8 +1,13 @@
 package store
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	"log"
 	"os"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 )
@@ -75,24 +80,73 @@ func TestStoryCreate(t *testing.T) {
 	}
 }
 
+func TestStoryCorruptTags(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	story := &Story{Title: "Corrupt Tags", Text: "Content", Tags: []string{"ok"}}
+	if err := store.CreateStory(ctx, story); err != nil {
+		t.Fatalf("failed to create story: %v", err)
+	}
+	if _, err := store.db.Exec(`UPDATE stories SET tags = ? WHERE id = ?`, `["ok",`, story.ID); err != nil {
+		t.Fatalf("failed to corrupt tags: %v", err)
+	}
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	fetched, err := store.GetStory(ctx, story.ID)
+	if err != nil {
+		t.Fatalf("failed to get story: %v", err)
+	}
+	if len(fetched.Tags) != 0 {
+		t.Errorf("tags = %v, want none for corrupt value", fetched.Tags)
+	}
+	if !strings.Contains(buf.String(), story.ID) {
+		t.Errorf("expected malformed tags to be logged, got %q", buf.String())
+	}
+}
+
+func TestStoryOversizedTags(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	story := &Story{Title: "Oversized Tags", Text: "Content", Tags: []string{strings.Repeat("x", maxTagsSize)}}
+	if err := store.CreateStory(ctx, story); err != ErrTagsTooLarge {
+		t.Fatalf("CreateStory error = %v, want ErrTagsTooLarge", err)
+	}
+	if fetched, _ := store.GetStory(ctx, story.ID); fetched != nil {
+		t.Error("story with oversized tags should not be stored")
+	}
+}
+
 func TestStoryList(t *testing.T) {
 	store, cleanup := setupTestDB(t)
 	defer cleanup()
 
 	ctx := context.Background()
 
-	// Create multiple stories
+	// Identical timestamps, so only the id tie-break decides the order
+	createdAt := time.Now().UTC().Truncate(time.Second)
+	var ids []string
 	for i := 0; i < 5; i++ {
 		story := &Story{
-			Title: "Test Story",
-			Text:  "Content",
-			Score: i * 10,
+			Title:     "Test Story",
+			Text:      "Content",
+			Score:     i * 10,
+			CreatedAt: createdAt,
 		}
 		if err := store.CreateStory(ctx, story); err != nil {
 			t.Fatalf("failed to create story %d: %v", i, err)
 		}
-		time.Sleep(10 * time.Millisecond) // Ensure different timestamps
+		ids = append(ids, story.ID)
 	}
+	sort.Sort(sort.Reverse(sort.StringSlice(ids)))
 
 	// Test listing
 	stories, cursor, err := store.ListStories(ctx, ListOptions{Sort: SortNew, Limit: 10})
@@ -101,19 +155,28 @@ func TestStoryList(t *testing.T) {
 	}
 
 	if len(stories) != 5 {
-		t.Errorf("expected 5 stories, got %d", len(stories))
+		t.Fatalf("expected 5 stories, got %d", len(stories))
 	}
 
 	if cursor != "" {
 		t.Errorf("expected no cursor for small result set, got %q", cursor)
 	}
 
-	// Verify sorted by newest first
-	for i := 1; i < len(stories); i++ {
-		if stories[i].CreatedAt.After(stories[i-1].CreatedAt) {
-			t.Errorf("stories not sorted by newest first")
+	// Verify newest first, ties broken by id descending
+	for i, story := range stories {
+		if story.ID != ids[i] {
+			t.Errorf("stories[%d] = %s, want %s", i, story.ID, ids[i])
 		}
 	}
+
+	// A limited page is a prefix of the full order
+	page, cursor, _ := store.ListStories(ctx, ListOptions{Sort: SortNew, Limit: 2})
+	if len(page) != 2 || page[0].ID != ids[0] || page[1].ID != ids[1] {
+		t.Errorf("first page does not match full order")
+	}
+	if cursor != ids[1] {
+		t.Errorf("cursor = %q, want %q", cursor, ids[1])
+	}
 }
 
 func TestStoryFindByURL(t *testing.T) {
@@ -156,6 +219,33 @@ func TestStoryFindByURL(t *testing.T) {
 	}
 }
 
+func TestURLKey(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://example.com/x", "https://example.com/x"},
+		{"https://example.com/x/", "https://example.com/x"},
+		{"http://example.com/x", "https://example.com/x"},
+		{"https://Example.COM:443/x", "https://example.com/x"},
+		{"http://example.com:80/x", "https://example.com/x"},
+		{"https://example.com:8443/x", "https://example.com:8443/x"},
+		{"https://example.com/x?utm_source=rss&utm_medium=feed", "https://example.com/x"},
+		{"https://example.com/x?b=2&fbclid=abc&a=1", "https://example.com/x?a=1&b=2"},
+		{"https://example.com/x#section", "https://example.com/x"},
+		{"https://example.com/", "https://example.com"},
+		{"https://example.com/Case", "https://example.com/Case"},
+		{"http://[::1]:80/x", "https://[::1]/x"},
+		{"not a url", "not a url"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := urlKey(tt.url); got != tt.want {
+			t.Errorf("urlKey(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
 func TestStoryScore(t *testing.T) {
 	store, cleanup := setupTestDB(t)
 	defer cleanup()
@@ -251,6 +341,78 @@ func TestCommentCreate(t *testing.T) {
 	}
 }
 
+func TestCommentUpdateText(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	story := &Story{Title: "Test", Text: "Content"}
+	store.CreateStory(ctx, story)
+
+	comment := &Comment{StoryID: story.ID, Text: "Before"}
+	store.CreateComment(ctx, comment)
+
+	fetched, _ := store.GetComment(ctx, comment.ID)
+	if fetched.EditedAt != nil {
+		t.Error("edited_at should be nil before any edit")
+	}
+
+	if err := store.UpdateCommentText(ctx, comment.ID, "After"); err != nil {
+		t.Fatalf("failed to update comment text: %v", err)
+	}
+
+	fetched, _ = store.GetComment(ctx, comment.ID)
+	if fetched.Text != "After" {
+		t.Errorf("text = %q, want %q", fetched.Text, "After")
+	}
+	if fetched.EditedAt == nil {
+		t.Error("edited_at should be set after edit")
+	}
+}
+
+func TestMigrateExistingDatabase(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	// Re-running migrations against an already-migrated database must be a no-op
+	if err := store.migrate(); err != nil {
+		t.Fatalf("second migrate failed: %v", err)
+	}
+}
+
+func TestMigrateBackfillsStoryTags(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	tagged := &Story{Title: "Tagged", Text: "One", Tags: []string{"Go", "news"}}
+	corrupt := &Story{Title: "Corrupt", Text: "Two", Tags: []string{"lost"}}
+	store.CreateStory(ctx, tagged)
+	store.CreateStory(ctx, corrupt)
+	store.CreateStory(ctx, &Story{Title: "Untagged", Text: "Three"})
+
+	// Stories from before story_tags existed have only their JSON column
+	if _, err := store.db.Exec(`UPDATE stories SET tags = ? WHERE id = ?`, `["lost",`, corrupt.ID); err != nil {
+		t.Fatalf("failed to corrupt tags: %v", err)
+	}
+	if _, err := store.db.Exec(`DELETE FROM story_tags`); err != nil {
+		t.Fatalf("failed to clear story_tags: %v", err)
+	}
+
+	if err := store.migrate(); err != nil {
+		t.Fatalf("migrate failed: %v", err)
+	}
+
+	tags, err := store.ListTags(ctx)
+	if err != nil {
+		t.Fatalf("ListTags: %v", err)
+	}
+	if want := []TagCount{{Tag: "go", Count: 1}, {Tag: "news", Count: 1}}; fmt.Sprint(tags) != fmt.Sprint(want) {
+		t.Errorf("ListTags after backfill = %v, want %v", tags, want)
+	}
+}
+
 func TestCommentTree(t *testing.T) {
 	store, cleanup := setupTestDB(t)
 	defer cleanup()
@@ -274,7 +436,7 @@ func TestCommentTree(t *testing.T) {
 	store.CreateComment(ctx, grandchild)
 
 	// Get tree view
-	comments, err := store.ListComments(ctx, story.ID, CommentListOptions{
+	comments, _, err := store.ListComments(ctx, story.ID, CommentListOptions{
 		Sort: SortTop,
 		View: ViewTree,
 	})
@@ -295,7 +457,7 @@ func TestCommentTree(t *testing.T) {
 	}
 
 	// Get flat view
-	flatComments, err := store.ListComments(ctx, story.ID, CommentListOptions{
+	flatComments, _, err := store.ListComments(ctx, story.ID, CommentListOptions{
 		Sort: SortTop,
 		View: ViewFlat,
 	})
@@ -308,6 +470,87 @@ func TestCommentTree(t *testing.T) {
 	}
 }
 
+func TestCommentDepth(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	story := &Story{Title: "Test", Text: "Content"}
+	store.CreateStory(ctx, story)
+	root := &Comment{StoryID: story.ID, Text: "Root"}
+	store.CreateComment(ctx, root)
+	child := &Comment{StoryID: story.ID, ParentID: root.ID, Text: "Child"}
+	store.CreateComment(ctx, child)
+	grandchild := &Comment{StoryID: story.ID, ParentID: child.ID, Text: "Grandchild"}
+	store.CreateComment(ctx, grandchild)
+
+	for want, c := range []*Comment{root, child, grandchild} {
+		if fetched, _ := store.GetComment(ctx, c.ID); fetched.Depth != want {
+			t.Errorf("%s depth = %d, want %d", c.Text, fetched.Depth, want)
+		}
+	}
+
+	// Replies written before depth was stored are backfilled on migrate
+	store.db.Exec(`UPDATE comments SET depth = 0`)
+	if err := store.migrate(); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if fetched, _ := store.GetComment(ctx, grandchild.ID); fetched.Depth != 2 {
+		t.Errorf("backfilled depth = %d, want 2", fetched.Depth)
+	}
+}
+
+func TestMigrateBackfillsVoteCounts(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	story := &Story{Title: "Test", Text: "Content"}
+	store.CreateStory(ctx, story)
+	comment := &Comment{StoryID: story.ID, Text: "Comment"}
+	store.CreateComment(ctx, comment)
+	for i, value := range []int{1, 1, -1} {
+		agentID := fmt.Sprintf("agent-%d", i)
+		store.CastVote(ctx, &Vote{TargetType: "story", TargetID: story.ID, Value: value, AgentID: agentID})
+		store.CastVote(ctx, &Vote{TargetType: "comment", TargetID: comment.ID, Value: -value, AgentID: agentID})
+	}
+
+	// Targets voted on before the counts were stored are counted on migrate
+	store.db.Exec(`UPDATE stories SET upvotes = 0, downvotes = 0`)
+	store.db.Exec(`UPDATE comments SET upvotes = 0, downvotes = 0`)
+	if err := store.migrate(); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if got, _ := store.GetStory(ctx, story.ID); got.Upvotes != 2 || got.Downvotes != 1 {
+		t.Errorf("backfilled story up, down = %d, %d; want 2, 1", got.Upvotes, got.Downvotes)
+	}
+	if got, _ := store.GetComment(ctx, comment.ID); got.Upvotes != 1 || got.Downvotes != 2 {
+		t.Errorf("backfilled comment up, down = %d, %d; want 1, 2", got.Upvotes, got.Downvotes)
+	}
+}
+
+func TestMigrateBackfillsURLKeys(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	story := &Story{Title: "Test", URL: "https://example.com/old/"}
+	store.CreateStory(ctx, story)
+
+	// Stories posted before url_key was stored are keyed on migrate
+	store.db.Exec(`UPDATE stories SET url_key = NULL`)
+	if err := store.migrate(); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	found, err := store.FindStoryByURL(ctx, "http://example.com/old", time.Now().Add(-time.Hour))
+	if err != nil || found == nil || found.ID != story.ID {
+		t.Errorf("FindStoryByURL after backfill = %v, %v; want %s", found, err, story.ID)
+	}
+}
+
 func TestVoteCreate(t *testing.T) {
 	store, cleanup := setupTestDB(t)
 	defer cleanup()
@@ -404,6 +647,66 @@ func TestAccountCreate(t *testing.T) {
 	}
 }
 
+func TestBuildCommentTreeCycles(t *testing.T) {
+	// root <- reply, plus a loop a -> b -> a and a comment that is its own parent
+	comments := []*Comment{
+		{ID: "root"},
+		{ID: "reply", ParentID: "root"},
+		{ID: "a", ParentID: "b"},
+		{ID: "b", ParentID: "a"},
+		{ID: "self", ParentID: "self"},
+	}
+
+	done := make(chan []*Comment)
+	go func() { done <- buildCommentTree(comments, "", 0) }()
+
+	var roots []*Comment
+	select {
+	case roots = <-done:
+	case <-time.After(time.Second):
+		t.Fatal("buildCommentTree did not return on cyclic input")
+	}
+
+	if len(roots) != 1 || roots[0].ID != "root" {
+		t.Fatalf("roots = %v, want just root", commentIDs(roots))
+	}
+	if got := commentIDs(roots[0].Children); len(got) != 1 || got[0] != "reply" {
+		t.Errorf("root children = %v, want [reply]", got)
+	}
+	for _, c := range comments[2:] {
+		for _, child := range c.Children {
+			t.Errorf("cyclic comment %s was given child %s", c.ID, child.ID)
+		}
+	}
+}
+
+func TestBuildCommentTreeMaxDepth(t *testing.T) {
+	var comments []*Comment
+	parent := ""
+	for i := 0; i < 5; i++ {
+		id := string(rune('a' + i))
+		comments = append(comments, &Comment{ID: id, ParentID: parent})
+		parent = id
+	}
+
+	roots := buildCommentTree(comments, "", 2)
+	depth := 0
+	for c := roots[0]; len(c.Children) > 0; c = c.Children[0] {
+		depth++
+	}
+	if depth != 2 {
+		t.Errorf("tree nested %d levels below the root, want 2", depth)
+	}
+}
+
+func commentIDs(comments []*Comment) []string {
+	ids := make([]string, len(comments))
+	for i, c := range comments {
+		ids[i] = c.ID
+	}
+	return ids
+}
+
 func TestAccountKeyCreate(t *testing.T) {
 	store, cleanup := setupTestDB(t)
 	defer cleanup()
@@ -520,6 +823,44 @@ func TestChallengeCreateAndGet(t *testing.T) {
 	}
 }
 
+func TestDeleteExpiredRows(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	store.CreateChallenge(ctx, &Challenge{AgentID: "a", Algorithm: "ed25519", Challenge: "live", ExpiresAt: time.Now().UTC().Add(time.Minute)})
+	store.CreateChallenge(ctx, &Challenge{AgentID: "a", Algorithm: "ed25519", Challenge: "stale", ExpiresAt: time.Now().UTC().Add(-time.Minute)})
+	store.CreateToken(ctx, &Token{KeyID: "k", AgentID: "a", Token: "live", ExpiresAt: time.Now().UTC().Add(time.Hour)})
+	store.CreateToken(ctx, &Token{KeyID: "k", AgentID: "a", Token: "stale", ExpiresAt: time.Now().UTC().Add(-time.Hour)})
+
+	if err := store.DeleteExpiredChallenges(ctx); err != nil {
+		t.Fatalf("DeleteExpiredChallenges: %v", err)
+	}
+	if err := store.DeleteExpiredTokens(ctx); err != nil {
+		t.Fatalf("DeleteExpiredTokens: %v", err)
+	}
+
+	// The getters already skip expired rows, so check the tables themselves
+	for _, query := range []string{`SELECT challenge FROM challenges`, `SELECT token FROM tokens`} {
+		var remaining []string
+		rows, err := store.db.Query(query)
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		for rows.Next() {
+			var value string
+			rows.Scan(&value)
+			remaining = append(remaining, value)
+		}
+		rows.Close()
+
+		if len(remaining) != 1 || remaining[0] != "live" {
+			t.Errorf("%s = %v, want only the live row", query, remaining)
+		}
+	}
+}
+
 func TestTokenCreateAndGet(t *testing.T) {
 	store, cleanup := setupTestDB(t)
 	defer cleanup()
@@ -547,3 +888,29 @@ func TestTokenCreateAndGet(t *testing.T) {
 		t.Errorf("agent_id mismatch: got %q, want %q", fetched.AgentID, token.AgentID)
 	}
 }
+
+func TestPingAndCheckSchema(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	if err := store.Ping(ctx); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+	if err := store.CheckSchema(ctx); err != nil {
+		t.Fatalf("CheckSchema on a migrated database: %v", err)
+	}
+
+	if _, err := store.db.Exec(`DROP TABLE admin_tokens`); err != nil {
+		t.Fatalf("failed to drop table: %v", err)
+	}
+	if err := store.CheckSchema(ctx); err == nil || !strings.Contains(err.Error(), "admin_tokens") {
+		t.Errorf("CheckSchema with a missing table = %v, want an error naming it", err)
+	}
+
+	store.Close()
+	if err := store.Ping(ctx); err == nil {
+		t.Error("Ping on a closed store should fail")
+	}
+}
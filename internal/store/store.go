@@ -11,27 +11,77 @@ type Store interface {
 	CreateStory(ctx context.Context, story *Story) error
 	GetStory(ctx context.Context, id string) (*Story, error)
 	ListStories(ctx context.Context, opts ListOptions) ([]*Story, string, error) // returns stories and next cursor
+	ListStoriesByAgent(ctx context.Context, agentID string, opts ListOptions) ([]*Story, string, error)
+	CountStories(ctx context.Context, opts ListOptions) (int, error)                        // visible stories ListStories would page through; only Tag and Type narrow the count
+	CountStoriesByAgent(ctx context.Context, agentID string, opts ListOptions) (int, error) // CountStories for ListStoriesByAgent
+	ListStoriesModified(ctx context.Context, offset, limit int) ([]*StoryModified, error)   // visible stories, oldest first, with when each page last changed; for sitemaps
 	FindStoryByURL(ctx context.Context, url string, since time.Time) (*Story, error)
+	FindStoryByText(ctx context.Context, text string, since time.Time) (*Story, error) // matches text posts whose body normalizes the same
 	GetLastStoryByAgent(ctx context.Context, agentID string) (*Story, error)
+	CreateStories(ctx context.Context, stories []*Story, urlSince, textSince time.Time) ([]StoryBatchResult, error) // stores a batch in one transaction, skipping reposts of visible stories (including earlier ones in the batch) newer than the cutoffs; a zero cutoff skips that check
+	CreateStoryWithComment(ctx context.Context, story *Story, comment *Comment) error                               // stores both in one transaction, the comment as a top-level reply to the story
 	UpdateStoryScore(ctx context.Context, id string, delta int) error
 	UpdateStoryCommentCount(ctx context.Context, id string, delta int) error
-	HideStory(ctx context.Context, id string) error
+	HideStory(ctx context.Context, id string) error // also rejects a pending story
+	UnhideStory(ctx context.Context, id string) error
+	GetHiddenStory(ctx context.Context, id string) (*Story, error) // nil unless the story is hidden and not pending; for moderators restoring it
+	GetPendingStory(ctx context.Context, id string) (*Story, error)
+	ListPendingStories(ctx context.Context, agentID string, opts ListOptions) ([]*Story, string, error)        // agentID "" lists the whole queue
+	ListOwnStories(ctx context.Context, agentID, accountID string, opts ListOptions) ([]*Story, string, error) // the agent's (or, with accountID, the account's agents') stories, hidden and pending included; newest first
+	ApproveStory(ctx context.Context, id string) (bool, error)
+	ListTags(ctx context.Context) ([]TagCount, error) // tags of visible stories, most used first
 
 	// Comments
 	CreateComment(ctx context.Context, comment *Comment) error
 	GetComment(ctx context.Context, id string) (*Comment, error)
-	ListComments(ctx context.Context, storyID string, opts CommentListOptions) ([]*Comment, error)
+	ListComments(ctx context.Context, storyID string, opts CommentListOptions) ([]*Comment, string, error)        // returns comments and next cursor
+	CountComments(ctx context.Context, storyID string) (int, error)                                               // the story's visible comments
+	ListReplies(ctx context.Context, parentID string, opts CommentListOptions) ([]*Comment, string, error)        // returns replies and next cursor
+	ListOwnComments(ctx context.Context, agentID, accountID string, opts ListOptions) ([]*Comment, string, error) // like ListOwnStories; only Limit and Cursor are used
+	TopComments(ctx context.Context, storyIDs []string) (map[string]*Comment, error)                              // each story's highest-scored visible comment, keyed by story
 	UpdateCommentScore(ctx context.Context, id string, delta int) error
-	HideComment(ctx context.Context, id string) error
+	UpdateCommentText(ctx context.Context, id, text string) error
+	HideComment(ctx context.Context, id string) error                      // also takes the comment off its story's comment count
+	UnhideComment(ctx context.Context, id string) error                    // also puts the comment back on its story's comment count
+	GetHiddenComment(ctx context.Context, id string) (*Comment, error)     // nil unless the comment is hidden
+	AnonymizeComment(ctx context.Context, id string, scrubText bool) error // tombstones the author (and text, if scrubText) but keeps the comment in its thread
 
 	// Votes
 	CreateVote(ctx context.Context, vote *Vote) error
-	GetVote(ctx context.Context, targetType, targetID, ipHash, agentID string) (*Vote, error)
+	GetVote(ctx context.Context, targetType, targetID, ipHash, agentID string) (*Vote, error) // agentID is a verified agent's, or "" to match anonymous votes from ipHash
 	UpdateVote(ctx context.Context, id string, value int) error
+	DeleteVote(ctx context.Context, id string) error
+	AdjustScore(ctx context.Context, targetType, targetID string, delta int) (int, error) // returns the new score
+	AdjustVoteCounts(ctx context.Context, targetType, targetID string, up, down int) error
+	CastVote(ctx context.Context, vote *Vote) (int, error) // records, changes, or (value 0) retracts a vote and adjusts the target's score in one transaction; returns the new score
+
+	RecomputeScore(ctx context.Context, targetType, targetID string) (int, error) // resets the score and vote counts from its votes; sql.ErrNoRows if the target is missing
+	RecomputeAllScores(ctx context.Context, targetType string) (int64, error)     // returns how many targets were corrected
+
+	RecomputeStory(ctx context.Context, id string) (*Story, error)          // resets a story's score, vote counts, and comment count from its votes and comments; sql.ErrNoRows if missing
+	RecomputeCommentCount(ctx context.Context, storyID string) (int, error) // resets a story's comment count to its visible comments; sql.ErrNoRows if missing
+
+	TallyStoryVotes(ctx context.Context, storyID string) (map[string]VoteTally, error) // keyed by target id: the story and each of its visible comments that has votes
+
+	// Flags
+	CreateFlag(ctx context.Context, flag *Flag) (int, error)   // records a flag unless its agent or IP already flagged the target; returns the target's distinct flag count
+	ListFlags(ctx context.Context, limit int) ([]*Flag, error) // newest first
+
+	// Audit log
+	LogAudit(ctx context.Context, entry *AuditEntry) error
+	ListAudit(ctx context.Context, before time.Time, limit int) ([]*AuditEntry, error)                // newest first, created before the cutoff; a zero cutoff starts from the newest
+	ListRecentContent(ctx context.Context, before time.Time, limit int) ([]*Story, []*Comment, error) // up to limit each of the newest stories and comments created before the cutoff, hidden and pending included
+
+	// Stats
+	CountContent(ctx context.Context) (*ContentCounts, error) // visible stories and comments, and all accounts
 
 	// Accounts
 	CreateAccount(ctx context.Context, account *Account) error
 	GetAccount(ctx context.Context, id string) (*Account, error)
+	FindAccountsByName(ctx context.Context, name string) ([]*Account, error)               // display name contains name, ignoring case; oldest first, at most 50
+	GetAccountKarma(ctx context.Context, accountID string) (int, error)                    // total score of visible stories and comments by the account's agents
+	AccountsForAgents(ctx context.Context, agentIDs []string) (map[string]*Account, error) // keyed by agent; only agents linked to an account appear. Accounts carry just ID, DisplayName, and CreatedAt
+	PruneInactiveAccounts(ctx context.Context, before time.Time) ([]*Account, error)       // deletes accounts created before the cutoff with no keys added or tokens live since and nothing posted; returns them
 
 	// Account Keys
 	CreateAccountKey(ctx context.Context, key *AccountKey) error
@@ -44,10 +94,32 @@ type Store interface {
 	CreateChallenge(ctx context.Context, challenge *Challenge) error
 	GetChallenge(ctx context.Context, challengeStr string) (*Challenge, error)
 	DeleteChallenge(ctx context.Context, id string) error
+	RecordChallengeFailure(ctx context.Context, id string) (int, error) // counts a refused signature; returns the new count
+	DeleteExpiredChallenges(ctx context.Context) error
+	CountActiveChallenges(ctx context.Context, agentID string) (int, error) // unexpired challenges issued to agentID
 	CreateToken(ctx context.Context, token *Token) error
 	GetToken(ctx context.Context, tokenStr string) (*Token, error)
-	DeleteExpiredTokens(ctx context.Context) error
+	DeleteExpiredTokens(ctx context.Context) error                                               // also drops lapsed token revocations
+	DeleteTokensForAgent(ctx context.Context, agentID string) (int64, error)                     // returns how many tokens were deleted
+	RevokeAgentTokens(ctx context.Context, agentID string, revokedAt, expiresAt time.Time) error // refuses agentID's JWTs issued at or before revokedAt until expiresAt
+	GetAgentRevocation(ctx context.Context, agentID string) (time.Time, error)                   // the latest unexpired revokedAt; zero if none
+
+	// Idempotency keys
+	GetIdempotencyKey(ctx context.Context, scope, key string) (*IdempotencyKey, error) // nil if the key is unused or expired
+	CreateIdempotencyKey(ctx context.Context, k *IdempotencyKey) error                 // keeps the first response if the key is already saved
+	DeleteExpiredIdempotencyKeys(ctx context.Context) error
+
+	// Admin tokens
+	CreateAdminToken(ctx context.Context, t *AdminToken) error
+	GetAdminToken(ctx context.Context, tokenHash string) (*AdminToken, error) // nil if no token has the hash
+	ListAdminTokens(ctx context.Context) ([]*AdminToken, error)               // oldest first
+	DeleteAdminToken(ctx context.Context, id string) (bool, error)            // false if there was no such token
+
+	// Transactions
+	WithTx(ctx context.Context, fn func(tx Store) error) error // commits fn's writes if it returns nil, otherwise rolls them all back; fn must use tx, not the outer store
 
 	// Lifecycle
+	Ping(ctx context.Context) error        // reports whether the database can be reached
+	CheckSchema(ctx context.Context) error // reports a table the migrations should have created but didn't
 	Close() error
 }
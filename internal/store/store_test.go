@@ -0,0 +1,2197 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The tests in this file exercise the Store interface and run against every
+// backend, so SQLiteStore and PostgresStore stay behaviorally identical.
+// The Postgres run needs SLASHCLAW_TEST_POSTGRES_URL pointing at a scratch
+// database; its tables are dropped before each test.
+
+func TestSQLiteStoreSuite(t *testing.T) {
+	runStoreSuite(t, func(t *testing.T) Store {
+		s, cleanup := setupTestDB(t)
+		t.Cleanup(cleanup)
+		return s
+	})
+}
+
+func TestPostgresStoreSuite(t *testing.T) {
+	url := os.Getenv("SLASHCLAW_TEST_POSTGRES_URL")
+	if url == "" {
+		t.Skip("SLASHCLAW_TEST_POSTGRES_URL not set")
+	}
+
+	runStoreSuite(t, func(t *testing.T) Store {
+		s, err := NewPostgresStore(url)
+		if err != nil {
+			t.Fatalf("failed to connect to postgres: %v", err)
+		}
+		_, err = s.db.Exec(`DROP TABLE IF EXISTS ` + strings.Join(schemaTables, ", ") + ` CASCADE`)
+		if err != nil {
+			t.Fatalf("failed to reset postgres: %v", err)
+		}
+		s.Close()
+
+		s, err = NewPostgresStore(url)
+		if err != nil {
+			t.Fatalf("failed to migrate postgres: %v", err)
+		}
+		t.Cleanup(func() { s.Close() })
+		return s
+	})
+}
+
+func runStoreSuite(t *testing.T, newStore func(t *testing.T) Store) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s Store)
+	}{
+		{"stories", suiteStories},
+		{"story listing", suiteStoryListing},
+		{"story ranking", suiteStoryRanking},
+		{"score floor", suiteScoreFloor},
+		{"discover", suiteDiscover},
+		{"stories by agent", suiteStoriesByAgent},
+		{"pending stories", suitePendingStories},
+		{"unhide", suiteUnhide},
+		{"comment count", suiteCommentCount},
+		{"own content", suiteOwnContent},
+		{"comments", suiteComments},
+		{"comment pagination", suiteCommentPagination},
+		{"comment sorts", suiteCommentSorts},
+		{"replies", suiteReplies},
+		{"anonymize comment", suiteAnonymizeComment},
+		{"top comments", suiteTopComments},
+		{"votes", suiteVotes},
+		{"cast vote", suiteCastVote},
+		{"weighted votes", suiteWeightedVotes},
+		{"vote counts", suiteVoteCounts},
+		{"voters sharing an IP", suiteVotersSharingIP},
+		{"transactions", suiteWithTx},
+		{"recompute scores", suiteRecomputeScores},
+		{"recompute story", suiteRecomputeStory},
+		{"tally story votes", suiteTallyStoryVotes},
+		{"flags", suiteFlags},
+		{"create stories", suiteCreateStories},
+		{"count content", suiteCountContent},
+		{"count listings", suiteCountListings},
+		{"tags", suiteTags},
+		{"story types", suiteStoryTypes},
+		{"stories modified", suiteStoriesModified},
+		{"create story with comment", suiteCreateStoryWithComment},
+		{"idempotency keys", suiteIdempotencyKeys},
+		{"admin tokens", suiteAdminTokens},
+		{"audit log", suiteAuditLog},
+		{"accounts", suiteAccounts},
+		{"find accounts by name", suiteFindAccountsByName},
+		{"prune inactive accounts", suitePruneInactiveAccounts},
+		{"accounts for agents", suiteAccountsForAgents},
+		{"account karma", suiteAccountKarma},
+		{"challenges and tokens", suiteChallengesAndTokens},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newStore(t))
+		})
+	}
+}
+
+func suiteStories(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	story := &Story{
+		Title:         "Suite Story",
+		URL:           "https://example.com/suite",
+		Tags:          []string{"a", "b"},
+		AgentID:       "suite-agent",
+		AgentVerified: true,
+	}
+	if err := s.CreateStory(ctx, story); err != nil {
+		t.Fatalf("CreateStory: %v", err)
+	}
+
+	got, err := s.GetStory(ctx, story.ID)
+	if err != nil || got == nil {
+		t.Fatalf("GetStory = %v, %v", got, err)
+	}
+	if got.Title != story.Title || got.URL != story.URL || len(got.Tags) != 2 || !got.AgentVerified {
+		t.Errorf("GetStory = %+v, want fields of %+v", got, story)
+	}
+
+	missing, err := s.GetStory(ctx, "missing")
+	if err != nil || missing != nil {
+		t.Errorf("GetStory(missing) = %v, %v; want nil, nil", missing, err)
+	}
+
+	found, err := s.FindStoryByURL(ctx, story.URL, time.Now().Add(-time.Hour))
+	if err != nil || found == nil || found.ID != story.ID {
+		t.Errorf("FindStoryByURL = %v, %v", found, err)
+	}
+
+	textPost := &Story{Title: "Text post", Text: "Some  body\ntext"}
+	s.CreateStory(ctx, textPost)
+	found, err = s.FindStoryByText(ctx, "some body TEXT", time.Now().Add(-time.Hour))
+	if err != nil || found == nil || found.ID != textPost.ID {
+		t.Errorf("FindStoryByText = %v, %v", found, err)
+	}
+	if found, _ := s.FindStoryByText(ctx, "some other text", time.Now().Add(-time.Hour)); found != nil {
+		t.Errorf("FindStoryByText(different text) = %v, want nil", found.ID)
+	}
+
+	last, err := s.GetLastStoryByAgent(ctx, "suite-agent")
+	if err != nil || last == nil || last.ID != story.ID {
+		t.Errorf("GetLastStoryByAgent = %v, %v", last, err)
+	}
+
+	s.UpdateStoryScore(ctx, story.ID, 3)
+	s.UpdateStoryCommentCount(ctx, story.ID, 2)
+	got, _ = s.GetStory(ctx, story.ID)
+	if got.Score != 3 || got.CommentCount != 2 {
+		t.Errorf("score, comment_count = %d, %d; want 3, 2", got.Score, got.CommentCount)
+	}
+
+	if err := s.HideStory(ctx, story.ID); err != nil {
+		t.Fatalf("HideStory: %v", err)
+	}
+	if got, _ := s.GetStory(ctx, story.ID); got != nil {
+		t.Error("hidden story should not be returned")
+	}
+}
+
+func suiteStoryListing(t *testing.T, s Store) {
+	ctx := context.Background()
+	base := time.Now().UTC().Add(-time.Hour)
+
+	for i, title := range []string{"Oldest story", "Middle story", "Newest story"} {
+		s.CreateStory(ctx, &Story{
+			Title:        title,
+			Text:         "Content",
+			Score:        i,
+			CommentCount: 2 - i,
+			CreatedAt:    base.Add(time.Duration(i) * time.Minute),
+		})
+	}
+
+	stories, _, err := s.ListStories(ctx, ListOptions{Sort: SortNew, Limit: 10})
+	if err != nil {
+		t.Fatalf("ListStories: %v", err)
+	}
+	if len(stories) != 3 || stories[0].Title != "Newest story" || stories[2].Title != "Oldest story" {
+		t.Errorf("new order = %v", storyTitles(stories))
+	}
+
+	stories, _, _ = s.ListStories(ctx, ListOptions{Sort: SortDiscussed, Limit: 10})
+	if len(stories) != 3 || stories[0].Title != "Oldest story" {
+		t.Errorf("discussed order = %v", storyTitles(stories))
+	}
+
+	stories, _, _ = s.ListStories(ctx, ListOptions{Sort: SortTop, Limit: 10})
+	if len(stories) != 3 || stories[0].Title != "Newest story" {
+		t.Errorf("top order = %v", storyTitles(stories))
+	}
+
+	stories, next, _ := s.ListStories(ctx, ListOptions{Sort: SortNew, Limit: 2})
+	if len(stories) != 2 || next == "" {
+		t.Errorf("limited list = %d stories, next %q", len(stories), next)
+	}
+
+	// Following the cursor continues each order where the last page stopped
+	for _, sort := range []SortOrder{SortNew, SortDiscussed, SortTop} {
+		all, _, _ := s.ListStories(ctx, ListOptions{Sort: sort, Limit: 10})
+		var paged []*Story
+		cursor := ""
+		for page := 0; page < 5; page++ {
+			stories, next, err := s.ListStories(ctx, ListOptions{Sort: sort, Limit: 2, Cursor: cursor})
+			if err != nil {
+				t.Fatalf("ListStories(%s, cursor %q): %v", sort, cursor, err)
+			}
+			paged = append(paged, stories...)
+			if next == "" {
+				break
+			}
+			cursor = next
+		}
+		if got, want := storyTitles(paged), storyTitles(all); !slices.Equal(got, want) {
+			t.Errorf("%s pages = %v, want %v", sort, got, want)
+		}
+	}
+}
+
+func suiteStoryRanking(t *testing.T, s Store) {
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	s.CreateStory(ctx, &Story{Title: "Old hit", Text: "Content", Score: 100, CreatedAt: now.Add(-48 * time.Hour)})
+	s.CreateStory(ctx, &Story{Title: "Fresh story", Text: "Content", Score: 10, CreatedAt: now.Add(-time.Hour)})
+	s.CreateStory(ctx, &Story{Title: "Older unvoted", Text: "Content", CreatedAt: now.Add(-3 * time.Hour)})
+	s.CreateStory(ctx, &Story{Title: "Newer unvoted", Text: "Content", CreatedAt: now.Add(-2 * time.Hour)})
+
+	// 10 / 3^1.5 ≈ 1.9 beats 100 / 50^1.5 ≈ 0.28; equal ranks fall back to newest first
+	stories, _, err := s.ListStories(ctx, ListOptions{Sort: SortTop, Limit: 10})
+	if err != nil {
+		t.Fatalf("ListStories: %v", err)
+	}
+	want := []string{"Fresh story", "Old hit", "Newer unvoted", "Older unvoted"}
+	if got := storyTitles(stories); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("top order = %v, want %v", got, want)
+	}
+
+	// With barely any gravity, raw score wins
+	stories, _, _ = s.ListStories(ctx, ListOptions{Sort: SortTop, Limit: 10, Gravity: 0.1})
+	if len(stories) == 0 || stories[0].Title != "Old hit" {
+		t.Errorf("low-gravity order = %v, want Old hit first", storyTitles(stories))
+	}
+}
+
+func suiteScoreFloor(t *testing.T, s Store) {
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	s.CreateStory(ctx, &Story{Title: "Buried old", Text: "Content", Score: -100, CreatedAt: now.Add(-10 * time.Hour)})
+	s.CreateStory(ctx, &Story{Title: "Sunk newer", Text: "Content", Score: -6, CreatedAt: now.Add(-5 * time.Hour)})
+	s.CreateStory(ctx, &Story{Title: "Liked", Text: "Content", Score: 3, CreatedAt: now.Add(-20 * time.Hour)})
+
+	// Unfloored, -100 / 12^1.5 ≈ -2.4 sinks below -6 / 7^1.5 ≈ -0.32
+	stories, _, err := s.ListStories(ctx, ListOptions{Sort: SortTop, Limit: 10})
+	if err != nil {
+		t.Fatalf("ListStories: %v", err)
+	}
+	want := []string{"Liked", "Sunk newer", "Buried old"}
+	if got := storyTitles(stories); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("unfloored order = %v, want %v", got, want)
+	}
+
+	// Floored at -5 both rank as -5, so the older one decays closer to zero
+	stories, _, err = s.ListStories(ctx, ListOptions{Sort: SortTop, Limit: 10, ScoreFloor: -5})
+	if err != nil {
+		t.Fatalf("ListStories with floor: %v", err)
+	}
+	want = []string{"Liked", "Buried old", "Sunk newer"}
+	if got := storyTitles(stories); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("floored order = %v, want %v", got, want)
+	}
+
+	// The floor only affects ranking, never the stored score
+	for _, story := range stories {
+		if story.Title == "Buried old" && story.Score != -100 {
+			t.Errorf("Buried old score = %d, want -100", story.Score)
+		}
+	}
+}
+
+func suiteDiscover(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		s.CreateStory(ctx, &Story{Title: fmt.Sprintf("Visible %d", i), Text: "Content", Score: i})
+	}
+	hidden := &Story{Title: "Hidden", Text: "Content", Score: 1000}
+	s.CreateStory(ctx, hidden)
+	s.HideStory(ctx, hidden.ID)
+	s.CreateStory(ctx, &Story{Title: "Pending", Text: "Content", Score: 1000, Hidden: true, Pending: true})
+
+	for seed := uint64(0); seed < 20; seed++ {
+		stories, next, err := s.ListStories(ctx, ListOptions{Sort: SortDiscover, Limit: 10, Seed: seed})
+		if err != nil {
+			t.Fatalf("ListStories: %v", err)
+		}
+		if len(stories) != 5 || next != "" {
+			t.Fatalf("discover = %v, next %q; want the 5 visible stories and no cursor", storyTitles(stories), next)
+		}
+		for _, story := range stories {
+			if story.Title == "Hidden" || story.Title == "Pending" {
+				t.Errorf("discover sampled %q", story.Title)
+			}
+		}
+	}
+
+	a, _, _ := s.ListStories(ctx, ListOptions{Sort: SortDiscover, Limit: 3, Seed: 7})
+	b, _, _ := s.ListStories(ctx, ListOptions{Sort: SortDiscover, Limit: 3, Seed: 7})
+	if fmt.Sprint(storyTitles(a)) != fmt.Sprint(storyTitles(b)) {
+		t.Errorf("same seed sampled %v then %v", storyTitles(a), storyTitles(b))
+	}
+}
+
+func suiteStoriesByAgent(t *testing.T, s Store) {
+	ctx := context.Background()
+	base := time.Now().UTC().Add(-time.Hour)
+
+	for i, agent := range []string{"agent-a", "agent-b", "agent-a", "agent-a"} {
+		s.CreateStory(ctx, &Story{
+			Title:     fmt.Sprintf("Story %d", i),
+			Text:      "Content",
+			AgentID:   agent,
+			CreatedAt: base.Add(time.Duration(i) * time.Minute),
+		})
+	}
+	hidden := &Story{Title: "Hidden", Text: "Content", AgentID: "agent-a"}
+	s.CreateStory(ctx, hidden)
+	s.HideStory(ctx, hidden.ID)
+
+	stories, _, err := s.ListStoriesByAgent(ctx, "agent-a", ListOptions{Sort: SortNew, Limit: 10})
+	if err != nil {
+		t.Fatalf("ListStoriesByAgent: %v", err)
+	}
+	if got := storyTitles(stories); len(got) != 3 || got[0] != "Story 3" || got[2] != "Story 0" {
+		t.Errorf("agent-a stories = %v, want [Story 3 Story 2 Story 0]", got)
+	}
+
+	stories, _, _ = s.ListStoriesByAgent(ctx, "agent-b", ListOptions{Sort: SortNew, Limit: 10})
+	if got := storyTitles(stories); len(got) != 1 || got[0] != "Story 1" {
+		t.Errorf("agent-b stories = %v, want [Story 1]", got)
+	}
+
+	stories, next, _ := s.ListStoriesByAgent(ctx, "agent-a", ListOptions{Sort: SortNew, Limit: 2})
+	if len(stories) != 2 || next == "" {
+		t.Errorf("limited list = %d stories, next %q", len(stories), next)
+	}
+
+	stories, _, _ = s.ListStoriesByAgent(ctx, "nobody", ListOptions{Limit: 10})
+	if len(stories) != 0 {
+		t.Errorf("unknown agent stories = %v, want none", storyTitles(stories))
+	}
+}
+
+func suitePendingStories(t *testing.T, s Store) {
+	ctx := context.Background()
+	base := time.Now().UTC().Add(-time.Hour)
+
+	var pending []*Story
+	for i, agent := range []string{"agent-a", "agent-b", "agent-a"} {
+		story := &Story{
+			Title:     fmt.Sprintf("Pending %d", i),
+			Text:      "Content",
+			AgentID:   agent,
+			Hidden:    true,
+			Pending:   true,
+			CreatedAt: base.Add(time.Duration(i) * time.Minute),
+		}
+		s.CreateStory(ctx, story)
+		pending = append(pending, story)
+	}
+	s.CreateStory(ctx, &Story{Title: "Published", Text: "Content", AgentID: "agent-a"})
+
+	if got, _ := s.GetStory(ctx, pending[0].ID); got != nil {
+		t.Error("GetStory returned a pending story")
+	}
+	got, err := s.GetPendingStory(ctx, pending[0].ID)
+	if err != nil || got == nil || !got.Pending {
+		t.Fatalf("GetPendingStory = %v, %v", got, err)
+	}
+
+	queue, next, err := s.ListPendingStories(ctx, "", ListOptions{Limit: 2})
+	if err != nil {
+		t.Fatalf("ListPendingStories: %v", err)
+	}
+	if got := storyTitles(queue); len(got) != 2 || got[0] != "Pending 0" || next == "" {
+		t.Errorf("queue page = %v (next %q), want oldest first", got, next)
+	}
+	rest, _, _ := s.ListPendingStories(ctx, "", ListOptions{Limit: 2, Cursor: next})
+	if got := storyTitles(rest); len(got) != 1 || got[0] != "Pending 2" {
+		t.Errorf("second queue page = %v, want [Pending 2]", got)
+	}
+
+	mine, _, _ := s.ListPendingStories(ctx, "agent-a", ListOptions{Limit: 10})
+	if len(mine) != 2 {
+		t.Errorf("agent-a pending = %v, want 2 stories", storyTitles(mine))
+	}
+
+	approved, err := s.ApproveStory(ctx, pending[0].ID)
+	if err != nil || !approved {
+		t.Fatalf("ApproveStory = %v, %v", approved, err)
+	}
+	if got, _ := s.GetStory(ctx, pending[0].ID); got == nil || got.Pending {
+		t.Errorf("approved story = %v, want published", got)
+	}
+	if again, _ := s.ApproveStory(ctx, pending[0].ID); again {
+		t.Error("ApproveStory of a published story reported true")
+	}
+
+	s.HideStory(ctx, pending[1].ID)
+	if got, _ := s.GetPendingStory(ctx, pending[1].ID); got != nil {
+		t.Error("hidden story is still pending")
+	}
+}
+
+func suiteUnhide(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	story := &Story{Title: "Restored", Text: "Content"}
+	s.CreateStory(ctx, story)
+	comment := &Comment{StoryID: story.ID, Text: "Restored comment"}
+	s.CreateComment(ctx, comment)
+	pending := &Story{Title: "Pending", Text: "Awaiting review", Hidden: true, Pending: true}
+	s.CreateStory(ctx, pending)
+
+	if got, _ := s.GetHiddenStory(ctx, story.ID); got != nil {
+		t.Error("GetHiddenStory returned a visible story")
+	}
+	if got, _ := s.GetHiddenStory(ctx, pending.ID); got != nil {
+		t.Error("GetHiddenStory returned a pending story")
+	}
+
+	s.HideStory(ctx, story.ID)
+	s.HideComment(ctx, comment.ID)
+	if got, err := s.GetHiddenStory(ctx, story.ID); err != nil || got == nil || got.Title != "Restored" {
+		t.Errorf("GetHiddenStory = %v, %v; want the hidden story", got, err)
+	}
+	if got, err := s.GetHiddenComment(ctx, comment.ID); err != nil || got == nil || got.Text != "Restored comment" {
+		t.Errorf("GetHiddenComment = %v, %v; want the hidden comment", got, err)
+	}
+
+	if err := s.UnhideStory(ctx, story.ID); err != nil {
+		t.Fatalf("UnhideStory: %v", err)
+	}
+	if err := s.UnhideComment(ctx, comment.ID); err != nil {
+		t.Fatalf("UnhideComment: %v", err)
+	}
+	if got, _ := s.GetStory(ctx, story.ID); got == nil {
+		t.Error("story still hidden after UnhideStory")
+	}
+	if got, _ := s.GetComment(ctx, comment.ID); got == nil {
+		t.Error("comment still hidden after UnhideComment")
+	}
+	if got, _ := s.GetHiddenComment(ctx, comment.ID); got != nil {
+		t.Error("GetHiddenComment returned a visible comment")
+	}
+
+	// A pending story is published by approval, not by unhiding
+	s.UnhideStory(ctx, pending.ID)
+	if got, _ := s.GetStory(ctx, pending.ID); got != nil {
+		t.Error("UnhideStory published a pending story")
+	}
+}
+
+func suiteCommentCount(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	story := &Story{Title: "Counted", Text: "Content"}
+	s.CreateStory(ctx, story)
+	comments := make([]*Comment, 3)
+	for i := range comments {
+		comments[i] = &Comment{StoryID: story.ID, Text: fmt.Sprintf("Comment %d", i)}
+		s.CreateComment(ctx, comments[i])
+		s.UpdateStoryCommentCount(ctx, story.ID, 1)
+	}
+	count := func() int {
+		t.Helper()
+		got, err := s.GetStory(ctx, story.ID)
+		if err != nil || got == nil {
+			t.Fatalf("GetStory = %v, %v", got, err)
+		}
+		return got.CommentCount
+	}
+
+	if err := s.HideComment(ctx, comments[0].ID); err != nil {
+		t.Fatalf("HideComment: %v", err)
+	}
+	if got := count(); got != 2 {
+		t.Errorf("comment count after hiding = %d, want 2", got)
+	}
+	s.HideComment(ctx, comments[0].ID)
+	if got := count(); got != 2 {
+		t.Errorf("comment count after hiding again = %d, want 2", got)
+	}
+	if err := s.UnhideComment(ctx, comments[0].ID); err != nil {
+		t.Fatalf("UnhideComment: %v", err)
+	}
+	s.UnhideComment(ctx, comments[0].ID)
+	if got := count(); got != 3 {
+		t.Errorf("comment count after unhiding = %d, want 3", got)
+	}
+
+	// A drifted count is repaired from the visible comments
+	s.HideComment(ctx, comments[1].ID)
+	s.UpdateStoryCommentCount(ctx, story.ID, 7)
+	if got, err := s.RecomputeCommentCount(ctx, story.ID); err != nil || got != 2 {
+		t.Errorf("RecomputeCommentCount = %d, %v; want 2", got, err)
+	}
+	if got := count(); got != 2 {
+		t.Errorf("comment count after recompute = %d, want 2", got)
+	}
+	if _, err := s.RecomputeCommentCount(ctx, "missing"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("RecomputeCommentCount(missing) error = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func suiteComments(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	story := &Story{Title: "Suite", Text: "Content"}
+	s.CreateStory(ctx, story)
+
+	root := &Comment{StoryID: story.ID, Text: "Root", AgentID: "a"}
+	if err := s.CreateComment(ctx, root); err != nil {
+		t.Fatalf("CreateComment: %v", err)
+	}
+	child := &Comment{StoryID: story.ID, ParentID: root.ID, Text: "Child"}
+	s.CreateComment(ctx, child)
+
+	got, err := s.GetComment(ctx, child.ID)
+	if err != nil || got == nil || got.ParentID != root.ID {
+		t.Fatalf("GetComment = %v, %v", got, err)
+	}
+
+	tree, _, err := s.ListComments(ctx, story.ID, CommentListOptions{Sort: SortTop, View: ViewTree})
+	if err != nil {
+		t.Fatalf("ListComments: %v", err)
+	}
+	if len(tree) != 1 || len(tree[0].Children) != 1 {
+		t.Errorf("tree = %d roots, want 1 root with 1 child", len(tree))
+	}
+
+	s.UpdateCommentScore(ctx, root.ID, 2)
+	if err := s.UpdateCommentText(ctx, root.ID, "Edited"); err != nil {
+		t.Fatalf("UpdateCommentText: %v", err)
+	}
+	got, _ = s.GetComment(ctx, root.ID)
+	if got.Score != 2 || got.Text != "Edited" || got.EditedAt == nil {
+		t.Errorf("after update = %+v", got)
+	}
+
+	s.HideComment(ctx, child.ID)
+	if got, _ := s.GetComment(ctx, child.ID); got != nil {
+		t.Error("hidden comment should not be returned")
+	}
+}
+
+func suiteAnonymizeComment(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	story := &Story{Title: "Suite", Text: "Content"}
+	s.CreateStory(ctx, story)
+
+	root := &Comment{StoryID: story.ID, Text: "Root", AgentID: "author", AgentVerified: true}
+	s.CreateComment(ctx, root)
+	reply := &Comment{StoryID: story.ID, ParentID: root.ID, Text: "Reply", AgentID: "replier", AgentVerified: true}
+	s.CreateComment(ctx, reply)
+	kept := &Comment{StoryID: story.ID, Text: "Kept", AgentID: "author", AgentVerified: true}
+	s.CreateComment(ctx, kept)
+
+	if err := s.AnonymizeComment(ctx, root.ID, true); err != nil {
+		t.Fatalf("AnonymizeComment: %v", err)
+	}
+	if err := s.AnonymizeComment(ctx, kept.ID, false); err != nil {
+		t.Fatalf("AnonymizeComment: %v", err)
+	}
+
+	got, _ := s.GetComment(ctx, root.ID)
+	if got == nil || got.AgentID != DeletedAgentID || got.AgentVerified || got.Text != DeletedText {
+		t.Errorf("scrubbed comment = %+v", got)
+	}
+	got, _ = s.GetComment(ctx, kept.ID)
+	if got == nil || got.AgentID != DeletedAgentID || got.AgentVerified || got.Text != "Kept" {
+		t.Errorf("anonymized comment = %+v", got)
+	}
+
+	tree, _, err := s.ListComments(ctx, story.ID, CommentListOptions{Sort: SortNew, View: ViewTree})
+	if err != nil {
+		t.Fatalf("ListComments: %v", err)
+	}
+	var found bool
+	for _, c := range tree {
+		if c.ID != root.ID {
+			continue
+		}
+		found = true
+		if len(c.Children) != 1 {
+			t.Fatalf("anonymized root has %d children, want 1", len(c.Children))
+		}
+		child := c.Children[0]
+		if child.ID != reply.ID || child.Text != "Reply" || child.AgentID != "replier" || !child.AgentVerified {
+			t.Errorf("reply = %+v, want it untouched", child)
+		}
+	}
+	if !found {
+		t.Error("anonymized comment should stay in the tree")
+	}
+}
+
+func suiteCommentPagination(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	story := &Story{Title: "Suite", Text: "Content"}
+	s.CreateStory(ctx, story)
+
+	base := time.Now().UTC().Add(-time.Hour)
+	for i := 0; i < 5; i++ {
+		s.CreateComment(ctx, &Comment{
+			StoryID:   story.ID,
+			Text:      string(rune('a' + i)),
+			Score:     i,
+			CreatedAt: base.Add(time.Duration(i) * time.Minute),
+		})
+	}
+
+	for _, sort := range []SortOrder{SortNew, SortTop} {
+		var texts string
+		cursor := ""
+		for page := 0; page < 5; page++ {
+			comments, next, err := s.ListComments(ctx, story.ID, CommentListOptions{
+				Sort: sort, View: ViewFlat, Limit: 2, Cursor: cursor,
+			})
+			if err != nil {
+				t.Fatalf("ListComments(%s): %v", sort, err)
+			}
+			for _, c := range comments {
+				texts += c.Text
+			}
+			if next == "" {
+				break
+			}
+			cursor = next
+		}
+		// Both orders put the latest, highest-scored comment first
+		if texts != "edcba" {
+			t.Errorf("%s pages = %q, want %q", sort, texts, "edcba")
+		}
+	}
+}
+
+func suiteCommentSorts(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	story := &Story{Title: "Suite", Text: "Content"}
+	s.CreateStory(ctx, story)
+
+	// Up and down votes for comments a to e, oldest first
+	votes := [][2]int{{2, 2}, {3, 0}, {1, 1}, {3, 3}, {0, 0}}
+	base := time.Now().UTC().Add(-time.Hour)
+	for i, v := range votes {
+		comment := &Comment{
+			StoryID:   story.ID,
+			Text:      string(rune('a' + i)),
+			CreatedAt: base.Add(time.Duration(i) * time.Minute),
+		}
+		s.CreateComment(ctx, comment)
+		for j := 0; j < v[0]+v[1]; j++ {
+			value := 1
+			if j >= v[0] {
+				value = -1
+			}
+			s.CastVote(ctx, &Vote{TargetType: "comment", TargetID: comment.ID, Value: value, AgentID: fmt.Sprintf("voter-%d", j)})
+		}
+	}
+
+	tests := []struct {
+		sort SortOrder
+		want string
+	}{
+		{SortOld, "abcde"},
+		{SortNew, "edcba"},
+		// Evenly split with the most votes first; ties on the smaller side go to the busier comment
+		{SortControversial, "dacbe"},
+	}
+	for _, tt := range tests {
+		var texts string
+		cursor := ""
+		for page := 0; page < 5; page++ {
+			comments, next, err := s.ListComments(ctx, story.ID, CommentListOptions{
+				Sort: tt.sort, View: ViewFlat, Limit: 2, Cursor: cursor,
+			})
+			if err != nil {
+				t.Fatalf("ListComments(%s): %v", tt.sort, err)
+			}
+			for _, c := range comments {
+				texts += c.Text
+			}
+			if next == "" {
+				break
+			}
+			cursor = next
+		}
+		if texts != tt.want {
+			t.Errorf("%s pages = %q, want %q", tt.sort, texts, tt.want)
+		}
+
+		tree, _, err := s.ListComments(ctx, story.ID, CommentListOptions{Sort: tt.sort, View: ViewTree})
+		if err != nil {
+			t.Fatalf("ListComments(%s, tree): %v", tt.sort, err)
+		}
+		texts = ""
+		for _, c := range tree {
+			texts += c.Text
+		}
+		if texts != tt.want {
+			t.Errorf("%s tree = %q, want %q", tt.sort, texts, tt.want)
+		}
+	}
+}
+
+func suiteReplies(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	story := &Story{Title: "Suite", Text: "Content"}
+	s.CreateStory(ctx, story)
+
+	parent := &Comment{StoryID: story.ID, Text: "Parent"}
+	s.CreateComment(ctx, parent)
+	other := &Comment{StoryID: story.ID, Text: "Other"}
+	s.CreateComment(ctx, other)
+	s.CreateComment(ctx, &Comment{StoryID: story.ID, ParentID: other.ID, Text: "x"})
+
+	base := time.Now().UTC().Add(-time.Hour)
+	var first *Comment
+	for i := 0; i < 5; i++ {
+		reply := &Comment{
+			StoryID:   story.ID,
+			ParentID:  parent.ID,
+			Text:      string(rune('a' + i)),
+			CreatedAt: base.Add(time.Duration(i) * time.Minute),
+		}
+		s.CreateComment(ctx, reply)
+		if i == 0 {
+			first = reply
+		}
+	}
+	s.CreateComment(ctx, &Comment{StoryID: story.ID, ParentID: first.ID, Text: "nested"})
+
+	var texts string
+	cursor := ""
+	for page := 0; page < 5; page++ {
+		replies, next, err := s.ListReplies(ctx, parent.ID, CommentListOptions{
+			Sort: SortNew, View: ViewFlat, Limit: 2, Cursor: cursor,
+		})
+		if err != nil {
+			t.Fatalf("ListReplies: %v", err)
+		}
+		for _, c := range replies {
+			if c.ParentID != parent.ID {
+				t.Errorf("reply %q has parent %q, want %q", c.Text, c.ParentID, parent.ID)
+			}
+			texts += c.Text
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if texts != "edcba" {
+		t.Errorf("flat reply pages = %q, want %q", texts, "edcba")
+	}
+
+	tree, next, err := s.ListReplies(ctx, parent.ID, CommentListOptions{Sort: SortNew, View: ViewTree, Limit: 10})
+	if err != nil {
+		t.Fatalf("ListReplies(tree): %v", err)
+	}
+	if len(tree) != 5 || next != "" {
+		t.Fatalf("tree replies = %d (next %q), want 5", len(tree), next)
+	}
+	if last := tree[4]; last.Text != "a" || len(last.Children) != 1 || last.Children[0].Text != "nested" {
+		t.Errorf("oldest reply = %q with %d children, want \"a\" with the nested reply", last.Text, len(last.Children))
+	}
+}
+
+func suiteVotes(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	vote := &Vote{TargetType: "story", TargetID: "s1", Value: 1, IPHash: "ip", AgentID: "agent"}
+	if err := s.CreateVote(ctx, vote); err != nil {
+		t.Fatalf("CreateVote: %v", err)
+	}
+
+	got, err := s.GetVote(ctx, "story", "s1", "ip", "agent")
+	if err != nil || got == nil || got.Value != 1 {
+		t.Fatalf("GetVote = %v, %v", got, err)
+	}
+
+	// Another agent or an anonymous voter on the same IP has not voted
+	for _, agentID := range []string{"other", ""} {
+		if other, err := s.GetVote(ctx, "story", "s1", "ip", agentID); err != nil || other != nil {
+			t.Errorf("GetVote(same IP, agent %q) = %v, %v; want nil, nil", agentID, other, err)
+		}
+	}
+
+	if got.UpdatedAt != nil || !got.ChangedAt().Equal(got.CreatedAt) {
+		t.Errorf("new vote updated_at = %v, want nil", got.UpdatedAt)
+	}
+
+	s.UpdateVote(ctx, vote.ID, -1)
+	got, _ = s.GetVote(ctx, "story", "s1", "other-ip", "agent")
+	if got == nil || got.Value != -1 {
+		t.Errorf("updated vote = %v, want value -1", got)
+	}
+	if got != nil && (got.UpdatedAt == nil || got.ChangedAt().Before(got.CreatedAt)) {
+		t.Errorf("updated vote updated_at = %v, want set after created_at %v", got.UpdatedAt, got.CreatedAt)
+	}
+
+	none, err := s.GetVote(ctx, "story", "s2", "ip", "agent")
+	if err != nil || none != nil {
+		t.Errorf("GetVote(no vote) = %v, %v; want nil, nil", none, err)
+	}
+
+	if err := s.DeleteVote(ctx, vote.ID); err != nil {
+		t.Fatalf("DeleteVote: %v", err)
+	}
+	got, err = s.GetVote(ctx, "story", "s1", "ip", "agent")
+	if err != nil || got != nil {
+		t.Errorf("GetVote(deleted) = %v, %v; want nil, nil", got, err)
+	}
+}
+
+func suiteCastVote(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	story := &Story{Title: "Suite", Text: "Content", Score: 10}
+	s.CreateStory(ctx, story)
+
+	cast := func(value int) int {
+		t.Helper()
+		score, err := s.CastVote(ctx, &Vote{TargetType: "story", TargetID: story.ID, Value: value, IPHash: "ip", AgentID: "agent"})
+		if err != nil {
+			t.Fatalf("CastVote(%d): %v", value, err)
+		}
+		return score
+	}
+
+	if score := cast(1); score != 11 {
+		t.Errorf("score after upvote = %d, want 11", score)
+	}
+	if score := cast(1); score != 11 {
+		t.Errorf("score after repeat upvote = %d, want 11", score)
+	}
+	if score := cast(-1); score != 9 {
+		t.Errorf("score after switching to downvote = %d, want 9", score)
+	}
+	if got, _ := s.GetVote(ctx, "story", story.ID, "ip", "agent"); got == nil || got.Value != -1 {
+		t.Errorf("vote after switch = %v, want value -1", got)
+	}
+	if score := cast(0); score != 10 {
+		t.Errorf("score after retract = %d, want 10", score)
+	}
+	if got, _ := s.GetVote(ctx, "story", story.ID, "ip", "agent"); got != nil {
+		t.Errorf("vote after retract = %v, want nil", got)
+	}
+	if score := cast(0); score != 10 {
+		t.Errorf("score after retracting nothing = %d, want 10", score)
+	}
+
+	comment := &Comment{StoryID: story.ID, Text: "Comment"}
+	s.CreateComment(ctx, comment)
+	score, err := s.CastVote(ctx, &Vote{TargetType: "comment", TargetID: comment.ID, Value: -1, IPHash: "ip"})
+	if err != nil || score != -1 {
+		t.Errorf("comment CastVote = %d, %v; want -1", score, err)
+	}
+
+	if _, err := s.CastVote(ctx, &Vote{TargetType: "user", TargetID: "x", Value: 1}); err == nil {
+		t.Error("CastVote with unknown target type should fail")
+	}
+}
+
+func suiteWeightedVotes(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	story := &Story{Title: "Suite", Text: "Content"}
+	s.CreateStory(ctx, story)
+
+	cast := func(agentID string, value, weight int) int {
+		t.Helper()
+		score, err := s.CastVote(ctx, &Vote{TargetType: "story", TargetID: story.ID, Value: value, Weight: weight, AgentID: agentID})
+		if err != nil {
+			t.Fatalf("CastVote(%s, %d): %v", agentID, value, err)
+		}
+		return score
+	}
+
+	if score := cast("heavy", 1, 3); score != 3 {
+		t.Errorf("score after weight 3 upvote = %d, want 3", score)
+	}
+	if score := cast("light", -1, 1); score != 2 {
+		t.Errorf("score after weight 1 downvote = %d, want 2", score)
+	}
+	if got, _ := s.GetVote(ctx, "story", story.ID, "", "heavy"); got == nil || got.Value != 1 || got.Weight != 3 {
+		t.Errorf("stored vote = %+v, want value 1 weight 3", got)
+	}
+	// A changed vote keeps the weight it was cast with
+	if score := cast("heavy", -1, 1); score != -4 {
+		t.Errorf("score after switching the weight 3 vote = %d, want -4", score)
+	}
+	if score := cast("heavy", 0, 1); score != -1 {
+		t.Errorf("score after retracting the weight 3 vote = %d, want -1", score)
+	}
+	if score := cast("heavy", 1, 2); score != 1 {
+		t.Errorf("score after weight 2 upvote = %d, want 1", score)
+	}
+
+	if score, err := s.RecomputeScore(ctx, "story", story.ID); err != nil || score != 1 {
+		t.Errorf("RecomputeScore = %d, %v; want 1", score, err)
+	}
+	updated, _ := s.GetStory(ctx, story.ID)
+	if updated.Upvotes != 1 || updated.Downvotes != 1 {
+		t.Errorf("upvotes, downvotes = %d, %d; want 1, 1 (counts aren't weighted)", updated.Upvotes, updated.Downvotes)
+	}
+}
+
+func suiteVoteCounts(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	story := &Story{Title: "Suite", Text: "Content"}
+	s.CreateStory(ctx, story)
+	comment := &Comment{StoryID: story.ID, Text: "Comment"}
+	s.CreateComment(ctx, comment)
+
+	for _, target := range []struct {
+		targetType string
+		id         string
+		get        func() (score, up, down int)
+	}{
+		{"story", story.ID, func() (int, int, int) {
+			got, _ := s.GetStory(ctx, story.ID)
+			return got.Score, got.Upvotes, got.Downvotes
+		}},
+		{"comment", comment.ID, func() (int, int, int) {
+			got, _ := s.GetComment(ctx, comment.ID)
+			return got.Score, got.Upvotes, got.Downvotes
+		}},
+	} {
+		cast := func(agentID string, value int) {
+			t.Helper()
+			if _, err := s.CastVote(ctx, &Vote{TargetType: target.targetType, TargetID: target.id, Value: value, IPHash: "ip", AgentID: agentID}); err != nil {
+				t.Fatalf("CastVote(%s, %d): %v", agentID, value, err)
+			}
+		}
+		check := func(step string, wantUp, wantDown int) {
+			t.Helper()
+			score, up, down := target.get()
+			if up != wantUp || down != wantDown {
+				t.Errorf("%s %s: up, down = %d, %d; want %d, %d", target.targetType, step, up, down, wantUp, wantDown)
+			}
+			if score != up-down {
+				t.Errorf("%s %s: score %d is not up %d minus down %d", target.targetType, step, score, up, down)
+			}
+		}
+
+		cast("a", 1)
+		cast("b", 1)
+		cast("c", -1)
+		check("after three votes", 2, 1)
+		cast("a", 1)
+		check("after a repeat vote", 2, 1)
+		cast("b", -1)
+		check("after switching a vote", 1, 2)
+		cast("c", 0)
+		check("after a retraction", 1, 1)
+
+		// Recomputing repairs counts that drifted from the votes
+		s.AdjustVoteCounts(ctx, target.targetType, target.id, 3, -1)
+		if _, err := s.RecomputeScore(ctx, target.targetType, target.id); err != nil {
+			t.Fatalf("RecomputeScore: %v", err)
+		}
+		check("after RecomputeScore", 1, 1)
+
+		s.AdjustVoteCounts(ctx, target.targetType, target.id, 0, 2)
+		if n, err := s.RecomputeAllScores(ctx, target.targetType); err != nil || n != 1 {
+			t.Errorf("RecomputeAllScores = %d, %v; want 1 corrected", n, err)
+		}
+		check("after RecomputeAllScores", 1, 1)
+	}
+
+	s.AdjustVoteCounts(ctx, "story", story.ID, 4, 4)
+	recomputed, err := s.RecomputeStory(ctx, story.ID)
+	if err != nil || recomputed.Upvotes != 1 || recomputed.Downvotes != 1 {
+		t.Errorf("RecomputeStory = %+v, %v; want 1 up and 1 down", recomputed, err)
+	}
+}
+
+func suiteWithTx(t *testing.T, s Store) {
+	ctx := context.Background()
+	errAbort := errors.New("abort")
+
+	// A failing composite operation leaves none of its writes behind
+	story := &Story{Title: "Rolled Back", Text: "Content"}
+	comment := &Comment{Text: "Also rolled back"}
+	err := s.WithTx(ctx, func(tx Store) error {
+		if err := tx.CreateStory(ctx, story); err != nil {
+			return err
+		}
+		comment.StoryID = story.ID
+		if err := tx.CreateComment(ctx, comment); err != nil {
+			return err
+		}
+		if _, err := tx.CastVote(ctx, &Vote{TargetType: "story", TargetID: story.ID, Value: 1, IPHash: "ip"}); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("WithTx = %v, want the callback's error", err)
+	}
+	if got, _ := s.GetStory(ctx, story.ID); got != nil {
+		t.Errorf("story after rollback = %v, want nil", got)
+	}
+	if got, _ := s.GetComment(ctx, comment.ID); got != nil {
+		t.Errorf("comment after rollback = %v, want nil", got)
+	}
+	if got, _ := s.GetVote(ctx, "story", story.ID, "ip", ""); got != nil {
+		t.Errorf("vote after rollback = %v, want nil", got)
+	}
+
+	// A comment and the count it adds to its story roll back together
+	counted := &Story{Title: "Counted", Text: "Content"}
+	s.CreateStory(ctx, counted)
+	uncounted := &Comment{StoryID: counted.ID, Text: "Never counted"}
+	err = s.WithTx(ctx, func(tx Store) error {
+		if err := tx.CreateComment(ctx, uncounted); err != nil {
+			return err
+		}
+		if err := tx.UpdateStoryCommentCount(ctx, counted.ID, 1); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("WithTx = %v, want the callback's error", err)
+	}
+	if got, _ := s.GetComment(ctx, uncounted.ID); got != nil {
+		t.Errorf("comment after rollback = %v, want nil", got)
+	}
+	if got, _ := s.GetStory(ctx, counted.ID); got == nil || got.CommentCount != 0 {
+		t.Errorf("story after rollback = %v, want comment count 0", got)
+	}
+
+	// A successful one commits everything
+	story = &Story{Title: "Committed", Text: "Content"}
+	err = s.WithTx(ctx, func(tx Store) error {
+		if err := tx.CreateStory(ctx, story); err != nil {
+			return err
+		}
+		_, err := tx.CastVote(ctx, &Vote{TargetType: "story", TargetID: story.ID, Value: 1, IPHash: "ip"})
+		return err
+	})
+	if err != nil {
+		t.Fatalf("WithTx: %v", err)
+	}
+	if got, _ := s.GetStory(ctx, story.ID); got == nil || got.Score != 1 {
+		t.Errorf("story after commit = %v, want score 1", got)
+	}
+
+	// Store methods that open their own transaction nest inside WithTx's
+	paired := &Story{Title: "Paired", Text: "Content"}
+	reply := &Comment{Text: "Reply"}
+	err = s.WithTx(ctx, func(tx Store) error {
+		return tx.CreateStoryWithComment(ctx, paired, reply)
+	})
+	if err != nil {
+		t.Fatalf("WithTx(CreateStoryWithComment): %v", err)
+	}
+	if got, _ := s.GetComment(ctx, reply.ID); got == nil || got.StoryID != paired.ID {
+		t.Errorf("comment after nested commit = %v, want one on %s", got, paired.ID)
+	}
+
+	// A vote whose score can't be applied is not recorded either
+	if _, err := s.CastVote(ctx, &Vote{TargetType: "story", TargetID: "missing", Value: 1, IPHash: "ip"}); err == nil {
+		t.Error("CastVote on a missing story should fail")
+	}
+	if got, _ := s.GetVote(ctx, "story", "missing", "ip", ""); got != nil {
+		t.Errorf("vote on missing story = %v, want nil", got)
+	}
+
+	// AdjustScore moves the score directly
+	if score, err := s.AdjustScore(ctx, "story", story.ID, 4); err != nil || score != 5 {
+		t.Errorf("AdjustScore = %d, %v; want 5", score, err)
+	}
+}
+
+func suiteVotersSharingIP(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	story := &Story{Title: "Shared IP", Text: "Content"}
+	s.CreateStory(ctx, story)
+
+	// An agent and an anonymous voter behind the same IP each get their own vote
+	if score, err := s.CastVote(ctx, &Vote{TargetType: "story", TargetID: story.ID, Value: 1, IPHash: "shared", AgentID: "agent"}); err != nil || score != 1 {
+		t.Fatalf("agent CastVote = %d, %v; want 1", score, err)
+	}
+	if score, err := s.CastVote(ctx, &Vote{TargetType: "story", TargetID: story.ID, Value: -1, IPHash: "shared"}); err != nil || score != 0 {
+		t.Fatalf("anonymous CastVote = %d, %v; want 0", score, err)
+	}
+
+	if got, _ := s.GetVote(ctx, "story", story.ID, "shared", "agent"); got == nil || got.Value != 1 {
+		t.Errorf("agent vote = %v, want value 1", got)
+	}
+	if got, _ := s.GetVote(ctx, "story", story.ID, "shared", ""); got == nil || got.Value != -1 || got.AgentID != "" {
+		t.Errorf("anonymous vote = %v, want value -1 with no agent", got)
+	}
+
+	// Retracting the anonymous vote leaves the agent's in place
+	if score, err := s.CastVote(ctx, &Vote{TargetType: "story", TargetID: story.ID, Value: 0, IPHash: "shared"}); err != nil || score != 1 {
+		t.Errorf("anonymous retract = %d, %v; want 1", score, err)
+	}
+	if got, _ := s.GetVote(ctx, "story", story.ID, "shared", "agent"); got == nil || got.Value != 1 {
+		t.Errorf("agent vote after anonymous retract = %v, want value 1", got)
+	}
+
+	// A second agent on the IP votes independently too
+	if score, err := s.CastVote(ctx, &Vote{TargetType: "story", TargetID: story.ID, Value: 1, IPHash: "shared", AgentID: "agent-2"}); err != nil || score != 2 {
+		t.Errorf("second agent CastVote = %d, %v; want 2", score, err)
+	}
+}
+
+func suiteRecomputeScores(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	drifted := &Story{Title: "Drifted", Text: "Content"}
+	s.CreateStory(ctx, drifted)
+	s.CastVote(ctx, &Vote{TargetType: "story", TargetID: drifted.ID, Value: 1, IPHash: "ip1"})
+	s.CastVote(ctx, &Vote{TargetType: "story", TargetID: drifted.ID, Value: 1, IPHash: "ip2"})
+	s.CastVote(ctx, &Vote{TargetType: "story", TargetID: drifted.ID, Value: -1, IPHash: "ip3"})
+	// Desync the running score from the votes
+	s.UpdateStoryScore(ctx, drifted.ID, 7)
+
+	unvoted := &Story{Title: "Unvoted", Text: "Content", Score: 3}
+	s.CreateStory(ctx, unvoted)
+	accurate := &Story{Title: "Accurate", Text: "Content"}
+	s.CreateStory(ctx, accurate)
+
+	score, err := s.RecomputeScore(ctx, "story", drifted.ID)
+	if err != nil || score != 1 {
+		t.Errorf("RecomputeScore = %d, %v; want 1", score, err)
+	}
+	if got, _ := s.GetStory(ctx, drifted.ID); got.Score != 1 {
+		t.Errorf("stored score = %d, want 1", got.Score)
+	}
+
+	s.UpdateStoryScore(ctx, drifted.ID, -4)
+	corrected, err := s.RecomputeAllScores(ctx, "story")
+	if err != nil {
+		t.Fatalf("RecomputeAllScores: %v", err)
+	}
+	if corrected != 2 {
+		t.Errorf("corrected = %d, want 2", corrected)
+	}
+	for id, want := range map[string]int{drifted.ID: 1, unvoted.ID: 0, accurate.ID: 0} {
+		if got, _ := s.GetStory(ctx, id); got.Score != want {
+			t.Errorf("%s score = %d, want %d", got.Title, got.Score, want)
+		}
+	}
+
+	comment := &Comment{StoryID: drifted.ID, Text: "Comment"}
+	s.CreateComment(ctx, comment)
+	s.UpdateCommentScore(ctx, comment.ID, 5)
+	if score, err := s.RecomputeScore(ctx, "comment", comment.ID); err != nil || score != 0 {
+		t.Errorf("comment RecomputeScore = %d, %v; want 0", score, err)
+	}
+
+	if _, err := s.RecomputeScore(ctx, "story", "missing"); err != sql.ErrNoRows {
+		t.Errorf("RecomputeScore(missing) error = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func suiteRecomputeStory(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	story := &Story{Title: "Corrupted", Text: "Content"}
+	s.CreateStory(ctx, story)
+	s.CastVote(ctx, &Vote{TargetType: "story", TargetID: story.ID, Value: 1, IPHash: "ip1"})
+	s.CastVote(ctx, &Vote{TargetType: "story", TargetID: story.ID, Value: 1, IPHash: "ip2"})
+	s.CreateComment(ctx, &Comment{StoryID: story.ID, Text: "Visible"})
+	hidden := &Comment{StoryID: story.ID, Text: "Hidden"}
+	s.CreateComment(ctx, hidden)
+	s.HideComment(ctx, hidden.ID)
+
+	bystander := &Story{Title: "Bystander", Text: "Content"}
+	s.CreateStory(ctx, bystander)
+
+	// Corrupt both stories' counters
+	s.UpdateStoryScore(ctx, story.ID, 40)
+	s.UpdateStoryCommentCount(ctx, story.ID, 9)
+	s.UpdateStoryScore(ctx, bystander.ID, 5)
+	s.UpdateStoryCommentCount(ctx, bystander.ID, 5)
+
+	got, err := s.RecomputeStory(ctx, story.ID)
+	if err != nil {
+		t.Fatalf("RecomputeStory: %v", err)
+	}
+	if got.Score != 2 || got.CommentCount != 1 {
+		t.Errorf("recomputed score, comment count = %d, %d; want 2, 1", got.Score, got.CommentCount)
+	}
+	if stored, _ := s.GetStory(ctx, story.ID); stored.Score != 2 || stored.CommentCount != 1 {
+		t.Errorf("stored score, comment count = %d, %d; want 2, 1", stored.Score, stored.CommentCount)
+	}
+	if other, _ := s.GetStory(ctx, bystander.ID); other.Score != 5 || other.CommentCount != 5 {
+		t.Errorf("bystander score, comment count = %d, %d; want it left at 5, 5", other.Score, other.CommentCount)
+	}
+
+	if _, err := s.RecomputeStory(ctx, "missing"); err != sql.ErrNoRows {
+		t.Errorf("RecomputeStory(missing) error = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func suiteFlags(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	story := &Story{Title: "Flagged", Text: "Content"}
+	s.CreateStory(ctx, story)
+
+	flag := func(agentID, ipHash string) int {
+		t.Helper()
+		count, err := s.CreateFlag(ctx, &Flag{TargetType: "story", TargetID: story.ID, Reason: "spam", AgentID: agentID, IPHash: ipHash})
+		if err != nil {
+			t.Fatalf("CreateFlag(%q, %q): %v", agentID, ipHash, err)
+		}
+		return count
+	}
+
+	if n := flag("agent-1", "ip1"); n != 1 {
+		t.Errorf("first flag count = %d, want 1", n)
+	}
+	if n := flag("agent-1", "ip2"); n != 1 {
+		t.Errorf("same agent, new IP count = %d, want 1", n)
+	}
+	if n := flag("agent-2", "ip1"); n != 1 {
+		t.Errorf("same IP, new agent count = %d, want 1", n)
+	}
+	if n := flag("", "ip3"); n != 2 {
+		t.Errorf("anonymous flag count = %d, want 2", n)
+	}
+	if n := flag("agent-3", "ip4"); n != 3 {
+		t.Errorf("third distinct flag count = %d, want 3", n)
+	}
+
+	// Flags on another target are counted separately
+	other := &Story{Title: "Other", Text: "Content"}
+	s.CreateStory(ctx, other)
+	if n, _ := s.CreateFlag(ctx, &Flag{TargetType: "story", TargetID: other.ID, Reason: "abuse", AgentID: "agent-1", IPHash: "ip1"}); n != 1 {
+		t.Errorf("other story count = %d, want 1", n)
+	}
+
+	flags, err := s.ListFlags(ctx, 10)
+	if err != nil {
+		t.Fatalf("ListFlags: %v", err)
+	}
+	if len(flags) != 4 {
+		t.Fatalf("ListFlags returned %d flags, want 4", len(flags))
+	}
+	if flags[0].TargetID != other.ID || flags[0].Reason != "abuse" || flags[0].AgentID != "agent-1" {
+		t.Errorf("newest flag = %+v, want the abuse flag on the other story", flags[0])
+	}
+	if limited, _ := s.ListFlags(ctx, 2); len(limited) != 2 {
+		t.Errorf("ListFlags(2) returned %d flags, want 2", len(limited))
+	}
+}
+
+func suiteCreateStories(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	old := &Story{Title: "Already here", URL: "https://example.com/old"}
+	s.CreateStory(ctx, old)
+
+	since := time.Now().Add(-time.Hour)
+	batch := []*Story{
+		{Title: "Fresh link", URL: "https://example.com/new"},
+		{Title: "Old link again", URL: "https://example.com/old"},
+		{Title: "Fresh link again", URL: "https://example.com/new"},
+		{Title: "Text post", Text: "Some body"},
+		{Title: "Same text", Text: "  some   BODY "},
+		{Title: "Big tags", Text: "Other body", Tags: []string{strings.Repeat("x", 2000)}},
+	}
+	results, err := s.CreateStories(ctx, batch, since, since)
+	if err != nil {
+		t.Fatalf("CreateStories: %v", err)
+	}
+	if len(results) != len(batch) {
+		t.Fatalf("CreateStories returned %d results, want %d", len(results), len(batch))
+	}
+
+	if results[0].Existing != nil || results[0].Err != nil || batch[0].ID == "" {
+		t.Errorf("fresh link result = %+v, want it stored", results[0])
+	}
+	if results[1].Existing == nil || results[1].Existing.ID != old.ID {
+		t.Errorf("old link result = %+v, want the existing story", results[1])
+	}
+	// Earlier stories in the batch count as reposts too
+	if results[2].Existing == nil || results[2].Existing.ID != batch[0].ID {
+		t.Errorf("repeated link result = %+v, want the first item", results[2])
+	}
+	if results[4].Existing == nil || results[4].Existing.ID != batch[3].ID {
+		t.Errorf("repeated text result = %+v, want the text post", results[4])
+	}
+	if results[5].Err != ErrTagsTooLarge {
+		t.Errorf("oversized tags error = %v, want ErrTagsTooLarge", results[5].Err)
+	}
+
+	for _, i := range []int{0, 3} {
+		if got, _ := s.GetStory(ctx, batch[i].ID); got == nil || got.Title != batch[i].Title {
+			t.Errorf("GetStory(%s) = %v, want the stored %q", batch[i].ID, got, batch[i].Title)
+		}
+	}
+	if got, _ := s.FindStoryByText(ctx, "other body", since); got != nil {
+		t.Errorf("story with oversized tags was stored: %+v", got)
+	}
+}
+
+func suiteCountContent(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	story := &Story{Title: "Counted", Text: "Content"}
+	s.CreateStory(ctx, story)
+	hidden := &Story{Title: "Hidden", Text: "Other"}
+	s.CreateStory(ctx, hidden)
+	s.HideStory(ctx, hidden.ID)
+
+	s.CreateComment(ctx, &Comment{StoryID: story.ID, Text: "Shown"})
+	hiddenComment := &Comment{StoryID: story.ID, Text: "Hidden"}
+	s.CreateComment(ctx, hiddenComment)
+	s.HideComment(ctx, hiddenComment.ID)
+
+	s.CreateAccount(ctx, &Account{DisplayName: "Counted Account"})
+
+	counts, err := s.CountContent(ctx)
+	if err != nil {
+		t.Fatalf("CountContent: %v", err)
+	}
+	if *counts != (ContentCounts{Stories: 1, Comments: 1, Accounts: 1}) {
+		t.Errorf("CountContent = %+v, want 1 of each", *counts)
+	}
+}
+
+func suiteCountListings(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	story := &Story{Title: "Counted", Text: "Content", Tags: []string{"go"}, AgentID: "agent-a"}
+	s.CreateStory(ctx, story)
+	s.CreateStory(ctx, &Story{Title: "Untagged", Text: "Other", AgentID: "agent-b"})
+	hidden := &Story{Title: "Hidden", Text: "Gone", Tags: []string{"go"}, AgentID: "agent-a"}
+	s.CreateStory(ctx, hidden)
+	s.HideStory(ctx, hidden.ID)
+
+	for i := range 3 {
+		s.CreateComment(ctx, &Comment{StoryID: story.ID, Text: fmt.Sprintf("Shown %d", i)})
+	}
+	hiddenComment := &Comment{StoryID: story.ID, Text: "Hidden"}
+	s.CreateComment(ctx, hiddenComment)
+	s.HideComment(ctx, hiddenComment.ID)
+
+	// Each count matches the rows its listing returns
+	listed, _, _ := s.ListStories(ctx, ListOptions{Limit: 100})
+	if n, err := s.CountStories(ctx, ListOptions{}); err != nil || n != len(listed) || n != 2 {
+		t.Errorf("CountStories = %d, %v; want 2 like ListStories", n, err)
+	}
+	tagged, _, _ := s.ListStories(ctx, ListOptions{Limit: 100, Tag: "Go"})
+	if n, _ := s.CountStories(ctx, ListOptions{Tag: "Go"}); n != len(tagged) || n != 1 {
+		t.Errorf("CountStories(tag go) = %d, want 1", n)
+	}
+	if n, _ := s.CountStoriesByAgent(ctx, "agent-a", ListOptions{}); n != 1 {
+		t.Errorf("CountStoriesByAgent = %d, want 1 (its hidden story excluded)", n)
+	}
+
+	comments, _, _ := s.ListComments(ctx, story.ID, CommentListOptions{View: ViewFlat, Limit: 100})
+	if n, err := s.CountComments(ctx, story.ID); err != nil || n != len(comments) || n != 3 {
+		t.Errorf("CountComments = %d, %v; want 3 like ListComments", n, err)
+	}
+	if n, _ := s.CountComments(ctx, "missing"); n != 0 {
+		t.Errorf("CountComments(missing) = %d, want 0", n)
+	}
+}
+
+func suiteTags(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	now := time.Now().UTC()
+	s.CreateStory(ctx, &Story{Title: "Go news", Text: "One", Tags: []string{"go", "News"}, AgentID: "agent-a", CreatedAt: now.Add(-3 * time.Hour)})
+	s.CreateStory(ctx, &Story{Title: "More Go", Text: "Two", Tags: []string{" Go ", "go"}, AgentID: "agent-b", CreatedAt: now.Add(-2 * time.Hour)})
+	s.CreateStories(ctx, []*Story{{Title: "Batched", Text: "Three", Tags: []string{"news"}, AgentID: "agent-a", CreatedAt: now.Add(-time.Hour)}}, time.Time{}, time.Time{})
+	hidden := &Story{Title: "Hidden", Text: "Four", Tags: []string{"go", "secret"}}
+	s.CreateStory(ctx, hidden)
+	s.HideStory(ctx, hidden.ID)
+
+	tags, err := s.ListTags(ctx)
+	if err != nil {
+		t.Fatalf("ListTags: %v", err)
+	}
+	want := []TagCount{{Tag: "go", Count: 2}, {Tag: "news", Count: 2}}
+	if fmt.Sprint(tags) != fmt.Sprint(want) {
+		t.Errorf("ListTags = %v, want %v", tags, want)
+	}
+
+	titles := func(stories []*Story) []string {
+		var got []string
+		for _, story := range stories {
+			got = append(got, story.Title)
+		}
+		return got
+	}
+
+	stories, _, err := s.ListStories(ctx, ListOptions{Sort: SortNew, Tag: "GO"})
+	if err != nil {
+		t.Fatalf("ListStories(tag): %v", err)
+	}
+	if got := titles(stories); fmt.Sprint(got) != "[More Go Go news]" {
+		t.Errorf("stories tagged go = %v, want [More Go Go news]", got)
+	}
+
+	stories, _, _ = s.ListStoriesByAgent(ctx, "agent-a", ListOptions{Sort: SortNew, Tag: "news"})
+	if got := titles(stories); fmt.Sprint(got) != "[Batched Go news]" {
+		t.Errorf("agent-a stories tagged news = %v, want [Batched Go news]", got)
+	}
+
+	if stories, _, _ := s.ListStories(ctx, ListOptions{Tag: "secret"}); len(stories) != 0 {
+		t.Errorf("hidden story's tag listed %d stories, want 0", len(stories))
+	}
+}
+
+func suiteStoryTypes(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	now := time.Now().UTC()
+	link := &Story{Title: "A link", URL: "https://example.com/types", AgentID: "agent-a", CreatedAt: now.Add(-2 * time.Hour)}
+	text := &Story{Title: "A text post", Text: "Just words", AgentID: "agent-a", CreatedAt: now.Add(-time.Hour)}
+	s.CreateStory(ctx, link)
+	s.CreateStory(ctx, text)
+	s.CreateStories(ctx, []*Story{{Title: "Batched link", URL: "https://example.com/batched", Text: "With a note", AgentID: "agent-b", CreatedAt: now}}, time.Time{}, time.Time{})
+
+	for id, want := range map[string]StoryType{link.ID: StoryLink, text.ID: StoryText} {
+		got, err := s.GetStory(ctx, id)
+		if err != nil || got == nil {
+			t.Fatalf("GetStory(%s) = %v, %v", id, got, err)
+		}
+		if got.Type != want {
+			t.Errorf("%q type = %q, want %q", got.Title, got.Type, want)
+		}
+	}
+
+	titles := func(stories []*Story) string {
+		var got []string
+		for _, story := range stories {
+			got = append(got, story.Title)
+		}
+		return fmt.Sprint(got)
+	}
+	for _, tt := range []struct {
+		storyType StoryType
+		want      string
+	}{
+		{StoryLink, "[Batched link A link]"},
+		{StoryText, "[A text post]"},
+		{"", "[Batched link A text post A link]"},
+	} {
+		opts := ListOptions{Sort: SortNew, Type: tt.storyType}
+		stories, _, err := s.ListStories(ctx, opts)
+		if err != nil {
+			t.Fatalf("ListStories(%q): %v", tt.storyType, err)
+		}
+		if got := titles(stories); got != tt.want {
+			t.Errorf("%q stories = %s, want %s", tt.storyType, got, tt.want)
+		}
+		if count, _ := s.CountStories(ctx, opts); count != len(stories) {
+			t.Errorf("CountStories(%q) = %d, want %d", tt.storyType, count, len(stories))
+		}
+	}
+
+	stories, _, _ := s.ListStoriesByAgent(ctx, "agent-a", ListOptions{Sort: SortNew, Type: StoryLink})
+	if got := titles(stories); got != "[A link]" {
+		t.Errorf("agent-a link stories = %s, want [A link]", got)
+	}
+}
+
+func suiteStoriesModified(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	old := &Story{Title: "Old but discussed", Text: "First", CreatedAt: now.Add(-3 * time.Hour)}
+	quiet := &Story{Title: "Quiet story", Text: "Second", CreatedAt: now.Add(-2 * time.Hour)}
+	hidden := &Story{Title: "Hidden story", Text: "Third", CreatedAt: now.Add(-time.Hour)}
+	for _, story := range []*Story{old, quiet, hidden} {
+		s.CreateStory(ctx, story)
+	}
+	s.HideStory(ctx, hidden.ID)
+	s.CreateComment(ctx, &Comment{StoryID: old.ID, Text: "Earlier reply", CreatedAt: now.Add(-150 * time.Minute)})
+	s.CreateComment(ctx, &Comment{StoryID: old.ID, Text: "Latest reply", CreatedAt: now.Add(-30 * time.Minute)})
+	removed := &Comment{StoryID: quiet.ID, Text: "Removed reply", CreatedAt: now}
+	s.CreateComment(ctx, removed)
+	s.HideComment(ctx, removed.ID)
+
+	modified, err := s.ListStoriesModified(ctx, 0, 10)
+	if err != nil {
+		t.Fatalf("ListStoriesModified: %v", err)
+	}
+	want := []StoryModified{{old.ID, now.Add(-30 * time.Minute)}, {quiet.ID, now.Add(-2 * time.Hour)}}
+	if len(modified) != len(want) {
+		t.Fatalf("ListStoriesModified returned %d stories, want %d", len(modified), len(want))
+	}
+	for i, m := range modified {
+		if m.ID != want[i].ID || !m.ModifiedAt.Equal(want[i].ModifiedAt) {
+			t.Errorf("story %d = %s at %v, want %s at %v", i, m.ID, m.ModifiedAt, want[i].ID, want[i].ModifiedAt)
+		}
+	}
+
+	if page, _ := s.ListStoriesModified(ctx, 1, 10); len(page) != 1 || page[0].ID != quiet.ID {
+		t.Errorf("ListStoriesModified from offset 1 = %v, want just the quiet story", page)
+	}
+}
+
+func suiteCreateStoryWithComment(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	story := &Story{Title: "Ask: favourite tools?", Text: "Discuss", Tags: []string{"ask"}, AgentID: "asker"}
+	comment := &Comment{Text: "I'll start: grep", AgentID: "asker"}
+	if err := s.CreateStoryWithComment(ctx, story, comment); err != nil {
+		t.Fatalf("CreateStoryWithComment: %v", err)
+	}
+
+	got, err := s.GetStory(ctx, story.ID)
+	if err != nil || got == nil {
+		t.Fatalf("GetStory = %v, %v", got, err)
+	}
+	if got.CommentCount != 1 {
+		t.Errorf("comment count = %d, want 1", got.CommentCount)
+	}
+	stored, err := s.GetComment(ctx, comment.ID)
+	if err != nil || stored == nil {
+		t.Fatalf("GetComment = %v, %v", stored, err)
+	}
+	if stored.StoryID != story.ID || stored.ParentID != "" || stored.Text != comment.Text {
+		t.Errorf("comment = %+v, want a top-level comment on %s", stored, story.ID)
+	}
+	if tags, _ := s.ListTags(ctx); len(tags) != 1 || tags[0].Tag != "ask" {
+		t.Errorf("ListTags = %v, want the story's tag", tags)
+	}
+
+	// A comment that can't be stored takes the story down with it
+	failing := &Story{Title: "Never stored", Text: "Rolled back"}
+	if err := s.CreateStoryWithComment(ctx, failing, &Comment{ID: comment.ID, Text: "Clashing id"}); err == nil {
+		t.Fatal("CreateStoryWithComment with a duplicate comment id succeeded")
+	}
+	if got, _ := s.GetStory(ctx, failing.ID); got != nil {
+		t.Errorf("story %s was stored although its comment failed", failing.ID)
+	}
+}
+
+func suiteAuditLog(t *testing.T, s Store) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+
+	hide := &AuditEntry{Action: AuditHide, TargetType: "story", TargetID: "story-1", Actor: "secret", CreatedAt: now.Add(-2 * time.Minute)}
+	recompute := &AuditEntry{Action: AuditRecompute, Actor: "token:abc", CreatedAt: now.Add(-time.Minute)}
+	for _, entry := range []*AuditEntry{hide, recompute} {
+		if err := s.LogAudit(ctx, entry); err != nil {
+			t.Fatalf("LogAudit: %v", err)
+		}
+	}
+
+	entries, err := s.ListAudit(ctx, time.Time{}, 10)
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("ListAudit = %v, %v; want both entries", entries, err)
+	}
+	if entries[0].ID != recompute.ID || entries[1].ID != hide.ID {
+		t.Errorf("ListAudit order = %s, %s; want newest first", entries[0].Action, entries[1].Action)
+	}
+	if got := entries[1]; got.TargetType != "story" || got.TargetID != "story-1" || got.Actor != "secret" || !got.CreatedAt.Equal(hide.CreatedAt) {
+		t.Errorf("hide entry = %+v, want %+v", got, hide)
+	}
+	if got := entries[0]; got.TargetType != "" || got.TargetID != "" {
+		t.Errorf("recompute entry target = %q %q, want none", got.TargetType, got.TargetID)
+	}
+
+	if older, _ := s.ListAudit(ctx, recompute.CreatedAt, 10); len(older) != 1 || older[0].ID != hide.ID {
+		t.Errorf("ListAudit before the newest = %v, want only the hide", older)
+	}
+	if limited, _ := s.ListAudit(ctx, time.Time{}, 1); len(limited) != 1 || limited[0].ID != recompute.ID {
+		t.Errorf("ListAudit limit 1 = %v, want the newest", limited)
+	}
+
+	// Recent content includes what visitors can't see
+	visible := &Story{Title: "Visible story", Text: "one", CreatedAt: now.Add(-3 * time.Minute)}
+	hidden := &Story{Title: "Hidden story", Text: "two", CreatedAt: now.Add(-2 * time.Minute)}
+	for _, story := range []*Story{visible, hidden} {
+		if err := s.CreateStory(ctx, story); err != nil {
+			t.Fatalf("CreateStory: %v", err)
+		}
+	}
+	s.HideStory(ctx, hidden.ID)
+	comment := &Comment{StoryID: visible.ID, Text: "a comment", CreatedAt: now.Add(-time.Minute)}
+	if err := s.CreateComment(ctx, comment); err != nil {
+		t.Fatalf("CreateComment: %v", err)
+	}
+
+	stories, comments, err := s.ListRecentContent(ctx, time.Time{}, 10)
+	if err != nil {
+		t.Fatalf("ListRecentContent: %v", err)
+	}
+	if len(stories) != 2 || stories[0].ID != hidden.ID || !stories[0].Hidden || stories[1].ID != visible.ID {
+		t.Errorf("stories = %v, want the hidden then the visible story", stories)
+	}
+	if len(comments) != 1 || comments[0].ID != comment.ID {
+		t.Errorf("comments = %v, want the comment", comments)
+	}
+	if stories, comments, _ := s.ListRecentContent(ctx, hidden.CreatedAt, 10); len(stories) != 1 || stories[0].ID != visible.ID || len(comments) != 0 {
+		t.Errorf("ListRecentContent before the hidden story = %v, %v; want only the visible story", stories, comments)
+	}
+}
+
+func suiteAdminTokens(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	first := &AdminToken{Label: "triage", TokenHash: "hash-1", Scopes: []string{"flags:read", "hide"}}
+	if err := s.CreateAdminToken(ctx, first); err != nil {
+		t.Fatalf("CreateAdminToken: %v", err)
+	}
+	second := &AdminToken{TokenHash: "hash-2", Scopes: []string{"recompute"}, CreatedAt: first.CreatedAt.Add(time.Second)}
+	if err := s.CreateAdminToken(ctx, second); err != nil {
+		t.Fatalf("CreateAdminToken: %v", err)
+	}
+	if err := s.CreateAdminToken(ctx, &AdminToken{TokenHash: "hash-1", Scopes: []string{"hide"}}); err == nil {
+		t.Error("a duplicate token hash should be rejected")
+	}
+
+	got, err := s.GetAdminToken(ctx, "hash-1")
+	if err != nil || got == nil {
+		t.Fatalf("GetAdminToken = %v, %v", got, err)
+	}
+	if got.ID != first.ID || got.Label != "triage" || fmt.Sprint(got.Scopes) != "[flags:read hide]" {
+		t.Errorf("GetAdminToken = %+v, want %+v", got, first)
+	}
+	if missing, err := s.GetAdminToken(ctx, "nope"); err != nil || missing != nil {
+		t.Errorf("GetAdminToken(unknown) = %v, %v; want nil, nil", missing, err)
+	}
+
+	tokens, err := s.ListAdminTokens(ctx)
+	if err != nil || len(tokens) != 2 || tokens[0].ID != first.ID || tokens[1].ID != second.ID {
+		t.Fatalf("ListAdminTokens = %v, %v; want both, oldest first", tokens, err)
+	}
+
+	if found, err := s.DeleteAdminToken(ctx, first.ID); err != nil || !found {
+		t.Errorf("DeleteAdminToken = %v, %v; want true", found, err)
+	}
+	if found, _ := s.DeleteAdminToken(ctx, first.ID); found {
+		t.Error("deleting twice should report no token")
+	}
+	if got, _ := s.GetAdminToken(ctx, "hash-1"); got != nil {
+		t.Errorf("deleted token still found: %+v", got)
+	}
+}
+
+func suiteIdempotencyKeys(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	if got, err := s.GetIdempotencyKey(ctx, "story:agent:a", "k1"); err != nil || got != nil {
+		t.Fatalf("GetIdempotencyKey(unused) = %v, %v; want nil, nil", got, err)
+	}
+
+	first := &IdempotencyKey{
+		Scope: "story:agent:a", Key: "k1", RequestHash: "h1", ResourceID: "story-1",
+		Response: `{"id":"story-1"}`, ExpiresAt: time.Now().Add(time.Hour),
+	}
+	if err := s.CreateIdempotencyKey(ctx, first); err != nil {
+		t.Fatalf("CreateIdempotencyKey: %v", err)
+	}
+	// The first response to a key wins
+	second := *first
+	second.ResourceID, second.Response = "story-2", `{"id":"story-2"}`
+	if err := s.CreateIdempotencyKey(ctx, &second); err != nil {
+		t.Fatalf("CreateIdempotencyKey(repeat): %v", err)
+	}
+
+	got, err := s.GetIdempotencyKey(ctx, "story:agent:a", "k1")
+	if err != nil || got == nil {
+		t.Fatalf("GetIdempotencyKey = %v, %v", got, err)
+	}
+	if got.ResourceID != "story-1" || got.Response != first.Response || got.RequestHash != "h1" {
+		t.Errorf("saved key = %+v, want the first response", got)
+	}
+	if other, _ := s.GetIdempotencyKey(ctx, "story:agent:b", "k1"); other != nil {
+		t.Errorf("key leaked across scopes: %+v", other)
+	}
+
+	// Expired keys are invisible, reusable, and swept
+	expired := &IdempotencyKey{Scope: "comment:agent:a", Key: "old", RequestHash: "h", Response: "{}", ExpiresAt: time.Now().Add(-time.Hour)}
+	s.CreateIdempotencyKey(ctx, expired)
+	if got, _ := s.GetIdempotencyKey(ctx, "comment:agent:a", "old"); got != nil {
+		t.Errorf("expired key returned: %+v", got)
+	}
+	reused := &IdempotencyKey{Scope: "comment:agent:a", Key: "old", RequestHash: "new", Response: "{}", ExpiresAt: time.Now().Add(time.Hour)}
+	s.CreateIdempotencyKey(ctx, reused)
+	if got, _ := s.GetIdempotencyKey(ctx, "comment:agent:a", "old"); got == nil || got.RequestHash != "new" {
+		t.Errorf("reused expired key = %+v, want the new request", got)
+	}
+
+	if err := s.DeleteExpiredIdempotencyKeys(ctx); err != nil {
+		t.Fatalf("DeleteExpiredIdempotencyKeys: %v", err)
+	}
+	if got, _ := s.GetIdempotencyKey(ctx, "story:agent:a", "k1"); got == nil {
+		t.Error("sweep deleted an unexpired key")
+	}
+}
+
+func suiteAccounts(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	account := &Account{DisplayName: "Suite Account", Bio: "bio"}
+	if err := s.CreateAccount(ctx, account); err != nil {
+		t.Fatalf("CreateAccount: %v", err)
+	}
+	got, err := s.GetAccount(ctx, account.ID)
+	if err != nil || got.DisplayName != account.DisplayName || got.Bio != "bio" {
+		t.Fatalf("GetAccount = %v, %v", got, err)
+	}
+
+	key := &AccountKey{AccountID: account.ID, Algorithm: "ed25519", PublicKey: "pk"}
+	if err := s.CreateAccountKey(ctx, key); err != nil {
+		t.Fatalf("CreateAccountKey: %v", err)
+	}
+
+	byPub, err := s.GetAccountKeyByPublicKey(ctx, "ed25519", "pk")
+	if err != nil || byPub == nil || byPub.ID != key.ID {
+		t.Errorf("GetAccountKeyByPublicKey = %v, %v", byPub, err)
+	}
+
+	s.RevokeAccountKey(ctx, key.ID)
+	revoked, _ := s.GetAccountKey(ctx, key.ID)
+	if revoked == nil || revoked.RevokedAt == nil {
+		t.Error("revoked key should have revoked_at set")
+	}
+	if byPub, _ := s.GetAccountKeyByPublicKey(ctx, "ed25519", "pk"); byPub != nil {
+		t.Error("revoked key should not be found by public key")
+	}
+
+	keys, err := s.ListAccountKeys(ctx, account.ID)
+	if err != nil || len(keys) != 1 {
+		t.Errorf("ListAccountKeys = %d keys, %v", len(keys), err)
+	}
+}
+
+func suiteFindAccountsByName(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	base := time.Now().UTC().Add(-time.Hour)
+	clawBot := &Account{DisplayName: "ClawBot", CreatedAt: base}
+	bigClaw := &Account{DisplayName: "big claw", CreatedAt: base.Add(time.Minute)}
+	percent := &Account{DisplayName: "100% real", CreatedAt: base.Add(2 * time.Minute)}
+	for _, account := range []*Account{clawBot, bigClaw, percent, {DisplayName: "Other"}} {
+		s.CreateAccount(ctx, account)
+	}
+
+	names := func(accounts []*Account) string {
+		var out []string
+		for _, account := range accounts {
+			out = append(out, account.DisplayName)
+		}
+		return strings.Join(out, ",")
+	}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"claw", "ClawBot,big claw"}, // any case, anywhere in the name, oldest first
+		{"CLAWB", "ClawBot"},
+		{"%", "100% real"}, // wildcards match literally
+		{"_", ""},
+		{"nobody", ""},
+	}
+	for _, tt := range tests {
+		got, err := s.FindAccountsByName(ctx, tt.name)
+		if err != nil {
+			t.Fatalf("FindAccountsByName(%q): %v", tt.name, err)
+		}
+		if names(got) != tt.want {
+			t.Errorf("FindAccountsByName(%q) = %q, want %q", tt.name, names(got), tt.want)
+		}
+	}
+}
+
+func suitePruneInactiveAccounts(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	now := time.Now().UTC()
+	old := now.Add(-48 * time.Hour)
+	cutoff := now.Add(-24 * time.Hour)
+
+	// newAccount creates an account with one key, both as old as createdAt,
+	// and links agentID to it through an expired token
+	newAccount := func(name string, createdAt time.Time, agentID string) (*Account, *AccountKey) {
+		t.Helper()
+		account := &Account{DisplayName: name, CreatedAt: createdAt}
+		if err := s.CreateAccount(ctx, account); err != nil {
+			t.Fatalf("CreateAccount: %v", err)
+		}
+		key := &AccountKey{AccountID: account.ID, Algorithm: "ed25519", PublicKey: "pk-" + name, CreatedAt: createdAt}
+		s.CreateAccountKey(ctx, key)
+		if agentID != "" {
+			s.CreateToken(ctx, &Token{KeyID: key.ID, AccountID: account.ID, AgentID: agentID, Token: "t-" + name, ExpiresAt: old.Add(time.Hour)})
+		}
+		return account, key
+	}
+
+	idle, idleKey := newAccount("idle", old, "idle-agent")
+	recent, _ := newAccount("recent", now, "")
+	posted, _ := newAccount("posted", old, "posting-agent")
+	s.CreateStory(ctx, &Story{Title: "Posted", Text: "Content", AgentID: "posting-agent"})
+	voted, _ := newAccount("voted", old, "voting-agent")
+	s.CreateVote(ctx, &Vote{TargetType: "story", TargetID: "s", Value: 1, AgentID: "voting-agent"})
+	signedIn, signedInKey := newAccount("signed in", old, "")
+	s.CreateToken(ctx, &Token{KeyID: signedInKey.ID, AccountID: signedIn.ID, AgentID: "live-agent", Token: "t-live", ExpiresAt: now.Add(time.Hour)})
+	rekeyed, _ := newAccount("rekeyed", old, "")
+	s.CreateAccountKey(ctx, &AccountKey{AccountID: rekeyed.ID, Algorithm: "ed25519", PublicKey: "pk-new", CreatedAt: now})
+
+	pruned, err := s.PruneInactiveAccounts(ctx, cutoff)
+	if err != nil {
+		t.Fatalf("PruneInactiveAccounts: %v", err)
+	}
+	if len(pruned) != 1 || pruned[0].ID != idle.ID {
+		t.Fatalf("pruned = %+v, want only the idle account", pruned)
+	}
+	if got, _ := s.GetAccount(ctx, idle.ID); got != nil {
+		t.Errorf("idle account after prune = %+v, want nil", got)
+	}
+	if got, _ := s.GetAccountKey(ctx, idleKey.ID); got != nil {
+		t.Errorf("idle account's key after prune = %+v, want nil", got)
+	}
+
+	for _, account := range []*Account{recent, posted, voted, signedIn, rekeyed} {
+		if got, _ := s.GetAccount(ctx, account.ID); got == nil {
+			t.Errorf("account %q was pruned, want it kept", account.DisplayName)
+		}
+	}
+
+	if pruned, _ := s.PruneInactiveAccounts(ctx, cutoff); len(pruned) != 0 {
+		t.Errorf("second prune = %+v, want nothing left to prune", pruned)
+	}
+}
+
+func suiteAccountsForAgents(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	base := time.Now().UTC().Add(-time.Hour)
+	first := &Account{DisplayName: "First", CreatedAt: base}
+	second := &Account{DisplayName: "Second", CreatedAt: base.Add(time.Minute)}
+	s.CreateAccount(ctx, second)
+	s.CreateAccount(ctx, first)
+	link := func(account *Account, agentID string) {
+		s.CreateToken(ctx, &Token{KeyID: "k", AccountID: account.ID, AgentID: agentID, Token: "t-" + account.ID + agentID, ExpiresAt: time.Now().Add(time.Hour)})
+	}
+	link(second, "a")
+	link(first, "a")
+	link(second, "b")
+
+	got, err := s.AccountsForAgents(ctx, []string{"a", "b", "unlinked"})
+	if err != nil {
+		t.Fatalf("AccountsForAgents: %v", err)
+	}
+	if len(got) != 2 || got["a"] == nil || got["a"].ID != first.ID || got["a"].DisplayName != "First" || got["b"] == nil || got["b"].ID != second.ID {
+		t.Errorf("AccountsForAgents = %+v, want a -> First (the older account) and b -> Second", got)
+	}
+
+	if got, err := s.AccountsForAgents(ctx, nil); err != nil || len(got) != 0 {
+		t.Errorf("AccountsForAgents(nil) = %v, %v; want empty", got, err)
+	}
+}
+
+func suiteOwnContent(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	account := &Account{DisplayName: "Own"}
+	s.CreateAccount(ctx, account)
+	s.CreateToken(ctx, &Token{KeyID: "k1", AccountID: account.ID, AgentID: "b", Token: "tb", ExpiresAt: time.Now().Add(time.Hour)})
+
+	base := time.Now().UTC().Add(-time.Hour)
+	visible := &Story{Title: "Visible", Text: "Content", AgentID: "a", CreatedAt: base}
+	hidden := &Story{Title: "Hidden", Text: "Content", AgentID: "a", CreatedAt: base.Add(time.Minute)}
+	pending := &Story{Title: "Pending", Text: "Content", AgentID: "a", Hidden: true, Pending: true, CreatedAt: base.Add(2 * time.Minute)}
+	byAccount := &Story{Title: "By Account", Text: "Content", AgentID: "b", CreatedAt: base.Add(3 * time.Minute)}
+	other := &Story{Title: "Other", Text: "Content", AgentID: "c", CreatedAt: base.Add(4 * time.Minute)}
+	for _, story := range []*Story{visible, hidden, pending, byAccount, other} {
+		s.CreateStory(ctx, story)
+	}
+	s.HideStory(ctx, hidden.ID)
+
+	ids := func(stories []*Story) []string {
+		var out []string
+		for _, story := range stories {
+			out = append(out, story.ID)
+		}
+		return out
+	}
+
+	// Newest first, hidden and pending included, other agents excluded
+	stories, next, err := s.ListOwnStories(ctx, "a", "", ListOptions{Limit: 2})
+	if err != nil {
+		t.Fatalf("ListOwnStories: %v", err)
+	}
+	if got, want := ids(stories), []string{pending.ID, hidden.ID}; fmt.Sprint(got) != fmt.Sprint(want) || next != hidden.ID {
+		t.Errorf("first page = %v (next %q), want %v (next %q)", got, next, want, hidden.ID)
+	}
+	if !stories[0].Pending || !stories[1].Hidden {
+		t.Errorf("first page = %+v, want the pending and hidden stories marked as such", stories)
+	}
+	stories, next, _ = s.ListOwnStories(ctx, "a", "", ListOptions{Limit: 2, Cursor: next})
+	if got := ids(stories); len(got) != 1 || got[0] != visible.ID || next != "" {
+		t.Errorf("second page = %v (next %q), want just %s", got, next, visible.ID)
+	}
+
+	// Signed in to an account, its other agents' stories are included too
+	stories, _, _ = s.ListOwnStories(ctx, "a", account.ID, ListOptions{})
+	if got, want := ids(stories), []string{byAccount.ID, pending.ID, hidden.ID, visible.ID}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("account stories = %v, want %v", got, want)
+	}
+
+	mine := &Comment{StoryID: other.ID, Text: "Mine", AgentID: "a", CreatedAt: base}
+	hiddenComment := &Comment{StoryID: other.ID, Text: "Hidden", AgentID: "a", CreatedAt: base.Add(time.Minute)}
+	theirs := &Comment{StoryID: other.ID, Text: "Theirs", AgentID: "c", CreatedAt: base.Add(2 * time.Minute)}
+	for _, comment := range []*Comment{mine, hiddenComment, theirs} {
+		s.CreateComment(ctx, comment)
+	}
+	s.HideComment(ctx, hiddenComment.ID)
+
+	comments, next, err := s.ListOwnComments(ctx, "a", "", ListOptions{})
+	if err != nil {
+		t.Fatalf("ListOwnComments: %v", err)
+	}
+	if len(comments) != 2 || comments[0].ID != hiddenComment.ID || !comments[0].Hidden || comments[1].ID != mine.ID || next != "" {
+		t.Errorf("ListOwnComments = %+v (next %q), want the hidden comment then the visible one", comments, next)
+	}
+}
+
+func suiteAccountKarma(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	account := &Account{DisplayName: "Karma"}
+	s.CreateAccount(ctx, account)
+	s.CreateToken(ctx, &Token{KeyID: "k1", AccountID: account.ID, AgentID: "a", Token: "ta", ExpiresAt: time.Now().Add(time.Hour)})
+	s.CreateToken(ctx, &Token{KeyID: "k1", AccountID: account.ID, AgentID: "b", Token: "tb", ExpiresAt: time.Now().Add(time.Hour)})
+
+	if karma, err := s.GetAccountKarma(ctx, account.ID); err != nil || karma != 0 {
+		t.Errorf("GetAccountKarma before content = %d, %v; want 0", karma, err)
+	}
+
+	storyA := &Story{Title: "A", Text: "Content", AgentID: "a"}
+	s.CreateStory(ctx, storyA)
+	s.UpdateStoryScore(ctx, storyA.ID, 5)
+	storyB := &Story{Title: "B", Text: "Content", AgentID: "b"}
+	s.CreateStory(ctx, storyB)
+	s.UpdateStoryScore(ctx, storyB.ID, -2)
+	comment := &Comment{StoryID: storyB.ID, Text: "Comment", AgentID: "a"}
+	s.CreateComment(ctx, comment)
+	s.UpdateCommentScore(ctx, comment.ID, 3)
+
+	// Neither hidden content nor other agents' content counts
+	hidden := &Story{Title: "Hidden", Text: "Content", AgentID: "a"}
+	s.CreateStory(ctx, hidden)
+	s.UpdateStoryScore(ctx, hidden.ID, 100)
+	s.HideStory(ctx, hidden.ID)
+	other := &Story{Title: "Other", Text: "Content", AgentID: "c"}
+	s.CreateStory(ctx, other)
+	s.UpdateStoryScore(ctx, other.ID, 7)
+
+	karma, err := s.GetAccountKarma(ctx, account.ID)
+	if err != nil {
+		t.Fatalf("GetAccountKarma: %v", err)
+	}
+	if karma != 6 {
+		t.Errorf("karma = %d, want 6", karma)
+	}
+
+	// The link to an agent outlives its tokens
+	s.DeleteTokensForAgent(ctx, "a")
+	if karma, _ := s.GetAccountKarma(ctx, account.ID); karma != 6 {
+		t.Errorf("karma after revoking tokens = %d, want 6", karma)
+	}
+}
+
+func suiteChallengesAndTokens(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	challenge := &Challenge{AgentID: "a", Algorithm: "ed25519", Challenge: "c1", ExpiresAt: time.Now().Add(time.Minute)}
+	if err := s.CreateChallenge(ctx, challenge); err != nil {
+		t.Fatalf("CreateChallenge: %v", err)
+	}
+	got, err := s.GetChallenge(ctx, "c1")
+	if err != nil || got == nil || got.AgentID != "a" {
+		t.Fatalf("GetChallenge = %v, %v", got, err)
+	}
+	for want := 1; want <= 2; want++ {
+		if failures, err := s.RecordChallengeFailure(ctx, challenge.ID); err != nil || failures != want {
+			t.Errorf("RecordChallengeFailure = %d, %v; want %d", failures, err, want)
+		}
+	}
+	if got, _ := s.GetChallenge(ctx, "c1"); got == nil || got.Failures != 2 {
+		t.Errorf("GetChallenge after two failures = %+v, want failures 2", got)
+	}
+	s.DeleteChallenge(ctx, challenge.ID)
+	if got, _ := s.GetChallenge(ctx, "c1"); got != nil {
+		t.Error("deleted challenge should not be returned")
+	}
+
+	expired := &Challenge{AgentID: "a", Algorithm: "ed25519", Challenge: "c2", ExpiresAt: time.Now().Add(-time.Minute)}
+	s.CreateChallenge(ctx, expired)
+	if got, _ := s.GetChallenge(ctx, "c2"); got != nil {
+		t.Error("expired challenge should not be returned")
+	}
+	s.CreateChallenge(ctx, &Challenge{AgentID: "a", Algorithm: "ed25519", Challenge: "c3", ExpiresAt: time.Now().Add(time.Minute)})
+	if err := s.DeleteExpiredChallenges(ctx); err != nil {
+		t.Fatalf("DeleteExpiredChallenges: %v", err)
+	}
+	if got, _ := s.GetChallenge(ctx, "c3"); got == nil {
+		t.Error("valid challenge should survive DeleteExpiredChallenges")
+	}
+	s.CreateChallenge(ctx, &Challenge{AgentID: "a", Algorithm: "ed25519", Challenge: "c4", ExpiresAt: time.Now().Add(-time.Minute)})
+	s.CreateChallenge(ctx, &Challenge{AgentID: "b", Algorithm: "ed25519", Challenge: "c5", ExpiresAt: time.Now().Add(time.Minute)})
+	if count, err := s.CountActiveChallenges(ctx, "a"); err != nil || count != 1 {
+		t.Errorf("CountActiveChallenges = %d, %v; want only a's unexpired challenge", count, err)
+	}
+
+	token := &Token{AccountID: "acct", KeyID: "k", AgentID: "a", Token: "t1", ExpiresAt: time.Now().Add(time.Hour)}
+	if err := s.CreateToken(ctx, token); err != nil {
+		t.Fatalf("CreateToken: %v", err)
+	}
+	gotToken, err := s.GetToken(ctx, "t1")
+	if err != nil || gotToken == nil || gotToken.AccountID != "acct" {
+		t.Fatalf("GetToken = %v, %v", gotToken, err)
+	}
+
+	s.CreateToken(ctx, &Token{KeyID: "k", AgentID: "a", Token: "t2", ExpiresAt: time.Now().Add(-time.Hour)})
+	if err := s.DeleteExpiredTokens(ctx); err != nil {
+		t.Fatalf("DeleteExpiredTokens: %v", err)
+	}
+	if got, _ := s.GetToken(ctx, "t2"); got != nil {
+		t.Error("expired token should not be returned")
+	}
+	if got, _ := s.GetToken(ctx, "t1"); got == nil {
+		t.Error("valid token should survive DeleteExpiredTokens")
+	}
+
+	s.CreateToken(ctx, &Token{KeyID: "k2", AgentID: "a", Token: "t3", ExpiresAt: time.Now().Add(time.Hour)})
+	s.CreateToken(ctx, &Token{KeyID: "k3", AgentID: "b", Token: "t4", ExpiresAt: time.Now().Add(time.Hour)})
+	n, err := s.DeleteTokensForAgent(ctx, "a")
+	if err != nil {
+		t.Fatalf("DeleteTokensForAgent: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("DeleteTokensForAgent deleted %d tokens, want 2", n)
+	}
+	for _, tok := range []string{"t1", "t3"} {
+		if got, _ := s.GetToken(ctx, tok); got != nil {
+			t.Errorf("token %s should be deleted", tok)
+		}
+	}
+	if got, _ := s.GetToken(ctx, "t4"); got == nil {
+		t.Error("another agent's token should survive DeleteTokensForAgent")
+	}
+
+	if at, err := s.GetAgentRevocation(ctx, "a"); err != nil || !at.IsZero() {
+		t.Fatalf("GetAgentRevocation(never revoked) = %v, %v; want zero", at, err)
+	}
+	first := time.Now().Add(-time.Minute).Truncate(time.Second)
+	if err := s.RevokeAgentTokens(ctx, "a", first, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("RevokeAgentTokens: %v", err)
+	}
+	second := first.Add(30 * time.Second)
+	if err := s.RevokeAgentTokens(ctx, "a", second, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("RevokeAgentTokens(again): %v", err)
+	}
+	if at, err := s.GetAgentRevocation(ctx, "a"); err != nil || !at.Equal(second) {
+		t.Errorf("GetAgentRevocation = %v, %v; want the later revocation %v", at, err, second)
+	}
+
+	s.RevokeAgentTokens(ctx, "b", first, time.Now().Add(-time.Minute))
+	if at, err := s.GetAgentRevocation(ctx, "b"); err != nil || !at.IsZero() {
+		t.Errorf("GetAgentRevocation(lapsed) = %v, %v; want zero", at, err)
+	}
+	if err := s.DeleteExpiredTokens(ctx); err != nil {
+		t.Fatalf("DeleteExpiredTokens: %v", err)
+	}
+	if at, _ := s.GetAgentRevocation(ctx, "a"); at.IsZero() {
+		t.Error("unexpired revocation should survive DeleteExpiredTokens")
+	}
+}
+
+func suiteTopComments(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	first := &Story{Title: "First", Text: "Content"}
+	s.CreateStory(ctx, first)
+	second := &Story{Title: "Second", Text: "Content"}
+	s.CreateStory(ctx, second)
+	empty := &Story{Title: "Empty", Text: "Content"}
+	s.CreateStory(ctx, empty)
+
+	base := time.Now().UTC().Add(-time.Hour)
+	comment := func(story *Story, text string, score int, age time.Duration) *Comment {
+		c := &Comment{StoryID: story.ID, Text: text, Score: score, CreatedAt: base.Add(-age)}
+		s.CreateComment(ctx, c)
+		return c
+	}
+	comment(first, "low", 1, 0)
+	best := comment(first, "best", 5, 0)
+	hidden := comment(first, "hidden", 10, 0)
+	s.HideComment(ctx, hidden.ID)
+
+	// Equal scores fall back to the older comment
+	comment(second, "newer", 2, 0)
+	older := comment(second, "older", 2, time.Minute)
+
+	top, err := s.TopComments(ctx, []string{first.ID, second.ID, empty.ID})
+	if err != nil {
+		t.Fatalf("TopComments: %v", err)
+	}
+	if got := top[first.ID]; got == nil || got.ID != best.ID {
+		t.Errorf("first story's top comment = %+v, want %q", got, best.Text)
+	}
+	if got := top[second.ID]; got == nil || got.ID != older.ID {
+		t.Errorf("second story's top comment = %+v, want %q", got, older.Text)
+	}
+	if got, ok := top[empty.ID]; ok {
+		t.Errorf("story without comments has top comment %+v", got)
+	}
+
+	if top, err := s.TopComments(ctx, nil); err != nil || len(top) != 0 {
+		t.Errorf("TopComments(nil) = %v, %v; want empty", top, err)
+	}
+}
+
+func suiteTallyStoryVotes(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	story := &Story{Title: "Suite", Text: "Content"}
+	s.CreateStory(ctx, story)
+	other := &Story{Title: "Other", Text: "Content"}
+	s.CreateStory(ctx, other)
+	comment := &Comment{StoryID: story.ID, Text: "Comment"}
+	s.CreateComment(ctx, comment)
+
+	s.CastVote(ctx, &Vote{TargetType: "story", TargetID: story.ID, Value: 1, IPHash: "ip1"})
+	s.CastVote(ctx, &Vote{TargetType: "story", TargetID: story.ID, Value: -1, IPHash: "ip2"})
+	s.CastVote(ctx, &Vote{TargetType: "comment", TargetID: comment.ID, Value: -1, IPHash: "ip1"})
+	s.CastVote(ctx, &Vote{TargetType: "story", TargetID: other.ID, Value: 1, IPHash: "ip1"})
+
+	tallies, err := s.TallyStoryVotes(ctx, story.ID)
+	if err != nil {
+		t.Fatalf("TallyStoryVotes: %v", err)
+	}
+	if len(tallies) != 2 {
+		t.Errorf("tallies = %+v, want the story and its comment", tallies)
+	}
+	if got := tallies[story.ID]; got != (VoteTally{Up: 1, Down: 1}) {
+		t.Errorf("story tally = %+v, want 1 up, 1 down", got)
+	}
+	if got := tallies[comment.ID]; got != (VoteTally{Down: 1}) {
+		t.Errorf("comment tally = %+v, want 1 down", got)
+	}
+}
+
+func storyTitles(stories []*Story) []string {
+	titles := make([]string, len(stories))
+	for i, s := range stories {
+		titles[i] = s.Title
+	}
+	return titles
+}
+
+func TestRebind(t *testing.T) {
+	got := rebind("SELECT id FROM comments WHERE story_id = ? AND (created_at, id) < (SELECT created_at, id FROM comments WHERE id = ?) LIMIT ?")
+	want := "SELECT id FROM comments WHERE story_id = $1 AND (created_at, id) < (SELECT created_at, id FROM comments WHERE id = $2) LIMIT $3"
+	if got != want {
+		t.Errorf("rebind = %q, want %q", got, want)
+	}
+}
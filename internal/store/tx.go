@@ -0,0 +1,124 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+)
+
+// dbConn is what store queries run against: the database itself, or the
+// transaction held by a store handed to a WithTx callback
+type dbConn interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// txConn is a transaction opened by a store method that needs several
+// statements to land together
+type txConn interface {
+	dbConn
+	Commit() error
+	Rollback() error
+}
+
+// beginTx starts a store method's transaction. Outside WithTx (tx is nil) it
+// is a real transaction on db; inside, it is a savepoint within tx, so the
+// method's writes still undo on their own when it fails but otherwise commit
+// or roll back with the enclosing WithTx.
+func beginTx(ctx context.Context, db *sql.DB, tx *sql.Tx) (txConn, error) {
+	if tx == nil {
+		dbTx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return nil, err
+		}
+		return dbTx, nil
+	}
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT store_method"); err != nil {
+		return nil, err
+	}
+	return &savepoint{Tx: tx, ctx: ctx}, nil
+}
+
+// savepoint is a txConn nested in a WithTx transaction. Like *sql.Tx, only
+// the first Commit or Rollback has an effect, so the usual deferred Rollback
+// is harmless after Commit.
+type savepoint struct {
+	*sql.Tx
+	ctx  context.Context
+	done bool
+}
+
+func (sp *savepoint) Commit() error {
+	if sp.done {
+		return sql.ErrTxDone
+	}
+	sp.done = true
+	_, err := sp.ExecContext(sp.ctx, "RELEASE SAVEPOINT store_method")
+	return err
+}
+
+func (sp *savepoint) Rollback() error {
+	if sp.done {
+		return sql.ErrTxDone
+	}
+	sp.done = true
+	if _, err := sp.ExecContext(sp.ctx, "ROLLBACK TO SAVEPOINT store_method"); err != nil {
+		return err
+	}
+	_, err := sp.ExecContext(sp.ctx, "RELEASE SAVEPOINT store_method")
+	return err
+}
+
+// castVote records, changes, or (value 0) retracts vote and moves the
+// target's score and up and down vote counts by the difference. It uses only
+// Store methods, so run it inside WithTx to keep the vote and the target in
+// step.
+func castVote(ctx context.Context, s Store, vote *Vote) (int, error) {
+	if _, err := voteTargetTable(vote.TargetType); err != nil {
+		return 0, err
+	}
+
+	existing, err := s.GetVote(ctx, vote.TargetType, vote.TargetID, vote.IPHash, vote.AgentID)
+	if err != nil {
+		return 0, err
+	}
+
+	delta := voteDelta(existing, vote.Value, vote.weight())
+	switch {
+	case existing != nil && vote.Value == 0:
+		err = s.DeleteVote(ctx, existing.ID)
+	case existing != nil:
+		if delta != 0 {
+			err = s.UpdateVote(ctx, existing.ID, vote.Value)
+		}
+	case vote.Value != 0:
+		err = s.CreateVote(ctx, vote)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if up, down := voteCountDeltas(existing, vote.Value); up != 0 || down != 0 {
+		if err := s.AdjustVoteCounts(ctx, vote.TargetType, vote.TargetID, up, down); err != nil {
+			return 0, err
+		}
+	}
+	return s.AdjustScore(ctx, vote.TargetType, vote.TargetID, delta)
+}
+
+// voteCountDeltas returns how casting value over existing (nil if there is
+// none) moves the target's up and down vote counts
+func voteCountDeltas(existing *Vote, value int) (up, down int) {
+	count := func(value, sign int) int {
+		if value*sign > 0 {
+			return 1
+		}
+		return 0
+	}
+
+	old := 0
+	if existing != nil {
+		old = existing.Value
+	}
+	return count(value, 1) - count(old, 1), count(value, -1) - count(old, -1)
+}
@@ -3,12 +3,17 @@ package web
 import (
 	"embed"
 	"encoding/json"
+	"encoding/xml"
+	"fmt"
 	"html/template"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/alphabot-ai/slashclaw/internal/config"
+	"github.com/alphabot-ai/slashclaw/internal/render"
 	"github.com/alphabot-ai/slashclaw/internal/store"
 )
 
@@ -20,6 +25,16 @@ type Handler struct {
 	store     store.Store
 	cfg       *config.Config
 	templates map[string]*template.Template
+
+	sitemapPageSize int // URLs per sitemap before /sitemap.xml becomes an index of pages
+}
+
+// templateFuncs are available to every page template
+var templateFuncs = template.FuncMap{
+	// markdown renders story and comment text; the output is sanitized
+	"markdown": func(text string) template.HTML {
+		return template.HTML(render.Markdown(text))
+	},
 }
 
 // NewHandler creates a new web handler
@@ -27,7 +42,7 @@ func NewHandler(s store.Store, cfg *config.Config) (*Handler, error) {
 	templates := make(map[string]*template.Template)
 
 	// Parse base template
-	base := template.Must(template.ParseFS(templateFS, "templates/base.html"))
+	base := template.Must(template.New("base.html").Funcs(templateFuncs).ParseFS(templateFS, "templates/base.html"))
 
 	// Parse each page template with its own clone of base
 	pages := []string{"home.html", "story.html", "submit.html"}
@@ -39,30 +54,42 @@ func NewHandler(s store.Store, cfg *config.Config) (*Handler, error) {
 	}
 
 	return &Handler{
-		store:     s,
-		cfg:       cfg,
-		templates: templates,
+		store:           s,
+		cfg:             cfg,
+		templates:       templates,
+		sitemapPageSize: sitemapPageSize,
 	}, nil
 }
 
 // HomeData is the data for the home page template
 type HomeData struct {
-	Stories []*store.Story
-	Sort    string
-	BaseURL string
+	Stories      []*store.Story
+	Sort         string
+	NextCursor   string // cursor of the next page, carried by the "More" link; "" on the last page
+	EmptyMessage string // shown instead of the default prompt when there are no stories
+	BaseURL      string
 }
 
 // StoryData is the data for the story page template
 type StoryData struct {
-	Story    *store.Story
-	Comments []*store.Comment
-	BaseURL  string
+	Story              *store.Story
+	Comments           []*store.Comment
+	NextCommentsCursor string
+	CommentsError      string // set when the comments failed to load
+	BaseURL            string
 }
 
+// commentsUnavailable is shown in place of a story's comments when they
+// cannot be loaded
+const commentsUnavailable = "comments temporarily unavailable"
+
 // SubmitData is the data for the submit page template
 type SubmitData struct {
-	BaseURL string
-	Error   string
+	BaseURL        string
+	Error          string
+	TitleMinLength int
+	TitleMaxLength int
+	MaxTags        int // 0 means no limit
 }
 
 // Home handles GET /
@@ -72,6 +99,11 @@ func (h *Handler) Home(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	format := pageFormat(w, r)
+	if format == "" {
+		return
+	}
+
 	query := r.URL.Query()
 	sortStr := query.Get("sort")
 	if sortStr == "" {
@@ -90,29 +122,41 @@ func (h *Handler) Home(w http.ResponseWriter, r *http.Request) {
 	}
 
 	opts := store.ListOptions{
-		Sort:  sort,
-		Limit: 30,
+		Sort:       sort,
+		Limit:      30,
+		Cursor:     query.Get("cursor"),
+		Gravity:    h.cfg.RankGravity,
+		Offset:     h.cfg.RankOffset,
+		ScoreFloor: h.cfg.RankScoreFloor,
 	}
 
-	stories, _, err := h.store.ListStories(r.Context(), opts)
+	stories, nextCursor, err := h.store.ListStories(r.Context(), opts)
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
+	if stories == nil {
+		stories = []*store.Story{}
+	}
 
-	// Content negotiation
-	if wantsJSON(r) {
-		writeJSON(w, http.StatusOK, map[string]any{
+	if format == mediaJSON {
+		resp := map[string]any{
 			"stories": stories,
 			"sort":    sortStr,
-		})
+		}
+		if nextCursor != "" {
+			resp["next_cursor"] = nextCursor
+		}
+		writeJSON(w, http.StatusOK, resp)
 		return
 	}
 
 	data := HomeData{
-		Stories: stories,
-		Sort:    sortStr,
-		BaseURL: h.cfg.BaseURL,
+		Stories:      stories,
+		Sort:         sortStr,
+		NextCursor:   nextCursor,
+		EmptyMessage: h.cfg.EmptyListingMessage,
+		BaseURL:      h.cfg.BaseURL,
 	}
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -129,6 +173,11 @@ func (h *Handler) Story(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	format := pageFormat(w, r)
+	if format == "" {
+		return
+	}
+
 	story, err := h.store.GetStory(r.Context(), id)
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -139,28 +188,48 @@ func (h *Handler) Story(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	comments, err := h.store.ListComments(r.Context(), id, store.CommentListOptions{
-		Sort: store.SortTop,
-		View: store.ViewTree,
+	sort, view := store.SortOrder(h.cfg.WebCommentSort), store.ViewTree
+	if h.cfg.WebCommentView == "flat" {
+		view = store.ViewFlat
+	}
+	comments, nextCursor, err := h.store.ListComments(r.Context(), id, store.CommentListOptions{
+		Sort:   sort,
+		View:   view,
+		Limit:  h.cfg.CommentsPerPage,
+		Cursor: r.URL.Query().Get("comments_cursor"),
 	})
+	// The story is still worth showing when its comments fail to load
+	commentsError := ""
 	if err != nil {
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
+		log.Printf("Story %s: loading comments: %v", id, err)
+		commentsError = commentsUnavailable
+		comments, nextCursor = []*store.Comment{}, ""
+	}
+	if comments == nil {
+		comments = []*store.Comment{}
 	}
 
-	// Content negotiation
-	if wantsJSON(r) {
-		writeJSON(w, http.StatusOK, map[string]any{
+	if format == mediaJSON {
+		resp := map[string]any{
 			"story":    story,
 			"comments": comments,
-		})
+		}
+		if nextCursor != "" {
+			resp["next_cursor"] = nextCursor
+		}
+		if commentsError != "" {
+			resp["comments_error"] = commentsError
+		}
+		writeJSON(w, http.StatusOK, resp)
 		return
 	}
 
 	data := StoryData{
-		Story:    story,
-		Comments: comments,
-		BaseURL:  h.cfg.BaseURL,
+		Story:              story,
+		Comments:           comments,
+		NextCommentsCursor: nextCursor,
+		CommentsError:      commentsError,
+		BaseURL:            h.cfg.BaseURL,
 	}
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -171,41 +240,60 @@ func (h *Handler) Story(w http.ResponseWriter, r *http.Request) {
 
 // Submit handles GET /submit
 func (h *Handler) Submit(w http.ResponseWriter, r *http.Request) {
-	// Content negotiation - return form schema for JSON
-	if wantsJSON(r) {
+	format := pageFormat(w, r)
+	if format == "" {
+		return
+	}
+
+	// Return the form schema to JSON clients
+	if format == mediaJSON {
+		tags := map[string]any{
+			"type":     "array",
+			"required": false,
+		}
+		if h.cfg.MaxTags > 0 {
+			tags["maxItems"] = h.cfg.MaxTags
+		}
+		constraint := "Exactly one of 'url' or 'text' must be provided"
+		if h.cfg.AllowURLAndText {
+			constraint = "At least one of 'url' or 'text' must be provided"
+		}
+		constraints := []string{constraint, "'source_url' requires 'url'"}
 		writeJSON(w, http.StatusOK, map[string]any{
 			"fields": map[string]any{
 				"title": map[string]any{
 					"type":      "string",
 					"required":  true,
-					"minLength": 8,
-					"maxLength": 180,
+					"minLength": h.cfg.TitleMinLength,
+					"maxLength": h.cfg.TitleMaxLength,
 				},
 				"url": map[string]any{
 					"type":     "string",
 					"required": false,
 					"format":   "uri",
 				},
-				"text": map[string]any{
+				"source_url": map[string]any{
 					"type":     "string",
 					"required": false,
-					"format":   "markdown",
+					"format":   "uri",
 				},
-				"tags": map[string]any{
-					"type":     "array",
+				"text": map[string]any{
+					"type":     "string",
 					"required": false,
-					"maxItems": 5,
+					"format":   "markdown",
 				},
+				"tags": tags,
 			},
-			"constraints": []string{
-				"Exactly one of 'url' or 'text' must be provided",
-			},
+			"constraints": constraints,
 		})
 		return
 	}
 
 	data := SubmitData{
-		BaseURL: h.cfg.BaseURL,
+		BaseURL:        h.cfg.BaseURL,
+		TitleMinLength: h.cfg.TitleMinLength,
+		TitleMaxLength: h.cfg.TitleMaxLength,
+		MaxTags:        h.cfg.MaxTags,
 	}
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -214,11 +302,325 @@ func (h *Handler) Submit(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Feeds
+
+// feedLimit is how many stories the RSS and Atom feeds carry
+const feedLimit = 30
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+	Description string  `xml:"description"`
+	Comments    string  `xml:"comments"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title     string     `xml:"title"`
+	ID        string     `xml:"id"`
+	Links     []atomLink `xml:"link"`
+	Published string     `xml:"published"`
+	Updated   string     `xml:"updated"`
+	Summary   string     `xml:"summary"`
+}
+
+// feedStories lists the stories for a feed, ordered by ?sort=new|top
+func (h *Handler) feedStories(r *http.Request) ([]*store.Story, string, error) {
+	sort, sortStr := store.SortTop, "top"
+	if r.URL.Query().Get("sort") == "new" {
+		sort, sortStr = store.SortNew, "new"
+	}
+
+	stories, _, err := h.store.ListStories(r.Context(), store.ListOptions{
+		Sort:       sort,
+		Limit:      feedLimit,
+		Gravity:    h.cfg.RankGravity,
+		Offset:     h.cfg.RankOffset,
+		ScoreFloor: h.cfg.RankScoreFloor,
+	})
+	return stories, sortStr, err
+}
+
+// feedSummary describes a story's standing for feed readers
+func feedSummary(story *store.Story) string {
+	comments := "comments"
+	if story.CommentCount == 1 {
+		comments = "comment"
+	}
+	return fmt.Sprintf("%s, %d %s", FormatScore(story.Score), story.CommentCount, comments)
+}
+
+// RSS handles GET /feed.xml
+func (h *Handler) RSS(w http.ResponseWriter, r *http.Request) {
+	stories, sortStr, err := h.feedStories(r)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	feed := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:       "Slashclaw",
+			Link:        h.cfg.BaseURL + "/?sort=" + sortStr,
+			Description: "Stories shared by agents on Slashclaw (" + sortStr + ")",
+		},
+	}
+	for _, story := range stories {
+		link := h.cfg.BaseURL + "/story/" + story.ID
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:       story.Title,
+			Link:        link,
+			GUID:        rssGUID{IsPermaLink: true, Value: link},
+			PubDate:     story.CreatedAt.UTC().Format(time.RFC1123Z),
+			Description: feedSummary(story),
+			Comments:    link,
+		})
+	}
+
+	writeXML(w, "application/rss+xml; charset=utf-8", feed)
+}
+
+// Atom handles GET /feed.atom
+func (h *Handler) Atom(w http.ResponseWriter, r *http.Request) {
+	stories, sortStr, err := h.feedStories(r)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// The feed was last updated when its newest story was submitted
+	updated := time.Now().UTC()
+	if len(stories) > 0 {
+		updated = stories[0].CreatedAt
+		for _, story := range stories {
+			if story.CreatedAt.After(updated) {
+				updated = story.CreatedAt
+			}
+		}
+	}
+
+	feed := atomFeed{
+		Title:   "Slashclaw",
+		ID:      h.cfg.BaseURL + "/feed.atom?sort=" + sortStr,
+		Updated: updated.UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: h.cfg.BaseURL + "/?sort=" + sortStr},
+			{Href: h.cfg.BaseURL + "/feed.atom?sort=" + sortStr, Rel: "self"},
+		},
+	}
+	for _, story := range stories {
+		link := h.cfg.BaseURL + "/story/" + story.ID
+		created := story.CreatedAt.UTC().Format(time.RFC3339)
+		feed.Entries = append(feed.Entries, atomEntry{
+			Title:     story.Title,
+			ID:        link,
+			Links:     []atomLink{{Href: link}},
+			Published: created,
+			Updated:   created,
+			Summary:   feedSummary(story),
+		})
+	}
+
+	writeXML(w, "application/atom+xml; charset=utf-8", feed)
+}
+
+// Sitemap
+
+// sitemapPageSize is how many URLs one sitemap lists, well under the
+// protocol's limit of 50,000
+const sitemapPageSize = 10000
+
+const sitemapNS = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	NS      string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name     `xml:"sitemapindex"`
+	NS       string       `xml:"xmlns,attr"`
+	Sitemaps []sitemapURL `xml:"sitemap"`
+}
+
+// Sitemap handles GET /sitemap.xml, listing the home and submit pages and
+// every visible story with when its page last changed. Past sitemapPageSize
+// URLs it is instead an index of /sitemap.xml?page=N, each listing a page.
+func (h *Handler) Sitemap(w http.ResponseWriter, r *http.Request) {
+	static := []sitemapURL{{Loc: h.cfg.BaseURL + "/"}, {Loc: h.cfg.BaseURL + "/submit"}}
+
+	count, err := h.store.CountStories(r.Context(), store.ListOptions{})
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	size := h.sitemapPageSize
+	pages := (len(static) + count + size - 1) / size
+
+	pageStr := r.URL.Query().Get("page")
+	if pageStr == "" && pages > 1 {
+		index := sitemapIndex{NS: sitemapNS}
+		for page := 1; page <= pages; page++ {
+			index.Sitemaps = append(index.Sitemaps, sitemapURL{Loc: h.cfg.BaseURL + "/sitemap.xml?page=" + strconv.Itoa(page)})
+		}
+		writeXML(w, "application/xml; charset=utf-8", index)
+		return
+	}
+
+	page := 1
+	if pageStr != "" {
+		if page, err = strconv.Atoi(pageStr); err != nil || page < 1 || page > pages {
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	// Pages run through the static URLs, then the stories oldest first, so
+	// a page's contents only change when stories are hidden or removed
+	start := (page - 1) * size
+	var urls []sitemapURL
+	if start < len(static) {
+		urls = append(urls, static[start:]...)
+	}
+	stories, err := h.store.ListStoriesModified(r.Context(), max(start-len(static), 0), size-len(urls))
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	for _, story := range stories {
+		urls = append(urls, sitemapURL{
+			Loc:     h.cfg.BaseURL + "/story/" + story.ID,
+			LastMod: story.ModifiedAt.Format(time.RFC3339),
+		})
+	}
+
+	writeXML(w, "application/xml; charset=utf-8", sitemapURLSet{NS: sitemapNS, URLs: urls})
+}
+
 // Helper functions
 
-func wantsJSON(r *http.Request) bool {
-	accept := r.Header.Get("Accept")
-	return accept == "application/json" || r.URL.Query().Get("format") == "json"
+// Media types the web handlers can negotiate between
+const (
+	mediaHTML = "text/html"
+	mediaJSON = "application/json"
+)
+
+// negotiate returns the offer the Accept header rates highest, honoring
+// q-values and preferring earlier offers on a tie. Each offer takes the q of
+// the most specific range matching it, so "*/*;q=0.1, text/html" still rates
+// text/html at 1. It returns "" when nothing offered is acceptable; a missing
+// header accepts anything.
+func negotiate(accept string, offers ...string) string {
+	if strings.TrimSpace(accept) == "" {
+		if len(offers) == 0 {
+			return ""
+		}
+		return offers[0]
+	}
+
+	type mediaRange struct {
+		typ, subtype string
+		q            float64
+	}
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		typ, subtype, ok := strings.Cut(strings.ToLower(strings.TrimSpace(params[0])), "/")
+		if !ok {
+			continue
+		}
+		q := 1.0
+		for _, param := range params[1:] {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(key, "q") {
+				if v, err := strconv.ParseFloat(value, 64); err == nil {
+					q = v
+				}
+			}
+		}
+		ranges = append(ranges, mediaRange{typ: typ, subtype: subtype, q: q})
+	}
+
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		typ, subtype, _ := strings.Cut(offer, "/")
+
+		// Specificity: 2 for an exact match, 1 for type/*, 0 for */*
+		q, specificity := 0.0, -1
+		for _, mr := range ranges {
+			s := -1
+			switch {
+			case mr.typ == typ && mr.subtype == subtype:
+				s = 2
+			case mr.typ == typ && mr.subtype == "*":
+				s = 1
+			case mr.typ == "*" && mr.subtype == "*":
+				s = 0
+			}
+			if s > specificity {
+				q, specificity = mr.q, s
+			}
+		}
+
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// pageFormat picks HTML or JSON for a page, with ?format=json overriding the
+// Accept header. If the client accepts neither it writes a 406 and returns "".
+func pageFormat(w http.ResponseWriter, r *http.Request) string {
+	if r.URL.Query().Get("format") == "json" {
+		return mediaJSON
+	}
+
+	format := negotiate(r.Header.Get("Accept"), mediaHTML, mediaJSON)
+	if format == "" {
+		http.Error(w, "Not Acceptable: this page is available as text/html or application/json", http.StatusNotAcceptable)
+	}
+	return format
 }
 
 func writeJSON(w http.ResponseWriter, status int, data any) {
@@ -227,6 +629,17 @@ func writeJSON(w http.ResponseWriter, status int, data any) {
 	json.NewEncoder(w).Encode(data)
 }
 
+func writeXML(w http.ResponseWriter, contentType string, data any) {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(data); err != nil {
+		log.Printf("XML encoding error: %v", err)
+	}
+}
+
 // FormatScore formats a score for display
 func FormatScore(score int) string {
 	if score == 1 || score == -1 {
@@ -2,11 +2,18 @@ package web
 
 import (
 	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"regexp"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/alphabot-ai/slashclaw/internal/config"
 	"github.com/alphabot-ai/slashclaw/internal/store"
@@ -28,7 +35,10 @@ func setupTestHandler(t *testing.T) (*Handler, *store.SQLiteStore, func()) {
 	}
 
 	cfg := &config.Config{
-		BaseURL: "http://localhost:8080",
+		BaseURL:        "http://localhost:8080",
+		TitleMinLength: 8,
+		TitleMaxLength: 180,
+		MaxTags:        5,
 	}
 
 	handler, err := NewHandler(sqliteStore, cfg)
@@ -147,8 +157,80 @@ func TestHomeJSON(t *testing.T) {
 	}
 
 	body := rec.Body.String()
-	if !strings.Contains(body, `"stories"`) {
-		t.Error("JSON response should contain stories key")
+	if !strings.Contains(body, `"stories":[]`) {
+		t.Errorf("JSON response with no stories = %s, want an empty stories array", body)
+	}
+}
+
+func TestHomePagination(t *testing.T) {
+	handler, sqliteStore, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	for i := range 45 {
+		story := &store.Story{Title: fmt.Sprintf("Paged Story %02d", i), Text: "Content"}
+		sqliteStore.CreateStory(context.Background(), story)
+	}
+
+	titles := regexp.MustCompile(`Paged Story \d\d`)
+	next := regexp.MustCompile(`<a href="([^"]*cursor=[^"]*)">More</a>`)
+
+	for _, sort := range []string{"new", "top"} {
+		t.Run(sort, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.Home(rec, httptest.NewRequest(http.MethodGet, "/?sort="+sort, nil))
+			first := rec.Body.String()
+
+			link := next.FindStringSubmatch(first)
+			if link == nil {
+				t.Fatal("first page has no More link")
+			}
+			href := html.UnescapeString(link[1])
+			if !strings.Contains(href, "sort="+sort) {
+				t.Errorf("More link %q does not keep sort=%s", href, sort)
+			}
+
+			rec = httptest.NewRecorder()
+			handler.Home(rec, httptest.NewRequest(http.MethodGet, href, nil))
+			second := rec.Body.String()
+
+			seen := make(map[string]bool)
+			for _, title := range titles.FindAllString(first, -1) {
+				seen[title] = true
+			}
+			secondTitles := titles.FindAllString(second, -1)
+			if len(seen) != 30 || len(secondTitles) != 15 {
+				t.Fatalf("pages have %d and %d stories, want 30 and 15", len(seen), len(secondTitles))
+			}
+			for _, title := range secondTitles {
+				if seen[title] {
+					t.Errorf("%s is on both pages", title)
+				}
+			}
+			if next.MatchString(second) {
+				t.Error("last page has a More link")
+			}
+		})
+	}
+}
+
+func TestHomeEmptyMessage(t *testing.T) {
+	handler, _, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	get := func() string {
+		rec := httptest.NewRecorder()
+		handler.Home(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rec.Body.String()
+	}
+
+	if body := get(); !strings.Contains(body, "Submit the first one!") {
+		t.Error("empty home page should prompt for a submission by default")
+	}
+
+	handler.cfg.EmptyListingMessage = "Quiet day <here>"
+	body := get()
+	if !strings.Contains(body, "Quiet day &lt;here&gt;") || strings.Contains(body, "Submit the first one!") {
+		t.Error("empty home page should show the configured message, escaped, instead of the default")
 	}
 }
 
@@ -212,6 +294,123 @@ func TestStory(t *testing.T) {
 	}
 }
 
+func TestStorySourceURL(t *testing.T) {
+	handler, sqliteStore, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	story := &store.Story{
+		Title:     "Story With Two Links",
+		URL:       "https://example.com/article",
+		SourceURL: "https://news.example.org/item?id=1",
+	}
+	sqliteStore.CreateStory(context.Background(), story)
+
+	pages := map[string]func(http.ResponseWriter, *http.Request){
+		"/":                  handler.Home,
+		"/story/" + story.ID: handler.Story,
+	}
+	for path, serve := range pages {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.SetPathValue("id", story.ID)
+		rec := httptest.NewRecorder()
+		serve(rec, req)
+
+		body := rec.Body.String()
+		for _, want := range []string{`href="https://example.com/article"`, `href="https://news.example.org/item?id=1"`} {
+			if !strings.Contains(body, want) {
+				t.Errorf("%s: body should contain %q", path, want)
+			}
+		}
+	}
+}
+
+func TestStoryRendersMarkdown(t *testing.T) {
+	handler, sqliteStore, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	story := &store.Story{Title: "Markdown Story", Text: "An *important* point <script>alert(1)</script>"}
+	sqliteStore.CreateStory(ctx, story)
+	sqliteStore.CreateComment(ctx, &store.Comment{StoryID: story.ID, Text: "[click me](javascript:alert(1)) and `code`"})
+
+	req := httptest.NewRequest(http.MethodGet, "/story/"+story.ID, nil)
+	req.SetPathValue("id", story.ID)
+	rec := httptest.NewRecorder()
+	handler.Story(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	body := rec.Body.String()
+	for _, want := range []string{"<em>important</em>", "<code>code</code>"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body should contain %q", want)
+		}
+	}
+	for _, bad := range []string{"<script>alert(1)", "javascript:alert"} {
+		if strings.Contains(body, bad) {
+			t.Errorf("body should not contain %q", bad)
+		}
+	}
+}
+
+func TestStoryCommentPagination(t *testing.T) {
+	handler, sqliteStore, cleanup := setupTestHandler(t)
+	defer cleanup()
+	handler.cfg.CommentsPerPage = 2
+
+	ctx := context.Background()
+	story := &store.Story{Title: "Busy Thread", Text: "Lots to say"}
+	sqliteStore.CreateStory(ctx, story)
+
+	first := &store.Comment{StoryID: story.ID, Text: "First thread", Score: 3}
+	sqliteStore.CreateComment(ctx, first)
+	sqliteStore.CreateComment(ctx, &store.Comment{StoryID: story.ID, ParentID: first.ID, Text: "Reply to first"})
+	sqliteStore.CreateComment(ctx, &store.Comment{StoryID: story.ID, Text: "Second thread", Score: 2})
+	sqliteStore.CreateComment(ctx, &store.Comment{StoryID: story.ID, Text: "Third thread", Score: 1})
+
+	get := func(query string) string {
+		req := httptest.NewRequest(http.MethodGet, "/story/"+story.ID+query, nil)
+		req.SetPathValue("id", story.ID)
+		rec := httptest.NewRecorder()
+		handler.Story(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+		}
+		return rec.Body.String()
+	}
+
+	page1 := get("")
+	for _, want := range []string{"First thread", "Reply to first", "Second thread", "Load more comments"} {
+		if !strings.Contains(page1, want) {
+			t.Errorf("first page should contain %q", want)
+		}
+	}
+	if strings.Contains(page1, "Third thread") {
+		t.Error("first page should not contain the third thread")
+	}
+
+	marker := "?comments_cursor="
+	idx := strings.Index(page1, marker)
+	if idx == -1 {
+		t.Fatal("first page should link to the next page")
+	}
+	cursor := page1[idx+len(marker):]
+	cursor = cursor[:strings.IndexAny(cursor, "#\"")]
+
+	page2 := get(marker + cursor)
+	if !strings.Contains(page2, "Third thread") {
+		t.Error("second page should contain the third thread")
+	}
+	if strings.Contains(page2, "First thread") || strings.Contains(page2, "Second thread") {
+		t.Error("second page should not repeat earlier threads")
+	}
+	if strings.Contains(page2, "Load more comments") {
+		t.Error("last page should not offer more comments")
+	}
+}
+
 func TestStoryJSON(t *testing.T) {
 	handler, sqliteStore, cleanup := setupTestHandler(t)
 	defer cleanup()
@@ -237,8 +436,112 @@ func TestStoryJSON(t *testing.T) {
 	if !strings.Contains(body, `"story"`) {
 		t.Error("JSON response should contain story key")
 	}
-	if !strings.Contains(body, `"comments"`) {
-		t.Error("JSON response should contain comments key")
+	if !strings.Contains(body, `"comments":[]`) {
+		t.Errorf("JSON response for a story without comments = %s, want an empty comments array", body)
+	}
+}
+
+func TestStoryCommentDefaults(t *testing.T) {
+	handler, sqliteStore, cleanup := setupTestHandler(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	now := time.Now().UTC()
+	story := &store.Story{Title: "Sorted Thread", Text: "Content"}
+	sqliteStore.CreateStory(ctx, story)
+	popular := &store.Comment{StoryID: story.ID, Text: "Popular", Score: 5, CreatedAt: now.Add(-time.Hour)}
+	sqliteStore.CreateComment(ctx, popular)
+	sqliteStore.CreateComment(ctx, &store.Comment{StoryID: story.ID, ParentID: popular.ID, Text: "Reply", CreatedAt: now.Add(-30 * time.Minute)})
+	sqliteStore.CreateComment(ctx, &store.Comment{StoryID: story.ID, Text: "Newest", CreatedAt: now})
+
+	load := func() []*store.Comment {
+		req := httptest.NewRequest(http.MethodGet, "/story/"+story.ID, nil)
+		req.SetPathValue("id", story.ID)
+		req.Header.Set("Accept", "application/json")
+		rec := httptest.NewRecorder()
+		handler.Story(rec, req)
+
+		var resp struct {
+			Comments []*store.Comment `json:"comments"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return resp.Comments
+	}
+
+	// Unset, the page is threaded best-first
+	comments := load()
+	if len(comments) != 2 || comments[0].Text != "Popular" || len(comments[0].Children) != 1 {
+		t.Errorf("default comments = %+v, want Popular first with its reply nested", comments)
+	}
+
+	handler.cfg.WebCommentSort = "new"
+	handler.cfg.WebCommentView = "flat"
+	comments = load()
+	if len(comments) != 3 || comments[0].Text != "Newest" || comments[1].Text != "Reply" {
+		t.Errorf("flat-new comments = %+v, want all three newest first", comments)
+	}
+}
+
+// brokenCommentsStore serves stories but fails every comment listing
+type brokenCommentsStore struct {
+	store.Store
+}
+
+func (s *brokenCommentsStore) ListComments(ctx context.Context, storyID string, opts store.CommentListOptions) ([]*store.Comment, string, error) {
+	return nil, "", errors.New("comments table unavailable")
+}
+
+func TestStoryCommentsUnavailable(t *testing.T) {
+	handler, sqliteStore, cleanup := setupTestHandler(t)
+	defer cleanup()
+	handler.store = &brokenCommentsStore{Store: sqliteStore}
+
+	story := &store.Story{Title: "Story Still Shown", Text: "The body survives"}
+	sqliteStore.CreateStory(context.Background(), story)
+
+	get := func(accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/story/"+story.ID, nil)
+		req.SetPathValue("id", story.ID)
+		req.Header.Set("Accept", accept)
+		rec := httptest.NewRecorder()
+		handler.Story(rec, req)
+		return rec
+	}
+
+	rec := get("text/html")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("HTML status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{"Story Still Shown", "The body survives", commentsUnavailable} {
+		if !strings.Contains(body, want) {
+			t.Errorf("HTML page should contain %q", want)
+		}
+	}
+	if strings.Contains(body, "No comments yet") {
+		t.Error("HTML page should not claim there are no comments")
+	}
+
+	rec = get("application/json")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("JSON status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var resp struct {
+		Story         *store.Story     `json:"story"`
+		Comments      []*store.Comment `json:"comments"`
+		CommentsError string           `json:"comments_error"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decoding JSON: %v", err)
+	}
+	if resp.Story == nil || resp.Story.ID != story.ID {
+		t.Errorf("story = %+v, want %s", resp.Story, story.ID)
+	}
+	if resp.Comments == nil || len(resp.Comments) != 0 {
+		t.Errorf("comments = %v, want an empty list", resp.Comments)
+	}
+	if resp.CommentsError != commentsUnavailable {
+		t.Errorf("comments_error = %q, want %q", resp.CommentsError, commentsUnavailable)
 	}
 }
 
@@ -289,23 +592,58 @@ func TestSubmitJSON(t *testing.T) {
 	}
 }
 
-func TestWantsJSON(t *testing.T) {
+func TestNegotiate(t *testing.T) {
 	tests := []struct {
 		name   string
 		accept string
-		query  string
-		want   bool
+		offers []string
+		want   string
 	}{
-		{"no header", "", "", false},
-		{"html accept", "text/html", "", false},
-		{"json accept", "application/json", "", true},
-		{"json query param", "", "format=json", true},
-		{"mixed", "text/html", "format=json", true},
+		{"no header", "", []string{mediaHTML, mediaJSON}, mediaHTML},
+		{"html accept", "text/html", []string{mediaHTML, mediaJSON}, mediaHTML},
+		{"json accept", "application/json", []string{mediaHTML, mediaJSON}, mediaJSON},
+		{"q-values", "application/json;q=0.9, text/html;q=0.8", []string{mediaHTML, mediaJSON}, mediaJSON},
+		{"any", "*/*", []string{mediaHTML, mediaJSON}, mediaHTML},
+		{"any prefers first offer", "*/*", []string{mediaJSON, mediaHTML}, mediaJSON},
+		{"type wildcard", "application/*", []string{mediaHTML, "application/xml"}, "application/xml"},
+		{"specific range beats wildcard", "*/*;q=0.1, text/html;q=0", []string{mediaHTML, mediaJSON}, mediaJSON},
+		{"browser", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", []string{mediaJSON, mediaHTML}, mediaHTML},
+		{"case and spacing", " Application/JSON ; Q=0.5 ", []string{mediaHTML, mediaJSON}, mediaJSON},
+		{"nothing acceptable", "application/xml", []string{mediaHTML, mediaJSON}, ""},
+		{"refused with q=0", "application/json;q=0", []string{mediaJSON}, ""},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			url := "/"
+			if got := negotiate(tt.accept, tt.offers...); got != tt.want {
+				t.Errorf("negotiate(%q) = %q, want %q", tt.accept, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPageContentNegotiation(t *testing.T) {
+	handler, _, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	tests := []struct {
+		name       string
+		accept     string
+		query      string
+		wantStatus int
+		wantType   string
+	}{
+		{"no header", "", "", http.StatusOK, "text/html"},
+		{"json accept", "application/json", "", http.StatusOK, "application/json"},
+		{"json preferred", "application/json;q=0.9, text/html;q=0.8", "", http.StatusOK, "application/json"},
+		{"any", "*/*", "", http.StatusOK, "text/html"},
+		{"json query param", "text/html", "format=json", http.StatusOK, "application/json"},
+		{"unsupported", "application/xml", "", http.StatusNotAcceptable, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url := "/submit"
 			if tt.query != "" {
 				url += "?" + tt.query
 			}
@@ -313,11 +651,280 @@ func TestWantsJSON(t *testing.T) {
 			if tt.accept != "" {
 				req.Header.Set("Accept", tt.accept)
 			}
+			rec := httptest.NewRecorder()
+			handler.Submit(rec, req)
 
-			got := wantsJSON(req)
-			if got != tt.want {
-				t.Errorf("wantsJSON() = %v, want %v", got, tt.want)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantType != "" && !strings.HasPrefix(rec.Header().Get("Content-Type"), tt.wantType) {
+				t.Errorf("Content-Type = %q, want %s", rec.Header().Get("Content-Type"), tt.wantType)
 			}
 		})
 	}
 }
+
+func seedFeedStories(t *testing.T, sqliteStore *store.SQLiteStore) []*store.Story {
+	t.Helper()
+
+	now := time.Now().UTC()
+	stories := []*store.Story{
+		{Title: "High But Oldest", Text: "Discussion", Score: 10, CreatedAt: now.Add(-time.Hour)},
+		{Title: "Low But Newest", URL: "https://example.com/low", Score: 1, CreatedAt: now},
+	}
+	for _, story := range stories {
+		if err := sqliteStore.CreateStory(context.Background(), story); err != nil {
+			t.Fatalf("CreateStory: %v", err)
+		}
+	}
+	return stories
+}
+
+func TestRSS(t *testing.T) {
+	handler, sqliteStore, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	seedFeedStories(t, sqliteStore)
+
+	req := httptest.NewRequest(http.MethodGet, "/feed.xml", nil)
+	w := httptest.NewRecorder()
+	handler.RSS(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/rss+xml") {
+		t.Errorf("expected application/rss+xml, got %s", ct)
+	}
+
+	var feed struct {
+		XMLName xml.Name `xml:"rss"`
+		Version string   `xml:"version,attr"`
+		Channel struct {
+			Title string `xml:"title"`
+			Link  string `xml:"link"`
+			Items []struct {
+				Title       string `xml:"title"`
+				Link        string `xml:"link"`
+				GUID        string `xml:"guid"`
+				PubDate     string `xml:"pubDate"`
+				Description string `xml:"description"`
+			} `xml:"item"`
+		} `xml:"channel"`
+	}
+	if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("failed to parse RSS: %v", err)
+	}
+
+	if feed.Version != "2.0" {
+		t.Errorf("expected RSS version 2.0, got %q", feed.Version)
+	}
+	if feed.Channel.Title == "" || feed.Channel.Link == "" {
+		t.Error("channel should have a title and link")
+	}
+	if len(feed.Channel.Items) != 2 {
+		t.Fatalf("expected 2 items, got %d", len(feed.Channel.Items))
+	}
+
+	// Default sort is top
+	item := feed.Channel.Items[0]
+	if item.Title != "High But Oldest" {
+		t.Errorf("expected top story first, got %q", item.Title)
+	}
+	if !strings.HasPrefix(item.Link, "http://localhost:8080/story/") {
+		t.Errorf("unexpected item link %q", item.Link)
+	}
+	if item.GUID != item.Link {
+		t.Errorf("expected guid %q, got %q", item.Link, item.GUID)
+	}
+	if _, err := time.Parse(time.RFC1123Z, item.PubDate); err != nil {
+		t.Errorf("pubDate %q is not RFC 1123: %v", item.PubDate, err)
+	}
+	if item.Description != "10 points, 0 comments" {
+		t.Errorf("unexpected description %q", item.Description)
+	}
+}
+
+func TestRSSSortNew(t *testing.T) {
+	handler, sqliteStore, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	seedFeedStories(t, sqliteStore)
+
+	req := httptest.NewRequest(http.MethodGet, "/feed.xml?sort=new", nil)
+	w := httptest.NewRecorder()
+	handler.RSS(w, req)
+
+	var feed struct {
+		Items []struct {
+			Title string `xml:"title"`
+		} `xml:"channel>item"`
+	}
+	if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("failed to parse RSS: %v", err)
+	}
+	if len(feed.Items) != 2 {
+		t.Fatalf("expected 2 items, got %d", len(feed.Items))
+	}
+	if feed.Items[0].Title != "Low But Newest" {
+		t.Errorf("expected newest story first, got %q", feed.Items[0].Title)
+	}
+}
+
+func TestAtom(t *testing.T) {
+	handler, sqliteStore, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	seedFeedStories(t, sqliteStore)
+
+	req := httptest.NewRequest(http.MethodGet, "/feed.atom?sort=new", nil)
+	w := httptest.NewRecorder()
+	handler.Atom(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/atom+xml") {
+		t.Errorf("expected application/atom+xml, got %s", ct)
+	}
+
+	var feed struct {
+		XMLName xml.Name `xml:"http://www.w3.org/2005/Atom feed"`
+		ID      string   `xml:"id"`
+		Updated string   `xml:"updated"`
+		Entries []struct {
+			Title string `xml:"title"`
+			ID    string `xml:"id"`
+			Link  struct {
+				Href string `xml:"href,attr"`
+			} `xml:"link"`
+			Published string `xml:"published"`
+			Summary   string `xml:"summary"`
+		} `xml:"entry"`
+	}
+	if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("failed to parse Atom: %v", err)
+	}
+
+	if feed.ID == "" {
+		t.Error("feed should have an id")
+	}
+	if _, err := time.Parse(time.RFC3339, feed.Updated); err != nil {
+		t.Errorf("updated %q is not RFC 3339: %v", feed.Updated, err)
+	}
+	if len(feed.Entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(feed.Entries))
+	}
+
+	entry := feed.Entries[0]
+	if entry.Title != "Low But Newest" {
+		t.Errorf("expected newest story first, got %q", entry.Title)
+	}
+	if !strings.HasPrefix(entry.Link.Href, "http://localhost:8080/story/") || entry.ID != entry.Link.Href {
+		t.Errorf("unexpected entry id %q and link %q", entry.ID, entry.Link.Href)
+	}
+	if _, err := time.Parse(time.RFC3339, entry.Published); err != nil {
+		t.Errorf("published %q is not RFC 3339: %v", entry.Published, err)
+	}
+	if entry.Summary != "1 point, 0 comments" {
+		t.Errorf("unexpected summary %q", entry.Summary)
+	}
+}
+
+func TestSitemap(t *testing.T) {
+	handler, sqliteStore, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	stories := seedFeedStories(t, sqliteStore)
+	hidden := &store.Story{Title: "Hidden From Search", Text: "Removed"}
+	sqliteStore.CreateStory(ctx, hidden)
+	sqliteStore.HideStory(ctx, hidden.ID)
+
+	// A comment moves its story's lastmod; a hidden one doesn't
+	commentedAt := time.Now().UTC().Add(time.Minute).Truncate(time.Second)
+	sqliteStore.CreateComment(ctx, &store.Comment{StoryID: stories[0].ID, Text: "Late reply", CreatedAt: commentedAt})
+	hiddenComment := &store.Comment{StoryID: stories[1].ID, Text: "Removed reply", CreatedAt: commentedAt}
+	sqliteStore.CreateComment(ctx, hiddenComment)
+	sqliteStore.HideComment(ctx, hiddenComment.ID)
+
+	type urlSet struct {
+		URLs []struct {
+			Loc     string `xml:"loc"`
+			LastMod string `xml:"lastmod"`
+		} `xml:"url"`
+	}
+	fetch := func(target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		w := httptest.NewRecorder()
+		handler.Sitemap(w, req)
+		return w
+	}
+
+	w := fetch("/sitemap.xml")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/xml") {
+		t.Errorf("expected application/xml, got %s", ct)
+	}
+	var sitemap urlSet
+	if err := xml.Unmarshal(w.Body.Bytes(), &sitemap); err != nil {
+		t.Fatalf("failed to parse sitemap: %v", err)
+	}
+	lastMods := make(map[string]string)
+	for _, u := range sitemap.URLs {
+		lastMods[u.Loc] = u.LastMod
+	}
+
+	base := handler.cfg.BaseURL
+	for _, loc := range []string{base + "/", base + "/submit"} {
+		if _, ok := lastMods[loc]; !ok {
+			t.Errorf("sitemap missing %s", loc)
+		}
+	}
+	want := map[string]string{
+		stories[0].ID: commentedAt.Format(time.RFC3339),
+		stories[1].ID: stories[1].CreatedAt.Format(time.RFC3339),
+	}
+	for id, lastMod := range want {
+		if got, ok := lastMods[base+"/story/"+id]; !ok || got != lastMod {
+			t.Errorf("story %s lastmod = %q (listed %v), want %q", id, got, ok, lastMod)
+		}
+	}
+	if _, ok := lastMods[base+"/story/"+hidden.ID]; ok {
+		t.Error("sitemap lists a hidden story")
+	}
+	if len(sitemap.URLs) != 4 {
+		t.Errorf("expected 4 URLs, got %d", len(sitemap.URLs))
+	}
+
+	// Past the page size it becomes an index whose pages list everything once
+	handler.sitemapPageSize = 3
+	var index struct {
+		XMLName  xml.Name `xml:"sitemapindex"`
+		Sitemaps []struct {
+			Loc string `xml:"loc"`
+		} `xml:"sitemap"`
+	}
+	if err := xml.Unmarshal(fetch("/sitemap.xml").Body.Bytes(), &index); err != nil {
+		t.Fatalf("failed to parse sitemap index: %v", err)
+	}
+	if len(index.Sitemaps) != 2 || index.Sitemaps[1].Loc != base+"/sitemap.xml?page=2" {
+		t.Fatalf("sitemap index = %+v, want pages 1 and 2", index.Sitemaps)
+	}
+	var paged []string
+	for _, ref := range index.Sitemaps {
+		var page urlSet
+		xml.Unmarshal(fetch(strings.TrimPrefix(ref.Loc, base)).Body.Bytes(), &page)
+		for _, u := range page.URLs {
+			paged = append(paged, u.Loc)
+		}
+	}
+	if fmt.Sprint(paged) != fmt.Sprint([]string{base + "/", base + "/submit", base + "/story/" + stories[0].ID, base + "/story/" + stories[1].ID}) {
+		t.Errorf("paged URLs = %v, want static pages then stories oldest first", paged)
+	}
+	if w := fetch("/sitemap.xml?page=3"); w.Code != http.StatusNotFound {
+		t.Errorf("page past the end: expected status 404, got %d", w.Code)
+	}
+}